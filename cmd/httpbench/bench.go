@@ -0,0 +1,371 @@
+package main
+
+/*
+	压测执行与统计: 闭环模式下固定数量的 worker 循环发送请求, 开环模式按目标 RPS 定时发起;
+	开环模式的延迟从计划发起时刻算起, 避免服务变慢时少算排队时间 (协同遗漏)
+*/
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/client"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+)
+
+// errorKindLimit 错误分类键的最大长度
+const errorKindLimit = 120
+
+// bench 一次压测的参数
+type bench struct {
+	client      *client.Client
+	newRequest  func(ctx context.Context) (*message.Request, error)
+	concurrency int
+	rps         float64
+	duration    time.Duration
+	// requests > 0 时完成该数量的请求后停止, 与 duration 先到者为准
+	requests int64
+	warmup   time.Duration
+	// progress 非空时每秒调用一次, 参数为已完成的请求数
+	progress func(done int64)
+}
+
+// sample 一次请求的结果
+type sample struct {
+	latency time.Duration
+	status  int
+	proto   string
+	bytes   int64
+	err     error
+	// warm 请求在预热阶段发起, 不计入结果
+	warm bool
+}
+
+// collector 汇总样本; 每个 worker 各自追加到分片, 避免在热路径上争用同一把锁
+type collector struct {
+	recording atomic.Bool
+	done      atomic.Int64
+	dropped   atomic.Int64
+
+	mu     sync.Mutex
+	shards []*shard
+}
+
+type shard struct {
+	latencies []time.Duration
+	status    map[int]int64
+	protos    map[string]int64
+	errors    map[string]int64
+	bytes     int64
+}
+
+func (c *collector) newShard() *shard {
+	s := &shard{status: make(map[int]int64), protos: make(map[string]int64), errors: make(map[string]int64)}
+	c.mu.Lock()
+	c.shards = append(c.shards, s)
+	c.mu.Unlock()
+	return s
+}
+
+// add 记录一次结果, 预热阶段的结果被丢弃
+func (s *shard) add(c *collector, smp sample) {
+	if smp.warm || !c.recording.Load() {
+		return
+	}
+	s.latencies = append(s.latencies, smp.latency)
+	s.bytes += smp.bytes
+	if smp.err != nil {
+		msg := smp.err.Error()
+		if len(msg) > errorKindLimit {
+			msg = msg[:errorKindLimit] + "..."
+		}
+		s.errors[msg]++
+	} else {
+		s.status[smp.status]++
+		s.protos[smp.proto]++
+	}
+	c.done.Add(1)
+}
+
+// run 执行压测直到时长或请求数达到上限, 或 ctx 结束
+func (b *bench) run(ctx context.Context) *result {
+	c := &collector{}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var start time.Time
+	var startMu sync.Mutex
+	begin := func() {
+		startMu.Lock()
+		start = time.Now()
+		startMu.Unlock()
+		c.recording.Store(true)
+		if b.duration > 0 {
+			time.AfterFunc(b.duration, cancel)
+		}
+	}
+	if b.warmup > 0 {
+		timer := time.AfterFunc(b.warmup, begin)
+		defer timer.Stop()
+	} else {
+		begin()
+	}
+	// take 在统计阶段为每个请求预占名额, 达到请求数后不再发起; 预热阶段不计数
+	var issued atomic.Int64
+	take := func() bool {
+		return b.requests <= 0 || !c.recording.Load() || issued.Add(1) <= b.requests
+	}
+
+	stopProgress := b.startProgress(ctx, c)
+	var wg sync.WaitGroup
+	if b.rps > 0 {
+		b.openLoop(ctx, c, &wg, take)
+	} else {
+		for range b.concurrency {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s := c.newShard()
+				for ctx.Err() == nil && take() {
+					smp := b.do(ctx, c, time.Now())
+					if ctx.Err() != nil && smp.err != nil {
+						// 结束时被取消的请求不计入
+						return
+					}
+					s.add(c, smp)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	stopProgress()
+	end := time.Now()
+
+	startMu.Lock()
+	defer startMu.Unlock()
+	var elapsed time.Duration
+	if !start.IsZero() {
+		elapsed = end.Sub(start)
+		if b.duration > 0 && elapsed > b.duration {
+			elapsed = b.duration
+		}
+	}
+	return newResult(c, elapsed)
+}
+
+// openLoop 按目标速率发起请求, 在途请求达到并发上限时计为 dropped
+func (b *bench) openLoop(ctx context.Context, c *collector, wg *sync.WaitGroup, take func() bool) {
+	interval := time.Duration(float64(time.Second) / b.rps)
+	sem := make(chan struct{}, b.concurrency)
+	pool := sync.Pool{New: func() any { return c.newShard() }}
+	next := time.Now()
+	for ctx.Err() == nil && take() {
+		if d := time.Until(next); d > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d):
+			}
+		}
+		planned := next
+		next = next.Add(interval)
+		select {
+		case sem <- struct{}{}:
+		default:
+			if c.recording.Load() {
+				c.dropped.Add(1)
+			}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			smp := b.do(ctx, c, planned)
+			if ctx.Err() != nil && smp.err != nil {
+				return
+			}
+			s := pool.Get().(*shard)
+			s.add(c, smp)
+			pool.Put(s)
+		}()
+	}
+}
+
+// do 发送一个请求并读完响应体, 延迟从 start 算起; 发起时尚在预热阶段的请求标记为 warm
+func (b *bench) do(ctx context.Context, c *collector, start time.Time) sample {
+	warm := !c.recording.Load()
+	smp := b.send(ctx, start)
+	smp.warm = warm
+	return smp
+}
+
+func (b *bench) send(ctx context.Context, start time.Time) sample {
+	req, err := b.newRequest(ctx)
+	if err != nil {
+		return sample{latency: time.Since(start), err: err}
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return sample{latency: time.Since(start), err: err}
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return sample{latency: time.Since(start), status: resp.StatusCode, proto: resp.Proto, bytes: n, err: err}
+}
+
+// startProgress 每秒报告一次进度, 返回停止函数
+func (b *bench) startProgress(ctx context.Context, c *collector) func() {
+	if b.progress == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				b.progress(c.done.Load())
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// result 压测结果, 也是 JSON 导出的格式
+type result struct {
+	Target      string  `json:"target"`
+	Method      string  `json:"method"`
+	Protocol    string  `json:"protocol"`
+	Mode        string  `json:"mode"`
+	Concurrency int     `json:"concurrency"`
+	TargetRPS   float64 `json:"target_rps,omitempty"`
+	// Elapsed 统计阶段的时长, 秒
+	Elapsed    float64 `json:"elapsed_seconds"`
+	Requests   int64   `json:"requests"`
+	Errors     int64   `json:"errors"`
+	Dropped    int64   `json:"dropped,omitempty"`
+	RPS        float64 `json:"rps"`
+	Bytes      int64   `json:"bytes"`
+	Throughput float64 `json:"bytes_per_second"`
+	// Latency 延迟分位数, 毫秒
+	Latency   latency          `json:"latency_ms"`
+	Histogram []bucket         `json:"histogram"`
+	Status    map[string]int64 `json:"status"`
+	Protocols map[string]int64 `json:"protocols"`
+	ErrorKind map[string]int64 `json:"error_kinds,omitempty"`
+	// Dials 新建的连接数, Reused 复用的次数
+	Dials  uint64 `json:"dials"`
+	Reused uint64 `json:"reused"`
+}
+
+type latency struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	Max  float64 `json:"max"`
+	P50  float64 `json:"p50"`
+	P75  float64 `json:"p75"`
+	P90  float64 `json:"p90"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p999"`
+}
+
+// bucket 延迟直方图的一格: 上界 (毫秒) 及落入的请求数
+type bucket struct {
+	UpperMs float64 `json:"le_ms"`
+	Count   int64   `json:"count"`
+}
+
+// histogramBuckets 直方图的格数
+const histogramBuckets = 10
+
+func newResult(c *collector, elapsed time.Duration) *result {
+	r := &result{
+		Elapsed:   elapsed.Seconds(),
+		Dropped:   c.dropped.Load(),
+		Status:    make(map[string]int64),
+		Protocols: make(map[string]int64),
+		ErrorKind: make(map[string]int64),
+	}
+	var all []time.Duration
+	c.mu.Lock()
+	for _, s := range c.shards {
+		all = append(all, s.latencies...)
+		r.Bytes += s.bytes
+		for code, n := range s.status {
+			r.Status[strconv.Itoa(code)] += n
+		}
+		for p, n := range s.protos {
+			r.Protocols[p] += n
+		}
+		for k, n := range s.errors {
+			r.ErrorKind[k] += n
+			r.Errors += n
+		}
+	}
+	c.mu.Unlock()
+	r.Requests = int64(len(all))
+	if elapsed > 0 {
+		r.RPS = float64(r.Requests) / elapsed.Seconds()
+		r.Throughput = float64(r.Bytes) / elapsed.Seconds()
+	}
+	if len(all) == 0 {
+		return r
+	}
+	slices.Sort(all)
+	var sum time.Duration
+	for _, d := range all {
+		sum += d
+	}
+	pct := func(p float64) float64 {
+		i := int(p*float64(len(all))+0.5) - 1
+		return ms(all[min(max(i, 0), len(all)-1)])
+	}
+	r.Latency = latency{
+		Min: ms(all[0]), Mean: ms(sum / time.Duration(len(all))), Max: ms(all[len(all)-1]),
+		P50: pct(0.50), P75: pct(0.75), P90: pct(0.90), P95: pct(0.95), P99: pct(0.99), P999: pct(0.999),
+	}
+	r.Histogram = histogram(all)
+	return r
+}
+
+// histogram 在 [min, max] 上等宽划分, all 已排序
+func histogram(all []time.Duration) []bucket {
+	lo, hi := all[0], all[len(all)-1]
+	width := (hi - lo) / histogramBuckets
+	if width <= 0 {
+		return []bucket{{UpperMs: ms(hi), Count: int64(len(all))}}
+	}
+	buckets := make([]bucket, histogramBuckets)
+	i := 0
+	for b := range buckets {
+		upper := lo + width*time.Duration(b+1)
+		if b == histogramBuckets-1 {
+			upper = hi
+		}
+		buckets[b].UpperMs = ms(upper)
+		for i < len(all) && all[i] <= upper {
+			buckets[b].Count++
+			i++
+		}
+	}
+	return buckets
+}
+
+func ms(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
@@ -0,0 +1,313 @@
+package main
+
+/*
+	httpbench: 基于 client.Transport 的 HTTP 压测工具, 用法类似 hey/wrk.
+	支持固定并发或目标 RPS、按时长或请求数结束、延迟分位数与直方图、状态码分布、
+	HTTP/1.1 与 HTTP/2 选择, 以及 JSON 格式的结果导出
+*/
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/client"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+)
+
+// multiFlag 可重复的字符串参数
+type multiFlag []string
+
+func (m *multiFlag) String() string     { return strings.Join(*m, ", ") }
+func (m *multiFlag) Set(v string) error { *m = append(*m, v); return nil }
+
+type options struct {
+	concurrency int
+	rps         float64
+	duration    time.Duration
+	requests    int64
+	warmup      time.Duration
+	method      string
+	headers     multiFlag
+	body        string
+	bodyFile    string
+	proto       string
+	insecure    bool
+	timeout     time.Duration
+	jsonOut     string
+	quiet       bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func parseFlags(args []string, stderr io.Writer) (*options, string, error) {
+	o := &options{}
+	fs := flag.NewFlagSet("httpbench", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: httpbench [flags] URL\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	fs.IntVar(&o.concurrency, "c", 50, "concurrent requests (workers, or the in-flight limit with -rps)")
+	fs.Float64Var(&o.rps, "rps", 0, "target requests per second (open loop); 0 sends as fast as the workers allow")
+	fs.DurationVar(&o.duration, "d", 10*time.Second, "test duration; 0 runs until -n requests complete")
+	fs.Int64Var(&o.requests, "n", 0, "stop after this many requests (0 = no limit)")
+	fs.DurationVar(&o.warmup, "warmup", 0, "warm-up period excluded from the results")
+	fs.StringVar(&o.method, "X", "GET", "request method")
+	fs.Var(&o.headers, "H", `request header "Name: value", repeatable`)
+	fs.StringVar(&o.body, "body", "", "request body")
+	fs.StringVar(&o.bodyFile, "body-file", "", "read the request body from a file")
+	fs.StringVar(&o.proto, "proto", "auto", "protocol: auto (ALPN), h1 or h2 (https only)")
+	fs.BoolVar(&o.insecure, "k", false, "skip TLS certificate verification")
+	fs.DurationVar(&o.timeout, "timeout", 30*time.Second, "per-request timeout")
+	fs.StringVar(&o.jsonOut, "json", "", `write the results as JSON to this file ("-" for stdout)`)
+	fs.BoolVar(&o.quiet, "q", false, "do not print progress")
+	if err := fs.Parse(args); err != nil {
+		return nil, "", err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return nil, "", errors.New("exactly one URL is required")
+	}
+	switch {
+	case o.concurrency <= 0:
+		return nil, "", errors.New("-c must be positive")
+	case o.duration <= 0 && o.requests <= 0:
+		return nil, "", errors.New("one of -d and -n is required")
+	case o.body != "" && o.bodyFile != "":
+		return nil, "", errors.New("-body and -body-file are mutually exclusive")
+	}
+	return o, fs.Arg(0), nil
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	o, rawURL, err := parseFlags(args, stderr)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintf(stderr, "httpbench: %v\n", err)
+		return 2
+	}
+	fail := func(err error) int {
+		fmt.Fprintf(stderr, "httpbench: %v\n", err)
+		return 1
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return fail(err)
+	}
+	tr, err := newTransport(o, target)
+	if err != nil {
+		return fail(err)
+	}
+	defer tr.CloseIdleConnections()
+	newReq, err := requestFactory(o, target)
+	if err != nil {
+		return fail(err)
+	}
+
+	b := &bench{
+		client:      client.New(client.WithTransport(tr), client.WithTimeout(o.timeout), client.WithUserAgent("httpbench")),
+		newRequest:  newReq,
+		concurrency: o.concurrency,
+		rps:         o.rps,
+		duration:    o.duration,
+		requests:    o.requests,
+		warmup:      o.warmup,
+	}
+	if !o.quiet {
+		b.progress = func(done int64) { fmt.Fprintf(stderr, "\r%d requests", done) }
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	mode := fmt.Sprintf("%d workers", o.concurrency)
+	if o.rps > 0 {
+		mode = fmt.Sprintf("%.0f req/s (max %d in flight)", o.rps, o.concurrency)
+	}
+	fmt.Fprintf(stderr, "benchmarking %s %s with %s\n", o.method, target.Redacted(), mode)
+	res := b.run(ctx)
+	if !o.quiet {
+		fmt.Fprint(stderr, "\r")
+	}
+
+	res.Target, res.Method, res.Protocol, res.Concurrency = target.Redacted(), o.method, o.proto, o.concurrency
+	res.Mode, res.TargetRPS = "closed", o.rps
+	if o.rps > 0 {
+		res.Mode = "open"
+	}
+	st := tr.Stats().Total
+	res.Dials, res.Reused = st.Dials, st.Reused
+
+	if o.jsonOut != "-" {
+		printResult(stdout, res)
+	}
+	if o.jsonOut != "" {
+		if err := writeJSON(o.jsonOut, stdout, res); err != nil {
+			return fail(err)
+		}
+	}
+	if o.proto == "h2" && res.Protocols["HTTP/2.0"] == 0 && res.Requests > res.Errors {
+		fmt.Fprintln(stderr, "httpbench: warning: the server did not negotiate HTTP/2")
+	}
+	return 0
+}
+
+// newTransport 按 -proto 配置传输层; 连接池保留与并发数相同的空闲连接, 避免反复建连
+func newTransport(o *options, target *url.URL) (*client.Transport, error) {
+	tr := &client.Transport{
+		Pool: client.NewPool(client.PoolConfig{
+			MaxIdlePerHost: o.concurrency,
+			TLSConfig:      &tls.Config{InsecureSkipVerify: o.insecure},
+		}),
+	}
+	switch o.proto {
+	case "auto":
+	case "h1":
+		tr.DisableHTTP2 = true
+	case "h2":
+		if target.Scheme != "https" {
+			return nil, errors.New("-proto h2 needs an https URL: HTTP/2 is negotiated with ALPN")
+		}
+	default:
+		return nil, fmt.Errorf("unknown protocol %q, want auto, h1 or h2", o.proto)
+	}
+	return tr, nil
+}
+
+// requestFactory 返回每次生成新请求的函数, 请求体预先读入内存
+func requestFactory(o *options, target *url.URL) (func(ctx context.Context) (*message.Request, error), error) {
+	body := []byte(o.body)
+	if o.bodyFile != "" {
+		b, err := os.ReadFile(o.bodyFile)
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+	type kv struct{ name, value string }
+	var headers []kv
+	for _, line := range o.headers {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("bad header %q, want \"Name: value\"", line)
+		}
+		headers = append(headers, kv{strings.TrimSpace(name), strings.TrimSpace(value)})
+	}
+	rawURL := target.String()
+	return func(ctx context.Context) (*message.Request, error) {
+		var r io.Reader
+		if len(body) > 0 {
+			r = bytes.NewReader(body)
+		}
+		req, err := message.NewRequestWithContext(ctx, o.method, rawURL, r)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range headers {
+			if strings.EqualFold(h.name, "Host") {
+				req.Host = h.value
+				continue
+			}
+			req.Header.Add(h.name, h.value)
+		}
+		return req, nil
+	}, nil
+}
+
+// printResult 输出可读的结果摘要
+func printResult(w io.Writer, r *result) {
+	fmt.Fprintf(w, "\nSummary:\n")
+	fmt.Fprintf(w, "  Elapsed:     %.2fs\n", r.Elapsed)
+	fmt.Fprintf(w, "  Requests:    %d (%d errors", r.Requests, r.Errors)
+	if r.Dropped > 0 {
+		fmt.Fprintf(w, ", %d dropped", r.Dropped)
+	}
+	fmt.Fprintf(w, ")\n")
+	fmt.Fprintf(w, "  Rate:        %.1f req/s\n", r.RPS)
+	fmt.Fprintf(w, "  Transfer:    %d bytes (%.1f KiB/s)\n", r.Bytes, r.Throughput/1024)
+	fmt.Fprintf(w, "  Connections: %d dialed, %d reused\n", r.Dials, r.Reused)
+	if r.Requests == 0 {
+		return
+	}
+	l := r.Latency
+	fmt.Fprintf(w, "\nLatency (ms):\n")
+	fmt.Fprintf(w, "  min %.3f  mean %.3f  max %.3f\n", l.Min, l.Mean, l.Max)
+	fmt.Fprintf(w, "  p50 %.3f  p75 %.3f  p90 %.3f  p95 %.3f  p99 %.3f  p99.9 %.3f\n", l.P50, l.P75, l.P90, l.P95, l.P99, l.P999)
+
+	fmt.Fprintf(w, "\nHistogram (ms):\n")
+	var peak int64
+	for _, b := range r.Histogram {
+		peak = max(peak, b.Count)
+	}
+	for _, b := range r.Histogram {
+		bar := 0
+		if peak > 0 {
+			bar = int(b.Count * 40 / peak)
+		}
+		fmt.Fprintf(w, "  %10.3f [%d]\t|%s\n", b.UpperMs, b.Count, strings.Repeat("■", bar))
+	}
+
+	if len(r.Status) > 0 {
+		fmt.Fprintf(w, "\nStatus codes:\n")
+		for _, code := range sortedKeys(r.Status) {
+			fmt.Fprintf(w, "  [%s] %d responses\n", code, r.Status[code])
+		}
+	}
+	if len(r.Protocols) > 0 {
+		fmt.Fprintf(w, "\nProtocols:\n")
+		for _, p := range sortedKeys(r.Protocols) {
+			fmt.Fprintf(w, "  %s: %d\n", p, r.Protocols[p])
+		}
+	}
+	if len(r.ErrorKind) > 0 {
+		fmt.Fprintf(w, "\nErrors:\n")
+		kinds := sortedKeys(r.ErrorKind)
+		sort.SliceStable(kinds, func(i, j int) bool { return r.ErrorKind[kinds[i]] > r.ErrorKind[kinds[j]] })
+		for _, k := range kinds {
+			fmt.Fprintf(w, "  [%d] %s\n", r.ErrorKind[k], k)
+		}
+	}
+}
+
+// writeJSON 把结果写到 path, "-" 表示 stdout
+func writeJSON(path string, stdout io.Writer, r *result) error {
+	out := stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
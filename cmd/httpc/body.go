@@ -0,0 +1,115 @@
+package main
+
+/*
+	请求体: -d 表单、--json 和 -F multipart, 全部读入内存以便重试和重定向时重放
+*/
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// requestBody 已经编码好的请求体
+type requestBody struct {
+	data        []byte
+	contentType string
+}
+
+func (b *requestBody) reader() io.Reader { return bytes.NewReader(b.data) }
+
+// buildBody 按参数构造请求体, 没有请求体时返回 nil
+func buildBody(o *options, stdin io.Reader) (*requestBody, error) {
+	n := 0
+	for _, set := range []bool{len(o.data) > 0, o.json != "", len(o.form) > 0} {
+		if set {
+			n++
+		}
+	}
+	if n > 1 {
+		return nil, errors.New("-d, --json and -F are mutually exclusive")
+	}
+	switch {
+	case len(o.data) > 0:
+		parts := make([]string, 0, len(o.data))
+		for _, d := range o.data {
+			b, err := readArg(d, stdin)
+			if err != nil {
+				return nil, err
+			}
+			// 与 curl 相同, 文件内容中的换行被去掉
+			parts = append(parts, strings.NewReplacer("\r", "", "\n", "").Replace(string(b)))
+		}
+		return &requestBody{data: []byte(strings.Join(parts, "&")), contentType: "application/x-www-form-urlencoded"}, nil
+	case o.json != "":
+		b, err := readArg(o.json, stdin)
+		if err != nil {
+			return nil, err
+		}
+		return &requestBody{data: b, contentType: "application/json"}, nil
+	case len(o.form) > 0:
+		return buildMultipart(o.form)
+	}
+	return nil, nil
+}
+
+// readArg 以 @ 开头时读取文件, @- 读取标准输入, 否则返回参数本身
+func readArg(arg string, stdin io.Reader) ([]byte, error) {
+	name, ok := strings.CutPrefix(arg, "@")
+	if !ok {
+		return []byte(arg), nil
+	}
+	if name == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(name)
+}
+
+// buildMultipart 编码 -F 字段: name=value 或 name=@file[;type=mime]
+func buildMultipart(fields []string) (*requestBody, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, f := range fields {
+		name, value, ok := strings.Cut(f, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("bad form field %q, want name=value or name=@file", f)
+		}
+		path, isFile := strings.CutPrefix(value, "@")
+		if !isFile {
+			if err := mw.WriteField(name, value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		ctype := "application/octet-stream"
+		if p, t, ok := strings.Cut(path, ";type="); ok {
+			path, ctype = p, t
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(name), escapeQuotes(filepath.Base(path))))
+		h.Set("Content-Type", ctype)
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		part.Write(data)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return &requestBody{data: buf.Bytes(), contentType: mw.FormDataContentType()}, nil
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+func escapeQuotes(s string) string { return quoteEscaper.Replace(s) }
@@ -0,0 +1,234 @@
+package main
+
+/*
+	recorder: 包在传输层外记录每次往返, 用于 -v 报文输出、-w 耗时统计和 HAR 导出.
+	连接耗时由拨号包装测得, TLS 握手耗时取连接池统计的差值, 其余按响应头和消息体读完的时刻划分
+*/
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/har"
+	"github.com/narcilee7/http-stack/pkg/http/client"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+type recorder struct {
+	next    client.RoundTripper
+	stats   func() client.PoolStats
+	verbose bool
+	log     io.Writer
+	har     *har.HAR
+	// last 最近一次往返, 用于 -w
+	last *exchange
+
+	mu      sync.Mutex
+	dialed  bool
+	connect time.Duration
+	remote  string
+}
+
+// exchange 一次往返的耗时; connect 和 tls 为 -1 表示复用了已有连接
+type exchange struct {
+	start   time.Time
+	connect time.Duration
+	tls     time.Duration
+	ttfb    time.Duration
+	total   time.Duration
+	size    int64
+	remote  string
+}
+
+// dial 包装拨号函数, 记录 TCP 连接耗时和对端地址
+func (r *recorder) dial(d func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		start := time.Now()
+		conn, err := d(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		elapsed := time.Since(start)
+		remote := conn.RemoteAddr().String()
+		r.mu.Lock()
+		r.dialed, r.connect, r.remote = true, elapsed, remote
+		r.mu.Unlock()
+		if r.verbose {
+			fmt.Fprintf(r.log, "* Connected to %s (%s) in %s\n", addr, remote, round(elapsed))
+		}
+		return conn, nil
+	}
+}
+
+// RoundTrip 实现 client.RoundTripper
+func (r *recorder) RoundTrip(req *message.Request) (*message.Response, error) {
+	var reqBody []byte
+	if r.har != nil && req.GetBody != nil {
+		if rc, err := req.GetBody(); err == nil {
+			reqBody, _ = io.ReadAll(rc)
+			rc.Close()
+		}
+	}
+	r.mu.Lock()
+	r.dialed = false
+	r.mu.Unlock()
+	handshakes := r.stats().Total.HandshakeTime
+	x := &exchange{start: time.Now(), connect: -1, tls: -1}
+	resp, err := r.next.RoundTrip(req)
+	x.ttfb = time.Since(x.start)
+
+	r.mu.Lock()
+	if r.dialed {
+		x.connect, x.remote = r.connect, r.remote
+		x.tls = 0
+		if d := r.stats().Total.HandshakeTime - handshakes; d > 0 {
+			x.tls = d
+			if r.verbose {
+				fmt.Fprintf(r.log, "* TLS handshake completed in %s\n", round(d))
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	if r.verbose {
+		proto := "HTTP/1.1"
+		if resp != nil {
+			proto = resp.Proto
+		}
+		fmt.Fprintf(r.log, "> %s %s %s\n", req.Method, req.URL.RequestURI(), proto)
+		if req.Header.Get("Host") == "" {
+			fmt.Fprintf(r.log, "> Host: %s\n", req.HostHeader())
+		}
+		writeHeader(r.log, "> ", "\n", req.Header)
+		fmt.Fprintln(r.log, ">")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if r.verbose {
+		fmt.Fprintf(r.log, "< %s %s\n", resp.Proto, resp.Status)
+		writeHeader(r.log, "< ", "\n", resp.Header)
+		fmt.Fprintln(r.log, "<")
+	}
+	r.last = x
+	rb := &recordingBody{ReadCloser: resp.Body, r: r, x: x, req: req, reqBody: reqBody, resp: resp}
+	if r.har != nil {
+		rb.buf = new(bytes.Buffer)
+	}
+	resp.Body = rb
+	return resp, nil
+}
+
+// recordingBody 统计响应体大小, 读完或关闭时结束这次往返的记录
+type recordingBody struct {
+	io.ReadCloser
+	r       *recorder
+	x       *exchange
+	req     *message.Request
+	reqBody []byte
+	resp    *message.Response
+	buf     *bytes.Buffer
+	once    sync.Once
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.x.size += int64(n)
+	if b.buf != nil {
+		b.buf.Write(p[:n])
+	}
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish()
+	return err
+}
+
+func (b *recordingBody) finish() {
+	b.once.Do(func() {
+		x := b.x
+		x.total = time.Since(x.start)
+		if b.r.har == nil {
+			return
+		}
+		var body []byte
+		if b.buf != nil {
+			body = b.buf.Bytes()
+		}
+		t := har.Timings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Send: 0}
+		wait := x.ttfb
+		if x.connect >= 0 {
+			// HAR 的 connect 包含 ssl
+			t.Connect = har.Millis(x.connect + x.tls)
+			t.SSL = har.Millis(x.tls)
+			wait -= x.connect + x.tls
+		}
+		t.Wait = har.Millis(max(wait, 0))
+		t.Receive = har.Millis(x.total - x.ttfb)
+		e := har.Entry{
+			StartedDateTime: x.start,
+			Time:            har.Millis(x.total),
+			Request:         har.NewRequest(b.req, b.reqBody),
+			Response:        har.NewResponse(b.resp, body),
+			Timings:         t,
+		}
+		if host, _, err := net.SplitHostPort(x.remote); err == nil {
+			e.ServerIPAddress = host
+		}
+		b.r.har.Add(e)
+	})
+}
+
+// printTiming 输出 -w 的耗时统计
+func (x *exchange) printTiming(w io.Writer) {
+	phase := func(d time.Duration) string {
+		if d < 0 {
+			return "reused"
+		}
+		return round(d).String()
+	}
+	fmt.Fprintf(w, "connect:  %s\n", phase(x.connect))
+	fmt.Fprintf(w, "tls:      %s\n", phase(x.tls))
+	fmt.Fprintf(w, "ttfb:     %s\n", round(x.ttfb))
+	fmt.Fprintf(w, "total:    %s\n", round(x.total))
+	fmt.Fprintf(w, "size:     %d bytes\n", x.size)
+	if x.total > 0 {
+		fmt.Fprintf(w, "speed:    %.0f bytes/s\n", float64(x.size)/x.total.Seconds())
+	}
+	if x.remote != "" {
+		fmt.Fprintf(w, "remote:   %s\n", x.remote)
+	}
+}
+
+// writeHeader 按名称排序输出头部, 每行加上前缀和行尾 eol
+func writeHeader(w io.Writer, prefix, eol string, h common.Header) {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range h[name] {
+			fmt.Fprintf(w, "%s%s: %s%s", prefix, name, v, eol)
+		}
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	if d > time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(time.Microsecond)
+}
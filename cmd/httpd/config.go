@@ -0,0 +1,291 @@
+package main
+
+/*
+	httpd 的配置: 可以来自命令行参数或 JSON 配置文件, 收到 SIGHUP 时重新读取配置文件并替换路由和证书
+*/
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/client"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/proxy"
+	"github.com/narcilee7/http-stack/pkg/http/server"
+	"github.com/narcilee7/http-stack/pkg/http/webdav"
+	"github.com/narcilee7/http-stack/pkg/log"
+	"github.com/narcilee7/http-stack/pkg/metrics"
+)
+
+// config httpd 的完整配置, 字段与 JSON 配置文件一一对应
+type config struct {
+	Addr string `json:"addr"`
+	// TLS 证书与私钥文件, 都为空时不启用 TLS
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+	H2C     bool   `json:"h2c"`
+	// Compress 压缩可压缩的响应
+	Compress bool `json:"compress"`
+	// AccessLog 访问日志的路径, "-" 为标准输出, 空表示不记录
+	AccessLog string `json:"access_log"`
+	// LogFormat 日志格式: "console" 或 "json"
+	LogFormat string `json:"log_format"`
+	LogLevel  string `json:"log_level"`
+	// Metrics 非空时在该路径上以 Prometheus 格式导出指标
+	Metrics string `json:"metrics"`
+	// ReadHeaderTimeout 等时长使用 time.ParseDuration 的格式
+	ReadHeaderTimeout duration `json:"read_header_timeout"`
+	IdleTimeout       duration `json:"idle_timeout"`
+	ShutdownTimeout   duration `json:"shutdown_timeout"`
+	// Middleware 作用于所有路由的插件, 按顺序由外到内, 见 server.RegisterPlugin
+	Middleware []server.PluginConfig `json:"middleware,omitempty"`
+	Routes     []route               `json:"routes"`
+}
+
+// route 一个路径前缀的处理方式, Root 和 Upstreams 二选一
+type route struct {
+	Prefix string `json:"prefix"`
+	// Root 静态文件目录
+	Root   string `json:"root,omitempty"`
+	Browse bool   `json:"browse,omitempty"`
+	// CacheControl 静态文件响应的 Cache-Control
+	CacheControl string `json:"cache_control,omitempty"`
+	// WebDAV 以 WebDAV 提供 Root, 客户端可以上传、移动和删除其中的文件
+	WebDAV bool `json:"webdav,omitempty"`
+	// Upstreams 反向代理的上游
+	Upstreams []string `json:"upstreams,omitempty"`
+	// StripPrefix 转发或映射到目录前去掉 Prefix
+	StripPrefix  bool `json:"strip_prefix,omitempty"`
+	PreserveHost bool `json:"preserve_host,omitempty"`
+	// Middleware 只作用于本路由的插件, 在全局插件之内
+	Middleware []server.PluginConfig `json:"middleware,omitempty"`
+}
+
+// duration 以字符串 (如 "30s") 表示的时长
+type duration struct{ time.Duration }
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) { return json.Marshal(d.String()) }
+
+func defaultConfig() *config {
+	return &config{
+		Addr:              ":8080",
+		LogFormat:         "console",
+		LogLevel:          "info",
+		ReadHeaderTimeout: duration{10 * time.Second},
+		IdleTimeout:       duration{2 * time.Minute},
+		ShutdownTimeout:   duration{15 * time.Second},
+	}
+}
+
+// loadConfig 读取配置文件, 未出现的字段保留默认值
+func loadConfig(path string) (*config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := defaultConfig()
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *config) validate() error {
+	if len(c.Routes) == 0 {
+		return errors.New("no routes: set a root directory or upstreams")
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("tls_cert and tls_key must be set together")
+	}
+	switch c.LogFormat {
+	case "console", "json":
+	default:
+		return fmt.Errorf("unknown log format %q", c.LogFormat)
+	}
+	if _, err := log.ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if err := checkPlugins(c.Middleware); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for i := range c.Routes {
+		r := &c.Routes[i]
+		if r.Prefix == "" {
+			r.Prefix = "/"
+		}
+		if !strings.HasPrefix(r.Prefix, "/") {
+			return fmt.Errorf("route %q: prefix must start with /", r.Prefix)
+		}
+		if seen[r.Prefix] {
+			return fmt.Errorf("route %q: duplicate prefix", r.Prefix)
+		}
+		seen[r.Prefix] = true
+		if (r.Root == "") == (len(r.Upstreams) == 0) {
+			return fmt.Errorf("route %q: exactly one of root and upstreams is required", r.Prefix)
+		}
+		if r.WebDAV && r.Root == "" {
+			return fmt.Errorf("route %q: webdav requires root", r.Prefix)
+		}
+		if r.Root != "" {
+			if fi, err := os.Stat(r.Root); err != nil || !fi.IsDir() {
+				return fmt.Errorf("route %q: root %s is not a directory", r.Prefix, r.Root)
+			}
+		}
+		if err := checkPlugins(r.Middleware); err != nil {
+			return fmt.Errorf("route %q: %w", r.Prefix, err)
+		}
+	}
+	return nil
+}
+
+// checkPlugins 检查插件名称都已注册; 插件配置的错误在组装处理器时报告
+func checkPlugins(configs []server.PluginConfig) error {
+	available := server.Plugins()
+	for _, p := range configs {
+		if !slices.Contains(available, p.Name) {
+			return fmt.Errorf("unknown plugin %q (available: %s)", p.Name, strings.Join(available, ", "))
+		}
+	}
+	return nil
+}
+
+// loadCertificate 读取证书和私钥, 未配置 TLS 时返回 nil
+func (c *config) loadCertificate() (*tls.Certificate, error) {
+	if c.TLSCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// buildHandler 按配置组装处理器链: 访问日志 -> 指标 -> 全局插件 -> 压缩 -> 路由 (-> 路由插件)
+// tr 由所有反向代理路由共享, 重新加载时沿用, 以免旧的空闲连接泄漏
+func (c *config) buildHandler(tr client.RoundTripper, accessLog, logger *log.Logger, reg *metrics.Registry) (server.Handler, error) {
+	m := &mux{}
+	scheme := "http"
+	if c.TLSCert != "" {
+		scheme = "https"
+	}
+	for _, r := range c.Routes {
+		var h server.Handler
+		if r.WebDAV {
+			dav := &webdav.Handler{FileSystem: webdav.Dir(r.Root)}
+			if r.StripPrefix {
+				dav.Prefix = r.Prefix
+			}
+			h = dav
+		} else if r.Root != "" {
+			fs := &server.FileServer{FS: os.DirFS(r.Root), Browse: r.Browse, CacheControl: r.CacheControl}
+			if r.StripPrefix {
+				fs.StripPrefix = r.Prefix
+			}
+			h = fs
+		} else {
+			rp, err := proxy.NewReverse(r.Upstreams...)
+			if err != nil {
+				return nil, fmt.Errorf("route %q: %w", r.Prefix, err)
+			}
+			rp.Transport = tr
+			rp.PreserveHost = r.PreserveHost
+			rp.Scheme = scheme
+			rp.Logger = logger
+			if r.StripPrefix {
+				prefix := strings.TrimSuffix(r.Prefix, "/")
+				rp.Rewrite = func(out *message.Request) {
+					out.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(out.URL.Path, prefix), "/")
+				}
+			}
+			h = rp
+		}
+		h, err := server.BuildChain(h, r.Middleware)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", r.Prefix, err)
+		}
+		m.handle(r.Prefix, h)
+	}
+	if c.Metrics != "" {
+		m.handle(c.Metrics, server.PrometheusHandler(reg))
+	}
+
+	var h server.Handler = m
+	if c.Compress {
+		h = &server.CompressHandler{Handler: h}
+	}
+	h, err := server.BuildChain(h, c.Middleware)
+	if err != nil {
+		return nil, err
+	}
+	h = &server.MetricsHandler{Handler: h, Metrics: reg}
+	if accessLog != nil {
+		h = &server.AccessLogHandler{Handler: h, Logger: accessLog}
+	}
+	return h, nil
+}
+
+// mux 按最长前缀匹配选择处理器. 以 / 结尾的前缀匹配整个子树, 否则只匹配完全相同的路径或其子路径
+type mux struct {
+	routes []muxRoute
+}
+
+type muxRoute struct {
+	prefix  string
+	handler server.Handler
+}
+
+func (m *mux) handle(prefix string, h server.Handler) {
+	m.routes = append(m.routes, muxRoute{prefix: prefix, handler: h})
+	sort.SliceStable(m.routes, func(i, j int) bool { return len(m.routes[i].prefix) > len(m.routes[j].prefix) })
+}
+
+func (m *mux) match(p string) server.Handler {
+	for _, r := range m.routes {
+		if p == r.prefix || strings.HasSuffix(r.prefix, "/") && strings.HasPrefix(p, r.prefix) ||
+			strings.HasPrefix(p, r.prefix+"/") {
+			return r.handler
+		}
+	}
+	return nil
+}
+
+// ServeHTTP 实现 server.Handler
+func (m *mux) ServeHTTP(w server.ResponseWriter, req *message.Request) {
+	h := m.match(req.URL.Path)
+	if h == nil {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(common.StatusNotFound)
+		w.Write([]byte("404 page not found\n"))
+		return
+	}
+	h.ServeHTTP(w, req)
+}
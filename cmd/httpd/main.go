@@ -0,0 +1,335 @@
+package main
+
+/*
+	httpd: 基于 server 包的静态文件服务器、WebDAV 服务器和反向代理, 演示整个协议栈.
+	支持 TLS (ALPN 协商 HTTP/2)、h2c、响应压缩、访问日志、Prometheus 指标和按配置启用的插件中间件;
+	SIGHUP (仅 unix) 重新加载配置文件、证书并重新打开访问日志, SIGINT/SIGTERM 优雅关闭
+*/
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/narcilee7/http-stack/pkg/http/client"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/server"
+	"github.com/narcilee7/http-stack/pkg/log"
+	"github.com/narcilee7/http-stack/pkg/metrics"
+	"github.com/narcilee7/http-stack/pkg/tcp"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stderr))
+}
+
+// parseFlags 解析参数; 指定了 -config 时以配置文件为准, 显式给出的全局参数覆盖文件中的值
+func parseFlags(args []string, stderr io.Writer) (*config, string, error) {
+	c := defaultConfig()
+	var (
+		configPath string
+		rt         route
+		upstreams  string
+	)
+	fs := flag.NewFlagSet("httpd", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: httpd [flags]\n\nserve a directory:   httpd -root ./public\nwebdav share:        httpd -root ./share -webdav\nreverse proxy:       httpd -proxy 127.0.0.1:9000,127.0.0.1:9001\nwith a config file:  httpd -config httpd.json\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&configPath, "config", "", "JSON config file; reloaded on SIGHUP")
+	fs.StringVar(&c.Addr, "addr", c.Addr, "listen address")
+	fs.StringVar(&rt.Root, "root", "", "directory to serve")
+	fs.BoolVar(&rt.Browse, "browse", false, "list directories without an index file")
+	fs.BoolVar(&rt.WebDAV, "webdav", false, "serve -root over WebDAV (read-write)")
+	fs.StringVar(&upstreams, "proxy", "", "comma-separated upstreams to reverse proxy to")
+	fs.StringVar(&rt.Prefix, "prefix", "/", "path prefix the -root or -proxy route is mounted at")
+	fs.BoolVar(&rt.StripPrefix, "strip-prefix", false, "strip the prefix before mapping to the directory or upstream")
+	fs.BoolVar(&rt.PreserveHost, "preserve-host", false, "forward the client's Host header to upstreams")
+	fs.StringVar(&c.TLSCert, "tls-cert", "", "TLS certificate file (PEM)")
+	fs.StringVar(&c.TLSKey, "tls-key", "", "TLS private key file (PEM)")
+	fs.BoolVar(&c.H2C, "h2c", false, "accept cleartext HTTP/2")
+	fs.BoolVar(&c.Compress, "compress", false, "gzip/deflate compressible responses")
+	fs.StringVar(&c.AccessLog, "access-log", "", `access log file, "-" for stdout`)
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "log format: console or json")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "server log level: debug, info, warn, error")
+	fs.StringVar(&c.Metrics, "metrics", "", "path to expose Prometheus metrics on, e.g. /metrics")
+	fs.DurationVar(&c.ShutdownTimeout.Duration, "shutdown-timeout", c.ShutdownTimeout.Duration, "time to wait for in-flight requests on shutdown")
+	if err := fs.Parse(args); err != nil {
+		return nil, "", err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return nil, "", fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	if upstreams != "" {
+		rt.Upstreams = strings.Split(upstreams, ",")
+	}
+
+	if configPath == "" {
+		if rt.Root != "" || len(rt.Upstreams) > 0 {
+			c.Routes = []route{rt}
+		}
+		return c, "", c.validate()
+	}
+	fc, err := loadConfig(configPath)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := applyFlags(fc, c, fs); err != nil {
+		return nil, "", err
+	}
+	return fc, configPath, nil
+}
+
+// applyFlags 把命令行上显式给出的全局参数覆盖到配置文件的值上; 路由只能在配置文件中定义
+func applyFlags(dst, src *config, fs *flag.FlagSet) error {
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			dst.Addr = src.Addr
+		case "tls-cert":
+			dst.TLSCert = src.TLSCert
+		case "tls-key":
+			dst.TLSKey = src.TLSKey
+		case "h2c":
+			dst.H2C = src.H2C
+		case "compress":
+			dst.Compress = src.Compress
+		case "access-log":
+			dst.AccessLog = src.AccessLog
+		case "log-format":
+			dst.LogFormat = src.LogFormat
+		case "log-level":
+			dst.LogLevel = src.LogLevel
+		case "metrics":
+			dst.Metrics = src.Metrics
+		case "shutdown-timeout":
+			dst.ShutdownTimeout = src.ShutdownTimeout
+		case "root", "browse", "webdav", "proxy", "prefix", "strip-prefix", "preserve-host":
+			err = fmt.Errorf("-%s cannot be combined with -config; define routes in the config file", f.Name)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return dst.validate()
+}
+
+func run(args []string, stderr io.Writer) int {
+	c, configPath, err := parseFlags(args, stderr)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintf(stderr, "httpd: %v\n", err)
+		return 2
+	}
+	d := &daemon{configPath: configPath, reg: metrics.NewRegistry(), access: &logFile{}}
+	d.logger = newLogger(stderr, c.LogFormat, c.LogLevel)
+	log.SetDefault(d.logger)
+	d.transport = &client.Transport{Metrics: d.reg}
+	if err := d.apply(c); err != nil {
+		fmt.Fprintf(stderr, "httpd: %v\n", err)
+		return 1
+	}
+
+	srv := &server.Server{
+		Addr:              c.Addr,
+		Handler:           d,
+		H2C:               c.H2C,
+		ReadHeaderTimeout: c.ReadHeaderTimeout.Duration,
+		IdleTimeout:       c.IdleTimeout.Duration,
+		Logger:            d.logger,
+		Metrics:           d.reg,
+	}
+	if c.TLSCert != "" {
+		srv.TLS = &tcp.TLSOptions{Config: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			// 证书在重新加载时替换, 新的握手立即使用新证书
+			GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return d.cert.Load(), nil },
+		}}
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	d.logger.Info("httpd: listening", log.String("addr", c.Addr), log.Bool("tls", srv.TLS != nil), log.Int("routes", len(c.Routes)))
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, append(reloadSignals, syscall.SIGINT, syscall.SIGTERM)...)
+	defer signal.Stop(sigc)
+	for {
+		select {
+		case err := <-errc:
+			d.logger.Error("httpd: server stopped", log.Err(err))
+			return 1
+		case sig := <-sigc:
+			if isReloadSignal(sig) {
+				d.reload(c)
+				continue
+			}
+			d.logger.Info("httpd: shutting down", log.String("signal", sig.String()), log.Duration("timeout", c.ShutdownTimeout.Duration))
+			ctx, cancel := context.WithTimeout(context.Background(), c.ShutdownTimeout.Duration)
+			err := srv.Shutdown(ctx)
+			cancel()
+			d.transport.CloseIdleConnections()
+			d.access.Close()
+			if err != nil {
+				d.logger.Warn("httpd: forced shutdown", log.Err(err))
+				return 1
+			}
+			return 0
+		}
+	}
+}
+
+// daemon 持有可在重新加载时替换的处理器和证书
+type daemon struct {
+	configPath string
+	logger     *log.Logger
+	reg        *metrics.Registry
+	transport  *client.Transport
+	access     *logFile
+
+	handler atomic.Pointer[server.Handler]
+	cert    atomic.Pointer[tls.Certificate]
+}
+
+// ServeHTTP 实现 server.Handler, 使用当前生效的处理器
+func (d *daemon) ServeHTTP(w server.ResponseWriter, req *message.Request) {
+	(*d.handler.Load()).ServeHTTP(w, req)
+}
+
+// apply 按配置构造处理器、读取证书并打开访问日志, 全部成功后才替换当前的设置
+func (d *daemon) apply(c *config) error {
+	cert, err := c.loadCertificate()
+	if err != nil {
+		return err
+	}
+	var accessLog *log.Logger
+	if c.AccessLog != "" {
+		if err := d.access.Open(c.AccessLog); err != nil {
+			return err
+		}
+		accessLog = newLogger(d.access, c.LogFormat, "info")
+	}
+	h, err := c.buildHandler(d.transport, accessLog, d.logger, d.reg)
+	if err != nil {
+		return err
+	}
+	d.handler.Store(&h)
+	if cert != nil {
+		d.cert.Store(cert)
+	}
+	return nil
+}
+
+// reload 处理 SIGHUP: 有配置文件时重新读取并应用, 否则只重新打开访问日志 (配合 logrotate).
+// 监听地址、TLS 开关、超时和服务器日志的变化需要重启才能生效
+func (d *daemon) reload(cur *config) {
+	if d.configPath == "" {
+		if err := d.access.Reopen(); err != nil {
+			d.logger.Error("httpd: reopening access log failed", log.Err(err))
+			return
+		}
+		d.logger.Info("httpd: access log reopened")
+		return
+	}
+	c, err := loadConfig(d.configPath)
+	if err != nil {
+		d.logger.Error("httpd: reload failed, keeping the current config", log.Err(err))
+		return
+	}
+	if c.Addr != cur.Addr || (c.TLSCert == "") != (cur.TLSCert == "") || c.H2C != cur.H2C {
+		d.logger.Warn("httpd: listener settings changed; restart to apply them")
+		c.Addr, c.TLSCert, c.TLSKey, c.H2C = cur.Addr, cur.TLSCert, cur.TLSKey, cur.H2C
+	}
+	if err := d.apply(c); err != nil {
+		d.logger.Error("httpd: reload failed, keeping the current config", log.Err(err))
+		return
+	}
+	d.logger.Info("httpd: config reloaded", log.String("config", d.configPath), log.Int("routes", len(c.Routes)))
+}
+
+func newLogger(w io.Writer, format, level string) *log.Logger {
+	lvl, err := log.ParseLevel(level)
+	if err != nil {
+		lvl = log.LevelInfo
+	}
+	var sink log.Sink = log.NewConsoleSink(w)
+	if format == "json" {
+		sink = log.NewJSONSink(w)
+	}
+	return log.New(sink, lvl)
+}
+
+// logFile 可以重新打开的日志文件, "-" 表示标准输出
+type logFile struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// Open 打开 path; 与当前路径相同时重新打开
+func (l *logFile) Open(path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if path == "-" {
+		l.closeLocked()
+		l.path, l.f = path, os.Stdout
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	l.closeLocked()
+	l.path, l.f = path, f
+	return nil
+}
+
+// Reopen 重新打开当前文件, 用于日志轮转后写入新文件
+func (l *logFile) Reopen() error {
+	l.mu.Lock()
+	path := l.path
+	l.mu.Unlock()
+	if path == "" {
+		return nil
+	}
+	return l.Open(path)
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return len(p), nil
+	}
+	return l.f.Write(p)
+}
+
+// Close 关闭文件, 之后的写入被丢弃
+func (l *logFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closeLocked()
+}
+
+func (l *logFile) closeLocked() error {
+	f := l.f
+	l.f = nil
+	if f == nil || f == os.Stdout {
+		return nil
+	}
+	return f.Close()
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// reloadSignals 非 unix 平台没有 SIGHUP, 不支持信号触发的重新加载
+var reloadSignals []os.Signal
+
+func isReloadSignal(os.Signal) bool { return false }
@@ -0,0 +1,13 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals 触发重新加载配置和证书的信号
+var reloadSignals = []os.Signal{syscall.SIGHUP}
+
+func isReloadSignal(sig os.Signal) bool { return sig == syscall.SIGHUP }
@@ -0,0 +1,91 @@
+package main
+
+/*
+	比较回放得到的响应与录制的响应: 状态码、选定的头部和消息体; JSON 消息体按语义比较, 忽略键顺序与空白
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+// snippetLimit 差异说明中消息体片段的最大长度
+const snippetLimit = 80
+
+// comparer 比较选项
+type comparer struct {
+	// headers 需要比较的响应头
+	headers []string
+	// ignoreBody 只比较状态码和头部
+	ignoreBody bool
+}
+
+// compare 返回 got 与录制的 e 之间的差异, 一致时为空
+func (c *comparer) compare(e *entry, status int, header common.Header, body []byte) []string {
+	var diffs []string
+	if status != e.status {
+		diffs = append(diffs, fmt.Sprintf("status: recorded %d, got %d", e.status, status))
+	}
+	for _, name := range c.headers {
+		want, got := strings.Join(e.respHeader.Values(name), ", "), strings.Join(header.Values(name), ", ")
+		if want != got {
+			diffs = append(diffs, fmt.Sprintf("header %s: recorded %q, got %q", name, want, got))
+		}
+	}
+	if c.ignoreBody || !e.hasBody {
+		return diffs
+	}
+	if d := diffBody(e.respBody, body); d != "" {
+		diffs = append(diffs, d)
+	}
+	return diffs
+}
+
+// diffBody 比较消息体, 两边都是 JSON 时比较解析后的值
+func diffBody(want, got []byte) string {
+	if bytes.Equal(want, got) {
+		return ""
+	}
+	var wv, gv any
+	if json.Unmarshal(want, &wv) == nil && json.Unmarshal(got, &gv) == nil {
+		if reflect.DeepEqual(wv, gv) {
+			return ""
+		}
+		return fmt.Sprintf("body: JSON differs: recorded %s, got %s", snippet(want), snippet(got))
+	}
+	i := 0
+	for i < len(want) && i < len(got) && want[i] == got[i] {
+		i++
+	}
+	d := fmt.Sprintf("body: differs at byte %d: recorded %s, got %s", i, snippet(want[i:]), snippet(got[i:]))
+	if len(want) != len(got) {
+		d += fmt.Sprintf(" (%d vs %d bytes)", len(want), len(got))
+	}
+	return d
+}
+
+// snippet 以可读形式截取消息体的开头
+func snippet(b []byte) string {
+	if !utf8.Valid(b) {
+		if len(b) > snippetLimit/2 {
+			return fmt.Sprintf("%x...", b[:snippetLimit/2])
+		}
+		return fmt.Sprintf("%x", b)
+	}
+	s := string(bytes.TrimSpace(b))
+	if len(s) > snippetLimit {
+		// 避免截断在多字节字符中间
+		n := snippetLimit
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		return fmt.Sprintf("%q...", s[:n])
+	}
+	return fmt.Sprintf("%q", s)
+}
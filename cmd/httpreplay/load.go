@@ -0,0 +1,186 @@
+package main
+
+/*
+	读取录制的流量: HAR 文件和 client.Recorder 的 cassette 都转换为同一种 entry
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/har"
+	"github.com/narcilee7/http-stack/pkg/http/client"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+// redactedValue cassette 中脱敏后的头部值, 这样的头部回放时不发送
+const redactedValue = "REDACTED"
+
+// entry 一次录制的请求与响应
+type entry struct {
+	// source 来源, 如 "api.har#3"
+	source  string
+	started time.Time
+	// duration 录制时的耗时, 未知时为 0
+	duration time.Duration
+
+	method string
+	url    *url.URL
+	header common.Header
+	body   []byte
+
+	status     int
+	respHeader common.Header
+	respBody   []byte
+	// hasBody 录制中保存了响应体; HAR 可以省略响应内容, 此时不比较消息体
+	hasBody bool
+}
+
+// loadFile 按内容识别 HAR 或 cassette 并读取, format 为 "auto"、"har" 或 "cassette"
+func loadFile(path, format string) ([]*entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if format == "auto" {
+		var probe map[string]json.RawMessage
+		if err := json.Unmarshal(data, &probe); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		switch {
+		case probe["log"] != nil:
+			format = "har"
+		case probe["interactions"] != nil:
+			format = "cassette"
+		default:
+			return nil, fmt.Errorf("%s: neither a HAR file nor a cassette", path)
+		}
+	}
+	switch format {
+	case "har":
+		h, err := har.Read(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return fromHAR(path, h)
+	case "cassette":
+		c, err := client.LoadCassette(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return fromCassette(path, c)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func fromHAR(path string, h *har.HAR) ([]*entry, error) {
+	var entries []*entry
+	for i, e := range h.Log.Entries {
+		src := fmt.Sprintf("%s#%d", path, i)
+		u, err := url.Parse(e.Request.URL)
+		if err != nil || !u.IsAbs() {
+			return nil, fmt.Errorf("%s: bad request URL %q", src, e.Request.URL)
+		}
+		body, err := e.Request.PostData.Body()
+		if err != nil {
+			return nil, fmt.Errorf("%s: request body: %w", src, err)
+		}
+		respBody, err := e.Response.Content.Body()
+		if err != nil {
+			return nil, fmt.Errorf("%s: response body: %w", src, err)
+		}
+		en := &entry{
+			source:     src,
+			started:    e.StartedDateTime,
+			duration:   har.Duration(e.Time),
+			method:     e.Request.Method,
+			url:        u,
+			header:     fromNameValues(e.Request.Headers),
+			body:       body,
+			status:     e.Response.Status,
+			respHeader: fromNameValues(e.Response.Headers),
+			respBody:   respBody,
+			hasBody:    e.Response.Content.Text != "" || e.Response.Content.Size == 0,
+		}
+		// HAR 保存的是解码后的内容, 不让服务器压缩响应才能逐字节比较
+		en.header.Del("Accept-Encoding")
+		en.respHeader.Del("Content-Encoding")
+		entries = append(entries, en)
+	}
+	return entries, nil
+}
+
+func fromCassette(path string, c *client.Cassette) ([]*entry, error) {
+	var entries []*entry
+	for i, it := range c.Interactions {
+		src := fmt.Sprintf("%s#%d", path, i)
+		u, err := url.Parse(it.Request.URL)
+		if err != nil || !u.IsAbs() {
+			return nil, fmt.Errorf("%s: bad request URL %q", src, it.Request.URL)
+		}
+		header := it.Request.Header.Clone()
+		if header == nil {
+			header = make(common.Header)
+		}
+		for k, vs := range header {
+			if len(vs) == 1 && vs[0] == redactedValue {
+				delete(header, k)
+			}
+		}
+		entries = append(entries, &entry{
+			source:     src,
+			started:    it.RecordedAt,
+			duration:   it.Duration,
+			method:     it.Request.Method,
+			url:        u,
+			header:     header,
+			body:       it.Request.Body,
+			status:     it.Response.StatusCode,
+			respHeader: it.Response.Header,
+			respBody:   it.Response.Body,
+			hasBody:    true,
+		})
+	}
+	return entries, nil
+}
+
+func fromNameValues(nvs []har.NameValue) common.Header {
+	h := make(common.Header)
+	for _, nv := range nvs {
+		// HTTP/2 的伪头部不是真正的头部
+		if strings.HasPrefix(nv.Name, ":") {
+			continue
+		}
+		h.Add(nv.Name, nv.Value)
+	}
+	return h
+}
+
+// loadAll 读取全部文件并按录制时间排序; 没有时间的条目保持文件中的顺序
+func loadAll(paths []string, format string) ([]*entry, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no input files")
+	}
+	var all []*entry
+	for _, p := range paths {
+		entries, err := loadFile(p, format)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, entries...)
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].started.IsZero() || all[j].started.IsZero() {
+			return false
+		}
+		return all[i].started.Before(all[j].started)
+	})
+	return all, nil
+}
@@ -0,0 +1,326 @@
+package main
+
+/*
+	httpreplay: 读取 HAR 文件或 client.Recorder 录制的 cassette, 向目标地址重新发送其中的请求,
+	可以按原始节奏或加速回放, 并把响应的状态码、头部和消息体与录制的响应比较.
+	有不一致或请求失败时退出码为 1, 便于在 CI 中做回归检查
+*/
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/client"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+// 退出码
+const (
+	exitOK       = 0
+	exitMismatch = 1
+	exitUsage    = 2
+)
+
+// multiFlag 可重复的字符串参数
+type multiFlag []string
+
+func (m *multiFlag) String() string     { return strings.Join(*m, ", ") }
+func (m *multiFlag) Set(v string) error { *m = append(*m, v); return nil }
+
+type options struct {
+	target         string
+	format         string
+	speed          float64
+	concurrency    int
+	methods        string
+	match          string
+	headers        multiFlag
+	compareHeaders multiFlag
+	ignoreBody     bool
+	preserveHost   bool
+	insecure       bool
+	timeout        time.Duration
+	jsonOut        string
+	verbose        bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func parseFlags(args []string, stderr io.Writer) (*options, []string, error) {
+	o := &options{}
+	fs := flag.NewFlagSet("httpreplay", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: httpreplay [flags] FILE...\n\nFILE is a HAR file or a recorded cassette.\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&o.target, "target", "", "send requests to this base URL instead of the recorded hosts")
+	fs.StringVar(&o.format, "format", "auto", "input format: auto, har or cassette")
+	fs.Float64Var(&o.speed, "speed", 1, "timing: 1 replays at the recorded pace, 2 twice as fast; 0 sends as fast as possible")
+	fs.IntVar(&o.concurrency, "c", 10, "maximum requests in flight (use 1 with -speed 0 to replay strictly in order)")
+	fs.StringVar(&o.methods, "methods", "", "replay only these comma-separated methods, e.g. GET,HEAD")
+	fs.StringVar(&o.match, "match", "", "replay only requests whose URL matches this regular expression")
+	fs.Var(&o.headers, "H", `request header "Name: value" overriding the recorded one, repeatable`)
+	fs.Var(&o.compareHeaders, "compare-header", "response header to compare, repeatable (default Content-Type)")
+	fs.BoolVar(&o.ignoreBody, "ignore-body", false, "compare only status codes and headers")
+	fs.BoolVar(&o.preserveHost, "preserve-host", false, "keep the recorded Host header when sending to -target")
+	fs.BoolVar(&o.insecure, "k", false, "skip TLS certificate verification")
+	fs.DurationVar(&o.timeout, "timeout", 30*time.Second, "per-request timeout")
+	fs.StringVar(&o.jsonOut, "json", "", `write a JSON report to this file ("-" for stdout)`)
+	fs.BoolVar(&o.verbose, "v", false, "print matching requests too")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return nil, nil, errors.New("at least one input file is required")
+	}
+	switch {
+	case o.speed < 0:
+		return nil, nil, errors.New("-speed must not be negative")
+	case o.concurrency <= 0:
+		return nil, nil, errors.New("-c must be positive")
+	}
+	if len(o.compareHeaders) == 0 {
+		o.compareHeaders = multiFlag{"Content-Type"}
+	}
+	return o, fs.Args(), nil
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	o, files, err := parseFlags(args, stderr)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		fmt.Fprintf(stderr, "httpreplay: %v\n", err)
+		return exitUsage
+	}
+	fail := func(err error) int {
+		fmt.Fprintf(stderr, "httpreplay: %v\n", err)
+		return exitUsage
+	}
+	entries, err := loadAll(files, o.format)
+	if err != nil {
+		return fail(err)
+	}
+	if entries, err = filter(entries, o.methods, o.match); err != nil {
+		return fail(err)
+	}
+	if len(entries) == 0 {
+		return fail(errors.New("no requests to replay"))
+	}
+
+	r := &replayer{
+		speed:        o.speed,
+		concurrency:  o.concurrency,
+		preserveHost: o.preserveHost,
+		header:       make(common.Header),
+		cmp:          &comparer{headers: o.compareHeaders, ignoreBody: o.ignoreBody},
+	}
+	if o.target != "" {
+		if !strings.Contains(o.target, "://") {
+			o.target = "http://" + o.target
+		}
+		if r.target, err = url.Parse(o.target); err != nil {
+			return fail(err)
+		}
+	}
+	for _, line := range o.headers {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fail(fmt.Errorf("bad header %q, want \"Name: value\"", line))
+		}
+		r.header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	tr := &client.Transport{
+		Pool: client.NewPool(client.PoolConfig{
+			MaxIdlePerHost: o.concurrency,
+			TLSConfig:      &tls.Config{InsecureSkipVerify: o.insecure},
+		}),
+	}
+	defer tr.CloseIdleConnections()
+	// 录制的请求自带 User-Agent, 不再添加默认值
+	r.client = client.New(client.WithTransport(tr), client.WithTimeout(o.timeout), client.WithUserAgent(""))
+
+	var mu sync.Mutex
+	r.done = func(res *outcome) {
+		if res.ok() && !o.verbose {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		printOutcome(stderr, res)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Fprintf(stderr, "replaying %d requests from %s\n", len(entries), strings.Join(files, ", "))
+	start := time.Now()
+	results := r.run(ctx, entries)
+	rep := newReport(results, time.Since(start))
+
+	if o.jsonOut != "-" {
+		printReport(stdout, rep)
+	}
+	if o.jsonOut != "" {
+		if err := writeJSON(o.jsonOut, stdout, rep); err != nil {
+			return fail(err)
+		}
+	}
+	if rep.Mismatched > 0 || rep.Errors > 0 || ctx.Err() != nil {
+		return exitMismatch
+	}
+	return exitOK
+}
+
+// filter 按方法和 URL 筛选条目
+func filter(entries []*entry, methods, match string) ([]*entry, error) {
+	var re *regexp.Regexp
+	if match != "" {
+		var err error
+		if re, err = regexp.Compile(match); err != nil {
+			return nil, fmt.Errorf("-match: %w", err)
+		}
+	}
+	allowed := make(map[string]bool)
+	for _, m := range strings.Split(methods, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			allowed[strings.ToUpper(m)] = true
+		}
+	}
+	var out []*entry
+	for _, e := range entries {
+		if len(allowed) > 0 && !allowed[e.method] {
+			continue
+		}
+		if re != nil && !re.MatchString(e.url.String()) {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// report 回放汇总, 也是 JSON 报告的格式
+type report struct {
+	Requests   int     `json:"requests"`
+	Matched    int     `json:"matched"`
+	Mismatched int     `json:"mismatched"`
+	Errors     int     `json:"errors"`
+	Elapsed    float64 `json:"elapsed_seconds"`
+	// Recorded 录制时从第一个请求开始到最后一个请求结束的时长, 秒
+	Recorded float64 `json:"recorded_seconds,omitempty"`
+	// MeanLatencyMs 回放的平均耗时, MeanRecordedMs 录制时的平均耗时
+	MeanLatencyMs  float64    `json:"mean_latency_ms"`
+	MeanRecordedMs float64    `json:"mean_recorded_ms,omitempty"`
+	Results        []*outcome `json:"results"`
+}
+
+func newReport(results []*outcome, elapsed time.Duration) *report {
+	rep := &report{Requests: len(results), Elapsed: elapsed.Seconds(), Results: results}
+	var first, last time.Time
+	var latency, recorded float64
+	var timed int
+	for _, o := range results {
+		switch {
+		case o.Error != "":
+			rep.Errors++
+		case len(o.Diffs) > 0:
+			rep.Mismatched++
+		default:
+			rep.Matched++
+		}
+		latency += o.LatencyMs
+		if o.RecordedMs > 0 {
+			recorded += o.RecordedMs
+			timed++
+		}
+		if e := o.entry; !e.started.IsZero() {
+			if first.IsZero() || e.started.Before(first) {
+				first = e.started
+			}
+			if end := e.started.Add(e.duration); end.After(last) {
+				last = end
+			}
+		}
+	}
+	if len(results) > 0 {
+		rep.MeanLatencyMs = latency / float64(len(results))
+	}
+	if timed > 0 {
+		rep.MeanRecordedMs = recorded / float64(timed)
+	}
+	if !first.IsZero() {
+		rep.Recorded = last.Sub(first).Seconds()
+	}
+	return rep
+}
+
+// printOutcome 输出一个条目的结果, 不一致时逐行列出差异
+func printOutcome(w io.Writer, o *outcome) {
+	tag := "OK  "
+	switch {
+	case o.Error != "":
+		tag = "ERR "
+	case len(o.Diffs) > 0:
+		tag = "DIFF"
+	}
+	u := o.URL
+	if u == "" {
+		u = o.entry.url.String()
+	}
+	fmt.Fprintf(w, "%s %s %s -> %d (%.1fms, recorded %d in %.1fms) [%s]\n",
+		tag, o.Method, u, o.Status, o.LatencyMs, o.Recorded, o.RecordedMs, o.Source)
+	if o.Error != "" {
+		fmt.Fprintf(w, "     %s\n", o.Error)
+	}
+	for _, d := range o.Diffs {
+		fmt.Fprintf(w, "     %s\n", d)
+	}
+}
+
+// printReport 输出可读的汇总
+func printReport(w io.Writer, r *report) {
+	fmt.Fprintf(w, "\nSummary:\n")
+	fmt.Fprintf(w, "  Requests:   %d (%d matched, %d mismatched, %d errors)\n", r.Requests, r.Matched, r.Mismatched, r.Errors)
+	fmt.Fprintf(w, "  Elapsed:    %.2fs", r.Elapsed)
+	if r.Recorded > 0 {
+		fmt.Fprintf(w, " (recorded %.2fs)", r.Recorded)
+	}
+	fmt.Fprintf(w, "\n  Latency:    mean %.1fms", r.MeanLatencyMs)
+	if r.MeanRecordedMs > 0 {
+		fmt.Fprintf(w, " (recorded %.1fms)", r.MeanRecordedMs)
+	}
+	fmt.Fprintln(w)
+}
+
+// writeJSON 把报告写到 path, "-" 表示 stdout
+func writeJSON(path string, stdout io.Writer, r *report) error {
+	out := stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
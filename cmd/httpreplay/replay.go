@@ -0,0 +1,185 @@
+package main
+
+/*
+	回放调度: speed > 0 时按录制时的相对时间 (除以 speed) 发起请求, speed 为 0 时尽快发送;
+	在途请求数受 concurrency 限制, 达到上限时后续请求等待, 推迟的时间记为 lag
+*/
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/client"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+// skipHeaders 不随请求回放的头部: 由传输层按实际连接生成
+var skipHeaders = []string{
+	"Host", "Content-Length", "Connection", "Keep-Alive", "Proxy-Connection",
+	"Transfer-Encoding", "Te", "Trailer", "Upgrade",
+}
+
+// replayer 回放参数
+type replayer struct {
+	client *client.Client
+	// target 非空时把请求改发到该地址, 保留原请求的路径和查询参数
+	target *url.URL
+	// preserveHost 改发时仍使用录制的 Host
+	preserveHost bool
+	// header 覆盖录制的请求头, 如补上 cassette 中被脱敏的 Authorization
+	header      common.Header
+	speed       float64
+	concurrency int
+	cmp         *comparer
+	// done 非空时每完成一个请求调用一次
+	done func(r *outcome)
+}
+
+// outcome 一个条目的回放结果, 也是 JSON 报告中的一项
+type outcome struct {
+	entry *entry
+
+	Source   string   `json:"source"`
+	Method   string   `json:"method"`
+	URL      string   `json:"url"`
+	Recorded int      `json:"recorded_status"`
+	Status   int      `json:"status,omitempty"`
+	Diffs    []string `json:"diffs,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	// LatencyMs 回放耗时, RecordedMs 录制时的耗时, 毫秒
+	LatencyMs  float64 `json:"latency_ms"`
+	RecordedMs float64 `json:"recorded_ms,omitempty"`
+	// LagMs 因并发上限而晚于计划发起的时间, 毫秒
+	LagMs float64 `json:"lag_ms,omitempty"`
+}
+
+// ok 响应与录制一致
+func (o *outcome) ok() bool { return o.Error == "" && len(o.Diffs) == 0 }
+
+// run 回放全部条目, 结果与 entries 顺序一致
+func (r *replayer) run(ctx context.Context, entries []*entry) []*outcome {
+	out := make([]*outcome, len(entries))
+	var base time.Time
+	for _, e := range entries {
+		if !e.started.IsZero() {
+			base = e.started
+			break
+		}
+	}
+	start := time.Now()
+	sem := make(chan struct{}, r.concurrency)
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		planned := start
+		if r.speed > 0 && !base.IsZero() && !e.started.IsZero() {
+			planned = start.Add(time.Duration(float64(e.started.Sub(base)) / r.speed))
+		}
+		if d := time.Until(planned); d > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(d):
+			}
+		}
+		select {
+		case <-ctx.Done():
+		case sem <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		o := &outcome{entry: e, Source: e.source, Method: e.method, Recorded: e.status, RecordedMs: ms(e.duration)}
+		if lag := time.Since(planned); r.speed > 0 && lag > time.Millisecond {
+			o.LagMs = ms(lag)
+		}
+		out[i] = o
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			r.replay(ctx, o)
+			if r.done != nil {
+				r.done(o)
+			}
+		}()
+	}
+	wg.Wait()
+	// 被中断时未发出的条目不计入结果
+	n := 0
+	for _, o := range out {
+		if o != nil {
+			out[n] = o
+			n++
+		}
+	}
+	return out[:n]
+}
+
+// replay 发送一个条目并比较响应
+func (r *replayer) replay(ctx context.Context, o *outcome) {
+	e := o.entry
+	req, err := r.newRequest(ctx, e)
+	if err != nil {
+		o.Error = err.Error()
+		return
+	}
+	o.URL = req.URL.String()
+	start := time.Now()
+	resp, err := r.client.Do(req)
+	if err != nil {
+		o.LatencyMs = ms(time.Since(start))
+		o.Error = err.Error()
+		return
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	o.LatencyMs = ms(time.Since(start))
+	o.Status = resp.StatusCode
+	if err != nil {
+		o.Error = "reading body: " + err.Error()
+		return
+	}
+	o.Diffs = r.cmp.compare(e, resp.StatusCode, resp.Header, body)
+}
+
+// newRequest 按录制的内容重建请求
+func (r *replayer) newRequest(ctx context.Context, e *entry) (*message.Request, error) {
+	u := *e.url
+	if r.target != nil {
+		u.Scheme, u.Host, u.User = r.target.Scheme, r.target.Host, r.target.User
+		if p := strings.TrimSuffix(r.target.Path, "/"); p != "" {
+			u.Path = p + u.Path
+			if u.RawPath != "" {
+				u.RawPath = strings.TrimSuffix(r.target.EscapedPath(), "/") + u.RawPath
+			}
+		}
+	}
+	var body io.Reader
+	if len(e.body) > 0 {
+		body = bytes.NewReader(e.body)
+	}
+	req, err := message.NewRequestWithContext(ctx, e.method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range e.header {
+		req.Header[k] = append([]string(nil), vs...)
+	}
+	if r.target != nil && r.preserveHost {
+		req.Host = e.url.Host
+	}
+	for _, k := range skipHeaders {
+		req.Header.Del(k)
+	}
+	for k, vs := range r.header {
+		req.Header[k] = append([]string(nil), vs...)
+	}
+	return req, nil
+}
+
+func ms(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
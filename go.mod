@@ -0,0 +1,3 @@
+module github.com/narcilee7/http-stack
+
+go 1.23
@@ -0,0 +1,130 @@
+package testing
+
+/*
+	测试断言辅助: 轮询等待条件成立、错误匹配和包含判断, 失败信息带调用位置
+*/
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Helper 包装 testing.TB 提供常用断言, 断言失败时标记测试失败并继续执行, 返回值表示断言是否成立
+type Helper struct {
+	testing.TB
+}
+
+// NewHelper 创建断言辅助
+func NewHelper(tb testing.TB) *Helper {
+	return &Helper{TB: tb}
+}
+
+// AssertEventually 每隔 interval 检查一次 cond, 在 timeout 内成立即通过; interval <= 0 时取 10ms
+func (h *Helper) AssertEventually(cond func() bool, timeout, interval time.Duration, msgAndArgs ...any) bool {
+	h.Helper()
+	if interval <= 0 {
+		interval = 10 * time.Millisecond
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		if cond() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			h.Errorf("condition not met within %v%s", timeout, formatMsg(msgAndArgs))
+			return false
+		}
+		time.Sleep(interval)
+	}
+}
+
+// AssertNoError 断言 err 为 nil
+func (h *Helper) AssertNoError(err error, msgAndArgs ...any) bool {
+	h.Helper()
+	if err != nil {
+		h.Errorf("unexpected error: %v%s", err, formatMsg(msgAndArgs))
+		return false
+	}
+	return true
+}
+
+// AssertErrorIs 断言 errors.Is(err, target)
+func (h *Helper) AssertErrorIs(err, target error, msgAndArgs ...any) bool {
+	h.Helper()
+	if !errors.Is(err, target) {
+		h.Errorf("error %v does not match %v%s", err, target, formatMsg(msgAndArgs))
+		return false
+	}
+	return true
+}
+
+// AssertErrorAs 断言 errors.As(err, target), target 为指向错误类型变量的指针
+func (h *Helper) AssertErrorAs(err error, target any, msgAndArgs ...any) bool {
+	h.Helper()
+	if !errors.As(err, target) {
+		h.Errorf("error %v is not assignable to %s%s", err, reflect.TypeOf(target).Elem(), formatMsg(msgAndArgs))
+		return false
+	}
+	return true
+}
+
+// AssertContains 断言 s 包含 sub, 两者为 string 或 []byte
+func (h *Helper) AssertContains(s, sub any, msgAndArgs ...any) bool {
+	h.Helper()
+	hay, ok1 := asBytes(s)
+	needle, ok2 := asBytes(sub)
+	if !ok1 || !ok2 {
+		h.Errorf("AssertContains: unsupported types %T, %T", s, sub)
+		return false
+	}
+	if !bytes.Contains(hay, needle) {
+		h.Errorf("%q does not contain %q%s", truncateForMsg(hay), needle, formatMsg(msgAndArgs))
+		return false
+	}
+	return true
+}
+
+// AssertEqual 按 reflect.DeepEqual 断言相等
+func (h *Helper) AssertEqual(want, got any, msgAndArgs ...any) bool {
+	h.Helper()
+	if !reflect.DeepEqual(want, got) {
+		h.Errorf("not equal:\n want: %#v\n  got: %#v%s", want, got, formatMsg(msgAndArgs))
+		return false
+	}
+	return true
+}
+
+func asBytes(v any) ([]byte, bool) {
+	switch v := v.(type) {
+	case string:
+		return []byte(v), true
+	case []byte:
+		return v, true
+	}
+	return nil, false
+}
+
+// truncateForMsg 截断过长的内容, 避免失败信息刷屏
+func truncateForMsg(b []byte) []byte {
+	const limit = 512
+	if len(b) <= limit {
+		return b
+	}
+	return append(b[:limit:limit], "..."...)
+}
+
+// formatMsg 将可选的 (format, args...) 格式化为 ": msg"
+func formatMsg(msgAndArgs []any) string {
+	if len(msgAndArgs) == 0 {
+		return ""
+	}
+	if format, ok := msgAndArgs[0].(string); ok {
+		return ": " + fmt.Sprintf(format, msgAndArgs[1:]...)
+	}
+	return ": " + strings.TrimSuffix(fmt.Sprintln(msgAndArgs...), "\n")
+}
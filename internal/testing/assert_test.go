@@ -0,0 +1,72 @@
+package testing
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHelperPassing(t *testing.T) {
+	h := NewHelper(t)
+	var n atomic.Int32
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		n.Store(1)
+	}()
+	var pathErr *fs.PathError
+	_, openErr := os.Open("/nonexistent/file")
+	ok := h.AssertEventually(func() bool { return n.Load() == 1 }, time.Second, 0) &&
+		h.AssertNoError(nil) &&
+		h.AssertErrorIs(openErr, fs.ErrNotExist) &&
+		h.AssertErrorAs(openErr, &pathErr) &&
+		h.AssertContains("hello world", []byte("o w")) &&
+		h.AssertEqual(map[string][]int{"a": {1}}, map[string][]int{"a": {1}})
+	if !ok || pathErr.Path != "/nonexistent/file" {
+		t.Fatal("passing assertions reported failure")
+	}
+}
+
+func TestHelperFailures(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		assert func(h *Helper) bool
+		want   string
+	}{
+		{"eventually", func(h *Helper) bool {
+			return h.AssertEventually(func() bool { return false }, 20*time.Millisecond, time.Millisecond, "waiting for %s", "x")
+		}, "condition not met within 20ms: waiting for x"},
+		{"no error", func(h *Helper) bool { return h.AssertNoError(io.EOF, 42) }, "unexpected error: EOF: 42"},
+		{"error is", func(h *Helper) bool { return h.AssertErrorIs(io.EOF, io.ErrUnexpectedEOF) }, "error EOF does not match unexpected EOF"},
+		{"error as", func(h *Helper) bool {
+			var pe *fs.PathError
+			return h.AssertErrorAs(errors.New("boom"), &pe)
+		}, "is not assignable to *fs.PathError"},
+		{"contains", func(h *Helper) bool { return h.AssertContains([]byte("abc"), "z") }, `"abc" does not contain "z"`},
+		{"contains types", func(h *Helper) bool { return h.AssertContains(1, "z") }, "unsupported types int, string"},
+		{"equal", func(h *Helper) bool { return h.AssertEqual(1, int64(1)) }, "want: 1\n  got: 1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var ok bool
+			tb := runFake(t, func(tb *fakeTB) { ok = tt.assert(NewHelper(tb)) })
+			if ok || !tb.Failed() || tb.fatal {
+				t.Fatalf("ok=%v failed=%v fatal=%v", ok, tb.Failed(), tb.fatal)
+			}
+			if !strings.Contains(tb.output(), tt.want) {
+				t.Fatalf("message %q does not contain %q", tb.output(), tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateForMsg(t *testing.T) {
+	long := []byte(strings.Repeat("x", 600))
+	got := truncateForMsg(long)
+	if len(got) != 515 || !strings.HasSuffix(string(got), "...") || len(long) != 600 || long[512] != 'x' {
+		t.Fatalf("truncateForMsg changed input or produced %d bytes", len(got))
+	}
+}
@@ -0,0 +1,368 @@
+package testing
+
+/*
+	抓包代理: 位于被测客户端和服务端之间的 HTTP/1.1 代理, 记录每一对请求和响应,
+	并可按规则即时修改 (删除或设置头部、改写或截断消息体), 用于互操作测试
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http1"
+)
+
+// ErrTruncated 消息体被代理按规则截断
+var ErrTruncated = errors.New("testing: body truncated by proxy")
+
+// ProxyMutation 代理的改写规则, 零值不做任何修改
+type ProxyMutation struct {
+	// Match 为空时作用于所有请求
+	Match func(req *message.Request) bool
+	// Once 只作用于第一个匹配的请求
+	Once bool
+
+	DropRequestHeaders []string
+	SetRequestHeaders  common.Header
+	// RequestBody 改写请求体, Content-Length 随之更新
+	RequestBody func(body []byte) []byte
+	// TruncateRequestBody > 0 时只转发请求体线上的前 n 字节后半关闭上游连接, 声明的长度不变
+	TruncateRequestBody int
+
+	DropResponseHeaders []string
+	SetResponseHeaders  common.Header
+	// ResponseBody 改写响应体, Content-Length 随之更新
+	ResponseBody func(body []byte) []byte
+	// TruncateResponseBody > 0 时只转发响应体线上的前 n 字节后关闭客户端连接, 声明的长度不变
+	TruncateResponseBody int
+
+	used bool
+}
+
+// ProxyExchange 代理记录的一次交换, 请求和响应均为改写前的原始内容
+type ProxyExchange struct {
+	Request         CapturedRequest
+	StatusCode      int
+	ResponseHeader  common.Header
+	ResponseBody    []byte
+	ResponseTrailer common.Header
+	// Mutated 至少有一条规则作用于这次交换
+	Mutated bool
+	// Err 转发失败或被截断的原因
+	Err      error
+	Duration time.Duration
+}
+
+// CapturingProxy 转发到固定上游的抓包代理, 并发安全
+type CapturingProxy struct {
+	// URL 形如 "http://127.0.0.1:port", 客户端用它代替上游地址
+	URL string
+	// Addr 监听地址
+	Addr string
+
+	target    string
+	ln        net.Listener
+	mu        sync.Mutex
+	mutations []*ProxyMutation
+	exchanges []ProxyExchange
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewCapturingProxy 启动转发到 target (host:port) 的代理, tb 不为空时在测试结束时自动关闭
+func NewCapturingProxy(tb testing.TB, target string) (*CapturingProxy, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &CapturingProxy{
+		URL:    "http://" + ln.Addr().String(),
+		Addr:   ln.Addr().String(),
+		target: target,
+		ln:     ln,
+		conns:  make(map[net.Conn]struct{}),
+	}
+	p.wg.Add(1)
+	go p.serve()
+	if tb != nil {
+		tb.Cleanup(p.Close)
+	}
+	return p, nil
+}
+
+// Mutate 添加改写规则, 多条规则按添加顺序依次作用
+func (p *CapturingProxy) Mutate(m *ProxyMutation) {
+	p.mu.Lock()
+	p.mutations = append(p.mutations, m)
+	p.mu.Unlock()
+}
+
+// ClearMutations 移除所有改写规则
+func (p *CapturingProxy) ClearMutations() {
+	p.mu.Lock()
+	p.mutations = nil
+	p.mu.Unlock()
+}
+
+// Exchanges 返回至今记录的交换
+func (p *CapturingProxy) Exchanges() []ProxyExchange {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]ProxyExchange(nil), p.exchanges...)
+}
+
+// Reset 清除改写规则和已记录的交换
+func (p *CapturingProxy) Reset() {
+	p.mu.Lock()
+	p.mutations, p.exchanges = nil, nil
+	p.mu.Unlock()
+}
+
+// Close 停止监听并关闭所有连接, 等待处理 goroutine 退出
+func (p *CapturingProxy) Close() {
+	p.closeOnce.Do(func() {
+		p.ln.Close()
+		p.mu.Lock()
+		for c := range p.conns {
+			c.Close()
+		}
+		p.mu.Unlock()
+		p.wg.Wait()
+	})
+}
+
+func (p *CapturingProxy) serve() {
+	defer p.wg.Done()
+	for {
+		c, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		p.track(c, true)
+		p.wg.Add(1)
+		go p.serveConn(c)
+	}
+}
+
+func (p *CapturingProxy) track(c net.Conn, add bool) {
+	p.mu.Lock()
+	if add {
+		p.conns[c] = struct{}{}
+	} else {
+		delete(p.conns, c)
+	}
+	p.mu.Unlock()
+}
+
+// match 返回作用于 req 的规则, 并消耗 Once 规则
+func (p *CapturingProxy) match(req *message.Request) []*ProxyMutation {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var ms []*ProxyMutation
+	for _, m := range p.mutations {
+		if m.used || m.Match != nil && !m.Match(req) {
+			continue
+		}
+		m.used = m.Once
+		ms = append(ms, m)
+	}
+	return ms
+}
+
+// proxyConn 一个客户端连接及其对应的上游连接
+type proxyConn struct {
+	p        *CapturingProxy
+	client   net.Conn
+	br       *bufio.Reader
+	bw       *bufio.Writer
+	upstream net.Conn
+	ubr      *bufio.Reader
+	ubw      *bufio.Writer
+}
+
+func (p *CapturingProxy) serveConn(c net.Conn) {
+	defer p.wg.Done()
+	pc := &proxyConn{p: p, client: c, br: bufio.NewReader(c), bw: bufio.NewWriter(c)}
+	defer func() {
+		c.Close()
+		p.track(c, false)
+		pc.closeUpstream()
+	}()
+	for pc.roundTrip() {
+	}
+}
+
+func (pc *proxyConn) closeUpstream() {
+	if pc.upstream != nil {
+		pc.upstream.Close()
+		pc.p.track(pc.upstream, false)
+		pc.upstream = nil
+	}
+}
+
+func (pc *proxyConn) dial() error {
+	if pc.upstream != nil {
+		return nil
+	}
+	c, err := net.Dial("tcp", pc.p.target)
+	if err != nil {
+		return err
+	}
+	pc.p.track(c, true)
+	pc.upstream, pc.ubr, pc.ubw = c, bufio.NewReader(c), bufio.NewWriter(c)
+	return nil
+}
+
+// roundTrip 转发一对请求和响应, 返回是否继续使用客户端连接
+func (pc *proxyConn) roundTrip() bool {
+	req, err := http1.ReadRequest(pc.br)
+	if err != nil {
+		return false
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return false
+	}
+	start := time.Now()
+	ex := ProxyExchange{Request: CapturedRequest{
+		Method:     req.Method,
+		Target:     req.RequestURI(),
+		Proto:      req.Proto,
+		Header:     req.Header.Clone(),
+		Body:       body,
+		RemoteAddr: pc.client.RemoteAddr().String(),
+		Time:       start,
+	}}
+	if t, ok := req.Body.(interface{ Trailer() common.Header }); ok {
+		ex.Request.Trailer = t.Trailer()
+		req.Trailer = t.Trailer()
+	}
+	defer func() {
+		ex.Duration = time.Since(start)
+		pc.p.mu.Lock()
+		pc.p.exchanges = append(pc.p.exchanges, ex)
+		pc.p.mu.Unlock()
+	}()
+
+	ms := pc.p.match(req)
+	ex.Mutated = len(ms) > 0
+	truncReq, truncResp := 0, 0
+	for _, m := range ms {
+		dropHeaders(req.Header, m.DropRequestHeaders, m.SetRequestHeaders)
+		if m.RequestBody != nil {
+			body = m.RequestBody(body)
+		}
+		truncReq = max(truncReq, m.TruncateRequestBody)
+		truncResp = max(truncResp, m.TruncateResponseBody)
+	}
+	if req.Body != message.NoBody || len(body) > 0 {
+		req.Header.Del("Transfer-Encoding")
+		req.Header.Del("Content-Length")
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+		req.Trailer = nil
+	}
+
+	if ex.Err = pc.dial(); ex.Err != nil {
+		pc.writeBadGateway(req)
+		return false
+	}
+	if truncReq > 0 {
+		ex.Err = writeTruncated(pc.upstream, truncReq, func(w *bufio.Writer) error { return http1.WriteRequest(w, req) })
+		if tc, ok := pc.upstream.(*net.TCPConn); ok {
+			tc.CloseWrite()
+		}
+	} else {
+		ex.Err = http1.WriteRequest(pc.ubw, req)
+	}
+	if ex.Err != nil && !errors.Is(ex.Err, ErrTruncated) {
+		pc.writeBadGateway(req)
+		return false
+	}
+
+	resp, err := http1.ReadResponse(pc.ubr, req)
+	if err != nil {
+		ex.Err = errors.Join(ex.Err, err)
+		pc.writeBadGateway(req)
+		return false
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		ex.Err = errors.Join(ex.Err, err)
+		return false
+	}
+	ex.StatusCode = resp.StatusCode
+	ex.ResponseHeader = resp.Header.Clone()
+	ex.ResponseBody = respBody
+	if t, ok := resp.Body.(interface{ Trailer() common.Header }); ok {
+		ex.ResponseTrailer = t.Trailer()
+		resp.Trailer = t.Trailer()
+	}
+	if resp.Close || truncReq > 0 {
+		pc.closeUpstream()
+	}
+
+	for _, m := range ms {
+		dropHeaders(resp.Header, m.DropResponseHeaders, m.SetResponseHeaders)
+		if m.ResponseBody != nil {
+			respBody = m.ResponseBody(respBody)
+		}
+	}
+	if resp.Body != message.NoBody {
+		resp.Header.Del("Transfer-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.Body = io.NopCloser(bytes.NewReader(respBody))
+		resp.ContentLength = int64(len(respBody))
+		resp.Trailer = nil
+	}
+	if truncResp > 0 {
+		ex.Err = errors.Join(ex.Err, writeTruncated(pc.client, truncResp, func(w *bufio.Writer) error { return http1.WriteResponse(w, resp) }))
+		return false
+	}
+	if err := http1.WriteResponse(pc.bw, resp); err != nil {
+		ex.Err = errors.Join(ex.Err, err)
+		return false
+	}
+	return !resp.Close && !req.Close
+}
+
+func (pc *proxyConn) writeBadGateway(req *message.Request) {
+	resp := message.NewResponse(common.StatusBadGateway)
+	resp.Request = req
+	resp.Close = true
+	http1.WriteResponse(pc.bw, resp)
+}
+
+func dropHeaders(h common.Header, drop []string, set common.Header) {
+	for _, k := range drop {
+		h.Del(k)
+	}
+	for k, vs := range set {
+		h[k] = append([]string(nil), vs...)
+	}
+}
+
+// writeTruncated 将 write 的输出写入 c, 消息体部分只保留前 n 字节, 成功时返回 ErrTruncated
+func writeTruncated(c net.Conn, n int, write func(w *bufio.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(bufio.NewWriter(&buf)); err != nil {
+		return err
+	}
+	b := buf.Bytes()
+	if i := bytes.Index(b, []byte("\r\n\r\n")); i >= 0 {
+		b = b[:min(len(b), i+4+n)]
+	}
+	if _, err := c.Write(b); err != nil {
+		return err
+	}
+	return ErrTruncated
+}
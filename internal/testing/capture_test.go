@@ -0,0 +1,148 @@
+package testing
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/client"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+func newCaptureSetup(t *testing.T) (*MockServer, *CapturingProxy, *client.Client) {
+	t.Helper()
+	srv, err := NewMockServer(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.HandleFunc("", "/*", func(req *message.Request, body []byte) *MockResponse {
+		return &MockResponse{
+			Header: common.Header{"X-Upstream": {"1"}, "X-Seen-Token": {req.Header.Get("X-Token")}},
+			Body:   append([]byte("echo:"), body...),
+		}
+	})
+	p, err := NewCapturingProxy(t, srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := client.New()
+	c.SetTimeout(5 * time.Second)
+	return srv, p, c
+}
+
+func post(t *testing.T, c *client.Client, url, body string) (*message.Response, string, error) {
+	t.Helper()
+	req, err := message.NewRequest("POST", url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Token", "secret")
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	return resp, string(b), err
+}
+
+func TestCapturingProxyRecords(t *testing.T) {
+	srv, p, c := newCaptureSetup(t)
+	resp, body, err := post(t, c, p.URL+"/items?id=1", "hello")
+	if err != nil || body != "echo:hello" || resp.Header.Get("X-Upstream") != "1" {
+		t.Fatalf("via proxy: %v %q %v", resp, body, err)
+	}
+	if _, body, err := post(t, c, p.URL+"/second", "again"); err != nil || body != "echo:again" {
+		t.Fatalf("second request on kept-alive connection: %q %v", body, err)
+	}
+
+	exs := p.Exchanges()
+	if len(exs) != 2 {
+		t.Fatalf("captured %d exchanges", len(exs))
+	}
+	ex := exs[0]
+	if ex.Request.Method != "POST" || ex.Request.Target != "/items?id=1" || string(ex.Request.Body) != "hello" ||
+		ex.Request.Header.Get("X-Token") != "secret" || ex.Request.RemoteAddr == "" {
+		t.Fatalf("captured request = %+v", ex.Request)
+	}
+	if ex.StatusCode != 200 || string(ex.ResponseBody) != "echo:hello" || ex.Mutated || ex.Err != nil || ex.Duration <= 0 {
+		t.Fatalf("captured exchange = %+v", ex)
+	}
+	if len(srv.Requests()) != 2 {
+		t.Fatalf("upstream saw %d requests", len(srv.Requests()))
+	}
+	p.Reset()
+	if len(p.Exchanges()) != 0 {
+		t.Fatal("Reset kept exchanges")
+	}
+}
+
+func TestCapturingProxyMutations(t *testing.T) {
+	srv, p, c := newCaptureSetup(t)
+	p.Mutate(&ProxyMutation{
+		Match:               func(req *message.Request) bool { return strings.HasPrefix(req.RequestURI(), "/rewrite") },
+		DropRequestHeaders:  []string{"X-Token"},
+		SetRequestHeaders:   common.Header{"X-Injected": {"yes"}},
+		RequestBody:         bytes.ToUpper,
+		DropResponseHeaders: []string{"X-Upstream"},
+		SetResponseHeaders:  common.Header{"X-Proxy": {"capture"}},
+		ResponseBody:        func(b []byte) []byte { return append(b, "!"...) },
+	})
+	p.Mutate(&ProxyMutation{Once: true, SetResponseHeaders: common.Header{"X-Once": {"1"}}})
+
+	resp, body, err := post(t, c, p.URL+"/rewrite", "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 请求体先被改写为大写再由上游回显, 响应体随后追加 "!"
+	if body != "echo:ABC!" || resp.Header.Get("X-Upstream") != "" || resp.Header.Get("X-Proxy") != "capture" ||
+		resp.Header.Get("X-Seen-Token") != "" || resp.Header.Get("X-Once") != "1" {
+		t.Fatalf("mutated response: %q %v", body, resp.Header)
+	}
+	last, _ := srv.LastRequest()
+	if last.Header.Get("X-Injected") != "yes" || string(last.Body) != "ABC" {
+		t.Fatalf("upstream request = %+v", last)
+	}
+	// 记录的是改写前的原始内容
+	ex := p.Exchanges()[0]
+	if !ex.Mutated || string(ex.Request.Body) != "abc" || string(ex.ResponseBody) != "echo:ABC" {
+		t.Fatalf("exchange = %+v", ex)
+	}
+
+	resp, body, err = post(t, c, p.URL+"/plain", "x")
+	if err != nil || body != "echo:x" || resp.Header.Get("X-Once") != "" || resp.Header.Get("X-Seen-Token") != "secret" {
+		t.Fatalf("unmatched request: %q %v %v", body, resp.Header, err)
+	}
+
+	p.ClearMutations()
+	p.Mutate(&ProxyMutation{TruncateResponseBody: 3})
+	if _, body, err := post(t, c, p.URL+"/cut", "truncated"); err == nil {
+		t.Fatalf("truncated response read without error: %q", body)
+	}
+	if ex := p.Exchanges()[2]; ex.Err == nil {
+		t.Fatal("truncation not recorded")
+	}
+}
+
+func TestCapturingProxyBadGateway(t *testing.T) {
+	srv, err := NewMockServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := srv.Addr
+	srv.Close()
+	p, err := NewCapturingProxy(t, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _, err := post(t, client.New(), p.URL+"/", "x")
+	if err != nil || resp.StatusCode != 502 {
+		t.Fatalf("upstream down: %v, %v", resp, err)
+	}
+	if ex := p.Exchanges(); len(ex) != 1 || ex[0].Err == nil {
+		t.Fatalf("exchanges = %+v", ex)
+	}
+}
@@ -0,0 +1,194 @@
+package testing
+
+/*
+	测试用 TLS 证书: 在内存中生成临时 CA 和叶子证书 (服务端、客户端、过期、未生效、自签名等),
+	无需证书文件即可测试服务器、客户端和代理的 TLS 路径
+*/
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"time"
+)
+
+// CA 测试用证书颁发机构
+type CA struct {
+	Cert    *x509.Certificate
+	Key     crypto.Signer
+	CertPEM []byte
+}
+
+// Cert 签发的证书及私钥
+type Cert struct {
+	Leaf    *x509.Certificate
+	Key     crypto.Signer
+	CertPEM []byte
+	KeyPEM  []byte
+	// TLS 可直接用于 tls.Config.Certificates, 证书链包含签发的 CA
+	TLS tls.Certificate
+}
+
+// CertOptions 签发选项
+type CertOptions struct {
+	// CommonName 默认为 DNSNames 的第一项或 "localhost"
+	CommonName string
+	// DNSNames 和 IPs 都为空时使用 localhost、127.0.0.1 和 ::1
+	DNSNames []string
+	IPs      []net.IP
+	// Client 签发客户端认证证书, 否则为服务端证书
+	Client bool
+	// NotBefore/NotAfter 为零时有效期为当前时间前 1 小时到 24 小时后
+	NotBefore time.Time
+	NotAfter  time.Time
+	// Expired 签发已过期的证书, 优先于 NotBefore/NotAfter
+	Expired bool
+	// NotYetValid 签发尚未生效的证书
+	NotYetValid bool
+}
+
+// NewCA 生成自签名 CA
+func NewCA() (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "http-stack test CA", Organization: []string{"http-stack"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{Cert: cert, Key: key, CertPEM: pemBlock("CERTIFICATE", der)}, nil
+}
+
+// Issue 签发证书
+func (ca *CA) Issue(opts CertOptions) (*Cert, error) {
+	return issue(opts, ca.Cert, ca.Key)
+}
+
+// SelfSigned 生成不由任何 CA 签发的自签名证书, 用于测试证书不受信任的情况
+func SelfSigned(opts CertOptions) (*Cert, error) {
+	return issue(opts, nil, nil)
+}
+
+func issue(opts CertOptions, parent *x509.Certificate, parentKey crypto.Signer) (*Cert, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.DNSNames) == 0 && len(opts.IPs) == 0 {
+		opts.DNSNames = []string{"localhost"}
+		opts.IPs = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	}
+	cn := opts.CommonName
+	if cn == "" {
+		cn = "localhost"
+		if len(opts.DNSNames) > 0 {
+			cn = opts.DNSNames[0]
+		}
+	}
+	now := time.Now()
+	notBefore, notAfter := opts.NotBefore, opts.NotAfter
+	switch {
+	case opts.Expired:
+		notBefore, notAfter = now.Add(-48*time.Hour), now.Add(-24*time.Hour)
+	case opts.NotYetValid:
+		notBefore, notAfter = now.Add(24*time.Hour), now.Add(48*time.Hour)
+	}
+	if notBefore.IsZero() {
+		notBefore = now.Add(-time.Hour)
+	}
+	if notAfter.IsZero() {
+		notAfter = now.Add(24 * time.Hour)
+	}
+	usage := x509.ExtKeyUsageServerAuth
+	if opts.Client {
+		usage = x509.ExtKeyUsageClientAuth
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     opts.DNSNames,
+		IPAddresses:  opts.IPs,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cert{Leaf: leaf, Key: key, CertPEM: pemBlock("CERTIFICATE", der), KeyPEM: pemBlock("PRIVATE KEY", keyDER)}
+	c.TLS = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+	if parent != tmpl {
+		c.TLS.Certificate = append(c.TLS.Certificate, parent.Raw)
+	}
+	return c, nil
+}
+
+// Pool 返回只包含该 CA 的证书池
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	return pool
+}
+
+// ServerConfig 返回使用 cert 的服务端配置; requireClientCert 为 true 时要求并校验由该 CA 签发的客户端证书
+func (ca *CA) ServerConfig(cert *Cert, requireClientCert bool) *tls.Config {
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert.TLS}, MinVersion: tls.VersionTLS12}
+	if requireClientCert {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		cfg.ClientCAs = ca.Pool()
+	}
+	return cfg
+}
+
+// ClientConfig 返回信任该 CA 的客户端配置, clientCert 不为空时用于双向认证
+func (ca *CA) ClientConfig(clientCert *Cert) *tls.Config {
+	cfg := &tls.Config{RootCAs: ca.Pool(), MinVersion: tls.VersionTLS12}
+	if clientCert != nil {
+		cfg.Certificates = []tls.Certificate{clientCert.TLS}
+	}
+	return cfg
+}
+
+func randomSerial() *big.Int {
+	n, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return n
+}
+
+func pemBlock(typ string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+}
@@ -0,0 +1,100 @@
+package testing
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"testing"
+)
+
+// handshake 在回环连接上完成一次 TLS 握手, 返回客户端和服务端的错误.
+// net.Pipe 没有缓冲, TLS 1.3 服务端发送会话票据时会与客户端互相阻塞, 因此使用 TCP
+func handshake(t *testing.T, serverCfg, clientCfg *tls.Config) (clientErr, serverErr error) {
+	c, s := tcpPair(t)
+	done := make(chan error, 1)
+	go func() {
+		ts := tls.Server(s, serverCfg)
+		err := ts.Handshake()
+		if err != nil {
+			// 让客户端的握手尽快结束
+			s.Close()
+		}
+		done <- err
+	}()
+	clientErr = tls.Client(c, clientCfg).Handshake()
+	if clientErr != nil {
+		c.Close()
+	}
+	return clientErr, <-done
+}
+
+func TestCAMutualTLS(t *testing.T) {
+	ca, err := NewCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := ca.Issue(CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := ca.Issue(CertOptions{Client: true, CommonName: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.Leaf.Subject.CommonName != "localhost" || len(srv.Leaf.IPAddresses) != 2 || len(srv.TLS.Certificate) != 2 {
+		t.Fatalf("server cert = %v %v, chain %d", srv.Leaf.Subject, srv.Leaf.IPAddresses, len(srv.TLS.Certificate))
+	}
+	if _, err := tls.X509KeyPair(srv.CertPEM, srv.KeyPEM); err != nil {
+		t.Fatalf("PEM pair: %v", err)
+	}
+
+	clientCfg := ca.ClientConfig(cli)
+	clientCfg.ServerName = "localhost"
+	if cerr, serr := handshake(t, ca.ServerConfig(srv, true), clientCfg); cerr != nil || serr != nil {
+		t.Fatalf("mTLS handshake: client %v, server %v", cerr, serr)
+	}
+
+	noCert := ca.ClientConfig(nil)
+	noCert.ServerName = "localhost"
+	if _, serr := handshake(t, ca.ServerConfig(srv, true), noCert); serr == nil {
+		t.Fatal("server accepted a client without certificate")
+	}
+	if cerr, serr := handshake(t, ca.ServerConfig(srv, false), noCert); cerr != nil || serr != nil {
+		t.Fatalf("one-way TLS: client %v, server %v", cerr, serr)
+	}
+}
+
+func TestCAInvalidCerts(t *testing.T) {
+	ca, err := NewCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _ := ca.Issue(CertOptions{Expired: true})
+	future, _ := ca.Issue(CertOptions{NotYetValid: true})
+	self, err := SelfSigned(CertOptions{DNSNames: []string{"example.test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if self.Leaf.Subject.CommonName != "example.test" || len(self.TLS.Certificate) != 1 {
+		t.Fatalf("self-signed cert = %v, chain %d", self.Leaf.Subject, len(self.TLS.Certificate))
+	}
+
+	for name, tt := range map[string]struct {
+		cert       *Cert
+		serverName string
+	}{
+		"expired":       {expired, "localhost"},
+		"not yet valid": {future, "localhost"},
+		"self-signed":   {self, "example.test"},
+	} {
+		cfg := ca.ClientConfig(nil)
+		cfg.ServerName = tt.serverName
+		cerr, _ := handshake(t, ca.ServerConfig(tt.cert, false), cfg)
+		var invalid x509.CertificateInvalidError
+		var unknown x509.UnknownAuthorityError
+		var verr *tls.CertificateVerificationError
+		if !errors.As(cerr, &verr) || !(errors.As(cerr, &invalid) || errors.As(cerr, &unknown)) {
+			t.Errorf("%s: client error = %v", name, cerr)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package testing
+
+/*
+	故障编排: 在测试的指定时间点注入故障 (关闭上游、暂停监听、延迟突增), 负载结束后在恢复窗口内校验不变量.
+	ChaosProxy 作为被测客户端与上游之间的 TCP 代理, 提供这些故障的开关
+*/
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRecoveryTimeout 校验不变量时等待系统恢复的默认时间
+const DefaultRecoveryTimeout = 5 * time.Second
+
+type chaosStep struct {
+	at   time.Duration
+	name string
+	fn   func()
+}
+
+type chaosInvariant struct {
+	name  string
+	check func() error
+}
+
+// ChaosController 故障编排器, 通过 TestSuite.Chaos 创建
+type ChaosController struct {
+	suite *TestSuite
+	// RecoveryTimeout 负载结束后等待不变量成立的时间
+	RecoveryTimeout time.Duration
+
+	steps      []chaosStep
+	invariants []chaosInvariant
+}
+
+// At 在 Run 开始后 d 时执行 fn
+func (c *ChaosController) At(d time.Duration, name string, fn func()) *ChaosController {
+	c.steps = append(c.steps, chaosStep{at: d, name: name, fn: fn})
+	return c
+}
+
+// During 在 [start, end) 期间维持一个故障: start 时调用 begin, end 时调用 stop
+func (c *ChaosController) During(start, end time.Duration, name string, begin, stop func()) *ChaosController {
+	c.At(start, name+" begin", begin)
+	return c.At(end, name+" end", stop)
+}
+
+// Invariant 注册负载结束后须 (最终) 成立的不变量, check 返回 nil 表示成立
+func (c *ChaosController) Invariant(name string, check func() error) *ChaosController {
+	c.invariants = append(c.invariants, chaosInvariant{name: name, check: check})
+	return c
+}
+
+// Run 按计划注入故障的同时执行 workload, 两者都结束后校验不变量; 返回 workload 的错误
+func (c *ChaosController) Run(workload func(ctx context.Context) error) error {
+	h := c.suite.Helper
+	h.Helper()
+	ctx, cancel := context.WithCancel(c.suite.Context())
+	defer cancel()
+
+	steps := slices.Clone(c.steps)
+	slices.SortStableFunc(steps, func(a, b chaosStep) int { return int(a.at - b.at) })
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		start := time.Now()
+		for _, st := range steps {
+			select {
+			case <-time.After(time.Until(start.Add(st.at))):
+			case <-ctx.Done():
+				return
+			}
+			h.Logf("chaos: %v %s", time.Since(start).Round(time.Millisecond), st.name)
+			st.fn()
+		}
+	}()
+
+	err := workload(ctx)
+	<-done
+
+	for _, inv := range c.invariants {
+		var last error
+		ok := h.AssertEventually(func() bool {
+			last = inv.check()
+			return last == nil
+		}, c.RecoveryTimeout, 50*time.Millisecond, "invariant %q", inv.name)
+		if !ok {
+			h.Logf("invariant %q: %v", inv.name, last)
+		}
+	}
+	return err
+}
+
+// ErrChaosKilled ChaosProxy 处于关闭状态
+var ErrChaosKilled = errors.New("testing: chaos proxy killed")
+
+// ChaosProxy 可注入故障的 TCP 代理, 客户端连接 Addr, 流量转发到上游
+type ChaosProxy struct {
+	// Addr 代理的监听地址, Kill/Restore 前后保持不变
+	Addr string
+
+	target  string
+	latency atomic.Int64
+
+	mu      sync.Mutex
+	ln      net.Listener
+	paused  bool
+	resume  chan struct{}
+	conns   map[net.Conn]struct{}
+	closed  bool
+	serving sync.WaitGroup
+}
+
+// NewChaosProxy 在本地回环地址上创建转发到 target 的代理, tb 结束时自动关闭
+func (s *TestSuite) NewChaosProxy(target string) *ChaosProxy {
+	s.Helper.Helper()
+	p, err := NewChaosProxy(target)
+	if err != nil {
+		s.Fatalf("chaos proxy: %v", err)
+	}
+	s.Cleanup(p.Close)
+	return p
+}
+
+// NewChaosProxy 创建转发到 target 的代理
+func NewChaosProxy(target string) (*ChaosProxy, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &ChaosProxy{Addr: ln.Addr().String(), target: target, conns: make(map[net.Conn]struct{})}
+	p.start(ln)
+	return p, nil
+}
+
+func (p *ChaosProxy) start(ln net.Listener) {
+	p.ln = ln
+	p.serving.Add(1)
+	go p.acceptLoop(ln)
+}
+
+func (p *ChaosProxy) acceptLoop(ln net.Listener) {
+	defer p.serving.Done()
+	for {
+		p.mu.Lock()
+		resume := p.resume
+		p.mu.Unlock()
+		if resume != nil {
+			// 暂停期间不再 Accept, 新连接停留在内核的等待队列中
+			<-resume
+		}
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		p.mu.Lock()
+		p.conns[c] = struct{}{}
+		// Pause 之前已阻塞在 Accept 中, 连接在恢复前不转发
+		resume = p.resume
+		p.mu.Unlock()
+		go p.forward(c, resume)
+	}
+}
+
+func (p *ChaosProxy) forward(client net.Conn, resume chan struct{}) {
+	defer p.drop(client)
+	if resume != nil {
+		<-resume
+	}
+	upstream, err := net.Dial("tcp", p.target)
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	p.conns[upstream] = struct{}{}
+	p.mu.Unlock()
+	defer p.drop(upstream)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); p.pipe(upstream, client) }()
+	go func() { defer wg.Done(); p.pipe(client, upstream) }()
+	wg.Wait()
+}
+
+// pipe 逐块转发, 每块按当前设置延迟; 一端结束即关闭双方
+func (p *ChaosProxy) pipe(dst, src net.Conn) {
+	defer dst.Close()
+	defer src.Close()
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if d := time.Duration(p.latency.Load()); d > 0 {
+				time.Sleep(d)
+			}
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *ChaosProxy) drop(c net.Conn) {
+	c.Close()
+	p.mu.Lock()
+	delete(p.conns, c)
+	p.mu.Unlock()
+}
+
+// SetLatency 为之后转发的每块数据增加延迟, 0 表示恢复
+func (p *ChaosProxy) SetLatency(d time.Duration) { p.latency.Store(int64(d)) }
+
+// Pause 停止接受新连接, 已接受的新连接在恢复前不转发; 已有连接不受影响
+func (p *ChaosProxy) Pause() {
+	p.mu.Lock()
+	if p.resume == nil {
+		p.resume = make(chan struct{})
+	}
+	p.mu.Unlock()
+}
+
+// Resume 恢复接受连接
+func (p *ChaosProxy) Resume() {
+	p.mu.Lock()
+	if p.resume != nil {
+		close(p.resume)
+		p.resume = nil
+	}
+	p.mu.Unlock()
+}
+
+// Kill 关闭监听和所有连接, 模拟上游宕机, 之后的连接被拒绝
+func (p *ChaosProxy) Kill() {
+	p.mu.Lock()
+	if p.ln != nil {
+		p.ln.Close()
+		p.ln = nil
+	}
+	for c := range p.conns {
+		c.Close()
+	}
+	p.mu.Unlock()
+}
+
+// Restore 在原地址上重新监听, 撤销 Kill
+func (p *ChaosProxy) Restore() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrChaosKilled
+	}
+	if p.ln != nil {
+		return nil
+	}
+	ln, err := net.Listen("tcp", p.Addr)
+	if err != nil {
+		return err
+	}
+	p.start(ln)
+	return nil
+}
+
+// Close 永久关闭代理
+func (p *ChaosProxy) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.Resume()
+	p.Kill()
+	p.serving.Wait()
+}
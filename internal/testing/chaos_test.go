@@ -0,0 +1,218 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// echoServer 启动回显服务器, 返回其地址
+func echoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// echoOnce 经 c 发送 msg 并读取回显
+func echoOnce(c net.Conn, msg string) error {
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.WriteString(c, msg); err != nil {
+		return err
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return err
+	}
+	if string(buf) != msg {
+		return errors.New("echo mismatch: " + string(buf))
+	}
+	return nil
+}
+
+func dialEcho(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestChaosProxyKillRestore(t *testing.T) {
+	p, err := NewChaosProxy(echoServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	c := dialEcho(t, p.Addr)
+	if err := echoOnce(c, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	p.Kill()
+	if err := echoOnce(c, "ping"); err == nil {
+		t.Fatal("connection survived Kill")
+	}
+	if _, err := net.DialTimeout("tcp", p.Addr, time.Second); err == nil {
+		t.Fatal("dial succeeded while killed")
+	}
+	if err := p.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if err := echoOnce(dialEcho(t, p.Addr), "again"); err != nil {
+		t.Fatalf("after Restore: %v", err)
+	}
+
+	p.Close()
+	if err := p.Restore(); !errors.Is(err, ErrChaosKilled) {
+		t.Fatalf("Restore after Close = %v", err)
+	}
+}
+
+func TestChaosProxyPauseAndLatency(t *testing.T) {
+	p, err := NewChaosProxy(echoServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	existing := dialEcho(t, p.Addr)
+	if err := echoOnce(existing, "a"); err != nil {
+		t.Fatal(err)
+	}
+	p.Pause()
+	// 已有连接不受影响
+	if err := echoOnce(existing, "b"); err != nil {
+		t.Fatalf("existing connection while paused: %v", err)
+	}
+	paused := dialEcho(t, p.Addr)
+	io.WriteString(paused, "c")
+	paused.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, _ := paused.Read(make([]byte, 1)); n != 0 {
+		t.Fatal("new connection forwarded while paused")
+	}
+	p.Resume()
+	paused.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(paused, make([]byte, 1)); err != nil {
+		t.Fatalf("paused connection after Resume: %v", err)
+	}
+
+	p.SetLatency(50 * time.Millisecond)
+	start := time.Now()
+	if err := echoOnce(existing, "d"); err != nil {
+		t.Fatal(err)
+	}
+	// 往返两个方向各延迟一次
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("round trip with latency took %v", d)
+	}
+}
+
+func TestChaosControllerRun(t *testing.T) {
+	s := NewTestSuite(t)
+	p := s.NewChaosProxy(echoServer(t))
+
+	var mu sync.Mutex
+	var events []string
+	logStep := func(name string) func() {
+		return func() {
+			mu.Lock()
+			events = append(events, name)
+			mu.Unlock()
+		}
+	}
+	var failures, successes int
+	errWorkload := errors.New("workload done")
+	err := s.Chaos().
+		During(60*time.Millisecond, 120*time.Millisecond, "outage",
+			func() { logStep("kill")(); p.Kill() },
+			func() { logStep("restore")(); p.Restore() }).
+		At(10*time.Millisecond, "first", logStep("first")).
+		Invariant("proxy serves", func() error {
+			c, err := net.DialTimeout("tcp", p.Addr, time.Second)
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			return echoOnce(c, "ok")
+		}).
+		Run(func(ctx context.Context) error {
+			deadline := time.Now().Add(180 * time.Millisecond)
+			for time.Now().Before(deadline) {
+				c, err := net.DialTimeout("tcp", p.Addr, time.Second)
+				if err == nil {
+					err = echoOnce(c, "x")
+					c.Close()
+				}
+				if err != nil {
+					failures++
+				} else {
+					successes++
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			return errWorkload
+		})
+	if !errors.Is(err, errWorkload) {
+		t.Fatalf("Run = %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(events, []string{"first", "kill", "restore"}) {
+		t.Fatalf("steps ran as %v", events)
+	}
+	if failures == 0 || successes == 0 {
+		t.Fatalf("failures=%d successes=%d; outage not observed", failures, successes)
+	}
+}
+
+func TestChaosControllerInvariantFailure(t *testing.T) {
+	tb := runFake(t, func(tb *fakeTB) {
+		c := NewTestSuite(tb).Chaos()
+		c.RecoveryTimeout = 50 * time.Millisecond
+		c.Invariant("never", func() error { return errors.New("still broken") }).
+			Run(func(context.Context) error { return nil })
+	})
+	if !tb.Failed() || !strings.Contains(tb.output(), `invariant "never"`) {
+		t.Fatalf("failed invariant not reported: %s", tb.output())
+	}
+}
+
+func TestTestSuiteMockServer(t *testing.T) {
+	s := NewTestSuite(t)
+	srv := s.MockServer()
+	srv.Handle("GET", "/", &MockResponse{Body: []byte("ok")})
+	c := dialEcho(t, srv.Addr)
+	io.WriteString(c, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 12)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "HTTP/1.1 200" {
+		t.Fatalf("suite mock server: %q, %v", buf, err)
+	}
+	if s.Context().Err() != nil {
+		t.Fatal("suite context canceled early")
+	}
+}
@@ -0,0 +1,206 @@
+package testing
+
+/*
+	可控时钟: 实现 utils.Clock, 时间只在调用 Advance/Set 时前进, 到期的定时器随之触发.
+	BlockUntil 让测试等待被测代码进入等待状态后再推进时间, 避免依赖真实时间的竞态
+*/
+
+import (
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// MockClock 手动推进的时钟, 并发安全
+type MockClock struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	now    time.Time
+	timers map[*mockTimer]struct{}
+}
+
+var _ utils.Clock = (*MockClock)(nil)
+
+// NewMockClock 创建从 start 开始的时钟, start 为零值时使用固定的 2000-01-01 UTC
+func NewMockClock(start time.Time) *MockClock {
+	if start.IsZero() {
+		start = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	c := &MockClock{now: start, timers: make(map[*mockTimer]struct{})}
+	c.cond.L = &c.mu
+	return c
+}
+
+// Now 返回当前模拟时间
+func (c *MockClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance 将时间推进 d, 并按到期先后触发期间到期的定时器和 ticker
+func (c *MockClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		t := c.nextDue(end)
+		if t == nil {
+			break
+		}
+		c.now = t.when
+		t.fire()
+	}
+	c.now = end
+	c.mu.Unlock()
+}
+
+// Set 将时间设为 t. t 晚于当前时间时同 Advance; 早于当前时间时只回拨时间, 不触发定时器
+func (c *MockClock) Set(t time.Time) {
+	if d := t.Sub(c.Now()); d > 0 {
+		c.Advance(d)
+		return
+	}
+	c.mu.Lock()
+	c.now = t
+	c.mu.Unlock()
+}
+
+// AdvanceToNext 将时间推进到最早的定时器到期并触发它, 没有定时器时返回 false
+func (c *MockClock) AdvanceToNext() bool {
+	c.mu.Lock()
+	var next *mockTimer
+	for t := range c.timers {
+		if next == nil || t.when.Before(next.when) {
+			next = t
+		}
+	}
+	if next == nil {
+		c.mu.Unlock()
+		return false
+	}
+	d := next.when.Sub(c.now)
+	c.mu.Unlock()
+	c.Advance(max(d, 0))
+	return true
+}
+
+// Timers 返回未触发的定时器和 ticker 数量, 包括 Sleep 中的调用方
+func (c *MockClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// BlockUntil 阻塞直到至少有 n 个未触发的定时器 (含 Sleep 中的调用方),
+// 用于确认被测 goroutine 已开始等待后再调用 Advance
+func (c *MockClock) BlockUntil(n int) {
+	c.mu.Lock()
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+	c.mu.Unlock()
+}
+
+// nextDue 返回 end 之前最早到期的定时器
+func (c *MockClock) nextDue(end time.Time) *mockTimer {
+	var next *mockTimer
+	for t := range c.timers {
+		if !t.when.After(end) && (next == nil || t.when.Before(next.when)) {
+			next = t
+		}
+	}
+	return next
+}
+
+// Sleep 阻塞直到其他 goroutine 将时间推进 d
+func (c *MockClock) Sleep(d time.Duration) {
+	<-c.NewTimer(d).C()
+}
+
+// NewTimer 创建在模拟时间 d 之后触发的定时器
+func (c *MockClock) NewTimer(d time.Duration) utils.Timer {
+	t := &mockTimer{c: c, ch: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// NewTicker 创建每隔模拟时间 d 触发一次的 ticker, 接收方来不及读取时丢弃触发
+func (c *MockClock) NewTicker(d time.Duration) utils.Ticker {
+	if d <= 0 {
+		panic("testing: non-positive interval for NewTicker")
+	}
+	t := &mockTimer{c: c, ch: make(chan time.Time, 1), period: d}
+	t.Reset(d)
+	return mockTicker{t}
+}
+
+// mockTimer period > 0 时为 ticker
+type mockTimer struct {
+	c      *MockClock
+	ch     chan time.Time
+	when   time.Time
+	period time.Duration
+}
+
+// fire 持有 c.mu 时调用
+func (t *mockTimer) fire() {
+	select {
+	case t.ch <- t.when:
+	default:
+	}
+	if t.period > 0 {
+		t.when = t.when.Add(t.period)
+	} else {
+		delete(t.c.timers, t)
+	}
+}
+
+func (t *mockTimer) C() <-chan time.Time { return t.ch }
+
+func (t *mockTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	_, active := t.c.timers[t]
+	delete(t.c.timers, t)
+	return active
+}
+
+func (t *mockTimer) Reset(d time.Duration) bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	return t.reset(d)
+}
+
+// reset 持有 c.mu 时调用
+func (t *mockTimer) reset(d time.Duration) bool {
+	_, active := t.c.timers[t]
+	t.when = t.c.now.Add(d)
+	if d <= 0 && t.period == 0 {
+		// 与 time.Timer 一致, 非正时长立即触发
+		delete(t.c.timers, t)
+		select {
+		case t.ch <- t.when:
+		default:
+		}
+		return active
+	}
+	t.c.timers[t] = struct{}{}
+	t.c.cond.Broadcast()
+	return active
+}
+
+type mockTicker struct{ t *mockTimer }
+
+func (k mockTicker) C() <-chan time.Time { return k.t.ch }
+func (k mockTicker) Stop()               { k.t.Stop() }
+
+func (k mockTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("testing: non-positive interval for Ticker.Reset")
+	}
+	k.t.c.mu.Lock()
+	defer k.t.c.mu.Unlock()
+	k.t.period = d
+	k.t.reset(d)
+}
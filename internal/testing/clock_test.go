@@ -0,0 +1,126 @@
+package testing
+
+import (
+	"testing"
+	"time"
+)
+
+func recv(t *testing.T, ch <-chan time.Time) (time.Time, bool) {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestMockClockTimers(t *testing.T) {
+	c := NewMockClock(time.Time{})
+	start := c.Now()
+	if start.Year() != 2000 {
+		t.Fatalf("default start = %v", start)
+	}
+	t1 := c.NewTimer(time.Second)
+	t2 := c.NewTimer(3 * time.Second)
+	if c.Timers() != 2 {
+		t.Fatalf("Timers = %d", c.Timers())
+	}
+
+	c.Advance(999 * time.Millisecond)
+	if _, ok := recv(t, t1.C()); ok {
+		t.Fatal("timer fired early")
+	}
+	c.Advance(time.Millisecond)
+	if v, ok := recv(t, t1.C()); !ok || !v.Equal(start.Add(time.Second)) {
+		t.Fatalf("timer fired at %v, %v", v, ok)
+	}
+	if t1.Stop() {
+		t.Fatal("Stop on a fired timer reported active")
+	}
+	if !t2.Reset(time.Second) {
+		t.Fatal("Reset on a pending timer reported inactive")
+	}
+	if !c.AdvanceToNext() || !c.Now().Equal(start.Add(2*time.Second)) {
+		t.Fatalf("AdvanceToNext moved to %v", c.Now())
+	}
+	if _, ok := recv(t, t2.C()); !ok {
+		t.Fatal("reset timer did not fire")
+	}
+	if c.AdvanceToNext() {
+		t.Fatal("AdvanceToNext with no timers")
+	}
+
+	// 非正时长立即触发
+	if _, ok := recv(t, c.NewTimer(0).C()); !ok {
+		t.Fatal("zero timer did not fire immediately")
+	}
+
+	// 回拨时间不触发定时器
+	t3 := c.NewTimer(time.Second)
+	c.Set(start)
+	if _, ok := recv(t, t3.C()); ok || !c.Now().Equal(start) {
+		t.Fatal("Set backwards fired a timer")
+	}
+	c.Set(start.Add(10 * time.Second))
+	if _, ok := recv(t, t3.C()); !ok {
+		t.Fatal("Set forwards did not fire the timer")
+	}
+}
+
+func TestMockClockTicker(t *testing.T) {
+	c := NewMockClock(time.Time{})
+	start := c.Now()
+	tk := c.NewTicker(time.Second)
+	c.Advance(time.Second)
+	if v, ok := recv(t, tk.C()); !ok || !v.Equal(start.Add(time.Second)) {
+		t.Fatalf("first tick = %v, %v", v, ok)
+	}
+	// 接收方未读取时多余的触发被丢弃
+	c.Advance(5 * time.Second)
+	if v, ok := recv(t, tk.C()); !ok || !v.Equal(start.Add(2*time.Second)) {
+		t.Fatalf("tick after advance = %v, %v", v, ok)
+	}
+	if _, ok := recv(t, tk.C()); ok {
+		t.Fatal("ticker buffered more than one tick")
+	}
+
+	tk.Reset(10 * time.Second)
+	c.Advance(9 * time.Second)
+	if _, ok := recv(t, tk.C()); ok {
+		t.Fatal("ticker fired before the new period")
+	}
+	c.Advance(time.Second)
+	if _, ok := recv(t, tk.C()); !ok {
+		t.Fatal("ticker did not fire after Reset")
+	}
+	tk.Stop()
+	if c.Timers() != 0 {
+		t.Fatalf("Timers after Stop = %d", c.Timers())
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("NewTicker(0) did not panic")
+		}
+	}()
+	c.NewTicker(0)
+}
+
+func TestMockClockSleep(t *testing.T) {
+	c := NewMockClock(time.Time{})
+	woke := make(chan time.Time)
+	go func() {
+		c.Sleep(time.Minute)
+		woke <- c.Now()
+	}()
+	c.BlockUntil(1)
+	c.Advance(time.Minute)
+	select {
+	case now := <-woke:
+		if now.Sub(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)) != time.Minute {
+			t.Fatalf("woke at %v", now)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sleep did not return after Advance")
+	}
+}
@@ -0,0 +1,198 @@
+package testing
+
+/*
+	HTTP 消息结构化比较: 逐字段比较请求或响应, 头部不区分出现顺序, 可忽略指定字段,
+	用于代理透传和缓存正确性测试
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+// DiffOptions 比较选项, nil 表示全部比较
+type DiffOptions struct {
+	// IgnoreHeaders 不比较的头部, 如 Date、Via、Age
+	IgnoreHeaders []string
+	// IgnoreBody 不比较消息体
+	IgnoreBody bool
+	// IgnoreProto 不比较协议版本
+	IgnoreProto bool
+	// UnorderedValues 同名头部的多个值也不区分顺序
+	UnorderedValues bool
+}
+
+func (o *DiffOptions) ignored(key string) bool {
+	if o == nil {
+		return false
+	}
+	for _, k := range o.IgnoreHeaders {
+		if common.CanonicalHeaderKey(k) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// MessageDiff 一个字段的差异
+type MessageDiff struct {
+	// Field 字段名, 如 "Status"、"Header Content-Type"、"Body"
+	Field string
+	Want  string
+	Got   string
+}
+
+func (d MessageDiff) String() string {
+	if d.Field == "Body" {
+		return "Body (-want +got):\n" + LineDiff(d.Want, d.Got)
+	}
+	return fmt.Sprintf("%s: want %s, got %s", d.Field, d.Want, d.Got)
+}
+
+// FormatDiffs 将差异格式化为多行文本
+func FormatDiffs(diffs []MessageDiff) string {
+	lines := make([]string, len(diffs))
+	for i, d := range diffs {
+		lines[i] = d.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// DiffRequests 比较两个请求, 消息体被读出后以内存副本放回
+func DiffRequests(want, got *message.Request, opts *DiffOptions) ([]MessageDiff, error) {
+	var diffs []MessageDiff
+	add := func(field string, w, g any) {
+		if ws, gs := fmt.Sprint(w), fmt.Sprint(g); ws != gs {
+			diffs = append(diffs, MessageDiff{Field: field, Want: ws, Got: gs})
+		}
+	}
+	add("Method", want.Method, got.Method)
+	add("Target", want.RequestURI(), got.RequestURI())
+	add("Host", want.HostHeader(), got.HostHeader())
+	if opts == nil || !opts.IgnoreProto {
+		add("Proto", want.Proto, got.Proto)
+	}
+	diffs = append(diffs, diffHeader("Header", want.Header, got.Header, opts)...)
+	diffs = append(diffs, diffHeader("Trailer", want.Trailer, got.Trailer, opts)...)
+	if opts == nil || !opts.IgnoreBody {
+		d, err := diffBody(&want.Body, &got.Body)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, d...)
+	}
+	return diffs, nil
+}
+
+// DiffResponses 比较两个响应, 消息体被读出后以内存副本放回
+func DiffResponses(want, got *message.Response, opts *DiffOptions) ([]MessageDiff, error) {
+	var diffs []MessageDiff
+	if want.StatusCode != got.StatusCode {
+		diffs = append(diffs, MessageDiff{Field: "Status", Want: fmt.Sprint(want.StatusCode), Got: fmt.Sprint(got.StatusCode)})
+	}
+	if (opts == nil || !opts.IgnoreProto) && want.Proto != got.Proto {
+		diffs = append(diffs, MessageDiff{Field: "Proto", Want: want.Proto, Got: got.Proto})
+	}
+	diffs = append(diffs, diffHeader("Header", want.Header, got.Header, opts)...)
+	diffs = append(diffs, diffHeader("Trailer", want.Trailer, got.Trailer, opts)...)
+	if opts == nil || !opts.IgnoreBody {
+		d, err := diffBody(&want.Body, &got.Body)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, d...)
+	}
+	return diffs, nil
+}
+
+// AssertResponsesEqual 比较两个响应, 有差异时使测试失败
+func AssertResponsesEqual(tb testing.TB, want, got *message.Response, opts *DiffOptions) {
+	tb.Helper()
+	diffs, err := DiffResponses(want, got, opts)
+	if err != nil {
+		tb.Fatalf("diff responses: %v", err)
+	}
+	if len(diffs) > 0 {
+		tb.Errorf("responses differ:\n%s", FormatDiffs(diffs))
+	}
+}
+
+// AssertRequestsEqual 比较两个请求, 有差异时使测试失败
+func AssertRequestsEqual(tb testing.TB, want, got *message.Request, opts *DiffOptions) {
+	tb.Helper()
+	diffs, err := DiffRequests(want, got, opts)
+	if err != nil {
+		tb.Fatalf("diff requests: %v", err)
+	}
+	if len(diffs) > 0 {
+		tb.Errorf("requests differ:\n%s", FormatDiffs(diffs))
+	}
+}
+
+func diffHeader(field string, want, got common.Header, opts *DiffOptions) []MessageDiff {
+	keys := make(map[string]bool)
+	for k := range want {
+		keys[k] = true
+	}
+	for k := range got {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		if !opts.ignored(k) {
+			sorted = append(sorted, k)
+		}
+	}
+	slices.Sort(sorted)
+	var diffs []MessageDiff
+	for _, k := range sorted {
+		w, g := want[k], got[k]
+		if opts != nil && opts.UnorderedValues {
+			w, g = slices.Sorted(slices.Values(w)), slices.Sorted(slices.Values(g))
+		}
+		if !slices.Equal(w, g) {
+			diffs = append(diffs, MessageDiff{Field: field + " " + k, Want: formatValues(w), Got: formatValues(g)})
+		}
+	}
+	return diffs
+}
+
+func formatValues(vs []string) string {
+	if vs == nil {
+		return "<absent>"
+	}
+	return fmt.Sprintf("%q", vs)
+}
+
+func diffBody(want, got *io.ReadCloser) ([]MessageDiff, error) {
+	w, err := readBody(want)
+	if err != nil {
+		return nil, err
+	}
+	g, err := readBody(got)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(w, g) {
+		return nil, nil
+	}
+	return []MessageDiff{{Field: "Body", Want: string(w), Got: string(g)}}, nil
+}
+
+// readBody 读出 *body 并放回内存副本
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == message.NoBody {
+		return nil, nil
+	}
+	data, err := io.ReadAll(*body)
+	(*body).Close()
+	*body = io.NopCloser(bytes.NewReader(data))
+	return data, err
+}
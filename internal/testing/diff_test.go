@@ -0,0 +1,120 @@
+package testing
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+)
+
+func newDiffResponse(status int, body string, header ...string) *message.Response {
+	resp := message.NewResponse(status)
+	for i := 0; i+1 < len(header); i += 2 {
+		resp.Header.Add(header[i], header[i+1])
+	}
+	resp.Body = io.NopCloser(strings.NewReader(body))
+	return resp
+}
+
+func TestDiffResponses(t *testing.T) {
+	want := newDiffResponse(200, "a\nb\n", "Content-Type", "text/plain", "Date", "x", "Vary", "Accept", "Vary", "Cookie")
+	got := newDiffResponse(201, "a\nc\n", "Content-Type", "text/html", "Date", "y", "Vary", "Cookie", "Vary", "Accept", "X-Extra", "1")
+	diffs, err := DiffResponses(want, got, &DiffOptions{IgnoreHeaders: []string{"date"}, UnorderedValues: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields []string
+	for _, d := range diffs {
+		fields = append(fields, d.Field)
+	}
+	if strings.Join(fields, ",") != "Status,Header Content-Type,Header X-Extra,Body" {
+		t.Fatalf("diff fields = %v", fields)
+	}
+	text := FormatDiffs(diffs)
+	for _, s := range []string{`Header X-Extra: want <absent>, got ["1"]`, "Status: want 200, got 201", "- b\n+ c"} {
+		if !strings.Contains(text, s) {
+			t.Errorf("FormatDiffs missing %q:\n%s", s, text)
+		}
+	}
+
+	// 消息体被放回, 可以再次读取
+	if b, _ := io.ReadAll(want.Body); string(b) != "a\nb\n" {
+		t.Fatalf("want body after diff = %q", b)
+	}
+
+	// 不忽略时同名头部值的顺序和 Date 都算差异
+	want = newDiffResponse(200, "", "Vary", "Accept", "Vary", "Cookie")
+	got = newDiffResponse(200, "", "Vary", "Cookie", "Vary", "Accept")
+	if diffs, _ := DiffResponses(want, got, nil); len(diffs) != 1 || diffs[0].Field != "Header Vary" {
+		t.Fatalf("ordered values diff = %v", diffs)
+	}
+}
+
+func TestDiffRequests(t *testing.T) {
+	newReq := func(method, url, body string) *message.Request {
+		var r io.Reader
+		if body != "" {
+			r = strings.NewReader(body)
+		}
+		req, err := message.NewRequest(method, url, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	a := newReq("POST", "http://example.com/a?x=1", "body")
+	b := newReq("POST", "http://example.com/a?x=1", "body")
+	if diffs, err := DiffRequests(a, b, nil); err != nil || len(diffs) != 0 {
+		t.Fatalf("equal requests differ: %v, %v", diffs, err)
+	}
+	b = newReq("PUT", "http://other.example/b", "other")
+	b.Proto = "HTTP/1.0"
+	diffs, err := DiffRequests(a, b, &DiffOptions{IgnoreBody: true, IgnoreProto: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields []string
+	for _, d := range diffs {
+		fields = append(fields, d.Field)
+	}
+	if strings.Join(fields, ",") != "Method,Target,Host" {
+		t.Fatalf("diff fields = %v", fields)
+	}
+}
+
+type errBody struct{}
+
+func (errBody) Read([]byte) (int, error) { return 0, errors.New("read failed") }
+func (errBody) Close() error             { return nil }
+
+func TestAssertMessagesEqual(t *testing.T) {
+	tb := runFake(t, func(tb *fakeTB) {
+		AssertResponsesEqual(tb, newDiffResponse(200, "x"), newDiffResponse(200, "x"), nil)
+	})
+	if tb.Failed() {
+		t.Fatalf("equal responses: %s", tb.output())
+	}
+	tb = runFake(t, func(tb *fakeTB) {
+		AssertResponsesEqual(tb, newDiffResponse(200, "x"), newDiffResponse(404, "x"), nil)
+	})
+	if !tb.Failed() || tb.fatal || !strings.Contains(tb.output(), "responses differ:\nStatus") {
+		t.Fatalf("different responses: %s", tb.output())
+	}
+	tb = runFake(t, func(tb *fakeTB) {
+		bad := newDiffResponse(200, "")
+		bad.Body = errBody{}
+		AssertResponsesEqual(tb, newDiffResponse(200, "x"), bad, nil)
+	})
+	if !tb.fatal || !strings.Contains(tb.output(), "read failed") {
+		t.Fatalf("body read error: %s", tb.output())
+	}
+
+	req, _ := message.NewRequest("GET", "http://example.com/", nil)
+	other, _ := message.NewRequest("GET", "http://example.com/other", nil)
+	tb = runFake(t, func(tb *fakeTB) { AssertRequestsEqual(tb, req, other, nil) })
+	if !strings.Contains(tb.output(), "requests differ:\nTarget: want /, got /other") {
+		t.Fatalf("different requests: %s", tb.output())
+	}
+}
@@ -0,0 +1,323 @@
+package testing
+
+/*
+	模拟 DNS 解析: 进程内的 DNS 服务端按编程设定应答 A/AAAA/CNAME (带 TTL), 也可模拟 NXDOMAIN、SERVFAIL、超时和延迟.
+	通过 Resolver 返回的 *net.Resolver 注入 tcp.Dialer 等组件, 测试双栈回退和 DNS 缓存时无需真实网络
+*/
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSFailure 模拟的解析失败
+type DNSFailure int
+
+const (
+	// DNSOK 正常应答
+	DNSOK DNSFailure = iota
+	// DNSNXDomain 域名不存在
+	DNSNXDomain
+	// DNSServFail 服务端错误
+	DNSServFail
+	// DNSTimeout 不应答, 直到调用方的 ctx 或超时结束
+	DNSTimeout
+)
+
+// DNS 报文中用到的类型和应答码
+const (
+	dnsTypeA     = 1
+	dnsTypeCNAME = 5
+	dnsTypeAAAA  = 28
+	dnsClassINET = 1
+
+	dnsRcodeSuccess  = 0
+	dnsRcodeServFail = 2
+	dnsRcodeNXDomain = 3
+)
+
+type dnsEntry struct {
+	v4      []net.IP
+	v6      []net.IP
+	cname   string
+	ttl     uint32
+	failure DNSFailure
+	delay   time.Duration
+}
+
+// MockResolver 可编程的 DNS 解析器, 并发安全. 未设置的域名应答 NXDOMAIN
+type MockResolver struct {
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+	queries map[string]int
+	closed  chan struct{}
+}
+
+// NewMockResolver 创建模拟解析器
+func NewMockResolver() *MockResolver {
+	return &MockResolver{
+		entries: make(map[string]*dnsEntry),
+		queries: make(map[string]int),
+		closed:  make(chan struct{}),
+	}
+}
+
+// dnsKey 规范化为小写且以 "." 结尾的域名
+func dnsKey(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+func (r *MockResolver) entry(name string) *dnsEntry {
+	k := dnsKey(name)
+	e := r.entries[k]
+	if e == nil {
+		e = &dnsEntry{ttl: 60}
+		r.entries[k] = e
+	}
+	return e
+}
+
+// SetHost 设置 name 的地址, 按地址族分别作为 A 和 AAAA 记录应答; 清除此前的 CNAME 和失败设置
+func (r *MockResolver) SetHost(name string, ttl time.Duration, addrs ...string) error {
+	var v4, v6 []net.IP
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			return errors.New("testing: invalid IP " + a)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			v4 = append(v4, ip4)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.entry(name)
+	e.v4, e.v6, e.cname, e.failure = v4, v6, "", DNSOK
+	e.ttl = uint32(ttl / time.Second)
+	return nil
+}
+
+// SetCNAME 设置 name 为 target 的别名
+func (r *MockResolver) SetCNAME(name, target string, ttl time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.entry(name)
+	e.v4, e.v6, e.cname, e.failure = nil, nil, dnsKey(target), DNSOK
+	e.ttl = uint32(ttl / time.Second)
+}
+
+// Fail 让 name 的查询以 f 失败
+func (r *MockResolver) Fail(name string, f DNSFailure) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entry(name).failure = f
+}
+
+// Delay 让 name 的应答延迟 d
+func (r *MockResolver) Delay(name string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entry(name).delay = d
+}
+
+// Remove 删除 name 的所有设置
+func (r *MockResolver) Remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, dnsKey(name))
+}
+
+// Queries 返回 name 被查询的次数 (A 和 AAAA 分别计数), 用于验证缓存
+func (r *MockResolver) Queries(name string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.queries[dnsKey(name)]
+}
+
+// Close 结束所有挂起的应答
+func (r *MockResolver) Close() {
+	select {
+	case <-r.closed:
+	default:
+		close(r.closed)
+	}
+}
+
+// Resolver 返回将所有查询发往该模拟解析器的 *net.Resolver.
+// 注意 /etc/hosts 中的名字 (如 localhost) 仍由 hosts 文件解析
+func (r *MockResolver) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo:     true,
+		StrictErrors: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go r.serve(server)
+			return client, nil
+		},
+	}
+}
+
+// serve 以 TCP 格式 (2 字节长度前缀) 处理一个连接上的查询, net.Pipe 不是 PacketConn, 解析器会使用该格式
+func (r *MockResolver) serve(c net.Conn) {
+	defer c.Close()
+	var lenBuf [2]byte
+	for {
+		if _, err := io.ReadFull(c, lenBuf[:]); err != nil {
+			return
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+		if _, err := io.ReadFull(c, msg); err != nil {
+			return
+		}
+		resp, delay, ok := r.answer(msg)
+		if !ok {
+			// 模拟超时: 等到调用方放弃
+			buf := make([]byte, 1)
+			c.Read(buf)
+			return
+		}
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-r.closed:
+				return
+			}
+		}
+		out := binary.BigEndian.AppendUint16(nil, uint16(len(resp)))
+		if _, err := c.Write(append(out, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+// answer 构造应答报文, ok 为 false 表示不应答
+func (r *MockResolver) answer(query []byte) (resp []byte, delay time.Duration, ok bool) {
+	if len(query) < 12 {
+		return nil, 0, false
+	}
+	name, off, err := readDNSName(query, 12)
+	if err != nil || off+4 > len(query) {
+		return nil, 0, false
+	}
+	qtype := binary.BigEndian.Uint16(query[off:])
+	question := query[12 : off+4]
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := dnsKey(name)
+	r.queries[key]++
+
+	var answers [][]byte
+	rcode := dnsRcodeSuccess
+	// 沿 CNAME 链解析, 限制长度防止环
+	for hops := 0; hops < 8; hops++ {
+		e := r.entries[key]
+		if e == nil {
+			rcode = dnsRcodeNXDomain
+			break
+		}
+		delay += e.delay
+		switch e.failure {
+		case DNSNXDomain:
+			rcode = dnsRcodeNXDomain
+		case DNSServFail:
+			rcode = dnsRcodeServFail
+		case DNSTimeout:
+			return nil, 0, false
+		}
+		if rcode != dnsRcodeSuccess {
+			break
+		}
+		if e.cname != "" {
+			answers = append(answers, dnsRR(key, dnsTypeCNAME, e.ttl, appendDNSName(nil, e.cname)))
+			if qtype == dnsTypeCNAME {
+				break
+			}
+			key = e.cname
+			continue
+		}
+		ips, typ := e.v4, uint16(dnsTypeA)
+		if qtype == dnsTypeAAAA {
+			ips, typ = e.v6, dnsTypeAAAA
+		}
+		if qtype == dnsTypeA || qtype == dnsTypeAAAA {
+			for _, ip := range ips {
+				answers = append(answers, dnsRR(key, typ, e.ttl, ip))
+			}
+		}
+		break
+	}
+
+	flags := uint16(0x8000 | 0x0400 | 0x0080)            // QR, AA, RA
+	flags |= binary.BigEndian.Uint16(query[2:]) & 0x7900 // 保留 opcode 和 RD
+	flags |= uint16(rcode)
+	resp = binary.BigEndian.AppendUint16(resp, binary.BigEndian.Uint16(query))
+	resp = binary.BigEndian.AppendUint16(resp, flags)
+	resp = binary.BigEndian.AppendUint16(resp, 1)
+	resp = binary.BigEndian.AppendUint16(resp, uint16(len(answers)))
+	resp = binary.BigEndian.AppendUint16(resp, 0)
+	resp = binary.BigEndian.AppendUint16(resp, 0)
+	resp = append(resp, question...)
+	for _, a := range answers {
+		resp = append(resp, a...)
+	}
+	return resp, delay, true
+}
+
+// dnsRR 编码一条资源记录, 名字不压缩
+func dnsRR(name string, typ uint16, ttl uint32, rdata []byte) []byte {
+	b := appendDNSName(nil, name)
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, dnsClassINET)
+	b = binary.BigEndian.AppendUint32(b, ttl)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(rdata)))
+	return append(b, rdata...)
+}
+
+func appendDNSName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// readDNSName 读取查询中的域名 (查询不使用压缩), 返回以 "." 结尾的名字和其后的偏移
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var sb strings.Builder
+	for {
+		if off >= len(msg) {
+			return "", 0, io.ErrUnexpectedEOF
+		}
+		n := int(msg[off])
+		off++
+		if n == 0 {
+			break
+		}
+		if n&0xc0 != 0 || off+n > len(msg) {
+			return "", 0, errors.New("testing: unsupported DNS name encoding")
+		}
+		sb.Write(msg[off : off+n])
+		sb.WriteByte('.')
+		off += n
+	}
+	if sb.Len() == 0 {
+		return ".", off, nil
+	}
+	return sb.String(), off, nil
+}
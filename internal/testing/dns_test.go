@@ -0,0 +1,119 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMockResolverLookup(t *testing.T) {
+	r := NewMockResolver()
+	defer r.Close()
+	if err := r.SetHost("api.test", time.Minute, "10.0.0.1", "10.0.0.2", "2001:db8::1"); err != nil {
+		t.Fatal(err)
+	}
+	r.SetCNAME("www.test", "API.test", time.Minute)
+	res := r.Resolver()
+	ctx := context.Background()
+
+	addrs, err := res.LookupHost(ctx, "api.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(addrs)
+	if want := []string{"10.0.0.1", "10.0.0.2", "2001:db8::1"}; !slices.Equal(addrs, want) {
+		t.Fatalf("LookupHost = %v, want %v", addrs, want)
+	}
+	// A 和 AAAA 各一次
+	if n := r.Queries("API.TEST"); n != 2 {
+		t.Fatalf("Queries = %d, want 2", n)
+	}
+
+	ips, err := res.LookupIP(ctx, "ip4", "www.test")
+	if err != nil || len(ips) != 2 {
+		t.Fatalf("LookupIP via CNAME = %v, %v", ips, err)
+	}
+	if cname, err := res.LookupCNAME(ctx, "www.test"); err != nil || cname != "api.test." {
+		t.Fatalf("LookupCNAME = %q, %v", cname, err)
+	}
+
+	if err := r.SetHost("bad.test", time.Minute, "not-an-ip"); err == nil {
+		t.Fatal("SetHost accepted an invalid IP")
+	}
+}
+
+func TestMockResolverFailures(t *testing.T) {
+	r := NewMockResolver()
+	defer r.Close()
+	res := r.Resolver()
+	ctx := context.Background()
+
+	var dnsErr *net.DNSError
+	if _, err := res.LookupHost(ctx, "unknown.test"); !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Fatalf("unset name = %v, want not found", err)
+	}
+
+	r.SetHost("flaky.test", time.Minute, "10.0.0.1")
+	r.Fail("flaky.test", DNSServFail)
+	if _, err := res.LookupHost(ctx, "flaky.test"); !errors.As(err, &dnsErr) || dnsErr.IsNotFound {
+		t.Fatalf("SERVFAIL = %v", err)
+	}
+	r.Fail("flaky.test", DNSNXDomain)
+	if _, err := res.LookupHost(ctx, "flaky.test"); !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Fatalf("NXDOMAIN = %v", err)
+	}
+
+	r.Fail("flaky.test", DNSTimeout)
+	tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := res.LookupHost(tctx, "flaky.test"); err == nil {
+		t.Fatal("timeout answered")
+	}
+
+	r.SetHost("slow.test", time.Minute, "10.0.0.9")
+	r.Delay("slow.test", 50*time.Millisecond)
+	start := time.Now()
+	if addrs, err := res.LookupHost(ctx, "slow.test"); err != nil || len(addrs) != 1 {
+		t.Fatalf("delayed lookup = %v, %v", addrs, err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("delayed lookup took %v", d)
+	}
+
+	r.Remove("slow.test")
+	if _, err := res.LookupHost(ctx, "slow.test"); !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Fatalf("removed name = %v", err)
+	}
+}
+
+func TestMockResolverCNAMELoop(t *testing.T) {
+	r := NewMockResolver()
+	defer r.Close()
+	r.SetCNAME("a.test", "b.test", time.Minute)
+	r.SetCNAME("b.test", "a.test", time.Minute)
+	tctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := r.Resolver().LookupHost(tctx, "a.test"); err == nil {
+		t.Fatal("CNAME loop resolved")
+	}
+}
+
+func TestDNSNameCodec(t *testing.T) {
+	b := appendDNSName(nil, "Example.COM.")
+	name, off, err := readDNSName(b, 0)
+	if err != nil || name != "Example.COM." || off != len(b) {
+		t.Fatalf("readDNSName = %q, %d, %v", name, off, err)
+	}
+	if name, _, _ := readDNSName([]byte{0}, 0); name != "." {
+		t.Fatalf("root name = %q", name)
+	}
+	if _, _, err := readDNSName([]byte{0xc0, 0x0c}, 0); err == nil {
+		t.Fatal("compressed name accepted")
+	}
+	if _, _, ok := NewMockResolver().answer([]byte{1, 2, 3}); ok {
+		t.Fatal("short query answered")
+	}
+}
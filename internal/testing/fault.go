@@ -0,0 +1,237 @@
+package testing
+
+/*
+	故障注入连接: 按读写字节偏移调度延迟、分片读写、中途重置、字节损坏和带宽限制,
+	让客户端和服务器的容错路径得到确定性的覆盖
+*/
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// FaultDir 故障作用的方向
+type FaultDir int
+
+const (
+	// FaultBoth 读写两个方向
+	FaultBoth FaultDir = iota
+	// FaultRead 只作用于读
+	FaultRead
+	// FaultWrite 只作用于写
+	FaultWrite
+)
+
+// Fault 一项故障, 在对应方向累计传输到 After 字节时生效, 到 Until 字节 (0 表示不结束) 时失效.
+// 多项同时生效时效果叠加
+type Fault struct {
+	Dir   FaultDir
+	After int64
+	Until int64
+	// Latency 每次读写前的额外延迟
+	Latency time.Duration
+	// MaxChunk > 0 时每次读最多返回、每次底层写最多写出 MaxChunk 字节
+	MaxChunk int
+	// Reset 到达 After 时以 ECONNRESET 失败并关闭底层连接
+	Reset bool
+	// Corrupt 将偏移 After 处的一个字节按位取反
+	Corrupt bool
+	// Rate > 0 时限制带宽, 单位字节/秒
+	Rate float64
+
+	limiter *utils.RateLimiter
+}
+
+func (f *Fault) appliesTo(dir FaultDir) bool {
+	return f.Dir == FaultBoth || f.Dir == dir
+}
+
+func (f *Fault) activeAt(pos int64) bool {
+	return pos >= f.After && (f.Until <= 0 || pos < f.Until)
+}
+
+// FaultConn 注入故障的连接, 读写各自可以并发调用
+type FaultConn struct {
+	net.Conn
+
+	mu     sync.Mutex
+	faults []*Fault
+	readN  int64
+	writeN int64
+}
+
+// NewFaultConn 包装 c 并注入 faults
+func NewFaultConn(c net.Conn, faults ...Fault) *FaultConn {
+	fc := &FaultConn{Conn: c}
+	for _, f := range faults {
+		fc.AddFault(f)
+	}
+	return fc
+}
+
+// AddFault 追加一项故障, 偏移以连接建立以来的累计字节计
+func (fc *FaultConn) AddFault(f Fault) {
+	if f.Rate > 0 {
+		f.limiter = utils.NewRateLimiter(f.Rate, max(int(f.Rate/10), 1))
+	}
+	fc.mu.Lock()
+	fc.faults = append(fc.faults, &f)
+	fc.mu.Unlock()
+}
+
+// ClearFaults 移除所有故障
+func (fc *FaultConn) ClearFaults() {
+	fc.mu.Lock()
+	fc.faults = nil
+	fc.mu.Unlock()
+}
+
+// Counts 返回已读和已写的字节数
+func (fc *FaultConn) Counts() (read, written int64) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.readN, fc.writeN
+}
+
+// plan 一次读写的合成效果
+type plan struct {
+	latency  time.Duration
+	limit    int
+	reset    bool
+	corrupt  bool
+	limiters []*utils.RateLimiter
+}
+
+// plan 计算从 pos 开始、最多 n 字节的一次读写的效果, 长度截断到下一个故障边界, 使故障精确地在 After 处生效
+func (fc *FaultConn) plan(dir FaultDir, n int) plan {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	pos := fc.readN
+	if dir == FaultWrite {
+		pos = fc.writeN
+	}
+	p := plan{limit: n}
+	for _, f := range fc.faults {
+		if !f.appliesTo(dir) {
+			continue
+		}
+		if !f.activeAt(pos) {
+			if f.After > pos {
+				p.limit = int(min(int64(p.limit), f.After-pos))
+			}
+			continue
+		}
+		if f.Until > 0 {
+			p.limit = int(min(int64(p.limit), f.Until-pos))
+		}
+		p.latency += f.Latency
+		if f.MaxChunk > 0 {
+			p.limit = min(p.limit, f.MaxChunk)
+		}
+		if f.Reset && pos == f.After {
+			p.reset = true
+		}
+		if f.Corrupt && pos == f.After {
+			p.corrupt = true
+		}
+		if f.limiter != nil {
+			p.limiters = append(p.limiters, f.limiter)
+		}
+	}
+	return p
+}
+
+func (fc *FaultConn) advance(dir FaultDir, n int) {
+	fc.mu.Lock()
+	if dir == FaultWrite {
+		fc.writeN += int64(n)
+	} else {
+		fc.readN += int64(n)
+	}
+	fc.mu.Unlock()
+}
+
+func (p *plan) wait(n int) {
+	if p.latency > 0 {
+		time.Sleep(p.latency)
+	}
+	for _, l := range p.limiters {
+		l.Wait(context.Background(), n)
+	}
+}
+
+func (fc *FaultConn) reset(op string) error {
+	if tc, ok := fc.Conn.(*net.TCPConn); ok {
+		// 让对端收到 RST 而不是 FIN
+		tc.SetLinger(0)
+	}
+	fc.Conn.Close()
+	return &net.OpError{Op: op, Net: "tcp", Source: fc.LocalAddr(), Addr: fc.RemoteAddr(),
+		Err: os.NewSyscallError(op, syscall.ECONNRESET)}
+}
+
+// Read 按生效的故障读取
+func (fc *FaultConn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return fc.Conn.Read(b)
+	}
+	p := fc.plan(FaultRead, len(b))
+	if p.reset {
+		return 0, fc.reset("read")
+	}
+	p.wait(p.limit)
+	n, err := fc.Conn.Read(b[:p.limit])
+	if n > 0 && p.corrupt {
+		b[0] ^= 0xff
+	}
+	fc.advance(FaultRead, n)
+	return n, err
+}
+
+// Write 按生效的故障分片写出
+func (fc *FaultConn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		p := fc.plan(FaultWrite, len(b)-written)
+		if p.reset {
+			return written, fc.reset("write")
+		}
+		chunk := b[written : written+p.limit]
+		if p.corrupt {
+			chunk = append([]byte(nil), chunk...)
+			chunk[0] ^= 0xff
+		}
+		p.wait(len(chunk))
+		n, err := fc.Conn.Write(chunk)
+		written += n
+		fc.advance(FaultWrite, n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Unwrap 返回被包装的连接
+func (fc *FaultConn) Unwrap() net.Conn { return fc.Conn }
+
+// FaultListener 为每个接受的连接注入相同的故障
+type FaultListener struct {
+	net.Listener
+	Faults []Fault
+}
+
+// Accept 返回包装为 *FaultConn 的连接
+func (l *FaultListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewFaultConn(c, l.Faults...), nil
+}
@@ -0,0 +1,159 @@
+package testing
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// tcpPair 返回一对已连接的回环 TCP 连接
+func tcpPair(t *testing.T) (client, server net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+	client, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server = <-accepted
+	if server == nil {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	client.SetDeadline(deadline)
+	server.SetDeadline(deadline)
+	return client, server
+}
+
+func TestFaultConnChunkedRead(t *testing.T) {
+	c, s := tcpPair(t)
+	fc := NewFaultConn(c, Fault{Dir: FaultRead, After: 2, Until: 8, MaxChunk: 3})
+	s.Write([]byte("0123456789"))
+
+	var got []int
+	buf := make([]byte, 64)
+	total := 0
+	for total < 10 {
+		n, err := fc.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, n)
+		total += n
+	}
+	// 前 2 字节截断在故障边界, 之后每次最多 3 字节直到偏移 8
+	if want := []int{2, 3, 3, 2}; !slices.Equal(got, want) {
+		t.Fatalf("read sizes = %v, want %v", got, want)
+	}
+	if r, w := fc.Counts(); r != 10 || w != 0 {
+		t.Fatalf("Counts = %d, %d", r, w)
+	}
+}
+
+func TestFaultConnCorruptWrite(t *testing.T) {
+	c, s := tcpPair(t)
+	fc := NewFaultConn(c, Fault{Dir: FaultWrite, After: 5, Corrupt: true})
+	msg := []byte("hello world")
+	if n, err := fc.Write(msg); n != len(msg) || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if string(msg) != "hello world" {
+		t.Fatal("caller's buffer was modified")
+	}
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(s, got); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte("hello world")
+	want[5] ^= 0xff
+	if !bytes.Equal(got, want) {
+		t.Fatalf("peer got %q, want %q", got, want)
+	}
+}
+
+func TestFaultConnReset(t *testing.T) {
+	c, s := tcpPair(t)
+	fc := NewFaultConn(c, Fault{Dir: FaultWrite, After: 4, Reset: true})
+	n, err := fc.Write([]byte("abcdefgh"))
+	if n != 4 || !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("Write = %d, %v; want 4, ECONNRESET", n, err)
+	}
+	got, err := io.ReadAll(s)
+	if string(got) != "abcd" {
+		t.Fatalf("peer got %q", got)
+	}
+	if err == nil {
+		// 设置了 SO_LINGER=0, 对端应看到 RST 而不是正常的 EOF
+		t.Fatal("peer saw a clean EOF after reset")
+	}
+}
+
+func TestFaultConnLatencyAndRate(t *testing.T) {
+	c, s := tcpPair(t)
+	fc := NewFaultConn(c, Fault{Dir: FaultWrite, Latency: 30 * time.Millisecond})
+	go io.Copy(io.Discard, s)
+
+	start := time.Now()
+	fc.Write([]byte("x"))
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Fatalf("write with latency took %v", d)
+	}
+
+	fc.ClearFaults()
+	fc.AddFault(Fault{Dir: FaultWrite, Rate: 2000})
+	start = time.Now()
+	if _, err := fc.Write(make([]byte, 600)); err != nil {
+		t.Fatal(err)
+	}
+	// 突发 200 字节, 剩余 400 字节以 2000 B/s 约需 200ms
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Fatalf("rate limited write took %v", d)
+	}
+}
+
+func TestFaultListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fl := &FaultListener{Listener: ln, Faults: []Fault{{MaxChunk: 1}}}
+	defer fl.Close()
+	go func() {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err == nil {
+			c.Write([]byte("abc"))
+			c.Close()
+		}
+	}()
+	c, err := fl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fc, ok := c.(*FaultConn)
+	if !ok || fc.Unwrap() == nil {
+		t.Fatalf("Accept returned %T", c)
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 8)
+	if n, err := c.Read(buf); n != 1 || err != nil {
+		t.Fatalf("Read = %d, %v; want 1 byte", n, err)
+	}
+}
@@ -0,0 +1,185 @@
+package testing
+
+/*
+	golden 文件比较: 将序列化后的 HTTP 消息与 testdata 下的 golden 文件比较, 用于解析器和序列化的回归测试.
+	以 -update 运行测试时改为重写 golden 文件; 比较前可通过规范化函数去掉日期、multipart 边界等易变内容
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http1"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files instead of comparing against them")
+
+// GoldenDir golden 文件所在目录, 相对于测试的工作目录 (即包目录)
+var GoldenDir = "testdata"
+
+// Normalizer 比较前对内容的规范化处理
+type Normalizer func([]byte) []byte
+
+// NormalizeRegexp 返回将 re 的匹配替换为 repl 的规范化函数, repl 语义同 Regexp.ReplaceAll
+func NormalizeRegexp(re *regexp.Regexp, repl string) Normalizer {
+	return func(b []byte) []byte { return re.ReplaceAll(b, []byte(repl)) }
+}
+
+var (
+	dateHeaderRe = regexp.MustCompile(`(?im)^(Date|Expires|Last-Modified):[^\r\n]*`)
+	boundaryRe   = regexp.MustCompile(`boundary="?([0-9A-Za-z'()+_,./:=?-]{1,70})"?`)
+)
+
+// NormalizeDate 将 Date、Expires 和 Last-Modified 头部的值替换为 <date>
+var NormalizeDate Normalizer = NormalizeRegexp(dateHeaderRe, "$1: <date>")
+
+// NormalizeBoundary 将 multipart 边界 (包括消息体中的分隔行) 替换为 BOUNDARY
+func NormalizeBoundary(b []byte) []byte {
+	for _, m := range boundaryRe.FindAllSubmatch(b, -1) {
+		b = bytes.ReplaceAll(b, m[1], []byte("BOUNDARY"))
+	}
+	return b
+}
+
+// AssertGolden 比较 got 与 GoldenDir/name.golden, 不一致时报告逐行差异并使测试失败
+func AssertGolden(tb testing.TB, name string, got []byte, norms ...Normalizer) {
+	tb.Helper()
+	for _, n := range norms {
+		got = n(got)
+	}
+	path := filepath.Join(GoldenDir, name+".golden")
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			tb.Fatalf("golden: %v", err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			tb.Fatalf("golden: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		tb.Fatalf("golden: %v (run with -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		tb.Errorf("golden %s mismatch (-want +got):\n%s", path, LineDiff(string(want), string(got)))
+	}
+}
+
+// AssertGoldenRequest 序列化请求后与 golden 文件比较, 消息体被读出后重新放回请求
+func AssertGoldenRequest(tb testing.TB, name string, req *message.Request, norms ...Normalizer) {
+	tb.Helper()
+	body := bufferBody(tb, &req.Body)
+	var buf bytes.Buffer
+	if err := http1.WriteRequest(bufio.NewWriter(&buf), req); err != nil {
+		tb.Fatalf("golden: write request: %v", err)
+	}
+	req.Body = body()
+	AssertGolden(tb, name, buf.Bytes(), norms...)
+}
+
+// AssertGoldenResponse 序列化响应后与 golden 文件比较, 消息体被读出后重新放回响应
+func AssertGoldenResponse(tb testing.TB, name string, resp *message.Response, norms ...Normalizer) {
+	tb.Helper()
+	body := bufferBody(tb, &resp.Body)
+	var buf bytes.Buffer
+	if err := http1.WriteResponse(bufio.NewWriter(&buf), resp); err != nil {
+		tb.Fatalf("golden: write response: %v", err)
+	}
+	resp.Body = body()
+	AssertGolden(tb, name, buf.Bytes(), norms...)
+}
+
+// bufferBody 读出 *body 并替换为内存副本, 返回生成新副本的函数
+func bufferBody(tb testing.TB, body *io.ReadCloser) func() io.ReadCloser {
+	tb.Helper()
+	if *body == nil || *body == message.NoBody {
+		orig := *body
+		return func() io.ReadCloser { return orig }
+	}
+	data, err := readBody(body)
+	if err != nil {
+		tb.Fatalf("golden: read body: %v", err)
+	}
+	return func() io.ReadCloser { return io.NopCloser(bytes.NewReader(data)) }
+}
+
+// LineDiff 返回 want 与 got 的逐行差异, 以 "-" 标记只在 want 中的行、"+" 标记只在 got 中的行,
+// 行内的 \r 和其他控制字符以转义形式显示
+func LineDiff(want, got string) string {
+	a, b := splitLines(want), splitLines(got)
+	// lcs[i][j] 为 a[i:] 与 b[j:] 的最长公共子序列长度
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var sb strings.Builder
+	line := func(prefix byte, s string) {
+		sb.WriteByte(prefix)
+		sb.WriteByte(' ')
+		sb.WriteString(visible(strings.TrimSuffix(s, "\n")))
+		sb.WriteByte('\n')
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			line(' ', a[i])
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			line('-', a[i])
+			i++
+		default:
+			line('+', b[j])
+			j++
+		}
+	}
+	return sb.String()
+}
+
+// splitLines 按行切分并保留换行符, 末尾的空串不计为一行
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// visible 转义控制字符, 使 CRLF 等差异可见
+func visible(s string) string {
+	if !strings.ContainsFunc(s, func(r rune) bool { return r < ' ' && r != '\t' }) {
+		return s
+	}
+	var sb strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\r':
+			sb.WriteString(`\r`)
+		case r < ' ' && r != '\t':
+			sb.WriteString(`\x`)
+			sb.WriteByte("0123456789abcdef"[r>>4])
+			sb.WriteByte("0123456789abcdef"[r&0xf])
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
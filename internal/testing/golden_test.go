@@ -0,0 +1,177 @@
+package testing
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+)
+
+// fakeTB 记录失败而不终止外层测试, 用于检验断言类辅助函数本身
+type fakeTB struct {
+	testing.TB
+
+	mu       sync.Mutex
+	failed   bool
+	fatal    bool
+	msgs     []string
+	cleanups []func()
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Errorf(format string, args ...any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failed = true
+	f.msgs = append(f.msgs, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeTB) Fatalf(format string, args ...any) {
+	f.Errorf(format, args...)
+	f.mu.Lock()
+	f.fatal = true
+	f.mu.Unlock()
+	runtime.Goexit()
+}
+
+func (f *fakeTB) Logf(format string, args ...any) {}
+
+func (f *fakeTB) Cleanup(fn func()) {
+	f.mu.Lock()
+	f.cleanups = append(f.cleanups, fn)
+	f.mu.Unlock()
+}
+
+func (f *fakeTB) runCleanups() {
+	for {
+		f.mu.Lock()
+		n := len(f.cleanups)
+		if n == 0 {
+			f.mu.Unlock()
+			return
+		}
+		fn := f.cleanups[n-1]
+		f.cleanups = f.cleanups[:n-1]
+		f.mu.Unlock()
+		fn()
+	}
+}
+
+func (f *fakeTB) Failed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.failed
+}
+
+func (f *fakeTB) output() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return strings.Join(f.msgs, "\n")
+}
+
+// runFake 在独立 goroutine 中以 fakeTB 运行 fn 及其注册的清理函数, 使 Fatalf 只结束 fn
+func runFake(t *testing.T, fn func(tb *fakeTB)) *fakeTB {
+	t.Helper()
+	tb := &fakeTB{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer tb.runCleanups()
+		fn(tb)
+	}()
+	<-done
+	return tb
+}
+
+func withGoldenDir(t *testing.T, update bool) string {
+	dir := t.TempDir()
+	oldDir, oldUpdate := GoldenDir, *updateGolden
+	GoldenDir, *updateGolden = dir, update
+	t.Cleanup(func() { GoldenDir, *updateGolden = oldDir, oldUpdate })
+	return dir
+}
+
+func TestAssertGoldenUpdateAndCompare(t *testing.T) {
+	dir := withGoldenDir(t, true)
+	AssertGolden(t, "sub/msg", []byte("line1\r\nline2\r\n"))
+	data, err := os.ReadFile(filepath.Join(dir, "sub", "msg.golden"))
+	if err != nil || string(data) != "line1\r\nline2\r\n" {
+		t.Fatalf("golden file = %q, %v", data, err)
+	}
+
+	*updateGolden = false
+	if tb := runFake(t, func(tb *fakeTB) { AssertGolden(tb, "sub/msg", []byte("line1\r\nline2\r\n")) }); tb.Failed() {
+		t.Fatalf("matching content failed: %s", tb.output())
+	}
+	tb := runFake(t, func(tb *fakeTB) { AssertGolden(tb, "sub/msg", []byte("line1\nline2\r\n")) })
+	if !tb.Failed() || !strings.Contains(tb.output(), `- line1\r`) || !strings.Contains(tb.output(), "+ line1\n") {
+		t.Fatalf("mismatch report:\n%s", tb.output())
+	}
+	tb = runFake(t, func(tb *fakeTB) { AssertGolden(tb, "missing", nil) })
+	if !tb.fatal || !strings.Contains(tb.output(), "-update") {
+		t.Fatalf("missing golden: %s", tb.output())
+	}
+}
+
+func TestAssertGoldenRequestKeepsBody(t *testing.T) {
+	withGoldenDir(t, true)
+	newReq := func() *message.Request {
+		req, err := message.NewRequest("POST", "http://example.com/upload", strings.NewReader("payload"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Date", "Mon, 02 Jan 2006 15:04:05 GMT")
+		return req
+	}
+	req := newReq()
+	AssertGoldenRequest(t, "req", req, NormalizeDate)
+	if b, _ := io.ReadAll(req.Body); string(b) != "payload" {
+		t.Fatalf("body after golden = %q", b)
+	}
+
+	*updateGolden = false
+	req = newReq()
+	req.Header.Set("Date", "Tue, 03 Jan 2006 10:00:00 GMT")
+	if tb := runFake(t, func(tb *fakeTB) { AssertGoldenRequest(tb, "req", req, NormalizeDate) }); tb.Failed() {
+		t.Fatalf("normalized date still differs: %s", tb.output())
+	}
+
+	resp := message.NewResponse(200)
+	resp.Header.Set("Content-Type", "text/plain")
+	resp.Body = io.NopCloser(strings.NewReader("ok"))
+	resp.ContentLength = 2
+	*updateGolden = true
+	AssertGoldenResponse(t, "resp", resp)
+	if b, _ := io.ReadAll(resp.Body); string(b) != "ok" {
+		t.Fatalf("response body after golden = %q", b)
+	}
+}
+
+func TestNormalizers(t *testing.T) {
+	in := "Content-Type: multipart/form-data; boundary=\"abc123\"\r\n" +
+		"Last-Modified: Mon, 02 Jan 2006 15:04:05 GMT\r\n\r\n--abc123\r\n--abc123--\r\n"
+	got := string(NormalizeBoundary(NormalizeDate([]byte(in))))
+	want := "Content-Type: multipart/form-data; boundary=\"BOUNDARY\"\r\n" +
+		"Last-Modified: <date>\r\n\r\n--BOUNDARY\r\n--BOUNDARY--\r\n"
+	if got != want {
+		t.Fatalf("normalized:\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	got := LineDiff("a\nb\nc\n", "a\nx\nc\x01\n")
+	want := "  a\n- b\n- c\n+ x\n+ c\\x01\n"
+	if got != want {
+		t.Fatalf("LineDiff =\n%s\nwant\n%s", got, want)
+	}
+	if d := LineDiff("same\n", "same\n"); strings.ContainsAny(d, "+-") {
+		t.Fatalf("equal inputs produced diff %q", d)
+	}
+}
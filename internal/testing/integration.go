@@ -1 +1,48 @@
 package testing
+
+/*
+	集成测试套件: 汇总断言、模拟服务器和资源清理, 并提供故障编排入口
+*/
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// DefaultSuiteTimeout 套件 Context 的默认超时
+const DefaultSuiteTimeout = time.Minute
+
+// TestSuite 一个集成测试的上下文, 测试结束时自动释放创建的资源
+type TestSuite struct {
+	*Helper
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewTestSuite 创建套件, 其 Context 在测试结束或 DefaultSuiteTimeout 后取消
+func NewTestSuite(tb testing.TB) *TestSuite {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultSuiteTimeout)
+	s := &TestSuite{Helper: NewHelper(tb), ctx: ctx, cancel: cancel}
+	tb.Cleanup(cancel)
+	return s
+}
+
+// Context 返回套件的 Context
+func (s *TestSuite) Context() context.Context { return s.ctx }
+
+// MockServer 启动一个随测试结束关闭的模拟服务器, 失败时终止测试
+func (s *TestSuite) MockServer() *MockServer {
+	s.Helper.Helper()
+	srv, err := NewMockServer(s.TB)
+	if err != nil {
+		s.Fatalf("start mock server: %v", err)
+	}
+	return srv
+}
+
+// Chaos 返回新的故障编排器
+func (s *TestSuite) Chaos() *ChaosController {
+	return &ChaosController{suite: s, RecoveryTimeout: DefaultRecoveryTimeout}
+}
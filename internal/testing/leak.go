@@ -0,0 +1,184 @@
+package testing
+
+/*
+	goroutine 泄漏检测: 在测试开始时记录现有 goroutine, 测试结束时检查新增且未退出的 goroutine,
+	支持按函数名设置白名单
+*/
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// DefaultLeakWait 结束检查时等待 goroutine 自行退出的最长时间
+const DefaultLeakWait = time.Second
+
+// Goroutine 一个 goroutine 的栈信息
+type Goroutine struct {
+	ID    int64
+	State string
+	// Top 栈顶函数, 如 "net.(*netFD).Read"
+	Top string
+	// Stack 完整的栈文本
+	Stack string
+}
+
+// LeakOption 泄漏检测选项
+type LeakOption func(*leakConfig)
+
+type leakConfig struct {
+	wait     time.Duration
+	ignore   []func(*Goroutine) bool
+	baseline map[int64]bool
+}
+
+// IgnoreTopFunction 忽略栈顶为 fn 的 goroutine
+func IgnoreTopFunction(fn string) LeakOption {
+	return func(c *leakConfig) {
+		c.ignore = append(c.ignore, func(g *Goroutine) bool { return g.Top == fn })
+	}
+}
+
+// IgnoreAnyFunction 忽略栈中任意位置包含函数 fn 的 goroutine, 适用于第三方常驻 goroutine
+func IgnoreAnyFunction(fn string) LeakOption {
+	return func(c *leakConfig) {
+		c.ignore = append(c.ignore, func(g *Goroutine) bool { return strings.Contains(g.Stack, "\n"+fn+"(") })
+	}
+}
+
+// LeakWait 设置等待 goroutine 退出的最长时间
+func LeakWait(d time.Duration) LeakOption {
+	return func(c *leakConfig) { c.wait = d }
+}
+
+// 测试框架自身的 goroutine, 栈中任意位置出现即忽略
+var defaultLeakIgnores = []string{
+	"testing.(*T).Run",
+	"testing.(*T).Parallel",
+	"testing.runTests",
+	"testing.tRunner",
+}
+
+// 运行时的常驻 goroutine, 只按栈顶匹配; 尚未开始运行的 goroutine 栈中也有 runtime.goexit, 不能按栈中任意位置匹配
+var defaultLeakTops = []string{
+	"os/signal.signal_recv",
+	"os/signal.loop",
+	"runtime.goexit",
+	"runtime.ReadTrace",
+}
+
+// CheckLeaks 记录当前的 goroutine, 在测试结束时检查期间新建且仍未退出的 goroutine, 有泄漏时使测试失败.
+// 在测试开头调用; 并行测试的 goroutine 会被误判, 不应与 t.Parallel 同时使用
+func CheckLeaks(tb testing.TB, opts ...LeakOption) {
+	tb.Helper()
+	cfg := &leakConfig{wait: DefaultLeakWait, baseline: make(map[int64]bool)}
+	for _, o := range opts {
+		o(cfg)
+	}
+	for _, g := range Goroutines() {
+		cfg.baseline[g.ID] = true
+	}
+	tb.Cleanup(func() {
+		tb.Helper()
+		if leaked := cfg.find(); len(leaked) > 0 {
+			var sb strings.Builder
+			for _, g := range leaked {
+				sb.WriteString("\n")
+				sb.WriteString(g.Stack)
+			}
+			tb.Errorf("found %d leaked goroutine(s):%s", len(leaked), sb.String())
+		}
+	})
+}
+
+// find 在等待时间内反复检查, 返回最后一次检查仍存在的泄漏
+func (c *leakConfig) find() []Goroutine {
+	deadline := time.Now().Add(c.wait)
+	backoff := time.Millisecond
+	for {
+		leaked := c.leaked()
+		if len(leaked) == 0 || time.Now().After(deadline) {
+			return leaked
+		}
+		time.Sleep(backoff)
+		backoff = min(backoff*2, 100*time.Millisecond)
+	}
+}
+
+func (c *leakConfig) leaked() []Goroutine {
+	var leaked []Goroutine
+	self := currentGoroutineID()
+next:
+	for _, g := range Goroutines() {
+		if g.ID == self || c.baseline[g.ID] {
+			continue
+		}
+		for _, fn := range defaultLeakIgnores {
+			if g.Top == fn || strings.Contains(g.Stack, "\n"+fn+"(") {
+				continue next
+			}
+		}
+		for _, fn := range defaultLeakTops {
+			if g.Top == fn {
+				continue next
+			}
+		}
+		for _, ignore := range c.ignore {
+			if ignore(&g) {
+				continue next
+			}
+		}
+		leaked = append(leaked, g)
+	}
+	return leaked
+}
+
+// Goroutines 返回当前所有 goroutine 的栈信息
+func Goroutines() []Goroutine {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	var gs []Goroutine
+	for _, block := range bytes.Split(buf, []byte("\n\n")) {
+		if g, ok := parseGoroutine(string(block)); ok {
+			gs = append(gs, g)
+		}
+	}
+	return gs
+}
+
+// parseGoroutine 解析 "goroutine 7 [chan receive]:\nmain.f(...)\n\t/path:12 +0x1d\n..." 形式的栈
+func parseGoroutine(s string) (Goroutine, bool) {
+	header, rest, _ := strings.Cut(s, "\n")
+	header, ok := strings.CutPrefix(header, "goroutine ")
+	if !ok {
+		return Goroutine{}, false
+	}
+	idStr, state, _ := strings.Cut(header, " ")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return Goroutine{}, false
+	}
+	state = strings.TrimSuffix(strings.TrimPrefix(state, "["), "]:")
+	top, _, _ := strings.Cut(rest, "\n")
+	if i := strings.LastIndexByte(top, '('); i > 0 {
+		top = top[:i]
+	}
+	return Goroutine{ID: id, State: state, Top: top, Stack: s}, true
+}
+
+func currentGoroutineID() int64 {
+	var buf [64]byte
+	g, _ := parseGoroutine(string(buf[:runtime.Stack(buf[:], false)]))
+	return g.ID
+}
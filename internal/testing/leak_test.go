@@ -0,0 +1,74 @@
+package testing
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+//go:noinline
+func leakyWait(ch chan struct{}) { <-ch }
+
+func TestCheckLeaksReportsBlockedGoroutine(t *testing.T) {
+	ch := make(chan struct{})
+	defer close(ch)
+	tb := runFake(t, func(tb *fakeTB) {
+		CheckLeaks(tb, LeakWait(50*time.Millisecond))
+		go leakyWait(ch)
+	})
+	if !tb.Failed() || !strings.Contains(tb.output(), "1 leaked goroutine") || !strings.Contains(tb.output(), "leakyWait") {
+		t.Fatalf("leak not reported:\n%s", tb.output())
+	}
+}
+
+func TestCheckLeaksWaitsForExit(t *testing.T) {
+	tb := runFake(t, func(tb *fakeTB) {
+		CheckLeaks(tb)
+		go time.Sleep(30 * time.Millisecond)
+		// 测试开始前已存在的 goroutine 不计入
+	})
+	if tb.Failed() {
+		t.Fatalf("short-lived goroutine reported as leak:\n%s", tb.output())
+	}
+}
+
+func TestCheckLeaksIgnore(t *testing.T) {
+	const fn = "github.com/narcilee7/http-stack/internal/testing.leakyWait"
+	for name, opt := range map[string]LeakOption{
+		"top": IgnoreTopFunction(fn),
+		"any": IgnoreAnyFunction(fn),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ch := make(chan struct{})
+			defer close(ch)
+			tb := runFake(t, func(tb *fakeTB) {
+				CheckLeaks(tb, LeakWait(20*time.Millisecond), opt)
+				go leakyWait(ch)
+			})
+			if tb.Failed() {
+				t.Fatalf("ignored goroutine reported:\n%s", tb.output())
+			}
+		})
+	}
+}
+
+func TestParseGoroutine(t *testing.T) {
+	g, ok := parseGoroutine("goroutine 42 [chan receive, 3 minutes]:\nmain.worker(0xc000010000)\n\t/src/main.go:12 +0x1d\ncreated by main.main\n")
+	if !ok || g.ID != 42 || g.State != "chan receive, 3 minutes" || g.Top != "main.worker" {
+		t.Fatalf("parseGoroutine = %+v, %v", g, ok)
+	}
+	if _, ok := parseGoroutine("not a goroutine"); ok {
+		t.Fatal("parsed garbage")
+	}
+
+	self := currentGoroutineID()
+	found := false
+	for _, g := range Goroutines() {
+		if g.ID == self {
+			found = strings.Contains(g.Stack, "TestParseGoroutine")
+		}
+	}
+	if !found {
+		t.Fatal("current goroutine missing from Goroutines")
+	}
+}
@@ -0,0 +1,275 @@
+package testing
+
+/*
+	压测工具: 开环 (固定 RPS) 或闭环 (固定并发) 地执行操作, 统计延迟分位数和错误分布,
+	供集成测试断言服务器的吞吐和延迟指标
+*/
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/client"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+)
+
+// LoadConfig 压测配置
+type LoadConfig struct {
+	// RPS > 0 时为开环模式, 按固定速率发起操作; 否则为闭环模式, 每个 worker 完成一次后立即发起下一次
+	RPS float64
+	// Concurrency 闭环模式的 worker 数, 开环模式的最大在途操作数; 默认 1 (开环模式默认 100)
+	Concurrency int
+	// Duration 统计阶段的时长
+	Duration time.Duration
+	// Warmup 预热时长, 期间的结果不计入统计
+	Warmup time.Duration
+}
+
+// LatencyStats 延迟统计
+type LatencyStats struct {
+	Min, Max, Mean      time.Duration
+	P50, P90, P99, P999 time.Duration
+}
+
+// LoadResult 压测结果
+type LoadResult struct {
+	// Requests 统计阶段完成的操作数, 含失败
+	Requests int64
+	// Errors 失败的操作数
+	Errors int64
+	// ErrorKinds 按错误信息分类的失败次数
+	ErrorKinds map[string]int64
+	// Dropped 开环模式下因在途操作达到上限而未能按时发起的次数
+	Dropped int64
+	// Elapsed 统计阶段的实际时长
+	Elapsed time.Duration
+	// Throughput 每秒完成的操作数
+	Throughput float64
+	// Latency 所有操作 (含失败) 的延迟; 开环模式从计划发起时间算起, 避免协同遗漏
+	Latency LatencyStats
+}
+
+// ErrorRate 返回失败比例
+func (r *LoadResult) ErrorRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Errors) / float64(r.Requests)
+}
+
+func (r *LoadResult) String() string {
+	return fmt.Sprintf("%d requests in %v (%.1f/s), %d errors, %d dropped, latency p50=%v p90=%v p99=%v max=%v",
+		r.Requests, r.Elapsed.Round(time.Millisecond), r.Throughput, r.Errors, r.Dropped,
+		r.Latency.P50, r.Latency.P90, r.Latency.P99, r.Latency.Max)
+}
+
+// loadStats 统计阶段的样本
+type loadStats struct {
+	mu        sync.Mutex
+	recording bool
+	samples   []time.Duration
+	errors    map[string]int64
+	nerr      int64
+	dropped   int64
+}
+
+func (s *loadStats) record(d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.recording {
+		return
+	}
+	s.samples = append(s.samples, d)
+	if err != nil {
+		s.nerr++
+		s.errors[errorKind(err)]++
+	}
+}
+
+// errorKind 错误分类键, 截断过长的信息
+func errorKind(err error) string {
+	msg := err.Error()
+	if len(msg) > 120 {
+		msg = msg[:120] + "..."
+	}
+	return msg
+}
+
+// RunLoad 按 cfg 执行 op 直到时长结束或 ctx 结束, op 需并发安全
+func RunLoad(ctx context.Context, cfg LoadConfig, op func(ctx context.Context) error) *LoadResult {
+	conc := cfg.Concurrency
+	if conc <= 0 {
+		conc = 1
+		if cfg.RPS > 0 {
+			conc = 100
+		}
+	}
+	stats := &loadStats{errors: make(map[string]int64)}
+	ctx, cancel := context.WithTimeout(ctx, cfg.Warmup+cfg.Duration)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	// 截止时间到达后 ctx.Err() 可能稍晚才变为非空, 此时失败的操作同样不应计入
+	finished := func() bool { return ctx.Err() != nil || !time.Now().Before(deadline) }
+
+	var start time.Time
+	begin := func() {
+		stats.mu.Lock()
+		stats.recording = true
+		start = time.Now()
+		stats.mu.Unlock()
+	}
+	if cfg.Warmup > 0 {
+		timer := time.AfterFunc(cfg.Warmup, begin)
+		defer timer.Stop()
+	} else {
+		begin()
+	}
+
+	var wg sync.WaitGroup
+	if cfg.RPS > 0 {
+		runOpenLoop(ctx, cfg.RPS, conc, op, stats, &wg, finished)
+	} else {
+		for range conc {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for !finished() {
+					t := time.Now()
+					err := op(ctx)
+					if finished() {
+						// 截止时被中断的操作不计入
+						return
+					}
+					stats.record(time.Since(t), err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	res := &LoadResult{
+		Requests:   int64(len(stats.samples)),
+		Errors:     stats.nerr,
+		ErrorKinds: stats.errors,
+		Dropped:    stats.dropped,
+	}
+	if !start.IsZero() {
+		end := time.Now()
+		if end.After(deadline) && ctx.Err() == context.DeadlineExceeded {
+			end = deadline
+		}
+		res.Elapsed = end.Sub(start)
+	}
+	if res.Elapsed > 0 {
+		res.Throughput = float64(res.Requests) / res.Elapsed.Seconds()
+	}
+	res.Latency = latencyStats(stats.samples)
+	return res
+}
+
+// runOpenLoop 按计划时间发起操作, 在途数达到上限时计为 dropped
+func runOpenLoop(ctx context.Context, rps float64, conc int, op func(context.Context) error, stats *loadStats, wg *sync.WaitGroup, finished func() bool) {
+	interval := time.Duration(float64(time.Second) / rps)
+	sem := make(chan struct{}, conc)
+	next := time.Now()
+	for {
+		if d := time.Until(next); d > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d):
+			}
+		} else if finished() {
+			return
+		}
+		planned := next
+		next = next.Add(interval)
+		select {
+		case sem <- struct{}{}:
+		default:
+			stats.mu.Lock()
+			if stats.recording {
+				stats.dropped++
+			}
+			stats.mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := op(ctx)
+			if !finished() {
+				stats.record(time.Since(planned), err)
+			}
+		}()
+	}
+}
+
+func latencyStats(samples []time.Duration) LatencyStats {
+	if len(samples) == 0 {
+		return LatencyStats{}
+	}
+	s := slices.Clone(samples)
+	slices.Sort(s)
+	var sum time.Duration
+	for _, d := range s {
+		sum += d
+	}
+	pct := func(p float64) time.Duration {
+		i := int(p*float64(len(s))+0.5) - 1
+		return s[min(max(i, 0), len(s)-1)]
+	}
+	return LatencyStats{
+		Min:  s[0],
+		Max:  s[len(s)-1],
+		Mean: sum / time.Duration(len(s)),
+		P50:  pct(0.50),
+		P90:  pct(0.90),
+		P99:  pct(0.99),
+		P999: pct(0.999),
+	}
+}
+
+// HTTPLoadOp 返回用 c 发送 newReq 生成的请求的压测操作, 响应体被读完丢弃, 非 2xx 状态计为错误
+func HTTPLoadOp(c *client.Client, newReq func() (*message.Request, error)) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		req, err := newReq()
+		if err != nil {
+			return err
+		}
+		resp, err := c.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("status %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// SortedErrorKinds 按次数从多到少返回错误分类, 便于输出
+func (r *LoadResult) SortedErrorKinds() []string {
+	kinds := make([]string, 0, len(r.ErrorKinds))
+	for k := range r.ErrorKinds {
+		kinds = append(kinds, k)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if r.ErrorKinds[kinds[i]] != r.ErrorKinds[kinds[j]] {
+			return r.ErrorKinds[kinds[i]] > r.ErrorKinds[kinds[j]]
+		}
+		return strings.Compare(kinds[i], kinds[j]) < 0
+	})
+	return kinds
+}
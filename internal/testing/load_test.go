@@ -0,0 +1,137 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/client"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+)
+
+func TestRunLoadClosedLoop(t *testing.T) {
+	var calls atomic.Int64
+	errOdd := errors.New("odd")
+	res := RunLoad(context.Background(), LoadConfig{Concurrency: 4, Duration: 150 * time.Millisecond, Warmup: 50 * time.Millisecond},
+		func(ctx context.Context) error {
+			time.Sleep(2 * time.Millisecond)
+			if calls.Add(1)%2 == 1 {
+				return errOdd
+			}
+			return nil
+		})
+	if res.Requests == 0 || res.Requests >= calls.Load() {
+		// 预热阶段的操作不计入统计
+		t.Fatalf("Requests = %d, calls = %d", res.Requests, calls.Load())
+	}
+	if res.Errors == 0 || res.ErrorKinds["odd"] != res.Errors || res.ErrorRate() <= 0 || res.ErrorRate() >= 1 {
+		t.Fatalf("errors = %d %v", res.Errors, res.ErrorKinds)
+	}
+	// 统计阶段在预热结束后才开始, 负载高时定时器触发偏晚, Elapsed 只会比 Duration 短
+	if res.Elapsed <= 0 || res.Elapsed > 150*time.Millisecond || res.Throughput <= 0 {
+		t.Fatalf("Elapsed = %v, Throughput = %v", res.Elapsed, res.Throughput)
+	}
+	if res.Latency.Min < 2*time.Millisecond || res.Latency.P50 < res.Latency.Min || res.Latency.Max < res.Latency.P99 {
+		t.Fatalf("latency = %+v", res.Latency)
+	}
+	if !strings.Contains(res.String(), "requests in") {
+		t.Fatalf("String = %q", res.String())
+	}
+}
+
+func TestRunLoadOpenLoop(t *testing.T) {
+	res := RunLoad(context.Background(), LoadConfig{RPS: 200, Duration: 200 * time.Millisecond},
+		func(ctx context.Context) error { return nil })
+	// 200 RPS 持续 200ms 约 40 次
+	if res.Requests < 20 || res.Requests > 50 || res.Dropped != 0 {
+		t.Fatalf("open loop: %v", res)
+	}
+
+	// 在途上限为 1 而每次操作耗时远超间隔, 多余的计划发起被丢弃
+	res = RunLoad(context.Background(), LoadConfig{RPS: 200, Concurrency: 1, Duration: 200 * time.Millisecond},
+		func(ctx context.Context) error {
+			select {
+			case <-ctx.Done():
+			case <-time.After(30 * time.Millisecond):
+			}
+			return nil
+		})
+	if res.Dropped == 0 || res.Requests > 10 {
+		t.Fatalf("saturated open loop: %v", res)
+	}
+}
+
+func TestRunLoadCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(30*time.Millisecond, cancel)
+	start := time.Now()
+	RunLoad(ctx, LoadConfig{Duration: time.Minute}, func(ctx context.Context) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("RunLoad ignored cancellation for %v", d)
+	}
+}
+
+func TestLatencyStats(t *testing.T) {
+	var samples []time.Duration
+	for i := 100; i >= 1; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	s := latencyStats(samples)
+	want := LatencyStats{Min: time.Millisecond, Max: 100 * time.Millisecond, Mean: 50500 * time.Microsecond,
+		P50: 50 * time.Millisecond, P90: 90 * time.Millisecond, P99: 99 * time.Millisecond, P999: 100 * time.Millisecond}
+	if s != want {
+		t.Fatalf("latencyStats = %+v, want %+v", s, want)
+	}
+	if samples[0] != 100*time.Millisecond {
+		t.Fatal("latencyStats sorted the caller's samples")
+	}
+	if latencyStats(nil) != (LatencyStats{}) {
+		t.Fatal("empty samples")
+	}
+}
+
+func TestSortedErrorKinds(t *testing.T) {
+	r := &LoadResult{ErrorKinds: map[string]int64{"b": 2, "a": 2, "c": 5}}
+	if got := r.SortedErrorKinds(); !slices.Equal(got, []string{"c", "a", "b"}) {
+		t.Fatalf("SortedErrorKinds = %v", got)
+	}
+	long := errors.New(strings.Repeat("e", 200))
+	if k := errorKind(long); len(k) != 123 {
+		t.Fatalf("errorKind length = %d", len(k))
+	}
+}
+
+func TestHTTPLoadOp(t *testing.T) {
+	s, err := NewMockServer(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Handle("GET", "/ok", &MockResponse{Body: []byte("fine")})
+	s.Handle("GET", "/fail", &MockResponse{Status: 503})
+	c := client.New()
+	op := func(path string) func(context.Context) error {
+		return HTTPLoadOp(c, func() (*message.Request, error) { return message.NewRequest("GET", s.URL+path, nil) })
+	}
+	ctx := context.Background()
+	if err := op("/ok")(ctx); err != nil {
+		t.Fatalf("2xx op = %v", err)
+	}
+	if err := op("/fail")(ctx); err == nil || err.Error() != "status 503" {
+		t.Fatalf("5xx op = %v", err)
+	}
+	if err := HTTPLoadOp(c, func() (*message.Request, error) { return nil, errors.New("build") })(ctx); err == nil {
+		t.Fatal("request builder error ignored")
+	}
+
+	res := RunLoad(ctx, LoadConfig{Concurrency: 2, Duration: 100 * time.Millisecond}, op("/ok"))
+	if res.Requests == 0 || res.Errors != 0 {
+		t.Fatalf("load against mock server: %v %v", res, res.ErrorKinds)
+	}
+}
@@ -1 +1,286 @@
 package testing
+
+/*
+	进程内可编程 HTTP/1.1 模拟服务器: 注册路由和固定响应, 模拟延迟和断连, 记录收到的请求.
+	直接基于 http1 的解析和写出实现, 不依赖生产服务器
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http1"
+)
+
+// MockResponse 模拟服务器返回的响应
+type MockResponse struct {
+	// Status 状态码, 0 表示 200
+	Status int
+	Header common.Header
+	Body   []byte
+	// Chunked 以分块编码发送消息体
+	Chunked bool
+	// Delay 发送响应前的等待时间
+	Delay time.Duration
+	// Drop 读完请求后不发送任何响应直接关闭连接
+	Drop bool
+	// DropAfter > 0 时只写出响应的前 DropAfter 字节后关闭连接, 模拟响应中途断开
+	DropAfter int
+	// Close 响应后关闭连接
+	Close bool
+}
+
+// MockHandler 动态生成响应, body 为已完整读取的请求体
+type MockHandler func(req *message.Request, body []byte) *MockResponse
+
+// CapturedRequest 模拟服务器收到的请求
+type CapturedRequest struct {
+	Method string
+	// Target 请求行中的目标, 如 "/a?b=1"
+	Target     string
+	Proto      string
+	Header     common.Header
+	Body       []byte
+	Trailer    common.Header
+	RemoteAddr string
+	Time       time.Time
+}
+
+type mockRoute struct {
+	method  string
+	pattern string
+	handler MockHandler
+}
+
+// match pattern 以 "*" 结尾时按前缀匹配路径, 否则精确匹配; method 为空时匹配任意方法
+func (r *mockRoute) match(method, path string) bool {
+	if r.method != "" && r.method != method {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(r.pattern, "*"); ok {
+		return strings.HasPrefix(path, prefix)
+	}
+	return r.pattern == path
+}
+
+// MockServer 监听本地回环地址的模拟服务器, 并发安全
+type MockServer struct {
+	// URL 形如 "http://127.0.0.1:port"
+	URL string
+	// Addr 监听地址
+	Addr string
+
+	ln       net.Listener
+	mu       sync.Mutex
+	routes   []*mockRoute
+	notFound MockHandler
+	requests []CapturedRequest
+	conns    map[net.Conn]struct{}
+	closed   chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewMockServer 启动模拟服务器, tb 不为空时在测试结束时自动关闭
+func NewMockServer(tb testing.TB) (*MockServer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &MockServer{
+		URL:    "http://" + ln.Addr().String(),
+		Addr:   ln.Addr().String(),
+		ln:     ln,
+		conns:  make(map[net.Conn]struct{}),
+		closed: make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	if tb != nil {
+		tb.Cleanup(s.Close)
+	}
+	return s, nil
+}
+
+// Handle 为 method (为空表示任意方法) 和路径注册固定响应, 后注册的路由优先
+func (s *MockServer) Handle(method, pattern string, resp *MockResponse) {
+	s.HandleFunc(method, pattern, func(*message.Request, []byte) *MockResponse { return resp })
+}
+
+// HandleFunc 注册动态处理函数, 后注册的路由优先
+func (s *MockServer) HandleFunc(method, pattern string, h MockHandler) {
+	s.mu.Lock()
+	s.routes = append(s.routes, &mockRoute{method: method, pattern: pattern, handler: h})
+	s.mu.Unlock()
+}
+
+// NotFound 设置没有匹配路由时的处理函数, 默认返回 404
+func (s *MockServer) NotFound(h MockHandler) {
+	s.mu.Lock()
+	s.notFound = h
+	s.mu.Unlock()
+}
+
+// Requests 返回至今收到的请求
+func (s *MockServer) Requests() []CapturedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]CapturedRequest(nil), s.requests...)
+}
+
+// LastRequest 返回最近一个请求, 没有时 ok 为 false
+func (s *MockServer) LastRequest() (req CapturedRequest, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.requests) == 0 {
+		return CapturedRequest{}, false
+	}
+	return s.requests[len(s.requests)-1], true
+}
+
+// Reset 清除路由和已记录的请求
+func (s *MockServer) Reset() {
+	s.mu.Lock()
+	s.routes, s.requests, s.notFound = nil, nil, nil
+	s.mu.Unlock()
+}
+
+// CloseConnections 关闭所有已建立的连接, 模拟服务端断开, 服务器继续接受新连接
+func (s *MockServer) CloseConnections() {
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+}
+
+// Close 停止监听并关闭所有连接, 等待处理 goroutine 退出
+func (s *MockServer) Close() {
+	select {
+	case <-s.closed:
+		return
+	default:
+	}
+	close(s.closed)
+	s.ln.Close()
+	s.CloseConnections()
+	s.wg.Wait()
+}
+
+func (s *MockServer) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.serveConn(c)
+	}
+}
+
+func (s *MockServer) serveConn(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		c.Close()
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	}()
+	br := bufio.NewReader(c)
+	bw := bufio.NewWriter(c)
+	for {
+		req, err := http1.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return
+		}
+		captured := CapturedRequest{
+			Method:     req.Method,
+			Target:     req.RequestURI(),
+			Proto:      req.Proto,
+			Header:     req.Header,
+			Body:       body,
+			RemoteAddr: c.RemoteAddr().String(),
+			Time:       time.Now(),
+		}
+		if t, ok := req.Body.(interface{ Trailer() common.Header }); ok {
+			captured.Trailer = t.Trailer()
+		}
+
+		s.mu.Lock()
+		s.requests = append(s.requests, captured)
+		h := s.notFound
+		for i := len(s.routes) - 1; i >= 0; i-- {
+			if s.routes[i].match(req.Method, req.URL.Path) {
+				h = s.routes[i].handler
+				break
+			}
+		}
+		s.mu.Unlock()
+
+		mr := &MockResponse{Status: common.StatusNotFound}
+		if h != nil {
+			if r := h(req, body); r != nil {
+				mr = r
+			}
+		}
+		if mr.Delay > 0 {
+			select {
+			case <-time.After(mr.Delay):
+			case <-s.closed:
+				return
+			}
+		}
+		if mr.Drop {
+			return
+		}
+		if err := s.writeResponse(c, bw, req, mr); err != nil || mr.Close || req.Close {
+			return
+		}
+	}
+}
+
+func (s *MockServer) writeResponse(c net.Conn, bw *bufio.Writer, req *message.Request, mr *MockResponse) error {
+	resp := message.NewResponse(mr.Status)
+	if mr.Status == 0 {
+		resp = message.NewResponse(common.StatusOK)
+	}
+	for k, vs := range mr.Header {
+		resp.Header[k] = append([]string(nil), vs...)
+	}
+	resp.Request = req
+	resp.Close = mr.Close || req.Close
+	resp.ContentLength = int64(len(mr.Body))
+	if len(mr.Body) > 0 {
+		resp.Body = io.NopCloser(bytes.NewReader(mr.Body))
+	}
+	if mr.Chunked && len(mr.Body) > 0 {
+		resp.ContentLength = -1
+	}
+	if mr.DropAfter <= 0 {
+		return http1.WriteResponse(bw, resp)
+	}
+	var buf bytes.Buffer
+	if err := http1.WriteResponse(bufio.NewWriter(&buf), resp); err != nil {
+		return err
+	}
+	c.Write(buf.Bytes()[:min(mr.DropAfter, buf.Len())])
+	return errDropped
+}
+
+var errDropped = errors.New("testing: connection dropped")
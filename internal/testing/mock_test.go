@@ -0,0 +1,178 @@
+package testing
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http1"
+)
+
+// mockConn 到模拟服务器的一条原始连接
+type mockConn struct {
+	t  *testing.T
+	c  net.Conn
+	br *bufio.Reader
+	bw *bufio.Writer
+}
+
+func dialMock(t *testing.T, s *MockServer) *mockConn {
+	t.Helper()
+	c, err := net.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	return &mockConn{t: t, c: c, br: bufio.NewReader(c), bw: bufio.NewWriter(c)}
+}
+
+// do 发送请求并读取响应和完整的消息体
+func (mc *mockConn) do(method, target, body string) (*message.Response, string, error) {
+	mc.t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := message.NewRequest(method, "http://mock"+target, r)
+	if err != nil {
+		mc.t.Fatal(err)
+	}
+	if err := http1.WriteRequest(mc.bw, req); err != nil {
+		return nil, "", err
+	}
+	if err := mc.bw.Flush(); err != nil {
+		return nil, "", err
+	}
+	resp, err := http1.ReadResponse(mc.br, req)
+	if err != nil {
+		return nil, "", err
+	}
+	b, err := io.ReadAll(resp.Body)
+	return resp, string(b), err
+}
+
+func TestMockServerRoutes(t *testing.T) {
+	s, err := NewMockServer(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Handle("GET", "/fixed", &MockResponse{Status: 201, Header: map[string][]string{"X-Mock": {"1"}}, Body: []byte("fixed")})
+	s.Handle("", "/api/*", &MockResponse{Body: []byte("prefix")})
+	s.Handle("", "/api/special", &MockResponse{Body: []byte("special")})
+	s.HandleFunc("POST", "/echo", func(req *message.Request, body []byte) *MockResponse {
+		return &MockResponse{Body: append([]byte(req.Header.Get("X-Tag")+":"), body...), Chunked: true}
+	})
+
+	mc := dialMock(t, s)
+	for _, tt := range []struct {
+		method, target, body string
+		status               int
+		want                 string
+	}{
+		{"GET", "/fixed", "", 201, "fixed"},
+		{"POST", "/fixed", "", 404, ""},
+		{"DELETE", "/api/users/1", "", 200, "prefix"},
+		{"GET", "/api/special", "", 200, "special"},
+		{"POST", "/echo", "hello", 200, ":hello"},
+		{"GET", "/missing", "", 404, ""},
+	} {
+		resp, body, err := mc.do(tt.method, tt.target, tt.body)
+		if err != nil {
+			t.Fatalf("%s %s: %v", tt.method, tt.target, err)
+		}
+		if resp.StatusCode != tt.status || body != tt.want {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.target, resp.StatusCode, body, tt.status, tt.want)
+		}
+	}
+
+	reqs := s.Requests()
+	if len(reqs) != 6 {
+		t.Fatalf("captured %d requests, want 6", len(reqs))
+	}
+	if r := reqs[4]; r.Method != "POST" || r.Target != "/echo" || string(r.Body) != "hello" || r.RemoteAddr == "" {
+		t.Fatalf("captured request = %+v", r)
+	}
+	if last, ok := s.LastRequest(); !ok || last.Target != "/missing" {
+		t.Fatalf("LastRequest = %+v, %v", last, ok)
+	}
+
+	s.NotFound(func(*message.Request, []byte) *MockResponse { return &MockResponse{Status: 418} })
+	if resp, _, _ := mc.do("GET", "/missing", ""); resp == nil || resp.StatusCode != 418 {
+		t.Fatalf("custom NotFound: %v", resp)
+	}
+	s.Reset()
+	if _, ok := s.LastRequest(); ok {
+		t.Fatal("Reset did not clear captured requests")
+	}
+	if resp, _, _ := mc.do("GET", "/fixed", ""); resp == nil || resp.StatusCode != 404 {
+		t.Fatal("Reset did not clear routes")
+	}
+}
+
+func TestMockServerDelayAndDrop(t *testing.T) {
+	s, err := NewMockServer(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Handle("GET", "/slow", &MockResponse{Delay: 50 * time.Millisecond, Body: []byte("late")})
+	s.Handle("GET", "/drop", &MockResponse{Drop: true})
+	s.Handle("GET", "/partial", &MockResponse{Body: []byte("0123456789"), DropAfter: 40})
+	s.Handle("GET", "/close", &MockResponse{Body: []byte("bye"), Close: true})
+
+	start := time.Now()
+	if _, body, err := dialMock(t, s).do("GET", "/slow", ""); err != nil || body != "late" {
+		t.Fatalf("delayed response: %q, %v", body, err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("delayed response arrived after %v", d)
+	}
+
+	if _, _, err := dialMock(t, s).do("GET", "/drop", ""); err == nil {
+		t.Fatal("dropped request got a response")
+	}
+
+	// 响应头已写出一部分后断开
+	if _, _, err := dialMock(t, s).do("GET", "/partial", ""); err == nil {
+		t.Fatal("truncated response parsed without error")
+	}
+
+	mc := dialMock(t, s)
+	resp, body, err := mc.do("GET", "/close", "")
+	if err != nil || body != "bye" || !resp.Close {
+		t.Fatalf("Close response: %v %q %v", resp, body, err)
+	}
+	if _, err := mc.br.ReadByte(); !errors.Is(err, io.EOF) {
+		t.Fatalf("read after Close response = %v, want EOF", err)
+	}
+}
+
+func TestMockServerCloseConnections(t *testing.T) {
+	s, err := NewMockServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Handle("", "/", &MockResponse{})
+	mc := dialMock(t, s)
+	if _, _, err := mc.do("GET", "/", ""); err != nil {
+		t.Fatal(err)
+	}
+	s.CloseConnections()
+	if _, err := mc.br.ReadByte(); err == nil {
+		t.Fatal("connection still open after CloseConnections")
+	}
+	// 服务器继续接受新连接
+	if _, _, err := dialMock(t, s).do("GET", "/", ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	s.Close()
+	if _, err := net.Dial("tcp", s.Addr); err == nil {
+		t.Fatal("server still accepting after Close")
+	}
+}
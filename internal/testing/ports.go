@@ -0,0 +1,202 @@
+package testing
+
+/*
+	端口分配: GetFreePort 关闭监听后返回端口号, 在关闭与被测组件重新监听之间可能被其他进程抢占;
+	Reserve 保持监听不关闭, 以租约形式把监听器或其文件描述符直接交给被测组件, 租约到期或测试结束时自动回收
+*/
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	// ErrLeaseExpired 租约已过期或已释放
+	ErrLeaseExpired = errors.New("testing: port lease expired")
+	// ErrLeaseTaken 租约的监听器已交出
+	ErrLeaseTaken = errors.New("testing: port lease already handed over")
+)
+
+// DefaultLeaseTTL 租约的默认有效期
+const DefaultLeaseTTL = time.Minute
+
+// PortManager 分配本地端口, 并发安全
+type PortManager struct {
+	// Host 监听的地址, 默认 "127.0.0.1"; 须在分配前设置
+	Host string
+
+	mu     sync.Mutex
+	leases map[*PortLease]struct{}
+	handed []io.Closer
+	closed bool
+}
+
+// NewPortManager 创建端口管理器, tb 不为空时在测试结束时释放全部租约并关闭已交出的监听器
+func NewPortManager(tb testing.TB) *PortManager {
+	m := &PortManager{leases: make(map[*PortLease]struct{})}
+	if tb != nil {
+		tb.Cleanup(m.Close)
+	}
+	return m
+}
+
+func (m *PortManager) host() string {
+	if m.Host != "" {
+		return m.Host
+	}
+	return "127.0.0.1"
+}
+
+// GetFreePort 返回一个当前空闲的端口. 监听在返回前已关闭, 端口可能被其他进程抢占, 优先使用 Reserve
+func (m *PortManager) GetFreePort() (int, error) {
+	ln, err := net.Listen("tcp", net.JoinHostPort(m.host(), "0"))
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+// Reserve 监听一个空闲端口并保持打开, 返回有效期为 ttl 的租约; ttl <= 0 时取 DefaultLeaseTTL.
+// 到期前未交出的租约被自动释放
+func (m *PortManager) Reserve(ttl time.Duration) (*PortLease, error) {
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(m.host(), "0"))
+	if err != nil {
+		return nil, err
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	l := &PortLease{Port: addr.Port, Addr: addr.String(), m: m, ln: ln.(*net.TCPListener)}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		ln.Close()
+		return nil, ErrLeaseExpired
+	}
+	m.leases[l] = struct{}{}
+	l.expires = time.Now().Add(ttl)
+	l.timer = time.AfterFunc(ttl, l.Release)
+	return l, nil
+}
+
+// Leases 返回尚未交出也未释放的租约数量
+func (m *PortManager) Leases() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.leases)
+}
+
+// Close 释放所有租约并关闭已交出的监听器和文件
+func (m *PortManager) Close() {
+	m.mu.Lock()
+	m.closed = true
+	leases := m.leases
+	handed := m.handed
+	m.leases, m.handed = make(map[*PortLease]struct{}), nil
+	m.mu.Unlock()
+	for l := range leases {
+		l.timer.Stop()
+		l.ln.Close()
+	}
+	for _, c := range handed {
+		c.Close()
+	}
+}
+
+// PortLease 一个保持监听的端口
+type PortLease struct {
+	Port int
+	// Addr 形如 "127.0.0.1:port"
+	Addr string
+
+	m       *PortManager
+	ln      *net.TCPListener
+	expires time.Time
+	timer   *time.Timer
+	taken   bool
+}
+
+// take 结束租约, 返回监听器的所有权; 调用方持有 m.mu
+func (l *PortLease) take() (*net.TCPListener, error) {
+	if _, ok := l.m.leases[l]; !ok {
+		if l.taken {
+			return nil, ErrLeaseTaken
+		}
+		return nil, ErrLeaseExpired
+	}
+	delete(l.m.leases, l)
+	l.timer.Stop()
+	l.taken = true
+	return l.ln, nil
+}
+
+// Listener 交出仍在监听的 net.Listener, 租约随之结束; 监听器在 PortManager 关闭时一并关闭
+func (l *PortLease) Listener() (net.Listener, error) {
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+	ln, err := l.take()
+	if err != nil {
+		return nil, err
+	}
+	l.m.handed = append(l.m.handed, ln)
+	return ln, nil
+}
+
+// File 交出监听 socket 的文件描述符副本, 用于传给子进程 (如 exec.Cmd.ExtraFiles);
+// 本进程内的监听器随即关闭, socket 由返回的文件保持打开
+func (l *PortLease) File() (*os.File, error) {
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+	ln, err := l.take()
+	if err != nil {
+		return nil, err
+	}
+	f, err := ln.File()
+	ln.Close()
+	if err != nil {
+		return nil, err
+	}
+	l.m.handed = append(l.m.handed, f)
+	return f, nil
+}
+
+// Renew 将租约延长为从现在起 ttl
+func (l *PortLease) Renew(ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+	if _, ok := l.m.leases[l]; !ok {
+		return ErrLeaseExpired
+	}
+	l.expires = time.Now().Add(ttl)
+	l.timer.Reset(ttl)
+	return nil
+}
+
+// Expires 返回租约的到期时间
+func (l *PortLease) Expires() time.Time {
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+	return l.expires
+}
+
+// Release 提前释放租约并关闭监听, 已交出或已释放时不做任何事
+func (l *PortLease) Release() {
+	l.m.mu.Lock()
+	_, ok := l.m.leases[l]
+	delete(l.m.leases, l)
+	l.m.mu.Unlock()
+	if ok {
+		l.timer.Stop()
+		l.ln.Close()
+	}
+}
@@ -0,0 +1,126 @@
+package testing
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPortManagerGetFreePort(t *testing.T) {
+	m := NewPortManager(t)
+	port, err := m.GetFreePort()
+	if err != nil || port == 0 {
+		t.Fatalf("GetFreePort = %d, %v", port, err)
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("port %d not free: %v", port, err)
+	}
+	ln.Close()
+}
+
+func TestPortLeaseListener(t *testing.T) {
+	m := NewPortManager(t)
+	l, err := m.Reserve(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Leases() != 1 || time.Until(l.Expires()) < DefaultLeaseTTL-time.Second {
+		t.Fatalf("Leases = %d, expires in %v", m.Leases(), time.Until(l.Expires()))
+	}
+	// 租约期间端口保持监听, 其他人无法占用
+	if ln, err := net.Listen("tcp", l.Addr); err == nil {
+		ln.Close()
+		t.Fatal("reserved port could be bound again")
+	}
+
+	ln, err := l.Listener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Leases() != 0 {
+		t.Fatalf("Leases after hand-over = %d", m.Leases())
+	}
+	if _, err := l.Listener(); !errors.Is(err, ErrLeaseTaken) {
+		t.Fatalf("second Listener = %v", err)
+	}
+	if err := l.Renew(time.Minute); !errors.Is(err, ErrLeaseExpired) {
+		t.Fatalf("Renew after hand-over = %v", err)
+	}
+	go func() {
+		if c, err := net.Dial("tcp", l.Addr); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	// 关闭管理器时一并关闭已交出的监听器
+	m.Close()
+	if _, err := ln.Accept(); err == nil {
+		t.Fatal("handed listener still open after Close")
+	}
+	if _, err := m.Reserve(0); !errors.Is(err, ErrLeaseExpired) {
+		t.Fatalf("Reserve after Close = %v", err)
+	}
+}
+
+func TestPortLeaseExpiry(t *testing.T) {
+	m := NewPortManager(t)
+	l, err := m.Reserve(50 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Renew(200 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if m.Leases() != 1 {
+		t.Fatal("renewed lease expired at the original deadline")
+	}
+	NewHelper(t).AssertEventually(func() bool { return m.Leases() == 0 }, 2*time.Second, 10*time.Millisecond, "lease expiry")
+	if _, err := l.Listener(); !errors.Is(err, ErrLeaseExpired) {
+		t.Fatalf("Listener after expiry = %v", err)
+	}
+	ln, err := net.Listen("tcp", l.Addr)
+	if err != nil {
+		t.Fatalf("expired lease still holds the port: %v", err)
+	}
+	ln.Close()
+
+	l2, _ := m.Reserve(0)
+	l2.Release()
+	l2.Release()
+	if _, err := l2.Listener(); !errors.Is(err, ErrLeaseExpired) {
+		t.Fatalf("Listener after Release = %v", err)
+	}
+}
+
+func TestPortLeaseFile(t *testing.T) {
+	m := NewPortManager(t)
+	l, err := m.Reserve(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := l.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 文件描述符仍在监听, 可以还原为监听器 (相当于子进程继承后的做法)
+	ln, err := net.FileListener(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if ln.Addr().String() != l.Addr {
+		t.Fatalf("restored listener on %v, want %s", ln.Addr(), l.Addr)
+	}
+	if _, err := l.File(); !errors.Is(err, ErrLeaseTaken) {
+		t.Fatalf("second File = %v", err)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build race
+
+package testing
+
+// RaceEnabled 当前是否以 -race 构建
+const RaceEnabled = true
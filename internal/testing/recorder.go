@@ -0,0 +1,122 @@
+package testing
+
+/*
+	响应录制器: 实现 server.ResponseWriter, 记录状态码、头部、消息体、trailer 以及 Flush/Hijack 调用,
+	无需网络即可对处理器和中间件做单元测试
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/server"
+)
+
+// ResponseRecorder 记录处理器写出的响应, 非并发安全
+type ResponseRecorder struct {
+	// Code WriteHeader 写出的状态码, 未写出时为 200
+	Code int
+	// HeaderMap 处理器通过 Header 修改的头部
+	HeaderMap common.Header
+	// Body 写出的消息体
+	Body *bytes.Buffer
+	// Flushes Flush 的调用次数
+	Flushes int
+	// HijackAttempts Hijack 的调用次数
+	HijackAttempts int
+	// HijackConn 不为空时 Hijack 返回该连接, 否则返回 server.ErrHijackUnsupported
+	HijackConn net.Conn
+
+	wroteHeader bool
+	hijacked    bool
+	snapHeader  common.Header
+	trailer     common.Header
+}
+
+var (
+	_ server.ResponseWriter = (*ResponseRecorder)(nil)
+	_ server.Flusher        = (*ResponseRecorder)(nil)
+	_ server.Hijacker       = (*ResponseRecorder)(nil)
+	_ server.TrailerWriter  = (*ResponseRecorder)(nil)
+)
+
+// NewRecorder 创建录制器
+func NewRecorder() *ResponseRecorder {
+	return &ResponseRecorder{Code: common.StatusOK, HeaderMap: make(common.Header), Body: new(bytes.Buffer)}
+}
+
+// Header 实现 server.ResponseWriter
+func (r *ResponseRecorder) Header() common.Header { return r.HeaderMap }
+
+// WriteHeader 记录状态码并保存此刻的头部快照, 重复调用被忽略
+func (r *ResponseRecorder) WriteHeader(code int) {
+	if r.wroteHeader || r.hijacked {
+		return
+	}
+	r.wroteHeader = true
+	r.Code = code
+	r.snapHeader = r.HeaderMap.Clone()
+}
+
+// Write 记录消息体
+func (r *ResponseRecorder) Write(p []byte) (int, error) {
+	if r.hijacked {
+		return 0, server.ErrHijacked
+	}
+	r.WriteHeader(common.StatusOK)
+	return r.Body.Write(p)
+}
+
+// Flush 记录一次 Flush, 未写出头部时先以 200 写出
+func (r *ResponseRecorder) Flush() {
+	r.WriteHeader(common.StatusOK)
+	r.Flushes++
+}
+
+// Hijack 记录接管尝试
+func (r *ResponseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.HijackAttempts++
+	if r.HijackConn == nil {
+		return nil, nil, server.ErrHijackUnsupported
+	}
+	if r.hijacked {
+		return nil, nil, server.ErrHijacked
+	}
+	r.hijacked = true
+	c := r.HijackConn
+	return c, bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c)), nil
+}
+
+// Hijacked 报告连接是否已被接管
+func (r *ResponseRecorder) Hijacked() bool { return r.hijacked }
+
+// Trailer 实现 server.TrailerWriter
+func (r *ResponseRecorder) Trailer() common.Header {
+	if r.trailer == nil {
+		r.trailer = make(common.Header)
+	}
+	return r.trailer
+}
+
+// Flushed 报告是否调用过 Flush
+func (r *ResponseRecorder) Flushed() bool { return r.Flushes > 0 }
+
+// Result 返回处理器写出的响应, 头部为写出头部时的快照; 应在处理器返回后调用
+func (r *ResponseRecorder) Result() *message.Response {
+	resp := message.NewResponse(r.Code)
+	resp.Header = r.HeaderMap.Clone()
+	if r.snapHeader != nil {
+		resp.Header = r.snapHeader.Clone()
+	}
+	body := bytes.Clone(r.Body.Bytes())
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	if len(r.trailer) > 0 {
+		resp.Trailer = r.trailer.Clone()
+	}
+	return resp
+}
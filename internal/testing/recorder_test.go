@@ -0,0 +1,74 @@
+package testing
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/narcilee7/http-stack/pkg/http/server"
+)
+
+func TestRecorderSnapshotsHeader(t *testing.T) {
+	r := NewRecorder()
+	r.Header().Set("Content-Type", "text/plain")
+	r.WriteHeader(202)
+	r.WriteHeader(500)
+	// 写出头部之后的修改不应出现在结果中
+	r.Header().Set("X-Late", "1")
+	io.WriteString(r, "hello ")
+	io.WriteString(r, "world")
+	r.Trailer().Set("X-Checksum", "abc")
+
+	resp := r.Result()
+	if resp.StatusCode != 202 || r.Code != 202 {
+		t.Fatalf("status = %d, want 202", resp.StatusCode)
+	}
+	if resp.Header.Get("Content-Type") != "text/plain" || resp.Header.Get("X-Late") != "" {
+		t.Fatalf("header = %v", resp.Header)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	if string(b) != "hello world" || resp.ContentLength != 11 {
+		t.Fatalf("body = %q (%d)", b, resp.ContentLength)
+	}
+	if resp.Trailer.Get("X-Checksum") != "abc" {
+		t.Fatalf("trailer = %v", resp.Trailer)
+	}
+}
+
+func TestRecorderImplicitStatus(t *testing.T) {
+	r := NewRecorder()
+	if r.Flushed() {
+		t.Fatal("fresh recorder reports Flushed")
+	}
+	r.Flush()
+	r.Flush()
+	r.WriteHeader(404)
+	if r.Code != 200 || r.Flushes != 2 || !r.Flushed() {
+		t.Fatalf("Code=%d Flushes=%d", r.Code, r.Flushes)
+	}
+}
+
+func TestRecorderHijack(t *testing.T) {
+	r := NewRecorder()
+	if _, _, err := r.Hijack(); !errors.Is(err, server.ErrHijackUnsupported) {
+		t.Fatalf("Hijack without conn = %v", err)
+	}
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	r.HijackConn = c1
+	conn, rw, err := r.Hijack()
+	if err != nil || conn != c1 || rw == nil || !r.Hijacked() {
+		t.Fatalf("Hijack = %v, %v, %v", conn, rw, err)
+	}
+	if _, _, err := r.Hijack(); !errors.Is(err, server.ErrHijacked) {
+		t.Fatalf("second Hijack = %v", err)
+	}
+	if _, err := r.Write([]byte("x")); !errors.Is(err, server.ErrHijacked) {
+		t.Fatalf("Write after Hijack = %v", err)
+	}
+	if r.HijackAttempts != 3 {
+		t.Fatalf("HijackAttempts = %d, want 3", r.HijackAttempts)
+	}
+}
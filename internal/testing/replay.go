@@ -0,0 +1,516 @@
+package testing
+
+/*
+	原始流量回放: 从 pcap 文件或十六进制转储中提取客户端发往服务端的 TCP 载荷,
+	经本地回环连接逐段发给连接处理器 (tcp.Handler), 记录输出、panic 和超时,
+	用于把线上抓到的畸形流量固化为回归测试
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/tcp"
+)
+
+var (
+	// ErrBadCapture 抓包或转储格式错误
+	ErrBadCapture = errors.New("testing: malformed capture")
+	// ErrPcapng 只支持经典 pcap 格式
+	ErrPcapng = errors.New("testing: pcapng captures are not supported, convert with editcap -F pcap")
+)
+
+// DefaultReplayTimeout 单次回放的默认超时
+const DefaultReplayTimeout = 5 * time.Second
+
+// Capture 一条连接上客户端发往服务端的数据, 按抓包时的分段保存
+type Capture struct {
+	// Name 来源描述, 如 "a.pcap 10.0.0.1:51234"
+	Name     string
+	Segments [][]byte
+}
+
+// Payload 返回拼接后的完整数据
+func (c *Capture) Payload() []byte {
+	return bytes.Join(c.Segments, nil)
+}
+
+// ParseHexDump 解析十六进制转储, 空行分隔数据段, '#' 开头的行为注释.
+// 支持纯十六进制、xxd、hexdump -C (含 Go 的 hex.Dump) 和 Wireshark 的十六进制复制格式
+func ParseHexDump(r io.Reader) (*Capture, error) {
+	c := &Capture{}
+	var seg []byte
+	flush := func() {
+		if len(seg) > 0 {
+			c.Segments = append(c.Segments, seg)
+			seg = nil
+		}
+	}
+	sc := bufio.NewScanner(r)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		switch {
+		case strings.TrimSpace(line) == "":
+			flush()
+			continue
+		case strings.HasPrefix(strings.TrimSpace(line), "#"):
+			continue
+		}
+		b, err := parseHexLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrBadCapture, lineNo, err)
+		}
+		seg = append(seg, b...)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return c, nil
+}
+
+// parseHexLine 去掉偏移量列和 ASCII 列后解码
+func parseHexLine(line string) ([]byte, error) {
+	line = strings.TrimLeft(line, " \t")
+	asciiSep := ""
+	if i := strings.IndexAny(line, " \t"); i > 0 {
+		first, rest := line[:i], line[i:]
+		switch {
+		case strings.HasSuffix(first, ":") && isHex(first[:len(first)-1]):
+			// xxd: "00000000: 4745 5420  GET "
+			line, asciiSep = rest[1:], "  "
+		case strings.HasPrefix(rest, "  ") && len(first) >= 4 && isHex(first):
+			// hexdump -C: "00000000  47 45  |GE|"; Wireshark: "0000   47 45   GE"
+			line, asciiSep = strings.TrimLeft(rest, " "), "   "
+		}
+	}
+	if i := strings.IndexByte(line, '|'); i >= 0 {
+		line = line[:i]
+	} else if asciiSep != "" {
+		if i := strings.Index(line, asciiSep); i >= 0 {
+			line = line[:i]
+		}
+	}
+	var out []byte
+	for _, f := range strings.Fields(line) {
+		b, err := hex.DecodeString(f)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+	}
+	return out, nil
+}
+
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if unhex(s[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func unhex(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c - 'a' + 10)
+	case 'A' <= c && c <= 'F':
+		return int(c - 'A' + 10)
+	}
+	return -1
+}
+
+// pcap 链路类型
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkRawAlt   = 12
+	linkLinuxSLL = 113
+)
+
+type pcapFlow struct {
+	key  string
+	isn  uint32
+	syn  bool
+	segs []pcapSegment
+}
+
+type pcapSegment struct {
+	seq  uint32
+	data []byte
+}
+
+// ReadPcap 解析经典 pcap 文件, 按连接重组发往 serverPort 的 TCP 载荷 (去除重传和重叠), 按首次出现的顺序返回.
+// serverPort 为 0 时取第一个 SYN 的目标端口. 支持以太网 (含 VLAN)、Linux cooked、回环和裸 IP 链路, IP 分片被忽略
+func ReadPcap(r io.Reader, serverPort int) ([]*Capture, error) {
+	var gh [24]byte
+	if _, err := io.ReadFull(r, gh[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadCapture, err)
+	}
+	var order binary.ByteOrder
+	switch {
+	case binary.LittleEndian.Uint32(gh[:]) == 0x0a0d0d0a:
+		return nil, ErrPcapng
+	case binary.LittleEndian.Uint32(gh[:]) == 0xa1b2c3d4 || binary.LittleEndian.Uint32(gh[:]) == 0xa1b23c4d:
+		order = binary.LittleEndian
+	case binary.BigEndian.Uint32(gh[:]) == 0xa1b23c4d || binary.BigEndian.Uint32(gh[:]) == 0xa1b2c3d4:
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("%w: unknown pcap magic %x", ErrBadCapture, gh[:4])
+	}
+	link := order.Uint32(gh[20:]) & 0x0fffffff
+
+	flows := make(map[string]*pcapFlow)
+	var ordered []*pcapFlow
+	var rh [16]byte
+	for {
+		if _, err := io.ReadFull(r, rh[:]); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("%w: %v", ErrBadCapture, err)
+		}
+		n := order.Uint32(rh[8:])
+		if n > 1<<24 {
+			return nil, fmt.Errorf("%w: record length %d", ErrBadCapture, n)
+		}
+		pkt := make([]byte, n)
+		if _, err := io.ReadFull(r, pkt); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadCapture, err)
+		}
+		ip, ok := linkPayload(link, pkt)
+		if !ok {
+			continue
+		}
+		src, dst, seg, ok := parseIPTCP(ip)
+		if !ok {
+			continue
+		}
+		flags := seg[13]
+		syn, ack := flags&0x02 != 0, flags&0x10 != 0
+		if serverPort == 0 && syn && !ack {
+			serverPort = int(binary.BigEndian.Uint16(seg[2:]))
+		}
+		if serverPort == 0 || int(binary.BigEndian.Uint16(seg[2:])) != serverPort {
+			continue
+		}
+		key := net.JoinHostPort(src.String(), fmt.Sprint(binary.BigEndian.Uint16(seg[0:]))) + " -> " +
+			net.JoinHostPort(dst.String(), fmt.Sprint(serverPort))
+		f := flows[key]
+		if f == nil || syn && !ack && f.syn {
+			// 同一四元组上的新 SYN 开始新连接
+			f = &pcapFlow{key: key}
+			flows[key] = f
+			ordered = append(ordered, f)
+		}
+		seq := binary.BigEndian.Uint32(seg[4:])
+		if syn {
+			f.isn, f.syn = seq+1, true
+			continue
+		}
+		if data := seg[int(seg[12]>>4)*4:]; len(data) > 0 {
+			f.segs = append(f.segs, pcapSegment{seq: seq, data: data})
+		}
+	}
+
+	var caps []*Capture
+	for _, f := range ordered {
+		if c := f.reassemble(); len(c.Segments) > 0 {
+			caps = append(caps, c)
+		}
+	}
+	return caps, nil
+}
+
+// reassemble 按序号排序并裁掉已发送过的部分; 没有抓到 SYN 时以最小序号为起点
+func (f *pcapFlow) reassemble() *Capture {
+	c := &Capture{Name: f.key}
+	if len(f.segs) == 0 {
+		return c
+	}
+	base := f.isn
+	if !f.syn {
+		base = f.segs[0].seq
+		for _, s := range f.segs {
+			if int32(s.seq-base) < 0 {
+				base = s.seq
+			}
+		}
+	}
+	slices.SortStableFunc(f.segs, func(a, b pcapSegment) int {
+		return int(int64(a.seq-base) - int64(b.seq-base))
+	})
+	var next uint32
+	for _, s := range f.segs {
+		off := s.seq - base
+		end := off + uint32(len(s.data))
+		if end <= next {
+			continue
+		}
+		if off < next {
+			s.data = s.data[next-off:]
+		}
+		c.Segments = append(c.Segments, s.data)
+		next = end
+	}
+	return c
+}
+
+// linkPayload 去掉链路层头部, 返回 IP 包
+func linkPayload(link uint32, pkt []byte) ([]byte, bool) {
+	switch link {
+	case linkEthernet:
+		if len(pkt) < 14 {
+			return nil, false
+		}
+		etype, p := binary.BigEndian.Uint16(pkt[12:]), pkt[14:]
+		for etype == 0x8100 || etype == 0x88a8 {
+			if len(p) < 4 {
+				return nil, false
+			}
+			etype, p = binary.BigEndian.Uint16(p[2:]), p[4:]
+		}
+		return p, etype == 0x0800 || etype == 0x86dd
+	case linkLinuxSLL:
+		if len(pkt) < 16 {
+			return nil, false
+		}
+		return pkt[16:], true
+	case linkNull:
+		if len(pkt) < 4 {
+			return nil, false
+		}
+		return pkt[4:], true
+	case linkRaw, linkRawAlt:
+		return pkt, true
+	}
+	return nil, false
+}
+
+// parseIPTCP 解析 IPv4/IPv6 头部, 返回地址和 TCP 段; 非 TCP、分片和截断的包返回 false
+func parseIPTCP(p []byte) (src, dst net.IP, seg []byte, ok bool) {
+	if len(p) < 1 {
+		return nil, nil, nil, false
+	}
+	switch p[0] >> 4 {
+	case 4:
+		if len(p) < 20 || p[9] != 6 {
+			return nil, nil, nil, false
+		}
+		if binary.BigEndian.Uint16(p[6:])&0x3fff != 0 {
+			return nil, nil, nil, false
+		}
+		hl, total := int(p[0]&0x0f)*4, int(binary.BigEndian.Uint16(p[2:]))
+		if total > len(p) || total < hl {
+			total = len(p)
+		}
+		src, dst, seg = net.IP(p[12:16]), net.IP(p[16:20]), p[hl:total]
+	case 6:
+		if len(p) < 40 || p[6] != 6 {
+			return nil, nil, nil, false
+		}
+		total := 40 + int(binary.BigEndian.Uint16(p[4:]))
+		src, dst, seg = net.IP(p[8:24]), net.IP(p[24:40]), p[40:min(total, len(p))]
+	default:
+		return nil, nil, nil, false
+	}
+	if len(seg) < 20 || len(seg) < int(seg[12]>>4)*4 {
+		return nil, nil, nil, false
+	}
+	return src, dst, seg, true
+}
+
+// LoadCaptures 按内容识别格式读取文件: pcap 按 serverPort 重组, 其余按十六进制转储解析
+func LoadCaptures(path string, serverPort int) ([]*Capture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(path)
+	if len(data) >= 4 && isPcapMagic(data[:4]) {
+		caps, err := ReadPcap(bytes.NewReader(data), serverPort)
+		for _, c := range caps {
+			c.Name = name + " " + c.Name
+		}
+		return caps, err
+	}
+	c, err := ParseHexDump(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	c.Name = name
+	return []*Capture{c}, nil
+}
+
+func isPcapMagic(b []byte) bool {
+	le, be := binary.LittleEndian.Uint32(b), binary.BigEndian.Uint32(b)
+	return le == 0x0a0d0d0a || le == 0xa1b2c3d4 || le == 0xa1b23c4d || be == 0xa1b2c3d4 || be == 0xa1b23c4d
+}
+
+// ReplayOptions 回放参数
+type ReplayOptions struct {
+	// Timeout 整次回放的超时, 0 表示 DefaultReplayTimeout
+	Timeout time.Duration
+	// SegmentDelay 相邻数据段之间的间隔, 使处理器按抓包时的分段收到数据; 为 0 时数据段可能被合并
+	SegmentDelay time.Duration
+	// KeepOpen 发送完后不半关闭连接, 等待处理器自行结束或超时
+	KeepOpen bool
+}
+
+// ReplayResult 一次回放的结果
+type ReplayResult struct {
+	// Output 处理器写回客户端的全部数据
+	Output []byte
+	// Panic 处理器 panic 的值, 未 panic 时为 nil
+	Panic any
+	Stack []byte
+	// TimedOut 处理器在超时前没有结束
+	TimedOut bool
+	Duration time.Duration
+}
+
+// Replay 建立本地回环连接, 将 c 的数据逐段发给 h, 返回处理器的输出.
+// 处理器的 panic 会被捕获并记录在结果中
+func Replay(h tcp.Handler, c *Capture, opts ReplayOptions) (*ReplayResult, error) {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultReplayTimeout
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	server, err := ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	defer server.Close()
+
+	res := &ReplayResult{}
+	start := time.Now()
+	type panicked struct {
+		v     any
+		stack []byte
+	}
+	handled := make(chan panicked, 1)
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				handled <- panicked{v, debug.Stack()}
+				return
+			}
+			handled <- panicked{}
+		}()
+		conn := tcp.NewConn(replayConn{server.(*net.TCPConn)})
+		defer conn.Close()
+		h.ServeConn(conn)
+	}()
+	go func() {
+		for i, seg := range c.Segments {
+			if i > 0 && opts.SegmentDelay > 0 {
+				time.Sleep(opts.SegmentDelay)
+			}
+			if _, err := client.Write(seg); err != nil {
+				return
+			}
+		}
+		if !opts.KeepOpen {
+			client.(*net.TCPConn).CloseWrite()
+		}
+	}()
+	var out bytes.Buffer
+	read := make(chan struct{})
+	go func() {
+		io.Copy(&out, client)
+		close(read)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case p := <-handled:
+		res.Panic, res.Stack = p.v, p.stack
+		select {
+		case <-read:
+		case <-timer.C:
+			res.TimedOut = true
+		}
+	case <-timer.C:
+		res.TimedOut = true
+	}
+	server.Close()
+	client.Close()
+	<-read
+	res.Output = out.Bytes()
+	res.Duration = time.Since(start)
+	return res, nil
+}
+
+// replayConn 处理器关闭连接时只发送 FIN: 直接关闭会因未读完的输入发送 RST, 使客户端丢失已写出的响应
+type replayConn struct{ *net.TCPConn }
+
+func (c replayConn) Close() error { return c.CloseWrite() }
+
+// ReplayFiles 对匹配 pattern 的每个抓包文件中的每条连接执行一次子测试 (名称为 Capture.Name).
+// check 为空时只要求处理器不 panic 且在超时前结束
+func ReplayFiles(t *testing.T, h tcp.Handler, pattern string, serverPort int, opts ReplayOptions, check func(t *testing.T, c *Capture, res *ReplayResult)) {
+	t.Helper()
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no captures match %s", pattern)
+	}
+	for _, file := range files {
+		caps, err := LoadCaptures(file, serverPort)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		for _, c := range caps {
+			t.Run(c.Name, func(t *testing.T) {
+				res, err := Replay(h, c, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if check != nil {
+					check(t, c, res)
+					return
+				}
+				if res.Panic != nil {
+					t.Fatalf("handler panicked: %v\n%s", res.Panic, res.Stack)
+				}
+				if res.TimedOut {
+					t.Fatalf("handler did not finish within timeout, output %q", res.Output)
+				}
+			})
+		}
+	}
+}
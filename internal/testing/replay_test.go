@@ -0,0 +1,183 @@
+package testing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/tcp"
+)
+
+func TestParseHexDumpFormats(t *testing.T) {
+	payload := []byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	for name, dump := range map[string]string{
+		"plain":     hex.EncodeToString(payload),
+		"go":        hex.Dump(payload),
+		"xxd":       "00000000: 4745 5420 2f20 4854 5450 2f31 2e31 0d0a  GET / HTTP/1.1..\n00000010: 486f 7374 3a20 780d 0a0d 0a              Host: x....",
+		"wireshark": "0000   47 45 54 20 2f 20 48 54 54 50 2f 31 2e 31 0d 0a   GET / HTTP/1.1..\n0010   48 6f 73 74 3a 20 78 0d 0a 0d 0a                  Host: x....",
+	} {
+		c, err := ParseHexDump(strings.NewReader(dump))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(c.Payload(), payload) || len(c.Segments) != 1 {
+			t.Errorf("%s: payload = %q in %d segments", name, c.Payload(), len(c.Segments))
+		}
+	}
+
+	c, err := ParseHexDump(strings.NewReader("# first segment\n6162\n\n\n# second\n63 64\n"))
+	if err != nil || len(c.Segments) != 2 || string(c.Segments[0]) != "ab" || string(c.Segments[1]) != "cd" {
+		t.Fatalf("segments = %q, %v", c.Segments, err)
+	}
+	if _, err := ParseHexDump(strings.NewReader("zz\n")); !errors.Is(err, ErrBadCapture) {
+		t.Fatalf("bad hex = %v", err)
+	}
+}
+
+// pcapWriter 生成以太网链路的经典 pcap, 用于构造测试抓包
+type pcapWriter struct{ bytes.Buffer }
+
+func newPcapWriter() *pcapWriter {
+	w := &pcapWriter{}
+	var gh [24]byte
+	binary.LittleEndian.PutUint32(gh[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(gh[4:], 2)
+	binary.LittleEndian.PutUint16(gh[6:], 4)
+	binary.LittleEndian.PutUint32(gh[16:], 65535)
+	binary.LittleEndian.PutUint32(gh[20:], linkEthernet)
+	w.Write(gh[:])
+	return w
+}
+
+// packet 写入一个 IPv4/TCP 包, flags 为 TCP 标志位
+func (w *pcapWriter) packet(src, dst [4]byte, sport, dport uint16, seq uint32, flags byte, data string, vlan bool) {
+	tcpSeg := make([]byte, 20, 20+len(data))
+	binary.BigEndian.PutUint16(tcpSeg[0:], sport)
+	binary.BigEndian.PutUint16(tcpSeg[2:], dport)
+	binary.BigEndian.PutUint32(tcpSeg[4:], seq)
+	tcpSeg[12] = 5 << 4
+	tcpSeg[13] = flags
+	tcpSeg = append(tcpSeg, data...)
+
+	ip := make([]byte, 20, 20+len(tcpSeg))
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcpSeg)))
+	ip[8], ip[9] = 64, 6
+	copy(ip[12:], src[:])
+	copy(ip[16:], dst[:])
+	ip = append(ip, tcpSeg...)
+
+	eth := make([]byte, 12, 18+len(ip))
+	if vlan {
+		eth = append(eth, 0x81, 0x00, 0x00, 0x07)
+	}
+	eth = append(eth, 0x08, 0x00)
+	eth = append(eth, ip...)
+
+	var rh [16]byte
+	binary.LittleEndian.PutUint32(rh[8:], uint32(len(eth)))
+	binary.LittleEndian.PutUint32(rh[12:], uint32(len(eth)))
+	w.Write(rh[:])
+	w.Write(eth)
+}
+
+func TestReadPcapReassembles(t *testing.T) {
+	cli, srv := [4]byte{10, 0, 0, 1}, [4]byte{10, 0, 0, 2}
+	const syn, ack = 0x02, 0x10
+	w := newPcapWriter()
+	w.packet(cli, srv, 5000, 80, 100, syn, "", false)
+	w.packet(srv, cli, 80, 5000, 900, syn|ack, "", false)
+	// 乱序、重传和部分重叠
+	w.packet(cli, srv, 5000, 80, 106, ack, "world", false)
+	w.packet(cli, srv, 5000, 80, 101, ack, "hello", true)
+	w.packet(cli, srv, 5000, 80, 101, ack, "hello", false)
+	w.packet(cli, srv, 5000, 80, 109, ack, "ld!", false)
+	w.packet(srv, cli, 80, 5000, 901, ack, "response ignored", false)
+	// 第二条连接没有抓到 SYN
+	w.packet([4]byte{10, 0, 0, 3}, srv, 6000, 80, 7000, ack, "second", false)
+	// 其他端口
+	w.packet(cli, srv, 5001, 443, 1, ack, "tls", false)
+
+	caps, err := ReadPcap(bytes.NewReader(w.Bytes()), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(caps) != 2 {
+		t.Fatalf("got %d captures", len(caps))
+	}
+	if got := string(caps[0].Payload()); got != "helloworld!" || caps[0].Name != "10.0.0.1:5000 -> 10.0.0.2:80" {
+		t.Fatalf("first capture %q = %q", caps[0].Name, got)
+	}
+	if got := string(caps[1].Payload()); got != "second" {
+		t.Fatalf("second capture = %q", got)
+	}
+
+	if _, err := ReadPcap(bytes.NewReader([]byte{0x0a, 0x0d, 0x0d, 0x0a, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}), 0); !errors.Is(err, ErrPcapng) {
+		t.Fatalf("pcapng = %v", err)
+	}
+	if _, err := ReadPcap(bytes.NewReader(make([]byte, 24)), 0); !errors.Is(err, ErrBadCapture) {
+		t.Fatalf("bad magic = %v", err)
+	}
+	if _, err := ReadPcap(bytes.NewReader(w.Bytes()[:w.Len()-3]), 0); !errors.Is(err, ErrBadCapture) {
+		t.Fatalf("truncated record = %v", err)
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.pcap"), w.Bytes(), 0o644)
+	os.WriteFile(filepath.Join(dir, "b.hex"), []byte("6869\n"), 0o644)
+	caps, err = LoadCaptures(filepath.Join(dir, "a.pcap"), 80)
+	if err != nil || len(caps) != 2 || !strings.HasPrefix(caps[0].Name, "a.pcap ") {
+		t.Fatalf("LoadCaptures pcap = %v, %v", caps, err)
+	}
+	caps, err = LoadCaptures(filepath.Join(dir, "b.hex"), 80)
+	if err != nil || len(caps) != 1 || caps[0].Name != "b.hex" || string(caps[0].Payload()) != "hi" {
+		t.Fatalf("LoadCaptures hex = %v, %v", caps, err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	capture := &Capture{Segments: [][]byte{[]byte("abc"), []byte("def")}}
+	echo := tcp.HandlerFunc(func(c *tcp.Conn) { io.Copy(c, c) })
+	res, err := Replay(echo, capture, ReplayOptions{SegmentDelay: 10 * time.Millisecond})
+	if err != nil || string(res.Output) != "abcdef" || res.Panic != nil || res.TimedOut {
+		t.Fatalf("echo replay = %+v, %v", res, err)
+	}
+
+	res, _ = Replay(tcp.HandlerFunc(func(c *tcp.Conn) {
+		c.Write([]byte("partial"))
+		panic("boom")
+	}), capture, ReplayOptions{})
+	if res.Panic != "boom" || len(res.Stack) == 0 || string(res.Output) != "partial" {
+		t.Fatalf("panic replay = %+v", res)
+	}
+
+	res, _ = Replay(echo, capture, ReplayOptions{KeepOpen: true, Timeout: 50 * time.Millisecond})
+	if !res.TimedOut || string(res.Output) != "abcdef" {
+		t.Fatalf("KeepOpen replay = %+v", res)
+	}
+}
+
+func TestReplayFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "one.hex"), []byte("6869\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "two.hex"), []byte("6f6b\n"), 0o644)
+	var seen []string
+	ReplayFiles(t, tcp.HandlerFunc(func(c *tcp.Conn) { io.Copy(c, c) }), filepath.Join(dir, "*.hex"), 0, ReplayOptions{},
+		func(t *testing.T, c *Capture, res *ReplayResult) {
+			if !bytes.Equal(res.Output, c.Payload()) {
+				t.Errorf("%s: output %q", c.Name, res.Output)
+			}
+			seen = append(seen, c.Name)
+		})
+	if strings.Join(seen, ",") != "one.hex,two.hex" {
+		t.Fatalf("replayed %v", seen)
+	}
+}
@@ -0,0 +1,272 @@
+package testing
+
+/*
+	端到端场景: 用构造器声明请求序列、期望的响应和时间约束, 对运行中的服务器依次执行.
+	后续步骤可以通过 ${name} 引用前面步骤从响应中提取的值:
+
+	NewScenario("login").
+		Step("login", "POST", "/login").WithBody("user=a").
+		ExpectStatus(302).CaptureHeader("next", "Location").
+		Step("follow", "GET", "${next}").ExpectStatus(200).Within(100 * time.Millisecond).
+		Run(t, srv.URL, nil)
+*/
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/client"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+// Scenario 一组按顺序执行的步骤, 前一步失败时后续步骤不再执行
+type Scenario struct {
+	Name string
+	// Header 每个请求的默认头部, 步骤中的同名头部优先
+	Header common.Header
+	Steps  []*ScenarioStep
+}
+
+// ScenarioStep 场景中的一个请求及其期望
+type ScenarioStep struct {
+	scenario *Scenario
+
+	Name string
+	// Method 和 Path 中的 ${name} 会被替换为已提取的值, Path 相对于 Run 的 baseURL
+	Method string
+	Path   string
+	Header common.Header
+	Body   string
+	// Pause 发送请求前的等待时间
+	Pause time.Duration
+
+	// Status 期望的状态码, 0 表示不检查
+	Status int
+	// Headers 期望的头部值, 值为空串表示该头部不应出现
+	Headers map[string]string
+	// BodyEquals 非空时期望响应体与之完全相同
+	BodyEquals *string
+	// BodyContains 期望响应体包含的片段
+	BodyContains []string
+	// MaxLatency 和 MinLatency 限定从发送请求到读完响应体的耗时, 0 表示不限制
+	MaxLatency time.Duration
+	MinLatency time.Duration
+	// Captures 变量名到提取函数, 在检查通过后执行
+	Captures map[string]func(resp *message.Response, body []byte) string
+}
+
+// NewScenario 创建空场景
+func NewScenario(name string) *Scenario {
+	return &Scenario{Name: name}
+}
+
+// WithHeader 设置每个请求的默认头部
+func (s *Scenario) WithHeader(key, value string) *Scenario {
+	if s.Header == nil {
+		s.Header = make(common.Header)
+	}
+	s.Header.Set(key, value)
+	return s
+}
+
+// Step 追加一个步骤
+func (s *Scenario) Step(name, method, path string) *ScenarioStep {
+	st := &ScenarioStep{scenario: s, Name: name, Method: method, Path: path}
+	s.Steps = append(s.Steps, st)
+	return st
+}
+
+// Step 在同一场景中追加下一个步骤
+func (st *ScenarioStep) Step(name, method, path string) *ScenarioStep {
+	return st.scenario.Step(name, method, path)
+}
+
+// Run 见 Scenario.Run
+func (st *ScenarioStep) Run(t *testing.T, baseURL string, c *client.Client) {
+	t.Helper()
+	st.scenario.Run(t, baseURL, c)
+}
+
+// WithHeader 设置请求头部, 值中可以使用 ${name}
+func (st *ScenarioStep) WithHeader(key, value string) *ScenarioStep {
+	if st.Header == nil {
+		st.Header = make(common.Header)
+	}
+	st.Header.Add(key, value)
+	return st
+}
+
+// WithBody 设置请求体, 其中可以使用 ${name}
+func (st *ScenarioStep) WithBody(body string) *ScenarioStep {
+	st.Body = body
+	return st
+}
+
+// After 发送请求前先等待 d
+func (st *ScenarioStep) After(d time.Duration) *ScenarioStep {
+	st.Pause = d
+	return st
+}
+
+// ExpectStatus 期望状态码
+func (st *ScenarioStep) ExpectStatus(code int) *ScenarioStep {
+	st.Status = code
+	return st
+}
+
+// ExpectHeader 期望头部值, value 为空串表示该头部不应出现
+func (st *ScenarioStep) ExpectHeader(key, value string) *ScenarioStep {
+	if st.Headers == nil {
+		st.Headers = make(map[string]string)
+	}
+	st.Headers[key] = value
+	return st
+}
+
+// ExpectBody 期望响应体完全相同
+func (st *ScenarioStep) ExpectBody(body string) *ScenarioStep {
+	st.BodyEquals = &body
+	return st
+}
+
+// ExpectBodyContains 期望响应体包含 part
+func (st *ScenarioStep) ExpectBodyContains(part string) *ScenarioStep {
+	st.BodyContains = append(st.BodyContains, part)
+	return st
+}
+
+// Within 期望在 d 内完成
+func (st *ScenarioStep) Within(d time.Duration) *ScenarioStep {
+	st.MaxLatency = d
+	return st
+}
+
+// NotBefore 期望耗时不少于 d, 用于验证限流、延迟等行为
+func (st *ScenarioStep) NotBefore(d time.Duration) *ScenarioStep {
+	st.MinLatency = d
+	return st
+}
+
+// Capture 从响应中提取值保存为变量 name
+func (st *ScenarioStep) Capture(name string, fn func(resp *message.Response, body []byte) string) *ScenarioStep {
+	if st.Captures == nil {
+		st.Captures = make(map[string]func(*message.Response, []byte) string)
+	}
+	st.Captures[name] = fn
+	return st
+}
+
+// CaptureHeader 将响应头部 key 的值保存为变量 name
+func (st *ScenarioStep) CaptureHeader(name, key string) *ScenarioStep {
+	return st.Capture(name, func(resp *message.Response, _ []byte) string { return resp.Header.Get(key) })
+}
+
+// Run 依次以子测试执行各步骤, c 为空时使用默认客户端
+func (s *Scenario) Run(t *testing.T, baseURL string, c *client.Client) {
+	t.Helper()
+	if c == nil {
+		c = client.New()
+	}
+	vars := make(map[string]string)
+	for i, st := range s.Steps {
+		name := st.Name
+		if name == "" {
+			name = fmt.Sprintf("step%d", i+1)
+		}
+		if !t.Run(name, func(t *testing.T) { st.run(t, s, baseURL, c, vars) }) {
+			t.Fatalf("scenario %q stopped at step %q", s.Name, name)
+		}
+	}
+}
+
+func (st *ScenarioStep) run(t *testing.T, s *Scenario, baseURL string, c *client.Client, vars map[string]string) {
+	t.Helper()
+	expand := func(v string) string {
+		for k, val := range vars {
+			v = strings.ReplaceAll(v, "${"+k+"}", val)
+		}
+		return v
+	}
+	path := expand(st.Path)
+	if !strings.Contains(path, "://") {
+		path = strings.TrimSuffix(baseURL, "/") + path
+	}
+	var body io.Reader
+	if st.Body != "" {
+		body = strings.NewReader(expand(st.Body))
+	}
+	req, err := message.NewRequestWithContext(context.Background(), expand(st.Method), path, body)
+	if err != nil {
+		t.Fatalf("build request: %v", err)
+	}
+	for _, h := range []common.Header{s.Header, st.Header} {
+		for k, vs := range h {
+			req.Header.Del(k)
+			for _, v := range vs {
+				req.Header.Add(k, expand(v))
+			}
+		}
+	}
+
+	if st.Pause > 0 {
+		time.Sleep(st.Pause)
+	}
+	start := time.Now()
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.Method, path, err)
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("%s %s: read body: %v", req.Method, path, err)
+	}
+
+	if st.Status != 0 && resp.StatusCode != st.Status {
+		t.Errorf("status = %d, want %d", resp.StatusCode, st.Status)
+	}
+	for k, want := range st.Headers {
+		switch got, ok := resp.Header.Get(k), resp.Header.Has(k); {
+		case want == "" && ok:
+			t.Errorf("header %s = %q, want absent", k, got)
+		case want != "" && got != want:
+			t.Errorf("header %s = %q, want %q", k, got, want)
+		}
+	}
+	if st.BodyEquals != nil && !bytes.Equal(respBody, []byte(expand(*st.BodyEquals))) {
+		t.Errorf("body = %q, want %q", truncateForLog(respBody), expand(*st.BodyEquals))
+	}
+	for _, part := range st.BodyContains {
+		if !bytes.Contains(respBody, []byte(expand(part))) {
+			t.Errorf("body %q does not contain %q", truncateForLog(respBody), expand(part))
+		}
+	}
+	if st.MaxLatency > 0 && elapsed > st.MaxLatency {
+		t.Errorf("took %v, want at most %v", elapsed, st.MaxLatency)
+	}
+	if st.MinLatency > 0 && elapsed < st.MinLatency {
+		t.Errorf("took %v, want at least %v", elapsed, st.MinLatency)
+	}
+	if t.Failed() {
+		return
+	}
+	for name, fn := range st.Captures {
+		vars[name] = fn(resp, respBody)
+	}
+}
+
+// truncateForLog 截断过长的消息体, 避免失败信息刷屏
+func truncateForLog(b []byte) string {
+	const limit = 256
+	if len(b) <= limit {
+		return string(b)
+	}
+	return fmt.Sprintf("%s... (%d bytes)", b[:limit], len(b))
+}
@@ -0,0 +1,97 @@
+package testing
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+)
+
+func TestScenarioCapturesAndExpectations(t *testing.T) {
+	s, err := NewMockServer(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.HandleFunc("POST", "/login", func(req *message.Request, body []byte) *MockResponse {
+		if string(body) != "user=alice" {
+			return &MockResponse{Status: 403}
+		}
+		return &MockResponse{Status: 302, Header: map[string][]string{"Location": {"/home/alice"}, "X-Token": {"t-42"}}}
+	})
+	s.HandleFunc("GET", "/home/*", func(req *message.Request, _ []byte) *MockResponse {
+		return &MockResponse{Body: []byte("hello " + req.Header.Get("Authorization") + " " + req.Header.Get("X-Client"))}
+	})
+	s.Handle("GET", "/slow", &MockResponse{Delay: 50 * time.Millisecond, Body: []byte("ok")})
+
+	NewScenario("login").
+		WithHeader("X-Client", "default").
+		Step("login", "POST", "/login").WithBody("user=alice").
+		ExpectStatus(302).ExpectHeader("Location", "/home/alice").ExpectHeader("X-Missing", "").
+		CaptureHeader("next", "Location").CaptureHeader("token", "X-Token").
+		Step("follow", "GET", "${next}").WithHeader("Authorization", "Bearer ${token}").WithHeader("X-Client", "step").
+		ExpectBody("hello Bearer t-42 step").ExpectBodyContains("t-42").Within(5 * time.Second).
+		Step("", "GET", s.URL+"/slow").After(10 * time.Millisecond).NotBefore(40 * time.Millisecond).ExpectBody("ok").
+		Run(t, s.URL+"/", nil)
+
+	reqs := s.Requests()
+	if len(reqs) != 3 {
+		t.Fatalf("server saw %d requests, want 3", len(reqs))
+	}
+	if got := reqs[0].Header.Get("X-Client"); got != "default" {
+		t.Errorf("scenario default header = %q", got)
+	}
+	if reqs[1].Target != "/home/alice" {
+		t.Errorf("captured path = %q", reqs[1].Target)
+	}
+}
+
+// TestScenarioStopsOnFailure 失败的场景会让测试失败, 因此在子进程中运行并检查输出
+func TestScenarioStopsOnFailure(t *testing.T) {
+	if os.Getenv("SCENARIO_FAILING") == "1" {
+		s, err := NewMockServer(t)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Handle("GET", "/broken", &MockResponse{Status: 500, Body: []byte("boom")})
+		NewScenario("failing").
+			Step("broken", "GET", "/broken").ExpectStatus(200).ExpectBodyContains("fine").
+			Step("never", "GET", "/never").
+			Run(t, s.URL, nil)
+		t.Log("unreachable")
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestScenarioStopsOnFailure$", "-test.v")
+	cmd.Env = append(os.Environ(), "SCENARIO_FAILING=1")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("failing scenario passed:\n%s", out)
+	}
+	for _, want := range []string{
+		"status = 500, want 200",
+		`body "boom" does not contain "fine"`,
+		`scenario "failing" stopped at step "broken"`,
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	for _, unwanted := range []string{"never", "unreachable"} {
+		if strings.Contains(string(out), unwanted) {
+			t.Errorf("output contains %q:\n%s", unwanted, out)
+		}
+	}
+}
+
+func TestTruncateForLog(t *testing.T) {
+	if got := truncateForLog([]byte("short")); got != "short" {
+		t.Errorf("truncateForLog(short) = %q", got)
+	}
+	got := truncateForLog([]byte(strings.Repeat("x", 300)))
+	if !strings.HasPrefix(got, strings.Repeat("x", 256)+"...") || !strings.HasSuffix(got, "(300 bytes)") {
+		t.Errorf("truncateForLog(300) = %q", got)
+	}
+}
@@ -0,0 +1,206 @@
+package testing
+
+/*
+	并发压力测试: 多个 worker 同时起跑, 按权重随机选取操作并随机让出调度, 制造尽可能多的交错,
+	配合 -race 检查连接池、限流器、连接跟踪等共享结构. 随机种子打印在日志中, 以 -stress.seed 重放各 worker 的同一操作序列
+*/
+
+import (
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var stressSeed = flag.Uint64("stress.seed", 0, "seed for Stress, 0 picks a random one")
+
+// StressOp 压力测试中的一种操作
+type StressOp struct {
+	Name string
+	// Weight 被选中的相对权重, <= 0 时取 1
+	Weight int
+	// Fn worker 为执行者编号, 可用于区分各自的数据; 返回错误视为失败
+	Fn func(worker int) error
+}
+
+// StressConfig 压力测试参数, 零值可用
+type StressConfig struct {
+	// Workers 并发数, 0 表示 GOMAXPROCS*2
+	Workers int
+	// Iterations 每个 worker 每轮执行的操作数, 0 表示 1000
+	Iterations int
+	// Rounds 重复轮数, 每轮所有 worker 重新同时起跑, 0 表示 1
+	Rounds int
+	// YieldRate 每次操作前让出调度的概率 [0, 1], 0 表示 0.1
+	YieldRate float64
+	// Timeout 整个测试的时间上限, 到达后停止发起新操作, 0 表示不限制
+	Timeout time.Duration
+	// Check 每轮结束 (所有 worker 停止) 后检查不变量, 如池中对象数和计数一致
+	Check func() error
+	// MaxErrors 报告的错误条数上限, 0 表示 10
+	MaxErrors int
+}
+
+// StressResult 压力测试的统计
+type StressResult struct {
+	Seed   uint64
+	Ops    map[string]int64
+	Errors map[string]int64
+	// Panics 操作中 panic 的次数, panic 被捕获并记为错误
+	Panics  int64
+	Elapsed time.Duration
+}
+
+// String 返回按操作名排序的统计摘要
+func (r *StressResult) String() string {
+	names := make([]string, 0, len(r.Ops))
+	for name := range r.Ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	fmt.Fprintf(&b, "seed=%d elapsed=%v", r.Seed, r.Elapsed.Round(time.Millisecond))
+	for _, name := range names {
+		fmt.Fprintf(&b, " %s=%d", name, r.Ops[name])
+		if n := r.Errors[name]; n > 0 {
+			fmt.Fprintf(&b, "(%d err)", n)
+		}
+	}
+	return b.String()
+}
+
+// Stress 以 cfg 并发执行 ops, 操作返回的错误、panic 和 Check 失败都会标记测试失败.
+// 未启用竞态检测时会在日志中提示
+func Stress(tb testing.TB, cfg StressConfig, ops ...StressOp) *StressResult {
+	tb.Helper()
+	if len(ops) == 0 {
+		tb.Fatal("Stress: no operations")
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = runtime.GOMAXPROCS(0) * 2
+	}
+	if cfg.Iterations <= 0 {
+		cfg.Iterations = 1000
+	}
+	if cfg.Rounds <= 0 {
+		cfg.Rounds = 1
+	}
+	if cfg.YieldRate <= 0 {
+		cfg.YieldRate = 0.1
+	}
+	if cfg.MaxErrors <= 0 {
+		cfg.MaxErrors = 10
+	}
+	seed := *stressSeed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	if !RaceEnabled {
+		tb.Log("Stress: race detector disabled, run with -race to detect data races")
+	}
+	tb.Logf("Stress: seed %d (rerun with -stress.seed=%d)", seed, seed)
+
+	total := 0
+	for _, op := range ops {
+		total += max(op.Weight, 1)
+	}
+	res := &StressResult{Seed: seed, Ops: make(map[string]int64), Errors: make(map[string]int64)}
+	// 计数按 worker 分开, 只在出错时加锁, 避免测试自身的锁掩盖被测结构的竞争
+	var mu sync.Mutex
+	reported := 0
+	fail := func(op string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if reported < cfg.MaxErrors {
+			reported++
+			tb.Errorf("Stress: %s: %v (seed %d)", op, err, seed)
+		}
+	}
+	type counts struct{ ops, errs, panics []int64 }
+	merge := func(c *counts) {
+		mu.Lock()
+		defer mu.Unlock()
+		for i, op := range ops {
+			res.Ops[op.Name] += c.ops[i]
+			res.Errors[op.Name] += c.errs[i]
+		}
+		for _, n := range c.panics {
+			res.Panics += n
+		}
+	}
+
+	start := time.Now()
+	var deadline time.Time
+	if cfg.Timeout > 0 {
+		deadline = start.Add(cfg.Timeout)
+	}
+	for round := 0; round < cfg.Rounds; round++ {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			break
+		}
+		begin := make(chan struct{})
+		var wg sync.WaitGroup
+		for w := 0; w < cfg.Workers; w++ {
+			wg.Add(1)
+			rng := rand.New(rand.NewPCG(seed, uint64(round)<<32|uint64(w)))
+			go func() {
+				defer wg.Done()
+				c := &counts{ops: make([]int64, len(ops)), errs: make([]int64, len(ops)), panics: make([]int64, len(ops))}
+				defer merge(c)
+				<-begin
+				for i := 0; i < cfg.Iterations; i++ {
+					if !deadline.IsZero() && i%64 == 0 && time.Now().After(deadline) {
+						return
+					}
+					idx := pickOp(ops, rng.IntN(total))
+					if rng.Float64() < cfg.YieldRate {
+						runtime.Gosched()
+					}
+					panicked, err := runOp(ops[idx], w)
+					c.ops[idx]++
+					if err != nil {
+						c.errs[idx]++
+						if panicked {
+							c.panics[idx]++
+						}
+						fail(ops[idx].Name, err)
+					}
+				}
+			}()
+		}
+		close(begin)
+		wg.Wait()
+		if cfg.Check != nil {
+			if err := cfg.Check(); err != nil {
+				tb.Errorf("Stress: invariant after round %d: %v (seed %d)", round+1, err, seed)
+			}
+		}
+	}
+	res.Elapsed = time.Since(start)
+	tb.Logf("Stress: %v", res)
+	return res
+}
+
+// pickOp 按权重返回第 n 个槽位对应的操作下标
+func pickOp(ops []StressOp, n int) int {
+	for i, op := range ops {
+		if n -= max(op.Weight, 1); n < 0 {
+			return i
+		}
+	}
+	return len(ops) - 1
+}
+
+func runOp(op StressOp, worker int) (panicked bool, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			panicked, err = true, fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return false, op.Fn(worker)
+}
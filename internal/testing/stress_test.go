@@ -0,0 +1,130 @@
+package testing
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStressCountsAndChecks(t *testing.T) {
+	var n atomic.Int64
+	rounds := 0
+	res := Stress(t, StressConfig{
+		Workers:    4,
+		Iterations: 200,
+		Rounds:     3,
+		YieldRate:  0.5,
+		Check: func() error {
+			// 每轮结束时所有 worker 都已停止, 计数应恰好是整轮的操作数
+			rounds++
+			if got, want := n.Load(), int64(rounds*4*200); got != want {
+				return errors.New("counter out of step with rounds")
+			}
+			return nil
+		},
+	},
+		StressOp{Name: "heavy", Weight: 9, Fn: func(int) error { n.Add(1); return nil }},
+		StressOp{Name: "light", Fn: func(int) error { n.Add(1); return nil }},
+	)
+	if rounds != 3 {
+		t.Fatalf("Check ran %d times, want 3", rounds)
+	}
+	if total := res.Ops["heavy"] + res.Ops["light"]; total != 2400 {
+		t.Fatalf("ops = %v, want 2400 in total", res.Ops)
+	}
+	if res.Ops["heavy"] < 4*res.Ops["light"] {
+		t.Errorf("weights ignored: %v", res.Ops)
+	}
+	if s := res.String(); !strings.Contains(s, "heavy=") || strings.Index(s, "heavy=") > strings.Index(s, "light=") {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestStressReportsErrorsAndPanics(t *testing.T) {
+	var res *StressResult
+	tb := runFake(t, func(tb *fakeTB) {
+		res = Stress(tb, StressConfig{Workers: 2, Iterations: 50, MaxErrors: 3},
+			StressOp{Name: "fail", Fn: func(int) error { return errors.New("broken") }},
+			StressOp{Name: "panic", Fn: func(int) error { panic("boom") }},
+		)
+	})
+	if !tb.Failed() {
+		t.Fatal("failing operations did not fail the test")
+	}
+	if got := strings.Count(tb.output(), "Stress: "); got != 3 {
+		t.Errorf("reported %d errors, want MaxErrors=3:\n%s", got, tb.output())
+	}
+	if res.Errors["fail"]+res.Errors["panic"] != 100 || res.Panics != res.Ops["panic"] {
+		t.Errorf("errors = %v, panics = %d, ops = %v", res.Errors, res.Panics, res.Ops)
+	}
+	if !strings.Contains(tb.output(), "seed") {
+		t.Errorf("report lacks the seed:\n%s", tb.output())
+	}
+
+	tb = runFake(t, func(tb *fakeTB) {
+		Stress(tb, StressConfig{Workers: 1, Iterations: 1, Check: func() error { return errors.New("leaked") }},
+			StressOp{Name: "noop", Fn: func(int) error { return nil }})
+	})
+	if !strings.Contains(tb.output(), "invariant after round 1: leaked") {
+		t.Errorf("invariant report:\n%s", tb.output())
+	}
+}
+
+// 同一种子下每个 worker 的操作序列相同
+func TestStressSeedReplay(t *testing.T) {
+	defer func(old uint64) { *stressSeed = old }(*stressSeed)
+	*stressSeed = 42
+
+	run := func() [][]string {
+		var mu sync.Mutex
+		seq := make([][]string, 3)
+		op := func(name string) StressOp {
+			return StressOp{Name: name, Fn: func(w int) error {
+				mu.Lock()
+				seq[w] = append(seq[w], name)
+				mu.Unlock()
+				return nil
+			}}
+		}
+		res := Stress(t, StressConfig{Workers: 3, Iterations: 100, Rounds: 2}, op("a"), op("b"), op("c"))
+		if res.Seed != 42 {
+			t.Fatalf("Seed = %d", res.Seed)
+		}
+		return seq
+	}
+	first, second := run(), run()
+	for w := range first {
+		if !slices.Equal(first[w], second[w]) {
+			t.Fatalf("worker %d diverged under the same seed", w)
+		}
+	}
+	if slices.Equal(first[0], first[1]) {
+		t.Error("workers share one sequence")
+	}
+}
+
+func TestStressTimeout(t *testing.T) {
+	start := time.Now()
+	res := Stress(t, StressConfig{Workers: 2, Iterations: 1 << 30, Rounds: 100, Timeout: 50 * time.Millisecond},
+		StressOp{Name: "sleep", Fn: func(int) error { time.Sleep(100 * time.Microsecond); return nil }})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Timeout ignored, ran for %v", elapsed)
+	}
+	if res.Ops["sleep"] == 0 {
+		t.Fatal("no operations ran before the timeout")
+	}
+}
+
+func TestPickOp(t *testing.T) {
+	ops := []StressOp{{Weight: 2}, {Weight: 0}, {Weight: 3}}
+	want := []int{0, 0, 1, 2, 2, 2}
+	for n, w := range want {
+		if got := pickOp(ops, n); got != w {
+			t.Errorf("pickOp(%d) = %d, want %d", n, got, w)
+		}
+	}
+}
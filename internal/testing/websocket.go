@@ -0,0 +1,705 @@
+package testing
+
+/*
+	WebSocket 测试辅助 (RFC 6455): 独立的帧编解码 (可构造非法帧用于负向测试)、
+	可编排脚本的服务端夹具和带帧断言的客户端连接, 不依赖生产实现
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http1"
+)
+
+var (
+	// ErrWSHandshake 握手请求或响应不符合 RFC 6455
+	ErrWSHandshake = errors.New("testing: bad websocket handshake")
+	// ErrWSFrameTooLarge 帧长度超过 MaxWSFrameSize
+	ErrWSFrameTooLarge = errors.New("testing: websocket frame too large")
+	// ErrWSUnexpectedFrame 脚本期望的帧与收到的不一致
+	ErrWSUnexpectedFrame = errors.New("testing: unexpected websocket frame")
+)
+
+// WebSocket 操作码
+const (
+	WSContinuation byte = 0x0
+	WSText         byte = 0x1
+	WSBinary       byte = 0x2
+	WSClose        byte = 0x8
+	WSPing         byte = 0x9
+	WSPong         byte = 0xa
+)
+
+// 常用关闭码
+const (
+	WSCloseNormal          = 1000
+	WSCloseGoingAway       = 1001
+	WSCloseProtocolError   = 1002
+	WSCloseUnsupportedData = 1003
+	WSCloseNoStatus        = 1005
+	WSCloseInvalidPayload  = 1007
+	WSClosePolicyViolation = 1008
+	WSCloseTooBig          = 1009
+)
+
+// MaxWSFrameSize 读取帧时允许的最大载荷
+const MaxWSFrameSize = 16 << 20
+
+// DefaultWSTimeout 断言等待帧的默认超时
+const DefaultWSTimeout = 2 * time.Second
+
+// wsGUID 计算 Sec-WebSocket-Accept 的固定 GUID
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WSFrame 一个 WebSocket 帧, 字段按线上格式原样保留, 可构造非法帧
+type WSFrame struct {
+	Fin bool
+	// RSV RSV1-3 三位, 取值 0-7
+	RSV    byte
+	Opcode byte
+	// Masked 读取时表示帧带掩码; 写出时由连接决定, 见 WSConn.WriteFrame
+	Masked  bool
+	Payload []byte
+}
+
+// String 返回便于阅读的描述, 用于断言失败信息
+func (f *WSFrame) String() string {
+	s := fmt.Sprintf("%s fin=%v", wsOpName(f.Opcode), f.Fin)
+	if f.RSV != 0 {
+		s += fmt.Sprintf(" rsv=%d", f.RSV)
+	}
+	if f.Opcode == WSClose {
+		code, reason := f.CloseCode()
+		return s + fmt.Sprintf(" code=%d reason=%q", code, reason)
+	}
+	if len(f.Payload) > 64 {
+		return s + fmt.Sprintf(" %q... (%d bytes)", f.Payload[:64], len(f.Payload))
+	}
+	return s + fmt.Sprintf(" %q", f.Payload)
+}
+
+// CloseCode 解析关闭帧的状态码和原因, 没有状态码时返回 WSCloseNoStatus
+func (f *WSFrame) CloseCode() (int, string) {
+	if len(f.Payload) < 2 {
+		return WSCloseNoStatus, ""
+	}
+	return int(binary.BigEndian.Uint16(f.Payload)), string(f.Payload[2:])
+}
+
+func wsOpName(op byte) string {
+	switch op {
+	case WSContinuation:
+		return "continuation"
+	case WSText:
+		return "text"
+	case WSBinary:
+		return "binary"
+	case WSClose:
+		return "close"
+	case WSPing:
+		return "ping"
+	case WSPong:
+		return "pong"
+	}
+	return fmt.Sprintf("opcode(%#x)", op)
+}
+
+// WSCloseFrame 构造关闭帧
+func WSCloseFrame(code int, reason string) *WSFrame {
+	p := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return &WSFrame{Fin: true, Opcode: WSClose, Payload: append(p, reason...)}
+}
+
+// ReadWSFrame 读取一个帧并去除掩码, 不做协议合法性检查
+func ReadWSFrame(r io.Reader) (*WSFrame, error) {
+	var hdr [14]byte
+	if _, err := io.ReadFull(r, hdr[:2]); err != nil {
+		return nil, err
+	}
+	f := &WSFrame{
+		Fin:    hdr[0]&0x80 != 0,
+		RSV:    hdr[0] >> 4 & 0x07,
+		Opcode: hdr[0] & 0x0f,
+		Masked: hdr[1]&0x80 != 0,
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		if _, err := io.ReadFull(r, hdr[2:4]); err != nil {
+			return nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(hdr[2:]))
+	case 127:
+		if _, err := io.ReadFull(r, hdr[2:10]); err != nil {
+			return nil, err
+		}
+		n = binary.BigEndian.Uint64(hdr[2:])
+	}
+	if n > MaxWSFrameSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrWSFrameTooLarge, n)
+	}
+	var key [4]byte
+	if f.Masked {
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return nil, err
+		}
+	}
+	f.Payload = make([]byte, n)
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return nil, err
+	}
+	if f.Masked {
+		wsMask(key, f.Payload)
+	}
+	return f, nil
+}
+
+// WriteWSFrame 写出一个帧, mask 为 true 时使用随机掩码; 不检查帧是否合法
+func WriteWSFrame(w io.Writer, f *WSFrame, mask bool) error {
+	b := []byte{f.RSV&0x07<<4 | f.Opcode&0x0f, 0}
+	if f.Fin {
+		b[0] |= 0x80
+	}
+	switch n := len(f.Payload); {
+	case n < 126:
+		b[1] = byte(n)
+	case n <= 0xffff:
+		b[1] = 126
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b[1] = 127
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	payload := f.Payload
+	if mask {
+		b[1] |= 0x80
+		var key [4]byte
+		rand.Read(key[:])
+		b = append(b, key[:]...)
+		payload = bytes.Clone(payload)
+		wsMask(key, payload)
+	}
+	_, err := w.Write(append(b, payload...))
+	return err
+}
+
+func wsMask(key [4]byte, p []byte) {
+	for i := range p {
+		p[i] ^= key[i&3]
+	}
+}
+
+// WSAcceptKey 计算 key 对应的 Sec-WebSocket-Accept
+func WSAcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// WSConn 握手完成后的 WebSocket 连接, 客户端写出的帧自动加掩码; 读写各自非并发安全
+type WSConn struct {
+	// Timeout 断言等待帧的时间, 0 表示 DefaultWSTimeout
+	Timeout time.Duration
+	// Protocol 协商得到的子协议
+	Protocol string
+
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool
+	wmu    sync.Mutex
+}
+
+// NetConn 返回底层连接, 可用于写出任意字节
+func (c *WSConn) NetConn() net.Conn { return c.conn }
+
+// WriteFrame 写出帧; 客户端连接总是加掩码, 需要发送未加掩码的客户端帧时使用 WriteRawFrame
+func (c *WSConn) WriteFrame(f *WSFrame) error {
+	return c.WriteRawFrame(f, c.client)
+}
+
+// WriteRawFrame 按 mask 指定是否加掩码写出帧, 用于构造违反掩码规则的帧
+func (c *WSConn) WriteRawFrame(f *WSFrame, mask bool) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return WriteWSFrame(c.conn, f, mask)
+}
+
+// WriteText 发送一个完整的文本消息
+func (c *WSConn) WriteText(s string) error {
+	return c.WriteFrame(&WSFrame{Fin: true, Opcode: WSText, Payload: []byte(s)})
+}
+
+// WriteBinary 发送一个完整的二进制消息
+func (c *WSConn) WriteBinary(b []byte) error {
+	return c.WriteFrame(&WSFrame{Fin: true, Opcode: WSBinary, Payload: b})
+}
+
+// WriteFragmented 将消息按 size 字节分片发送, 首帧为 op, 其余为继续帧
+func (c *WSConn) WriteFragmented(op byte, payload []byte, size int) error {
+	for first := true; first || len(payload) > 0; first = false {
+		n := min(size, len(payload))
+		f := &WSFrame{Fin: n == len(payload), Opcode: WSContinuation, Payload: payload[:n]}
+		if first {
+			f.Opcode = op
+		}
+		if err := c.WriteFrame(f); err != nil {
+			return err
+		}
+		payload = payload[n:]
+	}
+	return nil
+}
+
+// Ping 发送 ping 帧
+func (c *WSConn) Ping(payload []byte) error {
+	return c.WriteFrame(&WSFrame{Fin: true, Opcode: WSPing, Payload: payload})
+}
+
+// WriteClose 发送关闭帧
+func (c *WSConn) WriteClose(code int, reason string) error {
+	return c.WriteFrame(WSCloseFrame(code, reason))
+}
+
+// ReadFrame 读取下一个帧, 不自动回应 ping 和关闭帧
+func (c *WSConn) ReadFrame() (*WSFrame, error) {
+	return ReadWSFrame(c.br)
+}
+
+// ReadMessage 读取下一个完整消息, 拼接分片并自动回应 ping; 收到关闭帧时返回该帧
+func (c *WSConn) ReadMessage() (*WSFrame, error) {
+	var msg *WSFrame
+	for {
+		f, err := c.ReadFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case f.Opcode == WSPing:
+			if err := c.WriteFrame(&WSFrame{Fin: true, Opcode: WSPong, Payload: f.Payload}); err != nil {
+				return nil, err
+			}
+			continue
+		case f.Opcode == WSPong:
+			continue
+		case f.Opcode == WSClose:
+			return f, nil
+		case msg == nil:
+			msg = f
+		default:
+			msg.Payload = append(msg.Payload, f.Payload...)
+			msg.Fin = f.Fin
+		}
+		if msg.Fin {
+			return msg, nil
+		}
+	}
+}
+
+// Close 直接关闭底层连接, 不发送关闭帧
+func (c *WSConn) Close() error { return c.conn.Close() }
+
+func (c *WSConn) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return DefaultWSTimeout
+}
+
+// readWithin 在超时内读取下一个帧
+func (c *WSConn) readWithin(d time.Duration) (*WSFrame, error) {
+	c.conn.SetReadDeadline(time.Now().Add(d))
+	defer c.conn.SetReadDeadline(time.Time{})
+	return c.ReadFrame()
+}
+
+// ExpectFrame 断言下一个帧的操作码和载荷, payload 为 nil 时不比较载荷; 失败时终止测试
+func (c *WSConn) ExpectFrame(tb testing.TB, op byte, payload []byte) *WSFrame {
+	tb.Helper()
+	f, err := c.readWithin(c.timeout())
+	if err != nil {
+		tb.Fatalf("expected %s frame: %v", wsOpName(op), err)
+	}
+	if f.Opcode != op || payload != nil && !bytes.Equal(f.Payload, payload) {
+		want := &WSFrame{Fin: f.Fin, Opcode: op, Payload: payload}
+		tb.Fatalf("unexpected frame\nwant: %v\ngot:  %v", want, f)
+	}
+	return f
+}
+
+// ExpectText 断言下一个帧是内容为 s 的完整文本帧
+func (c *WSConn) ExpectText(tb testing.TB, s string) {
+	tb.Helper()
+	if f := c.ExpectFrame(tb, WSText, []byte(s)); !f.Fin {
+		tb.Fatalf("expected final text frame, got %v", f)
+	}
+}
+
+// ExpectBinary 断言下一个帧是内容为 b 的完整二进制帧
+func (c *WSConn) ExpectBinary(tb testing.TB, b []byte) {
+	tb.Helper()
+	if f := c.ExpectFrame(tb, WSBinary, b); !f.Fin {
+		tb.Fatalf("expected final binary frame, got %v", f)
+	}
+}
+
+// ExpectClose 断言下一个帧是关闭帧, code 为 0 时不比较状态码; 返回收到的状态码
+func (c *WSConn) ExpectClose(tb testing.TB, code int) int {
+	tb.Helper()
+	f := c.ExpectFrame(tb, WSClose, nil)
+	got, _ := f.CloseCode()
+	if code != 0 && got != code {
+		tb.Fatalf("close code = %d, want %d (%v)", got, code, f)
+	}
+	return got
+}
+
+// ExpectNoFrame 断言 d 内没有收到帧
+func (c *WSConn) ExpectNoFrame(tb testing.TB, d time.Duration) {
+	tb.Helper()
+	f, err := c.readWithin(d)
+	if err == nil {
+		tb.Fatalf("unexpected frame %v", f)
+	}
+	if !isTimeout(err) {
+		tb.Fatalf("expected no frame, connection failed: %v", err)
+	}
+}
+
+// ExpectEOF 断言对端在超时内关闭了连接
+func (c *WSConn) ExpectEOF(tb testing.TB) {
+	tb.Helper()
+	f, err := c.readWithin(c.timeout())
+	if err == nil {
+		tb.Fatalf("expected connection close, got frame %v", f)
+	}
+	if isTimeout(err) {
+		tb.Fatalf("connection still open after %v", c.timeout())
+	}
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// DialWS 连接 rawURL (ws://host/path) 并完成握手, protocols 为请求的子协议.
+// 握手被拒绝时返回服务端的响应和 ErrWSHandshake
+func DialWS(rawURL string, header common.Header, protocols ...string) (*WSConn, *message.Response, error) {
+	req, err := message.NewRequest(common.MethodGet, strings.Replace(rawURL, "ws://", "http://", 1), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, vs := range header {
+		req.Header[k] = append([]string(nil), vs...)
+	}
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(protocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(protocols, ", "))
+	}
+
+	conn, err := net.Dial("tcp", req.URL.Host)
+	if err != nil {
+		return nil, nil, err
+	}
+	br := bufio.NewReader(conn)
+	if err := http1.WriteRequest(bufio.NewWriter(conn), req); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	resp, err := http1.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode != common.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, resp, fmt.Errorf("%w: status %s", ErrWSHandshake, resp.Status)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != WSAcceptKey(key) {
+		conn.Close()
+		return nil, resp, fmt.Errorf("%w: Sec-WebSocket-Accept %q", ErrWSHandshake, got)
+	}
+	c := &WSConn{conn: conn, br: br, client: true, Protocol: resp.Header.Get("Sec-WebSocket-Protocol")}
+	return c, resp, nil
+}
+
+// WSHandler 服务端夹具处理一条已握手的连接, 返回的错误记录在 WSServer.Errors 中
+type WSHandler func(c *WSConn) error
+
+// WSEcho 原样回送数据帧 (保留分片), 回应 ping, 收到关闭帧时回送同一关闭帧后结束
+func WSEcho(c *WSConn) error {
+	for {
+		f, err := c.ReadFrame()
+		if err != nil {
+			return nil
+		}
+		switch f.Opcode {
+		case WSPing:
+			f.Opcode = WSPong
+		case WSPong:
+			continue
+		case WSClose:
+			return c.WriteFrame(f)
+		}
+		if err := c.WriteFrame(f); err != nil {
+			return err
+		}
+	}
+}
+
+// WSStep 脚本中的一步
+type WSStep func(c *WSConn) error
+
+// WSScript 依次执行 steps, 遇到错误即停止
+func WSScript(steps ...WSStep) WSHandler {
+	return func(c *WSConn) error {
+		for i, step := range steps {
+			if err := step(c); err != nil {
+				return fmt.Errorf("step %d: %w", i+1, err)
+			}
+		}
+		return nil
+	}
+}
+
+// WSSend 发送一个帧
+func WSSend(f *WSFrame) WSStep {
+	return func(c *WSConn) error { return c.WriteFrame(f) }
+}
+
+// WSSendText 发送一个文本消息
+func WSSendText(s string) WSStep {
+	return func(c *WSConn) error { return c.WriteText(s) }
+}
+
+// WSExpect 期望下一个帧的操作码和载荷, payload 为 nil 时不比较载荷
+func WSExpect(op byte, payload []byte) WSStep {
+	return func(c *WSConn) error {
+		f, err := c.readWithin(c.timeout())
+		if err != nil {
+			return err
+		}
+		if f.Opcode != op || payload != nil && !bytes.Equal(f.Payload, payload) {
+			return fmt.Errorf("%w: want %s %q, got %v", ErrWSUnexpectedFrame, wsOpName(op), payload, f)
+		}
+		return nil
+	}
+}
+
+// WSSleep 等待 d
+func WSSleep(d time.Duration) WSStep {
+	return func(*WSConn) error {
+		time.Sleep(d)
+		return nil
+	}
+}
+
+// WSSendClose 发送关闭帧
+func WSSendClose(code int, reason string) WSStep {
+	return func(c *WSConn) error { return c.WriteClose(code, reason) }
+}
+
+// WSDrop 不发送关闭帧直接断开连接
+func WSDrop() WSStep {
+	return func(c *WSConn) error { return c.Close() }
+}
+
+// WSServer 监听本地回环地址的 WebSocket 夹具, 每条连接握手后交给处理器
+type WSServer struct {
+	// URL 形如 "ws://127.0.0.1:port"
+	URL string
+	// Addr 监听地址
+	Addr string
+	// Protocols 支持的子协议, 选择客户端请求中第一个受支持的; 须在建立连接前设置
+	Protocols []string
+
+	ln         net.Listener
+	handler    WSHandler
+	mu         sync.Mutex
+	handshakes []CapturedRequest
+	errs       []error
+	conns      map[net.Conn]struct{}
+	wg         sync.WaitGroup
+	closeOnce  sync.Once
+}
+
+// NewWSServer 启动夹具, h 为空时使用 WSEcho; tb 不为空时在测试结束时关闭, 并报告处理器返回的错误
+func NewWSServer(tb testing.TB, h WSHandler) (*WSServer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		h = WSEcho
+	}
+	s := &WSServer{
+		URL:     "ws://" + ln.Addr().String(),
+		Addr:    ln.Addr().String(),
+		ln:      ln,
+		handler: h,
+		conns:   make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	if tb != nil {
+		tb.Cleanup(func() {
+			s.Close()
+			for _, err := range s.Errors() {
+				tb.Errorf("websocket fixture: %v", err)
+			}
+		})
+	}
+	return s, nil
+}
+
+// Handshakes 返回至今收到的握手请求, 包括被拒绝的
+func (s *WSServer) Handshakes() []CapturedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]CapturedRequest(nil), s.handshakes...)
+}
+
+// Errors 返回握手失败和处理器返回的错误
+func (s *WSServer) Errors() []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]error(nil), s.errs...)
+}
+
+// Close 停止监听并关闭所有连接, 等待处理器退出
+func (s *WSServer) Close() {
+	s.closeOnce.Do(func() {
+		s.ln.Close()
+		s.mu.Lock()
+		for c := range s.conns {
+			c.Close()
+		}
+		s.mu.Unlock()
+		s.wg.Wait()
+	})
+}
+
+func (s *WSServer) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.serveConn(c)
+	}
+}
+
+func (s *WSServer) fail(err error) {
+	s.mu.Lock()
+	s.errs = append(s.errs, err)
+	s.mu.Unlock()
+}
+
+func (s *WSServer) serveConn(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		c.Close()
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	}()
+	br := bufio.NewReader(c)
+	req, err := http1.ReadRequest(br)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.handshakes = append(s.handshakes, CapturedRequest{
+		Method:     req.Method,
+		Target:     req.RequestURI(),
+		Proto:      req.Proto,
+		Header:     req.Header,
+		RemoteAddr: c.RemoteAddr().String(),
+		Time:       time.Now(),
+	})
+	s.mu.Unlock()
+
+	resp := message.NewResponse(common.StatusSwitchingProtocols)
+	resp.Request = req
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if err := checkWSHandshake(req); err != nil {
+		s.fail(err)
+		resp = message.NewResponse(common.StatusBadRequest)
+		resp.Request, resp.Close = req, true
+		if req.Header.Get("Sec-WebSocket-Version") != "13" {
+			resp.Header.Set("Sec-WebSocket-Version", "13")
+		}
+		http1.WriteResponse(bufio.NewWriter(c), resp)
+		return
+	}
+	resp.Header.Set("Upgrade", "websocket")
+	resp.Header.Set("Connection", "Upgrade")
+	resp.Header.Set("Sec-WebSocket-Accept", WSAcceptKey(key))
+	ws := &WSConn{conn: c, br: br}
+	if p := s.selectProtocol(req.Header.Values("Sec-WebSocket-Protocol")); p != "" {
+		resp.Header.Set("Sec-WebSocket-Protocol", p)
+		ws.Protocol = p
+	}
+	if err := http1.WriteResponse(bufio.NewWriter(c), resp); err != nil {
+		return
+	}
+	if err := s.handler(ws); err != nil {
+		s.fail(err)
+	}
+}
+
+func (s *WSServer) selectProtocol(offered []string) string {
+	for _, v := range offered {
+		for _, p := range strings.Split(v, ",") {
+			for _, supported := range s.Protocols {
+				if strings.TrimSpace(p) == supported {
+					return supported
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// checkWSHandshake 按 RFC 6455 4.2.1 检查握手请求
+func checkWSHandshake(req *message.Request) error {
+	switch {
+	case req.Method != common.MethodGet:
+		return fmt.Errorf("%w: method %s", ErrWSHandshake, req.Method)
+	case !req.Header.HasToken("Upgrade", "websocket"):
+		return fmt.Errorf("%w: missing Upgrade: websocket", ErrWSHandshake)
+	case !req.Header.HasToken("Connection", "upgrade"):
+		return fmt.Errorf("%w: missing Connection: Upgrade", ErrWSHandshake)
+	case req.Header.Get("Sec-WebSocket-Version") != "13":
+		return fmt.Errorf("%w: Sec-WebSocket-Version %q", ErrWSHandshake, req.Header.Get("Sec-WebSocket-Version"))
+	}
+	if key, err := base64.StdEncoding.DecodeString(req.Header.Get("Sec-WebSocket-Key")); err != nil || len(key) != 16 {
+		return fmt.Errorf("%w: Sec-WebSocket-Key %q", ErrWSHandshake, req.Header.Get("Sec-WebSocket-Key"))
+	}
+	return nil
+}
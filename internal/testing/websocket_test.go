@@ -0,0 +1,192 @@
+package testing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWSFrameCodec(t *testing.T) {
+	for _, n := range []int{0, 5, 125, 126, 0xffff, 0x10000} {
+		for _, mask := range []bool{false, true} {
+			payload := bytes.Repeat([]byte{'x'}, n)
+			in := &WSFrame{Fin: n%2 == 0, RSV: 5, Opcode: WSBinary, Payload: payload}
+			var buf bytes.Buffer
+			if err := WriteWSFrame(&buf, in, mask); err != nil {
+				t.Fatal(err)
+			}
+			if mask && n > 8 && bytes.Contains(buf.Bytes(), payload) {
+				t.Fatalf("n=%d: masked frame carries the plain payload", n)
+			}
+			out, err := ReadWSFrame(&buf)
+			if err != nil {
+				t.Fatalf("n=%d mask=%v: %v", n, mask, err)
+			}
+			if out.Fin != in.Fin || out.RSV != 5 || out.Opcode != WSBinary || out.Masked != mask || !bytes.Equal(out.Payload, payload) {
+				t.Fatalf("n=%d mask=%v: round trip = %v", n, mask, out)
+			}
+			if buf.Len() != 0 {
+				t.Fatalf("n=%d: %d trailing bytes", n, buf.Len())
+			}
+		}
+	}
+
+	huge := []byte{0x82, 127}
+	huge = binary.BigEndian.AppendUint64(huge, MaxWSFrameSize+1)
+	if _, err := ReadWSFrame(bytes.NewReader(huge)); !errors.Is(err, ErrWSFrameTooLarge) {
+		t.Fatalf("oversized frame = %v", err)
+	}
+	if _, err := ReadWSFrame(bytes.NewReader([]byte{0x81, 5, 'a'})); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("truncated frame = %v", err)
+	}
+}
+
+func TestWSFrameHelpers(t *testing.T) {
+	if got := WSAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("WSAcceptKey = %q", got)
+	}
+	f := WSCloseFrame(WSCloseGoingAway, "bye")
+	if code, reason := f.CloseCode(); code != 1001 || reason != "bye" {
+		t.Fatalf("CloseCode = %d %q", code, reason)
+	}
+	if code, _ := (&WSFrame{Opcode: WSClose}).CloseCode(); code != WSCloseNoStatus {
+		t.Fatalf("empty close = %d", code)
+	}
+	if s := f.String(); s != `close fin=true code=1001 reason="bye"` {
+		t.Fatalf("String = %q", s)
+	}
+	if s := (&WSFrame{Opcode: 0x3, RSV: 1, Payload: bytes.Repeat([]byte("a"), 100)}).String(); !strings.HasPrefix(s, "opcode(0x3) fin=false rsv=1") || !strings.HasSuffix(s, "(100 bytes)") {
+		t.Fatalf("String = %q", s)
+	}
+}
+
+func TestWSServerEcho(t *testing.T) {
+	s, err := NewWSServer(t, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Protocols = []string{"chat", "superchat"}
+	c, resp, err := DialWS(s.URL+"/socket?room=1", map[string][]string{"Origin": {"http://example.test"}}, "v2", "superchat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp.StatusCode != 101 || c.Protocol != "superchat" {
+		t.Fatalf("handshake: %d, protocol %q", resp.StatusCode, c.Protocol)
+	}
+	hs := s.Handshakes()
+	if len(hs) != 1 || hs[0].Target != "/socket?room=1" || hs[0].Header.Get("Origin") != "http://example.test" {
+		t.Fatalf("handshakes = %+v", hs)
+	}
+
+	c.WriteText("hello")
+	c.ExpectText(t, "hello")
+	c.WriteBinary([]byte{1, 2, 3})
+	c.ExpectBinary(t, []byte{1, 2, 3})
+	c.Ping([]byte("p"))
+	c.ExpectFrame(t, WSPong, []byte("p"))
+
+	// 回显保留分片, ReadMessage 负责拼接
+	c.WriteFragmented(WSText, []byte("abcdefg"), 3)
+	msg, err := c.ReadMessage()
+	if err != nil || msg.Opcode != WSText || string(msg.Payload) != "abcdefg" || !msg.Fin {
+		t.Fatalf("ReadMessage = %v, %v", msg, err)
+	}
+	c.ExpectNoFrame(t, 20*time.Millisecond)
+
+	c.WriteClose(WSCloseNormal, "done")
+	if code := c.ExpectClose(t, WSCloseNormal); code != 1000 {
+		t.Fatalf("close code = %d", code)
+	}
+	c.ExpectEOF(t)
+}
+
+func TestWSServerScript(t *testing.T) {
+	s, err := NewWSServer(t, WSScript(
+		WSSendText("welcome"),
+		WSExpect(WSText, []byte("hi")),
+		WSSleep(10*time.Millisecond),
+		WSSend(&WSFrame{Fin: true, Opcode: WSBinary, Payload: []byte{0xff}}),
+		WSSendClose(WSClosePolicyViolation, "go away"),
+		WSDrop(),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _, err := DialWS(s.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.ExpectText(t, "welcome")
+	c.WriteText("hi")
+	// 服务端帧不带掩码
+	if f := c.ExpectFrame(t, WSBinary, []byte{0xff}); f.Masked {
+		t.Fatal("server frame was masked")
+	}
+	c.ExpectClose(t, WSClosePolicyViolation)
+	c.ExpectEOF(t)
+}
+
+func TestWSServerReportsErrors(t *testing.T) {
+	var url string
+	tb := runFake(t, func(tb *fakeTB) {
+		s, err := NewWSServer(tb, WSScript(WSExpect(WSText, []byte("password"))))
+		if err != nil {
+			tb.Fatalf("%v", err)
+		}
+		url = s.URL
+		c, _, err := DialWS(s.URL, nil)
+		if err != nil {
+			tb.Fatalf("%v", err)
+		}
+		defer c.Close()
+		c.WriteText("wrong")
+		c.ExpectEOF(tb)
+	})
+	if !strings.Contains(tb.output(), "websocket fixture: step 1: testing: unexpected websocket frame") {
+		t.Fatalf("script failure not reported: %s", tb.output())
+	}
+	if _, err := net.Dial("tcp", strings.TrimPrefix(url, "ws://")); err == nil {
+		t.Fatal("fixture still listening after cleanup")
+	}
+}
+
+func TestWSServerRejectsBadHandshake(t *testing.T) {
+	s, err := NewWSServer(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c, err := net.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(c, "GET / HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 8\r\n\r\n")
+	out, _ := io.ReadAll(bufio.NewReader(c))
+	if !bytes.HasPrefix(out, []byte("HTTP/1.1 400 ")) || !bytes.Contains(bytes.ToLower(out), []byte("sec-websocket-version: 13")) {
+		t.Fatalf("response = %q", out)
+	}
+	if errs := s.Errors(); len(errs) != 1 || !errors.Is(errs[0], ErrWSHandshake) {
+		t.Fatalf("Errors = %v", errs)
+	}
+
+	// 客户端一侧: 非 101 响应
+	srv, err := NewMockServer(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Handle("GET", "/", &MockResponse{Status: 403})
+	if _, resp, err := DialWS("ws://"+srv.Addr+"/", nil); !errors.Is(err, ErrWSHandshake) || resp == nil || resp.StatusCode != 403 {
+		t.Fatalf("DialWS against 403 = %v, %v", resp, err)
+	}
+}
@@ -1 +1,63 @@
 package cache
+
+/*
+	磁盘缓存, 每个条目一个文件, 文件名为键的 SHA-256
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+)
+
+// DiskStore 基于文件系统的存储
+type DiskStore struct {
+	dir string
+}
+
+// NewDiskStore 在 dir 下创建磁盘存储, 目录不存在时自动创建
+func NewDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DiskStore{dir: dir}, nil
+}
+
+// Get 读取缓存文件
+func (s *DiskStore) Get(key string) ([]byte, bool) {
+	b, err := os.ReadFile(s.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// Set 先写临时文件再重命名, 避免读到写了一半的条目
+func (s *DiskStore) Set(key string, value []byte) {
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(value)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	if err := os.Rename(f.Name(), s.path(key)); err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// Delete 删除缓存文件
+func (s *DiskStore) Delete(key string) {
+	os.Remove(s.path(key))
+}
+
+func (s *DiskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,462 @@
+package cache
+
+/*
+	HTTP 缓存 (RFC 9111): 在 Store 之上按请求目标和 Vary 选择头部存取响应, 判断新鲜度并提供失效接口;
+	客户端的 CacheTransport、服务端的 CacheHandler 和正向代理共用这一层
+*/
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"maps"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/metrics"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// XCacheHeader 标记响应来源的头部: HIT, MISS, REVALIDATED, STALE
+const XCacheHeader = "X-Cache"
+
+// DefaultMaxEntrySize 单个缓存条目默认的最大消息体字节数
+const DefaultMaxEntrySize = 8 << 20
+
+// maxVariants 同一请求目标最多保留的 Vary 变体数, 超出时淘汰最早存入的变体
+const maxVariants = 32
+
+// Freshness 缓存条目相对某个请求的可用状态
+type Freshness int
+
+const (
+	// Fresh 条目新鲜, 可以直接使用
+	Fresh Freshness = iota
+	// Stale 条目已过期, 但请求的 max-stale 允许使用
+	Stale
+	// StaleRevalidate 条目在 stale-while-revalidate 窗口内, 可以先使用再在后台验证
+	StaleRevalidate
+	// MustValidate 使用前必须向源站验证
+	MustValidate
+)
+
+// HTTPCache 基于 Store 的 HTTP 缓存, 零值不可用, 需设置 Store; 多个 goroutine 可以并发使用.
+// 缓存键由协议、主机、端口和请求目标组成
+type HTTPCache struct {
+	// Store 缓存存储, 如 NewMemoryStore 或 NewDiskStore
+	Store Store
+	// Private 为 true 时按私有缓存处理 (可以存储 private 响应, 忽略 s-maxage), 只适用于单用户的客户端
+	Private bool
+	// MaxEntrySize 超过该大小的消息体不缓存, 0 使用 DefaultMaxEntrySize
+	MaxEntrySize int64
+	// Clock 计算新鲜度和年龄的时间来源, 为空时使用系统时钟
+	Clock utils.Clock
+	// Metrics 非空时向其上报查找命中、存储和失效次数
+	Metrics *metrics.Registry
+
+	// mu 串行化变体索引的读改写
+	mu sync.Mutex
+
+	metricsOnce   sync.Once
+	lookups       *metrics.CounterVec
+	stores        *metrics.Counter
+	invalidations *metrics.Counter
+}
+
+// NewHTTPCache 创建共享的 HTTP 缓存
+func NewHTTPCache(store Store) *HTTPCache {
+	return &HTTPCache{Store: store}
+}
+
+// Entry 缓存的响应
+type Entry struct {
+	StatusCode   int
+	Status       string
+	Proto        string
+	Header       common.Header
+	Body         []byte
+	RequestTime  time.Time
+	ResponseTime time.Time
+	// Vary 选择头部及其在原始请求中的值
+	Vary map[string][]string
+}
+
+// variantIndex 保存在请求目标的主键下, 记录 Vary 选择头部和已存储的变体键
+type variantIndex struct {
+	Vary []string
+	Keys []string
+}
+
+// Now 返回缓存时钟的当前时间
+func (c *HTTPCache) Now() time.Time {
+	return utils.ClockOr(c.Clock).Now()
+}
+
+// Shared 报告是否按共享缓存处理
+func (c *HTTPCache) Shared() bool { return !c.Private }
+
+// EntryLimit 返回单个条目消息体的上限
+func (c *HTTPCache) EntryLimit() int64 {
+	if c.MaxEntrySize > 0 {
+		return c.MaxEntrySize
+	}
+	return DefaultMaxEntrySize
+}
+
+// Storable 报告 resp 作为 req 的响应能否存储; 只存储 GET 的完整响应
+func (c *HTTPCache) Storable(req *message.Request, status int, header common.Header) bool {
+	return req.Method == common.MethodGet && IsStorable(req.Method, status, req.Header, header, c.Shared())
+}
+
+// initMetrics 创建指标, 没有配置 Metrics 时使用私有的注册表
+func (c *HTTPCache) initMetrics() {
+	c.metricsOnce.Do(func() {
+		r := c.Metrics
+		if r == nil {
+			r = metrics.NewRegistry()
+		}
+		c.lookups = r.CounterVec("http_cache_lookups_total", "Cache lookups by result.", "result")
+		c.stores = r.Counter("http_cache_stores_total", "Responses stored.")
+		c.invalidations = r.Counter("http_cache_invalidations_total", "Cached targets invalidated.")
+	})
+}
+
+// Get 返回与 req 的目标和 Vary 选择头部都匹配的条目, 没有时返回 nil
+func (c *HTTPCache) Get(req *message.Request) *Entry {
+	c.initMetrics()
+	e := c.get(req)
+	if e != nil {
+		c.lookups.With("hit").Inc()
+	} else {
+		c.lookups.With("miss").Inc()
+	}
+	return e
+}
+
+func (c *HTTPCache) get(req *message.Request) *Entry {
+	primary := RequestKey(req)
+	idx, ok := c.loadIndex(primary)
+	if !ok {
+		return nil
+	}
+	key := variantKey(primary, idx.Vary, req.Header)
+	b, ok := c.Store.Get(key)
+	if !ok {
+		return nil
+	}
+	var e Entry
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&e); err != nil {
+		c.Store.Delete(key)
+		return nil
+	}
+	for name, values := range e.Vary {
+		if strings.Join(req.Header.Values(name), ",") != strings.Join(values, ",") {
+			return nil
+		}
+	}
+	return &e
+}
+
+// Put 以 req 选择的变体存储条目; 响应的 Vary 与已有变体不同时先清除旧变体
+func (c *HTTPCache) Put(req *message.Request, e *Entry) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+		return
+	}
+	primary := RequestKey(req)
+	vary := varyNames(e.Header)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	idx, _ := c.loadIndex(primary)
+	if !slices.Equal(idx.Vary, vary) {
+		c.deleteVariants(idx)
+		idx = variantIndex{Vary: vary}
+	}
+	key := variantKey(primary, vary, req.Header)
+	if !slices.Contains(idx.Keys, key) {
+		idx.Keys = append(idx.Keys, key)
+		if len(idx.Keys) > maxVariants {
+			c.Store.Delete(idx.Keys[0])
+			idx.Keys = slices.Delete(idx.Keys, 0, 1)
+		}
+	}
+	c.Store.Set(key, buf.Bytes())
+	c.saveIndex(primary, idx)
+	c.initMetrics()
+	c.stores.Inc()
+}
+
+// Delete 删除 req 目标的所有变体
+func (c *HTTPCache) Delete(req *message.Request) {
+	c.invalidateKey(RequestKey(req))
+}
+
+// Invalidate 删除 rawURL 的所有变体, rawURL 须包含主机, 如 "https://example.com/a?b=1"
+func (c *HTTPCache) Invalidate(rawURL string) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return
+	}
+	c.invalidateKey(urlKey(u.Scheme, u.Host, u.RequestURI()))
+}
+
+// InvalidateUnsafe 在非安全方法得到非错误响应后使目标及同一主机上 Location、Content-Location 指向的资源失效 (RFC 9111 4.4)
+func (c *HTTPCache) InvalidateUnsafe(req *message.Request, status int, header common.Header) {
+	if common.IsSafe(req.Method) || status >= 400 {
+		return
+	}
+	c.Delete(req)
+	scheme, host := requestScheme(req), requestHost(req)
+	for _, name := range []string{"Location", "Content-Location"} {
+		v := header.Get(name)
+		if v == "" {
+			continue
+		}
+		u, err := url.Parse(v)
+		if err != nil || u.Host != "" && !strings.EqualFold(u.Host, host) || u.Scheme != "" && !strings.EqualFold(u.Scheme, scheme) {
+			continue
+		}
+		if !strings.HasPrefix(u.Path, "/") {
+			// 相对引用按请求目标解析
+			base := &url.URL{Path: "/"}
+			if req.URL != nil {
+				base = &url.URL{Path: req.URL.Path}
+			}
+			u = base.ResolveReference(u)
+		}
+		c.invalidateKey(urlKey(scheme, host, u.RequestURI()))
+	}
+}
+
+// Freshness 判断条目对 req 的可用状态并返回条目的当前年龄
+func (c *HTTPCache) Freshness(req *message.Request, e *Entry) (Freshness, time.Duration) {
+	reqCC := ParseCacheControl(req.Header.Values("Cache-Control"))
+	age := CurrentAge(e.Header, e.RequestTime, e.ResponseTime, c.Now())
+	lifetime, _ := FreshnessLifetime(e.StatusCode, e.Header, c.Shared())
+	if maxAge, ok := reqCC.Duration("max-age"); ok && maxAge < lifetime {
+		lifetime = maxAge
+	}
+	minFresh, _ := reqCC.Duration("min-fresh")
+	respCC := ParseCacheControl(e.Header.Values("Cache-Control"))
+	noCache := reqCC.Has("no-cache") || respCC.Has("no-cache") || req.Header.Get("Pragma") == "no-cache"
+
+	if !noCache && age+minFresh < lifetime {
+		return Fresh, age
+	}
+	staleness := age - lifetime
+	if !noCache && !MustRevalidate(e.Header, c.Shared()) {
+		if maxStale, ok := reqCC["max-stale"]; ok {
+			limit, valid := reqCC.Duration("max-stale")
+			if maxStale == "" || valid && staleness <= limit {
+				return Stale, age
+			}
+		}
+		if swr := StaleWhileRevalidate(e.Header); swr > 0 && staleness <= swr {
+			return StaleRevalidate, age
+		}
+	}
+	return MustValidate, age
+}
+
+// UsableOnError 报告验证失败 (网络错误或 5xx) 时条目能否按 stale-if-error 继续使用, 并返回当前年龄
+func (c *HTTPCache) UsableOnError(e *Entry) (time.Duration, bool) {
+	age := CurrentAge(e.Header, e.RequestTime, e.ResponseTime, c.Now())
+	sie := StaleIfError(e.Header)
+	if sie <= 0 {
+		return age, false
+	}
+	lifetime, _ := FreshnessLifetime(e.StatusCode, e.Header, c.Shared())
+	return age, age-lifetime <= sie
+}
+
+// NewEntry 以响应的状态和头部创建条目, 消息体和 ResponseTime 由调用方在读完响应后填入
+func NewEntry(req *message.Request, status int, statusText, proto string, header common.Header, reqTime time.Time) *Entry {
+	e := &Entry{
+		StatusCode:  status,
+		Status:      statusText,
+		Proto:       proto,
+		Header:      header.Clone(),
+		RequestTime: reqTime,
+		Vary:        varyValues(req, header),
+	}
+	e.Header.Del(XCacheHeader)
+	return e
+}
+
+// Response 以条目构造 req 的响应, age 写入 Age 头部, xcache 写入 X-Cache 头部
+func (e *Entry) Response(req *message.Request, age time.Duration, xcache string) *message.Response {
+	resp := &message.Response{
+		StatusCode:    e.StatusCode,
+		Status:        e.Status,
+		Proto:         e.Proto,
+		Header:        e.ResponseHeader(age, xcache),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+	if req.Method == common.MethodHead {
+		resp.Body = message.NoBody
+	} else {
+		resp.Body = io.NopCloser(bytes.NewReader(e.Body))
+	}
+	return resp
+}
+
+// ResponseHeader 返回带 Age 和 X-Cache 的响应头部副本
+func (e *Entry) ResponseHeader(age time.Duration, xcache string) common.Header {
+	h := e.Header.Clone()
+	h.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	h.Set(XCacheHeader, xcache)
+	return h
+}
+
+// Merge 用 304 响应中的头部更新存储的头部 (RFC 9111 4.3.4)
+func (e *Entry) Merge(h common.Header, reqTime, respTime time.Time) {
+	for k, v := range h {
+		switch k {
+		case "Content-Length", "Transfer-Encoding", "Content-Encoding", XCacheHeader:
+			continue
+		}
+		e.Header[k] = append([]string(nil), v...)
+	}
+	e.RequestTime = reqTime
+	e.ResponseTime = respTime
+}
+
+// Conditional 返回携带条目验证器 (ETag、Last-Modified) 的条件请求
+func (e *Entry) Conditional(req *message.Request) *message.Request {
+	creq := req.Clone(req.Context())
+	if etag := e.Header.Get("ETag"); etag != "" && !creq.Header.Has("If-None-Match") {
+		creq.Header.Set("If-None-Match", etag)
+	}
+	if lm := e.Header.Get("Last-Modified"); lm != "" && !creq.Header.Has("If-Modified-Since") {
+		creq.Header.Set("If-Modified-Since", lm)
+	}
+	return creq
+}
+
+// RequestKey 返回请求目标的主键: 协议、小写主机、端口加请求目标, 如 "https://example.com:443/a?b=1".
+// URL 不带协议时 (服务端收到的 origin-form 请求) 按 http 处理
+func RequestKey(req *message.Request) string {
+	return urlKey(requestScheme(req), requestHost(req), req.RequestURI())
+}
+
+func requestScheme(req *message.Request) string {
+	if req.URL != nil && req.URL.Scheme != "" {
+		return req.URL.Scheme
+	}
+	return "http"
+}
+
+func requestHost(req *message.Request) string {
+	if req.URL != nil && req.URL.Host != "" {
+		return req.URL.Host
+	}
+	return req.HostHeader()
+}
+
+// urlKey 组成主键, 省略的端口按协议补全, 这样 example.com 与 example.com:80 共用条目
+func urlKey(scheme, host, uri string) string {
+	scheme = strings.ToLower(scheme)
+	if scheme == "" {
+		scheme = "http"
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		port := "80"
+		if scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+	}
+	return scheme + "://" + strings.ToLower(host) + uri
+}
+
+// VariantKey 返回 req 在条目 e 的 Vary 选择头部下的变体键; 选择头部取值不同的请求得到不同的键
+func VariantKey(req *message.Request, e *Entry) string {
+	return variantKey(RequestKey(req), slices.Sorted(maps.Keys(e.Vary)), req.Header)
+}
+
+// variantKey 由主键和 Vary 选择头部在请求中的值组成变体键
+func variantKey(primary string, vary []string, h common.Header) string {
+	var b strings.Builder
+	b.WriteString(primary)
+	b.WriteByte(0)
+	for _, name := range vary {
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(h.Values(name), ","))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func (c *HTTPCache) loadIndex(primary string) (variantIndex, bool) {
+	var idx variantIndex
+	b, ok := c.Store.Get(primary)
+	if !ok {
+		return idx, false
+	}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&idx); err != nil {
+		c.Store.Delete(primary)
+		return variantIndex{}, false
+	}
+	return idx, true
+}
+
+func (c *HTTPCache) saveIndex(primary string, idx variantIndex) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(idx); err != nil {
+		return
+	}
+	c.Store.Set(primary, buf.Bytes())
+}
+
+func (c *HTTPCache) deleteVariants(idx variantIndex) {
+	for _, key := range idx.Keys {
+		c.Store.Delete(key)
+	}
+}
+
+func (c *HTTPCache) invalidateKey(primary string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if idx, ok := c.loadIndex(primary); ok {
+		c.deleteVariants(idx)
+		c.initMetrics()
+		c.invalidations.Inc()
+	}
+	c.Store.Delete(primary)
+}
+
+// varyNames 返回响应 Vary 中规范化、排序后的头部名
+func varyNames(h common.Header) []string {
+	var names []string
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = common.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name != "" && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func varyValues(req *message.Request, h common.Header) map[string][]string {
+	var vary map[string][]string
+	for _, name := range varyNames(h) {
+		if vary == nil {
+			vary = make(map[string][]string)
+		}
+		vary[name] = req.Header.Values(name)
+	}
+	return vary
+}
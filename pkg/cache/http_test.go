@@ -0,0 +1,185 @@
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	httptest "github.com/narcilee7/http-stack/internal/testing"
+	"github.com/narcilee7/http-stack/pkg/cache"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+func newRequest(t *testing.T, header map[string]string) *message.Request {
+	t.Helper()
+	req, err := message.NewRequest("GET", "http://example.com/doc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	return req
+}
+
+// putEntry 以 clock 的当前时间存入一个 200 响应
+func putEntry(c *cache.HTTPCache, req *message.Request, header common.Header, body string) {
+	now := c.Now()
+	header.Set("Date", now.UTC().Format(utils.TimeFormat))
+	e := cache.NewEntry(req, 200, "200 OK", "HTTP/1.1", header, now)
+	e.ResponseTime = now
+	e.Body = []byte(body)
+	c.Put(req, e)
+}
+
+func TestFreshnessStates(t *testing.T) {
+	clock := httptest.NewMockClock(time.Time{})
+	c := &cache.HTTPCache{Store: cache.NewMemoryStore(0, 0), Clock: clock}
+	req := newRequest(t, nil)
+	putEntry(c, req, common.Header{"Cache-Control": {"max-age=60, stale-while-revalidate=30"}}, "v1")
+
+	state := func(header map[string]string) cache.Freshness {
+		t.Helper()
+		r := newRequest(t, header)
+		e := c.Get(r)
+		if e == nil {
+			t.Fatal("entry not found")
+		}
+		s, _ := c.Freshness(r, e)
+		return s
+	}
+	if s := state(nil); s != cache.Fresh {
+		t.Fatalf("state at age 0 = %v, want Fresh", s)
+	}
+	if s := state(map[string]string{"Cache-Control": "no-cache"}); s != cache.MustValidate {
+		t.Fatalf("state with request no-cache = %v, want MustValidate", s)
+	}
+	if s := state(map[string]string{"Cache-Control": "max-age=10"}); s != cache.Fresh {
+		t.Fatalf("state with request max-age=10 at age 0 = %v, want Fresh", s)
+	}
+
+	clock.Advance(70 * time.Second)
+	if s := state(nil); s != cache.StaleRevalidate {
+		t.Fatalf("state 10s into stale-while-revalidate = %v, want StaleRevalidate", s)
+	}
+	r := newRequest(t, nil)
+	if _, age := c.Freshness(r, c.Get(r)); age != 70*time.Second {
+		t.Fatalf("age = %v, want 70s", age)
+	}
+
+	clock.Advance(30 * time.Second)
+	if s := state(nil); s != cache.MustValidate {
+		t.Fatalf("state past stale-while-revalidate = %v, want MustValidate", s)
+	}
+	if s := state(map[string]string{"Cache-Control": "max-stale=60"}); s != cache.Stale {
+		t.Fatalf("state with max-stale=60 = %v, want Stale", s)
+	}
+	if s := state(map[string]string{"Cache-Control": "max-stale=10"}); s != cache.MustValidate {
+		t.Fatalf("state with max-stale=10 = %v, want MustValidate", s)
+	}
+}
+
+func TestMustRevalidateIgnoresMaxStale(t *testing.T) {
+	clock := httptest.NewMockClock(time.Time{})
+	c := &cache.HTTPCache{Store: cache.NewMemoryStore(0, 0), Clock: clock}
+	req := newRequest(t, nil)
+	putEntry(c, req, common.Header{"Cache-Control": {"max-age=1, must-revalidate"}}, "v1")
+	clock.Advance(time.Minute)
+	r := newRequest(t, map[string]string{"Cache-Control": "max-stale"})
+	if s, _ := c.Freshness(r, c.Get(r)); s != cache.MustValidate {
+		t.Fatalf("state = %v, want MustValidate", s)
+	}
+}
+
+func TestFreshnessLifetime(t *testing.T) {
+	date := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	httpTime := func(t time.Time) string { return t.Format(utils.TimeFormat) }
+	tests := []struct {
+		name      string
+		header    common.Header
+		shared    bool
+		want      time.Duration
+		heuristic bool
+	}{
+		{"max-age", common.Header{"Cache-Control": {"max-age=120"}}, false, 120 * time.Second, false},
+		{"s-maxage shared", common.Header{"Cache-Control": {"max-age=120, s-maxage=30"}}, true, 30 * time.Second, false},
+		{"s-maxage private", common.Header{"Cache-Control": {"max-age=120, s-maxage=30"}}, false, 120 * time.Second, false},
+		{"expires", common.Header{"Date": {httpTime(date)}, "Expires": {httpTime(date.Add(time.Hour))}}, false, time.Hour, false},
+		{"invalid expires", common.Header{"Date": {httpTime(date)}, "Expires": {"0"}}, false, 0, false},
+		{"heuristic", common.Header{"Date": {httpTime(date)}, "Last-Modified": {httpTime(date.Add(-10 * time.Hour))}}, false, time.Hour, true},
+	}
+	for _, tt := range tests {
+		got, heuristic := cache.FreshnessLifetime(200, tt.header, tt.shared)
+		if got != tt.want || heuristic != tt.heuristic {
+			t.Errorf("%s: FreshnessLifetime = %v, %v; want %v, %v", tt.name, got, heuristic, tt.want, tt.heuristic)
+		}
+	}
+}
+
+func TestVarySelectsVariant(t *testing.T) {
+	c := &cache.HTTPCache{Store: cache.NewMemoryStore(0, 0)}
+	vary := func() common.Header {
+		return common.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Encoding"}}
+	}
+	gzipReq := newRequest(t, map[string]string{"Accept-Encoding": "gzip"})
+	brReq := newRequest(t, map[string]string{"Accept-Encoding": "br"})
+	putEntry(c, gzipReq, vary(), "gzip body")
+	putEntry(c, brReq, vary(), "br body")
+
+	if e := c.Get(newRequest(t, map[string]string{"Accept-Encoding": "gzip"})); e == nil || string(e.Body) != "gzip body" {
+		t.Fatalf("gzip variant = %+v", e)
+	}
+	if e := c.Get(newRequest(t, map[string]string{"Accept-Encoding": "br"})); e == nil || string(e.Body) != "br body" {
+		t.Fatalf("br variant = %+v", e)
+	}
+	if e := c.Get(newRequest(t, nil)); e != nil {
+		t.Fatalf("request without Accept-Encoding matched %q", e.Body)
+	}
+
+	// Vary 选择头部变化后旧变体全部失效
+	putEntry(c, gzipReq, common.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Language"}}, "new")
+	if e := c.Get(brReq); e == nil || string(e.Body) != "new" {
+		t.Fatalf("Get after a Vary change = %+v, want the new entry", e)
+	}
+	if e := c.Get(newRequest(t, map[string]string{"Accept-Encoding": "br", "Accept-Language": "fr"})); e != nil {
+		t.Fatalf("request with another Accept-Language matched %q", e.Body)
+	}
+
+	c.Delete(gzipReq)
+	if e := c.Get(gzipReq); e != nil {
+		t.Fatal("Delete left a variant behind")
+	}
+}
+
+func TestIsStorable(t *testing.T) {
+	tests := []struct {
+		name   string
+		req    common.Header
+		resp   common.Header
+		shared bool
+		want   bool
+	}{
+		{"max-age", nil, common.Header{"Cache-Control": {"max-age=60"}}, true, true},
+		{"no-store", nil, common.Header{"Cache-Control": {"no-store, max-age=60"}}, false, false},
+		{"private shared", nil, common.Header{"Cache-Control": {"private, max-age=60"}}, true, false},
+		{"private local", nil, common.Header{"Cache-Control": {"private, max-age=60"}}, false, true},
+		{"authorization", common.Header{"Authorization": {"Bearer x"}}, common.Header{"Cache-Control": {"max-age=60"}}, true, false},
+		{"authorization public", common.Header{"Authorization": {"Bearer x"}}, common.Header{"Cache-Control": {"public, max-age=60"}}, true, true},
+		{"vary star", nil, common.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}, true, false},
+		{"vary list with star", nil, common.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept, *"}}, true, false},
+		{"vary star in second field", nil, common.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept", "*"}}, true, false},
+	}
+	for _, tt := range tests {
+		req := tt.req
+		if req == nil {
+			req = common.Header{}
+		}
+		if got := cache.IsStorable("GET", 200, req, tt.resp, tt.shared); got != tt.want {
+			t.Errorf("%s: IsStorable = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if cache.IsStorable("POST", 200, common.Header{}, common.Header{"Cache-Control": {"max-age=60"}}, false) {
+		t.Error("POST response is storable")
+	}
+}
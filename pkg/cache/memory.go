@@ -1 +1,110 @@
 package cache
+
+/*
+	内存缓存, 按 LRU 策略淘汰
+*/
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Store 缓存存储后端
+type Store interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(key string)
+}
+
+// MemoryStore 基于 LRU 的内存存储, 按条目数和总字节数限制容量
+type MemoryStore struct {
+	maxEntries int
+	maxBytes   int64
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	bytes int64
+}
+
+type memoryEntry struct {
+	key   string
+	value []byte
+}
+
+// NewMemoryStore 创建内存存储, 限制为 0 表示不限制
+func NewMemoryStore(maxEntries int, maxBytes int64) *MemoryStore {
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// Get 读取缓存并将其标记为最近使用
+func (s *MemoryStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.ll.MoveToFront(e)
+	return e.Value.(*memoryEntry).value, true
+}
+
+// Set 写入缓存, 超出容量时淘汰最久未使用的条目
+func (s *MemoryStore) Set(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxBytes > 0 && int64(len(value)) > s.maxBytes {
+		s.remove(key)
+		return
+	}
+	if e, ok := s.items[key]; ok {
+		ent := e.Value.(*memoryEntry)
+		s.bytes += int64(len(value)) - int64(len(ent.value))
+		ent.value = value
+		s.ll.MoveToFront(e)
+	} else {
+		s.items[key] = s.ll.PushFront(&memoryEntry{key: key, value: value})
+		s.bytes += int64(len(value))
+	}
+	for s.ll.Len() > 0 && (s.maxEntries > 0 && s.ll.Len() > s.maxEntries || s.maxBytes > 0 && s.bytes > s.maxBytes) {
+		s.remove(s.ll.Back().Value.(*memoryEntry).key)
+	}
+}
+
+// Delete 删除缓存条目
+func (s *MemoryStore) Delete(key string) {
+	s.mu.Lock()
+	s.remove(key)
+	s.mu.Unlock()
+}
+
+// Len 返回条目数
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len()
+}
+
+// Bytes 返回已用字节数
+func (s *MemoryStore) Bytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes
+}
+
+func (s *MemoryStore) remove(key string) {
+	e, ok := s.items[key]
+	if !ok {
+		return
+	}
+	s.ll.Remove(e)
+	delete(s.items, key)
+	s.bytes -= int64(len(e.Value.(*memoryEntry).value))
+}
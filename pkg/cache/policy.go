@@ -1 +1,191 @@
 package cache
+
+/*
+	缓存策略, 按 RFC 9111 计算可存储性、新鲜度和当前年龄
+*/
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// CacheControl 解析后的 Cache-Control 指令, 键为小写指令名
+type CacheControl map[string]string
+
+// ParseCacheControl 解析一个或多个 Cache-Control 头部值
+func ParseCacheControl(values []string) CacheControl {
+	cc := make(CacheControl)
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(part, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+			if _, dup := cc[name]; !dup {
+				cc[name] = value
+			}
+		}
+	}
+	return cc
+}
+
+// Has 判断是否包含指令
+func (cc CacheControl) Has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// Duration 返回以秒为单位的指令值, 不存在或非法时 ok 为 false
+func (cc CacheControl) Duration(directive string) (time.Duration, bool) {
+	v, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	secs, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || secs < 0 {
+		return 0, false
+	}
+	return time.Duration(secs) * time.Second, true
+}
+
+// 默认可启发式缓存的状态码 (RFC 9110 15.1)
+var heuristicStatus = map[int]bool{
+	200: true, 203: true, 204: true, 206: true, 300: true, 301: true,
+	308: true, 404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// IsStorable 判断响应是否可以被缓存存储 (RFC 9111 3)
+func IsStorable(method string, status int, reqHeader, respHeader common.Header, shared bool) bool {
+	if method != common.MethodGet && method != common.MethodHead {
+		return false
+	}
+	if status == common.StatusPartialContent || status < 200 {
+		return false
+	}
+	reqCC := ParseCacheControl(reqHeader.Values("Cache-Control"))
+	respCC := ParseCacheControl(respHeader.Values("Cache-Control"))
+	if reqCC.Has("no-store") || respCC.Has("no-store") {
+		return false
+	}
+	if shared {
+		if respCC.Has("private") {
+			return false
+		}
+		if reqHeader.Has("Authorization") && !respCC.Has("public") && !respCC.Has("s-maxage") && !respCC.Has("must-revalidate") {
+			return false
+		}
+	}
+	// Vary 中任一成员为 * 时响应总是不匹配后续请求 (RFC 9111 4.1)
+	if slices.Contains(varyNames(respHeader), "*") {
+		return false
+	}
+	if respHeader.Has("Expires") || respCC.Has("max-age") || respCC.Has("public") {
+		return true
+	}
+	if shared && respCC.Has("s-maxage") {
+		return true
+	}
+	return heuristicStatus[status]
+}
+
+// FreshnessLifetime 计算响应的新鲜期 (RFC 9111 4.2.1), heuristic 表示结果来自启发式估算
+func FreshnessLifetime(status int, header common.Header, shared bool) (lifetime time.Duration, heuristic bool) {
+	cc := ParseCacheControl(header.Values("Cache-Control"))
+	if shared {
+		if d, ok := cc.Duration("s-maxage"); ok {
+			return d, false
+		}
+	}
+	if d, ok := cc.Duration("max-age"); ok {
+		return d, false
+	}
+	date := headerTime(header, "Date")
+	if expires := header.Get("Expires"); expires != "" {
+		t, err := utils.ParseHTTPTime(expires)
+		if err != nil {
+			// 非法的 Expires 视为已过期
+			return 0, false
+		}
+		if date.IsZero() {
+			return 0, false
+		}
+		if d := t.Sub(date); d > 0 {
+			return d, false
+		}
+		return 0, false
+	}
+	if !heuristicStatus[status] && !cc.Has("public") {
+		return 0, false
+	}
+	// 启发式: 取 Last-Modified 距今时间的 10%, 上限一天
+	lastModified := headerTime(header, "Last-Modified")
+	if date.IsZero() || lastModified.IsZero() || !lastModified.Before(date) {
+		return 0, true
+	}
+	d := date.Sub(lastModified) / 10
+	if d > 24*time.Hour {
+		d = 24 * time.Hour
+	}
+	return d, true
+}
+
+// CurrentAge 计算响应的当前年龄 (RFC 9111 4.2.3)
+func CurrentAge(header common.Header, requestTime, responseTime, now time.Time) time.Duration {
+	var ageValue time.Duration
+	if secs, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil && secs > 0 {
+		ageValue = time.Duration(secs) * time.Second
+	}
+	date := headerTime(header, "Date")
+	if date.IsZero() {
+		date = responseTime
+	}
+	apparentAge := responseTime.Sub(date)
+	if apparentAge < 0 {
+		apparentAge = 0
+	}
+	responseDelay := responseTime.Sub(requestTime)
+	correctedAge := ageValue + responseDelay
+	initialAge := apparentAge
+	if correctedAge > initialAge {
+		initialAge = correctedAge
+	}
+	return initialAge + now.Sub(responseTime)
+}
+
+// StaleWhileRevalidate 返回 stale-while-revalidate 允许的过期容忍时间
+func StaleWhileRevalidate(header common.Header) time.Duration {
+	d, _ := ParseCacheControl(header.Values("Cache-Control")).Duration("stale-while-revalidate")
+	return d
+}
+
+// StaleIfError 返回 stale-if-error 允许的过期容忍时间
+func StaleIfError(header common.Header) time.Duration {
+	d, _ := ParseCacheControl(header.Values("Cache-Control")).Duration("stale-if-error")
+	return d
+}
+
+// MustRevalidate 判断过期后是否必须重新验证
+func MustRevalidate(header common.Header, shared bool) bool {
+	cc := ParseCacheControl(header.Values("Cache-Control"))
+	return cc.Has("must-revalidate") || shared && cc.Has("proxy-revalidate")
+}
+
+func headerTime(h common.Header, key string) time.Time {
+	v := h.Get(key)
+	if v == "" {
+		return time.Time{}
+	}
+	t, err := utils.ParseHTTPTime(v)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
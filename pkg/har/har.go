@@ -0,0 +1,353 @@
+package har
+
+/*
+	HAR 1.2 (HTTP Archive) 的数据结构与读写, 用于导出客户端流量和回放; 规范见 http://www.softwareishard.com/blog/har-12-spec/
+*/
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// ErrInvalid 不是合法的 HAR 文档
+var ErrInvalid = errors.New("har: invalid archive")
+
+// Version 写出的 HAR 版本
+const Version = "1.2"
+
+// HAR 顶层文档
+type HAR struct {
+	Log Log `json:"log"`
+}
+
+// Log 记录的流量
+type Log struct {
+	Version string  `json:"version"`
+	Creator Creator `json:"creator"`
+	Pages   []Page  `json:"pages,omitempty"`
+	Entries []Entry `json:"entries"`
+	Comment string  `json:"comment,omitempty"`
+}
+
+// Creator 生成 HAR 的工具
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Page 页面, 浏览器导出的 HAR 用它把请求分组
+type Page struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	ID              string    `json:"id"`
+	Title           string    `json:"title"`
+}
+
+// Entry 一次请求和响应
+type Entry struct {
+	Pageref         string    `json:"pageref,omitempty"`
+	StartedDateTime time.Time `json:"startedDateTime"`
+	// Time 总耗时, 毫秒
+	Time            float64  `json:"time"`
+	Request         Request  `json:"request"`
+	Response        Response `json:"response"`
+	Cache           struct{} `json:"cache"`
+	Timings         Timings  `json:"timings"`
+	ServerIPAddress string   `json:"serverIPAddress,omitempty"`
+	Connection      string   `json:"connection,omitempty"`
+	Comment         string   `json:"comment,omitempty"`
+}
+
+// Request 请求
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int64       `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+// Response 响应
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int64       `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+// NameValue 头部或查询参数
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Cookie 请求或响应中的 cookie
+type Cookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HTTPOnly bool       `json:"httpOnly,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+}
+
+// PostData 请求体
+type PostData struct {
+	MimeType string      `json:"mimeType"`
+	Params   []PostParam `json:"params,omitempty"`
+	Text     string      `json:"text"`
+	// Encoding 非标准字段 (与 Content 一致), 为 "base64" 时 Text 是 base64 编码的二进制数据
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// PostParam 表单参数
+type PostParam struct {
+	Name        string `json:"name"`
+	Value       string `json:"value,omitempty"`
+	FileName    string `json:"fileName,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// Content 响应体
+type Content struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	// Encoding 为 "base64" 时 Text 是 base64 编码的二进制数据
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Timings 各阶段耗时, 毫秒; -1 表示不适用或未知
+type Timings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// New 创建空的 HAR 文档
+func New(creator, version string) *HAR {
+	return &HAR{Log: Log{Version: Version, Creator: Creator{Name: creator, Version: version}, Entries: []Entry{}}}
+}
+
+// Read 读取并校验 HAR 文档
+func Read(r io.Reader) (*HAR, error) {
+	var h HAR
+	if err := json.NewDecoder(r).Decode(&h); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if h.Log.Version == "" {
+		return nil, fmt.Errorf("%w: missing log.version", ErrInvalid)
+	}
+	return &h, nil
+}
+
+// ReadFile 读取 HAR 文件
+func ReadFile(path string) (*HAR, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Write 以缩进的 JSON 写出
+func (h *HAR) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(h)
+}
+
+// WriteFile 写出到文件
+func (h *HAR) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := h.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Add 追加一条记录
+func (h *HAR) Add(e Entry) { h.Log.Entries = append(h.Log.Entries, e) }
+
+// NewRequest 由请求和已发送的请求体构造 HAR 请求, 请求的消息体不会被读取
+func NewRequest(req *message.Request, body []byte) Request {
+	r := Request{
+		Method:      req.Method,
+		HTTPVersion: req.Proto,
+		Cookies:     []Cookie{},
+		Headers:     headers(req.Header),
+		QueryString: []NameValue{},
+		HeadersSize: -1,
+		BodySize:    int64(len(body)),
+	}
+	if req.URL != nil {
+		r.URL = req.URL.String()
+		for name, values := range req.URL.Query() {
+			for _, v := range values {
+				r.QueryString = append(r.QueryString, NameValue{Name: name, Value: v})
+			}
+		}
+		sort.SliceStable(r.QueryString, func(i, j int) bool { return r.QueryString[i].Name < r.QueryString[j].Name })
+	}
+	if host := req.HostHeader(); host != "" && req.Header.Get("Host") == "" {
+		r.Headers = append([]NameValue{{Name: "Host", Value: host}}, r.Headers...)
+	}
+	for _, line := range req.Header.Values("Cookie") {
+		for _, pair := range strings.Split(line, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			if name != "" {
+				r.Cookies = append(r.Cookies, Cookie{Name: name, Value: value})
+			}
+		}
+	}
+	if len(body) > 0 || req.Method == common.MethodPost || req.Method == common.MethodPut || req.Method == common.MethodPatch {
+		pd := &PostData{MimeType: req.Header.Get("Content-Type")}
+		pd.Text, pd.Encoding = encodeText(body)
+		if strings.HasPrefix(pd.MimeType, "application/x-www-form-urlencoded") {
+			if vals, err := url.ParseQuery(string(body)); err == nil {
+				for name, vs := range vals {
+					for _, v := range vs {
+						pd.Params = append(pd.Params, PostParam{Name: name, Value: v})
+					}
+				}
+			}
+		}
+		r.PostData = pd
+	}
+	return r
+}
+
+// NewResponse 由响应和读出的消息体构造 HAR 响应, 响应的消息体不会被读取
+func NewResponse(resp *message.Response, body []byte) Response {
+	r := Response{
+		Status:      resp.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode))),
+		HTTPVersion: resp.Proto,
+		Cookies:     []Cookie{},
+		Headers:     headers(resp.Header),
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    int64(len(body)),
+		Content:     Content{Size: int64(len(body)), MimeType: resp.Header.Get("Content-Type")},
+	}
+	r.Content.Text, r.Content.Encoding = encodeText(body)
+	for _, v := range resp.Header.Values("Set-Cookie") {
+		if c, ok := parseSetCookie(v); ok {
+			r.Cookies = append(r.Cookies, c)
+		}
+	}
+	return r
+}
+
+// parseSetCookie 解析 Set-Cookie 中 HAR 关心的属性
+func parseSetCookie(line string) (Cookie, bool) {
+	parts := strings.Split(line, ";")
+	name, value, ok := strings.Cut(strings.TrimSpace(parts[0]), "=")
+	if !ok || name == "" {
+		return Cookie{}, false
+	}
+	c := Cookie{Name: name, Value: value}
+	for _, attr := range parts[1:] {
+		k, v, _ := strings.Cut(strings.TrimSpace(attr), "=")
+		switch strings.ToLower(k) {
+		case "path":
+			c.Path = v
+		case "domain":
+			c.Domain = v
+		case "expires":
+			if t, err := utils.ParseHTTPTime(v); err == nil {
+				c.Expires = &t
+			}
+		case "httponly":
+			c.HTTPOnly = true
+		case "secure":
+			c.Secure = true
+		}
+	}
+	return c, true
+}
+
+// Body 返回 Content 的原始字节, 按 Encoding 解码
+func (c Content) Body() ([]byte, error) {
+	return decodeText(c.Text, c.Encoding)
+}
+
+// Body 返回 PostData 的原始字节, 按 Encoding 解码
+func (p *PostData) Body() ([]byte, error) {
+	if p == nil {
+		return nil, nil
+	}
+	return decodeText(p.Text, p.Encoding)
+}
+
+// Millis 把时长转换为 HAR 使用的毫秒数
+func Millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Duration 把 HAR 的毫秒数转换为时长, 负数视为 0
+func Duration(ms float64) time.Duration {
+	if ms <= 0 {
+		return 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+func headers(h common.Header) []NameValue {
+	nv := []NameValue{}
+	for name, values := range h {
+		for _, v := range values {
+			nv = append(nv, NameValue{Name: name, Value: v})
+		}
+	}
+	// map 遍历无序, 排序使输出稳定; 同名头部保持原有顺序
+	sort.SliceStable(nv, func(i, j int) bool { return nv[i].Name < nv[j].Name })
+	return nv
+}
+
+// encodeText 有效的 UTF-8 原样作为文本, 否则 base64 编码
+func encodeText(b []byte) (text, encoding string) {
+	if utf8.Valid(b) {
+		return string(b), ""
+	}
+	return base64.StdEncoding.EncodeToString(b), "base64"
+}
+
+func decodeText(text, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
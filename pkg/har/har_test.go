@@ -0,0 +1,26 @@
+package har
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSetCookieExpires(t *testing.T) {
+	want := time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC)
+	for _, v := range []string{
+		"Wed, 21 Oct 2015 07:28:00 GMT",
+		"Wed, 21-Oct-2015 07:28:00 GMT",
+		"Wednesday, 21-Oct-15 07:28:00 GMT",
+	} {
+		c, ok := parseSetCookie("id=a3fWa; Expires=" + v + "; Path=/; Secure")
+		if !ok {
+			t.Fatalf("parseSetCookie(%q) failed", v)
+		}
+		if c.Expires == nil || !c.Expires.Equal(want) {
+			t.Errorf("Expires=%q parsed as %v, want %v", v, c.Expires, want)
+		}
+		if c.Path != "/" || !c.Secure {
+			t.Errorf("Expires=%q: cookie = %+v", v, c)
+		}
+	}
+}
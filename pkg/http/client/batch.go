@@ -0,0 +1,160 @@
+package client
+
+/*
+	批量请求, 以有限并发执行多个请求并汇总结果
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+)
+
+// ErrBatchAborted 请求因批量任务提前终止而未执行
+var ErrBatchAborted = errors.New("client: batch aborted")
+
+// BatchOptions 批量请求配置
+type BatchOptions struct {
+	// Concurrency 最大并发数, 默认 8
+	Concurrency int
+	// FailFast 出现致命错误后取消尚在执行的请求, 并跳过未开始的请求
+	FailFast bool
+	// IsFatal 判断结果是否为致命错误, 默认请求返回 error 即为致命
+	IsFatal func(r *BatchResult) bool
+}
+
+// BatchResult 单个请求的结果, 成功时调用方负责关闭 Response.Body
+type BatchResult struct {
+	Index    int
+	Request  *message.Request
+	Response *message.Response
+	Err      error
+}
+
+// BatchError 汇总批量请求中的失败
+type BatchError struct {
+	Failed []*BatchResult
+}
+
+func (e *BatchError) Error() string {
+	msgs := make([]string, 0, len(e.Failed))
+	for _, r := range e.Failed {
+		if r.Err == nil && r.Response != nil {
+			msgs = append(msgs, fmt.Sprintf("#%d: %s", r.Index, r.Response.Status))
+			continue
+		}
+		msgs = append(msgs, fmt.Sprintf("#%d: %v", r.Index, r.Err))
+	}
+	return fmt.Sprintf("client: %d request(s) failed: %s", len(e.Failed), strings.Join(msgs, "; "))
+}
+
+// Unwrap 返回全部失败原因
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, r := range e.Failed {
+		if r.Err != nil {
+			errs = append(errs, r.Err)
+		}
+	}
+	return errs
+}
+
+// DoAll 并发执行 reqs, 结果与请求按下标一一对应; 有请求失败时返回 *BatchError
+func (c *Client) DoAll(ctx context.Context, reqs []*message.Request, opts BatchOptions) ([]*BatchResult, error) {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 8
+	}
+	isFatal := opts.IsFatal
+	if isFatal == nil {
+		isFatal = func(r *BatchResult) bool { return r.Err != nil }
+	}
+
+	results := make([]*BatchResult, len(reqs))
+	sem := make(chan struct{}, concurrency)
+	abort := make(chan struct{})
+	var abortOnce sync.Once
+
+	var mu sync.Mutex
+	inflight := make(map[int]context.CancelFunc)
+	stopAll := func() {
+		abortOnce.Do(func() {
+			close(abort)
+			mu.Lock()
+			for _, cancel := range inflight {
+				cancel()
+			}
+			mu.Unlock()
+		})
+	}
+
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		res := &BatchResult{Index: i, Request: req}
+		results[i] = res
+
+		select {
+		case sem <- struct{}{}:
+		case <-abort:
+		case <-ctx.Done():
+		}
+		if isClosed(abort) || ctx.Err() != nil {
+			res.Err = ErrBatchAborted
+			if ctx.Err() != nil {
+				res.Err = ctx.Err()
+			}
+			continue
+		}
+
+		rctx, cancel := context.WithCancel(ctx)
+		mu.Lock()
+		inflight[i] = cancel
+		mu.Unlock()
+
+		wg.Add(1)
+		go func(i int, res *BatchResult, rctx context.Context, cancel context.CancelFunc) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			resp, err := c.Do(res.Request.WithContext(rctx))
+			mu.Lock()
+			delete(inflight, i)
+			mu.Unlock()
+			res.Response, res.Err = resp, err
+			if err != nil {
+				cancel()
+			} else {
+				// 消息体关闭时才释放上下文, 以免中断调用方读取
+				resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+			}
+			if opts.FailFast && isFatal(res) {
+				stopAll()
+			}
+		}(i, res, rctx, cancel)
+	}
+	wg.Wait()
+
+	var failed []*BatchResult
+	for _, r := range results {
+		if r.Err != nil || isFatal(r) {
+			failed = append(failed, r)
+		}
+	}
+	if len(failed) > 0 {
+		return results, &BatchError{Failed: failed}
+	}
+	return results, nil
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,268 @@
+package client
+
+/*
+	HTTP客户端熔断器, 按上游主机统计失败率, 在 closed/open/half-open 之间切换
+*/
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// ErrCircuitOpen 熔断器处于打开状态时返回, 可用 errors.Is 判断
+var ErrCircuitOpen = errors.New("client: circuit breaker is open")
+
+// CircuitOpenError 携带被熔断的主机和下一次允许探测的时间
+type CircuitOpenError struct {
+	Host    string
+	RetryAt time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("client: circuit breaker is open for %s (retry at %s)", e.Host, e.RetryAt.Format(time.RFC3339))
+}
+
+func (e *CircuitOpenError) Unwrap() error { return ErrCircuitOpen }
+
+// BreakerState 熔断器状态
+type BreakerState int
+
+const (
+	StateClosed BreakerState = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig 熔断器配置, 零值字段使用默认值
+type BreakerConfig struct {
+	// 统计窗口, 窗口结束后计数清零
+	Window time.Duration
+	// 窗口内最少请求数, 不足时不按失败率熔断
+	MinRequests int
+	// 失败率阈值 (0, 1], 达到后打开熔断器
+	FailureRatio float64
+	// 连续失败次数阈值, 达到后直接打开熔断器, 0 表示不启用
+	ConsecutiveFailures int
+	// 打开状态持续时间, 之后进入半开状态发送探测请求
+	ProbeInterval time.Duration
+	// ProbeBackoff 非空时探测连续失败会逐次延长打开状态的持续时间, 第 n 次连续打开持续 ProbeBackoff.Duration(n),
+	// 熔断器关闭后重新计数; 为空时固定使用 ProbeInterval
+	ProbeBackoff *utils.Backoff
+	// 半开状态下允许同时进行的探测请求数
+	MaxProbes int
+	// 半开状态下连续成功多少次后关闭熔断器
+	SuccessThreshold int
+	// 状态变化回调, 在锁外调用
+	OnStateChange func(host string, from, to BreakerState)
+	// Clock 时间来源, 为空时使用系统时钟
+	Clock utils.Clock
+}
+
+func (c *BreakerConfig) withDefaults() BreakerConfig {
+	cfg := *c
+	if cfg.Window <= 0 {
+		cfg.Window = 10 * time.Second
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 10
+	}
+	if cfg.FailureRatio <= 0 || cfg.FailureRatio > 1 {
+		cfg.FailureRatio = 0.5
+	}
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = 5 * time.Second
+	}
+	if cfg.MaxProbes <= 0 {
+		cfg.MaxProbes = 1
+	}
+	if cfg.SuccessThreshold <= 0 {
+		cfg.SuccessThreshold = 1
+	}
+	return cfg
+}
+
+// BreakerGroup 按主机维护一组熔断器
+type BreakerGroup struct {
+	cfg BreakerConfig
+	now func() time.Time
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+type breaker struct {
+	state       BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	consecutive int
+	retryAt     time.Time
+	trips       int
+	probes      int
+	successes   int
+}
+
+// NewBreakerGroup 创建按主机划分的熔断器组
+func NewBreakerGroup(cfg BreakerConfig) *BreakerGroup {
+	return &BreakerGroup{
+		cfg:      cfg.withDefaults(),
+		now:      utils.ClockOr(cfg.Clock).Now,
+		breakers: make(map[string]*breaker),
+	}
+}
+
+// Allow 判断是否允许向 host 发送请求, 允许时调用方必须在请求结束后调用 Done
+func (g *BreakerGroup) Allow(host string) error {
+	g.mu.Lock()
+	b := g.get(host)
+	now := g.now()
+	from := b.state
+
+	if b.state == StateOpen {
+		if now.Before(b.retryAt) {
+			g.mu.Unlock()
+			return &CircuitOpenError{Host: host, RetryAt: b.retryAt}
+		}
+		b.state = StateHalfOpen
+		b.probes = 0
+		b.successes = 0
+	}
+
+	if b.state == StateHalfOpen {
+		if b.probes >= g.cfg.MaxProbes {
+			g.mu.Unlock()
+			return &CircuitOpenError{Host: host, RetryAt: now.Add(g.cfg.ProbeInterval)}
+		}
+		b.probes++
+	}
+	to := b.state
+	g.mu.Unlock()
+
+	g.notify(host, from, to)
+	return nil
+}
+
+// Done 记录一次请求结果
+func (g *BreakerGroup) Done(host string, success bool) {
+	g.mu.Lock()
+	b := g.get(host)
+	now := g.now()
+	from := b.state
+
+	switch b.state {
+	case StateHalfOpen:
+		if b.probes > 0 {
+			b.probes--
+		}
+		if !success {
+			g.trip(b, now)
+			break
+		}
+		b.successes++
+		if b.successes >= g.cfg.SuccessThreshold {
+			b.state = StateClosed
+			b.trips = 0
+			g.resetWindow(b, now)
+		}
+	case StateClosed:
+		if now.Sub(b.windowStart) >= g.cfg.Window {
+			g.resetWindow(b, now)
+		}
+		b.requests++
+		if success {
+			b.consecutive = 0
+			break
+		}
+		b.failures++
+		b.consecutive++
+		if g.shouldTrip(b) {
+			g.trip(b, now)
+		}
+	}
+	to := b.state
+	g.mu.Unlock()
+
+	g.notify(host, from, to)
+}
+
+// State 返回 host 当前的熔断器状态
+func (g *BreakerGroup) State(host string) BreakerState {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	b, ok := g.breakers[host]
+	if !ok {
+		return StateClosed
+	}
+	if b.state == StateOpen && !g.now().Before(b.retryAt) {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+// Reset 清除 host 的熔断状态
+func (g *BreakerGroup) Reset(host string) {
+	g.mu.Lock()
+	delete(g.breakers, host)
+	g.mu.Unlock()
+}
+
+func (g *BreakerGroup) get(host string) *breaker {
+	b, ok := g.breakers[host]
+	if !ok {
+		b = &breaker{windowStart: g.now()}
+		g.breakers[host] = b
+	}
+	return b
+}
+
+func (g *BreakerGroup) shouldTrip(b *breaker) bool {
+	if g.cfg.ConsecutiveFailures > 0 && b.consecutive >= g.cfg.ConsecutiveFailures {
+		return true
+	}
+	if b.requests < g.cfg.MinRequests {
+		return false
+	}
+	return float64(b.failures)/float64(b.requests) >= g.cfg.FailureRatio
+}
+
+func (g *BreakerGroup) trip(b *breaker, now time.Time) {
+	b.state = StateOpen
+	b.trips++
+	openFor := g.cfg.ProbeInterval
+	if g.cfg.ProbeBackoff != nil {
+		openFor = g.cfg.ProbeBackoff.Duration(b.trips)
+	}
+	b.retryAt = now.Add(openFor)
+	b.probes = 0
+	b.successes = 0
+}
+
+func (g *BreakerGroup) resetWindow(b *breaker, now time.Time) {
+	b.windowStart = now
+	b.requests = 0
+	b.failures = 0
+	b.consecutive = 0
+}
+
+func (g *BreakerGroup) notify(host string, from, to BreakerState) {
+	if from != to && g.cfg.OnStateChange != nil {
+		g.cfg.OnStateChange(host, from, to)
+	}
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+)
+
+// fakeNow 返回可手动推进的时间函数
+func fakeNow(g *BreakerGroup) *time.Time {
+	now := time.Unix(1_700_000_000, 0)
+	g.now = func() time.Time { return now }
+	return &now
+}
+
+func TestBreakerStateTransitions(t *testing.T) {
+	var changes []string
+	g := NewBreakerGroup(BreakerConfig{
+		ConsecutiveFailures: 3,
+		ProbeInterval:       time.Second,
+		MaxProbes:           1,
+		SuccessThreshold:    2,
+		OnStateChange: func(host string, from, to BreakerState) {
+			changes = append(changes, from.String()+"->"+to.String())
+		},
+	})
+	now := fakeNow(g)
+	const host = "example.com:80"
+
+	for i := 0; i < 3; i++ {
+		if err := g.Allow(host); err != nil {
+			t.Fatalf("closed breaker rejected request %d: %v", i, err)
+		}
+		g.Done(host, false)
+	}
+	if s := g.State(host); s != StateOpen {
+		t.Fatalf("state after 3 failures = %v, want open", s)
+	}
+
+	err := g.Allow(host)
+	var oe *CircuitOpenError
+	if !errors.Is(err, ErrCircuitOpen) || !errors.As(err, &oe) {
+		t.Fatalf("open breaker Allow = %v, want *CircuitOpenError wrapping ErrCircuitOpen", err)
+	}
+	if oe.Host != host || !oe.RetryAt.Equal(now.Add(time.Second)) {
+		t.Fatalf("CircuitOpenError = %+v", oe)
+	}
+
+	*now = now.Add(time.Second)
+	if s := g.State(host); s != StateHalfOpen {
+		t.Fatalf("state after ProbeInterval = %v, want half-open", s)
+	}
+	if err := g.Allow(host); err != nil {
+		t.Fatalf("half-open breaker rejected the first probe: %v", err)
+	}
+	if err := g.Allow(host); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second concurrent probe = %v, want ErrCircuitOpen (MaxProbes 1)", err)
+	}
+	g.Done(host, true)
+	if s := g.State(host); s != StateHalfOpen {
+		t.Fatalf("state after 1 of 2 probe successes = %v, want half-open", s)
+	}
+	if err := g.Allow(host); err != nil {
+		t.Fatal(err)
+	}
+	g.Done(host, true)
+	if s := g.State(host); s != StateClosed {
+		t.Fatalf("state after SuccessThreshold probes = %v, want closed", s)
+	}
+
+	want := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if len(changes) != len(want) {
+		t.Fatalf("OnStateChange calls = %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("OnStateChange calls = %v, want %v", changes, want)
+		}
+	}
+}
+
+func TestBreakerFailedProbeReopens(t *testing.T) {
+	g := NewBreakerGroup(BreakerConfig{ConsecutiveFailures: 1, ProbeInterval: time.Second})
+	now := fakeNow(g)
+	const host = "example.com:80"
+	g.Allow(host)
+	g.Done(host, false)
+	*now = now.Add(time.Second)
+	if err := g.Allow(host); err != nil {
+		t.Fatal(err)
+	}
+	g.Done(host, false)
+	if s := g.State(host); s != StateOpen {
+		t.Fatalf("state after failed probe = %v, want open", s)
+	}
+}
+
+func TestBreakerFailureRatio(t *testing.T) {
+	g := NewBreakerGroup(BreakerConfig{MinRequests: 4, FailureRatio: 0.5, Window: time.Minute})
+	fakeNow(g)
+	const host = "example.com:80"
+	for _, ok := range []bool{true, false, true} {
+		g.Allow(host)
+		g.Done(host, ok)
+	}
+	if s := g.State(host); s != StateClosed {
+		t.Fatalf("state below MinRequests = %v, want closed", s)
+	}
+	g.Allow(host)
+	g.Done(host, false)
+	if s := g.State(host); s != StateOpen {
+		t.Fatalf("state at 2/4 failures = %v, want open", s)
+	}
+	if s := g.State("other.example:80"); s != StateClosed {
+		t.Fatalf("unrelated host state = %v, want closed", s)
+	}
+}
+
+func TestTransportBreakerStopsRequests(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				defer c.Close()
+				br := bufio.NewReader(c)
+				for {
+					line, err := br.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == "\r\n" {
+						c.Write([]byte("HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	tr := &Transport{Breakers: NewBreakerGroup(BreakerConfig{ConsecutiveFailures: 2, ProbeInterval: time.Hour})}
+	url := "http://" + ln.Addr().String() + "/"
+	for i := 0; i < 2; i++ {
+		req, _ := message.NewRequest("GET", url, nil)
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		resp.Body.Close()
+	}
+	req, _ := message.NewRequest("GET", url, nil)
+	if _, err := tr.RoundTrip(req); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("third request err = %v, want ErrCircuitOpen", err)
+	}
+	if n := accepted.Load(); n != 2 {
+		t.Fatalf("server accepted %d connections, want 2", n)
+	}
+}
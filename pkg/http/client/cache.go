@@ -0,0 +1,243 @@
+package client
+
+/*
+	HTTP客户端缓存层 (RFC 9111), 以 RoundTripper 形式包装下层传输
+*/
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/cache"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// XCacheHeader 标记响应来源的头部: HIT, MISS, REVALIDATED, STALE
+const XCacheHeader = cache.XCacheHeader
+
+// DefaultMaxCacheEntry 单个缓存条目默认的最大消息体字节数
+const DefaultMaxCacheEntry = cache.DefaultMaxEntrySize
+
+// CacheTransport 带缓存的 RoundTripper
+type CacheTransport struct {
+	// Transport 下层传输, 为空时使用默认 Transport
+	Transport RoundTripper
+	// Cache 非空时使用该 HTTP 缓存并忽略 Store、Shared、MaxEntrySize 和 Clock, 便于与其他组件共享缓存和调用失效接口
+	Cache *cache.HTTPCache
+	// Store 缓存存储, 如 cache.NewMemoryStore 或 cache.NewDiskStore
+	Store cache.Store
+	// Shared 为 true 时按共享缓存处理 (遵守 s-maxage/private)
+	Shared bool
+	// MaxEntrySize 超过该大小的消息体不缓存
+	MaxEntrySize int64
+	// Clock 计算新鲜度和年龄的时间来源, 为空时使用系统时钟
+	Clock utils.Clock
+
+	once  sync.Once
+	cache *cache.HTTPCache
+
+	// revalidating 正在后台验证的变体键, 同一变体同时只有一个验证请求
+	revalMu      sync.Mutex
+	revalidating map[string]struct{}
+}
+
+// NewCacheTransport 创建缓存传输层
+func NewCacheTransport(next RoundTripper, store cache.Store) *CacheTransport {
+	return &CacheTransport{Transport: next, Store: store}
+}
+
+func (t *CacheTransport) httpCache() *cache.HTTPCache {
+	t.once.Do(func() {
+		t.cache = t.Cache
+		if t.cache == nil {
+			t.cache = &cache.HTTPCache{Store: t.Store, Private: !t.Shared, MaxEntrySize: t.MaxEntrySize, Clock: t.Clock}
+		}
+	})
+	return t.cache
+}
+
+func (t *CacheTransport) next() RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return defaultTransport
+}
+
+var defaultTransport = &Transport{}
+
+// RoundTrip 实现 RoundTripper
+func (t *CacheTransport) RoundTrip(req *message.Request) (*message.Response, error) {
+	c := t.httpCache()
+	if req.Method != common.MethodGet && req.Method != common.MethodHead {
+		resp, err := t.next().RoundTrip(req)
+		if err == nil {
+			// 非安全方法成功后使缓存失效 (RFC 9111 4.4)
+			c.InvalidateUnsafe(req, resp.StatusCode, resp.Header)
+		}
+		return resp, err
+	}
+
+	reqCC := cache.ParseCacheControl(req.Header.Values("Cache-Control"))
+	if reqCC.Has("no-store") {
+		return t.next().RoundTrip(req)
+	}
+	entry := c.Get(req)
+	if entry == nil {
+		if reqCC.Has("only-if-cached") {
+			return gatewayTimeout(req), nil
+		}
+		return t.fetch(req)
+	}
+
+	switch state, age := c.Freshness(req, entry); state {
+	case cache.Fresh:
+		return entry.Response(req, age, "HIT"), nil
+	case cache.Stale:
+		return entry.Response(req, age, "STALE"), nil
+	case cache.StaleRevalidate:
+		t.startRevalidate(req, entry)
+		return entry.Response(req, age, "STALE"), nil
+	}
+	if reqCC.Has("only-if-cached") {
+		return gatewayTimeout(req), nil
+	}
+	return t.validate(req, entry)
+}
+
+// fetch 向上游请求并在允许时存储响应
+func (t *CacheTransport) fetch(req *message.Request) (*message.Response, error) {
+	reqTime := t.httpCache().Now()
+	resp, err := t.next().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Header.Set(XCacheHeader, "MISS")
+	if t.httpCache().Storable(req, resp.StatusCode, resp.Header) {
+		t.storeOnRead(req, resp, reqTime)
+	}
+	return resp, nil
+}
+
+// validate 携带验证器发送条件请求, 304 时更新并返回缓存的响应
+func (t *CacheTransport) validate(req *message.Request, entry *cache.Entry) (*message.Response, error) {
+	c := t.httpCache()
+	reqTime := c.Now()
+	resp, err := t.next().RoundTrip(entry.Conditional(req))
+	if err != nil {
+		if age, ok := c.UsableOnError(entry); ok {
+			return entry.Response(req, age, "STALE"), nil
+		}
+		return nil, err
+	}
+	if resp.StatusCode == common.StatusNotModified {
+		message.DrainAndClose(resp.Body, 4096)
+		entry.Merge(resp.Header, reqTime, c.Now())
+		c.Put(req, entry)
+		age := cache.CurrentAge(entry.Header, entry.RequestTime, entry.ResponseTime, c.Now())
+		return entry.Response(req, age, "REVALIDATED"), nil
+	}
+	if resp.StatusCode >= 500 {
+		if age, ok := c.UsableOnError(entry); ok {
+			message.DrainAndClose(resp.Body, 4096)
+			return entry.Response(req, age, "STALE"), nil
+		}
+	}
+	resp.Header.Set(XCacheHeader, "MISS")
+	if c.Storable(req, resp.StatusCode, resp.Header) {
+		t.storeOnRead(req, resp, reqTime)
+	} else {
+		c.Delete(req)
+	}
+	return resp, nil
+}
+
+// startRevalidate 在后台验证条目; 该变体已有验证在进行时直接返回, 热点键过期时不会涌向源站
+func (t *CacheTransport) startRevalidate(req *message.Request, entry *cache.Entry) {
+	key := cache.VariantKey(req, entry)
+	t.revalMu.Lock()
+	if _, busy := t.revalidating[key]; busy {
+		t.revalMu.Unlock()
+		return
+	}
+	if t.revalidating == nil {
+		t.revalidating = make(map[string]struct{})
+	}
+	t.revalidating[key] = struct{}{}
+	t.revalMu.Unlock()
+
+	// 调用方拿到过期响应后可能随即取消请求, 验证不应随之中止
+	req = req.Clone(context.WithoutCancel(req.Context()))
+	go func() {
+		defer func() {
+			t.revalMu.Lock()
+			delete(t.revalidating, key)
+			t.revalMu.Unlock()
+		}()
+		t.revalidate(req, entry)
+	}()
+}
+
+func (t *CacheTransport) revalidate(req *message.Request, entry *cache.Entry) {
+	resp, err := t.validate(req, entry)
+	if err != nil {
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// storeOnRead 在调用方读完消息体后存储响应
+func (t *CacheTransport) storeOnRead(req *message.Request, resp *message.Response, reqTime time.Time) {
+	c := t.httpCache()
+	entry := cache.NewEntry(req, resp.StatusCode, resp.Status, resp.Proto, resp.Header, reqTime)
+	resp.Body = &cachingBody{
+		body:  resp.Body,
+		limit: c.EntryLimit(),
+		done: func(body []byte) {
+			entry.Body = body
+			entry.ResponseTime = c.Now()
+			c.Put(req, entry)
+		},
+	}
+}
+
+func gatewayTimeout(req *message.Request) *message.Response {
+	resp := message.NewResponse(common.StatusGatewayTimeout)
+	resp.Request = req
+	return resp
+}
+
+// cachingBody 在消息体完整读到 EOF 时回调, 超出上限则放弃缓存
+type cachingBody struct {
+	body  io.ReadCloser
+	buf   bytes.Buffer
+	limit int64
+	over  bool
+	done  func([]byte)
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 && !b.over {
+		if int64(b.buf.Len()+n) > b.limit {
+			b.over = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.over && b.done != nil {
+		b.done(b.buf.Bytes())
+		b.done = nil
+	}
+	return n, err
+}
+
+func (b *cachingBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/cache"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+)
+
+func TestCacheRevalidatesEachVariantOnce(t *testing.T) {
+	release := make(chan struct{})
+	revalidated := make(chan string, 8)
+	var mu sync.Mutex
+	var ctxErrs []error
+	upstream := RoundTripperFunc(func(req *message.Request) (*message.Response, error) {
+		lang := req.Header.Get("Accept-Language")
+		if req.Header.Get("If-None-Match") != "" {
+			revalidated <- lang
+			<-release
+			mu.Lock()
+			ctxErrs = append(ctxErrs, req.Context().Err())
+			mu.Unlock()
+			resp := message.NewResponse(304)
+			resp.Header.Set("ETag", `"`+lang+`"`)
+			resp.Body = message.NoBody
+			return resp, nil
+		}
+		resp := message.NewResponse(200)
+		resp.Header.Set("Vary", "Accept-Language")
+		resp.Header.Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		resp.Header.Set("ETag", `"`+lang+`"`)
+		resp.Body = io.NopCloser(strings.NewReader(lang))
+		resp.ContentLength = int64(len(lang))
+		return resp, nil
+	})
+	tr := NewCacheTransport(upstream, cache.NewMemoryStore(0, 0))
+
+	get := func(ctx context.Context, lang string) string {
+		t.Helper()
+		req, _ := message.NewRequestWithContext(ctx, "GET", "http://example.com/doc", nil)
+		req.Header.Set("Accept-Language", lang)
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != lang {
+			t.Fatalf("body for %s = %q", lang, b)
+		}
+		return resp.Header.Get(XCacheHeader)
+	}
+	get(context.Background(), "en")
+	get(context.Background(), "fr")
+
+	for _, lang := range []string{"en", "fr", "en", "fr"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		if x := get(ctx, lang); x != "STALE" {
+			t.Fatalf("X-Cache for stale %s = %q, want STALE", lang, x)
+		}
+		cancel()
+	}
+
+	seen := map[string]bool{}
+	for len(seen) < 2 {
+		select {
+		case lang := <-revalidated:
+			if seen[lang] {
+				t.Fatalf("variant %s revalidated twice", lang)
+			}
+			seen[lang] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("revalidated variants = %v, want en and fr", seen)
+		}
+	}
+	close(release)
+	select {
+	case lang := <-revalidated:
+		t.Fatalf("variant %s revalidated again while a revalidation was running", lang)
+	case <-time.After(50 * time.Millisecond):
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(ctxErrs)
+		errs := append([]error(nil), ctxErrs...)
+		mu.Unlock()
+		if n == 2 {
+			for _, err := range errs {
+				if err != nil {
+					t.Fatalf("background revalidation saw a cancelled context: %v", err)
+				}
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("background revalidations did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
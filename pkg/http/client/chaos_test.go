@@ -0,0 +1,42 @@
+package client_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	httptest "github.com/narcilee7/http-stack/internal/testing"
+	"github.com/narcilee7/http-stack/pkg/http/client"
+)
+
+// 上游宕机期间请求失败, 恢复后客户端不应复用已断开的连接
+func TestClientRecoversAfterUpstreamOutage(t *testing.T) {
+	s := httptest.NewTestSuite(t)
+	srv := s.MockServer()
+	srv.Handle("GET", "/", &httptest.MockResponse{Body: []byte("ok")})
+	proxy := s.NewChaosProxy(srv.Addr)
+
+	c := client.New()
+	c.SetTimeout(2 * time.Second)
+	url := "http://" + proxy.Addr + "/"
+	get := func() error {
+		resp, err := c.Get(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+		return err
+	}
+	s.AssertNoError(get(), "before outage")
+
+	proxy.Kill()
+	if err := get(); err == nil {
+		t.Fatal("request succeeded while upstream was down")
+	}
+	s.AssertNoError(proxy.Restore())
+	s.AssertNoError(get(), "after restore")
+	if n := len(srv.Requests()); n != 2 {
+		t.Fatalf("upstream saw %d requests, want 2", n)
+	}
+}
@@ -3,3 +3,211 @@ package client
 /*
 	HTTP客户端实现, 支持HTTP/1.1和HTTP/2.0
 */
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// DefaultUserAgent 未配置时使用的 User-Agent
+const DefaultUserAgent = "http-stack/0.1"
+
+// Client HTTP客户端
+type Client struct {
+	transport   RoundTripper
+	timeout     time.Duration
+	headers     common.Header
+	tokenSource TokenSource
+	retry       *utils.Backoff
+}
+
+// Option 客户端配置项
+type Option func(*Client)
+
+// WithTransport 设置传输层
+func WithTransport(rt RoundTripper) Option {
+	return func(c *Client) { c.transport = rt }
+}
+
+// WithTokenSource 为每个请求注入 OAuth2 Bearer 令牌, 收到 401 时换新令牌重试一次
+func WithTokenSource(ts TokenSource) Option {
+	return func(c *Client) { c.tokenSource = ts }
+}
+
+// WithRetry 按退避策略重试幂等请求的网络错误和 502/503/504 响应, bo 为空时使用 DefaultRetryBackoff
+func WithRetry(bo *utils.Backoff) Option {
+	return func(c *Client) {
+		if bo == nil {
+			bo = &DefaultRetryBackoff
+		}
+		c.retry = bo
+	}
+}
+
+// WithTimeout 设置单次请求的总超时时间, 包括读取响应体
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// WithUserAgent 设置默认 User-Agent, 空字符串表示不发送
+func WithUserAgent(ua string) Option {
+	return func(c *Client) {
+		if ua == "" {
+			c.headers.Del("User-Agent")
+			return
+		}
+		c.headers.Set("User-Agent", ua)
+	}
+}
+
+// WithDefaultHeader 添加应用于每个请求的默认头部
+func WithDefaultHeader(key, value string) Option {
+	return func(c *Client) { c.headers.Add(key, value) }
+}
+
+// WithDefaultHeaders 批量设置默认头部, 覆盖同名的已有默认值
+func WithDefaultHeaders(h common.Header) Option {
+	return func(c *Client) {
+		for k, v := range h {
+			c.headers[common.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+		}
+	}
+}
+
+// WithoutDefaultHeader 移除默认头部
+func WithoutDefaultHeader(keys ...string) Option {
+	return func(c *Client) {
+		for _, k := range keys {
+			c.headers.Del(k)
+		}
+	}
+}
+
+// New 创建客户端
+func New(opts ...Option) *Client {
+	c := &Client{
+		headers: common.Header{"User-Agent": {DefaultUserAgent}},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.transport == nil {
+		c.transport = &Transport{}
+	}
+	if c.retry != nil {
+		c.transport = NewRetryTransport(c.transport, c.retry)
+	}
+	if c.tokenSource != nil {
+		c.transport = NewOAuth2Transport(c.transport, c.tokenSource)
+	}
+	return c
+}
+
+// SetTimeout 设置单次请求的总超时时间
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+// DefaultHeaders 返回默认头部的副本
+func (c *Client) DefaultHeaders() common.Header {
+	return c.headers.Clone()
+}
+
+// Transport 返回客户端使用的传输层
+func (c *Client) Transport() RoundTripper {
+	return c.transport
+}
+
+// OmitDefaultHeader 让单个请求不发送指定的默认头部
+func OmitDefaultHeader(req *message.Request, keys ...string) {
+	if req.Header == nil {
+		req.Header = make(common.Header)
+	}
+	for _, k := range keys {
+		req.Header[common.CanonicalHeaderKey(k)] = nil
+	}
+}
+
+// Get 发送 GET 请求
+func (c *Client) Get(url string) (*message.Response, error) {
+	req, err := message.NewRequest(common.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Post 发送 POST 请求
+func (c *Client) Post(url, contentType string, body io.Reader) (*message.Response, error) {
+	req, err := message.NewRequest(common.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return c.Do(req)
+}
+
+// Do 发送请求. 请求中已设置的头部优先于默认头部,
+// 值为 nil 的头部 (见 OmitDefaultHeader) 表示不发送对应的默认头部
+func (c *Client) Do(req *message.Request) (*message.Response, error) {
+	if req == nil {
+		return nil, errors.New("client: nil request")
+	}
+	req = c.prepare(req)
+
+	var cancel context.CancelFunc
+	if c.timeout > 0 {
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(req.Context(), c.timeout)
+		req = req.WithContext(ctx)
+	}
+	resp, err := c.transport.RoundTrip(req)
+	if err != nil {
+		if cancel != nil {
+			cancel()
+		}
+		return nil, err
+	}
+	if cancel != nil {
+		resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	}
+	return resp, nil
+}
+
+// prepare 合并默认头部, 不修改调用方的请求
+func (c *Client) prepare(req *message.Request) *message.Request {
+	if len(c.headers) == 0 {
+		return req
+	}
+	r2 := req.WithContext(req.Context())
+	r2.Header = req.Header.Clone()
+	if r2.Header == nil {
+		r2.Header = make(common.Header)
+	}
+	for k, v := range c.headers {
+		if _, ok := r2.Header[k]; ok {
+			continue
+		}
+		r2.Header[k] = append([]string(nil), v...)
+	}
+	return r2
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
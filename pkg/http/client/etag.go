@@ -0,0 +1,130 @@
+package client
+
+/*
+	条件 GET 辅助, 按 URL 保存验证器, 将 304 响应还原为之前保存的消息体
+*/
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+type validatorEntry struct {
+	etag         string
+	lastModified string
+	statusCode   int
+	status       string
+	header       common.Header
+	body         []byte
+}
+
+// ETagTransport 为 GET 请求自动附加 If-None-Match/If-Modified-Since,
+// 上游返回 304 时用之前保存的响应替代, 调用方始终看到完整响应
+type ETagTransport struct {
+	// Transport 下层传输, 为空时使用默认 Transport
+	Transport RoundTripper
+	// MaxBodySize 超过该大小的消息体不保存
+	MaxBodySize int64
+
+	mu      sync.Mutex
+	entries map[string]*validatorEntry
+}
+
+// NewETagTransport 创建条件 GET 传输层
+func NewETagTransport(next RoundTripper) *ETagTransport {
+	return &ETagTransport{Transport: next}
+}
+
+// Forget 删除 URL 对应的验证器
+func (t *ETagTransport) Forget(url string) {
+	t.mu.Lock()
+	delete(t.entries, url)
+	t.mu.Unlock()
+}
+
+// RoundTrip 实现 RoundTripper
+func (t *ETagTransport) RoundTrip(req *message.Request) (*message.Response, error) {
+	next := t.Transport
+	if next == nil {
+		next = defaultTransport
+	}
+	if req.Method != common.MethodGet || req.Header.Has("If-None-Match") || req.Header.Has("If-Modified-Since") {
+		return next.RoundTrip(req)
+	}
+
+	key := req.URL.String()
+	t.mu.Lock()
+	entry := t.entries[key]
+	t.mu.Unlock()
+
+	if entry != nil {
+		req = req.Clone(req.Context())
+		if entry.etag != "" {
+			req.Header.Set("If-None-Match", entry.etag)
+		}
+		if entry.lastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.lastModified)
+		}
+	}
+
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == common.StatusNotModified && entry != nil {
+		message.DrainAndClose(resp.Body, 4096)
+		h := entry.header.Clone()
+		for k, v := range resp.Header {
+			if k != "Content-Length" {
+				h[k] = v
+			}
+		}
+		return &message.Response{
+			StatusCode:    entry.statusCode,
+			Status:        entry.status,
+			Proto:         resp.Proto,
+			Header:        h,
+			Body:          io.NopCloser(bytes.NewReader(entry.body)),
+			ContentLength: int64(len(entry.body)),
+			Request:       resp.Request,
+		}, nil
+	}
+
+	etag, lm := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if resp.StatusCode != common.StatusOK || etag == "" && lm == "" {
+		if entry != nil {
+			t.Forget(key)
+		}
+		return resp, nil
+	}
+
+	limit := t.MaxBodySize
+	if limit <= 0 {
+		limit = DefaultMaxCacheEntry
+	}
+	saved := &validatorEntry{
+		etag:         etag,
+		lastModified: lm,
+		statusCode:   resp.StatusCode,
+		status:       resp.Status,
+		header:       resp.Header.Clone(),
+	}
+	resp.Body = &cachingBody{
+		body:  resp.Body,
+		limit: limit,
+		done: func(body []byte) {
+			saved.body = append([]byte(nil), body...)
+			t.mu.Lock()
+			if t.entries == nil {
+				t.entries = make(map[string]*validatorEntry)
+			}
+			t.entries[key] = saved
+			t.mu.Unlock()
+		},
+	}
+	return resp, nil
+}
@@ -0,0 +1,98 @@
+package client
+
+/*
+	空闲连接健康检查, 复用前探测连接是否已被对端关闭, 并在后台淘汰失效连接
+*/
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// connAlive 判断空闲连接是否仍然可用: 对端关闭或发送了未请求的数据都视为不可用
+func connAlive(conn net.Conn) bool {
+	for {
+		switch c := conn.(type) {
+		case *tls.Conn:
+			// 检查底层 socket, 空闲的 TLS 连接上出现任何记录 (如 close_notify) 都意味着不可复用
+			conn = c.NetConn()
+		case interface{ Unwrap() net.Conn }:
+			conn = c.Unwrap()
+		default:
+			return peekAlive(conn)
+		}
+	}
+}
+
+// deadlinePeek 不支持非阻塞探测时, 用极短的读超时尝试读取一个字节
+func deadlinePeek(conn net.Conn) bool {
+	var b [1]byte
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	_, err := conn.Read(b[:])
+	conn.SetReadDeadline(time.Time{})
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
+	}
+	return false
+}
+
+func (p *Pool) healthLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.evictDead()
+		}
+	}
+}
+
+// evictDead 取出全部空闲连接逐个检查, 可用的放回
+func (p *Pool) evictDead() {
+	now := time.Now()
+	p.mu.Lock()
+	candidates := make(map[string][]*PooledConn, len(p.hosts))
+	for key, hp := range p.hosts {
+		if len(hp.idle) > 0 {
+			candidates[key] = hp.idle
+			hp.m.idle.Add(-float64(len(hp.idle)))
+			hp.idle = nil
+		}
+	}
+	p.mu.Unlock()
+
+	for key, conns := range candidates {
+		var alive, dead []*PooledConn
+		var unhealthy uint64
+		for _, pc := range conns {
+			switch {
+			case p.cfg.IdleTimeout > 0 && now.Sub(pc.idleAt) > p.cfg.IdleTimeout:
+				dead = append(dead, pc)
+			case !connAlive(pc.Conn):
+				unhealthy++
+				dead = append(dead, pc)
+			default:
+				alive = append(alive, pc)
+			}
+		}
+
+		p.mu.Lock()
+		hp := p.host(key)
+		hp.m.evictions.Add(uint64(len(dead)))
+		hp.m.unhealthy.Add(unhealthy)
+		for _, pc := range alive {
+			if p.closed || len(hp.idle) >= p.cfg.MaxIdlePerHost {
+				hp.m.evictions.Inc()
+				dead = append(dead, pc)
+				continue
+			}
+			hp.idle = append(hp.idle, pc)
+			hp.m.idle.Inc()
+		}
+		p.mu.Unlock()
+		closeAll(dead)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package client
+
+import "net"
+
+func peekAlive(conn net.Conn) bool {
+	return deadlinePeek(conn)
+}
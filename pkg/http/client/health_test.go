@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPoolCheckHealthOnReuse(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		peer func(c net.Conn)
+	}{
+		{"peer closed", func(c net.Conn) { c.Close() }},
+		{"unsolicited data", func(c net.Conn) { c.Write([]byte("HTTP/1.1 408 Request Timeout\r\n\r\n")) }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, accepted := poolServer(t)
+			p := NewPool(PoolConfig{CheckHealthOnReuse: true})
+			defer p.Close()
+			ctx := context.Background()
+
+			pc, err := p.Get(ctx, "http", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.Put(pc)
+			tt.peer(<-accepted)
+			time.Sleep(20 * time.Millisecond)
+
+			again, err := p.Get(ctx, "http", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Discard(again)
+			if again.Reused() {
+				t.Fatal("unhealthy idle connection was reused")
+			}
+			if s := p.Stats().Total; s.Unhealthy != 1 || s.Evictions != 1 || s.Dials != 2 {
+				t.Fatalf("stats = %+v, want 1 unhealthy eviction and 2 dials", s)
+			}
+		})
+	}
+}
+
+func TestPoolHealthyConnReused(t *testing.T) {
+	addr, _ := poolServer(t)
+	p := NewPool(PoolConfig{CheckHealthOnReuse: true})
+	defer p.Close()
+	ctx := context.Background()
+
+	pc, err := p.Get(ctx, "http", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(pc)
+	again, err := p.Get(ctx, "http", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Discard(again)
+	if !again.Reused() {
+		t.Fatal("healthy idle connection was not reused")
+	}
+}
+
+func TestPoolHealthLoopEvictsDead(t *testing.T) {
+	addr, accepted := poolServer(t)
+	p := NewPool(PoolConfig{MaxIdlePerHost: 4, HealthCheckInterval: 10 * time.Millisecond})
+	defer p.Close()
+	ctx := context.Background()
+
+	a, err := p.Get(ctx, "http", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sa := <-accepted
+	b, err := p.Get(ctx, "http", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-accepted
+	p.Put(a)
+	p.Put(b)
+	sa.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s := p.Stats().Total
+		if s.Unhealthy == 1 && s.Idle == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stats = %+v, want the closed connection evicted and the live one kept", s)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	pc, err := p.Get(ctx, "http", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Discard(pc)
+	if !pc.Reused() || pc.Conn != b.Conn {
+		t.Fatal("live idle connection was not kept by the health check")
+	}
+}
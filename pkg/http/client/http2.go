@@ -0,0 +1,1282 @@
+package client
+
+/*
+	HTTP/2 客户端连接: 通过 ALPN 协商的 TLS 连接上多路复用并发请求.
+	读协程解析帧并把响应交给等待的请求, 帧的写出和 HPACK 编码由 wmu 串行化,
+	流表和流量控制窗口由 mu 保护; 需要同时持有时先取 wmu 再取 mu
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/hpack"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http1"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http2"
+	"github.com/narcilee7/http-stack/pkg/tcp"
+)
+
+// HTTP2Config 客户端 HTTP/2 参数, 零值字段使用默认值
+type HTTP2Config struct {
+	// MaxConcurrentStreams 单连接的并发请求上限, 与服务端的 SETTINGS_MAX_CONCURRENT_STREAMS 取较小者; 0 表示只受服务端限制
+	MaxConcurrentStreams uint32
+	// InitialWindowSize 每个流的接收窗口, 0 表示 1MB
+	InitialWindowSize uint32
+	// InitialConnWindowSize 连接级接收窗口, 0 表示 4MB, 允许多个流同时满窗口接收
+	InitialConnWindowSize uint32
+	// MaxReadFrameSize 允许服务端发送的最大帧载荷, 0 表示 1MB
+	MaxReadFrameSize uint32
+	// MaxHeaderListSize 响应头部列表的最大大小, 0 表示 1MB
+	MaxHeaderListSize uint32
+	// PriorityStrategy 多个请求体同时有数据待发时 DATA 帧的调度策略, 零值为 FIFO;
+	// 流的优先级取自请求的 Priority 头部 (RFC 9218)
+	PriorityStrategy http2.PriorityStrategy
+}
+
+const (
+	defaultHTTP2StreamWindow = 1 << 20
+	defaultHTTP2ConnWindow   = 4 << 20
+	defaultHTTP2MaxFrameSize = 1 << 20
+	// h2InitialMaxStreams 收到服务端 SETTINGS 之前假定的并发流上限
+	h2InitialMaxStreams = 100
+	// h2MaxAttempts 请求因服务端未处理而重试的总次数上限
+	h2MaxAttempts = 3
+	// h2MaxStreamID 流标识符的上限, 用尽后连接不再接受新请求
+	h2MaxStreamID = 1<<31 - 1
+)
+
+var (
+	// errHTTP2Unprocessed 服务端确认未处理该请求 (GOAWAY 或 REFUSED_STREAM), 可以在其他连接上安全重试
+	errHTTP2Unprocessed = errors.New("client: http2 request not processed by server")
+	errHTTP2ConnClosed  = errors.New("client: http2 connection closed")
+	errHTTP2BodyClosed  = errors.New("client: read on closed response body")
+	// errResponseHeaderTimeout 写出请求后超过 ResponseHeaderTimeout 未收到响应头
+	errResponseHeaderTimeout = errors.New("client: timeout awaiting response headers")
+)
+
+// withDefaults 返回补全默认值后的副本, c 可以为空
+func (c *HTTP2Config) withDefaults() HTTP2Config {
+	var conf HTTP2Config
+	if c != nil {
+		conf = *c
+	}
+	if conf.InitialWindowSize == 0 {
+		conf.InitialWindowSize = defaultHTTP2StreamWindow
+	}
+	conf.InitialWindowSize = min(conf.InitialWindowSize, http2.MaxWindowSize)
+	if conf.InitialConnWindowSize == 0 {
+		conf.InitialConnWindowSize = defaultHTTP2ConnWindow
+	}
+	conf.InitialConnWindowSize = min(max(conf.InitialConnWindowSize, http2.DefaultInitialWindowSize), http2.MaxWindowSize)
+	if conf.MaxReadFrameSize == 0 {
+		conf.MaxReadFrameSize = defaultHTTP2MaxFrameSize
+	}
+	conf.MaxReadFrameSize = min(max(conf.MaxReadFrameSize, http2.DefaultMaxFrameSize), http2.MaxFrameSizeLimit)
+	if conf.MaxHeaderListSize == 0 {
+		conf.MaxHeaderListSize = http1.DefaultMaxHeaderBytes
+	}
+	return conf
+}
+
+// limitStreams 按 MaxConcurrentStreams 收紧服务端给出的并发流上限
+func (c *HTTP2Config) limitStreams(n uint32) uint32 {
+	if c.MaxConcurrentStreams > 0 {
+		return min(n, c.MaxConcurrentStreams)
+	}
+	return n
+}
+
+type http2ConfigKey struct{}
+
+// WithHTTP2Config 让请求使用 conf 建立的 HTTP/2 连接, 覆盖 Transport.HTTP2.
+// 这类连接按 conf 单独归类, 携带同一个 conf 的请求共用连接, 用于在同一 Transport 上比较不同的窗口和调度参数
+func WithHTTP2Config(ctx context.Context, conf *HTTP2Config) context.Context {
+	return context.WithValue(ctx, http2ConfigKey{}, conf)
+}
+
+// http2ConfigFromContext 返回 WithHTTP2Config 设置的参数
+func http2ConfigFromContext(ctx context.Context) (*HTTP2Config, bool) {
+	conf, ok := ctx.Value(http2ConfigKey{}).(*HTTP2Config)
+	return conf, ok && conf != nil
+}
+
+// h2ConnectionHeaders HTTP/2 中禁止出现的连接级头部 (RFC 9113 8.2.2)
+var h2ConnectionHeaders = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// h2ConnSet 按主机键保存可以继续承载请求的 HTTP/2 连接, 并合并同一主机的并发建连
+type h2ConnSet struct {
+	mu      sync.Mutex
+	conns   map[string][]*h2ClientConn
+	dialing map[string]*h2Dial
+}
+
+// h2Dial 一次进行中的建连, done 关闭后 cc 为协商到 h2 的连接, 失败或协商到 HTTP/1.1 时为空
+type h2Dial struct {
+	done chan struct{}
+	cc   *h2ClientConn
+}
+
+// reserveLocked 在 key 的某条连接上预留一个流, 没有可用连接时返回空; 由调用方持有 s.mu
+func (s *h2ConnSet) reserveLocked(key string) *h2ClientConn {
+	for _, cc := range s.conns[key] {
+		if cc.reserveStream() {
+			return cc
+		}
+	}
+	return nil
+}
+
+func (s *h2ConnSet) add(cc *h2ClientConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[string][]*h2ClientConn)
+	}
+	s.conns[cc.key] = append(s.conns[cc.key], cc)
+}
+
+// remove 移除不再接受新请求的连接, 已在连接上的请求不受影响
+func (s *h2ConnSet) remove(cc *h2ClientConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns := s.conns[cc.key]
+	for i, c := range conns {
+		if c == cc {
+			conns = append(conns[:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(conns) == 0 {
+		delete(s.conns, cc.key)
+	} else {
+		s.conns[cc.key] = conns
+	}
+}
+
+// closeIdle 关闭没有活动请求的连接
+func (s *h2ConnSet) closeIdle() {
+	s.mu.Lock()
+	var all []*h2ClientConn
+	for _, conns := range s.conns {
+		all = append(all, conns...)
+	}
+	s.mu.Unlock()
+	for _, cc := range all {
+		cc.closeIfIdle()
+	}
+}
+
+// exchangeHTTP2 在可复用的 HTTP/2 连接或新建连接上发送 https 请求.
+// 新连接没有协商到 h2 时返回该连接, 由调用方按 HTTP/1.1 继续; 否则返回最终的响应或错误.
+// 服务端未处理的请求在消息体可重放时换一条连接重试
+func (t *Transport) exchangeHTTP2(req *message.Request, addr string) (*message.Response, *PooledConn, error) {
+	ctx := req.Context()
+	dialAddr, _ := DialAddrFromContext(ctx)
+	key := poolKey("https", addr, dialAddr)
+	conf := t.HTTP2
+	if c, ok := http2ConfigFromContext(ctx); ok {
+		conf = c
+		key += fmt.Sprintf("#h2conf=%p", c)
+	}
+	for attempt := 1; ; attempt++ {
+		cc, pc, err := t.getHTTP2(ctx, key, addr, conf)
+		if err != nil {
+			closeRequestBody(req)
+			return nil, nil, ctxErr(ctx, err)
+		}
+		if pc != nil {
+			return nil, pc, nil
+		}
+		resp, err := cc.roundTrip(req)
+		if err == nil || !errors.Is(err, errHTTP2Unprocessed) || attempt >= h2MaxAttempts || !req.Replayable() {
+			return resp, nil, err
+		}
+		if req, err = rewind(req); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// getHTTP2 返回已预留流的 HTTP/2 连接, 或新建的未协商到 h2 的连接.
+// 同一主机的并发请求等待进行中的建连, 协商到 h2 后共用该连接
+func (t *Transport) getHTTP2(ctx context.Context, key, addr string, conf *HTTP2Config) (*h2ClientConn, *PooledConn, error) {
+	s := &t.h2
+	for {
+		s.mu.Lock()
+		if cc := s.reserveLocked(key); cc != nil {
+			s.mu.Unlock()
+			return cc, nil, nil
+		}
+		d := s.dialing[key]
+		if d == nil {
+			d = &h2Dial{done: make(chan struct{})}
+			if s.dialing == nil {
+				s.dialing = make(map[string]*h2Dial)
+			}
+			s.dialing[key] = d
+			s.mu.Unlock()
+			cc, pc, err := t.dialHTTP2(ctx, key, addr, conf)
+			s.mu.Lock()
+			d.cc = cc
+			delete(s.dialing, key)
+			close(d.done)
+			s.mu.Unlock()
+			return cc, pc, err
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-d.done:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+		if d.cc == nil {
+			// 对端不支持 h2 或建连失败, 各自建连
+			return t.dialHTTP2(ctx, key, addr, conf)
+		}
+	}
+}
+
+// dialHTTP2 取得一条通过 ALPN 协商的连接, 协商到 h2 时在其上建立 HTTP/2 连接并预留一个流
+func (t *Transport) dialHTTP2(ctx context.Context, key, addr string, conf *HTTP2Config) (*h2ClientConn, *PooledConn, error) {
+	pc, err := t.Pool.get(ctx, "https", addr, t.Pool.alpnOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tc, ok := pc.Conn.(*tcp.Conn); !ok || tc.NegotiatedProtocol() != http2.NextProtoTLS {
+		return nil, pc, nil
+	}
+	cc, err := t.newH2ClientConn(ctx, key, pc, conf.withDefaults())
+	if err != nil {
+		return nil, nil, err
+	}
+	return cc, nil, nil
+}
+
+// h2ClientConn 一条客户端 HTTP/2 连接
+type h2ClientConn struct {
+	t    *Transport
+	key  string
+	conf HTTP2Config
+	pc   *PooledConn
+	fr   *http2.Framer
+	dec  *hpack.Decoder
+
+	// 读协程正在组装的头部块
+	hdrStream uint32
+	hdrEnd    bool
+	hdrBlock  []byte
+	// settingsc 收到服务端的第一个 SETTINGS 或连接关闭时关闭
+	settingsc   chan struct{}
+	gotSettings bool
+
+	wmu sync.Mutex
+	bw  *bufio.Writer
+	// sched 决定请求体的 DATA 帧在流之间的发送顺序
+	sched  *http2.WriteScheduler
+	enc    *hpack.Encoder
+	fields []hpack.HeaderField
+	hbuf   []byte
+
+	mu                sync.Mutex
+	cond              sync.Cond
+	streams           map[uint32]*h2ClientStream
+	nextStreamID      uint32
+	reserved          int
+	maxStreams        uint32
+	sendWindow        http2.Window
+	recvFlow          http2.InFlow
+	peerInitialWindow int64
+	peerMaxFrameSize  uint32
+	goAway            *http2.GoAwayError
+	// closing 空闲关闭中, 不再接受新请求
+	closing   bool
+	closed    bool
+	idleTimer *time.Timer
+}
+
+// h2ClientStream 一个请求/响应流
+type h2ClientStream struct {
+	cc   *h2ClientConn
+	req  *message.Request
+	body *h2ClientBody
+	// prio 请求的 Priority 头部给出的优先级
+	prio http2.Priority
+
+	// done 在响应头到达或请求失败时关闭, res 和 resErr 在此之后可读
+	once   sync.Once
+	done   chan struct{}
+	res    *message.Response
+	resErr error
+
+	// 以下字段只由读协程使用
+	resp    *message.Response
+	declLen int64
+	gotLen  int64
+
+	// cc.mu
+	id         uint32
+	state      http2.StreamState
+	sendWindow http2.Window
+	recvFlow   http2.InFlow
+	resetErr   error
+}
+
+// newH2ClientConn 在已协商 h2 的连接上发送前言和 SETTINGS, 启动读协程, 收到服务端的 SETTINGS 后登记连接,
+// 同时为调用方预留一个流. 失败时丢弃 pc
+func (t *Transport) newH2ClientConn(ctx context.Context, key string, pc *PooledConn, conf HTTP2Config) (*h2ClientConn, error) {
+	br := bufio.NewReader(pc)
+	bw := bufio.NewWriter(pc)
+	cc := &h2ClientConn{
+		t:                 t,
+		key:               key,
+		conf:              conf,
+		pc:                pc,
+		fr:                http2.NewFramer(bw, br),
+		dec:               hpack.NewDecoder(http2.DefaultHeaderTableSize),
+		bw:                bw,
+		enc:               hpack.NewEncoder(),
+		sched:             http2.NewWriteScheduler(conf.PriorityStrategy),
+		streams:           make(map[uint32]*h2ClientStream),
+		nextStreamID:      1,
+		reserved:          1,
+		maxStreams:        conf.limitStreams(h2InitialMaxStreams),
+		peerInitialWindow: http2.DefaultInitialWindowSize,
+		peerMaxFrameSize:  http2.DefaultMaxFrameSize,
+		settingsc:         make(chan struct{}),
+	}
+	cc.cond.L = &cc.mu
+	cc.fr.MaxReadFrameSize = conf.MaxReadFrameSize
+	cc.dec.MaxHeaderListSize = conf.MaxHeaderListSize
+	cc.dec.MaxStringLength = int(conf.MaxHeaderListSize)
+	cc.sendWindow.Add(http2.DefaultInitialWindowSize)
+	cc.recvFlow.Init(int64(conf.InitialConnWindowSize))
+
+	err := cc.writeFrame(func(fr *http2.Framer) error {
+		if _, err := cc.bw.WriteString(http2.ClientPreface); err != nil {
+			return err
+		}
+		if err := fr.WriteSettings(
+			http2.Setting{ID: http2.SettingEnablePush, Val: 0},
+			http2.Setting{ID: http2.SettingInitialWindowSize, Val: conf.InitialWindowSize},
+			http2.Setting{ID: http2.SettingMaxFrameSize, Val: conf.MaxReadFrameSize},
+			http2.Setting{ID: http2.SettingMaxHeaderListSize, Val: conf.MaxHeaderListSize},
+		); err != nil {
+			return err
+		}
+		if inc := conf.InitialConnWindowSize - http2.DefaultInitialWindowSize; inc > 0 {
+			return fr.WriteWindowUpdate(0, inc)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Pool.Discard(pc)
+		return nil, err
+	}
+	go cc.readLoop()
+
+	// 等待服务端的 SETTINGS, 以便按其并发流上限分配请求
+	select {
+	case <-cc.settingsc:
+	case <-ctx.Done():
+		cc.pc.Close()
+		return nil, ctx.Err()
+	}
+	cc.mu.Lock()
+	closed := cc.closed
+	cc.mu.Unlock()
+	if closed {
+		return nil, errHTTP2ConnClosed
+	}
+	t.h2.add(cc)
+	return cc, nil
+}
+
+// reserveStream 连接还能承载新请求时预留一个流
+func (cc *h2ClientConn) reserveStream() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.closed || cc.closing || cc.goAway != nil {
+		return false
+	}
+	if uint32(len(cc.streams)+cc.reserved) >= cc.maxStreams {
+		return false
+	}
+	if int64(cc.nextStreamID)+2*int64(cc.reserved) > h2MaxStreamID {
+		return false
+	}
+	cc.reserved++
+	cc.stopIdleTimer()
+	return true
+}
+
+// writeFrame 在写锁内写出帧并 Flush, 失败时关闭连接使读协程退出
+func (cc *h2ClientConn) writeFrame(fn func(fr *http2.Framer) error) error {
+	cc.wmu.Lock()
+	defer cc.wmu.Unlock()
+	err := fn(cc.fr)
+	if err == nil {
+		err = cc.bw.Flush()
+	}
+	if err != nil {
+		cc.pc.Close()
+	}
+	return err
+}
+
+// roundTrip 使用预留的流发送请求并等待响应头
+func (cc *h2ClientConn) roundTrip(req *message.Request) (*message.Response, error) {
+	ctx := req.Context()
+	hasBody := req.Body != nil && req.Body != message.NoBody
+	if !hasBody {
+		closeRequestBody(req)
+	}
+	st := &h2ClientStream{cc: cc, req: req, done: make(chan struct{}), declLen: -1,
+		prio: http2.ParsePriority(req.Header.Get("Priority"))}
+	st.body = &h2ClientBody{st: st}
+	st.body.cond.L = &st.body.mu
+	if err := cc.writeRequestHeaders(st, !hasBody); err != nil {
+		if hasBody {
+			closeRequestBody(req)
+		}
+		return nil, ctxErr(ctx, err)
+	}
+	if hasBody {
+		go cc.writeBody(st)
+	}
+
+	var timeout <-chan time.Time
+	if d := cc.t.ResponseHeaderTimeout; d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-st.done:
+	case <-ctx.Done():
+		cc.resetStream(st, http2.ErrCodeCancel, ctx.Err())
+	case <-timeout:
+		cc.resetStream(st, http2.ErrCodeCancel, errResponseHeaderTimeout)
+	}
+	<-st.done
+	if st.resErr != nil {
+		return nil, ctxErr(ctx, st.resErr)
+	}
+	resp := st.res
+	if resp.Body != message.NoBody {
+		// 读取响应体期间上下文取消时重置流
+		st.body.stop = context.AfterFunc(ctx, func() {
+			cc.resetStream(st, http2.ErrCodeCancel, ctx.Err())
+		})
+	}
+	return resp, nil
+}
+
+// deliver 交付响应头或错误, 只有第一次调用生效
+func (st *h2ClientStream) deliver(resp *message.Response, err error) {
+	st.once.Do(func() {
+		st.res, st.resErr = resp, err
+		close(st.done)
+	})
+}
+
+// fail 以 err 结束流: 尚未收到响应头时请求返回 err, 否则响应体读取返回 err
+func (st *h2ClientStream) fail(err error) {
+	st.deliver(nil, err)
+	st.body.closeWithError(err)
+}
+
+// writeRequestHeaders 分配流标识符并发出请求头部; 两者在同一写锁内完成, 保证标识符按序出现在连接上
+func (cc *h2ClientConn) writeRequestHeaders(st *h2ClientStream, end bool) error {
+	cc.wmu.Lock()
+	defer cc.wmu.Unlock()
+
+	cc.mu.Lock()
+	cc.reserved--
+	if cc.closed || cc.goAway != nil {
+		cc.mu.Unlock()
+		return fmt.Errorf("%w: connection no longer accepts requests", errHTTP2Unprocessed)
+	}
+	st.id = cc.nextStreamID
+	cc.nextStreamID += 2
+	st.state = http2.StateOpen
+	if end {
+		st.state = st.state.SendEndStream()
+	}
+	st.sendWindow.Add(cc.peerInitialWindow)
+	st.recvFlow.Init(int64(cc.conf.InitialWindowSize))
+	cc.streams[st.id] = st
+	maxFrame := cc.peerMaxFrameSize
+	cc.mu.Unlock()
+
+	cc.fields = appendRequestFields(cc.fields[:0], st.req, end)
+	cc.hbuf = cc.enc.AppendBlock(cc.hbuf[:0], cc.fields...)
+	err := cc.fr.WriteHeaderBlock(st.id, end, cc.hbuf, maxFrame)
+	if err == nil {
+		err = cc.bw.Flush()
+	}
+	if err != nil {
+		// 头部没有完整写出, 服务端不可能处理了该请求
+		cc.pc.Close()
+		return fmt.Errorf("%w: %v", errHTTP2Unprocessed, err)
+	}
+	return nil
+}
+
+// appendRequestFields 把请求转换为 HTTP/2 头部字段; end 为 true 表示请求没有消息体
+func appendRequestFields(dst []hpack.HeaderField, req *message.Request, end bool) []hpack.HeaderField {
+	dst = append(dst, hpack.HeaderField{Name: ":method", Value: req.Method})
+	if req.Method == common.MethodConnect {
+		dst = append(dst, hpack.HeaderField{Name: ":authority", Value: req.HostHeader()})
+	} else {
+		dst = append(dst,
+			hpack.HeaderField{Name: ":scheme", Value: "https"},
+			hpack.HeaderField{Name: ":authority", Value: req.HostHeader()},
+			hpack.HeaderField{Name: ":path", Value: req.RequestURI()},
+		)
+	}
+	for k, vs := range req.Header {
+		name := strings.ToLower(k)
+		if h2ConnectionHeaders[name] || name == "host" {
+			continue
+		}
+		sensitive := name == "authorization" || name == "proxy-authorization"
+		for _, v := range vs {
+			if name == "te" && v != "trailers" {
+				continue
+			}
+			dst = append(dst, hpack.HeaderField{Name: name, Value: v, Sensitive: sensitive})
+		}
+	}
+	if !req.Header.Has("Content-Length") {
+		switch {
+		case req.ContentLength > 0 && !end:
+			dst = append(dst, hpack.HeaderField{Name: "content-length", Value: strconv.FormatInt(req.ContentLength, 10)})
+		case end && methodExpectsBody(req.Method):
+			dst = append(dst, hpack.HeaderField{Name: "content-length", Value: "0"})
+		}
+	}
+	if len(req.Trailer) > 0 && !req.Header.Has("Trailer") {
+		keys := make([]string, 0, len(req.Trailer))
+		for k := range req.Trailer {
+			keys = append(keys, strings.ToLower(k))
+		}
+		dst = append(dst, hpack.HeaderField{Name: "trailer", Value: strings.Join(keys, ", ")})
+	}
+	return dst
+}
+
+func methodExpectsBody(method string) bool {
+	return method == common.MethodPost || method == common.MethodPut || method == common.MethodPatch
+}
+
+// writeBody 在独立的协程中按流量控制发送请求体, 有 trailer 时以 HEADERS 帧结束流
+func (cc *h2ClientConn) writeBody(st *h2ClientStream) {
+	req := st.req
+	defer closeRequestBody(req)
+	buf := make([]byte, 16<<10)
+	var written int64
+	for {
+		n, rerr := req.Body.Read(buf)
+		if n > 0 {
+			written += int64(n)
+			if err := cc.writeData(st, buf[:n], false); err != nil {
+				return
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			cc.resetStream(st, http2.ErrCodeCancel, fmt.Errorf("client: read request body: %w", rerr))
+			return
+		}
+	}
+	if req.ContentLength > 0 && written != req.ContentLength {
+		cc.resetStream(st, http2.ErrCodeCancel, fmt.Errorf("client: request body length %d, declared %d", written, req.ContentLength))
+		return
+	}
+	if len(req.Trailer) == 0 {
+		cc.writeData(st, nil, true)
+		return
+	}
+	cc.writeTrailers(st, req.Trailer)
+}
+
+// writable 返回流不能再发送的原因, 由调用方持有 cc.mu
+func (st *h2ClientStream) writable() error {
+	switch {
+	case st.resetErr != nil:
+		return st.resetErr
+	case st.cc.closed:
+		return errHTTP2ConnClosed
+	case !st.state.CanSendData():
+		return errHTTP2ConnClosed
+	}
+	return nil
+}
+
+// writeData 按流量控制窗口和对端的 MAX_FRAME_SIZE 切分发送 p, end 为 true 时最后一帧带 END_STREAM
+func (cc *h2ClientConn) writeData(st *h2ClientStream, p []byte, end bool) error {
+	for {
+		cc.mu.Lock()
+		var n int
+		for {
+			if err := st.writable(); err != nil {
+				cc.mu.Unlock()
+				return err
+			}
+			if len(p) == 0 {
+				break
+			}
+			avail := min(st.sendWindow.Available(), cc.sendWindow.Available(), int64(cc.peerMaxFrameSize))
+			if avail > 0 {
+				n = int(min(avail, int64(len(p))))
+				break
+			}
+			cc.cond.Wait()
+		}
+		st.sendWindow.Take(int64(n))
+		cc.sendWindow.Take(int64(n))
+		last := end && n == len(p)
+		if last {
+			st.state = st.state.SendEndStream()
+		}
+		closed := st.state == http2.StateClosed
+		cc.mu.Unlock()
+
+		chunk := p[:n]
+		cc.sched.Acquire(st.id, st.prio)
+		err := cc.writeFrame(func(fr *http2.Framer) error { return fr.WriteData(st.id, last, chunk) })
+		cc.sched.Release()
+		if err != nil {
+			return err
+		}
+		if closed {
+			cc.removeStream(st)
+		}
+		p = p[n:]
+		if len(p) == 0 && (last || !end) {
+			return nil
+		}
+	}
+}
+
+// writeTrailers 以带 END_STREAM 的 HEADERS 帧发送请求 trailer
+func (cc *h2ClientConn) writeTrailers(st *h2ClientStream, trailer common.Header) error {
+	cc.mu.Lock()
+	if err := st.writable(); err != nil {
+		cc.mu.Unlock()
+		return err
+	}
+	st.state = st.state.SendEndStream()
+	closed := st.state == http2.StateClosed
+	maxFrame := cc.peerMaxFrameSize
+	cc.mu.Unlock()
+
+	err := cc.writeFrame(func(fr *http2.Framer) error {
+		cc.fields = cc.fields[:0]
+		for k, vs := range trailer {
+			name := strings.ToLower(k)
+			for _, v := range vs {
+				cc.fields = append(cc.fields, hpack.HeaderField{Name: name, Value: v})
+			}
+		}
+		cc.hbuf = cc.enc.AppendBlock(cc.hbuf[:0], cc.fields...)
+		return fr.WriteHeaderBlock(st.id, true, cc.hbuf, maxFrame)
+	})
+	if closed {
+		cc.removeStream(st)
+	}
+	return err
+}
+
+// readLoop 读取并处理服务端的帧, 退出时关闭连接并结束所有流
+func (cc *h2ClientConn) readLoop() {
+	err := cc.readFrames()
+	var ce http2.ConnectionError
+	if errors.As(err, &ce) {
+		cc.writeFrame(func(fr *http2.Framer) error { return fr.WriteGoAway(0, http2.ErrCode(ce), nil) })
+	}
+	cc.close(err)
+}
+
+func (cc *h2ClientConn) readFrames() error {
+	first := true
+	for {
+		f, err := cc.fr.ReadFrame()
+		if err == nil {
+			if sf, ok := f.(*http2.SettingsFrame); first && (!ok || sf.IsAck()) {
+				// 服务端的连接前言是一个 SETTINGS 帧
+				err = http2.ConnectionError(http2.ErrCodeProtocol)
+			} else {
+				first = false
+				err = cc.processFrame(f)
+			}
+		}
+		if err == nil {
+			continue
+		}
+		var se *http2.StreamError
+		if errors.As(err, &se) {
+			cc.mu.Lock()
+			st := cc.streams[se.StreamID]
+			cc.mu.Unlock()
+			if st != nil {
+				cc.resetStream(st, se.Code, se)
+			} else {
+				cc.writeFrame(func(fr *http2.Framer) error { return fr.WriteRSTStream(se.StreamID, se.Code) })
+			}
+			continue
+		}
+		return err
+	}
+}
+
+// close 在读协程退出时关闭连接, 以 err 结束所有未完成的流
+func (cc *h2ClientConn) close(err error) {
+	if !cc.gotSettings {
+		close(cc.settingsc)
+	}
+	cc.t.h2.remove(cc)
+	cc.mu.Lock()
+	cc.closed = true
+	cc.stopIdleTimer()
+	streams := make([]*h2ClientStream, 0, len(cc.streams))
+	for _, st := range cc.streams {
+		streams = append(streams, st)
+	}
+	clear(cc.streams)
+	cc.cond.Broadcast()
+	cc.mu.Unlock()
+	cc.t.Pool.Discard(cc.pc)
+
+	if err == nil || errors.Is(err, io.EOF) {
+		err = errHTTP2ConnClosed
+	} else {
+		err = fmt.Errorf("%w: %v", errHTTP2ConnClosed, err)
+	}
+	for _, st := range streams {
+		st.fail(err)
+	}
+}
+
+func (cc *h2ClientConn) processFrame(f http2.Frame) error {
+	switch f := f.(type) {
+	case *http2.SettingsFrame:
+		return cc.processSettings(f)
+	case *http2.HeadersFrame:
+		cc.hdrStream, cc.hdrEnd = f.StreamID, f.StreamEnded()
+		cc.hdrBlock = append(cc.hdrBlock[:0], f.BlockFragment...)
+		if f.HeadersEnded() {
+			return cc.processHeaderBlock()
+		}
+	case *http2.ContinuationFrame:
+		cc.hdrBlock = append(cc.hdrBlock, f.BlockFragment...)
+		if len(cc.hdrBlock) > 2*http1.DefaultMaxHeaderBytes {
+			return http2.ConnectionError(http2.ErrCodeEnhanceYourCalm)
+		}
+		if f.HeadersEnded() {
+			return cc.processHeaderBlock()
+		}
+	case *http2.DataFrame:
+		return cc.processData(f)
+	case *http2.WindowUpdateFrame:
+		return cc.processWindowUpdate(f)
+	case *http2.RSTStreamFrame:
+		return cc.processRSTStream(f)
+	case *http2.PingFrame:
+		if !f.IsAck() {
+			data := f.Data
+			return cc.writeFrame(func(fr *http2.Framer) error { return fr.WritePing(true, data) })
+		}
+	case *http2.GoAwayFrame:
+		cc.processGoAway(f)
+	case *http2.PushPromiseFrame:
+		// 已通过 SETTINGS_ENABLE_PUSH 禁止推送
+		return http2.ConnectionError(http2.ErrCodeProtocol)
+	}
+	// PRIORITY 和未知类型的帧忽略
+	return nil
+}
+
+func (cc *h2ClientConn) processSettings(f *http2.SettingsFrame) error {
+	if f.IsAck() {
+		return nil
+	}
+	for _, s := range f.Settings {
+		switch s.ID {
+		case http2.SettingHeaderTableSize:
+			cc.wmu.Lock()
+			cc.enc.SetMaxDynamicTableSizeLimit(s.Val)
+			cc.wmu.Unlock()
+		case http2.SettingInitialWindowSize:
+			cc.mu.Lock()
+			delta := int64(s.Val) - cc.peerInitialWindow
+			cc.peerInitialWindow = int64(s.Val)
+			for _, st := range cc.streams {
+				if !st.sendWindow.Add(delta) {
+					cc.mu.Unlock()
+					return http2.ConnectionError(http2.ErrCodeFlowControl)
+				}
+			}
+			cc.cond.Broadcast()
+			cc.mu.Unlock()
+		case http2.SettingMaxFrameSize:
+			cc.mu.Lock()
+			cc.peerMaxFrameSize = s.Val
+			cc.mu.Unlock()
+		case http2.SettingMaxConcurrentStreams:
+			cc.mu.Lock()
+			cc.maxStreams = cc.conf.limitStreams(s.Val)
+			cc.mu.Unlock()
+		}
+	}
+	err := cc.writeFrame(func(fr *http2.Framer) error { return fr.WriteSettingsAck() })
+	if !cc.gotSettings {
+		cc.gotSettings = true
+		close(cc.settingsc)
+	}
+	return err
+}
+
+func (cc *h2ClientConn) processWindowUpdate(f *http2.WindowUpdateFrame) error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if f.StreamID == 0 {
+		if !cc.sendWindow.Add(int64(f.Increment)) {
+			return http2.ConnectionError(http2.ErrCodeFlowControl)
+		}
+		cc.cond.Broadcast()
+		return nil
+	}
+	if f.StreamID >= cc.nextStreamID {
+		return http2.ConnectionError(http2.ErrCodeProtocol)
+	}
+	if st := cc.streams[f.StreamID]; st != nil {
+		if !st.sendWindow.Add(int64(f.Increment)) {
+			return &http2.StreamError{StreamID: f.StreamID, Code: http2.ErrCodeFlowControl}
+		}
+		cc.cond.Broadcast()
+	}
+	return nil
+}
+
+// processRSTStream 服务端重置流; REFUSED_STREAM 表示请求未被处理
+func (cc *h2ClientConn) processRSTStream(f *http2.RSTStreamFrame) error {
+	cc.mu.Lock()
+	idle := f.StreamID >= cc.nextStreamID
+	st := cc.streams[f.StreamID]
+	cc.mu.Unlock()
+	if idle {
+		return http2.ConnectionError(http2.ErrCodeProtocol)
+	}
+	if st == nil {
+		return nil
+	}
+	var err error = &http2.StreamError{StreamID: f.StreamID, Code: f.Code}
+	if f.Code == http2.ErrCodeRefusedStream && st.resp == nil {
+		err = fmt.Errorf("%w: %v", errHTTP2Unprocessed, err)
+	}
+	cc.closeStream(st, err)
+	return nil
+}
+
+// processGoAway 停止在连接上发起新请求; 标识符大于 LastStreamID 的流未被处理, 以可重试的错误结束
+func (cc *h2ClientConn) processGoAway(f *http2.GoAwayFrame) {
+	cc.t.h2.remove(cc)
+	goAway := &http2.GoAwayError{LastStreamID: f.LastStreamID, Code: f.Code, Debug: string(f.Debug)}
+	cc.mu.Lock()
+	cc.goAway = goAway
+	var unprocessed []*h2ClientStream
+	for id, st := range cc.streams {
+		if id > f.LastStreamID {
+			unprocessed = append(unprocessed, st)
+		}
+	}
+	cc.mu.Unlock()
+	for _, st := range unprocessed {
+		cc.closeStream(st, fmt.Errorf("%w: %v", errHTTP2Unprocessed, goAway))
+	}
+	cc.closeIfIdle()
+}
+
+// processHeaderBlock 解码完整的头部块, 作为响应头或响应 trailer 交给对应的流
+func (cc *h2ClientConn) processHeaderBlock() error {
+	id, end := cc.hdrStream, cc.hdrEnd
+	cc.hdrStream = 0
+	fields, err := cc.dec.Decode(cc.hdrBlock)
+	tooLarge := errors.Is(err, hpack.ErrHeaderListTooLarge)
+	if err != nil && !tooLarge {
+		return http2.ConnectionError(http2.ErrCodeCompression)
+	}
+
+	cc.mu.Lock()
+	st := cc.streams[id]
+	idle := id >= cc.nextStreamID || id%2 == 0
+	cc.mu.Unlock()
+	if st == nil {
+		if idle {
+			return http2.ConnectionError(http2.ErrCodeProtocol)
+		}
+		// 已重置的流
+		return nil
+	}
+	if tooLarge {
+		return &http2.StreamError{StreamID: id, Code: http2.ErrCodeProtocol, Cause: err}
+	}
+	if st.resp != nil {
+		return cc.processTrailers(st, fields, end)
+	}
+	return cc.processResponse(st, fields, end)
+}
+
+// processResponse 由响应头构造响应并交给等待的请求, 1xx 信息性响应被跳过
+func (cc *h2ClientConn) processResponse(st *h2ClientStream, fields []hpack.HeaderField, end bool) error {
+	malformed := func(format string, args ...any) error {
+		return &http2.StreamError{StreamID: st.id, Code: http2.ErrCodeProtocol, Cause: fmt.Errorf(format, args...)}
+	}
+	status := ""
+	header := make(common.Header, len(fields))
+	for _, f := range fields {
+		if f.IsPseudo() {
+			if f.Name != ":status" || status != "" || len(header) > 0 {
+				return malformed("unexpected pseudo-header %s", f.Name)
+			}
+			status = f.Value
+			continue
+		}
+		if h2ConnectionHeaders[f.Name] {
+			return malformed("connection-specific header %s", f.Name)
+		}
+		header.Add(f.Name, f.Value)
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil || len(status) != 3 || code < 100 {
+		return malformed("invalid :status %q", status)
+	}
+	if code < 200 {
+		if end || code == common.StatusSwitchingProtocols {
+			return malformed("invalid informational response %d", code)
+		}
+		return nil
+	}
+
+	resp := &message.Response{
+		StatusCode:    code,
+		Status:        message.StatusLine(code),
+		Proto:         "HTTP/2.0",
+		Header:        header,
+		ContentLength: -1,
+		Request:       st.req,
+	}
+	if v := header.Get("Content-Length"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return malformed("invalid content-length %q", v)
+		}
+		resp.ContentLength = n
+		if st.req.Method != common.MethodHead {
+			st.declLen = n
+		}
+	}
+	st.resp = resp
+	if !end {
+		resp.Body = &bodyEOFSignal{body: st.body, fn: func(bool) { st.body.finish() }}
+		st.deliver(resp, nil)
+		return nil
+	}
+	if st.declLen > 0 {
+		return malformed("content-length %d with empty body", st.declLen)
+	}
+	resp.Body = message.NoBody
+	if resp.ContentLength < 0 {
+		resp.ContentLength = 0
+	}
+	st.deliver(resp, nil)
+	cc.recvEndStream(st)
+	return nil
+}
+
+// processTrailers 处理响应 trailer, 必须带 END_STREAM 且不含伪头部
+func (cc *h2ClientConn) processTrailers(st *h2ClientStream, fields []hpack.HeaderField, end bool) error {
+	if !end {
+		return &http2.StreamError{StreamID: st.id, Code: http2.ErrCodeProtocol}
+	}
+	trailer := make(common.Header, len(fields))
+	for _, f := range fields {
+		if f.IsPseudo() {
+			return &http2.StreamError{StreamID: st.id, Code: http2.ErrCodeProtocol}
+		}
+		trailer.Add(f.Name, f.Value)
+	}
+	if st.declLen >= 0 && st.gotLen != st.declLen {
+		return &http2.StreamError{StreamID: st.id, Code: http2.ErrCodeProtocol}
+	}
+	// trailer 在响应体返回 EOF 之前写入, 读到 EOF 的调用方可以安全读取
+	st.resp.Trailer = trailer
+	cc.recvEndStream(st)
+	return nil
+}
+
+// recvEndStream 服务端结束发送: 响应体返回 EOF, 双方都结束时删除流
+func (cc *h2ClientConn) recvEndStream(st *h2ClientStream) {
+	cc.mu.Lock()
+	st.state = st.state.RecvEndStream()
+	closed := st.state == http2.StateClosed
+	cc.mu.Unlock()
+	st.body.closeWithError(io.EOF)
+	if closed {
+		cc.removeStream(st)
+	}
+}
+
+func (cc *h2ClientConn) processData(f *http2.DataFrame) error {
+	id, n := f.StreamID, int64(f.Length)
+	cc.mu.Lock()
+	if !cc.recvFlow.Take(n) {
+		cc.mu.Unlock()
+		return http2.ConnectionError(http2.ErrCodeFlowControl)
+	}
+	st := cc.streams[id]
+	if st == nil || !st.state.CanRecvData() {
+		idle := id >= cc.nextStreamID
+		cc.mu.Unlock()
+		cc.consumed(nil, n)
+		if idle {
+			return http2.ConnectionError(http2.ErrCodeProtocol)
+		}
+		// 已重置的流上仍在途的数据
+		return nil
+	}
+	if !st.recvFlow.Take(n) {
+		cc.mu.Unlock()
+		cc.consumed(nil, n)
+		return &http2.StreamError{StreamID: id, Code: http2.ErrCodeFlowControl}
+	}
+	cc.mu.Unlock()
+	if st.resp == nil {
+		cc.consumed(nil, n)
+		return &http2.StreamError{StreamID: id, Code: http2.ErrCodeProtocol, Cause: errors.New("DATA before HEADERS")}
+	}
+	st.gotLen += int64(len(f.Data))
+	end := f.StreamEnded()
+	if st.declLen >= 0 && (st.gotLen > st.declLen || end && st.gotLen != st.declLen) {
+		cc.consumed(nil, n)
+		return &http2.StreamError{StreamID: id, Code: http2.ErrCodeProtocol}
+	}
+
+	// 填充不会被读取, 立即归还; 响应体已关闭时数据直接丢弃
+	pad := n - int64(len(f.Data))
+	if len(f.Data) > 0 && !st.body.write(f.Data) {
+		pad = n
+	}
+	cc.consumed(st, pad)
+	if end {
+		cc.recvEndStream(st)
+	}
+	return nil
+}
+
+// consumed 归还 n 个字节的接收窗口, 累计足够时发送 WINDOW_UPDATE; st 为空时只归还连接窗口
+func (cc *h2ClientConn) consumed(st *h2ClientStream, n int64) {
+	if n <= 0 {
+		return
+	}
+	cc.mu.Lock()
+	connInc := cc.recvFlow.Release(n)
+	var streamInc uint32
+	if st != nil && cc.streams[st.id] == st && st.state.CanRecvData() {
+		streamInc = st.recvFlow.Release(n)
+	}
+	closed := cc.closed
+	cc.mu.Unlock()
+	if closed || connInc == 0 && streamInc == 0 {
+		return
+	}
+	cc.writeFrame(func(fr *http2.Framer) error {
+		if connInc > 0 {
+			if err := fr.WriteWindowUpdate(0, connInc); err != nil {
+				return err
+			}
+		}
+		if streamInc > 0 {
+			return fr.WriteWindowUpdate(st.id, streamInc)
+		}
+		return nil
+	})
+}
+
+// resetStream 以 err 结束流, 流仍在连接上时向服务端发送 RST_STREAM
+func (cc *h2ClientConn) resetStream(st *h2ClientStream, code http2.ErrCode, err error) {
+	cc.mu.Lock()
+	active := cc.streams[st.id] == st && !cc.closed
+	cc.mu.Unlock()
+	cc.closeStream(st, err)
+	if active {
+		cc.writeFrame(func(fr *http2.Framer) error { return fr.WriteRSTStream(st.id, code) })
+	}
+}
+
+// closeStream 立即关闭流, 唤醒等待窗口的请求体写出方并结束响应
+func (cc *h2ClientConn) closeStream(st *h2ClientStream, err error) {
+	cc.mu.Lock()
+	st.state = http2.StateClosed
+	if st.resetErr == nil {
+		st.resetErr = err
+	}
+	cc.cond.Broadcast()
+	cc.mu.Unlock()
+	st.fail(err)
+	cc.removeStream(st)
+}
+
+// removeStream 从流表中删除已结束的流; 收到 GOAWAY 且没有剩余流时关闭连接, 否则开始空闲计时
+func (cc *h2ClientConn) removeStream(st *h2ClientStream) {
+	cc.mu.Lock()
+	if cc.streams[st.id] == st {
+		delete(cc.streams, st.id)
+	}
+	idle := len(cc.streams) == 0 && cc.reserved == 0 && !cc.closed
+	done := idle && cc.goAway != nil
+	if idle && !done {
+		cc.startIdleTimer()
+	}
+	cc.mu.Unlock()
+	cc.sched.Forget(st.id)
+	if done {
+		cc.pc.Close()
+	}
+}
+
+// startIdleTimer 连接空闲超过连接池的 IdleTimeout 后关闭, 由调用方持有 cc.mu
+func (cc *h2ClientConn) startIdleTimer() {
+	d := cc.t.Pool.cfg.IdleTimeout
+	if d <= 0 {
+		return
+	}
+	if cc.idleTimer == nil {
+		cc.idleTimer = time.AfterFunc(d, cc.closeIfIdle)
+		return
+	}
+	cc.idleTimer.Reset(d)
+}
+
+// stopIdleTimer 由调用方持有 cc.mu
+func (cc *h2ClientConn) stopIdleTimer() {
+	if cc.idleTimer != nil {
+		cc.idleTimer.Stop()
+	}
+}
+
+// closeIfIdle 没有活动请求时发送 GOAWAY 并关闭连接
+func (cc *h2ClientConn) closeIfIdle() {
+	cc.mu.Lock()
+	if cc.closed || len(cc.streams) > 0 || cc.reserved > 0 {
+		cc.mu.Unlock()
+		return
+	}
+	cc.closing = true
+	cc.mu.Unlock()
+	cc.t.h2.remove(cc)
+	cc.writeFrame(func(fr *http2.Framer) error { return fr.WriteGoAway(0, http2.ErrCodeNo, nil) })
+	cc.pc.Close()
+}
+
+// h2ClientBody HTTP/2 响应体, 读协程写入 DATA 帧的数据, 调用方读取后归还流量控制窗口
+type h2ClientBody struct {
+	st *h2ClientStream
+	// stop 取消上下文监听, 响应体结束时调用
+	stop func() bool
+
+	mu     sync.Mutex
+	cond   sync.Cond
+	buf    bytes.Buffer
+	err    error
+	closed bool
+}
+
+// write 追加数据, 响应体已被关闭时返回 false
+func (b *h2ClientBody) write(p []byte) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed || b.err != nil {
+		return false
+	}
+	b.buf.Write(p)
+	b.cond.Signal()
+	return true
+}
+
+func (b *h2ClientBody) closeWithError(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.cond.Broadcast()
+}
+
+func (b *h2ClientBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	for b.buf.Len() == 0 && b.err == nil && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		b.mu.Unlock()
+		return 0, errHTTP2BodyClosed
+	}
+	if b.buf.Len() == 0 {
+		err := b.err
+		b.mu.Unlock()
+		return 0, err
+	}
+	n, _ := b.buf.Read(p)
+	b.mu.Unlock()
+	b.st.cc.consumed(b.st, int64(n))
+	return n, nil
+}
+
+// Close 丢弃未读的数据并归还其连接窗口; 响应尚未结束时重置流, 让服务端停止发送
+func (b *h2ClientBody) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	eof := b.err == io.EOF
+	n := b.buf.Len()
+	b.buf.Reset()
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	cc := b.st.cc
+	cc.consumed(nil, int64(n))
+	if !eof {
+		cc.resetStream(b.st, http2.ErrCodeCancel, errHTTP2BodyClosed)
+	}
+	return nil
+}
+
+// finish 响应体读完或关闭时停止监听上下文
+func (b *h2ClientBody) finish() {
+	if b.stop != nil {
+		b.stop()
+	}
+}
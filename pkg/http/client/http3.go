@@ -0,0 +1,647 @@
+package client
+
+/*
+	HTTP/3 客户端 (实验性): 设置 Transport.DialQUIC 后 https 请求经 QUIC 发送, 每个请求占用一个双向流.
+	并发流数量和流量控制由 QUIC 负责, 每个主机只保留一条连接
+*/
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/hpack"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http1"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http3"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/qpack"
+	"github.com/narcilee7/http-stack/pkg/quic"
+)
+
+// h3MaxControlFrame 服务端控制流上单个帧载荷的上限
+const h3MaxControlFrame = 16 << 10
+
+// errHTTP3NoALPN QUIC 握手没有协商到 h3
+var errHTTP3NoALPN = errors.New("client: quic connection did not negotiate h3")
+
+// h3ConnSet 按主机键保存 HTTP/3 连接, 并合并同一主机的并发建连
+type h3ConnSet struct {
+	mu      sync.Mutex
+	conns   map[string]*h3ClientConn
+	dialing map[string]*h3Dial
+}
+
+// h3Dial 一次进行中的建连, done 关闭后 err 可读; 成功时连接已登记在 conns 中
+type h3Dial struct {
+	done chan struct{}
+	err  error
+}
+
+// remove 移除不再接受新请求的连接
+func (s *h3ConnSet) remove(cc *h3ClientConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns[cc.key] == cc {
+		delete(s.conns, cc.key)
+	}
+}
+
+// closeIdle 关闭没有活动请求的连接
+func (s *h3ConnSet) closeIdle() {
+	s.mu.Lock()
+	var idle []*h3ClientConn
+	for key, cc := range s.conns {
+		if cc.active.Load() == 0 {
+			idle = append(idle, cc)
+			delete(s.conns, key)
+		}
+	}
+	s.mu.Unlock()
+	for _, cc := range idle {
+		cc.qc.CloseWithError(uint64(http3.ErrCodeNo), "")
+	}
+}
+
+// exchangeHTTP3 经 HTTP/3 发送 https 请求. 服务端拒绝 (H3_REQUEST_REJECTED 或 GOAWAY) 的请求在消息体可重放时重试
+func (t *Transport) exchangeHTTP3(req *message.Request, addr string) (*message.Response, error) {
+	ctx := req.Context()
+	dialAddr, _ := DialAddrFromContext(ctx)
+	key := poolKey("https", addr, dialAddr)
+	for attempt := 1; ; attempt++ {
+		cc, err := t.getHTTP3(ctx, key, addr, dialAddr)
+		if err != nil {
+			closeRequestBody(req)
+			return nil, ctxErr(ctx, err)
+		}
+		resp, err := cc.roundTrip(req)
+		if err == nil || !errors.Is(err, errHTTP2Unprocessed) || attempt >= h2MaxAttempts || !req.Replayable() {
+			return resp, err
+		}
+		if req, err = rewind(req); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// getHTTP3 返回 key 的 HTTP/3 连接并为调用方计入一个活动请求, 没有时建连;
+// 同一主机的并发请求等待进行中的建连
+func (t *Transport) getHTTP3(ctx context.Context, key, addr, dialAddr string) (*h3ClientConn, error) {
+	s := &t.h3
+	for {
+		s.mu.Lock()
+		if cc := s.conns[key]; cc != nil {
+			cc.active.Add(1)
+			s.mu.Unlock()
+			return cc, nil
+		}
+		d := s.dialing[key]
+		if d == nil {
+			break
+		}
+		s.mu.Unlock()
+		select {
+		case <-d.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+	}
+	d := &h3Dial{done: make(chan struct{})}
+	if s.dialing == nil {
+		s.dialing = make(map[string]*h3Dial)
+	}
+	s.dialing[key] = d
+	s.mu.Unlock()
+
+	cc, err := t.dialHTTP3(ctx, key, addr, dialAddr)
+	s.mu.Lock()
+	delete(s.dialing, key)
+	d.err = err
+	if cc != nil {
+		if s.conns == nil {
+			s.conns = make(map[string]*h3ClientConn)
+		}
+		s.conns[key] = cc
+		cc.active.Add(1)
+	}
+	close(d.done)
+	s.mu.Unlock()
+	return cc, err
+}
+
+// dialHTTP3 建立 QUIC 连接, 以 addr 的主机名作为 SNI, 并打开控制流
+func (t *Transport) dialHTTP3(ctx context.Context, key, addr, dialAddr string) (*h3ClientConn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	conf := &tls.Config{}
+	if t.Pool.cfg.TLSConfig != nil {
+		conf = t.Pool.cfg.TLSConfig.Clone()
+	}
+	if conf.ServerName == "" {
+		conf.ServerName = host
+	}
+	conf.NextProtos = []string{http3.NextProtoH3}
+	target := addr
+	if dialAddr != "" {
+		target = dialAddr
+	}
+	qc, err := t.DialQUIC(ctx, target, conf)
+	if err != nil {
+		return nil, err
+	}
+	if qc.ConnectionState().NegotiatedProtocol != http3.NextProtoH3 {
+		qc.CloseWithError(uint64(http3.ErrCodeVersionFallback), "")
+		return nil, errHTTP3NoALPN
+	}
+
+	cc := &h3ClientConn{t: t, key: key, qc: qc, goAwayID: -1}
+	go cc.acceptUniStreams()
+	ctrl, err := qc.OpenUniStream(ctx)
+	if err == nil {
+		buf := http3.AppendVarInt(nil, uint64(http3.StreamTypeControl))
+		buf = http3.AppendSettingsFrame(buf, http3.Setting{ID: http3.SettingMaxFieldSectionSize, Val: http1.DefaultMaxHeaderBytes})
+		_, err = ctrl.Write(buf)
+	}
+	if err != nil {
+		qc.CloseWithError(uint64(http3.ErrCodeInternal), "")
+		return nil, err
+	}
+	context.AfterFunc(qc.Context(), func() { t.h3.remove(cc) })
+	return cc, nil
+}
+
+// h3ClientConn 一条客户端 HTTP/3 连接
+type h3ClientConn struct {
+	t   *Transport
+	key string
+	qc  quic.Conn
+	// active 进行中的请求数量, 在 h3ConnSet.mu 内增加, 以免取出的连接被 closeIdle 关闭
+	active atomic.Int32
+
+	mu sync.Mutex
+	// goAwayID 服务端 GOAWAY 中的流 ID, ID 不小于它的请求未被处理; -1 表示没有收到 GOAWAY
+	goAwayID int64
+	// gotControl 已收到服务端的控制流
+	gotControl bool
+}
+
+// closeWithError 关闭连接: 连接错误使用其错误码, 其余错误使用 H3_INTERNAL_ERROR
+func (cc *h3ClientConn) closeWithError(err error) {
+	code := http3.ErrCodeInternal
+	var ce http3.ConnectionError
+	if errors.As(err, &ce) {
+		code = http3.ErrCode(ce)
+	}
+	cc.qc.CloseWithError(uint64(code), err.Error())
+}
+
+// acceptUniStreams 接受服务端发起的单向流, 连接关闭时返回
+func (cc *h3ClientConn) acceptUniStreams() {
+	for {
+		rs, err := cc.qc.AcceptUniStream(cc.qc.Context())
+		if err != nil {
+			return
+		}
+		go cc.handleUniStream(rs)
+	}
+}
+
+// handleUniStream 读取服务端的控制流, 丢弃 QPACK 流; 本端不允许推送, 推送流和未知类型的流被拒收
+func (cc *h3ClientConn) handleUniStream(rs quic.ReceiveStream) {
+	br := bufio.NewReader(rs)
+	v, err := http3.ReadVarInt(br)
+	if err != nil {
+		return
+	}
+	switch http3.StreamType(v) {
+	case http3.StreamTypeControl:
+		cc.mu.Lock()
+		dup := cc.gotControl
+		cc.gotControl = true
+		cc.mu.Unlock()
+		if dup {
+			err = http3.ConnectionError(http3.ErrCodeStreamCreation)
+		} else {
+			err = cc.readControl(br)
+		}
+	case http3.StreamTypeQPACKEncoder, http3.StreamTypeQPACKDecoder:
+		if _, err = io.Copy(io.Discard, br); err == nil {
+			err = io.EOF
+		}
+	case http3.StreamTypePush:
+		// 没有发送 MAX_PUSH_ID, 服务端不能推送 (RFC 9114 4.6)
+		err = http3.ConnectionError(http3.ErrCodeID)
+	default:
+		rs.CancelRead(uint64(http3.ErrCodeStreamCreation))
+		return
+	}
+	if err == io.EOF {
+		err = http3.ConnectionError(http3.ErrCodeClosedCriticalStream)
+	}
+	var ce http3.ConnectionError
+	if errors.As(err, &ce) {
+		cc.closeWithError(err)
+	}
+}
+
+// readControl 读取服务端的控制流, 第一个帧必须是 SETTINGS; 收到 GOAWAY 后连接不再接受新请求
+func (cc *h3ClientConn) readControl(br *bufio.Reader) error {
+	fr := http3.NewFrameReader(br)
+	for first := true; ; first = false {
+		t, _, err := fr.Next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case first && t != http3.FrameSettings:
+			return http3.ConnectionError(http3.ErrCodeMissingSettings)
+		case !first && t == http3.FrameSettings,
+			t == http3.FrameData, t == http3.FrameHeaders, t == http3.FramePushPromise, t == http3.FrameMaxPushID:
+			return http3.ConnectionError(http3.ErrCodeFrameUnexpected)
+		}
+		p, err := fr.ReadPayload(h3MaxControlFrame)
+		if errors.Is(err, http3.ErrFrameTooLarge) {
+			return http3.ConnectionError(http3.ErrCodeExcessiveLoad)
+		}
+		if err != nil {
+			return err
+		}
+		switch t {
+		case http3.FrameSettings:
+			if _, err := http3.ParseSettings(p); err != nil {
+				return err
+			}
+		case http3.FrameGoAway:
+			id, err := http3.ParseGoAway(p)
+			if err != nil {
+				return err
+			}
+			cc.mu.Lock()
+			if cc.goAwayID >= 0 && int64(id) > cc.goAwayID || !quic.StreamID(id).ClientInitiated() {
+				// GOAWAY 的流 ID 只能减小, 且必须是客户端发起的双向流
+				cc.mu.Unlock()
+				return http3.ConnectionError(http3.ErrCodeID)
+			}
+			cc.goAwayID = int64(id)
+			cc.mu.Unlock()
+			cc.t.h3.remove(cc)
+		}
+	}
+}
+
+// unprocessed 服务端是否确认不会处理流 id 上的请求
+func (cc *h3ClientConn) unprocessed(id quic.StreamID) bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.goAwayID >= 0 && int64(id) >= cc.goAwayID
+}
+
+// roundTrip 在新的请求流上发送请求并等待响应头, 调用方已计入活动请求
+func (cc *h3ClientConn) roundTrip(req *message.Request) (*message.Response, error) {
+	ctx := req.Context()
+	st, err := cc.qc.OpenStream(ctx)
+	if err != nil {
+		cc.active.Add(-1)
+		closeRequestBody(req)
+		return nil, ctxErr(ctx, err)
+	}
+
+	// 上下文取消或等待响应头超时时重置流, 打断阻塞的读写
+	var timedOut atomic.Bool
+	cancel := func(timeout bool) {
+		timedOut.Store(timeout)
+		st.CancelRead(uint64(http3.ErrCodeRequestCancelled))
+		st.CancelWrite(uint64(http3.ErrCodeRequestCancelled))
+	}
+	stop := context.AfterFunc(ctx, func() { cancel(false) })
+	var timer *time.Timer
+	if cc.t.ResponseHeaderTimeout > 0 {
+		timer = time.AfterFunc(cc.t.ResponseHeaderTimeout, func() { cancel(true) })
+	}
+	fail := func(err error) (*message.Response, error) {
+		stop()
+		if timer != nil {
+			timer.Stop()
+		}
+		cc.active.Add(-1)
+		var se *quic.StreamError
+		switch {
+		case cc.unprocessed(st.StreamID()),
+			errors.As(err, &se) && se.ErrorCode == uint64(http3.ErrCodeRequestRejected):
+			return nil, fmt.Errorf("%w: %v", errHTTP2Unprocessed, err)
+		case timedOut.Load():
+			return nil, errResponseHeaderTimeout
+		}
+		return nil, ctxErr(ctx, err)
+	}
+
+	end := req.Body == nil || req.Body == message.NoBody
+	fields := appendRequestFields(nil, req, end)
+	if err := writeH3FieldSection(st, fields); err != nil {
+		closeRequestBody(req)
+		return fail(err)
+	}
+	if end {
+		closeRequestBody(req)
+		st.Close()
+	} else {
+		go writeH3Body(st, req)
+	}
+
+	fr := http3.NewFrameReader(st)
+	for {
+		t, _, err := fr.Next()
+		switch {
+		case err == io.EOF:
+			st.CancelRead(uint64(http3.ErrCodeRequestIncomplete))
+			return fail(io.ErrUnexpectedEOF)
+		case err != nil:
+			cc.streamError(st, err)
+			return fail(err)
+		case t != http3.FrameHeaders:
+			cc.closeWithError(http3.ConnectionError(http3.ErrCodeFrameUnexpected))
+			return fail(http3.ConnectionError(http3.ErrCodeFrameUnexpected))
+		}
+		fields, err := readH3FieldSection(fr)
+		if err != nil {
+			cc.streamError(st, err)
+			return fail(err)
+		}
+		resp, err := newH3Response(req, fields)
+		if err != nil {
+			cc.streamError(st, err)
+			return fail(err)
+		}
+		if resp == nil {
+			// 1xx 信息性响应
+			continue
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		body := &h3ClientBody{cc: cc, st: st, fr: fr, resp: resp, declLen: -1, stop: stop}
+		if req.Method != common.MethodHead {
+			body.declLen = resp.ContentLength
+		}
+		resp.Body = body
+		return resp, nil
+	}
+}
+
+// streamError 连接错误关闭连接, 流错误以其错误码重置请求流, 其余错误 (如流已被重置) 忽略
+func (cc *h3ClientConn) streamError(st quic.Stream, err error) {
+	var ce http3.ConnectionError
+	var se *http3.StreamError
+	switch {
+	case errors.As(err, &ce):
+		cc.closeWithError(err)
+	case errors.As(err, &se):
+		st.CancelRead(uint64(se.Code))
+		st.CancelWrite(uint64(se.Code))
+	}
+}
+
+// writeH3Body 在独立的协程中以 DATA 帧发送请求体, 有 trailer 时以 HEADERS 帧结束
+func writeH3Body(st quic.Stream, req *message.Request) {
+	defer closeRequestBody(req)
+	buf := make([]byte, 16<<10)
+	var hdr []byte
+	for {
+		n, err := req.Body.Read(buf)
+		if n > 0 {
+			hdr = http3.AppendFrameHeader(hdr[:0], http3.FrameData, uint64(n))
+			if _, werr := st.Write(hdr); werr != nil {
+				return
+			}
+			if _, werr := st.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			st.CancelWrite(uint64(http3.ErrCodeRequestCancelled))
+			return
+		}
+	}
+	if len(req.Trailer) > 0 {
+		var fields []hpack.HeaderField
+		for k, vs := range req.Trailer {
+			name := strings.ToLower(k)
+			for _, v := range vs {
+				fields = append(fields, hpack.HeaderField{Name: name, Value: v})
+			}
+		}
+		if writeH3FieldSection(st, fields) != nil {
+			return
+		}
+	}
+	st.Close()
+}
+
+// writeH3FieldSection 以一个 HEADERS 帧发出字段节
+func writeH3FieldSection(st quic.SendStream, fields []hpack.HeaderField) error {
+	block := qpack.AppendFieldSection(nil, fields...)
+	buf := http3.AppendFrameHeader(make([]byte, 0, len(block)+16), http3.FrameHeaders, uint64(len(block)))
+	_, err := st.Write(append(buf, block...))
+	return err
+}
+
+// readH3FieldSection 读取当前 HEADERS 帧并解码字段节, 超过 DefaultMaxHeaderBytes 时是 H3_EXCESSIVE_LOAD 流错误
+func readH3FieldSection(fr *http3.FrameReader) ([]hpack.HeaderField, error) {
+	p, err := fr.ReadPayload(http1.DefaultMaxHeaderBytes)
+	if errors.Is(err, http3.ErrFrameTooLarge) {
+		return nil, &http3.StreamError{Code: http3.ErrCodeExcessiveLoad, Cause: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	dec := qpack.Decoder{MaxFieldSectionSize: http1.DefaultMaxHeaderBytes, MaxStringLength: http1.DefaultMaxHeaderBytes}
+	fields, err := dec.Decode(p)
+	if errors.Is(err, qpack.ErrFieldSectionTooLarge) {
+		return nil, &http3.StreamError{Code: http3.ErrCodeExcessiveLoad, Cause: err}
+	}
+	if err != nil {
+		return nil, http3.ConnectionError(http3.ErrCodeQPACKDecompressionFailed)
+	}
+	return fields, nil
+}
+
+// newH3Response 由响应头构造响应, 1xx 信息性响应返回空; 格式错误时返回 H3_MESSAGE_ERROR 流错误
+func newH3Response(req *message.Request, fields []hpack.HeaderField) (*message.Response, error) {
+	malformed := func(format string, args ...any) error {
+		return &http3.StreamError{Code: http3.ErrCodeMessage, Cause: fmt.Errorf(format, args...)}
+	}
+	status := ""
+	header := make(common.Header, len(fields))
+	for _, f := range fields {
+		if f.IsPseudo() {
+			if f.Name != ":status" || status != "" || len(header) > 0 {
+				return nil, malformed("unexpected pseudo-header %s", f.Name)
+			}
+			status = f.Value
+			continue
+		}
+		if h2ConnectionHeaders[f.Name] {
+			return nil, malformed("connection-specific header %s", f.Name)
+		}
+		header.Add(f.Name, f.Value)
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil || len(status) != 3 || code < 100 {
+		return nil, malformed("invalid :status %q", status)
+	}
+	if code < 200 {
+		if code == common.StatusSwitchingProtocols {
+			return nil, malformed("invalid informational response %d", code)
+		}
+		return nil, nil
+	}
+	resp := &message.Response{
+		StatusCode:    code,
+		Status:        message.StatusLine(code),
+		Proto:         "HTTP/3.0",
+		Header:        header,
+		ContentLength: -1,
+		Request:       req,
+	}
+	if v := header.Get("Content-Length"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return nil, malformed("invalid content-length %q", v)
+		}
+		resp.ContentLength = n
+	}
+	return resp, nil
+}
+
+// h3ClientBody HTTP/3 响应体, 从请求流读取 DATA 帧, 结束时把 trailer 写入 resp.Trailer
+type h3ClientBody struct {
+	cc   *h3ClientConn
+	st   quic.Stream
+	fr   *http3.FrameReader
+	resp *message.Response
+	// declLen Content-Length 声明的长度, -1 表示未声明
+	declLen int64
+	gotLen  int64
+	inData  bool
+	// err 读完时为 io.EOF, 之后的读取都返回它
+	err  error
+	stop func() bool
+	once sync.Once
+}
+
+func (b *h3ClientBody) Read(p []byte) (int, error) {
+	for b.err == nil {
+		if !b.inData {
+			b.setErr(b.next())
+			continue
+		}
+		n, err := b.fr.Read(p)
+		if err == io.EOF {
+			b.inData = false
+			continue
+		}
+		b.gotLen += int64(n)
+		if b.declLen >= 0 && b.gotLen > b.declLen {
+			b.setErr(b.fail(fmt.Errorf("body exceeds content-length %d", b.declLen)))
+			return 0, b.err
+		}
+		if err != nil {
+			b.setErr(err)
+		}
+		return n, err
+	}
+	return 0, b.err
+}
+
+// next 读取下一个帧头: DATA 继续消息体, HEADERS 为 trailer 且之后流必须结束
+func (b *h3ClientBody) next() error {
+	t, _, err := b.fr.Next()
+	switch {
+	case err == io.EOF:
+		return b.end()
+	case err != nil:
+		return err
+	case t == http3.FrameData:
+		b.inData = true
+		return nil
+	case t != http3.FrameHeaders:
+		err := http3.ConnectionError(http3.ErrCodeFrameUnexpected)
+		b.cc.closeWithError(err)
+		return err
+	}
+	fields, err := readH3FieldSection(b.fr)
+	if err != nil {
+		b.cc.streamError(b.st, err)
+		return err
+	}
+	trailer := make(common.Header, len(fields))
+	for _, f := range fields {
+		if f.IsPseudo() {
+			return b.fail(fmt.Errorf("pseudo-header %s in trailer", f.Name))
+		}
+		trailer.Add(f.Name, f.Value)
+	}
+	if _, _, err := b.fr.Next(); err == nil {
+		err := http3.ConnectionError(http3.ErrCodeFrameUnexpected)
+		b.cc.closeWithError(err)
+		return err
+	} else if err != io.EOF {
+		return err
+	}
+	b.resp.Trailer = trailer
+	return b.end()
+}
+
+// end 在流结束时检查消息体长度
+func (b *h3ClientBody) end() error {
+	if b.declLen >= 0 && b.gotLen != b.declLen {
+		return b.fail(fmt.Errorf("body length %d does not match content-length %d", b.gotLen, b.declLen))
+	}
+	return io.EOF
+}
+
+// fail 以 H3_MESSAGE_ERROR 重置流
+func (b *h3ClientBody) fail(err error) error {
+	err = &http3.StreamError{StreamID: int64(b.st.StreamID()), Code: http3.ErrCodeMessage, Cause: err}
+	b.cc.streamError(b.st, err)
+	return err
+}
+
+// setErr 记录读取结束的原因并释放请求占用的连接计数
+func (b *h3ClientBody) setErr(err error) {
+	if err == nil {
+		return
+	}
+	b.err = err
+	b.once.Do(func() {
+		b.stop()
+		b.cc.active.Add(-1)
+	})
+}
+
+// Close 没有读完时以 H3_REQUEST_CANCELLED 要求服务端停止发送
+func (b *h3ClientBody) Close() error {
+	if b.err == nil {
+		b.st.CancelRead(uint64(http3.ErrCodeRequestCancelled))
+		b.st.CancelWrite(uint64(http3.ErrCodeRequestCancelled))
+	}
+	b.setErr(errHTTP2BodyClosed)
+	return nil
+}
@@ -0,0 +1,95 @@
+package client
+
+/*
+	连接池的指标: 按连接池键 (scheme://host:port) 统计空闲与活动连接、拨号、复用、淘汰和 TLS 握手耗时,
+	PoolStats 由这些指标汇总而来
+*/
+
+import (
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/metrics"
+)
+
+// poolMetrics 连接池上报的指标
+type poolMetrics struct {
+	idle         *metrics.GaugeVec
+	active       *metrics.GaugeVec
+	dials        *metrics.CounterVec
+	dialErrors   *metrics.CounterVec
+	reused       *metrics.CounterVec
+	evictions    *metrics.CounterVec
+	unhealthy    *metrics.CounterVec
+	handshake    *metrics.HistogramVec
+	handshakeMax *metrics.GaugeVec
+}
+
+func newPoolMetrics(r *metrics.Registry) *poolMetrics {
+	if r == nil {
+		r = metrics.NewRegistry()
+	}
+	return &poolMetrics{
+		idle:         r.GaugeVec("http_client_pool_idle_conns", "Idle connections kept by the pool.", "host"),
+		active:       r.GaugeVec("http_client_pool_active_conns", "Connections handed out by the pool.", "host"),
+		dials:        r.CounterVec("http_client_dials_total", "New connections dialed.", "host"),
+		dialErrors:   r.CounterVec("http_client_dial_errors_total", "Failed dials, including TLS handshake failures.", "host"),
+		reused:       r.CounterVec("http_client_conns_reused_total", "Idle connections reused.", "host"),
+		evictions:    r.CounterVec("http_client_conn_evictions_total", "Idle connections closed by the pool.", "host"),
+		unhealthy:    r.CounterVec("http_client_conns_unhealthy_total", "Idle connections found dead by health checks.", "host"),
+		handshake:    r.HistogramVec("http_client_tls_handshake_seconds", "TLS handshake duration.", metrics.DefBuckets, "host"),
+		handshakeMax: r.GaugeVec("http_client_tls_handshake_max_seconds", "Longest TLS handshake.", "host"),
+	}
+}
+
+// hostMetrics 单个连接池键的指标序列
+type hostMetrics struct {
+	idle         *metrics.Gauge
+	active       *metrics.Gauge
+	dials        *metrics.Counter
+	dialErrors   *metrics.Counter
+	reused       *metrics.Counter
+	evictions    *metrics.Counter
+	unhealthy    *metrics.Counter
+	handshake    *metrics.Histogram
+	handshakeMax *metrics.Gauge
+}
+
+func (m *poolMetrics) host(key string) *hostMetrics {
+	return &hostMetrics{
+		idle:         m.idle.With(key),
+		active:       m.active.With(key),
+		dials:        m.dials.With(key),
+		dialErrors:   m.dialErrors.With(key),
+		reused:       m.reused.With(key),
+		evictions:    m.evictions.With(key),
+		unhealthy:    m.unhealthy.With(key),
+		handshake:    m.handshake.With(key),
+		handshakeMax: m.handshakeMax.With(key),
+	}
+}
+
+// observeHandshake 记录一次 TLS 握手
+func (m *hostMetrics) observeHandshake(d time.Duration) {
+	m.handshake.ObserveDuration(d)
+	m.handshakeMax.SetMax(d.Seconds())
+}
+
+// stats 汇总为 HostStats, Idle 由调用方填写
+func (m *hostMetrics) stats() HostStats {
+	h := m.handshake.Snapshot()
+	return HostStats{
+		Active:        int(m.active.Value()),
+		Dials:         m.dials.Value(),
+		DialErrors:    m.dialErrors.Value(),
+		Reused:        m.reused.Value(),
+		Evictions:     m.evictions.Value(),
+		Unhealthy:     m.unhealthy.Value(),
+		Handshakes:    h.Count,
+		HandshakeTime: seconds(h.Sum),
+		HandshakeMax:  seconds(m.handshakeMax.Value()),
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,142 @@
+package client
+
+/*
+	OAuth2 Bearer 令牌注入: 过期自动刷新, 收到 401 时换新令牌重试一次
+*/
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+// tokenExpiryDelta 令牌在到期前多久即视为过期, 避免在途请求恰好过期
+const tokenExpiryDelta = 10 * time.Second
+
+// Token OAuth2 访问令牌
+type Token struct {
+	AccessToken string
+	// TokenType 为空时使用 Bearer
+	TokenType string
+	// Expiry 为零值表示不过期
+	Expiry time.Time
+}
+
+// Valid 判断令牌是否存在且未过期
+func (t *Token) Valid() bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || time.Now().Add(tokenExpiryDelta).Before(t.Expiry))
+}
+
+func (t *Token) authorization() string {
+	typ := t.TokenType
+	if typ == "" || typ == "bearer" {
+		typ = "Bearer"
+	}
+	return typ + " " + t.AccessToken
+}
+
+// TokenSource 提供访问令牌, 每次调用都应返回可用的令牌 (必要时重新获取)
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// TokenSourceFunc 将函数适配为 TokenSource
+type TokenSourceFunc func(ctx context.Context) (*Token, error)
+
+func (f TokenSourceFunc) Token(ctx context.Context) (*Token, error) { return f(ctx) }
+
+// StaticTokenSource 总是返回同一个令牌
+func StaticTokenSource(tok *Token) TokenSource {
+	return TokenSourceFunc(func(context.Context) (*Token, error) { return tok, nil })
+}
+
+// ErrInvalidToken TokenSource 返回了空令牌
+var ErrInvalidToken = errors.New("client: token source returned an invalid token")
+
+// reuseTokenSource 缓存令牌直到过期, 并发请求共享一次刷新
+type reuseTokenSource struct {
+	src TokenSource
+
+	mu  sync.Mutex
+	tok *Token
+}
+
+func (s *reuseTokenSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tok.Valid() {
+		return s.tok, nil
+	}
+	tok, err := s.src.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tok == nil || tok.AccessToken == "" {
+		return nil, ErrInvalidToken
+	}
+	s.tok = tok
+	return tok, nil
+}
+
+// invalidate 丢弃被服务端拒绝的令牌; 若已被其他请求刷新则不做处理
+func (s *reuseTokenSource) invalidate(tok *Token) {
+	s.mu.Lock()
+	if s.tok == tok {
+		s.tok = nil
+	}
+	s.mu.Unlock()
+}
+
+// OAuth2Transport 为每个请求附加 Authorization 头部
+type OAuth2Transport struct {
+	// Transport 下层传输, 为空时使用默认 Transport
+	Transport RoundTripper
+
+	source *reuseTokenSource
+}
+
+// NewOAuth2Transport 创建 OAuth2 传输层
+func NewOAuth2Transport(next RoundTripper, src TokenSource) *OAuth2Transport {
+	return &OAuth2Transport{Transport: next, source: &reuseTokenSource{src: src}}
+}
+
+// RoundTrip 实现 RoundTripper
+func (t *OAuth2Transport) RoundTrip(req *message.Request) (*message.Response, error) {
+	next := t.Transport
+	if next == nil {
+		next = defaultTransport
+	}
+	tok, err := t.source.Token(req.Context())
+	if err != nil {
+		closeRequestBody(req)
+		return nil, err
+	}
+	resp, err := next.RoundTrip(withToken(req, tok))
+	if err != nil || resp.StatusCode != common.StatusUnauthorized || !req.Replayable() {
+		return resp, err
+	}
+
+	// 401: 令牌可能已被撤销, 换新令牌重试一次
+	t.source.invalidate(tok)
+	fresh, err := t.source.Token(req.Context())
+	if err != nil || fresh.AccessToken == tok.AccessToken {
+		return resp, nil
+	}
+	retry, err := rewind(req)
+	if err != nil {
+		return resp, nil
+	}
+	message.DrainAndClose(resp.Body, 64<<10)
+	return next.RoundTrip(withToken(retry, fresh))
+}
+
+func withToken(req *message.Request, tok *Token) *message.Request {
+	r2 := req.Clone(req.Context())
+	r2.Body = req.Body
+	r2.Header.Set("Authorization", tok.authorization())
+	return r2
+}
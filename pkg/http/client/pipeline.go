@@ -0,0 +1,186 @@
+package client
+
+/*
+	HTTP/1.1 管道化 (实验性): 在一个连接上连续写出多个幂等请求, 按顺序读取响应
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http1"
+)
+
+// ErrNotPipelinable 请求不满足管道化条件: 必须幂等、可重放且目标相同
+var ErrNotPipelinable = errors.New("client: request cannot be pipelined")
+
+// errPipelineClosed 服务端在处理完全部请求前关闭了连接
+var errPipelineClosed = errors.New("client: server closed pipelined connection early")
+
+// Pipeline 将 reqs 管道化发送到同一主机, 返回与请求一一对应的响应, 响应体已读入内存.
+// 服务端提前关闭连接时, 未得到响应的请求在新连接上重发; 新连接仍无进展时退化为逐个发送
+func (t *Transport) Pipeline(reqs []*message.Request) ([]*message.Response, error) {
+	t.init()
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+	scheme, addr, err := pipelineTarget(reqs)
+	if err != nil {
+		return nil, err
+	}
+	if proxy, _ := t.proxyFor(reqs[0]); proxy != nil || len(t.Signers) > 0 {
+		return t.sequential(reqs, nil)
+	}
+
+	resps := make([]*message.Response, len(reqs))
+	done := 0
+	for done < len(reqs) {
+		n, err := t.pipelineOnce(reqs[done:], resps[done:], scheme, addr)
+		done += n
+		if err == nil {
+			continue
+		}
+		if n == 0 {
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return resps[:done], err
+			}
+			rest, err := t.sequential(reqs[done:], resps[done:])
+			return append(resps[:done], rest...), err
+		}
+	}
+	return resps, nil
+}
+
+func pipelineTarget(reqs []*message.Request) (scheme, addr string, err error) {
+	for i, req := range reqs {
+		if req.URL == nil {
+			return "", "", ErrNilURL
+		}
+		if !common.IsIdempotent(req.Method) || !req.Replayable() {
+			return "", "", fmt.Errorf("%w: %s %s", ErrNotPipelinable, req.Method, req.URL)
+		}
+		s := req.URL.Scheme
+		if s != "http" && s != "https" {
+			return "", "", ErrUnsupportedScheme
+		}
+		a := canonicalAddr(s, req.URL.Host)
+		if i == 0 {
+			scheme, addr = s, a
+		} else if s != scheme || a != addr {
+			return "", "", fmt.Errorf("%w: mixed targets %s and %s", ErrNotPipelinable, addr, a)
+		}
+	}
+	return scheme, addr, nil
+}
+
+// pipelineOnce 在一个连接上处理尽可能多的请求, 返回已得到响应的数量
+func (t *Transport) pipelineOnce(reqs []*message.Request, resps []*message.Response, scheme, addr string) (int, error) {
+	ctx := reqs[0].Context()
+	pc, err := t.Pool.Get(ctx, scheme, addr)
+	if err != nil {
+		return 0, err
+	}
+	stop := context.AfterFunc(ctx, func() { pc.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+	if deadline, ok := ctx.Deadline(); ok {
+		pc.SetDeadline(deadline)
+	}
+
+	writeErr := make(chan error, 1)
+	go func() {
+		bw := bufio.NewWriter(pc)
+		for _, req := range reqs {
+			r, err := rewind(req)
+			if err == nil {
+				err = http1.WriteRequest(bw, r)
+			}
+			if err != nil {
+				writeErr <- err
+				return
+			}
+		}
+		writeErr <- nil
+	}()
+
+	br := bufio.NewReader(pc)
+	n := 0
+	closed := false
+	for ; n < len(reqs) && !closed; n++ {
+		resp, err := http1.ReadResponse(br, reqs[n])
+		if err != nil {
+			break
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			break
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resps[n] = resp
+		closed = resp.Close
+	}
+
+	if n < len(reqs) || closed {
+		// 连接不可再用, 关闭后写协程也会退出
+		t.Pool.Discard(pc)
+		<-writeErr
+		if n < len(reqs) {
+			return n, ctxErr(ctx, errPipelineClosed)
+		}
+		return n, nil
+	}
+	if err := <-writeErr; err != nil || br.Buffered() > 0 {
+		t.Pool.Discard(pc)
+		return n, nil
+	}
+	pc.SetDeadline(time.Time{})
+	t.Pool.Put(pc)
+	return n, nil
+}
+
+// sequential 逐个发送请求并读入响应体
+func (t *Transport) sequential(reqs []*message.Request, resps []*message.Response) ([]*message.Response, error) {
+	if resps == nil {
+		resps = make([]*message.Response, len(reqs))
+	}
+	for i, req := range reqs {
+		r, err := rewind(req)
+		if err != nil {
+			return resps[:i], err
+		}
+		resp, err := t.RoundTrip(r)
+		if err != nil {
+			return resps[:i], err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return resps[:i], err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resps[i] = resp
+	}
+	return resps, nil
+}
+
+// rewind 返回带有新请求体副本的请求, 以便重复发送
+func rewind(req *message.Request) (*message.Request, error) {
+	if req.GetBody == nil {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	r2 := req.WithContext(req.Context())
+	r2.Body = body
+	return r2, nil
+}
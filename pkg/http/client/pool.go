@@ -3,3 +3,362 @@ package client
 /*
 	HTTP客户端连接池, 管理HTTP连接
 */
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http2"
+	"github.com/narcilee7/http-stack/pkg/metrics"
+	"github.com/narcilee7/http-stack/pkg/tcp"
+)
+
+// PoolConfig 连接池配置
+type PoolConfig struct {
+	// 每个主机最多保留的空闲连接数
+	MaxIdlePerHost int
+	// 空闲连接最长保留时间, 0 表示不过期
+	IdleTimeout time.Duration
+	// 建立 TCP 连接的函数, 为空时使用默认的 tcp.Dialer
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// https 连接使用的 TLS 配置
+	TLSConfig *tls.Config
+	// 复用空闲连接前检查对端是否已关闭或发送了多余数据
+	CheckHealthOnReuse bool
+	// 后台巡检空闲连接的间隔, 关闭已失效或超时的连接, 0 表示不巡检
+	HealthCheckInterval time.Duration
+	// 非空时向其上报连接池指标; 多个连接池共用时同一主机的统计合并, Stats 反映合并后的值
+	Metrics *metrics.Registry
+}
+
+// HostStats 单个主机的连接统计
+type HostStats struct {
+	Idle          int
+	Active        int
+	Dials         uint64
+	DialErrors    uint64
+	Reused        uint64
+	Evictions     uint64
+	Unhealthy     uint64
+	Handshakes    uint64
+	HandshakeTime time.Duration
+	HandshakeMax  time.Duration
+}
+
+// ReuseRatio 复用连接占全部取用次数的比例
+func (s HostStats) ReuseRatio() float64 {
+	total := s.Reused + s.Dials
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Reused) / float64(total)
+}
+
+// AvgHandshake TLS 握手平均耗时
+func (s HostStats) AvgHandshake() time.Duration {
+	if s.Handshakes == 0 {
+		return 0
+	}
+	return s.HandshakeTime / time.Duration(s.Handshakes)
+}
+
+func (s *HostStats) add(o HostStats) {
+	s.Idle += o.Idle
+	s.Active += o.Active
+	s.Dials += o.Dials
+	s.DialErrors += o.DialErrors
+	s.Reused += o.Reused
+	s.Evictions += o.Evictions
+	s.Unhealthy += o.Unhealthy
+	s.Handshakes += o.Handshakes
+	s.HandshakeTime += o.HandshakeTime
+	if o.HandshakeMax > s.HandshakeMax {
+		s.HandshakeMax = o.HandshakeMax
+	}
+}
+
+// PoolStats 连接池统计快照
+type PoolStats struct {
+	Hosts map[string]HostStats
+	Total HostStats
+}
+
+// PooledConn 连接池中的连接
+type PooledConn struct {
+	net.Conn
+	key       string
+	createdAt time.Time
+	idleAt    time.Time
+	reused    bool
+}
+
+// Key 返回连接所属的主机键 (scheme://host:port, 指定连接地址时附加 @ip:port)
+func (pc *PooledConn) Key() string { return pc.key }
+
+// Reused 连接是否来自空闲池
+func (pc *PooledConn) Reused() bool { return pc.reused }
+
+// CreatedAt 连接建立时间
+func (pc *PooledConn) CreatedAt() time.Time { return pc.createdAt }
+
+type hostPool struct {
+	idle []*PooledConn
+	m    *hostMetrics
+}
+
+// Pool 按主机划分的连接池
+type Pool struct {
+	cfg     PoolConfig
+	metrics *poolMetrics
+
+	mu     sync.Mutex
+	hosts  map[string]*hostPool
+	closed bool
+	stop   chan struct{}
+	// tlsOpts 所有 https 连接共用, 以便会话恢复
+	tlsOpts *tcp.TLSOptions
+	// alpnOpts 通过 ALPN 协商 HTTP/2 的连接使用; 与 tlsOpts 分开, 代理隧道等只支持 HTTP/1.1 的路径不会协商到 h2
+	alpnOpts *tcp.TLSOptions
+}
+
+// NewPool 创建连接池
+func NewPool(cfg PoolConfig) *Pool {
+	if cfg.MaxIdlePerHost <= 0 {
+		cfg.MaxIdlePerHost = 2
+	}
+	if cfg.Dial == nil {
+		cfg.Dial = (&tcp.Dialer{}).DialFunc()
+	}
+	p := &Pool{
+		cfg:     cfg,
+		metrics: newPoolMetrics(cfg.Metrics),
+		hosts:   make(map[string]*hostPool),
+		stop:    make(chan struct{}),
+		tlsOpts: &tcp.TLSOptions{Config: cfg.TLSConfig},
+		alpnOpts: &tcp.TLSOptions{
+			Config:     cfg.TLSConfig,
+			NextProtos: []string{http2.NextProtoTLS, "http/1.1"},
+		},
+	}
+	if cfg.HealthCheckInterval > 0 {
+		go p.healthLoop(cfg.HealthCheckInterval)
+	}
+	return p
+}
+
+func poolKey(scheme, addr, dialAddr string) string {
+	if dialAddr != "" && dialAddr != addr {
+		return scheme + "://" + addr + "@" + dialAddr
+	}
+	return scheme + "://" + addr
+}
+
+type dialAddrKey struct{}
+
+// WithDialAddr 让请求直接连接 dialAddr (ip:port), Host 和 SNI 仍使用原始地址,
+// 用于蓝绿发布验证和绕过 DNS 的健康探测
+func WithDialAddr(ctx context.Context, dialAddr string) context.Context {
+	return context.WithValue(ctx, dialAddrKey{}, dialAddr)
+}
+
+// DialAddrFromContext 返回 WithDialAddr 设置的连接地址
+func DialAddrFromContext(ctx context.Context) (string, bool) {
+	addr, ok := ctx.Value(dialAddrKey{}).(string)
+	return addr, ok && addr != ""
+}
+
+// Get 取出一个到 addr 的连接, 优先复用空闲连接.
+// ctx 中通过 WithDialAddr 指定了连接地址时, 连接单独归类, 不与按 DNS 解析的连接混用
+func (p *Pool) Get(ctx context.Context, scheme, addr string) (*PooledConn, error) {
+	return p.get(ctx, scheme, addr, p.tlsOpts)
+}
+
+// get 同 Get, https 连接使用 tlsOpts 握手
+func (p *Pool) get(ctx context.Context, scheme, addr string, tlsOpts *tcp.TLSOptions) (*PooledConn, error) {
+	dialAddr, _ := DialAddrFromContext(ctx)
+	key := poolKey(scheme, addr, dialAddr)
+	return p.GetWith(ctx, key, func(ctx context.Context) (net.Conn, time.Duration, error) {
+		target := addr
+		if dialAddr != "" {
+			target = dialAddr
+		}
+		conn, err := p.cfg.Dial(ctx, "tcp", target)
+		if err != nil {
+			return nil, 0, err
+		}
+		if scheme != "https" {
+			return conn, 0, nil
+		}
+		return handshake(ctx, conn, addr, tlsOpts)
+	})
+}
+
+// GetWith 取出键为 key 的空闲连接, 没有时调用 dial 建立新连接,
+// dial 返回连接和其中 TLS 握手的耗时
+func (p *Pool) GetWith(ctx context.Context, key string, dial func(context.Context) (net.Conn, time.Duration, error)) (*PooledConn, error) {
+	for {
+		pc := p.popIdle(key)
+		if pc == nil {
+			break
+		}
+		healthy := !p.cfg.CheckHealthOnReuse || connAlive(pc.Conn)
+
+		p.mu.Lock()
+		hp := p.host(key)
+		if !healthy {
+			hp.m.evictions.Inc()
+			hp.m.unhealthy.Inc()
+			p.mu.Unlock()
+			pc.Conn.Close()
+			continue
+		}
+		pc.reused = true
+		hp.m.reused.Inc()
+		hp.m.active.Inc()
+		p.mu.Unlock()
+		return pc, nil
+	}
+
+	p.mu.Lock()
+	hp := p.host(key)
+	hp.m.dials.Inc()
+	p.mu.Unlock()
+
+	conn, hs, err := dial(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		hp.m.dialErrors.Inc()
+		return nil, err
+	}
+	if hs > 0 {
+		hp.m.observeHandshake(hs)
+	}
+	hp.m.active.Inc()
+	return &PooledConn{Conn: conn, key: key, createdAt: time.Now()}, nil
+}
+
+// popIdle 取出最近归还的空闲连接, 顺带关闭已超过空闲时间的连接
+func (p *Pool) popIdle(key string) *PooledConn {
+	now := time.Now()
+	var stale []*PooledConn
+	defer func() { closeAll(stale) }()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	hp := p.host(key)
+	for len(hp.idle) > 0 {
+		pc := hp.idle[len(hp.idle)-1]
+		hp.idle = hp.idle[:len(hp.idle)-1]
+		hp.m.idle.Dec()
+		if p.cfg.IdleTimeout > 0 && now.Sub(pc.idleAt) > p.cfg.IdleTimeout {
+			hp.m.evictions.Inc()
+			stale = append(stale, pc)
+			continue
+		}
+		return pc
+	}
+	return nil
+}
+
+// Put 将连接归还到空闲池, 超出上限时关闭
+func (p *Pool) Put(pc *PooledConn) {
+	p.mu.Lock()
+	hp := p.host(pc.key)
+	hp.m.active.Dec()
+	if p.closed || len(hp.idle) >= p.cfg.MaxIdlePerHost {
+		hp.m.evictions.Inc()
+		p.mu.Unlock()
+		pc.Conn.Close()
+		return
+	}
+	pc.idleAt = time.Now()
+	hp.idle = append(hp.idle, pc)
+	hp.m.idle.Inc()
+	p.mu.Unlock()
+}
+
+// Discard 关闭一个不可复用的连接
+func (p *Pool) Discard(pc *PooledConn) {
+	p.mu.Lock()
+	p.host(pc.key).m.active.Dec()
+	p.mu.Unlock()
+	pc.Conn.Close()
+}
+
+// CloseIdle 关闭所有空闲连接
+func (p *Pool) CloseIdle() {
+	p.mu.Lock()
+	var conns []*PooledConn
+	for _, hp := range p.hosts {
+		hp.m.evictions.Add(uint64(len(hp.idle)))
+		hp.m.idle.Add(-float64(len(hp.idle)))
+		conns = append(conns, hp.idle...)
+		hp.idle = nil
+	}
+	p.mu.Unlock()
+	closeAll(conns)
+}
+
+// Close 关闭连接池, 之后归还的连接会被直接关闭
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.stop)
+	}
+	p.mu.Unlock()
+	p.CloseIdle()
+	return nil
+}
+
+// Stats 返回连接池的实时统计快照
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := PoolStats{Hosts: make(map[string]HostStats, len(p.hosts))}
+	for key, hp := range p.hosts {
+		hs := hp.m.stats()
+		hs.Idle = len(hp.idle)
+		s.Hosts[key] = hs
+		s.Total.add(hs)
+	}
+	return s
+}
+
+func (p *Pool) host(key string) *hostPool {
+	hp, ok := p.hosts[key]
+	if !ok {
+		hp = &hostPool{m: p.metrics.host(key)}
+		p.hosts[key] = hp
+	}
+	return hp
+}
+
+// handshake 在 conn 上以 addr 的主机名作为 SNI 完成 TLS 握手
+func (p *Pool) handshake(ctx context.Context, conn net.Conn, addr string) (net.Conn, time.Duration, error) {
+	return handshake(ctx, conn, addr, p.tlsOpts)
+}
+
+func handshake(ctx context.Context, conn net.Conn, addr string, opts *tcp.TLSOptions) (net.Conn, time.Duration, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	tc, err := tcp.ClientHandshake(ctx, conn, host, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	return tc, tc.TLS().HandshakeDuration, nil
+}
+
+func closeAll(conns []*PooledConn) {
+	for _, pc := range conns {
+		pc.Conn.Close()
+	}
+}
@@ -0,0 +1,96 @@
+package client_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httptest "github.com/narcilee7/http-stack/internal/testing"
+	"github.com/narcilee7/http-stack/pkg/http/client"
+)
+
+// countingConn 只记录关闭次数的连接
+type countingConn struct {
+	net.Conn
+	closed *atomic.Int64
+}
+
+func (c *countingConn) Close() error {
+	c.closed.Add(1)
+	return nil
+}
+
+// 并发取用、归还、丢弃和清空空闲连接, 每轮结束后连接数必须对得上
+func TestPoolStress(t *testing.T) {
+	const maxIdle = 2
+	p := client.NewPool(client.PoolConfig{MaxIdlePerHost: maxIdle})
+	defer p.Close()
+
+	var gets, closed atomic.Int64
+	dial := func(context.Context) (net.Conn, time.Duration, error) {
+		return &countingConn{closed: &closed}, 0, nil
+	}
+	get := func(worker int) (*client.PooledConn, error) {
+		pc, err := p.GetWith(context.Background(), fmt.Sprintf("http://host%d:80", worker%3), dial)
+		if err == nil {
+			gets.Add(1)
+		}
+		return pc, err
+	}
+
+	httptest.Stress(t, httptest.StressConfig{
+		Workers:    8,
+		Iterations: 500,
+		Rounds:     3,
+		Check: func() error {
+			s := p.Stats()
+			if s.Total.Active != 0 {
+				return fmt.Errorf("%d connections still active", s.Total.Active)
+			}
+			for key, hs := range s.Hosts {
+				if hs.Idle > maxIdle {
+					return fmt.Errorf("%s holds %d idle connections", key, hs.Idle)
+				}
+			}
+			if got := int64(s.Total.Dials + s.Total.Reused); got != gets.Load() {
+				return fmt.Errorf("dials+reused = %d, gets = %d", got, gets.Load())
+			}
+			if got := closed.Load() + int64(s.Total.Idle); got != int64(s.Total.Dials) {
+				return fmt.Errorf("closed+idle = %d, dials = %d", got, s.Total.Dials)
+			}
+			return nil
+		},
+	},
+		httptest.StressOp{Name: "get-put", Weight: 6, Fn: func(w int) error {
+			pc, err := get(w)
+			if err != nil {
+				return err
+			}
+			p.Put(pc)
+			return nil
+		}},
+		httptest.StressOp{Name: "get-discard", Weight: 2, Fn: func(w int) error {
+			pc, err := get(w)
+			if err != nil {
+				return err
+			}
+			p.Discard(pc)
+			return nil
+		}},
+		httptest.StressOp{Name: "close-idle", Fn: func(int) error {
+			p.CloseIdle()
+			return nil
+		}},
+		httptest.StressOp{Name: "stats", Fn: func(int) error {
+			for key, hs := range p.Stats().Hosts {
+				if hs.Idle > maxIdle {
+					return fmt.Errorf("%s holds %d idle connections", key, hs.Idle)
+				}
+			}
+			return nil
+		}},
+	)
+}
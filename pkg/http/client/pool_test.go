@@ -0,0 +1,207 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// poolServer 接受连接并把服务端一侧交给测试
+func poolServer(t *testing.T) (addr string, accepted <-chan net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan net.Conn, 16)
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, c)
+			mu.Unlock()
+			ch <- c
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, c := range conns {
+			c.Close()
+		}
+	})
+	return ln.Addr().String(), ch
+}
+
+// waitClosed 等待对端关闭连接
+func waitClosed(t *testing.T, c net.Conn) {
+	t.Helper()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("connection not closed by the pool: %v", err)
+	}
+}
+
+func TestPoolReusesIdleConn(t *testing.T) {
+	addr, _ := poolServer(t)
+	p := NewPool(PoolConfig{})
+	defer p.Close()
+	ctx := context.Background()
+
+	pc, err := p.Get(ctx, "http", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pc.Reused() || pc.Key() != "http://"+addr {
+		t.Fatalf("first Get: reused=%v key=%q", pc.Reused(), pc.Key())
+	}
+	if s := p.Stats().Hosts[pc.Key()]; s.Active != 1 || s.Idle != 0 || s.Dials != 1 {
+		t.Fatalf("stats while in use = %+v", s)
+	}
+	p.Put(pc)
+	if s := p.Stats().Hosts[pc.Key()]; s.Active != 0 || s.Idle != 1 {
+		t.Fatalf("stats after Put = %+v", s)
+	}
+
+	again, err := p.Get(ctx, "http", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Reused() || again.Conn != pc.Conn {
+		t.Fatal("idle connection was not reused")
+	}
+	s := p.Stats().Hosts[pc.Key()]
+	if s.Dials != 1 || s.Reused != 1 || s.ReuseRatio() != 0.5 {
+		t.Fatalf("stats after reuse = %+v, ratio %v", s, s.ReuseRatio())
+	}
+	p.Discard(again)
+	if s := p.Stats().Total; s.Active != 0 || s.Idle != 0 {
+		t.Fatalf("stats after Discard = %+v", s)
+	}
+}
+
+func TestPoolMaxIdlePerHost(t *testing.T) {
+	addr, accepted := poolServer(t)
+	p := NewPool(PoolConfig{MaxIdlePerHost: 1})
+	defer p.Close()
+	ctx := context.Background()
+
+	a, err := p.Get(ctx, "http", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-accepted
+	b, err := p.Get(ctx, "http", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb := <-accepted
+	p.Put(a)
+	p.Put(b)
+	waitClosed(t, sb)
+	if s := p.Stats().Total; s.Idle != 1 || s.Evictions != 1 {
+		t.Fatalf("stats = %+v, want 1 idle and 1 eviction", s)
+	}
+}
+
+func TestPoolIdleTimeout(t *testing.T) {
+	addr, accepted := poolServer(t)
+	p := NewPool(PoolConfig{IdleTimeout: 20 * time.Millisecond})
+	defer p.Close()
+	ctx := context.Background()
+
+	pc, err := p.Get(ctx, "http", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := <-accepted
+	p.Put(pc)
+	time.Sleep(40 * time.Millisecond)
+
+	pc, err = p.Get(ctx, "http", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Discard(pc)
+	if pc.Reused() {
+		t.Fatal("expired idle connection was reused")
+	}
+	waitClosed(t, first)
+	if s := p.Stats().Total; s.Dials != 2 || s.Evictions != 1 {
+		t.Fatalf("stats = %+v, want 2 dials and 1 eviction", s)
+	}
+}
+
+func TestPoolSeparatesHosts(t *testing.T) {
+	addr1, _ := poolServer(t)
+	addr2, _ := poolServer(t)
+	p := NewPool(PoolConfig{})
+	defer p.Close()
+	ctx := context.Background()
+
+	a, err := p.Get(ctx, "http", addr1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(a)
+	b, err := p.Get(ctx, "http", addr2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Discard(b)
+	if b.Reused() {
+		t.Fatal("connection to one host reused for another")
+	}
+	if s := p.Stats(); len(s.Hosts) != 2 || s.Total.Dials != 2 || s.Total.Idle != 1 {
+		t.Fatalf("stats = %+v", s)
+	}
+}
+
+func TestPoolDialError(t *testing.T) {
+	errDial := errors.New("dial refused")
+	p := NewPool(PoolConfig{Dial: func(context.Context, string, string) (net.Conn, error) { return nil, errDial }})
+	defer p.Close()
+	if _, err := p.Get(context.Background(), "http", "example.com:80"); !errors.Is(err, errDial) {
+		t.Fatalf("Get = %v, want the dial error", err)
+	}
+	if s := p.Stats().Total; s.Dials != 1 || s.DialErrors != 1 || s.Active != 0 {
+		t.Fatalf("stats = %+v", s)
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	addr, accepted := poolServer(t)
+	p := NewPool(PoolConfig{})
+	ctx := context.Background()
+
+	idle, err := p.Get(ctx, "http", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sIdle := <-accepted
+	busy, err := p.Get(ctx, "http", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sBusy := <-accepted
+	p.Put(idle)
+
+	p.Close()
+	waitClosed(t, sIdle)
+	// 关闭后归还的连接直接关闭
+	p.Put(busy)
+	waitClosed(t, sBusy)
+	if s := p.Stats().Total; s.Idle != 0 {
+		t.Fatalf("stats after Close = %+v", s)
+	}
+}
@@ -0,0 +1,330 @@
+package client
+
+/*
+	HTTP代理支持: absolute-form 转发、CONNECT 隧道, 以及 407 时的 Basic/Digest 代理认证
+*/
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http1"
+)
+
+// ProxyFunc 返回请求应经过的代理, 返回 nil 表示直连
+type ProxyFunc func(req *message.Request) (*url.URL, error)
+
+// ProxyURL 返回总是使用 u 作为代理的 ProxyFunc
+func ProxyURL(u *url.URL) ProxyFunc {
+	return func(*message.Request) (*url.URL, error) { return u, nil }
+}
+
+// ProxyCredentials 按代理地址 (host:port) 配置的认证凭据, 优先于代理 URL 中的凭据
+type ProxyCredentials map[string]*url.Userinfo
+
+// ProxyConnectError CONNECT 隧道建立失败
+type ProxyConnectError struct {
+	Proxy      string
+	StatusCode int
+	Status     string
+}
+
+func (e *ProxyConnectError) Error() string {
+	return fmt.Sprintf("client: proxy %s refused CONNECT: %s", e.Proxy, e.Status)
+}
+
+func (t *Transport) proxyFor(req *message.Request) (*url.URL, error) {
+	if t.Proxy == nil {
+		return nil, nil
+	}
+	proxy, err := t.Proxy(req)
+	if err != nil || proxy == nil {
+		return nil, err
+	}
+	if proxy.Scheme != "http" && proxy.Scheme != "https" {
+		return nil, fmt.Errorf("%w: proxy %s", ErrUnsupportedScheme, proxy.Scheme)
+	}
+	return proxy, nil
+}
+
+func (t *Transport) proxyUser(proxy *url.URL) *url.Userinfo {
+	if user, ok := t.ProxyCredentials[canonicalAddr(proxy.Scheme, proxy.Host)]; ok {
+		return user
+	}
+	return proxy.User
+}
+
+func proxyKey(proxy *url.URL) string {
+	return "proxy+" + proxy.Scheme + "://" + canonicalAddr(proxy.Scheme, proxy.Host)
+}
+
+// dialProxy 建立到代理的连接, https 代理先完成 TLS 握手
+func (t *Transport) dialProxy(ctx context.Context, proxy *url.URL) (net.Conn, time.Duration, error) {
+	addr := canonicalAddr(proxy.Scheme, proxy.Host)
+	conn, err := t.Pool.cfg.Dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	if proxy.Scheme != "https" {
+		return conn, 0, nil
+	}
+	return t.Pool.handshake(ctx, conn, addr)
+}
+
+// getProxyConn 取出经代理到达 addr 的连接, https 目标通过 CONNECT 隧道建立
+func (t *Transport) getProxyConn(ctx context.Context, proxy *url.URL, scheme, addr string) (*PooledConn, error) {
+	if scheme == "http" {
+		return t.Pool.GetWith(ctx, proxyKey(proxy), func(ctx context.Context) (net.Conn, time.Duration, error) {
+			return t.dialProxy(ctx, proxy)
+		})
+	}
+	key := proxyKey(proxy) + "|" + poolKey(scheme, addr, "")
+	return t.Pool.GetWith(ctx, key, func(ctx context.Context) (net.Conn, time.Duration, error) {
+		conn, hs, err := t.dialProxy(ctx, proxy)
+		if err != nil {
+			return nil, 0, err
+		}
+		conn, err = t.connect(ctx, conn, proxy, addr)
+		if err != nil {
+			return nil, 0, err
+		}
+		tc, hs2, err := t.Pool.handshake(ctx, conn, addr)
+		return tc, hs + hs2, err
+	})
+}
+
+// connect 在代理连接上建立到 addr 的隧道, 收到 407 且有凭据时认证后重试一次
+func (t *Transport) connect(ctx context.Context, conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	user := t.proxyUser(proxy)
+	header := make(common.Header)
+	if user != nil {
+		header.Set("Proxy-Authorization", basicAuth(user))
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, br, err := sendConnect(ctx, conn, addr, header)
+		if err != nil {
+			conn.Close()
+			return nil, ctxErr(ctx, err)
+		}
+		if resp.StatusCode/100 == 2 {
+			if br.Buffered() > 0 {
+				conn.Close()
+				return nil, fmt.Errorf("client: proxy %s sent data before tunnel was established", proxy.Host)
+			}
+			return conn, nil
+		}
+		if resp.StatusCode == common.StatusProxyAuthRequired && attempt == 0 && user != nil {
+			auth, ok := proxyAuthorization(user, resp.Header.Values("Proxy-Authenticate"), common.MethodConnect, addr)
+			if ok {
+				message.DrainAndClose(resp.Body, 64<<10)
+				if resp.Close {
+					conn.Close()
+					if conn, _, err = t.dialProxy(ctx, proxy); err != nil {
+						return nil, err
+					}
+				}
+				header.Set("Proxy-Authorization", auth)
+				continue
+			}
+		}
+		conn.Close()
+		return nil, &ProxyConnectError{Proxy: proxy.Host, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+}
+
+func sendConnect(ctx context.Context, conn net.Conn, addr string, header common.Header) (*message.Response, *bufio.Reader, error) {
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req, err := message.NewRequestWithContext(ctx, common.MethodConnect, "", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.URL = &url.URL{Host: addr}
+	req.Host = addr
+	req.Header = header
+
+	if err := http1.WriteRequest(bufio.NewWriter(conn), req); err != nil {
+		return nil, nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http1.ReadResponse(br, req)
+	return resp, br, err
+}
+
+// retryProxyAuth 对经 HTTP 代理转发的请求, 收到 407 时带认证头重发一次
+func (t *Transport) retryProxyAuth(req *message.Request, resp *message.Response, proxy *url.URL, scheme, addr string) (*message.Response, error) {
+	user := t.proxyUser(proxy)
+	if user == nil || !req.Replayable() {
+		return resp, nil
+	}
+	auth, ok := proxyAuthorization(user, resp.Header.Values("Proxy-Authenticate"), req.Method, proxyRequestURI(req))
+	if !ok {
+		return resp, nil
+	}
+	req2 := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		req2.Body = body
+	}
+	message.DrainAndClose(resp.Body, 64<<10)
+	req2.Header.Set("Proxy-Authorization", auth)
+	return t.exchange(req2, scheme, addr, proxy)
+}
+
+func proxyRequestURI(req *message.Request) string {
+	u := *req.URL
+	u.User = nil
+	u.Fragment = ""
+	return u.String()
+}
+
+func basicAuth(user *url.Userinfo) string {
+	pass, _ := user.Password()
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+pass))
+}
+
+// proxyAuthorization 根据代理返回的质询生成 Proxy-Authorization, 优先使用 Digest
+func proxyAuthorization(user *url.Userinfo, challenges []string, method, uri string) (string, bool) {
+	var basic bool
+	for _, c := range challenges {
+		scheme, params := parseChallenge(c)
+		switch strings.ToLower(scheme) {
+		case "digest":
+			if auth, ok := digestAuth(user, params, method, uri); ok {
+				return auth, true
+			}
+		case "basic":
+			basic = true
+		}
+	}
+	if basic {
+		return basicAuth(user), true
+	}
+	return "", false
+}
+
+// parseChallenge 解析单个认证质询, 如 Digest realm="x", nonce="y"
+func parseChallenge(s string) (string, map[string]string) {
+	s = strings.TrimSpace(s)
+	scheme, rest, _ := strings.Cut(s, " ")
+	params := make(map[string]string)
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		var key string
+		key, rest, _ = strings.Cut(rest, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimSpace(rest)
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				b.WriteByte(rest[i])
+			}
+			value = b.String()
+			if i < len(rest) {
+				i++
+			}
+			rest = rest[i:]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			value = strings.TrimSpace(value)
+			rest = "," + rest
+		}
+		params[key] = value
+		rest = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rest), ","))
+	}
+	return scheme, params
+}
+
+// digestAuth 按 RFC 7616 计算 Digest 认证头, 支持 MD5/SHA-256 及其 -sess 变体
+func digestAuth(user *url.Userinfo, params map[string]string, method, uri string) (string, bool) {
+	realm, nonce := params["realm"], params["nonce"]
+	if nonce == "" {
+		return "", false
+	}
+	algorithm := params["algorithm"]
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+	var newHash func() hash.Hash
+	switch strings.ToUpper(strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS")) {
+	case "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", false
+	}
+	h := func(s string) string {
+		d := newHash()
+		d.Write([]byte(s))
+		return hex.EncodeToString(d.Sum(nil))
+	}
+
+	var qop string
+	if q, ok := params["qop"]; ok {
+		for _, v := range strings.Split(q, ",") {
+			if strings.TrimSpace(v) == "auth" {
+				qop = "auth"
+			}
+		}
+		if qop == "" {
+			return "", false
+		}
+	}
+
+	var cb [8]byte
+	rand.Read(cb[:])
+	cnonce := hex.EncodeToString(cb[:])
+	const nc = "00000001"
+
+	pass, _ := user.Password()
+	ha1 := h(user.Username() + ":" + realm + ":" + pass)
+	if strings.HasSuffix(strings.ToUpper(algorithm), "-SESS") {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+
+	var response string
+	if qop != "" {
+		response = h(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + nonce + ":" + ha2)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username=%q, realm=%q, nonce=%q, uri=%q, algorithm=%s, response=%q`,
+		user.Username(), realm, nonce, uri, algorithm, response)
+	if qop != "" {
+		fmt.Fprintf(&b, `, qop=%s, nc=%s, cnonce=%q`, qop, nc, cnonce)
+	}
+	if opaque, ok := params["opaque"]; ok {
+		fmt.Fprintf(&b, `, opaque=%q`, opaque)
+	}
+	return b.String(), true
+}
@@ -0,0 +1,301 @@
+package client
+
+/*
+	录制回放传输层 (VCR 风格), 将真实响应录制到 cassette 文件, 测试时确定性地回放
+*/
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+// RecorderMode 录制器工作模式
+type RecorderMode int
+
+const (
+	// ModeReplay 只回放, 没有匹配的录制时返回 ErrNoInteraction
+	ModeReplay RecorderMode = iota
+	// ModeRecord 总是请求上游并录制
+	ModeRecord
+	// ModeReplayOrRecord 有匹配时回放, 否则请求上游并录制
+	ModeReplayOrRecord
+)
+
+// ErrNoInteraction 回放模式下没有匹配的录制
+var ErrNoInteraction = errors.New("client: no recorded interaction matches request")
+
+// redacted 脱敏后的头部值
+const redacted = "REDACTED"
+
+// DefaultScrubHeaders 默认在录制时脱敏的头部
+var DefaultScrubHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Cassette 录制文件内容
+type Cassette struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction 一次请求/响应
+type Interaction struct {
+	Request    RecordedRequest  `json:"request"`
+	Response   RecordedResponse `json:"response"`
+	RecordedAt time.Time        `json:"recorded_at"`
+	Duration   time.Duration    `json:"duration"`
+
+	used bool
+}
+
+// RecordedRequest 录制的请求
+type RecordedRequest struct {
+	Method string        `json:"method"`
+	URL    string        `json:"url"`
+	Header common.Header `json:"header,omitempty"`
+	Body   RecordedBody  `json:"body,omitempty"`
+}
+
+// RecordedResponse 录制的响应
+type RecordedResponse struct {
+	StatusCode int           `json:"status_code"`
+	Status     string        `json:"status"`
+	Proto      string        `json:"proto"`
+	Header     common.Header `json:"header,omitempty"`
+	Body       RecordedBody  `json:"body,omitempty"`
+}
+
+// RecordedBody 消息体, 合法 UTF-8 以文本保存, 否则以 base64 保存
+type RecordedBody []byte
+
+func (b RecordedBody) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+	return json.Marshal(map[string]string{"base64": base64.StdEncoding.EncodeToString(b)})
+}
+
+func (b *RecordedBody) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*b = []byte(s)
+		return nil
+	}
+	var enc map[string]string
+	if err := json.Unmarshal(data, &enc); err != nil {
+		return err
+	}
+	raw, err := base64.StdEncoding.DecodeString(enc["base64"])
+	if err != nil {
+		return err
+	}
+	*b = raw
+	return nil
+}
+
+// MatcherFunc 判断请求是否与录制的请求匹配, body 为可重放请求体的内容
+type MatcherFunc func(req *message.Request, body []byte, recorded *RecordedRequest) bool
+
+// DefaultMatcher 按方法和 URL 匹配
+func DefaultMatcher(req *message.Request, _ []byte, rec *RecordedRequest) bool {
+	return req.Method == rec.Method && req.URL.String() == rec.URL
+}
+
+// BodyMatcher 按方法、URL 和请求体匹配
+func BodyMatcher(req *message.Request, body []byte, rec *RecordedRequest) bool {
+	return DefaultMatcher(req, body, rec) && bytes.Equal(body, rec.Body)
+}
+
+// Recorder 录制回放 RoundTripper
+type Recorder struct {
+	// Transport 录制时使用的下层传输, 为空时使用默认 Transport
+	Transport RoundTripper
+	// Matcher 请求匹配规则, 默认 DefaultMatcher
+	Matcher MatcherFunc
+	// ScrubHeaders 录制时脱敏的头部, 默认 DefaultScrubHeaders
+	ScrubHeaders []string
+	// AllowRepeat 允许同一条录制被多次回放
+	AllowRepeat bool
+
+	path     string
+	mode     RecorderMode
+	mu       sync.Mutex
+	cassette Cassette
+	dirty    bool
+}
+
+// NewRecorder 打开 cassette 文件, 回放模式下文件必须存在
+func NewRecorder(path string, mode RecorderMode) (*Recorder, error) {
+	r := &Recorder{path: path, mode: mode, cassette: Cassette{Version: 1}}
+	if mode == ModeRecord {
+		return r, nil
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &r.cassette); err != nil {
+			return nil, fmt.Errorf("client: bad cassette %s: %w", path, err)
+		}
+	case errors.Is(err, os.ErrNotExist) && mode == ModeReplayOrRecord:
+	default:
+		return nil, err
+	}
+	return r, nil
+}
+
+// Interactions 返回当前所有录制
+func (r *Recorder) Interactions() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Interaction(nil), r.cassette.Interactions...)
+}
+
+// RoundTrip 实现 RoundTripper
+func (r *Recorder) RoundTrip(req *message.Request) (*message.Response, error) {
+	body, err := SignableBody(req)
+	if err != nil && r.mode != ModeRecord {
+		closeRequestBody(req)
+		return nil, err
+	}
+
+	if r.mode != ModeRecord {
+		if resp := r.replay(req, body); resp != nil {
+			closeRequestBody(req)
+			return resp, nil
+		}
+		if r.mode == ModeReplay {
+			closeRequestBody(req)
+			return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, req.Method, req.URL)
+		}
+	}
+	return r.record(req, body)
+}
+
+func (r *Recorder) replay(req *message.Request, body []byte) *message.Response {
+	match := r.Matcher
+	if match == nil {
+		match = DefaultMatcher
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.cassette.Interactions {
+		it := &r.cassette.Interactions[i]
+		if it.used && !r.AllowRepeat || !match(req, body, &it.Request) {
+			continue
+		}
+		it.used = true
+		rec := it.Response
+		return &message.Response{
+			StatusCode:    rec.StatusCode,
+			Status:        rec.Status,
+			Proto:         rec.Proto,
+			Header:        rec.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader(rec.Body)),
+			ContentLength: int64(len(rec.Body)),
+			Request:       req,
+		}
+	}
+	return nil
+}
+
+func (r *Recorder) record(req *message.Request, body []byte) (*message.Response, error) {
+	next := r.Transport
+	if next == nil {
+		next = defaultTransport
+	}
+	start := time.Now()
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	it := Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: r.scrub(req.Header),
+			Body:   body,
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Proto:      resp.Proto,
+			Header:     r.scrub(resp.Header),
+			Body:       respBody,
+		},
+		RecordedAt: start,
+		Duration:   time.Since(start),
+		used:       true,
+	}
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, it)
+	r.dirty = true
+	r.mu.Unlock()
+
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	resp.ContentLength = int64(len(respBody))
+	return resp, nil
+}
+
+func (r *Recorder) scrub(h common.Header) common.Header {
+	h = h.Clone()
+	keys := r.ScrubHeaders
+	if keys == nil {
+		keys = DefaultScrubHeaders
+	}
+	for _, k := range keys {
+		if vs := h.Values(k); len(vs) > 0 {
+			h.Set(k, redacted)
+		}
+	}
+	return h
+}
+
+// Save 将新录制的内容写回 cassette 文件
+func (r *Recorder) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.dirty {
+		return nil
+	}
+	data, err := json.MarshalIndent(&r.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(r.path, data, 0o644); err != nil {
+		return err
+	}
+	r.dirty = false
+	return nil
+}
+
+// LoadCassette 读取 cassette 文件
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
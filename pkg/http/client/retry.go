@@ -3,3 +3,133 @@ package client
 /*
 	HTTP客户端重试机制
 */
+
+import (
+	"io"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/log"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// DefaultRetryBackoff 默认重试退避: 共 3 次尝试, 100ms 起指数增长, 50% 抖动
+var DefaultRetryBackoff = utils.Backoff{MaxAttempts: 3, Jitter: 0.5}
+
+// RetryTransport 失败时按退避策略重试的 RoundTripper.
+// 只重试幂等且消息体可重放的请求, 等待受请求 ctx 控制
+type RetryTransport struct {
+	// Transport 实际发送请求, 为空时使用默认传输层
+	Transport RoundTripper
+	// Backoff 重试间隔与次数, 为空时使用 DefaultRetryBackoff
+	Backoff *utils.Backoff
+	// ShouldRetry 判断是否重试, 为空时重试网络错误和 429/502/503/504 响应
+	ShouldRetry func(req *message.Request, resp *message.Response, err error) bool
+	// MaxRetryAfter 响应的 Retry-After 超过该值时不再重试而直接返回响应, 0 表示 DefaultMaxRetryAfter
+	MaxRetryAfter time.Duration
+	// Logger 以 Debug 级别记录每次重试, 为空时使用 log.Default()
+	Logger *log.Logger
+}
+
+// DefaultMaxRetryAfter 默认愿意等待的最长 Retry-After
+const DefaultMaxRetryAfter = time.Minute
+
+// NewRetryTransport 创建重试传输层, bo 为空时使用 DefaultRetryBackoff
+func NewRetryTransport(next RoundTripper, bo *utils.Backoff) *RetryTransport {
+	return &RetryTransport{Transport: next, Backoff: bo}
+}
+
+func (t *RetryTransport) transport() RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return defaultTransport
+}
+
+// RoundTrip 实现 RoundTripper
+func (t *RetryTransport) RoundTrip(req *message.Request) (*message.Response, error) {
+	if !common.IsIdempotent(req.Method) || !req.Replayable() {
+		return t.transport().RoundTrip(req)
+	}
+	bo := t.Backoff
+	if bo == nil {
+		bo = &DefaultRetryBackoff
+	}
+	shouldRetry := t.ShouldRetry
+	if shouldRetry == nil {
+		shouldRetry = defaultShouldRetry
+	}
+
+	maxWait := t.MaxRetryAfter
+	if maxWait <= 0 {
+		maxWait = DefaultMaxRetryAfter
+	}
+
+	var resp *message.Response
+	var err error
+	ctx := req.Context()
+	for it := bo.Iter(); it.Next(ctx); {
+		if it.Attempt() > 1 {
+			t.logRetry(req, it.Attempt(), resp, err)
+		}
+		if resp != nil {
+			// 丢弃上一次的响应以便复用连接
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		r := req
+		if it.Attempt() > 1 {
+			if r, err = rewind(req); err != nil {
+				return nil, err
+			}
+		}
+		resp, err = t.transport().RoundTrip(r)
+		if !shouldRetry(r, resp, err) {
+			break
+		}
+		if resp != nil {
+			// 服务端要求的等待时间作为下一次退避的下限
+			if wait, ok := common.ParseRetryAfter(resp.Header.Get("Retry-After"), bo.Clock); ok {
+				if wait > maxWait {
+					break
+				}
+				it.Delay(wait)
+			}
+		}
+	}
+	if resp == nil && err == nil {
+		// 首次尝试前 ctx 已结束
+		err = ctxErr(ctx, ctx.Err())
+	}
+	return resp, err
+}
+
+// logRetry 记录第 attempt 次尝试及上一次失败的结果
+func (t *RetryTransport) logRetry(req *message.Request, attempt int, resp *message.Response, err error) {
+	logger := log.Or(t.Logger)
+	if !logger.Enabled(log.LevelDebug) {
+		return
+	}
+	fields := []log.Field{log.String("method", req.Method), log.Int("attempt", attempt)}
+	if req.URL != nil {
+		fields = append(fields, log.String("url", req.URL.Redacted()))
+	}
+	if err != nil {
+		fields = append(fields, log.Err(err))
+	} else if resp != nil {
+		fields = append(fields, log.Int("status", resp.StatusCode))
+	}
+	logger.Debug("client: retrying request", fields...)
+}
+
+func defaultShouldRetry(req *message.Request, resp *message.Response, err error) bool {
+	if err != nil {
+		return req.Context().Err() == nil
+	}
+	switch resp.StatusCode {
+	case common.StatusTooManyRequests, common.StatusBadGateway, common.StatusServiceUnavailable, common.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
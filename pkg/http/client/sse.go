@@ -0,0 +1,290 @@
+package client
+
+/*
+	Server-Sent Events 客户端 (HTML 标准 9.2 EventSource): 解析 text/event-stream 并把事件送到通道,
+	连接断开后按服务器给出的 retry 间隔重连并携带 Last-Event-ID, ctx 结束时停止
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// DefaultSSERetry 服务器没有给出 retry 字段时的重连间隔
+const DefaultSSERetry = 3 * time.Second
+
+// DefaultSSEMaxRetry 连续失败时重连间隔加倍的上限
+const DefaultSSEMaxRetry = time.Minute
+
+// maxSSELineSize 事件流中单行的最大长度
+const maxSSELineSize = 1 << 20
+
+// ErrEventStream 服务器的应答不是事件流 (状态码或 Content-Type 不对), 不再重连
+var ErrEventStream = errors.New("client: not an event stream")
+
+// Event 一条服务器发送事件
+type Event struct {
+	// ID 事件的 id 字段, 没有时沿用之前的 id
+	ID string
+	// Type 事件类型, 默认为 "message"
+	Type string
+	// Data 事件数据, 多个 data 行以换行连接
+	Data string
+}
+
+// EventSource 订阅一个事件流, 断线后自动重连
+type EventSource struct {
+	// URL 事件流地址
+	URL string
+	// Client 发送请求的客户端, 为空时使用 New(); 不要设置 WithTimeout, 否则长连接会被超时中断
+	Client *Client
+	// Header 附加的请求头
+	Header common.Header
+	// LastEventID 首次连接时发送的 Last-Event-ID, 用于从上次中断处继续
+	LastEventID string
+	// Retry 初始重连间隔, 0 时使用 DefaultSSERetry; 服务器的 retry 字段会覆盖它
+	Retry time.Duration
+	// MaxRetry 连续失败时重连间隔加倍的上限, 0 时使用 DefaultSSEMaxRetry
+	MaxRetry time.Duration
+	// BufferSize 事件通道的缓冲大小
+	BufferSize int
+	// OnError 非空时在每次连接失败或断开后调用, delay 为距下次重连的时间
+	OnError func(err error, delay time.Duration)
+
+	mu     sync.Mutex
+	lastID string
+	retry  time.Duration
+	err    error
+}
+
+// Subscribe 开始接收事件. 返回的通道在 ctx 结束或遇到不可恢复的错误时关闭, 之后可用 Err 取得原因
+func (es *EventSource) Subscribe(ctx context.Context) <-chan Event {
+	es.mu.Lock()
+	es.lastID = es.LastEventID
+	es.retry = es.Retry
+	if es.retry <= 0 {
+		es.retry = DefaultSSERetry
+	}
+	es.err = nil
+	es.mu.Unlock()
+	ch := make(chan Event, es.BufferSize)
+	go es.run(ctx, ch)
+	return ch
+}
+
+// Err 返回通道关闭的原因: ctx 的错误、ErrEventStream 等; 服务器以 204 结束订阅时为 nil
+func (es *EventSource) Err() error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return es.err
+}
+
+// LastID 返回最近收到的事件 id, 可保存下来供下次订阅时设置 LastEventID
+func (es *EventSource) LastID() string {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return es.lastID
+}
+
+func (es *EventSource) run(ctx context.Context, ch chan<- Event) {
+	defer close(ch)
+	maxRetry := es.MaxRetry
+	if maxRetry <= 0 {
+		maxRetry = DefaultSSEMaxRetry
+	}
+	failures := 0
+	for {
+		received, err := es.connect(ctx, ch)
+		if ctx.Err() != nil {
+			es.setErr(ctx.Err())
+			return
+		}
+		if err == nil || errors.Is(err, ErrEventStream) {
+			es.setErr(err)
+			return
+		}
+		if received {
+			failures = 0
+		}
+		es.mu.Lock()
+		base := es.retry
+		es.mu.Unlock()
+		// 连续失败时加倍, 收到过事件的连接断开后按原间隔重连; 服务器给出的间隔大于上限时以它为准
+		b := utils.Backoff{Min: base, Max: max(maxRetry, base)}
+		failures++
+		delay := b.Duration(failures)
+		if es.OnError != nil {
+			es.OnError(err, delay)
+		}
+		if err := utils.SleepContext(ctx, delay); err != nil {
+			es.setErr(err)
+			return
+		}
+	}
+}
+
+func (es *EventSource) setErr(err error) {
+	es.mu.Lock()
+	es.err = err
+	es.mu.Unlock()
+}
+
+// connect 建立一次连接并读取事件直到断开; received 表示本次连接收到过事件.
+// 返回 nil 表示服务器要求停止 (204), ErrEventStream 表示不应重连, 其他错误都会重连
+func (es *EventSource) connect(ctx context.Context, ch chan<- Event) (received bool, err error) {
+	req, err := message.NewRequestWithContext(ctx, common.MethodGet, es.URL, nil)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrEventStream, err)
+	}
+	for k, vs := range es.Header {
+		req.Header[k] = append([]string(nil), vs...)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-store")
+	if id := es.LastID(); id != "" {
+		req.Header.Set("Last-Event-ID", id)
+	}
+	c := es.Client
+	if c == nil {
+		c = defaultSSEClient
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == common.StatusNoContent:
+		return false, nil
+	case resp.StatusCode == common.StatusTooManyRequests || resp.StatusCode >= 500:
+		return false, fmt.Errorf("client: event stream: %s", resp.Status)
+	case resp.StatusCode != common.StatusOK:
+		return false, fmt.Errorf("%w: %s", ErrEventStream, resp.Status)
+	}
+	if mt, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";"); !strings.EqualFold(strings.TrimSpace(mt), "text/event-stream") {
+		return false, fmt.Errorf("%w: Content-Type %q", ErrEventStream, resp.Header.Get("Content-Type"))
+	}
+	// 读消息体不一定响应 ctx, 结束时关闭消息体让读取返回
+	stop := context.AfterFunc(ctx, func() { resp.Body.Close() })
+	defer stop()
+
+	p := &sseParser{lastID: es.LastID()}
+	p.idBuf = p.lastID
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 0, 4096), maxSSELineSize)
+	sc.Split(scanSSELines)
+	for sc.Scan() {
+		ev, ok := p.line(sc.Text())
+		es.mu.Lock()
+		es.lastID = p.lastID
+		if p.retry > 0 {
+			es.retry = p.retry
+		}
+		es.mu.Unlock()
+		if !ok {
+			continue
+		}
+		select {
+		case ch <- ev:
+			received = true
+		case <-ctx.Done():
+			return received, ctx.Err()
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return received, err
+	}
+	return received, errors.New("client: event stream closed by server")
+}
+
+var defaultSSEClient = New()
+
+// sseParser 按行解析事件流, 空行时分派缓冲的事件
+type sseParser struct {
+	// lastID 在分派时才从 idBuf 更新, 连接在事件中途断开时不会跳过该事件
+	lastID    string
+	idBuf     string
+	retry     time.Duration
+	eventType string
+	data      strings.Builder
+	hasData   bool
+	started   bool
+}
+
+// line 处理一行, 空行且有数据时返回完整的事件
+func (p *sseParser) line(s string) (Event, bool) {
+	if !p.started {
+		// 流开头的 BOM 被忽略
+		s = strings.TrimPrefix(s, "\uFEFF")
+		p.started = true
+	}
+	if s == "" {
+		p.lastID = p.idBuf
+		defer func() {
+			p.eventType = ""
+			p.data.Reset()
+			p.hasData = false
+		}()
+		if !p.hasData {
+			return Event{}, false
+		}
+		typ := p.eventType
+		if typ == "" {
+			typ = "message"
+		}
+		return Event{ID: p.lastID, Type: typ, Data: strings.TrimSuffix(p.data.String(), "\n")}, true
+	}
+	if s[0] == ':' {
+		return Event{}, false
+	}
+	field, value, _ := strings.Cut(s, ":")
+	value = strings.TrimPrefix(value, " ")
+	switch field {
+	case "event":
+		p.eventType = value
+	case "data":
+		p.data.WriteString(value)
+		p.data.WriteByte('\n')
+		p.hasData = true
+	case "id":
+		if !strings.ContainsRune(value, 0) {
+			p.idBuf = value
+		}
+	case "retry":
+		if ms, err := strconv.ParseUint(value, 10, 32); err == nil {
+			p.retry = time.Duration(ms) * time.Millisecond
+		}
+	}
+	return Event{}, false
+}
+
+// scanSSELines 按 CRLF、LF 或单独的 CR 分行; 流结束时不完整的最后一行被丢弃
+func scanSSELines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	i := bytes.IndexAny(data, "\r\n")
+	switch {
+	case i < 0:
+		return 0, nil, nil
+	case data[i] == '\n':
+		return i + 1, data[:i], nil
+	case i+1 < len(data):
+		if data[i+1] == '\n' {
+			return i + 2, data[:i], nil
+		}
+		return i + 1, data[:i], nil
+	case atEOF:
+		return i + 1, data[:i], nil
+	}
+	// CR 在缓冲区末尾, 需要再读一个字节才能判断是否为 CRLF
+	return 0, nil, nil
+}
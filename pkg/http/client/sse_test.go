@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+)
+
+func TestEventSourceReconnectBackoff(t *testing.T) {
+	var conns atomic.Int32
+	rt := RoundTripperFunc(func(req *message.Request) (*message.Response, error) {
+		if conns.Add(1) == 1 {
+			resp := message.NewResponse(200)
+			resp.Header.Set("Content-Type", "text/event-stream")
+			resp.Body = io.NopCloser(strings.NewReader("retry: 20\n\n"))
+			return resp, nil
+		}
+		return nil, errors.New("connection refused")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var delays []time.Duration
+	es := &EventSource{
+		URL:      "http://example.com/events",
+		Client:   New(WithTransport(rt)),
+		Retry:    time.Hour,
+		MaxRetry: 50 * time.Millisecond,
+		OnError: func(err error, delay time.Duration) {
+			delays = append(delays, delay)
+			if len(delays) == 4 {
+				cancel()
+			}
+		},
+	}
+	for range es.Subscribe(ctx) {
+	}
+	if !errors.Is(es.Err(), context.Canceled) {
+		t.Fatalf("Err() = %v, want context.Canceled", es.Err())
+	}
+	// 服务器的 retry 作为退避起点, 逐次加倍直到上限
+	want := []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond}
+	if len(delays) != len(want) {
+		t.Fatalf("delays = %v, want %v", delays, want)
+	}
+	for i := range want {
+		if delays[i] != want[i] {
+			t.Fatalf("delays = %v, want %v", delays, want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package client
+
+/*
+	追踪传输层: 为每个请求创建 client span 并以 traceparent 传播给服务端,
+	span 在响应体读完或关闭时结束, 以便计入消息体的传输时间
+*/
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/trace"
+)
+
+// TraceTransport 追踪请求的 RoundTripper
+type TraceTransport struct {
+	// Transport 下层传输, 为空时使用默认 Transport
+	Transport RoundTripper
+	// Tracer 创建 span, 为空时使用 trace.NoopTracer (只传播上下文中已有的标识)
+	Tracer trace.Tracer
+}
+
+func (t *TraceTransport) next() RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return defaultTransport
+}
+
+// RoundTrip 实现 RoundTripper
+func (t *TraceTransport) RoundTrip(req *message.Request) (*message.Response, error) {
+	tracer := t.Tracer
+	if tracer == nil {
+		tracer = trace.NoopTracer{}
+	}
+	attrs := []trace.Attribute{trace.String(trace.AttrHTTPRequestMethod, req.Method)}
+	if req.URL != nil {
+		u := *req.URL
+		u.User = nil
+		attrs = append(attrs, trace.String(trace.AttrURLFull, u.String()))
+		if host, port, err := net.SplitHostPort(canonicalAddr(u.Scheme, u.Host)); err == nil {
+			attrs = append(attrs, trace.String(trace.AttrServerAddress, trimBrackets(host)))
+			if n, err := strconv.Atoi(port); err == nil {
+				attrs = append(attrs, trace.Int(trace.AttrServerPort, n))
+			}
+		}
+	}
+	if req.ContentLength > 0 {
+		attrs = append(attrs, trace.Int64(trace.AttrHTTPRequestBodySize, req.ContentLength))
+	}
+	ctx, span := tracer.Start(req.Context(), req.Method, trace.StartOptions{Kind: trace.SpanKindClient, Attributes: attrs})
+
+	out := req.Clone(ctx)
+	trace.Inject(ctx, out.Header)
+	resp, err := t.next().RoundTrip(out)
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttributes(trace.String(trace.AttrErrorType, errorType(err)))
+		span.SetStatus(trace.StatusError, err.Error())
+		span.End()
+		return nil, err
+	}
+	span.SetAttributes(trace.Int(trace.AttrHTTPResponseStatusCode, resp.StatusCode))
+	if resp.Proto != "" {
+		span.SetAttributes(trace.String(trace.AttrNetworkProtocolVersion, strings.TrimPrefix(resp.Proto, "HTTP/")))
+	}
+	// 客户端把 4xx 和 5xx 都视为错误
+	if resp.StatusCode >= 400 {
+		span.SetAttributes(trace.String(trace.AttrErrorType, strconv.Itoa(resp.StatusCode)))
+		span.SetStatus(trace.StatusError, "")
+	}
+	if resp.Body == nil || resp.Body == message.NoBody {
+		span.SetAttributes(trace.Int64(trace.AttrHTTPResponseBodySize, 0))
+		span.End()
+		return resp, nil
+	}
+	resp.Body = &tracedBody{body: resp.Body, span: span}
+	return resp, nil
+}
+
+// errorType 返回错误的类别, 用作 error.type 属性
+func errorType(err error) string {
+	var ne net.Error
+	switch {
+	case errors.As(err, &ne) && ne.Timeout():
+		return "timeout"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "unexpected_eof"
+	}
+	return "_OTHER"
+}
+
+// tracedBody 统计响应体字节数, 读到 EOF、出错或关闭时结束 span
+type tracedBody struct {
+	body io.ReadCloser
+	span trace.Span
+	n    int64
+	once sync.Once
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.n += int64(n)
+	if err == io.EOF {
+		b.finish(nil)
+	} else if err != nil {
+		b.finish(err)
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	err := b.body.Close()
+	b.finish(nil)
+	return err
+}
+
+func (b *tracedBody) finish(err error) {
+	b.once.Do(func() {
+		if err != nil {
+			b.span.RecordError(err)
+		}
+		b.span.SetAttributes(trace.Int64(trace.AttrHTTPResponseBodySize, b.n))
+		b.span.End()
+	})
+}
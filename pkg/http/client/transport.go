@@ -3,3 +3,302 @@ package client
 /*
 	HTTP客户端传输层
 */
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http1"
+	"github.com/narcilee7/http-stack/pkg/metrics"
+	"github.com/narcilee7/http-stack/pkg/quic"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// RoundTripper 执行单次 HTTP 事务
+type RoundTripper interface {
+	RoundTrip(req *message.Request) (*message.Response, error)
+}
+
+// RoundTripperFunc 将函数适配为 RoundTripper
+type RoundTripperFunc func(req *message.Request) (*message.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *message.Request) (*message.Response, error) { return f(req) }
+
+var (
+	ErrNilURL            = errors.New("client: request URL is nil")
+	ErrUnsupportedScheme = errors.New("client: unsupported protocol scheme")
+)
+
+// Transport HTTP 传输层, 通过连接池复用连接; 直连的 https 请求通过 ALPN 协商 HTTP/2, 在一条连接上多路复用
+type Transport struct {
+	// Pool 连接池, 为空时使用默认配置
+	Pool *Pool
+	// Metrics Pool 为空时默认连接池上报指标的注册表, 为空时不导出
+	Metrics *metrics.Registry
+	// Breakers 按主机熔断, 为空时不启用
+	Breakers *BreakerGroup
+	// Proxy 选择请求使用的代理, 为空时直连
+	Proxy ProxyFunc
+	// ProxyCredentials 按代理地址配置的认证凭据
+	ProxyCredentials ProxyCredentials
+	// UnixSocket 非空时所有 http 请求都经该 Unix socket 发送, 如 /var/run/docker.sock
+	UnixSocket string
+	// Signers 每次发送前依次执行的签名钩子
+	Signers []RequestSigner
+	// ResponseHeaderTimeout 写完请求后等待响应头的最长时间
+	ResponseHeaderTimeout time.Duration
+	// DisableHTTP2 为 true 时 https 请求只使用 HTTP/1.1
+	DisableHTTP2 bool
+	// HTTP2 HTTP/2 连接参数, 为空时使用默认值; 单个请求可以通过 WithHTTP2Config 覆盖
+	HTTP2 *HTTP2Config
+	// DialQUIC 非空时直连的 https 请求经 HTTP/3 发送 (实验性), 由它建立 QUIC 连接;
+	// 经代理的请求不受影响
+	DialQUIC quic.DialFunc
+
+	once sync.Once
+	h2   h2ConnSet
+	h3   h3ConnSet
+}
+
+func (t *Transport) init() {
+	t.once.Do(func() {
+		if t.Pool == nil {
+			t.Pool = NewPool(PoolConfig{Metrics: t.Metrics})
+		}
+	})
+}
+
+// Stats 返回连接池的实时统计
+func (t *Transport) Stats() PoolStats {
+	t.init()
+	return t.Pool.Stats()
+}
+
+// CloseIdleConnections 关闭所有空闲连接, 包括没有活动请求的 HTTP/2 和 HTTP/3 连接
+func (t *Transport) CloseIdleConnections() {
+	t.init()
+	t.Pool.CloseIdle()
+	t.h2.closeIdle()
+	t.h3.closeIdle()
+}
+
+// RoundTrip 实现 RoundTripper
+func (t *Transport) RoundTrip(req *message.Request) (*message.Response, error) {
+	t.init()
+	if req.URL == nil {
+		closeRequestBody(req)
+		return nil, ErrNilURL
+	}
+	scheme := req.URL.Scheme
+	var addr string
+	switch {
+	case scheme == schemeHTTPUnix || t.UnixSocket != "" && scheme == "http":
+		socket, ureq, err := t.unixTarget(req)
+		if err != nil {
+			closeRequestBody(req)
+			return nil, err
+		}
+		req, scheme, addr = ureq, schemeUnix, socket
+	case scheme == "http" || scheme == "https":
+		addr = canonicalAddr(scheme, req.URL.Host)
+	default:
+		closeRequestBody(req)
+		return nil, ErrUnsupportedScheme
+	}
+
+	if t.Breakers != nil {
+		if err := t.Breakers.Allow(addr); err != nil {
+			closeRequestBody(req)
+			return nil, err
+		}
+	}
+	resp, err := t.roundTrip(req, scheme, addr)
+	if t.Breakers != nil {
+		t.Breakers.Done(addr, err == nil && resp.StatusCode < 500)
+	}
+	return resp, err
+}
+
+func (t *Transport) roundTrip(req *message.Request, scheme, addr string) (*message.Response, error) {
+	var proxy *url.URL
+	var err error
+	if scheme != schemeUnix {
+		proxy, err = t.proxyFor(req)
+	}
+	if err != nil {
+		closeRequestBody(req)
+		return nil, err
+	}
+	if proxy != nil && scheme == "http" {
+		if user := t.proxyUser(proxy); user != nil && !req.Header.Has("Proxy-Authorization") {
+			req = req.Clone(req.Context())
+			req.Header.Set("Proxy-Authorization", basicAuth(user))
+		}
+	}
+	resp, err := t.exchange(req, scheme, addr, proxy)
+	if err == nil && proxy != nil && resp.StatusCode == common.StatusProxyAuthRequired {
+		return t.retryProxyAuth(req, resp, proxy, scheme, addr)
+	}
+	return resp, err
+}
+
+// exchange 取得连接, 写出请求并读取响应头
+func (t *Transport) exchange(req *message.Request, scheme, addr string, proxy *url.URL) (*message.Response, error) {
+	ctx := req.Context()
+	if len(t.Signers) > 0 {
+		signed, err := t.sign(req)
+		if err != nil {
+			closeRequestBody(req)
+			return nil, err
+		}
+		req = signed
+	}
+	var pc *PooledConn
+	var err error
+	switch {
+	case scheme == schemeUnix:
+		pc, err = t.getUnixConn(ctx, addr)
+	case proxy != nil:
+		pc, err = t.getProxyConn(ctx, proxy, scheme, addr)
+	case scheme == "https" && t.DialQUIC != nil:
+		return t.exchangeHTTP3(req, addr)
+	case scheme == "https" && !t.DisableHTTP2:
+		var resp *message.Response
+		if resp, pc, err = t.exchangeHTTP2(req, addr); pc == nil {
+			return resp, err
+		}
+	default:
+		pc, err = t.Pool.Get(ctx, scheme, addr)
+	}
+	if err != nil {
+		closeRequestBody(req)
+		return nil, err
+	}
+
+	// 上下文取消时通过设置过期时间打断阻塞的读写
+	stop := context.AfterFunc(ctx, func() {
+		pc.SetDeadline(time.Unix(1, 0))
+	})
+	if deadline, ok := ctx.Deadline(); ok {
+		pc.SetDeadline(deadline)
+	}
+
+	// 请求写出时已 Flush, 写缓冲可立即归还
+	bw := utils.GetWriter(pc, 4<<10)
+	if proxy != nil && scheme == "http" {
+		err = http1.WriteProxyRequest(bw, req)
+	} else {
+		err = http1.WriteRequest(bw, req)
+	}
+	utils.PutWriter(bw)
+	closeRequestBody(req)
+	if err != nil {
+		stop()
+		t.Pool.Discard(pc)
+		return nil, ctxErr(ctx, err)
+	}
+
+	if t.ResponseHeaderTimeout > 0 {
+		pc.SetReadDeadline(time.Now().Add(t.ResponseHeaderTimeout))
+	}
+	br := bufio.NewReader(pc)
+	resp, err := http1.ReadResponse(br, req)
+	if err != nil {
+		stop()
+		t.Pool.Discard(pc)
+		return nil, ctxErr(ctx, err)
+	}
+	if t.ResponseHeaderTimeout > 0 {
+		if deadline, ok := ctx.Deadline(); ok {
+			pc.SetReadDeadline(deadline)
+		} else {
+			pc.SetReadDeadline(time.Time{})
+		}
+	}
+
+	resp.Body = &bodyEOFSignal{
+		body: resp.Body,
+		fn: func(eof bool) {
+			reusable := stop() && eof && !resp.Close && !req.Close && br.Buffered() == 0
+			if reusable {
+				pc.SetDeadline(time.Time{})
+				t.Pool.Put(pc)
+				return
+			}
+			t.Pool.Discard(pc)
+		},
+	}
+	return resp, nil
+}
+
+// bodyEOFSignal 在消息体读完或关闭时回调一次, 用于归还连接
+type bodyEOFSignal struct {
+	body io.ReadCloser
+	mu   sync.Mutex
+	done bool
+	fn   func(eof bool)
+}
+
+func (b *bodyEOFSignal) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if err != nil {
+		b.finish(err == io.EOF)
+	}
+	return n, err
+}
+
+func (b *bodyEOFSignal) Close() error {
+	err := b.body.Close()
+	b.finish(false)
+	return err
+}
+
+func (b *bodyEOFSignal) finish(eof bool) {
+	b.mu.Lock()
+	if b.done {
+		b.mu.Unlock()
+		return
+	}
+	b.done = true
+	b.mu.Unlock()
+	b.fn(eof)
+}
+
+func closeRequestBody(req *message.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+func ctxErr(ctx context.Context, err error) error {
+	if cerr := ctx.Err(); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+func canonicalAddr(scheme, host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	port := "80"
+	if scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(trimBrackets(host), port)
+}
+
+func trimBrackets(host string) string {
+	if len(host) > 1 && host[0] == '[' && host[len(host)-1] == ']' {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
@@ -0,0 +1,91 @@
+package client
+
+/*
+	Unix socket 目标: 支持 http+unix:// URL 和传输层级别的 socket 覆盖
+*/
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+)
+
+const (
+	schemeHTTPUnix = "http+unix"
+	schemeUnix     = "unix"
+)
+
+// ErrNoUnixSocket 无法从 http+unix URL 中确定 socket 路径
+var ErrNoUnixSocket = errors.New("client: no unix socket found in URL")
+
+// unixTarget 确定 socket 路径并返回改写为普通 http URL 的请求.
+// http+unix:///var/run/app.sock/api 依次尝试路径前缀, 取第一个存在的 socket 文件,
+// 剩余部分作为请求路径; URL 中的主机部分 (可为空) 用作 Host 头部
+func (t *Transport) unixTarget(req *message.Request) (string, *message.Request, error) {
+	u := *req.URL
+	var socket string
+	if u.Scheme != schemeHTTPUnix {
+		socket = t.UnixSocket
+	} else {
+		var rest string
+		socket, rest = splitSocketPath(u.Path)
+		if socket == "" {
+			return "", nil, ErrNoUnixSocket
+		}
+		u.Path = rest
+		u.RawPath = ""
+	}
+
+	u.Scheme = "http"
+	if u.Host == "" {
+		u.Host = "localhost"
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	r2 := req.WithContext(req.Context())
+	r2.URL = &u
+	if r2.Host == "" || req.URL.Scheme == schemeHTTPUnix {
+		r2.Host = u.Host
+	}
+	return socket, r2, nil
+}
+
+func splitSocketPath(p string) (socket, rest string) {
+	for i := 1; i <= len(p); i++ {
+		if i < len(p) && p[i] != '/' {
+			continue
+		}
+		fi, err := os.Stat(p[:i])
+		if err != nil {
+			return "", ""
+		}
+		if fi.Mode()&os.ModeSocket != 0 {
+			return p[:i], p[i:]
+		}
+		if !fi.IsDir() {
+			return "", ""
+		}
+	}
+	return "", ""
+}
+
+func (t *Transport) getUnixConn(ctx context.Context, socket string) (*PooledConn, error) {
+	return t.Pool.GetWith(ctx, schemeUnix+"://"+socket, func(ctx context.Context) (net.Conn, time.Duration, error) {
+		conn, err := t.Pool.cfg.Dial(ctx, "unix", socket)
+		return conn, 0, err
+	})
+}
+
+// UnixSocketURL 构造 http+unix URL, socket 必须为绝对路径
+func UnixSocketURL(socket, path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return schemeHTTPUnix + "://" + socket + path
+}
@@ -1 +1,30 @@
 package message
+
+/*
+	消息体处理
+*/
+
+import (
+	"io"
+)
+
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error)         { return 0, io.EOF }
+func (noBody) Close() error                     { return nil }
+func (noBody) WriteTo(io.Writer) (int64, error) { return 0, nil }
+
+// NoBody 表示没有消息体
+var NoBody = noBody{}
+
+// DrainAndClose 读完并关闭消息体, 以便底层连接可以复用
+func DrainAndClose(body io.ReadCloser, limit int64) error {
+	if body == nil {
+		return nil
+	}
+	_, err := io.Copy(io.Discard, io.LimitReader(body, limit))
+	if cerr := body.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
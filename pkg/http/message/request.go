@@ -1 +1,164 @@
 package message
+
+/*
+	HTTP请求结构
+*/
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+// Request HTTP请求
+type Request struct {
+	Method string
+	URL    *url.URL
+	Proto  string
+	Header common.Header
+	// Host 覆盖 URL 中的主机, 用于 Host 头部
+	Host string
+
+	Body io.ReadCloser
+	// GetBody 返回消息体的新副本, 用于重试和重定向时重放
+	GetBody func() (io.ReadCloser, error)
+	// ContentLength 为 -1 表示未知长度, 使用分块编码发送
+	ContentLength int64
+	Trailer       common.Header
+	Close         bool
+
+	ctx context.Context
+}
+
+// NewRequest 创建请求
+func NewRequest(method, rawURL string, body io.Reader) (*Request, error) {
+	return NewRequestWithContext(context.Background(), method, rawURL, body)
+}
+
+// NewRequestWithContext 创建绑定上下文的请求
+func NewRequestWithContext(ctx context.Context, method, rawURL string, body io.Reader) (*Request, error) {
+	if ctx == nil {
+		return nil, errors.New("message: nil context")
+	}
+	if method == "" {
+		method = common.MethodGet
+	}
+	if !common.ValidMethod(method) {
+		return nil, errors.New("message: invalid method " + method)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	req := &Request{
+		Method: method,
+		URL:    u,
+		Proto:  "HTTP/1.1",
+		Header: make(common.Header),
+		Host:   u.Host,
+		ctx:    ctx,
+	}
+	if body != nil {
+		setBody(req, body)
+	}
+	return req, nil
+}
+
+func setBody(req *Request, body io.Reader) {
+	switch v := body.(type) {
+	case *bytes.Buffer:
+		buf := v.Bytes()
+		req.ContentLength = int64(len(buf))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(buf)), nil
+		}
+	case *bytes.Reader:
+		snapshot := *v
+		req.ContentLength = int64(v.Len())
+		req.GetBody = func() (io.ReadCloser, error) {
+			r := snapshot
+			return io.NopCloser(&r), nil
+		}
+	case *strings.Reader:
+		snapshot := *v
+		req.ContentLength = int64(v.Len())
+		req.GetBody = func() (io.ReadCloser, error) {
+			r := snapshot
+			return io.NopCloser(&r), nil
+		}
+	default:
+		req.ContentLength = -1
+	}
+	rc, ok := body.(io.ReadCloser)
+	if !ok {
+		rc = io.NopCloser(body)
+	}
+	req.Body = rc
+	if req.GetBody != nil && req.ContentLength == 0 {
+		req.Body = NoBody
+		req.GetBody = func() (io.ReadCloser, error) { return NoBody, nil }
+	}
+}
+
+// Context 返回请求的上下文
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext 返回绑定新上下文的浅拷贝
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("message: nil context")
+	}
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+// Clone 返回绑定新上下文的深拷贝, 消息体不复制
+func (r *Request) Clone(ctx context.Context) *Request {
+	r2 := r.WithContext(ctx)
+	if r.URL != nil {
+		u := *r.URL
+		r2.URL = &u
+	}
+	r2.Header = r.Header.Clone()
+	r2.Trailer = r.Trailer.Clone()
+	return r2
+}
+
+// HostHeader 返回应写入 Host 头部的值
+func (r *Request) HostHeader() string {
+	if r.Host != "" {
+		return r.Host
+	}
+	if r.URL != nil {
+		return r.URL.Host
+	}
+	return ""
+}
+
+// RequestURI 返回请求行中的目标 (origin-form)
+func (r *Request) RequestURI() string {
+	if r.URL == nil {
+		return "/"
+	}
+	uri := r.URL.RequestURI()
+	if uri == "" {
+		uri = "/"
+	}
+	return uri
+}
+
+// Replayable 判断消息体是否可以重放
+func (r *Request) Replayable() bool {
+	return r.Body == nil || r.Body == NoBody || r.GetBody != nil
+}
@@ -1 +1,50 @@
 package message
+
+/*
+	HTTP响应结构
+*/
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+// Response HTTP响应
+type Response struct {
+	StatusCode int
+	// Status 为完整状态, 如 "200 OK"
+	Status string
+	Proto  string
+	Header common.Header
+
+	Body io.ReadCloser
+	// ContentLength 为 -1 表示未知长度
+	ContentLength int64
+	Trailer       common.Header
+	Close         bool
+
+	// Request 产生该响应的请求
+	Request *Request
+}
+
+// NewResponse 创建状态码对应的响应
+func NewResponse(code int) *Response {
+	return &Response{
+		StatusCode: code,
+		Status:     StatusLine(code),
+		Proto:      "HTTP/1.1",
+		Header:     make(common.Header),
+		Body:       NoBody,
+	}
+}
+
+// StatusLine 返回 "code reason" 形式的状态
+func StatusLine(code int) string {
+	text := common.StatusText(code)
+	if text == "" {
+		text = "status code " + strconv.Itoa(code)
+	}
+	return strconv.Itoa(code) + " " + text
+}
@@ -1 +1,146 @@
 package common
+
+/*
+	HTTP头部处理
+*/
+
+import (
+	"io"
+	"sort"
+	"strings"
+)
+
+// Header HTTP头部, 键为规范化的头部名称
+type Header map[string][]string
+
+// Add 追加一个头部值
+func (h Header) Add(key, value string) {
+	key = CanonicalHeaderKey(key)
+	h[key] = append(h[key], value)
+}
+
+// Set 设置头部值, 覆盖已有的值
+func (h Header) Set(key, value string) {
+	h[CanonicalHeaderKey(key)] = []string{value}
+}
+
+// Get 返回头部的第一个值
+func (h Header) Get(key string) string {
+	v := h[CanonicalHeaderKey(key)]
+	if len(v) == 0 {
+		return ""
+	}
+	return v[0]
+}
+
+// Values 返回头部的全部值
+func (h Header) Values(key string) []string {
+	return h[CanonicalHeaderKey(key)]
+}
+
+// Has 判断头部是否存在
+func (h Header) Has(key string) bool {
+	_, ok := h[CanonicalHeaderKey(key)]
+	return ok
+}
+
+// HasToken 判断逗号分隔的头部值中是否包含 token (不区分大小写), 如 Connection: keep-alive, Upgrade
+func (h Header) HasToken(key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Del 删除头部
+func (h Header) Del(key string) {
+	delete(h, CanonicalHeaderKey(key))
+}
+
+// Clone 深拷贝头部
+func (h Header) Clone() Header {
+	if h == nil {
+		return nil
+	}
+	h2 := make(Header, len(h))
+	for k, v := range h {
+		if v == nil {
+			h2[k] = nil
+			continue
+		}
+		h2[k] = append([]string(nil), v...)
+	}
+	return h2
+}
+
+// Write 以 wire 格式写出头部, 值为空的键被跳过, 键按字典序输出
+func (h Header) Write(w io.Writer) error {
+	return h.WriteSubset(w, nil)
+}
+
+// WriteSubset 同 Write, 但跳过 exclude 中为 true 的键
+func (h Header) WriteSubset(w io.Writer, exclude map[string]bool) error {
+	keys := make([]string, 0, len(h))
+	for k, v := range h {
+		if len(v) > 0 && !exclude[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		for _, v := range h[k] {
+			v = headerValueReplacer.Replace(v)
+			if _, err := io.WriteString(w, k+": "+strings.TrimSpace(v)+"\r\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 防止头部值中的换行符造成头部注入
+var headerValueReplacer = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// CanonicalHeaderKey 返回规范化的头部名称, 如 content-type -> Content-Type.
+// 包含非法字符的名称原样返回
+func CanonicalHeaderKey(s string) string {
+	upper := true
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !IsTokenChar(c) {
+			return s
+		}
+		if upper && 'a' <= c && c <= 'z' || !upper && 'A' <= c && c <= 'Z' {
+			return canonicalize(s)
+		}
+		upper = c == '-'
+	}
+	return s
+}
+
+func canonicalize(s string) string {
+	b := []byte(s)
+	upper := true
+	for i, c := range b {
+		if upper && 'a' <= c && c <= 'z' {
+			b[i] = c - ('a' - 'A')
+		} else if !upper && 'A' <= c && c <= 'Z' {
+			b[i] = c + ('a' - 'A')
+		}
+		upper = c == '-'
+	}
+	return string(b)
+}
+
+// IsTokenChar 判断字符是否为 RFC 9110 token 字符
+func IsTokenChar(c byte) bool {
+	if c >= 0x80 || c <= ' ' || c == 0x7f {
+		return false
+	}
+	return !strings.ContainsRune("\"(),/:;<=>?@[\\]{}", rune(c))
+}
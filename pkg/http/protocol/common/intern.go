@@ -0,0 +1,60 @@
+package common
+
+/*
+	常见头部名称的驻留表, 解析器据此复用规范化的名称字符串
+*/
+
+import "github.com/narcilee7/http-stack/pkg/utils"
+
+// 常见的请求和响应头部名称 (规范化形式)
+var commonHeaderNames = []string{
+	"Accept", "Accept-Charset", "Accept-Encoding", "Accept-Language", "Accept-Ranges",
+	"Access-Control-Allow-Credentials", "Access-Control-Allow-Headers", "Access-Control-Allow-Methods",
+	"Access-Control-Allow-Origin", "Access-Control-Expose-Headers", "Access-Control-Max-Age",
+	"Access-Control-Request-Headers", "Access-Control-Request-Method",
+	"Age", "Allow", "Alt-Svc", "Authorization", "Cache-Control", "Connection",
+	"Content-Disposition", "Content-Encoding", "Content-Language", "Content-Length",
+	"Content-Location", "Content-Range", "Content-Security-Policy", "Content-Type", "Cookie",
+	"Date", "Etag", "Expect", "Expires", "Forwarded", "From", "Host",
+	"If-Match", "If-Modified-Since", "If-None-Match", "If-Range", "If-Unmodified-Since",
+	"Keep-Alive", "Last-Modified", "Link", "Location", "Max-Forwards", "Origin", "Pragma",
+	"Proxy-Authenticate", "Proxy-Authorization", "Proxy-Connection", "Range", "Referer",
+	"Retry-After", "Sec-Websocket-Accept", "Sec-Websocket-Extensions", "Sec-Websocket-Key",
+	"Sec-Websocket-Protocol", "Sec-Websocket-Version", "Server", "Set-Cookie",
+	"Strict-Transport-Security", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+	"Upgrade-Insecure-Requests", "User-Agent", "Vary", "Via", "Www-Authenticate", "Warning",
+	"X-Content-Type-Options", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto",
+	"X-Frame-Options", "X-Real-Ip", "X-Request-Id", "X-Requested-With",
+}
+
+// headerNames 只使用预置表, 不随请求增长
+var headerNames = utils.NewInterner(0, commonHeaderNames...)
+
+// maxInternedHeaderName 超过该长度的名称不查表
+const maxInternedHeaderName = 64
+
+// CanonicalHeaderKeyBytes 同 CanonicalHeaderKey, 常见头部名称返回共享的字符串而不分配内存
+func CanonicalHeaderKeyBytes(b []byte) string {
+	if len(b) <= maxInternedHeaderName {
+		var buf [maxInternedHeaderName]byte
+		key := buf[:len(b)]
+		upper := true
+		for i, c := range b {
+			if !IsTokenChar(c) {
+				return string(b)
+			}
+			if upper && 'a' <= c && c <= 'z' {
+				c -= 'a' - 'A'
+			} else if !upper && 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			key[i] = c
+			upper = c == '-'
+		}
+		if s, ok := headerNames.Lookup(key); ok {
+			return s
+		}
+		return string(key)
+	}
+	return CanonicalHeaderKey(string(b))
+}
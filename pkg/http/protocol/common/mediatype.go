@@ -0,0 +1,93 @@
+package common
+
+/*
+	媒体类型解析 (RFC 9110 8.3.1), 用于 Content-Type 和 Accept
+*/
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// ErrInvalidMediaType 媒体类型格式错误
+var ErrInvalidMediaType = errors.New("common: invalid media type")
+
+// ParseMediaType 解析 "type/subtype; k=v" 形式的媒体类型, 类型和参数名转为小写, 参数值保持原样.
+// 引号内的分号和转义的引号被正确处理; 没有参数时 params 为 nil
+func ParseMediaType(v string) (mediaType string, params map[string]string, err error) {
+	base, rest, _ := strings.Cut(v, ";")
+	base = strings.TrimSpace(base)
+	typ, sub, ok := strings.Cut(base, "/")
+	if !ok || !isToken(typ) || !isToken(sub) {
+		return "", nil, ErrInvalidMediaType
+	}
+	mediaType = utils.ToLowerASCII(base)
+
+	var sc utils.ParamScanner
+	sc.Reset(rest, ';')
+	for sc.Next() {
+		key := sc.Key()
+		if !isToken(key) {
+			return mediaType, nil, ErrInvalidMediaType
+		}
+		if params == nil {
+			params = make(map[string]string)
+		}
+		key = utils.ToLowerASCII(key)
+		if _, dup := params[key]; dup {
+			return mediaType, nil, ErrInvalidMediaType
+		}
+		params[key] = sc.Value()
+	}
+	if sc.Err() != nil {
+		return mediaType, nil, ErrInvalidMediaType
+	}
+	return mediaType, params, nil
+}
+
+// FormatMediaType 格式化媒体类型和参数, 参数按名称排序, 非 token 的值加引号
+func FormatMediaType(mediaType string, params map[string]string) string {
+	var b strings.Builder
+	b.WriteString(utils.ToLowerASCII(mediaType))
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		v := params[k]
+		b.WriteString("; ")
+		b.WriteString(utils.ToLowerASCII(k))
+		b.WriteByte('=')
+		if isToken(v) {
+			b.WriteString(v)
+			continue
+		}
+		b.WriteByte('"')
+		for i := 0; i < len(v); i++ {
+			if v[i] == '"' || v[i] == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(v[i])
+		}
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// isToken 报告 s 是否为非空的 token (RFC 9110 5.6.2)
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -1 +1,44 @@
 package common
+
+/*
+	HTTP方法
+*/
+
+const (
+	MethodGet     = "GET"
+	MethodHead    = "HEAD"
+	MethodPost    = "POST"
+	MethodPut     = "PUT"
+	MethodPatch   = "PATCH"
+	MethodDelete  = "DELETE"
+	MethodConnect = "CONNECT"
+	MethodOptions = "OPTIONS"
+	MethodTrace   = "TRACE"
+)
+
+// IsSafe 判断方法是否为安全方法 (RFC 9110 9.2.1)
+func IsSafe(method string) bool {
+	switch method {
+	case MethodGet, MethodHead, MethodOptions, MethodTrace:
+		return true
+	}
+	return false
+}
+
+// IsIdempotent 判断方法是否幂等 (RFC 9110 9.2.2)
+func IsIdempotent(method string) bool {
+	return IsSafe(method) || method == MethodPut || method == MethodDelete
+}
+
+// ValidMethod 判断方法名是否为合法的 token
+func ValidMethod(method string) bool {
+	if method == "" {
+		return false
+	}
+	for i := 0; i < len(method); i++ {
+		if !IsTokenChar(method[i]) {
+			return false
+		}
+	}
+	return true
+}
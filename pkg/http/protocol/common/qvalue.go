@@ -0,0 +1,146 @@
+package common
+
+/*
+	带权重列表解析 (RFC 9110 12.4.2), 用于 Accept、Accept-Encoding、Accept-Language 和 TE
+*/
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// QualityValue 带权重列表中的一项
+type QualityValue struct {
+	// Value 小写的取值, 如 "gzip"、"text/html"、"*"
+	Value string
+	// Q 权重 [0, 1], 未指定时为 1
+	Q float64
+	// Params 除 q 以外的参数, 键为小写; 没有参数时为 nil
+	Params map[string]string
+}
+
+// ParseQualityList 解析一个或多个头部值, 按权重从高到低稳定排序 (同权重保持出现顺序).
+// q 值非法的项被忽略
+func ParseQualityList(values ...string) []QualityValue {
+	var list []QualityValue
+	for _, v := range values {
+		for _, item := range splitQuoted(v, ',') {
+			if qv, ok := parseQualityItem(item); ok {
+				list = append(list, qv)
+			}
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Q > list[j].Q })
+	return list
+}
+
+func parseQualityItem(item string) (QualityValue, bool) {
+	value, rest, _ := strings.Cut(item, ";")
+	qv := QualityValue{Value: strings.ToLower(strings.TrimSpace(value)), Q: 1}
+	if qv.Value == "" {
+		return qv, false
+	}
+	var sc utils.ParamScanner
+	sc.Reset(rest, ';')
+	for sc.Next() {
+		k := utils.ToLowerASCII(sc.Key())
+		if k == "q" {
+			q, ok := parseQ(sc.Value())
+			if !ok {
+				return qv, false
+			}
+			qv.Q = q
+			continue
+		}
+		if qv.Params == nil {
+			qv.Params = make(map[string]string)
+		}
+		qv.Params[k] = sc.Value()
+	}
+	return qv, sc.Err() == nil
+}
+
+// parseQ 按 qvalue = ( "0" [ "." 0*3DIGIT ] ) / ( "1" [ "." 0*3("0") ] ) 解析
+func parseQ(s string) (float64, bool) {
+	if s == "" || len(s) > 5 || s[0] != '0' && s[0] != '1' || len(s) > 1 && s[1] != '.' {
+		return 0, false
+	}
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil || q > 1 {
+		return 0, false
+	}
+	return q, true
+}
+
+// splitQuoted 按 sep 切分, 忽略引号内的分隔符, 丢弃空白项
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			if p := strings.TrimSpace(s[start:i]); p != "" {
+				parts = append(parts, p)
+			}
+			start = i + 1
+		}
+	}
+	if p := strings.TrimSpace(s[start:]); p != "" || len(parts) == 0 {
+		parts = append(parts, p)
+	}
+	return parts
+}
+
+// Quality 返回 value 在列表中的权重, 依次匹配完全相同、"type/*" 和 "*"; 未出现时返回 0, false
+func Quality(list []QualityValue, value string) (float64, bool) {
+	value = strings.ToLower(value)
+	best, found, specificity := 0.0, false, -1
+	for _, qv := range list {
+		s := matchSpecificity(qv.Value, value)
+		if s > specificity {
+			best, found, specificity = qv.Q, true, s
+		}
+	}
+	return best, found
+}
+
+// matchSpecificity 完全匹配为 2, "type/*" 为 1, "*" 或 "*/*" 为 0, 不匹配为 -1
+func matchSpecificity(pattern, value string) int {
+	switch {
+	case pattern == value:
+		return 2
+	case pattern == "*" || pattern == "*/*":
+		return 0
+	case strings.HasSuffix(pattern, "/*"):
+		if typ, _, ok := strings.Cut(value, "/"); ok && typ == pattern[:len(pattern)-2] {
+			return 1
+		}
+	}
+	return -1
+}
+
+// Negotiate 从 offers 中选出权重最高且大于 0 的一项, 同权重时按 offers 的顺序; header 为空时返回 offers[0].
+// 没有可接受的项时返回空串
+func Negotiate(header []string, offers ...string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	list := ParseQualityList(header...)
+	if len(list) == 0 {
+		return offers[0]
+	}
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q, ok := Quality(list, offer); ok && q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,46 @@
+package common
+
+/*
+	Retry-After 解析 (RFC 9110 10.2.3), 供客户端重试和 429 限流中间件使用
+*/
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// ParseRetryAfter 解析 delta-seconds 或 HTTP 日期形式的 Retry-After, 返回相对 clock 当前时间的等待时长;
+// 日期已过时返回 0. clock 为空时使用系统时钟, 格式错误时 ok 为 false
+func ParseRetryAfter(value string, clock utils.Clock) (d time.Duration, ok bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if '0' <= value[0] && value[0] <= '9' {
+		secs, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			if ne, _ := err.(*strconv.NumError); ne == nil || ne.Err != strconv.ErrRange {
+				return 0, false
+			}
+		}
+		// 超大值截断到 time.Duration 上限
+		if secs > uint64(1<<63-1)/uint64(time.Second) {
+			return 1<<63 - 1, true
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := utils.ParseHTTPTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(t.Sub(utils.ClockOr(clock).Now()), 0), true
+}
+
+// SetRetryAfter 设置 Retry-After 为 d 向上取整的秒数
+func SetRetryAfter(h Header, d time.Duration) {
+	secs := (max(d, 0) + time.Second - 1) / time.Second
+	h.Set("Retry-After", strconv.FormatInt(int64(secs), 10))
+}
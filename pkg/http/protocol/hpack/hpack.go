@@ -0,0 +1,469 @@
+package hpack
+
+/*
+	HPACK 头部压缩 (RFC 7541): 静态表、动态表、整数和字符串编码, 以及完整头部块的编码器与解码器.
+	不依赖帧层, 由 HTTP/2 的客户端和服务端共用
+*/
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultTableSize 动态表的初始大小, 与 HTTP/2 的 SETTINGS_HEADER_TABLE_SIZE 默认值相同
+const DefaultTableSize = 4096
+
+var (
+	// ErrCompression 头部块解码失败, 在 HTTP/2 中对应连接错误 COMPRESSION_ERROR
+	ErrCompression = errors.New("hpack: decoding error")
+	// ErrStringTooLong 字符串超过解码器的 MaxStringLength
+	ErrStringTooLong = errors.New("hpack: string too long")
+	// ErrHeaderListTooLarge 解码后的头部列表超过 MaxHeaderListSize
+	ErrHeaderListTooLarge = errors.New("hpack: header list too large")
+)
+
+// HeaderField 一个头部字段, 名称为小写
+type HeaderField struct {
+	Name, Value string
+	// Sensitive 编码为永不索引的字面量, 防止经中间节点压缩泄露 (如 Authorization)
+	Sensitive bool
+}
+
+// Size 字段在动态表中占用的大小 (RFC 7541 4.1)
+func (f HeaderField) Size() uint32 {
+	return uint32(len(f.Name) + len(f.Value) + 32)
+}
+
+// IsPseudo 是否为伪头部 (以 ':' 开头)
+func (f HeaderField) IsPseudo() bool {
+	return len(f.Name) > 0 && f.Name[0] == ':'
+}
+
+func (f HeaderField) String() string {
+	if f.Sensitive {
+		return f.Name + ": <sensitive>"
+	}
+	return f.Name + ": " + f.Value
+}
+
+// staticTable RFC 7541 附录 A, 下标从 1 开始
+var staticTable = [...]HeaderField{
+	{Name: ":authority"},
+	{Name: ":method", Value: "GET"},
+	{Name: ":method", Value: "POST"},
+	{Name: ":path", Value: "/"},
+	{Name: ":path", Value: "/index.html"},
+	{Name: ":scheme", Value: "http"},
+	{Name: ":scheme", Value: "https"},
+	{Name: ":status", Value: "200"},
+	{Name: ":status", Value: "204"},
+	{Name: ":status", Value: "206"},
+	{Name: ":status", Value: "304"},
+	{Name: ":status", Value: "400"},
+	{Name: ":status", Value: "404"},
+	{Name: ":status", Value: "500"},
+	{Name: "accept-charset"},
+	{Name: "accept-encoding", Value: "gzip, deflate"},
+	{Name: "accept-language"},
+	{Name: "accept-ranges"},
+	{Name: "accept"},
+	{Name: "access-control-allow-origin"},
+	{Name: "age"},
+	{Name: "allow"},
+	{Name: "authorization"},
+	{Name: "cache-control"},
+	{Name: "content-disposition"},
+	{Name: "content-encoding"},
+	{Name: "content-language"},
+	{Name: "content-length"},
+	{Name: "content-location"},
+	{Name: "content-range"},
+	{Name: "content-type"},
+	{Name: "cookie"},
+	{Name: "date"},
+	{Name: "etag"},
+	{Name: "expect"},
+	{Name: "expires"},
+	{Name: "from"},
+	{Name: "host"},
+	{Name: "if-match"},
+	{Name: "if-modified-since"},
+	{Name: "if-none-match"},
+	{Name: "if-range"},
+	{Name: "if-unmodified-since"},
+	{Name: "last-modified"},
+	{Name: "link"},
+	{Name: "location"},
+	{Name: "max-forwards"},
+	{Name: "proxy-authenticate"},
+	{Name: "proxy-authorization"},
+	{Name: "range"},
+	{Name: "referer"},
+	{Name: "refresh"},
+	{Name: "retry-after"},
+	{Name: "server"},
+	{Name: "set-cookie"},
+	{Name: "strict-transport-security"},
+	{Name: "transfer-encoding"},
+	{Name: "user-agent"},
+	{Name: "vary"},
+	{Name: "via"},
+	{Name: "www-authenticate"},
+}
+
+// staticPairs 和 staticNames 静态表的反向索引, 同名取最小下标
+var (
+	staticPairs = make(map[[2]string]uint64, len(staticTable))
+	staticNames = make(map[string]uint64, len(staticTable))
+)
+
+func init() {
+	for i, f := range staticTable {
+		idx := uint64(i + 1)
+		staticPairs[[2]string{f.Name, f.Value}] = idx
+		if _, ok := staticNames[f.Name]; !ok {
+			staticNames[f.Name] = idx
+		}
+	}
+}
+
+// dynamicTable 动态表, ents 按插入顺序保存 (最新的在末尾)
+type dynamicTable struct {
+	ents    []HeaderField
+	size    uint32
+	maxSize uint32
+}
+
+func (t *dynamicTable) setMaxSize(n uint32) {
+	t.maxSize = n
+	t.evict()
+}
+
+func (t *dynamicTable) add(f HeaderField) {
+	t.ents = append(t.ents, f)
+	t.size += f.Size()
+	t.evict()
+}
+
+func (t *dynamicTable) evict() {
+	n := 0
+	for t.size > t.maxSize && n < len(t.ents) {
+		t.size -= t.ents[n].Size()
+		n++
+	}
+	if n > 0 {
+		t.ents = append(t.ents[:0], t.ents[n:]...)
+	}
+}
+
+// at 返回动态表中第 i 项 (1 为最新)
+func (t *dynamicTable) at(i uint64) (HeaderField, bool) {
+	if i < 1 || i > uint64(len(t.ents)) {
+		return HeaderField{}, false
+	}
+	return t.ents[len(t.ents)-int(i)], true
+}
+
+// search 返回完全匹配或同名项的下标 (1 为最新), 未找到时为 0
+func (t *dynamicTable) search(f HeaderField) (idx uint64, exact bool) {
+	for i := len(t.ents) - 1; i >= 0; i-- {
+		e := t.ents[i]
+		if e.Name != f.Name {
+			continue
+		}
+		j := uint64(len(t.ents) - i)
+		if e.Value == f.Value {
+			return j, true
+		}
+		if idx == 0 {
+			idx = j
+		}
+	}
+	return idx, false
+}
+
+// appendVarInt 按 n 位前缀编码整数 (RFC 7541 5.1), first 为前缀所在字节的高位
+func appendVarInt(dst []byte, n uint8, first byte, v uint64) []byte {
+	max := uint64(1)<<n - 1
+	if v < max {
+		return append(dst, first|byte(v))
+	}
+	dst = append(dst, first|byte(max))
+	v -= max
+	for v >= 128 {
+		dst = append(dst, byte(v&0x7f|0x80))
+		v >>= 7
+	}
+	return append(dst, byte(v))
+}
+
+// readVarInt 解码 n 位前缀的整数, 返回值和剩余数据; 超过 32 位时视为错误
+func readVarInt(n uint8, p []byte) (uint64, []byte, error) {
+	if len(p) == 0 {
+		return 0, p, fmt.Errorf("%w: truncated integer", ErrCompression)
+	}
+	max := uint64(1)<<n - 1
+	v := uint64(p[0]) & max
+	p = p[1:]
+	if v < max {
+		return v, p, nil
+	}
+	for shift := uint(0); len(p) > 0; shift += 7 {
+		// 32 位以内的值最多 5 个续字节, 更长的编码 (包括只补零的) 一律拒绝, 否则高位会被静默丢弃
+		if shift > 28 {
+			return 0, p, fmt.Errorf("%w: integer overflow", ErrCompression)
+		}
+		b := p[0]
+		p = p[1:]
+		v += uint64(b&0x7f) << shift
+		if v > 1<<32 {
+			return 0, p, fmt.Errorf("%w: integer overflow", ErrCompression)
+		}
+		if b&0x80 == 0 {
+			return v, p, nil
+		}
+	}
+	return 0, p, fmt.Errorf("%w: truncated integer", ErrCompression)
+}
+
+// Encoder 头部块编码器, 非并发安全; 同一连接的头部块必须按写出顺序编码
+type Encoder struct {
+	dyn dynamicTable
+	// limit 对端通告的 SETTINGS_HEADER_TABLE_SIZE
+	limit uint32
+	// pending 需要在下一个头部块开头发出表大小更新; minSize 为期间出现过的最小值
+	pending bool
+	minSize uint32
+}
+
+// NewEncoder 创建编码器, 动态表大小为协议默认的 4096
+func NewEncoder() *Encoder {
+	return &Encoder{dyn: dynamicTable{maxSize: DefaultTableSize}, limit: DefaultTableSize}
+}
+
+// SetMaxDynamicTableSizeLimit 应用对端的 SETTINGS_HEADER_TABLE_SIZE, 当前表超过上限时缩小
+func (e *Encoder) SetMaxDynamicTableSizeLimit(n uint32) {
+	e.limit = n
+	if e.dyn.maxSize > n {
+		e.SetMaxDynamicTableSize(n)
+	}
+}
+
+// SetMaxDynamicTableSize 调整动态表大小 (不超过上限), 在下一个头部块开头通知对端
+func (e *Encoder) SetMaxDynamicTableSize(n uint32) {
+	n = min(n, e.limit)
+	if !e.pending || n < e.minSize {
+		e.minSize = n
+	}
+	e.pending = true
+	e.dyn.setMaxSize(n)
+}
+
+// AppendBlock 将 fields 编码为一个完整的头部块追加到 dst
+func (e *Encoder) AppendBlock(dst []byte, fields ...HeaderField) []byte {
+	if e.pending {
+		if e.minSize < e.dyn.maxSize {
+			dst = appendVarInt(dst, 5, 0x20, uint64(e.minSize))
+		}
+		dst = appendVarInt(dst, 5, 0x20, uint64(e.dyn.maxSize))
+		e.pending = false
+	}
+	for _, f := range fields {
+		dst = e.appendField(dst, f)
+	}
+	return dst
+}
+
+func (e *Encoder) appendField(dst []byte, f HeaderField) []byte {
+	if !f.Sensitive {
+		if idx, ok := staticPairs[[2]string{f.Name, f.Value}]; ok {
+			return appendVarInt(dst, 7, 0x80, idx)
+		}
+	}
+	nameIdx := staticNames[f.Name]
+	if dynIdx, exact := e.dyn.search(f); dynIdx > 0 {
+		if exact && !f.Sensitive {
+			return appendVarInt(dst, 7, 0x80, dynIdx+uint64(len(staticTable)))
+		}
+		if nameIdx == 0 {
+			nameIdx = dynIdx + uint64(len(staticTable))
+		}
+	}
+
+	switch {
+	case f.Sensitive:
+		dst = appendVarInt(dst, 4, 0x10, nameIdx)
+	case f.Size() <= e.dyn.maxSize:
+		dst = appendVarInt(dst, 6, 0x40, nameIdx)
+		defer e.dyn.add(f)
+	default:
+		dst = appendVarInt(dst, 4, 0x00, nameIdx)
+	}
+	if nameIdx == 0 {
+		dst = appendString(dst, f.Name)
+	}
+	return appendString(dst, f.Value)
+}
+
+// appendString 编码字符串字面量, Huffman 编码更短时使用 Huffman
+func appendString(dst []byte, s string) []byte {
+	if n := HuffmanEncodedLen(s); n < len(s) {
+		dst = appendVarInt(dst, 7, 0x80, uint64(n))
+		return AppendHuffmanString(dst, s)
+	}
+	dst = appendVarInt(dst, 7, 0, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// Decoder 头部块解码器, 非并发安全; 同一连接的头部块必须按收到的顺序解码
+type Decoder struct {
+	// MaxStringLength 单个名称或值的最大长度, 0 表示不限制
+	MaxStringLength int
+	// MaxHeaderListSize 头部列表的最大大小 (按 HeaderField.Size 累计), 0 表示不限制
+	MaxHeaderListSize uint32
+
+	dyn dynamicTable
+	// limit 本端通告的 SETTINGS_HEADER_TABLE_SIZE, 对端的表大小更新不能超过它
+	limit uint32
+	buf   []byte
+}
+
+// NewDecoder 创建动态表上限为 maxTableSize 的解码器
+func NewDecoder(maxTableSize uint32) *Decoder {
+	return &Decoder{dyn: dynamicTable{maxSize: maxTableSize}, limit: maxTableSize}
+}
+
+// SetMaxDynamicTableSizeLimit 更新本端通告的表大小上限, 应在对端确认 SETTINGS 后调用
+func (d *Decoder) SetMaxDynamicTableSizeLimit(n uint32) {
+	d.limit = n
+	if d.dyn.maxSize > n {
+		d.dyn.setMaxSize(n)
+	}
+}
+
+// Decode 解码一个完整的头部块. 超过 MaxHeaderListSize 时仍解码完整个块以保持动态表同步,
+// 然后返回 ErrHeaderListTooLarge; 其余错误都包装 ErrCompression, 连接不能继续使用
+func (d *Decoder) Decode(block []byte) ([]HeaderField, error) {
+	var fields []HeaderField
+	var total uint32
+	tooLarge := false
+	emit := func(f HeaderField) {
+		if tooLarge {
+			return
+		}
+		total += f.Size()
+		if d.MaxHeaderListSize > 0 && total > d.MaxHeaderListSize {
+			tooLarge, fields = true, nil
+			return
+		}
+		fields = append(fields, f)
+	}
+	sawField := false
+	for p := block; len(p) > 0; {
+		b := p[0]
+		var err error
+		switch {
+		case b&0x80 != 0:
+			var idx uint64
+			if idx, p, err = readVarInt(7, p); err != nil {
+				return nil, err
+			}
+			f, ok := d.at(idx)
+			if !ok {
+				return nil, fmt.Errorf("%w: invalid index %d", ErrCompression, idx)
+			}
+			emit(f)
+		case b&0xe0 == 0x20:
+			if sawField {
+				return nil, fmt.Errorf("%w: table size update after header field", ErrCompression)
+			}
+			var size uint64
+			if size, p, err = readVarInt(5, p); err != nil {
+				return nil, err
+			}
+			if size > uint64(d.limit) {
+				return nil, fmt.Errorf("%w: table size %d exceeds limit %d", ErrCompression, size, d.limit)
+			}
+			d.dyn.setMaxSize(uint32(size))
+			continue
+		default:
+			// 0x40 加入索引, 0x10 永不索引, 0x00 不索引
+			indexing, prefix := b&0xc0 == 0x40, uint8(4)
+			if indexing {
+				prefix = 6
+			}
+			var f HeaderField
+			if f, p, err = d.readLiteral(prefix, p); err != nil {
+				return nil, err
+			}
+			f.Sensitive = b&0xf0 == 0x10
+			if indexing {
+				d.dyn.add(f)
+			}
+			emit(f)
+		}
+		sawField = true
+	}
+	if tooLarge {
+		return nil, ErrHeaderListTooLarge
+	}
+	return fields, nil
+}
+
+func (d *Decoder) at(idx uint64) (HeaderField, bool) {
+	if idx >= 1 && idx <= uint64(len(staticTable)) {
+		return staticTable[idx-1], true
+	}
+	f, ok := d.dyn.at(idx - uint64(len(staticTable)))
+	f.Sensitive = false
+	return f, ok && idx > 0
+}
+
+func (d *Decoder) readLiteral(prefix uint8, p []byte) (HeaderField, []byte, error) {
+	var f HeaderField
+	idx, p, err := readVarInt(prefix, p)
+	if err != nil {
+		return f, p, err
+	}
+	if idx > 0 {
+		nf, ok := d.at(idx)
+		if !ok {
+			return f, p, fmt.Errorf("%w: invalid name index %d", ErrCompression, idx)
+		}
+		f.Name = nf.Name
+	} else if f.Name, p, err = d.readString(p); err != nil {
+		return f, p, err
+	}
+	f.Value, p, err = d.readString(p)
+	return f, p, err
+}
+
+func (d *Decoder) readString(p []byte) (string, []byte, error) {
+	if len(p) == 0 {
+		return "", p, fmt.Errorf("%w: truncated string", ErrCompression)
+	}
+	huffman := p[0]&0x80 != 0
+	n, p, err := readVarInt(7, p)
+	if err != nil {
+		return "", p, err
+	}
+	if n > uint64(len(p)) {
+		return "", p, fmt.Errorf("%w: truncated string", ErrCompression)
+	}
+	raw := p[:n]
+	p = p[n:]
+	if !huffman {
+		if d.MaxStringLength > 0 && len(raw) > d.MaxStringLength {
+			return "", p, ErrStringTooLong
+		}
+		return string(raw), p, nil
+	}
+	d.buf, err = AppendHuffmanDecode(d.buf[:0], raw, d.MaxStringLength)
+	if err != nil {
+		if errors.Is(err, ErrStringTooLong) {
+			return "", p, err
+		}
+		return "", p, fmt.Errorf("%w: %v", ErrCompression, err)
+	}
+	return string(d.buf), p, nil
+}
@@ -0,0 +1,74 @@
+package hpack
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestReadVarIntRejectsOverlong(t *testing.T) {
+	// 前缀取满后跟 10 个只有续位的字节: 位移超过 64 后的值不能被静默丢弃
+	p := []byte{0x1f}
+	for range 10 {
+		p = append(p, 0x80)
+	}
+	p = append(p, 0x01)
+	if _, _, err := readVarInt(5, p); !errors.Is(err, ErrCompression) {
+		t.Fatalf("readVarInt(overlong) err = %v, want ErrCompression", err)
+	}
+
+	v, rest, err := readVarInt(5, []byte{0x1f, 0x9a, 0x0a, 0xff})
+	if err != nil || v != 1337 || len(rest) != 1 {
+		t.Fatalf("readVarInt(1337) = %d, %d bytes left, %v", v, len(rest), err)
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	enc := NewEncoder()
+	f.Add(enc.AppendBlock(nil,
+		HeaderField{Name: ":method", Value: "GET"},
+		HeaderField{Name: "custom-key", Value: "custom-value"},
+		HeaderField{Name: "authorization", Value: "secret", Sensitive: true},
+	))
+	f.Add([]byte{0x3f, 0xe1, 0x1f})
+	f.Add([]byte{0x1f, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01})
+	f.Add([]byte{0x40, 0x85, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00})
+	f.Fuzz(func(t *testing.T, block []byte) {
+		d := NewDecoder(DefaultTableSize)
+		d.MaxStringLength = 1 << 16
+		d.MaxHeaderListSize = 1 << 16
+		// 错误是允许的, 只要不 panic; 同一解码器上再解码一次以覆盖动态表残留状态
+		d.Decode(block)
+		d.Decode(block)
+	})
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add("custom-key", "custom-value", uint16(4096), false)
+	f.Add("cookie", "a=b; c=d", uint16(0), false)
+	f.Add("authorization", "Bearer xyz", uint16(64), true)
+	f.Add(":path", "/index.html", uint16(1<<15), false)
+	f.Fuzz(func(t *testing.T, name, value string, size uint16, sensitive bool) {
+		fields := []HeaderField{
+			{Name: ":method", Value: "GET"},
+			{Name: name, Value: value, Sensitive: sensitive},
+			{Name: "accept-encoding", Value: value},
+		}
+		enc := NewEncoder()
+		dec := NewDecoder(DefaultTableSize)
+		// 第一个块填充动态表, 随后缩小或放大表 (不超过上限) 再编码, 覆盖表大小更新和索引命中
+		for i, tableSize := range []uint32{DefaultTableSize, uint32(size), DefaultTableSize} {
+			if i > 0 {
+				enc.SetMaxDynamicTableSize(tableSize)
+			}
+			block := enc.AppendBlock(nil, fields...)
+			got, err := dec.Decode(block)
+			if err != nil {
+				t.Fatalf("block %d: Decode: %v", i, err)
+			}
+			if !slices.Equal(got, fields) {
+				t.Fatalf("block %d: got %v, want %v", i, got, fields)
+			}
+		}
+	})
+}
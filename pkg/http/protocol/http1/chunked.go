@@ -1 +1,153 @@
 package http1
+
+/*
+	分块传输编码 (RFC 9112 7.1)
+*/
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// ErrMalformedChunk 分块编码格式错误
+var ErrMalformedChunk = errors.New("http1: malformed chunked encoding")
+
+// ChunkedReader 解码分块编码的消息体, 读到最后一个分块后解析 trailer
+type ChunkedReader struct {
+	br      *bufio.Reader
+	remain  uint64
+	started bool
+	done    bool
+	err     error
+	// Trailer 最后一个分块之后的 trailer 字段, 读到 EOF 后可用
+	Trailer common.Header
+}
+
+// NewChunkedReader 创建分块解码器
+func NewChunkedReader(br *bufio.Reader) *ChunkedReader {
+	return &ChunkedReader{br: br}
+}
+
+func (cr *ChunkedReader) Read(p []byte) (int, error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+	if cr.done {
+		return 0, io.EOF
+	}
+	if cr.remain == 0 {
+		if cr.started {
+			if err := cr.readCRLF(); err != nil {
+				cr.err = err
+				return 0, err
+			}
+		}
+		cr.started = true
+		size, err := cr.readSize()
+		if err != nil {
+			cr.err = err
+			return 0, err
+		}
+		if size == 0 {
+			trailer, err := ReadHeader(cr.br, DefaultMaxHeaderBytes)
+			if err != nil {
+				cr.err = err
+				return 0, err
+			}
+			if len(trailer) > 0 {
+				cr.Trailer = trailer
+			}
+			cr.done = true
+			return 0, io.EOF
+		}
+		cr.remain = size
+	}
+	if uint64(len(p)) > cr.remain {
+		p = p[:cr.remain]
+	}
+	n, err := cr.br.Read(p)
+	cr.remain -= uint64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		cr.err = err
+	}
+	return n, err
+}
+
+func (cr *ChunkedReader) readSize() (uint64, error) {
+	line, err := readLine(cr.br, 4096)
+	if err != nil {
+		// 在分块边界处断开同样是截断, 不能当作消息体正常结束
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	size, err := utils.ParseChunkSize(line)
+	if err != nil {
+		return 0, ErrMalformedChunk
+	}
+	return size, nil
+}
+
+func (cr *ChunkedReader) readCRLF() error {
+	line, err := readLine(cr.br, 2)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if len(line) != 0 {
+		return ErrMalformedChunk
+	}
+	return nil
+}
+
+// ChunkedWriter 以分块编码写出消息体, Close 时写出最后一个分块和 trailer
+type ChunkedWriter struct {
+	w io.Writer
+	// Trailer 在 Close 时写出的 trailer 字段
+	Trailer common.Header
+}
+
+// NewChunkedWriter 创建分块编码器
+func NewChunkedWriter(w io.Writer) *ChunkedWriter {
+	return &ChunkedWriter{w: w}
+}
+
+func (cw *ChunkedWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	var hdr [utils.MaxChunkSizeLen + 2]byte
+	if _, err := cw.w.Write(append(utils.AppendChunkSize(hdr[:0], uint64(len(p))), "\r\n"...)); err != nil {
+		return 0, err
+	}
+	n, err := cw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if _, err := io.WriteString(cw.w, "\r\n"); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// Close 写出最后一个分块, 不关闭底层 writer
+func (cw *ChunkedWriter) Close() error {
+	if _, err := io.WriteString(cw.w, "0\r\n"); err != nil {
+		return err
+	}
+	if err := cw.Trailer.Write(cw.w); err != nil {
+		return err
+	}
+	_, err := io.WriteString(cw.w, "\r\n")
+	return err
+}
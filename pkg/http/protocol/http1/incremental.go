@@ -0,0 +1,153 @@
+package http1
+
+/*
+	增量请求解析: 数据按到达顺序分段送入, 凑齐一个完整的请求 (头部和消息体) 后才交给调用方,
+	供事件循环这类不能阻塞在读取上的连接模型使用. 校验规则与 ReadRequest 相同
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+)
+
+// DefaultMaxBodyBytes RequestParser 默认缓冲的最大请求体字节数
+const DefaultMaxBodyBytes = 4 << 20
+
+// ErrBodyTooLarge 请求体超过 RequestParser 的缓冲上限
+var ErrBodyTooLarge = errors.New("http1: request body too large")
+
+// RequestParser 增量请求解析器, 零值可用, 不能并发使用.
+// 只在头部结束或消息体收齐时解析, 未完成的请求不会重复扫描已检查过的头部字节
+type RequestParser struct {
+	// MaxHeaderBytes 请求行和头部的最大字节数, 0 时使用 DefaultMaxHeaderBytes
+	MaxHeaderBytes int
+	// MaxBodyBytes 请求体的最大字节数, 0 时使用 DefaultMaxBodyBytes
+	MaxBodyBytes int64
+
+	buf []byte
+	// scanned 已确认不含头部结束标记的前缀长度
+	scanned int
+	// need 头部已完整且消息体长度已知时, 凑齐请求所需的总字节数
+	need int
+	err  error
+}
+
+// Feed 追加收到的数据, 之后调用 Next 取出已完整的请求
+func (p *RequestParser) Feed(data []byte) {
+	p.buf = append(p.buf, data...)
+}
+
+// Buffered 返回已缓冲但尚未组成请求的字节数
+func (p *RequestParser) Buffered() int { return len(p.buf) }
+
+// Next 返回下一个完整的请求, 数据不足时返回 nil, nil. 请求体已全部读入内存.
+// 出错后解析器不可再用, 之后的调用返回同一错误
+func (p *RequestParser) Next() (*message.Request, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	req, err := p.next()
+	if err != nil {
+		p.err = err
+		p.buf = nil
+	}
+	return req, err
+}
+
+func (p *RequestParser) next() (*message.Request, error) {
+	if p.need > 0 && len(p.buf) < p.need {
+		return nil, nil
+	}
+	if p.need == 0 {
+		end := headerEnd(p.buf, p.scanned)
+		if end < 0 {
+			if len(p.buf) > p.maxHeaderBytes() {
+				return nil, ErrHeaderTooLarge
+			}
+			// 标记最长三个字节, 保留末尾以便与下一段数据拼接
+			p.scanned = max(0, len(p.buf)-3)
+			return nil, nil
+		}
+		if end > p.maxHeaderBytes() {
+			return nil, ErrHeaderTooLarge
+		}
+	}
+
+	r := bytes.NewReader(p.buf)
+	br := bufio.NewReaderSize(r, len(p.buf)+16)
+	req, err := ReadRequest(br)
+	if err != nil {
+		// 头部已完整, 此时的错误都是格式错误
+		return nil, err
+	}
+	consumed := func() int { return len(p.buf) - r.Len() - br.Buffered() }
+	if req.ContentLength > 0 {
+		if req.ContentLength > p.maxBodyBytes() {
+			return nil, ErrBodyTooLarge
+		}
+		if need := consumed() + int(req.ContentLength); len(p.buf) < need {
+			p.need = need
+			return nil, nil
+		}
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, p.maxBodyBytes()+1))
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		// 分块消息体尚未收齐
+		if int64(len(p.buf)) > int64(p.maxHeaderBytes())+p.maxBodyBytes() {
+			return nil, ErrBodyTooLarge
+		}
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case int64(len(body)) > p.maxBodyBytes():
+		return nil, ErrBodyTooLarge
+	}
+	if cb, ok := req.Body.(*chunkedBody); ok && len(cb.Trailer()) > 0 {
+		req.Trailer = cb.Trailer()
+	}
+	req.Body = message.NoBody
+	if len(body) > 0 {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+
+	n := consumed()
+	p.buf = append(p.buf[:0], p.buf[n:]...)
+	p.scanned, p.need = 0, 0
+	return req, nil
+}
+
+func (p *RequestParser) maxHeaderBytes() int {
+	if p.MaxHeaderBytes > 0 {
+		return p.MaxHeaderBytes
+	}
+	return DefaultMaxHeaderBytes
+}
+
+func (p *RequestParser) maxBodyBytes() int64 {
+	if p.MaxBodyBytes > 0 {
+		return p.MaxBodyBytes
+	}
+	return DefaultMaxBodyBytes
+}
+
+// headerEnd 从 from 开始查找空行, 返回头部区域 (含空行) 的长度, 没有找到时返回 -1; 行尾可以是 CRLF 或 LF
+func headerEnd(b []byte, from int) int {
+	for i := from; i < len(b); i++ {
+		if b[i] != '\n' {
+			continue
+		}
+		switch {
+		case i+1 < len(b) && b[i+1] == '\n':
+			return i + 2
+		case i+2 < len(b) && b[i+1] == '\r' && b[i+2] == '\n':
+			return i + 3
+		}
+	}
+	return -1
+}
@@ -0,0 +1,94 @@
+package http1
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// feedAll 逐字节送入 data, 收集解析出的请求
+func feedAll(t *testing.T, p *RequestParser, data string) []string {
+	t.Helper()
+	var got []string
+	for i := 0; i < len(data); i++ {
+		p.Feed([]byte{data[i]})
+		for {
+			req, err := p.Next()
+			if err != nil {
+				t.Fatalf("Next after %d bytes: %v", i+1, err)
+			}
+			if req == nil {
+				break
+			}
+			body, _ := io.ReadAll(req.Body)
+			got = append(got, req.Method+" "+req.URL.Path+" "+string(body)+" "+req.Trailer.Get("X-Sum"))
+		}
+	}
+	return got
+}
+
+func TestRequestParserPipelinedByteByByte(t *testing.T) {
+	data := "GET /a HTTP/1.1\r\nHost: x\r\n\r\n" +
+		"POST /b HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello" +
+		"POST /c HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n2\r\nde\r\n0\r\nX-Sum: 5\r\n\r\n" +
+		"GET /d HTTP/1.1\nHost: x\n\n"
+	var p RequestParser
+	got := feedAll(t, &p, data)
+	want := []string{"GET /a  ", "POST /b hello ", "POST /c abcde 5", "GET /d  "}
+	if len(got) != len(want) {
+		t.Fatalf("requests = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("request %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+	if p.Buffered() != 0 {
+		t.Fatalf("Buffered = %d after the last request", p.Buffered())
+	}
+}
+
+func TestRequestParserLimits(t *testing.T) {
+	p := RequestParser{MaxHeaderBytes: 64}
+	p.Feed([]byte("GET / HTTP/1.1\r\nX-Long: " + string(make([]byte, 100))))
+	if _, err := p.Next(); !errors.Is(err, ErrHeaderTooLarge) {
+		t.Fatalf("unterminated long header: err = %v, want ErrHeaderTooLarge", err)
+	}
+
+	p = RequestParser{MaxBodyBytes: 4}
+	p.Feed([]byte("POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\n"))
+	if _, err := p.Next(); !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("Content-Length over limit: err = %v, want ErrBodyTooLarge", err)
+	}
+
+	p = RequestParser{MaxBodyBytes: 4}
+	p.Feed([]byte("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n"))
+	if _, err := p.Next(); !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("chunked body over limit: err = %v, want ErrBodyTooLarge", err)
+	}
+}
+
+func TestRequestParserErrorIsSticky(t *testing.T) {
+	var p RequestParser
+	p.Feed([]byte("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n"))
+	_, err := p.Next()
+	if !errors.Is(err, ErrMalformedRequest) {
+		t.Fatalf("TE with CL: err = %v, want ErrMalformedRequest", err)
+	}
+	p.Feed([]byte("GET / HTTP/1.1\r\n\r\n"))
+	if _, err2 := p.Next(); err2 != err {
+		t.Fatalf("Next after error = %v, want %v", err2, err)
+	}
+}
+
+func TestReadRequestChunkedTruncatedAtBoundary(t *testing.T) {
+	req, err := ReadRequest(bufio.NewReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(req.Body); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("ReadAll = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
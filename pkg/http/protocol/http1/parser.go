@@ -1 +1,291 @@
 package http1
+
+/*
+	HTTP/1.1 请求/响应解析
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// DefaultMaxHeaderBytes 头部区域默认的最大字节数
+const DefaultMaxHeaderBytes = 1 << 20
+
+var (
+	ErrHeaderTooLarge   = errors.New("http1: header too large")
+	ErrMalformedHeader  = errors.New("http1: malformed header")
+	ErrMalformedStatus  = errors.New("http1: malformed status line")
+	ErrMalformedRequest = errors.New("http1: malformed request line")
+	ErrBadContentLength = errors.New("http1: bad Content-Length")
+)
+
+// ReadResponse 从 br 读取一个响应, req 用于判断 HEAD 等无消息体的情况
+func ReadResponse(br *bufio.Reader, req *message.Request) (*message.Response, error) {
+	line, err := readLine(br, DefaultMaxHeaderBytes)
+	if err != nil {
+		return nil, err
+	}
+	proto, rest, ok := strings.Cut(string(line), " ")
+	if !ok || !strings.HasPrefix(proto, "HTTP/") {
+		return nil, fmt.Errorf("%w: %q", ErrMalformedStatus, line)
+	}
+	codeStr, _, _ := strings.Cut(rest, " ")
+	code, err := strconv.Atoi(codeStr)
+	if err != nil || len(codeStr) != 3 {
+		return nil, fmt.Errorf("%w: %q", ErrMalformedStatus, line)
+	}
+
+	header, err := ReadHeader(br, DefaultMaxHeaderBytes)
+	if err != nil {
+		return nil, err
+	}
+	resp := &message.Response{
+		StatusCode: code,
+		Status:     strings.TrimSpace(rest),
+		Proto:      proto,
+		Header:     header,
+		Request:    req,
+	}
+	resp.Close = shouldClose(proto, header)
+
+	noBody := !common.BodyAllowedForStatus(code) || (req != nil && req.Method == common.MethodHead)
+	if req != nil && req.Method == common.MethodConnect && code/100 == 2 {
+		// CONNECT 成功后连接变为隧道, 没有消息体
+		noBody = true
+	}
+	if noBody {
+		resp.Body = message.NoBody
+		resp.ContentLength = 0
+		if req != nil && req.Method == common.MethodHead {
+			resp.ContentLength, _ = contentLength(header)
+		}
+		return resp, nil
+	}
+	body, n, err := bodyReader(br, header, true)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 && !isChunked(header) {
+		resp.Close = true
+	}
+	resp.Body = body
+	resp.ContentLength = n
+	return resp, nil
+}
+
+// ReadRequest 从 br 读取一个请求
+func ReadRequest(br *bufio.Reader) (*message.Request, error) {
+	line, err := readLine(br, DefaultMaxHeaderBytes)
+	if err != nil {
+		return nil, err
+	}
+	method, rest, ok1 := strings.Cut(string(line), " ")
+	target, proto, ok2 := strings.Cut(rest, " ")
+	if !ok1 || !ok2 || !common.ValidMethod(method) || !strings.HasPrefix(proto, "HTTP/") {
+		return nil, fmt.Errorf("%w: %q", ErrMalformedRequest, line)
+	}
+	var u *url.URL
+	if method == common.MethodConnect && !strings.HasPrefix(target, "/") {
+		u = &url.URL{Host: target}
+	} else if u, err = url.ParseRequestURI(target); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedRequest, err)
+	}
+
+	header, err := ReadHeader(br, DefaultMaxHeaderBytes)
+	if err != nil {
+		return nil, err
+	}
+	req, err := message.NewRequest(method, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.URL = u
+	req.Proto = proto
+	req.Header = header
+	req.Host = u.Host
+	if req.Host == "" {
+		req.Host = header.Get("Host")
+	}
+	req.Close = shouldClose(proto, header)
+
+	body, n, err := bodyReader(br, header, false)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = body
+	req.ContentLength = n
+	return req, nil
+}
+
+// ReadHeader 读取头部区域直到空行
+func ReadHeader(br *bufio.Reader, maxBytes int) (common.Header, error) {
+	h := make(common.Header)
+	total := 0
+	for {
+		line, err := readLine(br, maxBytes-total)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		total += len(line) + 2
+		if len(line) == 0 {
+			return h, nil
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			// obs-fold 已被 RFC 9112 废弃
+			return nil, ErrMalformedHeader
+		}
+		i := bytes.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("%w: %q", ErrMalformedHeader, line)
+		}
+		name := line[:i]
+		for _, c := range name {
+			if !common.IsTokenChar(c) {
+				return nil, fmt.Errorf("%w: %q", ErrMalformedHeader, line)
+			}
+		}
+		key := common.CanonicalHeaderKeyBytes(name)
+		h[key] = append(h[key], strings.TrimSpace(string(line[i+1:])))
+	}
+}
+
+// readLine 读取一行并去掉行尾的 CRLF 或 LF, 超过 max 字节时返回 ErrHeaderTooLarge
+func readLine(br *bufio.Reader, max int) ([]byte, error) {
+	if max <= 0 {
+		return nil, ErrHeaderTooLarge
+	}
+	line, err := utils.ReadLine(br, max, false)
+	if err == utils.ErrLineTooLong {
+		return nil, ErrHeaderTooLarge
+	}
+	return line, err
+}
+
+func isChunked(h common.Header) bool {
+	te := h.Values("Transfer-Encoding")
+	if len(te) == 0 {
+		return false
+	}
+	last := te[len(te)-1]
+	if i := strings.LastIndexByte(last, ','); i >= 0 {
+		last = last[i+1:]
+	}
+	return strings.EqualFold(strings.TrimSpace(last), "chunked")
+}
+
+func contentLength(h common.Header) (int64, error) {
+	values := h.Values("Content-Length")
+	if len(values) == 0 {
+		return -1, nil
+	}
+	first := strings.TrimSpace(values[0])
+	for _, v := range values[1:] {
+		if strings.TrimSpace(v) != first {
+			return 0, ErrBadContentLength
+		}
+	}
+	n, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || n < 0 {
+		return 0, ErrBadContentLength
+	}
+	return n, nil
+}
+
+// bodyReader 按 RFC 9112 6.3 确定消息体长度; 响应可以读到连接关闭, 请求没有长度时为空
+func bodyReader(br *bufio.Reader, h common.Header, response bool) (io.ReadCloser, int64, error) {
+	if h.Has("Transfer-Encoding") {
+		if !isChunked(h) {
+			if response {
+				return io.NopCloser(br), -1, nil
+			}
+			return nil, 0, fmt.Errorf("%w: unsupported transfer coding", ErrMalformedRequest)
+		}
+		// 请求同时带有两者是请求走私的常见手法, 直接拒绝 (RFC 9112 6.1); 响应中忽略 Content-Length
+		if !response && h.Has("Content-Length") {
+			return nil, 0, fmt.Errorf("%w: both Transfer-Encoding and Content-Length", ErrMalformedRequest)
+		}
+		h.Del("Content-Length")
+		cr := NewChunkedReader(br)
+		return &chunkedBody{cr: cr, header: h}, -1, nil
+	}
+	n, err := contentLength(h)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch {
+	case n == 0:
+		return message.NoBody, 0, nil
+	case n > 0:
+		return &lengthBody{r: br, n: n}, n, nil
+	case response:
+		return io.NopCloser(br), -1, nil
+	}
+	return message.NoBody, 0, nil
+}
+
+// lengthBody 读取 Content-Length 个字节; 对端在此之前关闭连接时返回 io.ErrUnexpectedEOF,
+// 以免截断的消息体被当作完整读完
+type lengthBody struct {
+	r io.Reader
+	n int64
+}
+
+func (b *lengthBody) Read(p []byte) (int, error) {
+	if b.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.n {
+		p = p[:b.n]
+	}
+	n, err := b.r.Read(p)
+	b.n -= int64(n)
+	if err == io.EOF && b.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && b.n == 0 {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (b *lengthBody) Close() error { return nil }
+
+type chunkedBody struct {
+	cr     *ChunkedReader
+	header common.Header
+}
+
+func (b *chunkedBody) Read(p []byte) (int, error) { return b.cr.Read(p) }
+func (b *chunkedBody) Close() error               { return nil }
+
+// Trailer 返回分块消息体读完后的 trailer
+func (b *chunkedBody) Trailer() common.Header { return b.cr.Trailer }
+
+func shouldClose(proto string, h common.Header) bool {
+	for _, v := range h.Values("Connection") {
+		for _, tok := range strings.Split(v, ",") {
+			tok = strings.TrimSpace(tok)
+			if strings.EqualFold(tok, "close") {
+				return true
+			}
+			if proto == "HTTP/1.0" && strings.EqualFold(tok, "keep-alive") {
+				return false
+			}
+		}
+	}
+	return proto == "HTTP/1.0"
+}
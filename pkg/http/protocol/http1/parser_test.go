@@ -0,0 +1,61 @@
+package http1
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+)
+
+func readResponse(t *testing.T, raw string) *message.Response {
+	t.Helper()
+	req, _ := message.NewRequest("GET", "http://example.com/", nil)
+	resp, err := ReadResponse(bufio.NewReader(strings.NewReader(raw)), req)
+	if err != nil {
+		t.Fatalf("ReadResponse: %v", err)
+	}
+	return resp
+}
+
+func TestReadResponseLengthBody(t *testing.T) {
+	resp := readResponse(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhelloEXTRA")
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || string(body) != "hello" {
+		t.Fatalf("body = %q, %v; want %q", body, err, "hello")
+	}
+}
+
+func TestReadResponseTruncatedLengthBody(t *testing.T) {
+	// 对端在 Content-Length 之前关闭连接, 不能被当作完整读完
+	resp := readResponse(t, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello")
+	body, err := io.ReadAll(resp.Body)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("ReadAll err = %v, want io.ErrUnexpectedEOF", err)
+	}
+	if string(body) != "hello" {
+		t.Fatalf("body = %q, want %q", body, "hello")
+	}
+}
+
+func TestReadRequestRejectsTransferEncodingWithContentLength(t *testing.T) {
+	// 前端按 Content-Length 分帧、后端按 chunked 分帧时, "G..." 会被当作下一个请求 (CL.TE 走私)
+	raw := "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nGET /admin HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	_, err := ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+	if !errors.Is(err, ErrMalformedRequest) {
+		t.Fatalf("ReadRequest err = %v, want ErrMalformedRequest", err)
+	}
+}
+
+func TestReadResponseChunkedIgnoresContentLength(t *testing.T) {
+	resp := readResponse(t, "HTTP/1.1 200 OK\r\nContent-Length: 100\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n")
+	if resp.Header.Has("Content-Length") {
+		t.Fatal("Content-Length kept alongside chunked coding")
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || string(body) != "hello" {
+		t.Fatalf("body = %q, %v; want %q", body, err, "hello")
+	}
+}
@@ -1 +1,180 @@
 package http1
+
+/*
+	HTTP/1.1 请求/响应写入
+*/
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+// ErrBodyLengthMismatch 消息体实际长度与 Content-Length 不符
+var ErrBodyLengthMismatch = errors.New("http1: body length does not match Content-Length")
+
+// WriteRequest 以 HTTP/1.1 格式写出请求并 Flush, 不关闭请求体
+func WriteRequest(w *bufio.Writer, req *message.Request) error {
+	return writeRequest(w, req, req.RequestURI())
+}
+
+// WriteProxyRequest 以 absolute-form 写出发往 HTTP 代理的请求
+func WriteProxyRequest(w *bufio.Writer, req *message.Request) error {
+	u := *req.URL
+	u.User = nil
+	u.Fragment = ""
+	return writeRequest(w, req, u.String())
+}
+
+func writeRequest(w *bufio.Writer, req *message.Request, target string) error {
+	if req.Method == common.MethodConnect {
+		target = req.HostHeader()
+	}
+	if _, err := w.WriteString(req.Method + " " + target + " HTTP/1.1\r\n"); err != nil {
+		return err
+	}
+	if _, err := w.WriteString("Host: " + req.HostHeader() + "\r\n"); err != nil {
+		return err
+	}
+
+	header := req.Header
+	hasBody := req.Body != nil && req.Body != message.NoBody
+	chunked := hasBody && req.ContentLength < 0
+	if header == nil {
+		header = make(common.Header)
+	}
+	extra := make(common.Header)
+	switch {
+	case chunked:
+		extra.Set("Transfer-Encoding", "chunked")
+		if len(req.Trailer) > 0 {
+			keys := ""
+			for k := range req.Trailer {
+				if keys != "" {
+					keys += ", "
+				}
+				keys += k
+			}
+			extra.Set("Trailer", keys)
+		}
+	case hasBody || req.ContentLength > 0 || methodExpectsBody(req.Method):
+		extra.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	}
+	if req.Close {
+		extra.Set("Connection", "close")
+	}
+
+	for k := range extra {
+		if header.Has(k) {
+			delete(extra, k)
+		}
+	}
+	if err := header.WriteSubset(w, reqWriteExcludeHeader); err != nil {
+		return err
+	}
+	if err := extra.Write(w); err != nil {
+		return err
+	}
+	if _, err := w.WriteString("\r\n"); err != nil {
+		return err
+	}
+
+	if hasBody {
+		if err := writeBody(w, req.Body, req.ContentLength, req.Trailer); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// WriteResponse 以 HTTP/1.1 格式写出响应并 Flush, 不关闭响应体.
+// ContentLength < 0 且有消息体时使用分块编码; 状态码不允许消息体或请求为 HEAD 时不写出消息体
+func WriteResponse(w *bufio.Writer, resp *message.Response) error {
+	proto := resp.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	status := resp.Status
+	if status == "" {
+		status = message.StatusLine(resp.StatusCode)
+	}
+	if _, err := w.WriteString(proto + " " + status + "\r\n"); err != nil {
+		return err
+	}
+
+	header := resp.Header
+	if header == nil {
+		header = make(common.Header)
+	}
+	bodyAllowed := common.BodyAllowedForStatus(resp.StatusCode)
+	hasBody := bodyAllowed && resp.Body != nil && resp.Body != message.NoBody
+	chunked := hasBody && resp.ContentLength < 0 && proto != "HTTP/1.0"
+	extra := make(common.Header)
+	switch {
+	case chunked:
+		extra.Set("Transfer-Encoding", "chunked")
+	case bodyAllowed && resp.ContentLength >= 0:
+		extra.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	if resp.Close {
+		extra.Set("Connection", "close")
+	}
+	for k := range extra {
+		if header.Has(k) {
+			delete(extra, k)
+		}
+	}
+	if err := header.Write(w); err != nil {
+		return err
+	}
+	if err := extra.Write(w); err != nil {
+		return err
+	}
+	if _, err := w.WriteString("\r\n"); err != nil {
+		return err
+	}
+
+	head := resp.Request != nil && resp.Request.Method == common.MethodHead
+	if hasBody && !head {
+		length := resp.ContentLength
+		if length < 0 && !chunked {
+			// HTTP/1.0 没有分块编码, 消息体以关闭连接结束
+			if _, err := io.Copy(w, resp.Body); err != nil {
+				return err
+			}
+		} else if err := writeBody(w, resp.Body, length, resp.Trailer); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// Host 由请求行之后单独写出
+var reqWriteExcludeHeader = map[string]bool{"Host": true}
+
+func methodExpectsBody(method string) bool {
+	return method == common.MethodPost || method == common.MethodPut || method == common.MethodPatch
+}
+
+func writeBody(w io.Writer, body io.Reader, length int64, trailer common.Header) error {
+	if length < 0 {
+		cw := NewChunkedWriter(w)
+		cw.Trailer = trailer
+		if _, err := io.Copy(cw, body); err != nil {
+			return err
+		}
+		return cw.Close()
+	}
+	n, err := io.Copy(w, io.LimitReader(body, length))
+	if err != nil {
+		return err
+	}
+	if n != length {
+		return ErrBodyLengthMismatch
+	}
+	return nil
+}
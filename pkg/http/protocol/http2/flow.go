@@ -0,0 +1,117 @@
+package http2
+
+/*
+	流状态机和流量控制窗口
+*/
+
+// StreamState 流状态 (RFC 9113 5.1), 不含服务端推送使用的 reserved 状态
+type StreamState uint8
+
+const (
+	StateIdle StreamState = iota
+	StateOpen
+	// StateHalfClosedLocal 本端已发送 END_STREAM
+	StateHalfClosedLocal
+	// StateHalfClosedRemote 对端已发送 END_STREAM
+	StateHalfClosedRemote
+	StateClosed
+)
+
+var streamStateNames = [...]string{"idle", "open", "half-closed (local)", "half-closed (remote)", "closed"}
+
+func (s StreamState) String() string {
+	if int(s) < len(streamStateNames) {
+		return streamStateNames[s]
+	}
+	return "unknown"
+}
+
+// CanRecvData 该状态下是否允许收到 DATA/HEADERS
+func (s StreamState) CanRecvData() bool {
+	return s == StateOpen || s == StateHalfClosedLocal
+}
+
+// CanSendData 该状态下是否允许发送 DATA/HEADERS
+func (s StreamState) CanSendData() bool {
+	return s == StateOpen || s == StateHalfClosedRemote
+}
+
+// RecvEndStream 收到 END_STREAM 后的状态
+func (s StreamState) RecvEndStream() StreamState {
+	switch s {
+	case StateOpen:
+		return StateHalfClosedRemote
+	case StateHalfClosedLocal:
+		return StateClosed
+	}
+	return s
+}
+
+// SendEndStream 发送 END_STREAM 后的状态
+func (s StreamState) SendEndStream() StreamState {
+	switch s {
+	case StateOpen:
+		return StateHalfClosedLocal
+	case StateHalfClosedRemote:
+		return StateClosed
+	}
+	return s
+}
+
+// Window 发送方向的流量控制窗口. SETTINGS_INITIAL_WINDOW_SIZE 减小时可能为负; 非并发安全
+type Window struct {
+	n int64
+}
+
+// Available 当前可发送的字节数, 窗口为负时返回 0
+func (w *Window) Available() int64 {
+	return max(w.n, 0)
+}
+
+// Add 增加窗口 (WINDOW_UPDATE 或初始窗口调整), 超过 MaxWindowSize 时返回 false
+func (w *Window) Add(n int64) bool {
+	if w.n+n > MaxWindowSize {
+		return false
+	}
+	w.n += n
+	return true
+}
+
+// Take 消耗 n 个字节, 调用方应先确认 Available 足够
+func (w *Window) Take(n int64) {
+	w.n -= n
+}
+
+// InFlow 接收方向的流量控制: 记录对端还能发送的字节数, 以及已被读取但尚未归还的字节数
+type InFlow struct {
+	avail int64
+	// unsent 已读取尚未通过 WINDOW_UPDATE 归还的字节
+	unsent int64
+	size   int64
+}
+
+// Init 以窗口大小 n 初始化
+func (f *InFlow) Init(n int64) {
+	f.avail, f.size, f.unsent = n, n, 0
+}
+
+// Take 收到 n 个字节 (含填充), 超出窗口时返回 false, 应视为 FLOW_CONTROL_ERROR
+func (f *InFlow) Take(n int64) bool {
+	if n > f.avail {
+		return false
+	}
+	f.avail -= n
+	return true
+}
+
+// Release 归还已读取的 n 个字节, 累计达到窗口一半时返回应发送的 WINDOW_UPDATE 增量, 否则返回 0
+func (f *InFlow) Release(n int64) uint32 {
+	f.unsent += n
+	if f.unsent < f.size/2 && f.avail > 0 {
+		return 0
+	}
+	inc := f.unsent
+	f.avail += inc
+	f.unsent = 0
+	return uint32(inc)
+}
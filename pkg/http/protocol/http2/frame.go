@@ -0,0 +1,521 @@
+package http2
+
+/*
+	帧的读写 (RFC 9113 第 4、6 节): 读取时按类型校验长度、流 ID 和填充, 违规时返回对应的连接或流错误;
+	写出时每个帧以一次 Write 完成, 调用方负责并发互斥
+*/
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// FrameType 帧类型
+type FrameType uint8
+
+const (
+	FrameData         FrameType = 0x0
+	FrameHeaders      FrameType = 0x1
+	FramePriority     FrameType = 0x2
+	FrameRSTStream    FrameType = 0x3
+	FrameSettings     FrameType = 0x4
+	FramePushPromise  FrameType = 0x5
+	FramePing         FrameType = 0x6
+	FrameGoAway       FrameType = 0x7
+	FrameWindowUpdate FrameType = 0x8
+	FrameContinuation FrameType = 0x9
+)
+
+var frameNames = [...]string{"DATA", "HEADERS", "PRIORITY", "RST_STREAM", "SETTINGS", "PUSH_PROMISE", "PING", "GOAWAY", "WINDOW_UPDATE", "CONTINUATION"}
+
+func (t FrameType) String() string {
+	if int(t) < len(frameNames) {
+		return frameNames[t]
+	}
+	return fmt.Sprintf("UNKNOWN_FRAME_TYPE_%d", uint8(t))
+}
+
+// Flags 帧标志位, 含义取决于帧类型
+type Flags uint8
+
+const (
+	FlagEndStream  Flags = 0x1
+	FlagAck        Flags = 0x1
+	FlagEndHeaders Flags = 0x4
+	FlagPadded     Flags = 0x8
+	FlagPriority   Flags = 0x20
+)
+
+// Has 判断是否设置了 v
+func (f Flags) Has(v Flags) bool { return f&v == v }
+
+// FrameHeader 帧头部
+type FrameHeader struct {
+	Length   uint32
+	Type     FrameType
+	Flags    Flags
+	StreamID uint32
+}
+
+// Header 返回帧头部
+func (h FrameHeader) Header() FrameHeader { return h }
+
+func (h FrameHeader) String() string {
+	return fmt.Sprintf("%v stream=%d len=%d flags=%#x", h.Type, h.StreamID, h.Length, uint8(h.Flags))
+}
+
+// Frame 读取到的帧, 具体类型见 *DataFrame 等
+type Frame interface {
+	Header() FrameHeader
+}
+
+// DataFrame DATA 帧, Data 已去除填充; Length 含填充, 用于流量控制
+type DataFrame struct {
+	FrameHeader
+	Data []byte
+}
+
+// StreamEnded 是否带 END_STREAM
+func (f *DataFrame) StreamEnded() bool { return f.Flags.Has(FlagEndStream) }
+
+// PriorityParam 优先级信息 (RFC 9113 已弃用该方案, 仅解析不使用)
+type PriorityParam struct {
+	StreamDep uint32
+	Exclusive bool
+	Weight    uint8
+}
+
+// HeadersFrame HEADERS 帧
+type HeadersFrame struct {
+	FrameHeader
+	Priority      PriorityParam
+	BlockFragment []byte
+}
+
+// StreamEnded 是否带 END_STREAM
+func (f *HeadersFrame) StreamEnded() bool { return f.Flags.Has(FlagEndStream) }
+
+// HeadersEnded 是否带 END_HEADERS
+func (f *HeadersFrame) HeadersEnded() bool { return f.Flags.Has(FlagEndHeaders) }
+
+// PriorityFrame PRIORITY 帧
+type PriorityFrame struct {
+	FrameHeader
+	PriorityParam
+}
+
+// RSTStreamFrame RST_STREAM 帧
+type RSTStreamFrame struct {
+	FrameHeader
+	Code ErrCode
+}
+
+// SettingsFrame SETTINGS 帧
+type SettingsFrame struct {
+	FrameHeader
+	Settings []Setting
+}
+
+// IsAck 是否为确认帧
+func (f *SettingsFrame) IsAck() bool { return f.Flags.Has(FlagAck) }
+
+// PushPromiseFrame PUSH_PROMISE 帧
+type PushPromiseFrame struct {
+	FrameHeader
+	PromiseID     uint32
+	BlockFragment []byte
+}
+
+// PingFrame PING 帧
+type PingFrame struct {
+	FrameHeader
+	Data [8]byte
+}
+
+// IsAck 是否为确认帧
+func (f *PingFrame) IsAck() bool { return f.Flags.Has(FlagAck) }
+
+// GoAwayFrame GOAWAY 帧
+type GoAwayFrame struct {
+	FrameHeader
+	LastStreamID uint32
+	Code         ErrCode
+	Debug        []byte
+}
+
+// WindowUpdateFrame WINDOW_UPDATE 帧, StreamID 为 0 时作用于连接
+type WindowUpdateFrame struct {
+	FrameHeader
+	Increment uint32
+}
+
+// ContinuationFrame CONTINUATION 帧
+type ContinuationFrame struct {
+	FrameHeader
+	BlockFragment []byte
+}
+
+// HeadersEnded 是否带 END_HEADERS
+func (f *ContinuationFrame) HeadersEnded() bool { return f.Flags.Has(FlagEndHeaders) }
+
+// UnknownFrame 未知类型的帧, 按协议应忽略
+type UnknownFrame struct {
+	FrameHeader
+	Payload []byte
+}
+
+// Framer 在一条连接上读写帧. 读取得到的帧引用内部缓冲, 在下一次 ReadFrame 之前有效
+type Framer struct {
+	// MaxReadFrameSize 允许读取的最大帧载荷, 应与本端通告的 SETTINGS_MAX_FRAME_SIZE 一致
+	MaxReadFrameSize uint32
+
+	r    io.Reader
+	w    io.Writer
+	hdr  [FrameHeaderLen]byte
+	rbuf []byte
+	wbuf []byte
+	// contStream 非 0 时表示头部块未结束, 下一帧必须是该流的 CONTINUATION
+	contStream uint32
+}
+
+// NewFramer 创建 Framer, w 或 r 可以为空 (只读或只写)
+func NewFramer(w io.Writer, r io.Reader) *Framer {
+	return &Framer{MaxReadFrameSize: DefaultMaxFrameSize, r: r, w: w}
+}
+
+// ReadFrameHeader 读取帧头部
+func ReadFrameHeader(r io.Reader, buf []byte) (FrameHeader, error) {
+	if _, err := io.ReadFull(r, buf[:FrameHeaderLen]); err != nil {
+		return FrameHeader{}, err
+	}
+	return FrameHeader{
+		Length:   uint32(buf[0])<<16 | uint32(buf[1])<<8 | uint32(buf[2]),
+		Type:     FrameType(buf[3]),
+		Flags:    Flags(buf[4]),
+		StreamID: binary.BigEndian.Uint32(buf[5:]) & (1<<31 - 1),
+	}, nil
+}
+
+// ReadFrame 读取并校验下一个帧. 协议错误以 ConnectionError 或 *StreamError 返回
+func (f *Framer) ReadFrame() (Frame, error) {
+	fh, err := ReadFrameHeader(f.r, f.hdr[:])
+	if err != nil {
+		return nil, err
+	}
+	if fh.Length > f.MaxReadFrameSize {
+		return nil, ConnectionError(ErrCodeFrameSize)
+	}
+	if cap(f.rbuf) < int(fh.Length) {
+		f.rbuf = make([]byte, fh.Length)
+	}
+	payload := f.rbuf[:fh.Length]
+	if _, err := io.ReadFull(f.r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if f.contStream != 0 && (fh.Type != FrameContinuation || fh.StreamID != f.contStream) {
+		return nil, ConnectionError(ErrCodeProtocol)
+	}
+	fr, err := parseFrame(fh, payload)
+	if err != nil {
+		return nil, err
+	}
+	switch fr := fr.(type) {
+	case *HeadersFrame:
+		f.trackHeaders(fh.StreamID, fr.HeadersEnded())
+	case *PushPromiseFrame:
+		f.trackHeaders(fh.StreamID, fh.Flags.Has(FlagEndHeaders))
+	case *ContinuationFrame:
+		if f.contStream == 0 {
+			return nil, ConnectionError(ErrCodeProtocol)
+		}
+		f.trackHeaders(fh.StreamID, fr.HeadersEnded())
+	}
+	return fr, nil
+}
+
+func (f *Framer) trackHeaders(streamID uint32, ended bool) {
+	if ended {
+		f.contStream = 0
+	} else {
+		f.contStream = streamID
+	}
+}
+
+func parseFrame(fh FrameHeader, p []byte) (Frame, error) {
+	needStream := func() error {
+		if fh.StreamID == 0 {
+			return ConnectionError(ErrCodeProtocol)
+		}
+		return nil
+	}
+	noStream := func() error {
+		if fh.StreamID != 0 {
+			return ConnectionError(ErrCodeProtocol)
+		}
+		return nil
+	}
+	switch fh.Type {
+	case FrameData:
+		if err := needStream(); err != nil {
+			return nil, err
+		}
+		data, err := unpad(fh, p)
+		if err != nil {
+			return nil, err
+		}
+		return &DataFrame{FrameHeader: fh, Data: data}, nil
+	case FrameHeaders:
+		if err := needStream(); err != nil {
+			return nil, err
+		}
+		p, err := unpad(fh, p)
+		if err != nil {
+			return nil, err
+		}
+		hf := &HeadersFrame{FrameHeader: fh}
+		if fh.Flags.Has(FlagPriority) {
+			if len(p) < 5 {
+				return nil, ConnectionError(ErrCodeFrameSize)
+			}
+			hf.Priority = parsePriority(p)
+			if hf.Priority.StreamDep == fh.StreamID {
+				return nil, &StreamError{StreamID: fh.StreamID, Code: ErrCodeProtocol}
+			}
+			p = p[5:]
+		}
+		hf.BlockFragment = p
+		return hf, nil
+	case FramePriority:
+		if err := needStream(); err != nil {
+			return nil, err
+		}
+		if len(p) != 5 {
+			return nil, &StreamError{StreamID: fh.StreamID, Code: ErrCodeFrameSize}
+		}
+		return &PriorityFrame{FrameHeader: fh, PriorityParam: parsePriority(p)}, nil
+	case FrameRSTStream:
+		if err := needStream(); err != nil {
+			return nil, err
+		}
+		if len(p) != 4 {
+			return nil, ConnectionError(ErrCodeFrameSize)
+		}
+		return &RSTStreamFrame{FrameHeader: fh, Code: ErrCode(binary.BigEndian.Uint32(p))}, nil
+	case FrameSettings:
+		if err := noStream(); err != nil {
+			return nil, err
+		}
+		if fh.Flags.Has(FlagAck) && len(p) != 0 {
+			return nil, ConnectionError(ErrCodeFrameSize)
+		}
+		settings, err := ParseSettings(p)
+		if err != nil {
+			return nil, err
+		}
+		return &SettingsFrame{FrameHeader: fh, Settings: settings}, nil
+	case FramePushPromise:
+		if err := needStream(); err != nil {
+			return nil, err
+		}
+		p, err := unpad(fh, p)
+		if err != nil {
+			return nil, err
+		}
+		if len(p) < 4 {
+			return nil, ConnectionError(ErrCodeFrameSize)
+		}
+		return &PushPromiseFrame{FrameHeader: fh, PromiseID: binary.BigEndian.Uint32(p) & (1<<31 - 1), BlockFragment: p[4:]}, nil
+	case FramePing:
+		if err := noStream(); err != nil {
+			return nil, err
+		}
+		if len(p) != 8 {
+			return nil, ConnectionError(ErrCodeFrameSize)
+		}
+		pf := &PingFrame{FrameHeader: fh}
+		copy(pf.Data[:], p)
+		return pf, nil
+	case FrameGoAway:
+		if err := noStream(); err != nil {
+			return nil, err
+		}
+		if len(p) < 8 {
+			return nil, ConnectionError(ErrCodeFrameSize)
+		}
+		return &GoAwayFrame{
+			FrameHeader:  fh,
+			LastStreamID: binary.BigEndian.Uint32(p) & (1<<31 - 1),
+			Code:         ErrCode(binary.BigEndian.Uint32(p[4:])),
+			Debug:        p[8:],
+		}, nil
+	case FrameWindowUpdate:
+		if len(p) != 4 {
+			return nil, ConnectionError(ErrCodeFrameSize)
+		}
+		inc := binary.BigEndian.Uint32(p) & (1<<31 - 1)
+		if inc == 0 {
+			if fh.StreamID == 0 {
+				return nil, ConnectionError(ErrCodeProtocol)
+			}
+			return nil, &StreamError{StreamID: fh.StreamID, Code: ErrCodeProtocol}
+		}
+		return &WindowUpdateFrame{FrameHeader: fh, Increment: inc}, nil
+	case FrameContinuation:
+		if err := needStream(); err != nil {
+			return nil, err
+		}
+		return &ContinuationFrame{FrameHeader: fh, BlockFragment: p}, nil
+	}
+	return &UnknownFrame{FrameHeader: fh, Payload: p}, nil
+}
+
+// unpad 去掉 PADDED 标志对应的填充, 填充长度不小于载荷时为协议错误
+func unpad(fh FrameHeader, p []byte) ([]byte, error) {
+	if !fh.Flags.Has(FlagPadded) {
+		return p, nil
+	}
+	if len(p) < 1 || int(p[0]) >= len(p) {
+		return nil, ConnectionError(ErrCodeProtocol)
+	}
+	return p[1 : len(p)-int(p[0])], nil
+}
+
+func parsePriority(p []byte) PriorityParam {
+	v := binary.BigEndian.Uint32(p)
+	return PriorityParam{StreamDep: v & (1<<31 - 1), Exclusive: v>>31 == 1, Weight: p[4]}
+}
+
+// startWrite 开始组装一个帧, 长度在 endWrite 时回填
+func (f *Framer) startWrite(t FrameType, flags Flags, streamID uint32) {
+	f.wbuf = append(f.wbuf[:0], 0, 0, 0, byte(t), byte(flags))
+	f.wbuf = binary.BigEndian.AppendUint32(f.wbuf, streamID&(1<<31-1))
+}
+
+func (f *Framer) endWrite() error {
+	n := len(f.wbuf) - FrameHeaderLen
+	if n > MaxFrameSizeLimit {
+		return ErrFrameTooLarge
+	}
+	f.wbuf[0], f.wbuf[1], f.wbuf[2] = byte(n>>16), byte(n>>8), byte(n)
+	_, err := f.w.Write(f.wbuf)
+	return err
+}
+
+// WriteRawFrame 写出任意帧, 不做校验, 用于测试和扩展帧
+func (f *Framer) WriteRawFrame(t FrameType, flags Flags, streamID uint32, payload []byte) error {
+	f.startWrite(t, flags, streamID)
+	f.wbuf = append(f.wbuf, payload...)
+	return f.endWrite()
+}
+
+// WriteData 写出 DATA 帧, 调用方负责按对端的 MAX_FRAME_SIZE 和流量控制窗口切分
+func (f *Framer) WriteData(streamID uint32, endStream bool, data []byte) error {
+	var flags Flags
+	if endStream {
+		flags |= FlagEndStream
+	}
+	return f.WriteRawFrame(FrameData, flags, streamID, data)
+}
+
+// WriteHeaders 写出 HEADERS 帧, 头部块超过一帧时后续部分用 WriteContinuation 写出
+func (f *Framer) WriteHeaders(streamID uint32, endStream, endHeaders bool, block []byte) error {
+	var flags Flags
+	if endStream {
+		flags |= FlagEndStream
+	}
+	if endHeaders {
+		flags |= FlagEndHeaders
+	}
+	return f.WriteRawFrame(FrameHeaders, flags, streamID, block)
+}
+
+// WriteContinuation 写出 CONTINUATION 帧
+func (f *Framer) WriteContinuation(streamID uint32, endHeaders bool, block []byte) error {
+	var flags Flags
+	if endHeaders {
+		flags |= FlagEndHeaders
+	}
+	return f.WriteRawFrame(FrameContinuation, flags, streamID, block)
+}
+
+// WriteHeaderBlock 按 maxFrameSize 将头部块切分为 HEADERS 和若干 CONTINUATION 帧写出
+func (f *Framer) WriteHeaderBlock(streamID uint32, endStream bool, block []byte, maxFrameSize uint32) error {
+	first := true
+	for {
+		n := min(len(block), int(maxFrameSize))
+		chunk, last := block[:n], n == len(block)
+		var err error
+		if first {
+			err = f.WriteHeaders(streamID, endStream, last, chunk)
+		} else {
+			err = f.WriteContinuation(streamID, last, chunk)
+		}
+		if err != nil || last {
+			return err
+		}
+		block, first = block[n:], false
+	}
+}
+
+// WritePriority 写出 PRIORITY 帧
+func (f *Framer) WritePriority(streamID uint32, p PriorityParam) error {
+	f.startWrite(FramePriority, 0, streamID)
+	dep := p.StreamDep
+	if p.Exclusive {
+		dep |= 1 << 31
+	}
+	f.wbuf = binary.BigEndian.AppendUint32(f.wbuf, dep)
+	f.wbuf = append(f.wbuf, p.Weight)
+	return f.endWrite()
+}
+
+// WriteRSTStream 写出 RST_STREAM 帧
+func (f *Framer) WriteRSTStream(streamID uint32, code ErrCode) error {
+	f.startWrite(FrameRSTStream, 0, streamID)
+	f.wbuf = binary.BigEndian.AppendUint32(f.wbuf, uint32(code))
+	return f.endWrite()
+}
+
+// WriteSettings 写出 SETTINGS 帧
+func (f *Framer) WriteSettings(settings ...Setting) error {
+	f.startWrite(FrameSettings, 0, 0)
+	for _, s := range settings {
+		f.wbuf = binary.BigEndian.AppendUint16(f.wbuf, uint16(s.ID))
+		f.wbuf = binary.BigEndian.AppendUint32(f.wbuf, s.Val)
+	}
+	return f.endWrite()
+}
+
+// WriteSettingsAck 写出 SETTINGS 确认帧
+func (f *Framer) WriteSettingsAck() error {
+	return f.WriteRawFrame(FrameSettings, FlagAck, 0, nil)
+}
+
+// WritePing 写出 PING 帧
+func (f *Framer) WritePing(ack bool, data [8]byte) error {
+	var flags Flags
+	if ack {
+		flags = FlagAck
+	}
+	return f.WriteRawFrame(FramePing, flags, 0, data[:])
+}
+
+// WriteGoAway 写出 GOAWAY 帧
+func (f *Framer) WriteGoAway(lastStreamID uint32, code ErrCode, debug []byte) error {
+	f.startWrite(FrameGoAway, 0, 0)
+	f.wbuf = binary.BigEndian.AppendUint32(f.wbuf, lastStreamID&(1<<31-1))
+	f.wbuf = binary.BigEndian.AppendUint32(f.wbuf, uint32(code))
+	f.wbuf = append(f.wbuf, debug...)
+	return f.endWrite()
+}
+
+// WriteWindowUpdate 写出 WINDOW_UPDATE 帧, streamID 为 0 时作用于连接
+func (f *Framer) WriteWindowUpdate(streamID, increment uint32) error {
+	f.startWrite(FrameWindowUpdate, 0, streamID)
+	f.wbuf = binary.BigEndian.AppendUint32(f.wbuf, increment&(1<<31-1))
+	return f.endWrite()
+}
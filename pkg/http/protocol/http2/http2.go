@@ -0,0 +1,181 @@
+package http2
+
+/*
+	HTTP/2 (RFC 9113) 协议基础: 连接前言、SETTINGS 参数、错误码和连接/流级错误
+*/
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ClientPreface 客户端连接前言
+const ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// NextProtoTLS TLS ALPN 中 HTTP/2 的协议标识
+const NextProtoTLS = "h2"
+
+// NextProtoCleartext 明文 HTTP/2 在 Upgrade 头部中的协议标识
+const NextProtoCleartext = "h2c"
+
+// 协议规定的默认值和上限
+const (
+	DefaultHeaderTableSize   = 4096
+	DefaultInitialWindowSize = 65535
+	DefaultMaxFrameSize      = 16384
+	MaxFrameSizeLimit        = 1<<24 - 1
+	MaxWindowSize            = 1<<31 - 1
+	// FrameHeaderLen 帧头部长度
+	FrameHeaderLen = 9
+)
+
+// SettingID SETTINGS 参数标识
+type SettingID uint16
+
+const (
+	SettingHeaderTableSize      SettingID = 0x1
+	SettingEnablePush           SettingID = 0x2
+	SettingMaxConcurrentStreams SettingID = 0x3
+	SettingInitialWindowSize    SettingID = 0x4
+	SettingMaxFrameSize         SettingID = 0x5
+	SettingMaxHeaderListSize    SettingID = 0x6
+)
+
+func (id SettingID) String() string {
+	switch id {
+	case SettingHeaderTableSize:
+		return "HEADER_TABLE_SIZE"
+	case SettingEnablePush:
+		return "ENABLE_PUSH"
+	case SettingMaxConcurrentStreams:
+		return "MAX_CONCURRENT_STREAMS"
+	case SettingInitialWindowSize:
+		return "INITIAL_WINDOW_SIZE"
+	case SettingMaxFrameSize:
+		return "MAX_FRAME_SIZE"
+	case SettingMaxHeaderListSize:
+		return "MAX_HEADER_LIST_SIZE"
+	}
+	return "UNKNOWN_SETTING_" + strconv.Itoa(int(id))
+}
+
+// Setting 一个 SETTINGS 参数
+type Setting struct {
+	ID  SettingID
+	Val uint32
+}
+
+// Valid 检查参数取值, 不合法时返回应使用的连接错误
+func (s Setting) Valid() error {
+	switch s.ID {
+	case SettingEnablePush:
+		if s.Val > 1 {
+			return ConnectionError(ErrCodeProtocol)
+		}
+	case SettingInitialWindowSize:
+		if s.Val > MaxWindowSize {
+			return ConnectionError(ErrCodeFlowControl)
+		}
+	case SettingMaxFrameSize:
+		if s.Val < DefaultMaxFrameSize || s.Val > MaxFrameSizeLimit {
+			return ConnectionError(ErrCodeProtocol)
+		}
+	}
+	return nil
+}
+
+// ParseSettings 解析 SETTINGS 帧载荷, 也用于 HTTP2-Settings 头部解码后的内容
+func ParseSettings(p []byte) ([]Setting, error) {
+	if len(p)%6 != 0 {
+		return nil, ConnectionError(ErrCodeFrameSize)
+	}
+	settings := make([]Setting, 0, len(p)/6)
+	for ; len(p) > 0; p = p[6:] {
+		s := Setting{ID: SettingID(binary.BigEndian.Uint16(p)), Val: binary.BigEndian.Uint32(p[2:])}
+		if err := s.Valid(); err != nil {
+			return nil, err
+		}
+		settings = append(settings, s)
+	}
+	return settings, nil
+}
+
+func (s Setting) String() string {
+	return fmt.Sprintf("%v=%d", s.ID, s.Val)
+}
+
+// ErrCode RST_STREAM 和 GOAWAY 中的错误码
+type ErrCode uint32
+
+const (
+	ErrCodeNo                 ErrCode = 0x0
+	ErrCodeProtocol           ErrCode = 0x1
+	ErrCodeInternal           ErrCode = 0x2
+	ErrCodeFlowControl        ErrCode = 0x3
+	ErrCodeSettingsTimeout    ErrCode = 0x4
+	ErrCodeStreamClosed       ErrCode = 0x5
+	ErrCodeFrameSize          ErrCode = 0x6
+	ErrCodeRefusedStream      ErrCode = 0x7
+	ErrCodeCancel             ErrCode = 0x8
+	ErrCodeCompression        ErrCode = 0x9
+	ErrCodeConnect            ErrCode = 0xa
+	ErrCodeEnhanceYourCalm    ErrCode = 0xb
+	ErrCodeInadequateSecurity ErrCode = 0xc
+	ErrCodeHTTP11Required     ErrCode = 0xd
+)
+
+var errCodeNames = [...]string{
+	"NO_ERROR", "PROTOCOL_ERROR", "INTERNAL_ERROR", "FLOW_CONTROL_ERROR", "SETTINGS_TIMEOUT",
+	"STREAM_CLOSED", "FRAME_SIZE_ERROR", "REFUSED_STREAM", "CANCEL", "COMPRESSION_ERROR",
+	"CONNECT_ERROR", "ENHANCE_YOUR_CALM", "INADEQUATE_SECURITY", "HTTP_1_1_REQUIRED",
+}
+
+func (e ErrCode) String() string {
+	if int(e) < len(errCodeNames) {
+		return errCodeNames[e]
+	}
+	return fmt.Sprintf("unknown error code 0x%x", uint32(e))
+}
+
+// ConnectionError 连接级错误, 发送 GOAWAY 后关闭连接
+type ConnectionError ErrCode
+
+func (e ConnectionError) Error() string {
+	return "http2: connection error: " + ErrCode(e).String()
+}
+
+// StreamError 流级错误, 以 RST_STREAM 结束该流, 连接继续使用
+type StreamError struct {
+	StreamID uint32
+	Code     ErrCode
+	// Cause 导致错误的原因, 可为空
+	Cause error
+}
+
+func (e *StreamError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("http2: stream %d error: %v: %v", e.StreamID, e.Code, e.Cause)
+	}
+	return fmt.Sprintf("http2: stream %d error: %v", e.StreamID, e.Code)
+}
+
+func (e *StreamError) Unwrap() error { return e.Cause }
+
+// GoAwayError 对端发送了 GOAWAY
+type GoAwayError struct {
+	LastStreamID uint32
+	Code         ErrCode
+	Debug        string
+}
+
+func (e *GoAwayError) Error() string {
+	return fmt.Sprintf("http2: received GOAWAY: last stream %d, %v, %q", e.LastStreamID, e.Code, e.Debug)
+}
+
+// ErrFrameTooLarge 帧长度超过本端通告的 MAX_FRAME_SIZE
+var ErrFrameTooLarge = errors.New("http2: frame too large")
+
+// ErrBadPreface 连接没有以客户端前言开始
+var ErrBadPreface = errors.New("http2: bad connection preface")
@@ -0,0 +1,167 @@
+package http2
+
+/*
+	流的发送调度: 多个流同时有 DATA 待发时, 按策略决定下一帧属于哪个流.
+	优先级使用 RFC 9218 的 urgency/incremental 参数, 来自请求的 Priority 头部
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PriorityStrategy DATA 帧在流之间的调度策略
+type PriorityStrategy uint8
+
+const (
+	// PriorityFIFO 按等待的先后顺序发送
+	PriorityFIFO PriorityStrategy = iota
+	// PriorityRoundRobin 在有数据待发的流之间轮转, 最久未发送的流优先
+	PriorityRoundRobin
+	// PriorityUrgency 按 urgency 从高到低发送 (RFC 9218); 同一 urgency 内 incremental 的流轮转, 其余按流 ID 依次发送
+	PriorityUrgency
+)
+
+func (s PriorityStrategy) String() string {
+	switch s {
+	case PriorityFIFO:
+		return "fifo"
+	case PriorityRoundRobin:
+		return "round-robin"
+	case PriorityUrgency:
+		return "urgency"
+	}
+	return "PriorityStrategy(" + strconv.Itoa(int(s)) + ")"
+}
+
+// ParsePriorityStrategy 解析 String 返回的策略名, 用于命令行和配置文件
+func ParsePriorityStrategy(s string) (PriorityStrategy, error) {
+	for _, v := range []PriorityStrategy{PriorityFIFO, PriorityRoundRobin, PriorityUrgency} {
+		if v.String() == s {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("http2: unknown priority strategy %q", s)
+}
+
+// Priority RFC 9218 的优先级参数, Urgency 为 0 (最高) 到 7
+type Priority struct {
+	Urgency     uint8
+	Incremental bool
+}
+
+// DefaultPriority 没有 Priority 头部时的优先级
+var DefaultPriority = Priority{Urgency: 3}
+
+// ParsePriority 解析 Priority 头部的值, 如 "u=1, i"; 无法识别的参数被忽略, 不合法的 urgency 使用默认值
+func ParsePriority(v string) Priority {
+	p := DefaultPriority
+	for _, item := range strings.Split(v, ",") {
+		key, val, _ := strings.Cut(strings.TrimSpace(item), "=")
+		if i := strings.IndexByte(val, ';'); i >= 0 {
+			val = val[:i]
+		}
+		switch strings.TrimSpace(key) {
+		case "u":
+			if n, err := strconv.Atoi(val); err == nil && n >= 0 && n <= 7 {
+				p.Urgency = uint8(n)
+			}
+		case "i":
+			p.Incremental = val == "" || val == "?1"
+		}
+	}
+	return p
+}
+
+// WriteScheduler 在多个流争用连接时按策略依次授予发送 DATA 帧的权利, 一次只有一个流持有.
+// 控制帧不经过调度器, 可以插在 DATA 帧之间发出
+type WriteScheduler struct {
+	strategy PriorityStrategy
+
+	mu      sync.Mutex
+	busy    bool
+	waiters []*schedWaiter
+	seq     uint64
+	// served 每个流最近一次取得发送权的序号, 用于轮转
+	served map[uint32]uint64
+}
+
+type schedWaiter struct {
+	id    uint32
+	prio  Priority
+	seq   uint64
+	ready chan struct{}
+}
+
+// NewWriteScheduler 创建使用 strategy 的调度器
+func NewWriteScheduler(strategy PriorityStrategy) *WriteScheduler {
+	return &WriteScheduler{strategy: strategy, served: make(map[uint32]uint64)}
+}
+
+// Acquire 等待流 id 取得发送权, 发送一帧后必须调用 Release
+func (s *WriteScheduler) Acquire(id uint32, prio Priority) {
+	s.mu.Lock()
+	s.seq++
+	if !s.busy {
+		s.busy = true
+		s.served[id] = s.seq
+		s.mu.Unlock()
+		return
+	}
+	w := &schedWaiter{id: id, prio: prio, seq: s.seq, ready: make(chan struct{})}
+	s.waiters = append(s.waiters, w)
+	s.mu.Unlock()
+	<-w.ready
+}
+
+// Release 交还发送权, 按策略唤醒下一个等待的流
+func (s *WriteScheduler) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.waiters) == 0 {
+		s.busy = false
+		return
+	}
+	best := 0
+	for i := 1; i < len(s.waiters); i++ {
+		if s.before(s.waiters[i], s.waiters[best]) {
+			best = i
+		}
+	}
+	w := s.waiters[best]
+	s.waiters = append(s.waiters[:best], s.waiters[best+1:]...)
+	s.seq++
+	s.served[w.id] = s.seq
+	close(w.ready)
+}
+
+// Forget 在流结束时丢弃它的调度记录
+func (s *WriteScheduler) Forget(id uint32) {
+	s.mu.Lock()
+	delete(s.served, id)
+	s.mu.Unlock()
+}
+
+// before 按策略判断 a 是否应先于 b 发送, 由调用方持有 s.mu
+func (s *WriteScheduler) before(a, b *schedWaiter) bool {
+	switch s.strategy {
+	case PriorityRoundRobin:
+		if sa, sb := s.served[a.id], s.served[b.id]; sa != sb {
+			return sa < sb
+		}
+	case PriorityUrgency:
+		if a.prio.Urgency != b.prio.Urgency {
+			return a.prio.Urgency < b.prio.Urgency
+		}
+		if a.prio.Incremental && b.prio.Incremental {
+			if sa, sb := s.served[a.id], s.served[b.id]; sa != sb {
+				return sa < sb
+			}
+		} else if a.id != b.id {
+			return a.id < b.id
+		}
+	}
+	return a.seq < b.seq
+}
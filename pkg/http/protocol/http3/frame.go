@@ -0,0 +1,222 @@
+package http3
+
+/*
+	HTTP/3 帧 (RFC 9114 7): 帧头由类型和长度两个变长整数组成, 流量控制和分片由 QUIC 负责
+*/
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// FrameType 帧类型
+type FrameType uint64
+
+const (
+	FrameData        FrameType = 0x0
+	FrameHeaders     FrameType = 0x1
+	FrameCancelPush  FrameType = 0x3
+	FrameSettings    FrameType = 0x4
+	FramePushPromise FrameType = 0x5
+	FrameGoAway      FrameType = 0x7
+	FrameMaxPushID   FrameType = 0xd
+)
+
+func (t FrameType) String() string {
+	switch t {
+	case FrameData:
+		return "DATA"
+	case FrameHeaders:
+		return "HEADERS"
+	case FrameCancelPush:
+		return "CANCEL_PUSH"
+	case FrameSettings:
+		return "SETTINGS"
+	case FramePushPromise:
+		return "PUSH_PROMISE"
+	case FrameGoAway:
+		return "GOAWAY"
+	case FrameMaxPushID:
+		return "MAX_PUSH_ID"
+	}
+	return fmt.Sprintf("UNKNOWN_FRAME_TYPE_0x%x", uint64(t))
+}
+
+// known 是否为本实现识别的帧类型, 其余类型按扩展帧忽略
+func (t FrameType) known() bool {
+	switch t {
+	case FrameData, FrameHeaders, FrameCancelPush, FrameSettings, FramePushPromise, FrameGoAway, FrameMaxPushID:
+		return true
+	}
+	return false
+}
+
+// reservedHTTP2 HTTP/2 中存在而 HTTP/3 保留的帧类型, 收到时是连接错误 (RFC 9114 7.2.8)
+func (t FrameType) reservedHTTP2() bool {
+	return t == 0x2 || t == 0x6 || t == 0x8 || t == 0x9
+}
+
+// ErrFrameTooLarge 帧载荷超过调用方允许的长度
+var ErrFrameTooLarge = errors.New("http3: frame too large")
+
+// FrameReader 从一个流中依次读取帧, 跳过扩展帧; 当前帧的载荷通过 Read 读取
+type FrameReader struct {
+	r *bufio.Reader
+	// remaining 当前帧未读的载荷长度
+	remaining uint64
+}
+
+// NewFrameReader 创建读取 r 的帧读取器, r 为 *bufio.Reader 时直接使用
+func NewFrameReader(r io.Reader) *FrameReader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &FrameReader{r: br}
+}
+
+// Next 丢弃当前帧未读的载荷并读取下一帧的类型和载荷长度.
+// 流在帧边界处结束时返回 io.EOF; 帧被截断时返回 H3_FRAME_ERROR 连接错误
+func (fr *FrameReader) Next() (FrameType, uint64, error) {
+	for {
+		if err := fr.discard(); err != nil {
+			return 0, 0, err
+		}
+		t, err := ReadVarInt(fr.r)
+		if err != nil {
+			return 0, 0, fr.truncated(err, err == io.EOF)
+		}
+		n, err := ReadVarInt(fr.r)
+		if err != nil {
+			return 0, 0, fr.truncated(err, false)
+		}
+		ft := FrameType(t)
+		if ft.reservedHTTP2() {
+			return 0, 0, ConnectionError(ErrCodeFrameUnexpected)
+		}
+		fr.remaining = n
+		if ft.known() {
+			return ft, n, nil
+		}
+	}
+}
+
+func (fr *FrameReader) discard() error {
+	for fr.remaining > 0 {
+		n, err := fr.r.Discard(int(min(fr.remaining, 1<<20)))
+		fr.remaining -= uint64(n)
+		if err != nil {
+			return fr.truncated(err, false)
+		}
+	}
+	return nil
+}
+
+// truncated 把读取错误转换为返回值; clean 为 true 表示流恰好在帧边界处结束
+func (fr *FrameReader) truncated(err error, clean bool) error {
+	if clean {
+		return io.EOF
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ConnectionError(ErrCodeFrame)
+	}
+	return err
+}
+
+// Read 读取当前帧的载荷, 读完时返回 io.EOF
+func (fr *FrameReader) Read(p []byte) (int, error) {
+	if fr.remaining == 0 {
+		return 0, io.EOF
+	}
+	if uint64(len(p)) > fr.remaining {
+		p = p[:fr.remaining]
+	}
+	n, err := fr.r.Read(p)
+	fr.remaining -= uint64(n)
+	if err != nil {
+		return n, fr.truncated(err, false)
+	}
+	return n, nil
+}
+
+// ReadPayload 读取当前帧的完整载荷, 长度超过 max 时返回 ErrFrameTooLarge 且不读取
+func (fr *FrameReader) ReadPayload(max uint64) ([]byte, error) {
+	if fr.remaining > max {
+		return nil, ErrFrameTooLarge
+	}
+	p := make([]byte, fr.remaining)
+	if _, err := io.ReadFull(fr, p); err != nil {
+		return nil, fr.truncated(err, false)
+	}
+	return p, nil
+}
+
+// AppendFrameHeader 追加帧头, 载荷由调用方随后追加或写出
+func AppendFrameHeader(dst []byte, t FrameType, length uint64) []byte {
+	dst = AppendVarInt(dst, uint64(t))
+	return AppendVarInt(dst, length)
+}
+
+// AppendSettingsFrame 追加完整的 SETTINGS 帧
+func AppendSettingsFrame(dst []byte, settings ...Setting) []byte {
+	var n uint64
+	for _, s := range settings {
+		n += uint64(VarIntLen(uint64(s.ID)) + VarIntLen(s.Val))
+	}
+	dst = AppendFrameHeader(dst, FrameSettings, n)
+	for _, s := range settings {
+		dst = AppendVarInt(dst, uint64(s.ID))
+		dst = AppendVarInt(dst, s.Val)
+	}
+	return dst
+}
+
+// ParseSettings 解析 SETTINGS 帧载荷. 重复的参数和 HTTP/2 保留的参数是 H3_SETTINGS_ERROR (RFC 9114 7.2.4.1)
+func ParseSettings(p []byte) ([]Setting, error) {
+	var settings []Setting
+	seen := make(map[SettingID]bool)
+	for len(p) > 0 {
+		id, rest, err := ParseVarInt(p)
+		if err != nil {
+			return nil, ConnectionError(ErrCodeFrame)
+		}
+		val, rest, err := ParseVarInt(rest)
+		if err != nil {
+			return nil, ConnectionError(ErrCodeFrame)
+		}
+		p = rest
+		sid := SettingID(id)
+		if sid >= 0x2 && sid <= 0x5 || seen[sid] {
+			return nil, ConnectionError(ErrCodeSettings)
+		}
+		seen[sid] = true
+		settings = append(settings, Setting{ID: sid, Val: val})
+	}
+	return settings, nil
+}
+
+// AppendGoAwayFrame 追加 GOAWAY 帧, 服务端发送时 id 为第一个不会处理的请求流 ID
+func AppendGoAwayFrame(dst []byte, id uint64) []byte {
+	dst = AppendFrameHeader(dst, FrameGoAway, uint64(VarIntLen(id)))
+	return AppendVarInt(dst, id)
+}
+
+// ParseGoAway 解析 GOAWAY 帧载荷
+func ParseGoAway(p []byte) (uint64, error) {
+	id, rest, err := ParseVarInt(p)
+	if err != nil || len(rest) > 0 {
+		return 0, ConnectionError(ErrCodeFrame)
+	}
+	return id, nil
+}
+
+// GoAwayError 对端发送了 GOAWAY, 不会处理 ID 不小于 StreamID 的请求
+type GoAwayError struct {
+	StreamID uint64
+}
+
+func (e *GoAwayError) Error() string {
+	return fmt.Sprintf("http3: received GOAWAY: stream %d", e.StreamID)
+}
@@ -0,0 +1,197 @@
+package http3
+
+/*
+	HTTP/3 (RFC 9114) 协议基础: QUIC 变长整数、单向流类型、SETTINGS 参数和错误码
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// NextProtoH3 TLS ALPN 中 HTTP/3 的协议标识
+const NextProtoH3 = "h3"
+
+// MaxVarInt QUIC 变长整数能表示的最大值
+const MaxVarInt = 1<<62 - 1
+
+// ErrVarIntOverflow 整数超过 MaxVarInt
+var ErrVarIntOverflow = errors.New("http3: varint overflow")
+
+// VarIntLen 返回 v 编码后的长度
+func VarIntLen(v uint64) int {
+	switch {
+	case v < 1<<6:
+		return 1
+	case v < 1<<14:
+		return 2
+	case v < 1<<30:
+		return 4
+	}
+	return 8
+}
+
+// AppendVarInt 按 RFC 9000 16 节追加变长整数, v 不能超过 MaxVarInt
+func AppendVarInt(dst []byte, v uint64) []byte {
+	switch VarIntLen(v) {
+	case 1:
+		return append(dst, byte(v))
+	case 2:
+		return append(dst, byte(v>>8)|0x40, byte(v))
+	case 4:
+		return append(dst, byte(v>>24)|0x80, byte(v>>16), byte(v>>8), byte(v))
+	}
+	return append(dst, byte(v>>56)|0xc0, byte(v>>48), byte(v>>40), byte(v>>32),
+		byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// ReadVarInt 读取一个变长整数; 在第一个字节之后遇到 EOF 时返回 io.ErrUnexpectedEOF
+func ReadVarInt(r io.ByteReader) (uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	n := 1 << (b >> 6)
+	v := uint64(b & 0x3f)
+	for i := 1; i < n; i++ {
+		if b, err = r.ReadByte(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
+}
+
+// ParseVarInt 从 p 开头解析一个变长整数, 返回值和剩余数据
+func ParseVarInt(p []byte) (uint64, []byte, error) {
+	if len(p) == 0 {
+		return 0, p, io.ErrUnexpectedEOF
+	}
+	n := 1 << (p[0] >> 6)
+	if len(p) < n {
+		return 0, p, io.ErrUnexpectedEOF
+	}
+	v := uint64(p[0] & 0x3f)
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64(p[i])
+	}
+	return v, p[n:], nil
+}
+
+// StreamType 单向流开头的流类型
+type StreamType uint64
+
+const (
+	StreamTypeControl      StreamType = 0x0
+	StreamTypePush         StreamType = 0x1
+	StreamTypeQPACKEncoder StreamType = 0x2
+	StreamTypeQPACKDecoder StreamType = 0x3
+)
+
+// SettingID SETTINGS 参数标识
+type SettingID uint64
+
+const (
+	SettingQPACKMaxTableCapacity SettingID = 0x1
+	SettingMaxFieldSectionSize   SettingID = 0x6
+	SettingQPACKBlockedStreams   SettingID = 0x7
+)
+
+func (id SettingID) String() string {
+	switch id {
+	case SettingQPACKMaxTableCapacity:
+		return "QPACK_MAX_TABLE_CAPACITY"
+	case SettingMaxFieldSectionSize:
+		return "MAX_FIELD_SECTION_SIZE"
+	case SettingQPACKBlockedStreams:
+		return "QPACK_BLOCKED_STREAMS"
+	}
+	return fmt.Sprintf("UNKNOWN_SETTING_0x%x", uint64(id))
+}
+
+// Setting 一个 SETTINGS 参数
+type Setting struct {
+	ID  SettingID
+	Val uint64
+}
+
+func (s Setting) String() string {
+	return fmt.Sprintf("%v=%d", s.ID, s.Val)
+}
+
+// ErrCode 连接关闭和流重置使用的应用错误码
+type ErrCode uint64
+
+const (
+	ErrCodeNo                   ErrCode = 0x100
+	ErrCodeGeneralProtocol      ErrCode = 0x101
+	ErrCodeInternal             ErrCode = 0x102
+	ErrCodeStreamCreation       ErrCode = 0x103
+	ErrCodeClosedCriticalStream ErrCode = 0x104
+	ErrCodeFrameUnexpected      ErrCode = 0x105
+	ErrCodeFrame                ErrCode = 0x106
+	ErrCodeExcessiveLoad        ErrCode = 0x107
+	ErrCodeID                   ErrCode = 0x108
+	ErrCodeSettings             ErrCode = 0x109
+	ErrCodeMissingSettings      ErrCode = 0x10a
+	ErrCodeRequestRejected      ErrCode = 0x10b
+	ErrCodeRequestCancelled     ErrCode = 0x10c
+	ErrCodeRequestIncomplete    ErrCode = 0x10d
+	ErrCodeMessage              ErrCode = 0x10e
+	ErrCodeConnect              ErrCode = 0x10f
+	ErrCodeVersionFallback      ErrCode = 0x110
+
+	ErrCodeQPACKDecompressionFailed ErrCode = 0x200
+	ErrCodeQPACKEncoderStream       ErrCode = 0x201
+	ErrCodeQPACKDecoderStream       ErrCode = 0x202
+)
+
+var errCodeNames = [...]string{
+	"H3_NO_ERROR", "H3_GENERAL_PROTOCOL_ERROR", "H3_INTERNAL_ERROR", "H3_STREAM_CREATION_ERROR",
+	"H3_CLOSED_CRITICAL_STREAM", "H3_FRAME_UNEXPECTED", "H3_FRAME_ERROR", "H3_EXCESSIVE_LOAD",
+	"H3_ID_ERROR", "H3_SETTINGS_ERROR", "H3_MISSING_SETTINGS", "H3_REQUEST_REJECTED",
+	"H3_REQUEST_CANCELLED", "H3_REQUEST_INCOMPLETE", "H3_MESSAGE_ERROR", "H3_CONNECT_ERROR",
+	"H3_VERSION_FALLBACK",
+}
+
+func (e ErrCode) String() string {
+	switch {
+	case e >= ErrCodeNo && int(e-ErrCodeNo) < len(errCodeNames):
+		return errCodeNames[e-ErrCodeNo]
+	case e == ErrCodeQPACKDecompressionFailed:
+		return "QPACK_DECOMPRESSION_FAILED"
+	case e == ErrCodeQPACKEncoderStream:
+		return "QPACK_ENCODER_STREAM_ERROR"
+	case e == ErrCodeQPACKDecoderStream:
+		return "QPACK_DECODER_STREAM_ERROR"
+	}
+	return fmt.Sprintf("unknown error code 0x%x", uint64(e))
+}
+
+// ConnectionError 连接级错误, 以该错误码关闭 QUIC 连接
+type ConnectionError ErrCode
+
+func (e ConnectionError) Error() string {
+	return "http3: connection error: " + ErrCode(e).String()
+}
+
+// StreamError 流级错误, 以该错误码重置请求流, 连接继续使用
+type StreamError struct {
+	StreamID int64
+	Code     ErrCode
+	// Cause 导致错误的原因, 可为空
+	Cause error
+}
+
+func (e *StreamError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("http3: stream %d error: %v: %v", e.StreamID, e.Code, e.Cause)
+	}
+	return fmt.Sprintf("http3: stream %d error: %v", e.StreamID, e.Code)
+}
+
+func (e *StreamError) Unwrap() error { return e.Cause }
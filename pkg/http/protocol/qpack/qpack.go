@@ -0,0 +1,344 @@
+package qpack
+
+/*
+	QPACK 头部压缩 (RFC 9204) 的无动态表实现: 只使用静态表和字面量, 通告 SETTINGS_QPACK_MAX_TABLE_CAPACITY 为 0,
+	因此不需要编码器/解码器指令流, 也不会出现阻塞的流. 字符串的 Huffman 编码与 HPACK 相同
+*/
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/narcilee7/http-stack/pkg/http/protocol/hpack"
+)
+
+var (
+	// ErrDecompressionFailed 字段节解码失败, 在 HTTP/3 中对应连接错误 QPACK_DECOMPRESSION_FAILED
+	ErrDecompressionFailed = errors.New("qpack: decompression failed")
+	// ErrFieldSectionTooLarge 解码后的字段节超过 MaxFieldSectionSize
+	ErrFieldSectionTooLarge = errors.New("qpack: field section too large")
+)
+
+// HeaderField 与 HPACK 相同的头部字段, 名称为小写
+type HeaderField = hpack.HeaderField
+
+// staticTable RFC 9204 附录 A, 下标从 0 开始
+var staticTable = [...]HeaderField{
+	{Name: ":authority"},
+	{Name: ":path", Value: "/"},
+	{Name: "age", Value: "0"},
+	{Name: "content-disposition"},
+	{Name: "content-length", Value: "0"},
+	{Name: "cookie"},
+	{Name: "date"},
+	{Name: "etag"},
+	{Name: "if-modified-since"},
+	{Name: "if-none-match"},
+	{Name: "last-modified"},
+	{Name: "link"},
+	{Name: "location"},
+	{Name: "referer"},
+	{Name: "set-cookie"},
+	{Name: ":method", Value: "CONNECT"},
+	{Name: ":method", Value: "DELETE"},
+	{Name: ":method", Value: "GET"},
+	{Name: ":method", Value: "HEAD"},
+	{Name: ":method", Value: "OPTIONS"},
+	{Name: ":method", Value: "POST"},
+	{Name: ":method", Value: "PUT"},
+	{Name: ":scheme", Value: "http"},
+	{Name: ":scheme", Value: "https"},
+	{Name: ":status", Value: "103"},
+	{Name: ":status", Value: "200"},
+	{Name: ":status", Value: "304"},
+	{Name: ":status", Value: "404"},
+	{Name: ":status", Value: "503"},
+	{Name: "accept", Value: "*/*"},
+	{Name: "accept", Value: "application/dns-message"},
+	{Name: "accept-encoding", Value: "gzip, deflate, br"},
+	{Name: "accept-ranges", Value: "bytes"},
+	{Name: "access-control-allow-headers", Value: "cache-control"},
+	{Name: "access-control-allow-headers", Value: "content-type"},
+	{Name: "access-control-allow-origin", Value: "*"},
+	{Name: "cache-control", Value: "max-age=0"},
+	{Name: "cache-control", Value: "max-age=2592000"},
+	{Name: "cache-control", Value: "max-age=604800"},
+	{Name: "cache-control", Value: "no-cache"},
+	{Name: "cache-control", Value: "no-store"},
+	{Name: "cache-control", Value: "public, max-age=31536000"},
+	{Name: "content-encoding", Value: "br"},
+	{Name: "content-encoding", Value: "gzip"},
+	{Name: "content-type", Value: "application/dns-message"},
+	{Name: "content-type", Value: "application/javascript"},
+	{Name: "content-type", Value: "application/json"},
+	{Name: "content-type", Value: "application/x-www-form-urlencoded"},
+	{Name: "content-type", Value: "image/gif"},
+	{Name: "content-type", Value: "image/jpeg"},
+	{Name: "content-type", Value: "image/png"},
+	{Name: "content-type", Value: "text/css"},
+	{Name: "content-type", Value: "text/html; charset=utf-8"},
+	{Name: "content-type", Value: "text/plain"},
+	{Name: "content-type", Value: "text/plain;charset=utf-8"},
+	{Name: "range", Value: "bytes=0-"},
+	{Name: "strict-transport-security", Value: "max-age=31536000"},
+	{Name: "strict-transport-security", Value: "max-age=31536000; includesubdomains"},
+	{Name: "strict-transport-security", Value: "max-age=31536000; includesubdomains; preload"},
+	{Name: "vary", Value: "accept-encoding"},
+	{Name: "vary", Value: "origin"},
+	{Name: "x-content-type-options", Value: "nosniff"},
+	{Name: "x-xss-protection", Value: "1; mode=block"},
+	{Name: ":status", Value: "100"},
+	{Name: ":status", Value: "204"},
+	{Name: ":status", Value: "206"},
+	{Name: ":status", Value: "302"},
+	{Name: ":status", Value: "400"},
+	{Name: ":status", Value: "403"},
+	{Name: ":status", Value: "421"},
+	{Name: ":status", Value: "425"},
+	{Name: ":status", Value: "500"},
+	{Name: "accept-language"},
+	{Name: "access-control-allow-credentials", Value: "FALSE"},
+	{Name: "access-control-allow-credentials", Value: "TRUE"},
+	{Name: "access-control-allow-headers", Value: "*"},
+	{Name: "access-control-allow-methods", Value: "get"},
+	{Name: "access-control-allow-methods", Value: "get, post, options"},
+	{Name: "access-control-allow-methods", Value: "options"},
+	{Name: "access-control-expose-headers", Value: "content-length"},
+	{Name: "access-control-request-headers", Value: "content-type"},
+	{Name: "access-control-request-method", Value: "get"},
+	{Name: "access-control-request-method", Value: "post"},
+	{Name: "alt-svc", Value: "clear"},
+	{Name: "authorization"},
+	{Name: "content-security-policy", Value: "script-src 'none'; object-src 'none'; base-uri 'none'"},
+	{Name: "early-data", Value: "1"},
+	{Name: "expect-ct"},
+	{Name: "forwarded"},
+	{Name: "if-range"},
+	{Name: "origin"},
+	{Name: "purpose", Value: "prefetch"},
+	{Name: "server"},
+	{Name: "timing-allow-origin", Value: "*"},
+	{Name: "upgrade-insecure-requests", Value: "1"},
+	{Name: "user-agent"},
+	{Name: "x-forwarded-for"},
+	{Name: "x-frame-options", Value: "deny"},
+	{Name: "x-frame-options", Value: "sameorigin"},
+}
+
+// staticPairs 和 staticNames 静态表的反向索引, 同名取最小下标
+var (
+	staticPairs = make(map[[2]string]uint64, len(staticTable))
+	staticNames = make(map[string]uint64, len(staticTable))
+)
+
+func init() {
+	for i, f := range staticTable {
+		idx := uint64(i)
+		if _, ok := staticPairs[[2]string{f.Name, f.Value}]; !ok {
+			staticPairs[[2]string{f.Name, f.Value}] = idx
+		}
+		if _, ok := staticNames[f.Name]; !ok {
+			staticNames[f.Name] = idx
+		}
+	}
+}
+
+// appendVarInt 按 n 位前缀编码整数 (RFC 9204 4.1.1, 与 HPACK 相同), first 为前缀所在字节的高位
+func appendVarInt(dst []byte, n uint8, first byte, v uint64) []byte {
+	max := uint64(1)<<n - 1
+	if v < max {
+		return append(dst, first|byte(v))
+	}
+	dst = append(dst, first|byte(max))
+	v -= max
+	for v >= 128 {
+		dst = append(dst, byte(v&0x7f|0x80))
+		v >>= 7
+	}
+	return append(dst, byte(v))
+}
+
+// readVarInt 解码 n 位前缀的整数, 返回值和剩余数据; 超过 62 位时视为错误
+func readVarInt(n uint8, p []byte) (uint64, []byte, error) {
+	if len(p) == 0 {
+		return 0, p, fmt.Errorf("%w: truncated integer", ErrDecompressionFailed)
+	}
+	max := uint64(1)<<n - 1
+	v := uint64(p[0]) & max
+	p = p[1:]
+	if v < max {
+		return v, p, nil
+	}
+	for shift := uint(0); len(p) > 0; shift += 7 {
+		b := p[0]
+		p = p[1:]
+		if shift > 56 {
+			return 0, p, fmt.Errorf("%w: integer overflow", ErrDecompressionFailed)
+		}
+		v += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v, p, nil
+		}
+	}
+	return 0, p, fmt.Errorf("%w: truncated integer", ErrDecompressionFailed)
+}
+
+// AppendFieldSection 把 fields 编码为一个字段节追加到 dst. 不使用动态表, 编码没有状态, 可以并发调用
+func AppendFieldSection(dst []byte, fields ...HeaderField) []byte {
+	// Required Insert Count 和 Delta Base 都为 0
+	dst = append(dst, 0, 0)
+	for _, f := range fields {
+		dst = appendField(dst, f)
+	}
+	return dst
+}
+
+func appendField(dst []byte, f HeaderField) []byte {
+	// N 位要求中间节点不以索引形式转发敏感字段
+	var never byte
+	if f.Sensitive {
+		never = 0x20
+	} else if idx, ok := staticPairs[[2]string{f.Name, f.Value}]; ok {
+		// 索引字段行, T=1 表示静态表
+		return appendVarInt(dst, 6, 0xc0, idx)
+	}
+	if idx, ok := staticNames[f.Name]; ok {
+		// 名称引用静态表的字面量字段行: 01NT
+		dst = appendVarInt(dst, 4, 0x50|never, idx)
+		return appendString(dst, 7, 0, f.Value)
+	}
+	// 字面量名称的字面量字段行: 001NH
+	dst = appendString(dst, 3, 0x20|never>>1, f.Name)
+	return appendString(dst, 7, 0, f.Value)
+}
+
+// appendString 以 n 位长度前缀编码字符串, 长度前缀之上的一位为 Huffman 标志, first 为更高的位.
+// Huffman 编码更短时使用 Huffman
+func appendString(dst []byte, n uint8, first byte, s string) []byte {
+	if hl := hpack.HuffmanEncodedLen(s); hl < len(s) {
+		dst = appendVarInt(dst, n, first|1<<n, uint64(hl))
+		return hpack.AppendHuffmanString(dst, s)
+	}
+	dst = appendVarInt(dst, n, first, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// Decoder 字段节解码器. 不使用动态表, 零值可用, 非并发安全
+type Decoder struct {
+	// MaxStringLength 单个名称或值的最大长度, 0 表示不限制
+	MaxStringLength int
+	// MaxFieldSectionSize 字段节的最大大小 (按 HeaderField.Size 累计), 0 表示不限制
+	MaxFieldSectionSize uint64
+
+	buf []byte
+}
+
+// Decode 解码一个完整的字段节. 引用动态表的表示都视为错误, 因为本端通告的表容量为 0.
+// 超过 MaxFieldSectionSize 时返回 ErrFieldSectionTooLarge, 其余错误都包装 ErrDecompressionFailed
+func (d *Decoder) Decode(p []byte) ([]HeaderField, error) {
+	ric, p, err := readVarInt(8, p)
+	if err != nil {
+		return nil, err
+	}
+	if _, p, err = readVarInt(7, p); err != nil {
+		return nil, err
+	}
+	if ric != 0 {
+		return nil, fmt.Errorf("%w: dynamic table reference with zero capacity", ErrDecompressionFailed)
+	}
+
+	var fields []HeaderField
+	var total uint64
+	for len(p) > 0 {
+		b := p[0]
+		var f HeaderField
+		switch {
+		case b&0x80 != 0:
+			// 索引字段行
+			if b&0x40 == 0 {
+				return nil, fmt.Errorf("%w: dynamic table reference", ErrDecompressionFailed)
+			}
+			var idx uint64
+			if idx, p, err = readVarInt(6, p); err != nil {
+				return nil, err
+			}
+			if f, err = staticEntry(idx); err != nil {
+				return nil, err
+			}
+		case b&0xc0 == 0x40:
+			// 名称引用的字面量字段行
+			if b&0x10 == 0 {
+				return nil, fmt.Errorf("%w: dynamic table reference", ErrDecompressionFailed)
+			}
+			var idx uint64
+			if idx, p, err = readVarInt(4, p); err != nil {
+				return nil, err
+			}
+			nf, err := staticEntry(idx)
+			if err != nil {
+				return nil, err
+			}
+			f.Name = nf.Name
+			if f.Value, p, err = d.readString(7, p); err != nil {
+				return nil, err
+			}
+			f.Sensitive = b&0x20 != 0
+		case b&0xe0 == 0x20:
+			// 字面量名称的字面量字段行
+			if f.Name, p, err = d.readString(3, p); err != nil {
+				return nil, err
+			}
+			if f.Value, p, err = d.readString(7, p); err != nil {
+				return nil, err
+			}
+			f.Sensitive = b&0x10 != 0
+		default:
+			// 0001 和 0000 开头的 post-base 表示只能引用动态表
+			return nil, fmt.Errorf("%w: dynamic table reference", ErrDecompressionFailed)
+		}
+		total += uint64(f.Size())
+		if d.MaxFieldSectionSize > 0 && total > d.MaxFieldSectionSize {
+			return nil, ErrFieldSectionTooLarge
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func staticEntry(idx uint64) (HeaderField, error) {
+	if idx >= uint64(len(staticTable)) {
+		return HeaderField{}, fmt.Errorf("%w: invalid static index %d", ErrDecompressionFailed, idx)
+	}
+	return staticTable[idx], nil
+}
+
+// readString 读取 n 位长度前缀的字符串, 前缀之上的一位为 Huffman 标志
+func (d *Decoder) readString(n uint8, p []byte) (string, []byte, error) {
+	if len(p) == 0 {
+		return "", p, fmt.Errorf("%w: truncated string", ErrDecompressionFailed)
+	}
+	huffman := p[0]&(1<<n) != 0
+	l, p, err := readVarInt(n, p)
+	if err != nil {
+		return "", p, err
+	}
+	if l > uint64(len(p)) {
+		return "", p, fmt.Errorf("%w: truncated string", ErrDecompressionFailed)
+	}
+	raw := p[:l]
+	p = p[l:]
+	if !huffman {
+		if d.MaxStringLength > 0 && len(raw) > d.MaxStringLength {
+			return "", p, ErrFieldSectionTooLarge
+		}
+		return string(raw), p, nil
+	}
+	d.buf, err = hpack.AppendHuffmanDecode(d.buf[:0], raw, d.MaxStringLength)
+	if err != nil {
+		if errors.Is(err, hpack.ErrStringTooLong) {
+			return "", p, ErrFieldSectionTooLarge
+		}
+		return "", p, fmt.Errorf("%w: %v", ErrDecompressionFailed, err)
+	}
+	return string(d.buf), p, nil
+}
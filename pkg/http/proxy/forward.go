@@ -0,0 +1,304 @@
+package proxy
+
+/*
+	正向代理: 转发 absolute-form 请求, 以 CONNECT 建立 TCP 隧道;
+	支持客户端 Basic 认证、访问控制、串联上游代理, 以及按共享缓存语义缓存转发的响应
+*/
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/cache"
+	"github.com/narcilee7/http-stack/pkg/http/client"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/server"
+	"github.com/narcilee7/http-stack/pkg/log"
+	"github.com/narcilee7/http-stack/pkg/tcp"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// ErrPrivateAddress 目标解析到被 Rules.DenyPrivate 拒绝的内网地址
+var ErrPrivateAddress = errors.New("proxy: destination is a private address")
+
+// DefaultName Via 头部中默认的代理名称
+const DefaultName = "http-stack"
+
+// copyBufferSize 转发消息体使用的缓冲大小
+const copyBufferSize = 32 << 10
+
+// hopHeaders 只对单跳连接有意义的头部, 转发时去掉 (RFC 9110 7.6.1)
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// Forward 正向代理处理器, 作为 server.Server 的 Handler 使用
+type Forward struct {
+	// Transport 转发 absolute-form 请求的传输层, 为空时按 Upstream、Dial 和 Cache 创建
+	Transport client.RoundTripper
+	// Upstream 上游代理, 非空时请求和隧道都经它转发; URL 中的用户信息作为 Basic 凭据
+	Upstream *url.URL
+	// Dial 连接目标或上游代理, 为空时使用默认的 tcp.Dialer
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Rules 访问控制规则
+	Rules Rules
+	// Authenticate 非空时要求客户端以 Proxy-Authorization 提供 Basic 凭据, 返回 false 时回复 407
+	Authenticate func(user, pass string) bool
+	// Realm 407 响应中的认证域, 默认 "proxy"
+	Realm string
+	// Cache 非空时缓存转发的响应, 应按共享缓存配置 (Private 为 false); 只在 Transport 为空时生效
+	Cache *cache.HTTPCache
+	// TunnelIdleTimeout 隧道两个方向都没有数据超过该时间后关闭, 0 表示不限制
+	TunnelIdleTimeout time.Duration
+	// Name 写入 Via 头部的代理名称, 默认 DefaultName; 请求的 Via 中已有该名称时视为转发环路
+	Name string
+	// Logger 记录转发失败和隧道结束, 为空时使用 log.Default()
+	Logger *log.Logger
+
+	once      sync.Once
+	transport client.RoundTripper
+}
+
+// defaultDialer Dial 为空时使用的拨号器
+var defaultDialer = &tcp.Dialer{}
+
+func (f *Forward) name() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return DefaultName
+}
+
+func (f *Forward) getTransport() client.RoundTripper {
+	f.once.Do(func() {
+		f.transport = f.Transport
+		if f.transport != nil {
+			return
+		}
+		t := &client.Transport{Pool: client.NewPool(client.PoolConfig{Dial: f.dial})}
+		if f.Upstream != nil {
+			t.Proxy = client.ProxyURL(f.Upstream)
+		}
+		f.transport = t
+		if f.Cache != nil {
+			f.transport = &client.CacheTransport{Transport: t, Cache: f.Cache}
+		}
+	})
+	return f.transport
+}
+
+// dial 建立到目标或上游代理的连接; 直连时检查实际的对端地址
+func (f *Forward) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	dial := f.Dial
+	if dial == nil {
+		dial = defaultDialer.DialFunc()
+	}
+	c, err := dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if f.Rules.DenyPrivate && f.Upstream == nil {
+		if ip, ok := addrIP(c.RemoteAddr()); ok && isPrivate(ip) {
+			c.Close()
+			return nil, fmt.Errorf("%w: %s (%s)", ErrPrivateAddress, addr, ip)
+		}
+	}
+	return c, nil
+}
+
+// ServeHTTP 实现 server.Handler
+func (f *Forward) ServeHTTP(w server.ResponseWriter, req *message.Request) {
+	if !f.Rules.allowClient(server.RemoteAddr(req)) {
+		proxyError(w, common.StatusForbidden, "client not allowed")
+		return
+	}
+	if f.Authenticate != nil && !f.authenticated(req) {
+		realm := f.Realm
+		if realm == "" {
+			realm = "proxy"
+		}
+		w.Header().Set("Proxy-Authenticate", `Basic realm="`+realm+`"`)
+		proxyError(w, common.StatusProxyAuthRequired, "proxy authentication required")
+		return
+	}
+	if req.Method == common.MethodConnect {
+		f.connect(w, req)
+		return
+	}
+	if req.URL == nil || !req.URL.IsAbs() || req.URL.Host == "" {
+		proxyError(w, common.StatusBadRequest, "request target is not an absolute URL")
+		return
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		proxyError(w, common.StatusBadRequest, "unsupported scheme "+req.URL.Scheme)
+		return
+	}
+	if !f.Rules.allowHost(req.URL.Hostname()) {
+		proxyError(w, common.StatusForbidden, "destination not allowed")
+		return
+	}
+	if f.looped(req.Header) {
+		proxyError(w, common.StatusLoopDetected, "forwarding loop detected")
+		return
+	}
+	f.forward(w, req)
+}
+
+// authenticated 校验 Proxy-Authorization 中的 Basic 凭据
+func (f *Forward) authenticated(req *message.Request) bool {
+	scheme, cred, ok := strings.Cut(req.Header.Get("Proxy-Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(cred))
+	if err != nil {
+		return false
+	}
+	user, pass, ok := strings.Cut(string(b), ":")
+	return ok && f.Authenticate(user, pass)
+}
+
+// looped 报告 Via 中是否已经出现本代理
+func (f *Forward) looped(h common.Header) bool {
+	name := f.name()
+	for _, v := range h.Values("Via") {
+		for _, hop := range strings.Split(v, ",") {
+			fields := strings.Fields(hop)
+			if len(fields) >= 2 && strings.EqualFold(fields[1], name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// forward 转发 absolute-form 请求并把响应写回客户端
+func (f *Forward) forward(w server.ResponseWriter, req *message.Request) {
+	out := req.Clone(req.Context())
+	out.Host = req.URL.Host
+	out.Close = false
+	removeHopHeaders(out.Header)
+	addVia(out.Header, req.Proto, f.name())
+	if out.ContentLength == 0 {
+		out.Body = message.NoBody
+	}
+
+	resp, err := f.getTransport().RoundTrip(out)
+	if err != nil {
+		log.Or(f.Logger).Warn("proxy: forward failed", log.String("method", req.Method), log.String("url", req.URL.Redacted()), log.Err(err))
+		proxyError(w, errorStatus(err), err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	removeHopHeaders(resp.Header)
+	addVia(resp.Header, resp.Proto, f.name())
+	h := w.Header()
+	for k, vs := range resp.Header {
+		h[k] = vs
+	}
+	if resp.ContentLength >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	tw, _ := w.(server.TrailerWriter)
+	if tw != nil && resp.ContentLength < 0 {
+		// 上游的 trailer 在消息体读完后才知道, 长度未知时提前取得 trailer 使响应以分块编码发送
+		tw.Trailer()
+	}
+	w.WriteHeader(resp.StatusCode)
+	if err := copyBody(w, resp.Body, resp.ContentLength < 0); err != nil {
+		return
+	}
+	if tw != nil {
+		for k, vs := range resp.Trailer {
+			tw.Trailer()[k] = vs
+		}
+	}
+}
+
+// removeHopHeaders 删除逐跳头部, 包括 Connection 中列出的头部
+func removeHopHeaders(h common.Header) {
+	for _, v := range h.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// addVia 追加本代理的 Via 记录 (RFC 9110 7.6.3)
+func addVia(h common.Header, proto, name string) {
+	version := strings.TrimPrefix(proto, "HTTP/")
+	if version == "" {
+		version = "1.1"
+	}
+	h.Add("Via", version+" "+name)
+}
+
+// copyBody 复制消息体, flush 为 true 时每次写出后立即发送, 用于长度未知的流式响应
+func copyBody(w io.Writer, r io.Reader, flush bool) error {
+	fl, _ := w.(server.Flusher)
+	if !flush {
+		fl = nil
+	}
+	buf := utils.GetBytes(copyBufferSize)
+	defer utils.PutBytes(buf)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if fl != nil {
+				fl.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// errorStatus 按转发错误选择返回给客户端的状态码
+func errorStatus(err error) int {
+	var ne net.Error
+	switch {
+	case errors.Is(err, ErrPrivateAddress):
+		return common.StatusForbidden
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		return common.StatusGatewayTimeout
+	}
+	return common.StatusBadGateway
+}
+
+// proxyError 写出代理自身产生的错误响应
+func proxyError(w server.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write([]byte(msg + "\n"))
+}
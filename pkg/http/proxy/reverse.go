@@ -0,0 +1,283 @@
+package proxy
+
+/*
+	反向代理: 把请求轮询转发到一组上游, 补充 X-Forwarded-* 头部并流式写回响应;
+	连接失败的上游在一段时间内被跳过, 幂等请求换下一个上游重试
+*/
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/client"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/server"
+	"github.com/narcilee7/http-stack/pkg/log"
+)
+
+// ErrNoUpstream Reverse 没有配置上游
+var ErrNoUpstream = errors.New("proxy: no upstream configured")
+
+// DefaultFailTimeout 上游连接失败后被跳过的默认时长
+const DefaultFailTimeout = 10 * time.Second
+
+// Reverse 反向代理处理器, 作为 server.Server 的 Handler 使用
+type Reverse struct {
+	// Upstreams 上游地址, 如 http://10.0.0.1:8080/api; 请求路径拼接在上游路径之后
+	Upstreams []*url.URL
+	// Transport 转发请求的传输层, 为空时使用默认的 client.Transport
+	Transport client.RoundTripper
+	// PreserveHost 为 true 时保留客户端的 Host, 否则使用上游的主机名
+	PreserveHost bool
+	// Scheme 客户端访问本代理使用的协议, 写入 X-Forwarded-Proto; 默认 "http"
+	Scheme string
+	// Rewrite 非空时在转发前修改发往上游的请求
+	Rewrite func(out *message.Request)
+	// FailTimeout 上游连接失败后被跳过的时长, 0 时使用 DefaultFailTimeout
+	FailTimeout time.Duration
+	// Name 写入 Via 头部的代理名称, 默认 DefaultName
+	Name string
+	// Logger 记录转发失败, 为空时使用 log.Default()
+	Logger *log.Logger
+
+	once      sync.Once
+	transport client.RoundTripper
+	next      atomic.Uint64
+	mu        sync.Mutex
+	// down 上游 (按下标) 恢复可用的时刻
+	down map[int]time.Time
+}
+
+// NewReverse 创建转发到 upstreams 的反向代理, 地址不含协议时按 http 处理
+func NewReverse(upstreams ...string) (*Reverse, error) {
+	if len(upstreams) == 0 {
+		return nil, ErrNoUpstream
+	}
+	r := &Reverse{}
+	for _, s := range upstreams {
+		if !strings.Contains(s, "://") {
+			s = "http://" + s
+		}
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		if u.Host == "" {
+			return nil, errors.New("proxy: upstream " + s + " has no host")
+		}
+		r.Upstreams = append(r.Upstreams, u)
+	}
+	return r, nil
+}
+
+func (r *Reverse) getTransport() client.RoundTripper {
+	r.once.Do(func() {
+		r.transport = r.Transport
+		if r.transport == nil {
+			r.transport = &client.Transport{}
+		}
+	})
+	return r.transport
+}
+
+func (r *Reverse) name() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return DefaultName
+}
+
+// pick 轮询选出下一个可用的上游, skip 中的上游本次请求已经失败过; 都不可用时仍按轮询返回一个
+func (r *Reverse) pick(skip map[int]bool) int {
+	n := len(r.Upstreams)
+	start := int(r.next.Add(1)-1) % n
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fallback := -1
+	for i := range n {
+		idx := (start + i) % n
+		if skip[idx] {
+			continue
+		}
+		if until, ok := r.down[idx]; ok && now.Before(until) {
+			if fallback < 0 {
+				fallback = idx
+			}
+			continue
+		}
+		return idx
+	}
+	return fallback
+}
+
+// markDown 在 FailTimeout 内跳过上游 idx
+func (r *Reverse) markDown(idx int) {
+	d := r.FailTimeout
+	if d <= 0 {
+		d = DefaultFailTimeout
+	}
+	r.mu.Lock()
+	if r.down == nil {
+		r.down = make(map[int]time.Time)
+	}
+	r.down[idx] = time.Now().Add(d)
+	r.mu.Unlock()
+}
+
+// ServeHTTP 实现 server.Handler
+func (r *Reverse) ServeHTTP(w server.ResponseWriter, req *message.Request) {
+	if len(r.Upstreams) == 0 {
+		proxyError(w, common.StatusBadGateway, ErrNoUpstream.Error())
+		return
+	}
+	// 请求体只能读一次, 没有请求体或可以重放的幂等请求才换上游重试
+	replayable := common.IsIdempotent(req.Method) && (req.ContentLength == 0 || req.GetBody != nil)
+	skip := make(map[int]bool)
+	for {
+		idx := r.pick(skip)
+		if idx < 0 {
+			proxyError(w, common.StatusBadGateway, "no upstream available")
+			return
+		}
+		out, err := r.outgoing(req, r.Upstreams[idx], len(skip) > 0)
+		if err != nil {
+			proxyError(w, common.StatusBadGateway, err.Error())
+			return
+		}
+		resp, err := r.getTransport().RoundTrip(out)
+		if err == nil {
+			r.writeResponse(w, resp)
+			return
+		}
+		log.Or(r.Logger).Warn("proxy: upstream request failed", log.String("upstream", r.Upstreams[idx].Host),
+			log.String("method", req.Method), log.String("uri", req.URL.RequestURI()), log.Err(err))
+		if isDialError(err) {
+			r.markDown(idx)
+		}
+		skip[idx] = true
+		if !replayable || !isDialError(err) || len(skip) == len(r.Upstreams) || req.Context().Err() != nil {
+			proxyError(w, errorStatus(err), err.Error())
+			return
+		}
+	}
+}
+
+// outgoing 构造发往上游的请求; retry 为 true 时从 GetBody 重新取得请求体
+func (r *Reverse) outgoing(req *message.Request, up *url.URL, retry bool) (*message.Request, error) {
+	out := req.Clone(req.Context())
+	u := *up
+	u.Path = joinPath(up.Path, req.URL.Path)
+	u.RawPath = ""
+	switch {
+	case up.RawQuery == "":
+		u.RawQuery = req.URL.RawQuery
+	case req.URL.RawQuery != "":
+		u.RawQuery = up.RawQuery + "&" + req.URL.RawQuery
+	}
+	u.User = nil
+	out.URL = &u
+	out.Close = false
+	if r.PreserveHost {
+		out.Host = req.HostHeader()
+	} else {
+		out.Host = up.Host
+	}
+	out.Header.Del("Host")
+	removeHopHeaders(out.Header)
+	addVia(out.Header, req.Proto, r.name())
+	r.addForwarded(out.Header, req)
+	switch {
+	case out.ContentLength == 0:
+		out.Body = message.NoBody
+	case retry:
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		out.Body = body
+	}
+	if r.Rewrite != nil {
+		r.Rewrite(out)
+	}
+	return out, nil
+}
+
+// addForwarded 追加 X-Forwarded-For, 设置 X-Forwarded-Host 和 X-Forwarded-Proto
+func (r *Reverse) addForwarded(h common.Header, req *message.Request) {
+	if addr := server.RemoteAddr(req); addr != nil {
+		ip, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			ip = addr.String()
+		}
+		if prior := h.Values("X-Forwarded-For"); len(prior) > 0 {
+			ip = strings.Join(prior, ", ") + ", " + ip
+		}
+		h.Set("X-Forwarded-For", ip)
+	}
+	if h.Get("X-Forwarded-Host") == "" {
+		h.Set("X-Forwarded-Host", req.HostHeader())
+	}
+	if h.Get("X-Forwarded-Proto") == "" {
+		scheme := r.Scheme
+		if scheme == "" {
+			scheme = "http"
+		}
+		h.Set("X-Forwarded-Proto", scheme)
+	}
+}
+
+// writeResponse 把上游响应写回客户端, 长度未知的响应边读边发送
+func (r *Reverse) writeResponse(w server.ResponseWriter, resp *message.Response) {
+	defer resp.Body.Close()
+	removeHopHeaders(resp.Header)
+	addVia(resp.Header, resp.Proto, r.name())
+	h := w.Header()
+	for k, vs := range resp.Header {
+		h[k] = vs
+	}
+	if resp.ContentLength >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	tw, _ := w.(server.TrailerWriter)
+	if tw != nil && resp.ContentLength < 0 {
+		tw.Trailer()
+	}
+	w.WriteHeader(resp.StatusCode)
+	if err := copyBody(w, resp.Body, resp.ContentLength < 0); err != nil {
+		return
+	}
+	if tw != nil {
+		for k, vs := range resp.Trailer {
+			tw.Trailer()[k] = vs
+		}
+	}
+}
+
+// isDialError 报告错误是否发生在连接上游时, 此时上游一定没有收到请求
+func isDialError(err error) bool {
+	var oe *net.OpError
+	return errors.As(err, &oe) && oe.Op == "dial" && !errors.Is(err, context.Canceled)
+}
+
+// joinPath 拼接上游路径和请求路径, 保证两者之间恰好一个 /
+func joinPath(base, p string) string {
+	switch {
+	case base == "" || base == "/":
+		if p == "" {
+			return "/"
+		}
+		return p
+	case p == "" || p == "/":
+		return base
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(p, "/")
+}
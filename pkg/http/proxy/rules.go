@@ -0,0 +1,103 @@
+package proxy
+
+/*
+	访问控制: 按客户端网段、目标主机和 CONNECT 端口放行或拒绝, 并可拒绝连接内网地址
+*/
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Rules 正向代理的访问控制规则, 零值放行除 CONNECT 到非 443 端口外的所有请求
+type Rules struct {
+	// Clients 允许使用代理的客户端网段, 为空时不限制
+	Clients []netip.Prefix
+	// AllowHosts 非空时只允许访问其中的主机; "*.example.com" 匹配 example.com 的所有子域名
+	AllowHosts []string
+	// DenyHosts 禁止访问的主机, 写法同 AllowHosts, 优先于 AllowHosts
+	DenyHosts []string
+	// ConnectPorts CONNECT 允许的目标端口, 为空时只允许 443
+	ConnectPorts []int
+	// DenyPrivate 为 true 时拒绝访问回环、私有、链路本地和未指定地址.
+	// 直连时按连接建立后的实际对端地址检查, 域名解析到内网地址同样被拒绝; 经上游代理时只能检查 IP 字面量
+	DenyPrivate bool
+}
+
+// allowClient 报告 addr 是否在允许的客户端网段内, 地址未知时只在不限制客户端时放行
+func (r *Rules) allowClient(addr net.Addr) bool {
+	if len(r.Clients) == 0 {
+		return true
+	}
+	ip, ok := addrIP(addr)
+	if !ok {
+		return false
+	}
+	return slices.ContainsFunc(r.Clients, func(p netip.Prefix) bool { return p.Contains(ip) })
+}
+
+// allowHost 报告是否允许访问 host (不含端口)
+func (r *Rules) allowHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if matchHost(r.DenyHosts, host) {
+		return false
+	}
+	if len(r.AllowHosts) > 0 && !matchHost(r.AllowHosts, host) {
+		return false
+	}
+	if r.DenyPrivate {
+		if ip, err := netip.ParseAddr(host); err == nil && isPrivate(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// allowConnect 报告是否允许以 CONNECT 访问 host:port
+func (r *Rules) allowConnect(host, port string) bool {
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return false
+	}
+	ports := r.ConnectPorts
+	if len(ports) == 0 {
+		ports = []int{443}
+	}
+	return slices.Contains(ports, n) && r.allowHost(host)
+}
+
+// matchHost 报告 host 是否匹配 patterns 中的任一项
+func matchHost(patterns []string, host string) bool {
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if suffix, ok := strings.CutPrefix(p, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == p {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrivate 报告 ip 是否为不应从代理访问的内网地址
+func isPrivate(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// addrIP 取出连接地址中的 IP
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	if addr == nil {
+		return netip.Addr{}, false
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ap.Addr().Unmap(), true
+}
@@ -0,0 +1,193 @@
+package proxy
+
+/*
+	CONNECT 隧道: HTTP/1.1 接管连接后在两端之间双向转发, HTTP/2 和 HTTP/3 在流上转发;
+	配置了上游代理时先向上游发送 CONNECT 再转发
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/client"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http1"
+	"github.com/narcilee7/http-stack/pkg/http/server"
+	"github.com/narcilee7/http-stack/pkg/log"
+	"github.com/narcilee7/http-stack/pkg/tcp"
+)
+
+// connect 处理 CONNECT 请求
+func (f *Forward) connect(w server.ResponseWriter, req *message.Request) {
+	addr := ""
+	if req.URL != nil {
+		addr = req.URL.Host
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		proxyError(w, common.StatusBadRequest, "CONNECT target must be host:port")
+		return
+	}
+	if !f.Rules.allowConnect(host, port) {
+		proxyError(w, common.StatusForbidden, "destination not allowed")
+		return
+	}
+	target, err := f.dialTunnel(req.Context(), addr)
+	if err != nil {
+		log.Or(f.Logger).Warn("proxy: tunnel dial failed", log.String("target", addr), log.Err(err))
+		proxyError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	if hj, ok := w.(server.Hijacker); ok {
+		nc, brw, err := hj.Hijack()
+		if err == nil {
+			f.tunnelConn(nc, brw, target)
+			return
+		}
+		if !errors.Is(err, server.ErrHijackUnsupported) {
+			target.Close()
+			return
+		}
+	}
+	f.tunnelStream(w, req, target)
+}
+
+// dialTunnel 建立到 addr 的隧道连接, 配置了上游代理时经上游的 CONNECT 建立
+func (f *Forward) dialTunnel(ctx context.Context, addr string) (net.Conn, error) {
+	if f.Upstream == nil {
+		return f.dial(ctx, "tcp", addr)
+	}
+	up := f.Upstream
+	upAddr := up.Host
+	if up.Port() == "" {
+		port := "80"
+		if up.Scheme == "https" {
+			port = "443"
+		}
+		upAddr = net.JoinHostPort(up.Hostname(), port)
+	}
+	conn, err := f.dial(ctx, "tcp", upAddr)
+	if err != nil {
+		return nil, err
+	}
+	// 上下文取消时通过设置过期时间打断阻塞的握手
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if up.Scheme == "https" {
+		tc := tls.Client(conn, &tls.Config{ServerName: up.Hostname()})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+
+	creq, err := message.NewRequestWithContext(ctx, common.MethodConnect, "", nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	creq.Host = addr
+	if up.User != nil {
+		pass, _ := up.User.Password()
+		creq.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(up.User.Username()+":"+pass)))
+	}
+	bw := bufio.NewWriter(conn)
+	br := bufio.NewReader(conn)
+	err = http1.WriteRequest(bw, creq)
+	var resp *message.Response
+	if err == nil {
+		resp, err = http1.ReadResponse(br, creq)
+	}
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		conn.Close()
+		return nil, &client.ProxyConnectError{Proxy: upAddr, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	conn.SetDeadline(time.Time{})
+	return withBuffered(conn, br), nil
+}
+
+// tunnelConn 在接管的 HTTP/1.1 连接与目标之间转发, 返回时两个连接都已关闭
+func (f *Forward) tunnelConn(nc net.Conn, brw *bufio.ReadWriter, target net.Conn) {
+	brw.Writer.WriteString("HTTP/1.1 200 Connection Established\r\n\r\n")
+	if err := brw.Writer.Flush(); err != nil {
+		nc.Close()
+		target.Close()
+		return
+	}
+	// 客户端可能紧随 CONNECT 发出了数据 (如 TLS ClientHello), 已在读缓冲中
+	src := withBuffered(nc, brw.Reader)
+	stats, err := tcp.Proxy(tcpConn(src), tcpConn(target), &tcp.ProxyOptions{IdleTimeout: f.TunnelIdleTimeout})
+	log.Or(f.Logger).Debug("proxy: tunnel closed", log.String("target", target.RemoteAddr().String()),
+		log.Int64("sent", stats.AToB), log.Int64("received", stats.BToA), log.Duration("duration", stats.Duration), log.Err(err))
+}
+
+// tunnelStream 在 HTTP/2 或 HTTP/3 的 CONNECT 流与目标之间转发: 请求体发往目标, 目标的数据作为响应体
+func (f *Forward) tunnelStream(w server.ResponseWriter, req *message.Request, target net.Conn) {
+	defer target.Close()
+	w.WriteHeader(common.StatusOK)
+	if fl, ok := w.(server.Flusher); ok {
+		fl.Flush()
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(target, req.Body)
+		tcp.CloseWrite(target)
+	}()
+	copyBody(w, target, true)
+	target.Close()
+	req.Body.Close()
+	<-done
+}
+
+// bufferedConn 先返回读缓冲中剩余数据的连接
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// Unwrap 返回底层连接, 供半关闭穿透
+func (c *bufferedConn) Unwrap() net.Conn { return c.Conn }
+
+// withBuffered 在 br 中还有未读数据时让 c 先返回这些数据
+func withBuffered(c net.Conn, br *bufio.Reader) net.Conn {
+	n := br.Buffered()
+	if n == 0 {
+		return c
+	}
+	b, _ := br.Peek(n)
+	return &bufferedConn{Conn: c, r: io.MultiReader(bytes.NewReader(bytes.Clone(b)), c)}
+}
+
+// tcpConn 将 c 适配为 tcp.Proxy 使用的 *tcp.Conn
+func tcpConn(c net.Conn) *tcp.Conn {
+	if tc, ok := c.(*tcp.Conn); ok {
+		return tc
+	}
+	return tcp.NewConn(c)
+}
@@ -0,0 +1,225 @@
+package server
+
+/*
+	服务端缓存中间件: 在处理器之前加一层共享 HTTP 缓存 (RFC 9111), 命中时不调用处理器;
+	未命中时边写出边记录可存储的响应, 过期条目以条件请求交给处理器验证
+*/
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/cache"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+// CacheHandler 以 Cache 缓存 Handler 的响应. 升级请求和 Range 请求不经过缓存;
+// 非安全方法先使请求目标失效再交给 Handler
+type CacheHandler struct {
+	// Handler 被缓存的处理器
+	Handler Handler
+	// Cache 使用的 HTTP 缓存, 可与其他 CacheHandler 或客户端 CacheTransport 共享
+	Cache *cache.HTTPCache
+	// Scheme 缓存键中使用的协议, 服务端收到的请求 URL 不带协议; TLS 服务器应设为 "https",
+	// 避免与共享同一缓存的明文端点互相命中. 默认 "http"
+	Scheme string
+}
+
+// cacheRequest 返回用于缓存键的请求, URL 补上 Scheme; 交给处理器的仍是原请求
+func (h *CacheHandler) cacheRequest(req *message.Request) *message.Request {
+	if h.Scheme == "" || req.URL == nil || req.URL.Scheme != "" {
+		return req
+	}
+	creq := *req
+	u := *req.URL
+	u.Scheme = h.Scheme
+	creq.URL = &u
+	return &creq
+}
+
+// ServeHTTP 实现 Handler
+func (h *CacheHandler) ServeHTTP(w ResponseWriter, req *message.Request) {
+	c := h.Cache
+	creq := h.cacheRequest(req)
+	if req.Method != common.MethodGet && req.Method != common.MethodHead {
+		if !common.IsSafe(req.Method) {
+			// 处理器的结果在写出前无法得知, 提前失效只会多一次未命中
+			c.Delete(creq)
+		}
+		h.Handler.ServeHTTP(w, req)
+		return
+	}
+	reqCC := cache.ParseCacheControl(req.Header.Values("Cache-Control"))
+	if reqCC.Has("no-store") || req.Header.Has("Upgrade") || req.Header.Has("Range") {
+		h.Handler.ServeHTTP(w, req)
+		return
+	}
+
+	entry := c.Get(creq)
+	if entry != nil {
+		switch state, age := c.Freshness(creq, entry); state {
+		case cache.Fresh:
+			writeEntry(w, req, entry, age, "HIT")
+			return
+		case cache.Stale:
+			writeEntry(w, req, entry, age, "STALE")
+			return
+		case cache.StaleRevalidate:
+			writeEntry(w, req, entry, age, "STALE")
+			// 请求的上下文在处理器返回后取消, 后台验证不受影响
+			go h.revalidate(req.Clone(context.WithoutCancel(req.Context())), entry)
+			return
+		}
+	}
+	if reqCC.Has("only-if-cached") {
+		w.WriteHeader(common.StatusGatewayTimeout)
+		return
+	}
+	cw := &cacheWriter{w: w, req: creq, cache: c, entry: entry, reqTime: c.Now(), base: w.Header().Clone()}
+	hreq := req
+	if entry != nil {
+		hreq = entry.Conditional(req)
+	}
+	h.Handler.ServeHTTP(cw, hreq)
+	cw.finish()
+}
+
+// revalidate 在后台以条件请求验证条目, 处理器的输出被丢弃
+func (h *CacheHandler) revalidate(req *message.Request, entry *cache.Entry) {
+	dw := &discardWriter{header: make(common.Header)}
+	cw := &cacheWriter{w: dw, req: h.cacheRequest(req), cache: h.Cache, entry: entry, reqTime: h.Cache.Now(), base: make(common.Header)}
+	h.Handler.ServeHTTP(cw, entry.Conditional(req))
+	cw.finish()
+}
+
+// writeEntry 以缓存条目响应
+func writeEntry(w ResponseWriter, req *message.Request, e *cache.Entry, age time.Duration, xcache string) {
+	h := w.Header()
+	for k, vs := range e.ResponseHeader(age, xcache) {
+		h[k] = vs
+	}
+	h.Set("Content-Length", strconv.Itoa(len(e.Body)))
+	w.WriteHeader(e.StatusCode)
+	if req.Method != common.MethodHead {
+		w.Write(e.Body)
+	}
+}
+
+// cacheWriter 包装处理器的 ResponseWriter: 记录可存储的响应; 验证时遇到 304 或可按 stale-if-error 掩盖的 5xx 改用缓存条目响应
+type cacheWriter struct {
+	w       ResponseWriter
+	req     *message.Request
+	cache   *cache.HTTPCache
+	entry   *cache.Entry
+	reqTime time.Time
+	// base 调用处理器前的响应头部, 改用缓存条目响应时丢弃处理器设置的头部
+	base common.Header
+
+	wroteHeader bool
+	status      int
+	header      common.Header
+	// useEntry 非空时丢弃处理器的输出, 以缓存条目和该 X-Cache 值响应
+	useEntry string
+	store    bool
+	buf      bytes.Buffer
+}
+
+func (cw *cacheWriter) Header() common.Header { return cw.w.Header() }
+
+func (cw *cacheWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	if code >= 100 && code < 200 {
+		cw.w.WriteHeader(code)
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = code
+	cw.header = cw.w.Header().Clone()
+	if cw.entry != nil {
+		if code == common.StatusNotModified {
+			cw.useEntry = "REVALIDATED"
+			return
+		}
+		if _, ok := cw.cache.UsableOnError(cw.entry); ok && code >= 500 {
+			cw.useEntry = "STALE"
+			return
+		}
+	}
+	cw.store = cw.cache.Storable(cw.req, code, cw.header)
+	cw.w.Header().Set(cache.XCacheHeader, "MISS")
+	cw.w.WriteHeader(code)
+}
+
+func (cw *cacheWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(common.StatusOK)
+	}
+	if cw.useEntry != "" {
+		return len(p), nil
+	}
+	if cw.store {
+		if int64(cw.buf.Len()+len(p)) > cw.cache.EntryLimit() {
+			cw.store = false
+			cw.buf = bytes.Buffer{}
+		} else {
+			cw.buf.Write(p)
+		}
+	}
+	return cw.w.Write(p)
+}
+
+// Flush 实现 Flusher
+func (cw *cacheWriter) Flush() {
+	if cw.useEntry != "" {
+		return
+	}
+	if fl, ok := cw.w.(Flusher); ok {
+		fl.Flush()
+	}
+}
+
+// finish 在处理器返回后存储或更新缓存, 需要时以缓存条目写出响应
+func (cw *cacheWriter) finish() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(common.StatusOK)
+	}
+	c := cw.cache
+	if cw.useEntry != "" {
+		h := cw.w.Header()
+		clear(h)
+		for k, vs := range cw.base {
+			h[k] = vs
+		}
+	}
+	switch {
+	case cw.useEntry == "REVALIDATED":
+		cw.entry.Merge(cw.header, cw.reqTime, c.Now())
+		c.Put(cw.req, cw.entry)
+		age := cache.CurrentAge(cw.entry.Header, cw.entry.RequestTime, cw.entry.ResponseTime, c.Now())
+		writeEntry(cw.w, cw.req, cw.entry, age, cw.useEntry)
+	case cw.useEntry == "STALE":
+		age, _ := c.UsableOnError(cw.entry)
+		writeEntry(cw.w, cw.req, cw.entry, age, cw.useEntry)
+	case cw.store:
+		e := cache.NewEntry(cw.req, cw.status, message.StatusLine(cw.status), "HTTP/1.1", cw.header, cw.reqTime)
+		e.Body = cw.buf.Bytes()
+		e.ResponseTime = c.Now()
+		c.Put(cw.req, e)
+	case cw.entry != nil:
+		c.Delete(cw.req)
+	}
+}
+
+// discardWriter 丢弃输出的 ResponseWriter, 用于后台验证
+type discardWriter struct {
+	header common.Header
+}
+
+func (d *discardWriter) Header() common.Header       { return d.header }
+func (d *discardWriter) WriteHeader(int)             {}
+func (d *discardWriter) Write(p []byte) (int, error) { return len(p), nil }
@@ -1 +1,440 @@
 package server
+
+/*
+	HTTP/1.1 连接处理: 读取请求、调用处理器、写出响应, 支持 keep-alive、分块编码、trailer 和连接接管
+*/
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http1"
+	"github.com/narcilee7/http-stack/pkg/tcp"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+var (
+	// ErrBodyNotAllowed 该状态码或请求方法不允许响应体
+	ErrBodyNotAllowed = errors.New("server: request method or response status code does not allow body")
+	// ErrContentLength 写出的字节数超过了声明的 Content-Length
+	ErrContentLength = errors.New("server: wrote more than the declared Content-Length")
+)
+
+const (
+	// bufferBodySize 处理器结束前缓冲的响应体大小, 全部在缓冲内时以 Content-Length 发送
+	bufferBodySize = 4 << 10
+	// maxDrainBytes 处理器未读完请求体时, 为复用连接最多读掉的字节数
+	maxDrainBytes = 256 << 10
+)
+
+// http1Conn 一条 HTTP/1.1 连接
+type http1Conn struct {
+	srv *Server
+	c   *tcp.Conn
+	br  *bufio.Reader
+	bw  *bufio.Writer
+
+	// hijacked 接管方关闭连接时关闭; 在此之前 ServeConn 不能返回, 否则 tcp.Server 会关闭连接
+	hijacked chan struct{}
+
+	mu       sync.Mutex
+	idle     bool
+	stopping bool
+}
+
+// serveHTTP1 在连接上提供 HTTP/1.1 服务, 返回连接是否被处理器接管
+func (s *Server) serveHTTP1(c *tcp.Conn, br *bufio.Reader, bw *bufio.Writer) (hijacked bool) {
+	hc := &http1Conn{srv: s, c: c, br: br, bw: bw, hijacked: make(chan struct{})}
+	if !s.trackConn(hc, true) {
+		return false
+	}
+	defer s.trackConn(hc, false)
+	defer func() {
+		select {
+		case <-hc.hijacked:
+			hijacked = true
+		default:
+		}
+	}()
+	for hc.setIdle(true) {
+		c.SetReadDeadline(deadline(s.IdleTimeout))
+		if _, err := hc.br.Peek(1); err != nil {
+			return
+		}
+		if !hc.setIdle(false) {
+			return
+		}
+		c.SetReadDeadline(deadline(s.ReadHeaderTimeout))
+		req, err := http1.ReadRequest(hc.br)
+		if err != nil {
+			hc.writeError(err)
+			return
+		}
+		c.SetReadDeadline(time.Time{})
+		if s.h2cEnabled(c) && isH2CUpgrade(req) {
+			up, err := hc.upgradeH2C(req)
+			if err != nil {
+				// 消息体已读出一部分, 不能再按 HTTP/1.1 交给处理器
+				hc.writeError(err)
+				return
+			}
+			if up != nil {
+				s.trackConn(hc, false)
+				s.serveHTTP2(c, hc.br, hc.bw, up)
+				return
+			}
+		}
+		c.SetWriteDeadline(deadline(s.WriteTimeout))
+		// 解析出的头部在请求处理期间一直占用内存, 计入连接的预算; 超出时账户关闭连接
+		size := headerSize(req.Header)
+		if c.Memory().Reserve(size) != nil {
+			return
+		}
+		ok := hc.serveRequest(req)
+		c.Memory().Release(size)
+		if !ok {
+			return
+		}
+	}
+	return
+}
+
+// headerSize 估算解析后的头部占用的内存, 每个字段另计 32 字节开销 (与 HPACK 的计法相同, RFC 7541 4.1)
+func headerSize(h common.Header) int64 {
+	var n int64
+	for k, vs := range h {
+		for _, v := range vs {
+			n += int64(len(k)+len(v)) + 32
+		}
+	}
+	return n
+}
+
+// setIdle 切换空闲状态, 连接正在关闭时返回 false
+func (hc *http1Conn) setIdle(idle bool) bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.idle = idle
+	return !hc.stopping
+}
+
+// startShutdown 空闲连接立即中断读取, 处理中的连接在响应后关闭
+func (hc *http1Conn) startShutdown() {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.stopping = true
+	if hc.idle {
+		hc.c.SetReadDeadline(time.Unix(1, 0))
+	}
+}
+
+func (hc *http1Conn) shuttingDown() bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	return hc.stopping
+}
+
+// writeError 对无法解析的请求回复错误状态, 连接随后关闭
+func (hc *http1Conn) writeError(err error) {
+	var code int
+	var ne net.Error
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, net.ErrClosed), errors.As(err, &ne) && ne.Timeout():
+		return
+	case errors.Is(err, http1.ErrHeaderTooLarge):
+		code = common.StatusRequestHeaderFieldsTooLarge
+	default:
+		code = common.StatusBadRequest
+	}
+	resp := message.NewResponse(code)
+	resp.Header.Set("Date", utils.HTTPDate())
+	resp.Close = true
+	hc.c.SetWriteDeadline(deadline(time.Second))
+	http1.WriteResponse(hc.bw, resp)
+}
+
+// serveRequest 处理一个请求, 返回连接是否可以继续使用
+func (hc *http1Conn) serveRequest(req *message.Request) bool {
+	ctx, cancel := context.WithCancel(withRemoteAddr(context.Background(), hc.c.RemoteAddr()))
+	defer cancel()
+	req = req.WithContext(ctx)
+	w := &http1Response{conn: hc, req: req, header: hc.srv.responseHeader(), contentLength: -1}
+	if req.Proto == "HTTP/1.1" && strings.EqualFold(req.Header.Get("Expect"), "100-continue") {
+		req.Body = &expectContinueReader{ReadCloser: req.Body, w: w}
+	}
+	if !hc.callHandler(w, req) {
+		return false
+	}
+	if w.hijacked {
+		<-hc.hijacked
+		return false
+	}
+	if err := w.finish(); err != nil {
+		return false
+	}
+	if w.closeAfter || req.Close {
+		return false
+	}
+	// 读掉未读完的请求体才能读取下一个请求
+	if ec, ok := req.Body.(*expectContinueReader); ok && !ec.sent {
+		return false
+	}
+	n, err := io.Copy(io.Discard, io.LimitReader(req.Body, maxDrainBytes+1))
+	return err == nil && n <= maxDrainBytes
+}
+
+// callHandler 调用处理器, panic 时回复 500 (若头部尚未发出) 并返回 false
+func (hc *http1Conn) callHandler(w *http1Response, req *message.Request) (ok bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			hc.srv.logPanic(req, v, debug.Stack())
+			if !w.committed && !w.hijacked {
+				resp := message.NewResponse(common.StatusInternalServerError)
+				resp.Header.Set("Date", utils.HTTPDate())
+				resp.Close = true
+				http1.WriteResponse(hc.bw, resp)
+			}
+			ok = false
+		}
+	}()
+	hc.srv.Handler.ServeHTTP(w, req)
+	return true
+}
+
+// expectContinueReader 在处理器首次读取请求体时发送 100 Continue
+type expectContinueReader struct {
+	io.ReadCloser
+	w    *http1Response
+	sent bool
+}
+
+func (r *expectContinueReader) Read(p []byte) (int, error) {
+	if !r.sent {
+		r.sent = true
+		if !r.w.committed {
+			r.w.conn.bw.WriteString("HTTP/1.1 100 Continue\r\n\r\n")
+			r.w.conn.bw.Flush()
+		}
+	}
+	return r.ReadCloser.Read(p)
+}
+
+// http1Response HTTP/1.1 的 ResponseWriter, 同时实现 Flusher、Hijacker 和 TrailerWriter
+type http1Response struct {
+	conn    *http1Conn
+	req     *message.Request
+	header  common.Header
+	trailer common.Header
+
+	status      int
+	wroteHeader bool
+	// committed 状态行和头部已写入连接
+	committed bool
+	hijacked  bool
+	// buf 提交前缓冲的响应体
+	buf []byte
+	// contentLength 声明的长度, -1 表示未声明
+	contentLength int64
+	written       int64
+	chunked       *http1.ChunkedWriter
+	closeAfter    bool
+}
+
+func (w *http1Response) Header() common.Header { return w.header }
+
+// Trailer 返回在响应体之后发送的 trailer, 调用后响应使用分块编码
+func (w *http1Response) Trailer() common.Header {
+	if w.trailer == nil {
+		w.trailer = make(common.Header)
+	}
+	return w.trailer
+}
+
+func (w *http1Response) WriteHeader(code int) {
+	if w.hijacked || w.wroteHeader {
+		return
+	}
+	if code >= 100 && code < 200 && code != common.StatusSwitchingProtocols {
+		// 1xx 信息性响应立即发出, 不影响最终响应
+		w.conn.bw.WriteString("HTTP/1.1 " + message.StatusLine(code) + "\r\n")
+		w.header.Write(w.conn.bw)
+		w.conn.bw.WriteString("\r\n")
+		w.conn.bw.Flush()
+		return
+	}
+	w.wroteHeader = true
+	w.status = code
+	if v := w.header.Get("Content-Length"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			w.contentLength = n
+		} else {
+			w.header.Del("Content-Length")
+		}
+	}
+}
+
+// bodyAllowed 状态码是否允许响应体
+func (w *http1Response) bodyAllowed() bool {
+	return common.BodyAllowedForStatus(w.status)
+}
+
+func (w *http1Response) Write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, ErrHijacked
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(common.StatusOK)
+	}
+	if !w.bodyAllowed() {
+		return 0, ErrBodyNotAllowed
+	}
+	if w.contentLength >= 0 && w.written+int64(len(p)) > w.contentLength {
+		return 0, ErrContentLength
+	}
+	w.written += int64(len(p))
+	if w.req.Method == common.MethodHead {
+		return len(p), nil
+	}
+	if !w.committed {
+		if len(w.buf)+len(p) <= bufferBodySize {
+			w.buf = append(w.buf, p...)
+			return len(p), nil
+		}
+		if err := w.commit(false); err != nil {
+			return 0, err
+		}
+	}
+	return w.writeBody(p)
+}
+
+func (w *http1Response) writeBody(p []byte) (int, error) {
+	if w.chunked != nil {
+		return w.chunked.Write(p)
+	}
+	return w.conn.bw.Write(p)
+}
+
+// commit 写出状态行和头部并确定消息体的分帧方式; final 表示处理器已结束, 响应体全部在缓冲中
+func (w *http1Response) commit(final bool) error {
+	w.committed = true
+	h := w.header
+	h.Del("Transfer-Encoding")
+	head := w.req.Method == common.MethodHead
+	switch {
+	case !w.bodyAllowed() || w.contentLength >= 0:
+	case w.trailer != nil && w.req.Proto != "HTTP/1.0":
+		h.Set("Transfer-Encoding", "chunked")
+	case final && (!head || w.written > 0):
+		h.Set("Content-Length", strconv.FormatInt(w.written, 10))
+	case head:
+	case w.req.Proto == "HTTP/1.0":
+		// HTTP/1.0 没有分块编码, 以关闭连接结束消息体
+		w.closeAfter = true
+	default:
+		h.Set("Transfer-Encoding", "chunked")
+	}
+	if h.Get("Transfer-Encoding") == "chunked" && !head {
+		w.chunked = http1.NewChunkedWriter(w.conn.bw)
+	}
+	if w.req.Close || w.conn.shuttingDown() || h.HasToken("Connection", "close") {
+		w.closeAfter = true
+	}
+	if w.closeAfter {
+		h.Set("Connection", "close")
+	} else if w.req.Proto == "HTTP/1.0" {
+		h.Set("Connection", "keep-alive")
+	}
+	bw := w.conn.bw
+	bw.WriteString("HTTP/1.1 " + message.StatusLine(w.status) + "\r\n")
+	if err := h.Write(bw); err != nil {
+		return err
+	}
+	if _, err := bw.WriteString("\r\n"); err != nil {
+		return err
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) > 0 {
+		if _, err := w.writeBody(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush 发出已写入的头部和响应体
+func (w *http1Response) Flush() {
+	if w.hijacked {
+		return
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(common.StatusOK)
+	}
+	if !w.committed {
+		w.commit(false)
+	}
+	w.conn.bw.Flush()
+}
+
+// finish 在处理器返回后结束响应
+func (w *http1Response) finish() error {
+	if !w.wroteHeader {
+		w.WriteHeader(common.StatusOK)
+	}
+	if !w.committed {
+		if err := w.commit(true); err != nil {
+			return err
+		}
+	}
+	if w.chunked != nil {
+		w.chunked.Trailer = w.trailer
+		if err := w.chunked.Close(); err != nil {
+			return err
+		}
+	}
+	if w.contentLength >= 0 && w.written < w.contentLength && w.req.Method != common.MethodHead {
+		// 响应体短于声明的长度, 客户端只能通过连接关闭发现
+		w.closeAfter = true
+	}
+	return w.conn.bw.Flush()
+}
+
+// Hijack 接管底层连接, 缓冲中未读的数据保留在返回的 Reader 中
+func (w *http1Response) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.hijacked {
+		return nil, nil, ErrHijacked
+	}
+	w.hijacked = true
+	if err := w.conn.bw.Flush(); err != nil {
+		return nil, nil, err
+	}
+	c := w.conn.c
+	c.SetDeadline(time.Time{})
+	hc := &hijackedConn{Conn: c, done: w.conn.hijacked}
+	return hc, bufio.NewReadWriter(w.conn.br, w.conn.bw), nil
+}
+
+// hijackedConn 被接管的连接, 关闭时通知 serveHTTP1 返回
+type hijackedConn struct {
+	net.Conn
+	once sync.Once
+	done chan struct{}
+}
+
+func (c *hijackedConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
+
+// Unwrap 返回底层连接, 供 tcp.CloseWrite 等半关闭操作穿透
+func (c *hijackedConn) Unwrap() net.Conn { return c.Conn }
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http1"
+	"github.com/narcilee7/http-stack/pkg/tcp"
+)
+
+func TestHTTP1BuffersChargedToMemoryBudget(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	budget := &tcp.MemoryBudget{}
+	used := make(chan int64, 1)
+	h := HandlerFunc(func(w ResponseWriter, req *message.Request) {
+		used <- budget.Used()
+	})
+	srv := &Server{Handler: h, MemoryBudget: budget}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http1.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if n := <-used; n < tcp.DefaultReadBufferSize+tcp.DefaultWriteBufferSize {
+		t.Fatalf("budget used during request = %d, want at least the connection buffers", n)
+	}
+
+	c.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for budget.Used() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("budget used after close = %d, want 0", budget.Used())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHTTP1HeadersChargedToMemoryBudget(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	budget := &tcp.MemoryBudget{PerConn: tcp.DefaultReadBufferSize + tcp.DefaultWriteBufferSize + 1024}
+	called := make(chan struct{}, 1)
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, req *message.Request) { called <- struct{}{} }), MemoryBudget: budget}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	big := strings.Repeat("x", 2048)
+	if _, err := c.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nX-Big: " + big + "\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("read %d bytes, want the connection closed", n)
+	}
+	select {
+	case <-called:
+		t.Fatal("handler ran although the headers exceeded the connection budget")
+	default:
+	}
+	if budget.Exceeded() == 0 {
+		t.Fatal("budget did not record the rejected reservation")
+	}
+}
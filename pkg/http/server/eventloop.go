@@ -0,0 +1,196 @@
+package server
+
+/*
+	事件循环模式的 HTTP/1.1 服务: 连接由 tcp.EventLoop 承载, 空闲连接不占用 goroutine.
+	收到的数据送入 http1.RequestParser, 请求完整 (含消息体) 后才在新的 goroutine 中调用处理器,
+	响应缓冲后一次写出. 同一连接上的请求按序处理, 流水线中后续的请求在前一个响应写出后再解析.
+	不支持 HTTP/2、Hijack、流式响应和 Expect: 100-continue
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"runtime/debug"
+	"sync"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http1"
+	"github.com/narcilee7/http-stack/pkg/tcp"
+)
+
+// NewEventHandler 返回以事件循环模式提供 s 的 HTTP/1.1 服务的回调, 使用 s 的 Handler、AltSvc、OnPanic 和 Logger:
+//
+//	el := &tcp.EventLoop{Handler: srv.NewEventHandler()}
+//	el.Serve(ln)
+func (s *Server) NewEventHandler() tcp.EventHandler {
+	return &eventHandler{srv: s}
+}
+
+type eventHandler struct {
+	srv *Server
+}
+
+// eventConn 附加在 tcp.EventConn 上的解析状态
+type eventConn struct {
+	mu     sync.Mutex
+	parser http1.RequestParser
+	// busy 有请求正在处理, 其间收到的数据只缓冲不解析
+	busy   bool
+	closed bool
+}
+
+func (h *eventHandler) OnOpen(c *tcp.EventConn) {
+	c.SetContext(&eventConn{})
+}
+
+func (h *eventHandler) OnData(c *tcp.EventConn, data []byte) {
+	ec := c.Context().(*eventConn)
+	ec.mu.Lock()
+	// 解析器缓冲的数据计入连接的内存预算, 超出时关闭连接
+	if c.Memory().Reserve(int64(len(data))) != nil {
+		ec.closed = true
+		ec.mu.Unlock()
+		c.Close()
+		return
+	}
+	ec.parser.Feed(data)
+	h.next(c, ec)
+}
+
+func (h *eventHandler) OnClose(c *tcp.EventConn, err error) {
+	ec := c.Context().(*eventConn)
+	ec.mu.Lock()
+	ec.closed = true
+	ec.mu.Unlock()
+}
+
+// next 没有请求在处理时取出下一个完整的请求并在新的 goroutine 中处理; 调用时持有 ec.mu, 返回前释放
+func (h *eventHandler) next(c *tcp.EventConn, ec *eventConn) {
+	if ec.busy || ec.closed {
+		ec.mu.Unlock()
+		return
+	}
+	before := ec.parser.Buffered()
+	req, err := ec.parser.Next()
+	c.Memory().Release(int64(before - ec.parser.Buffered()))
+	if err != nil {
+		ec.closed = true
+		ec.mu.Unlock()
+		code := common.StatusBadRequest
+		switch {
+		case errors.Is(err, http1.ErrHeaderTooLarge):
+			code = common.StatusRequestHeaderFieldsTooLarge
+		case errors.Is(err, http1.ErrBodyTooLarge):
+			code = common.StatusRequestEntityTooLarge
+		}
+		resp := message.NewResponse(code)
+		resp.Header = h.srv.responseHeader()
+		resp.Close = true
+		h.write(c, resp)
+		c.CloseAfterWrite()
+		return
+	}
+	if req == nil {
+		ec.mu.Unlock()
+		return
+	}
+	ec.busy = true
+	ec.mu.Unlock()
+	go h.serve(c, ec, req)
+}
+
+func (h *eventHandler) serve(c *tcp.EventConn, ec *eventConn, req *message.Request) {
+	ctx, cancel := context.WithCancel(withRemoteAddr(context.Background(), c.RemoteAddr()))
+	req = req.WithContext(ctx)
+	w := &eventResponse{header: h.srv.responseHeader()}
+	ok := h.callHandler(w, req)
+	cancel()
+
+	w.WriteHeader(common.StatusOK)
+	resp := message.NewResponse(w.code)
+	resp.Header = w.sent
+	resp.Request = req
+	resp.Close = req.Close || !ok
+	if w.body.Len() > 0 {
+		resp.Body = io.NopCloser(bytes.NewReader(w.body.Bytes()))
+	} else {
+		resp.Body = message.NoBody
+	}
+	resp.ContentLength = int64(w.body.Len())
+	if err := h.write(c, resp); err != nil || resp.Close {
+		ec.mu.Lock()
+		ec.closed = true
+		ec.mu.Unlock()
+		c.CloseAfterWrite()
+		return
+	}
+
+	ec.mu.Lock()
+	ec.busy = false
+	h.next(c, ec)
+}
+
+// callHandler 调用处理器, panic 时改为回复 500 并返回 false
+func (h *eventHandler) callHandler(w *eventResponse, req *message.Request) (ok bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			h.srv.logPanic(req, v, debug.Stack())
+			w.reset(common.StatusInternalServerError, h.srv.responseHeader())
+			ok = false
+		}
+	}()
+	h.srv.Handler.ServeHTTP(w, req)
+	return true
+}
+
+// write 序列化响应并交给连接发送
+func (h *eventHandler) write(c *tcp.EventConn, resp *message.Response) error {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	if err := http1.WriteResponse(bw, resp); err != nil {
+		return err
+	}
+	_, err := c.Write(buf.Bytes())
+	return err
+}
+
+// eventResponse 缓冲整个响应的 ResponseWriter
+type eventResponse struct {
+	header common.Header
+	// sent WriteHeader 时的头部快照, 之后对 header 的修改不再生效
+	sent        common.Header
+	code        int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *eventResponse) Header() common.Header { return w.header }
+
+func (w *eventResponse) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.code = code
+	w.sent = w.header.Clone()
+}
+
+func (w *eventResponse) Write(p []byte) (int, error) {
+	w.WriteHeader(common.StatusOK)
+	if !common.BodyAllowedForStatus(w.code) {
+		return 0, ErrBodyNotAllowed
+	}
+	return w.body.Write(p)
+}
+
+// reset 丢弃已写出的内容, 改为以 code 回复
+func (w *eventResponse) reset(code int, header common.Header) {
+	w.header = header
+	w.body.Reset()
+	w.wroteHeader = false
+	w.WriteHeader(code)
+}
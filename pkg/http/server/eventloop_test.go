@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http1"
+	"github.com/narcilee7/http-stack/pkg/tcp"
+)
+
+func startEventLoop(t *testing.T, h Handler) net.Conn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	el := &tcp.EventLoop{Handler: (&Server{Handler: h}).NewEventHandler(), Loops: 1}
+	errc := make(chan error, 1)
+	go func() { errc <- el.Serve(ln) }()
+	t.Cleanup(func() { el.Close() })
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	select {
+	case err := <-errc:
+		if errors.Is(err, tcp.ErrEventLoopUnsupported) {
+			t.Skip(err)
+		}
+		t.Fatal(err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	return c
+}
+
+func TestEventLoopServesPipelinedRequests(t *testing.T) {
+	c := startEventLoop(t, HandlerFunc(func(w ResponseWriter, req *message.Request) {
+		body, _ := io.ReadAll(req.Body)
+		w.Header().Set("X-Path", req.URL.Path)
+		w.Write([]byte(req.Method + ":" + string(body)))
+	}))
+
+	raw := "GET /one HTTP/1.1\r\nHost: x\r\n\r\n" +
+		"POST /two HTTP/1.1\r\nHost: x\r\nContent-Length: 4\r\n\r\nbody" +
+		"GET /three HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"
+	// 分段写出, 让请求跨越多次 OnData
+	for i := 0; i < len(raw); i += 7 {
+		if _, err := c.Write([]byte(raw[i:min(i+7, len(raw))])); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	br := bufio.NewReader(c)
+	for _, want := range []struct{ path, body string }{{"/one", "GET:"}, {"/two", "POST:body"}, {"/three", "GET:"}} {
+		resp, err := http1.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("response for %s: %v", want.path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != 200 || resp.Header.Get("X-Path") != want.path || string(body) != want.body {
+			t.Fatalf("response = %d %s %q, want 200 %s %q", resp.StatusCode, resp.Header.Get("X-Path"), body, want.path, want.body)
+		}
+		if resp.Header.Get("Date") == "" {
+			t.Fatal("response has no Date header")
+		}
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("read after Connection: close = %v, want EOF", err)
+	}
+}
+
+func TestEventLoopRejectsMalformedRequest(t *testing.T) {
+	c := startEventLoop(t, HandlerFunc(func(w ResponseWriter, req *message.Request) {
+		t.Error("handler called for a malformed request")
+	}))
+	if _, err := c.Write([]byte("POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(c)
+	resp, err := http1.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	if resp.StatusCode != 400 || !resp.Close {
+		t.Fatalf("response = %d close=%v, want 400 with Connection: close", resp.StatusCode, resp.Close)
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("read after 400 = %v, want EOF", err)
+	}
+}
+
+func TestEventLoopHandlerPanic(t *testing.T) {
+	srv := &Server{OnPanic: func(*message.Request, any, []byte) {}}
+	srv.Handler = HandlerFunc(func(w ResponseWriter, req *message.Request) {
+		w.Write([]byte("partial"))
+		panic("boom")
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	el := &tcp.EventLoop{Handler: srv.NewEventHandler(), Loops: 1}
+	go el.Serve(ln)
+	defer el.Close()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	c.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	resp, err := http1.ReadResponse(bufio.NewReader(c), nil)
+	if errors.Is(err, io.EOF) {
+		t.Skip("event loop not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 500 || len(body) != 0 {
+		t.Fatalf("response = %d %q, want an empty 500", resp.StatusCode, body)
+	}
+}
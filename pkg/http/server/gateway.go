@@ -0,0 +1,238 @@
+package server
+
+/*
+	网关常用的中间件: RateLimitHandler 令牌桶限流, RequestIDHandler 为请求分配标识, CORSHandler 处理跨域请求
+*/
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// rateLimitIdle 按客户端限流时, 空闲超过该时长 (且桶已回满) 的客户端状态被清理
+const rateLimitIdle = time.Minute
+
+// RateLimitHandler 以令牌桶限制 Handler 的请求速率, 超出时返回 429 和 Retry-After
+type RateLimitHandler struct {
+	// Handler 被限流的处理器
+	Handler Handler
+	// Rate 每秒允许的请求数
+	Rate float64
+	// Burst 桶容量, 0 时取 max(Rate, 1)
+	Burst int
+	// PerClient 按客户端 IP 分别限流, 否则所有请求共用一个桶
+	PerClient bool
+
+	once      sync.Once
+	global    *utils.RateLimiter
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+	idle      time.Duration
+}
+
+type clientLimiter struct {
+	limiter *utils.RateLimiter
+	seen    time.Time
+}
+
+func (h *RateLimitHandler) init() {
+	h.once.Do(func() {
+		h.global = utils.NewRateLimiter(h.Rate, h.Burst)
+		h.clients = make(map[string]*clientLimiter)
+		// 清理前桶必须已回满, 否则清理等于给客户端补发令牌
+		h.idle = max(rateLimitIdle, time.Duration(float64(h.global.Burst())/h.Rate*float64(time.Second)))
+	})
+}
+
+// ServeHTTP 实现 Handler
+func (h *RateLimitHandler) ServeHTTP(w ResponseWriter, req *message.Request) {
+	h.init()
+	if !h.limiter(req).Allow() {
+		common.SetRetryAfter(w.Header(), time.Duration(float64(time.Second)/h.Rate))
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(common.StatusTooManyRequests)
+		w.Write([]byte("rate limit exceeded\n"))
+		return
+	}
+	h.Handler.ServeHTTP(w, req)
+}
+
+// limiter 返回请求对应的令牌桶, 顺便清理空闲的客户端
+func (h *RateLimitHandler) limiter(req *message.Request) *utils.RateLimiter {
+	if !h.PerClient {
+		return h.global
+	}
+	key := ""
+	if addr := RemoteAddr(req); addr != nil {
+		key = addr.String()
+		if host, _, err := net.SplitHostPort(key); err == nil {
+			key = host
+		}
+	}
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if now.Sub(h.lastSweep) > h.idle {
+		for k, c := range h.clients {
+			if now.Sub(c.seen) > h.idle {
+				delete(h.clients, k)
+			}
+		}
+		h.lastSweep = now
+	}
+	c := h.clients[key]
+	if c == nil {
+		c = &clientLimiter{limiter: utils.NewRateLimiter(h.Rate, h.Burst)}
+		h.clients[key] = c
+	}
+	c.seen = now
+	return c.limiter
+}
+
+// DefaultRequestIDHeader RequestIDHandler 默认使用的头部
+const DefaultRequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen 沿用客户端请求 ID 的最大长度, 更长的视为无效并重新生成
+const maxRequestIDLen = 128
+
+// RequestIDHandler 沿用请求中的请求 ID, 没有或无效时生成一个, 写入转给 Handler 的请求和响应头部
+type RequestIDHandler struct {
+	// Handler 被包装的处理器
+	Handler Handler
+	// Header 请求 ID 所在的头部, 为空时使用 DefaultRequestIDHeader
+	Header string
+}
+
+// ServeHTTP 实现 Handler
+func (h *RequestIDHandler) ServeHTTP(w ResponseWriter, req *message.Request) {
+	name := h.Header
+	if name == "" {
+		name = DefaultRequestIDHeader
+	}
+	id := req.Header.Get(name)
+	if !validRequestID(id) {
+		var b [16]byte
+		rand.Read(b[:])
+		id = hex.EncodeToString(b[:])
+		r2 := req.WithContext(req.Context())
+		r2.Header = req.Header.Clone()
+		if r2.Header == nil {
+			r2.Header = make(common.Header)
+		}
+		r2.Header.Set(name, id)
+		req = r2
+	}
+	w.Header().Set(name, id)
+	h.Handler.ServeHTTP(w, req)
+}
+
+// validRequestID 只接受长度有限的可见 ASCII 字符, 避免日志注入
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// DefaultCORSMethods CORSHandler 默认允许的方法
+var DefaultCORSMethods = []string{common.MethodGet, common.MethodHead, common.MethodPost}
+
+// CORSHandler 按 Fetch 标准的 CORS 协议应答预检请求, 并为允许的来源添加跨域响应头
+type CORSHandler struct {
+	// Handler 处理非预检请求的处理器
+	Handler Handler
+	// AllowedOrigins 允许的来源, 如 "https://example.com"; "*" 允许任意来源, 为空时等同于 "*"
+	AllowedOrigins []string
+	// AllowedMethods 允许的方法, 为空时使用 DefaultCORSMethods
+	AllowedMethods []string
+	// AllowedHeaders 允许的请求头, 为空时允许预检请求中列出的所有头部
+	AllowedHeaders []string
+	// ExposedHeaders 允许脚本读取的响应头
+	ExposedHeaders []string
+	// AllowCredentials 允许携带 cookie 等凭据; 此时响应回显具体来源而不是 "*"
+	AllowCredentials bool
+	// MaxAge 预检结果的缓存秒数, 0 表示不发送
+	MaxAge int
+}
+
+// ServeHTTP 实现 Handler
+func (h *CORSHandler) ServeHTTP(w ResponseWriter, req *message.Request) {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		h.Handler.ServeHTTP(w, req)
+		return
+	}
+	hdr := w.Header()
+	hdr.Add("Vary", "Origin")
+	allowOrigin, ok := h.allowOrigin(origin)
+	preflight := req.Method == common.MethodOptions && req.Header.Has("Access-Control-Request-Method")
+	if !preflight {
+		if ok {
+			hdr.Set("Access-Control-Allow-Origin", allowOrigin)
+			if h.AllowCredentials {
+				hdr.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if len(h.ExposedHeaders) > 0 {
+				hdr.Set("Access-Control-Expose-Headers", strings.Join(h.ExposedHeaders, ", "))
+			}
+		}
+		h.Handler.ServeHTTP(w, req)
+		return
+	}
+
+	hdr.Add("Vary", "Access-Control-Request-Method")
+	hdr.Add("Vary", "Access-Control-Request-Headers")
+	methods := h.AllowedMethods
+	if len(methods) == 0 {
+		methods = DefaultCORSMethods
+	}
+	// 不允许的预检请求也返回 204, 只是不带许可头部, 由浏览器拒绝实际请求
+	if ok && slices.Contains(methods, req.Header.Get("Access-Control-Request-Method")) {
+		hdr.Set("Access-Control-Allow-Origin", allowOrigin)
+		hdr.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(h.AllowedHeaders) > 0 {
+			hdr.Set("Access-Control-Allow-Headers", strings.Join(h.AllowedHeaders, ", "))
+		} else if reqHeaders := req.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+			hdr.Set("Access-Control-Allow-Headers", reqHeaders)
+		}
+		if h.AllowCredentials {
+			hdr.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if h.MaxAge > 0 {
+			hdr.Set("Access-Control-Max-Age", strconv.Itoa(h.MaxAge))
+		}
+	}
+	w.WriteHeader(common.StatusNoContent)
+}
+
+// allowOrigin 返回 Access-Control-Allow-Origin 的值, 来源不被允许时 ok 为 false
+func (h *CORSHandler) allowOrigin(origin string) (string, bool) {
+	if len(h.AllowedOrigins) == 0 || slices.Contains(h.AllowedOrigins, "*") {
+		if h.AllowCredentials {
+			return origin, true
+		}
+		return "*", true
+	}
+	for _, o := range h.AllowedOrigins {
+		if strings.EqualFold(o, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,122 @@
+package server
+
+/*
+	明文 HTTP/2 (h2c): 识别以连接前言开头的连接 (prior knowledge), 以及 HTTP/1.1 的 Upgrade: h2c 升级
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http2"
+	"github.com/narcilee7/http-stack/pkg/tcp"
+)
+
+// maxH2CUpgradeBody 随升级请求一起读入内存的请求体上限, 更大的请求按 HTTP/1.1 处理
+const maxH2CUpgradeBody = 64 << 10
+
+// h2cUpgrade 升级前的 HTTP/1.1 请求和它携带的 HTTP2-Settings
+type h2cUpgrade struct {
+	req      *message.Request
+	settings []http2.Setting
+}
+
+// h2cEnabled 明文连接上是否接受 h2c
+func (s *Server) h2cEnabled(c *tcp.Conn) bool {
+	return s.H2C && !s.DisableHTTP2 && c.TLS() == nil
+}
+
+// sniffPreface 判断连接是否以 HTTP/2 连接前言开头. 只读取区分所需的字节,
+// 不会为了凑满前言长度而阻塞较短的 HTTP/1.1 请求
+func (s *Server) sniffPreface(c *tcp.Conn, br *bufio.Reader) bool {
+	c.SetReadDeadline(deadline(s.IdleTimeout))
+	defer c.SetReadDeadline(time.Time{})
+	for n := 1; ; {
+		p, err := br.Peek(n)
+		if err != nil || string(p) != http2.ClientPreface[:n] {
+			return false
+		}
+		if n == len(http2.ClientPreface) {
+			return true
+		}
+		n = min(max(n+1, br.Buffered()), len(http2.ClientPreface))
+	}
+}
+
+// isH2CUpgrade 请求是否要求升级到 h2c (RFC 7540 3.2)
+func isH2CUpgrade(req *message.Request) bool {
+	return req.Proto == "HTTP/1.1" &&
+		req.Header.HasToken("Upgrade", http2.NextProtoCleartext) &&
+		req.Header.HasToken("Connection", "Upgrade") &&
+		req.Header.HasToken("Connection", "HTTP2-Settings") &&
+		len(req.Header.Values("HTTP2-Settings")) == 1
+}
+
+// upgradeH2C 读入升级请求的消息体并回复 101. HTTP2-Settings 不合法或消息体过大时
+// 返回 nil, nil, 请求按 HTTP/1.1 处理; 读消息体或写 101 失败时返回错误, 请求已无法完整交给处理器, 连接须关闭
+func (hc *http1Conn) upgradeH2C(req *message.Request) (*h2cUpgrade, error) {
+	if req.ContentLength < 0 || req.ContentLength > maxH2CUpgradeBody {
+		return nil, nil
+	}
+	raw := strings.TrimRight(req.Header.Get("HTTP2-Settings"), "=")
+	payload, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, nil
+	}
+	settings, err := http2.ParseSettings(payload)
+	if err != nil {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	hc.c.SetWriteDeadline(deadline(hc.srv.WriteTimeout))
+	hc.bw.WriteString("HTTP/1.1 " + message.StatusLine(common.StatusSwitchingProtocols) + "\r\n")
+	hc.bw.WriteString("Connection: Upgrade\r\nUpgrade: " + http2.NextProtoCleartext + "\r\n\r\n")
+	if err := hc.bw.Flush(); err != nil {
+		return nil, err
+	}
+	hc.c.SetWriteDeadline(time.Time{})
+
+	for _, k := range []string{"Connection", "Upgrade", "HTTP2-Settings", "Keep-Alive", "Proxy-Connection"} {
+		req.Header.Del(k)
+	}
+	req.Proto = "HTTP/2.0"
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if len(body) == 0 {
+		req.Body = message.NoBody
+	}
+	req.ContentLength = int64(len(body))
+	return &h2cUpgrade{req: req, settings: settings}, nil
+}
+
+// startUpgradeStream 应用 HTTP2-Settings (视为已确认的 SETTINGS, 不回复 ACK),
+// 并把升级请求作为已半关闭 (远端) 的流 1 交给处理器
+func (c *h2Conn) startUpgradeStream(up *h2cUpgrade) error {
+	if err := c.applySettings(up.settings); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	st := &h2Stream{conn: c, id: 1, cancel: cancel, state: http2.StateHalfClosedRemote, declLen: up.req.ContentLength,
+		prio: http2.ParsePriority(up.req.Header.Get("Priority"))}
+	req := up.req.WithContext(ctx)
+
+	c.mu.Lock()
+	c.maxStreamID = 1
+	st.sendWindow.Add(c.peerInitialWindow)
+	st.recvFlow.Init(int64(c.conf.InitialWindowSize))
+	c.streams[st.id] = st
+	c.mu.Unlock()
+	c.startHandler(st, req)
+	return nil
+}
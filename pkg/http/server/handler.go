@@ -3,3 +3,70 @@ package server
 /*
 	HTTP服务请求处理器, 处理HTTP请求和响应
 */
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+// ErrHijackUnsupported 当前连接不支持接管, 如 HTTP/2 流
+var ErrHijackUnsupported = errors.New("server: connection does not support hijacking")
+
+// ErrHijacked 连接已被接管, 不能再通过 ResponseWriter 写出
+var ErrHijacked = errors.New("server: connection has been hijacked")
+
+// remoteAddrKey 请求上下文中保存对端地址的键
+type remoteAddrKey struct{}
+
+// withRemoteAddr 返回携带对端地址 addr 的上下文
+func withRemoteAddr(ctx context.Context, addr net.Addr) context.Context {
+	return context.WithValue(ctx, remoteAddrKey{}, addr)
+}
+
+// RemoteAddr 返回请求所在连接的对端地址, 请求不是由 Server 接收时返回 nil
+func RemoteAddr(req *message.Request) net.Addr {
+	addr, _ := req.Context().Value(remoteAddrKey{}).(net.Addr)
+	return addr
+}
+
+// Handler 处理一个请求, 与具体协议版本无关
+type Handler interface {
+	ServeHTTP(w ResponseWriter, req *message.Request)
+}
+
+// HandlerFunc 将函数适配为 Handler
+type HandlerFunc func(w ResponseWriter, req *message.Request)
+
+// ServeHTTP 调用 f(w, req)
+func (f HandlerFunc) ServeHTTP(w ResponseWriter, req *message.Request) { f(w, req) }
+
+// ResponseWriter 处理器写出响应的接口
+type ResponseWriter interface {
+	// Header 返回响应头部, 在 WriteHeader 或首次 Write 之后的修改不再生效
+	Header() common.Header
+	// WriteHeader 写出状态行和头部, 只有第一次调用有效
+	WriteHeader(code int)
+	// Write 写出消息体, 未调用 WriteHeader 时先以 200 写出头部
+	Write(p []byte) (int, error)
+}
+
+// Flusher 由支持将缓冲数据立即发送给客户端的 ResponseWriter 实现, 用于流式响应
+type Flusher interface {
+	Flush()
+}
+
+// Hijacker 由支持接管底层连接的 ResponseWriter 实现, 用于 WebSocket 等协议升级.
+// 接管后服务器不再处理该连接, 由调用方负责关闭
+type Hijacker interface {
+	Hijack() (net.Conn, *bufio.ReadWriter, error)
+}
+
+// TrailerWriter 由支持发送 trailer 的 ResponseWriter 实现, Trailer 返回的头部在消息体之后发送
+type TrailerWriter interface {
+	Trailer() common.Header
+}
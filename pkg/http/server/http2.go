@@ -0,0 +1,1216 @@
+package server
+
+/*
+	HTTP/2 连接处理: 读协程解析帧并维护流状态, 每个流在独立的协程中调用处理器.
+	帧的写出和 HPACK 编码由 wmu 串行化, 流表和流量控制窗口由 mu 保护
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/hpack"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http1"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http2"
+	"github.com/narcilee7/http-stack/pkg/tcp"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// HTTP2Config HTTP/2 参数, 零值字段使用默认值
+type HTTP2Config struct {
+	// MaxConcurrentStreams 单连接的并发流上限, 0 表示 250; 被重置的流在处理器返回前仍计入上限
+	MaxConcurrentStreams uint32
+	// MaxResetRate 客户端每秒可以重置的流数, 可累积至 MaxConcurrentStreams 个;
+	// 超出时以 ENHANCE_YOUR_CALM 关闭连接, 防御快速重置 (rapid reset) 攻击. 0 表示 100
+	MaxResetRate float64
+	// MaxControlRate 客户端每秒可以触发的 PING 和 SETTINGS 应答数, 可累积至同样多个;
+	// 超出时以 ENHANCE_YOUR_CALM 关闭连接, 防御控制帧洪泛. 0 表示 100
+	MaxControlRate float64
+	// InitialWindowSize 每个流的接收窗口, 0 表示 1MB
+	InitialWindowSize uint32
+	// InitialConnWindowSize 连接级接收窗口, 0 表示 1MB
+	InitialConnWindowSize uint32
+	// MaxReadFrameSize 允许对端发送的最大帧载荷, 0 表示 1MB
+	MaxReadFrameSize uint32
+	// MaxHeaderListSize 请求头部列表的最大大小, 0 表示 1MB
+	MaxHeaderListSize uint32
+	// PriorityStrategy 多个响应同时有数据待发时 DATA 帧的调度策略, 零值为 FIFO;
+	// 流的优先级取自请求的 Priority 头部 (RFC 9218)
+	PriorityStrategy http2.PriorityStrategy
+}
+
+const (
+	defaultMaxConcurrentStreams = 250
+	defaultMaxResetRate         = 100
+	defaultMaxControlRate       = 100
+	defaultHTTP2WindowSize      = 1 << 20
+	defaultHTTP2MaxFrameSize    = 1 << 20
+	// goAwayTimeout 所有流结束后等待客户端关闭连接的时间
+	goAwayTimeout = time.Second
+	// minContinuationLimit 一个头部块最多的 CONTINUATION 帧数的下限; 实际上限还按 MaxHeaderListSize 放宽,
+	// 使 16KB 的帧也能承载允许的最大头部块
+	minContinuationLimit = 64
+)
+
+var (
+	errStreamClosed = errors.New("server: http2 stream closed")
+	errConnClosed   = errors.New("server: http2 connection closed")
+	errBodyClosed   = errors.New("server: read on closed request body")
+)
+
+// withDefaults 返回补全默认值后的副本, c 可以为空
+func (c *HTTP2Config) withDefaults() HTTP2Config {
+	var conf HTTP2Config
+	if c != nil {
+		conf = *c
+	}
+	if conf.MaxConcurrentStreams == 0 {
+		conf.MaxConcurrentStreams = defaultMaxConcurrentStreams
+	}
+	if conf.MaxResetRate <= 0 {
+		conf.MaxResetRate = defaultMaxResetRate
+	}
+	if conf.MaxControlRate <= 0 {
+		conf.MaxControlRate = defaultMaxControlRate
+	}
+	if conf.InitialWindowSize == 0 {
+		conf.InitialWindowSize = defaultHTTP2WindowSize
+	}
+	conf.InitialWindowSize = min(conf.InitialWindowSize, http2.MaxWindowSize)
+	if conf.InitialConnWindowSize == 0 {
+		conf.InitialConnWindowSize = defaultHTTP2WindowSize
+	}
+	conf.InitialConnWindowSize = min(max(conf.InitialConnWindowSize, http2.DefaultInitialWindowSize), http2.MaxWindowSize)
+	if conf.MaxReadFrameSize == 0 {
+		conf.MaxReadFrameSize = defaultHTTP2MaxFrameSize
+	}
+	conf.MaxReadFrameSize = min(max(conf.MaxReadFrameSize, http2.DefaultMaxFrameSize), http2.MaxFrameSizeLimit)
+	if conf.MaxHeaderListSize == 0 {
+		conf.MaxHeaderListSize = http1.DefaultMaxHeaderBytes
+	}
+	return conf
+}
+
+// h2Conn 一条 HTTP/2 连接
+type h2Conn struct {
+	srv  *Server
+	conf HTTP2Config
+	c    *tcp.Conn
+	fr   *http2.Framer
+	dec  *hpack.Decoder
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+
+	// resets 限制客户端重置流的速率, 只由读协程使用
+	resets *utils.RateLimiter
+	// controls 限制需要应答的 PING 和 SETTINGS 的速率, 只由读协程使用
+	controls *utils.RateLimiter
+
+	// 读协程正在组装的头部块, hdrFrames 为已收到的 CONTINUATION 帧数
+	hdrStream uint32
+	hdrEnd    bool
+	hdrBlock  []byte
+	hdrFrames int
+
+	wmu sync.Mutex
+	bw  *bufio.Writer
+	// sched 决定响应的 DATA 帧在流之间的发送顺序
+	sched *http2.WriteScheduler
+	enc   *hpack.Encoder
+	// fields 编码时复用的字段切片, 由 wmu 保护
+	fields []hpack.HeaderField
+	hbuf   []byte
+
+	mu      sync.Mutex
+	cond    sync.Cond
+	streams map[uint32]*h2Stream
+	// handlers 运行中的处理器数, 流被重置后处理器可能仍在运行, 并发上限按它计算
+	handlers          int
+	maxStreamID       uint32
+	sendWindow        http2.Window
+	recvFlow          http2.InFlow
+	peerInitialWindow int64
+	peerMaxFrameSize  uint32
+	goAwaySent        bool
+	draining          bool
+	closed            bool
+}
+
+// h2Stream 一个请求/响应流, 标注的字段由 conn.mu 保护
+type h2Stream struct {
+	conn   *h2Conn
+	id     uint32
+	cancel context.CancelFunc
+	// body 请求体, 请求没有消息体时为空
+	body *h2Body
+	// prio 请求的 Priority 头部给出的优先级
+	prio http2.Priority
+	// mem 请求头部计入连接内存预算的字节数, 流移除时归还
+	mem int64
+
+	// conn.mu
+	state      http2.StreamState
+	sendWindow http2.Window
+	recvFlow   http2.InFlow
+	resetErr   error
+	declLen    int64
+	gotLen     int64
+}
+
+// serveHTTP2 在连接上提供 HTTP/2 服务; up 非空时连接由 HTTP/1.1 请求升级而来, 该请求作为流 1 处理
+func (s *Server) serveHTTP2(c *tcp.Conn, br *bufio.Reader, bw *bufio.Writer, up *h2cUpgrade) {
+	conf := s.HTTP2.withDefaults()
+	if s.HTTP2ForConn != nil {
+		if cc := s.HTTP2ForConn(c); cc != nil {
+			conf = cc.withDefaults()
+		}
+	}
+	hc := &h2Conn{
+		srv:               s,
+		conf:              conf,
+		c:                 c,
+		fr:                http2.NewFramer(bw, br),
+		dec:               hpack.NewDecoder(http2.DefaultHeaderTableSize),
+		bw:                bw,
+		enc:               hpack.NewEncoder(),
+		sched:             http2.NewWriteScheduler(conf.PriorityStrategy),
+		streams:           make(map[uint32]*h2Stream),
+		resets:            utils.NewRateLimiter(conf.MaxResetRate, int(conf.MaxConcurrentStreams)),
+		controls:          utils.NewRateLimiter(conf.MaxControlRate, 0),
+		peerInitialWindow: http2.DefaultInitialWindowSize,
+		peerMaxFrameSize:  http2.DefaultMaxFrameSize,
+	}
+	hc.cond.L = &hc.mu
+	hc.fr.MaxReadFrameSize = conf.MaxReadFrameSize
+	hc.dec.MaxHeaderListSize = conf.MaxHeaderListSize
+	hc.dec.MaxStringLength = int(conf.MaxHeaderListSize)
+	hc.sendWindow.Add(http2.DefaultInitialWindowSize)
+	hc.recvFlow.Init(int64(conf.InitialConnWindowSize))
+	hc.ctx, hc.stop = context.WithCancel(withRemoteAddr(context.Background(), c.RemoteAddr()))
+	if !s.trackConn(hc, true) {
+		return
+	}
+	defer s.trackConn(hc, false)
+	hc.serve(br, up)
+}
+
+func (c *h2Conn) serve(br *bufio.Reader, up *h2cUpgrade) {
+	defer c.close()
+	c.c.SetReadDeadline(deadline(c.srv.ReadHeaderTimeout))
+	preface := make([]byte, len(http2.ClientPreface))
+	if _, err := io.ReadFull(br, preface); err != nil || string(preface) != http2.ClientPreface {
+		return
+	}
+	err := c.writeFrame(func(fr *http2.Framer) error {
+		if err := fr.WriteSettings(
+			http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: c.conf.MaxConcurrentStreams},
+			http2.Setting{ID: http2.SettingInitialWindowSize, Val: c.conf.InitialWindowSize},
+			http2.Setting{ID: http2.SettingMaxFrameSize, Val: c.conf.MaxReadFrameSize},
+			http2.Setting{ID: http2.SettingMaxHeaderListSize, Val: c.conf.MaxHeaderListSize},
+		); err != nil {
+			return err
+		}
+		if inc := c.conf.InitialConnWindowSize - http2.DefaultInitialWindowSize; inc > 0 {
+			return fr.WriteWindowUpdate(0, inc)
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	if up != nil {
+		if err := c.startUpgradeStream(up); err != nil {
+			return
+		}
+	}
+
+	first := true
+	for {
+		c.setReadDeadline()
+		f, err := c.fr.ReadFrame()
+		if err == nil {
+			if sf, ok := f.(*http2.SettingsFrame); first && (!ok || sf.IsAck()) {
+				// 前言之后的第一个帧必须是 SETTINGS
+				err = http2.ConnectionError(http2.ErrCodeProtocol)
+			} else {
+				first = false
+				err = c.processFrame(f)
+			}
+		}
+		if err == nil {
+			continue
+		}
+		var se *http2.StreamError
+		var ce http2.ConnectionError
+		var ne net.Error
+		switch {
+		case errors.As(err, &se):
+			c.resetStream(se.StreamID, se.Code)
+			continue
+		case errors.As(err, &ce):
+			c.writeGoAway(http2.ErrCode(ce))
+		case errors.As(err, &ne) && ne.Timeout():
+			c.mu.Lock()
+			idle := !c.draining && len(c.streams) == 0
+			c.mu.Unlock()
+			if idle {
+				c.writeGoAway(http2.ErrCodeNo)
+			}
+		}
+		return
+	}
+}
+
+// setReadDeadline 没有活动的流时按 IdleTimeout 设置读超时; 头部块未结束时保留 startHeaderBlock 设置的超时
+func (c *h2Conn) setReadDeadline() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.draining || c.hdrStream != 0 {
+		return
+	}
+	if len(c.streams) == 0 {
+		c.c.SetReadDeadline(deadline(c.srv.IdleTimeout))
+	} else {
+		c.c.SetReadDeadline(time.Time{})
+	}
+}
+
+// close 在读协程退出时关闭连接, 结束所有流并等待处理器返回
+func (c *h2Conn) close() {
+	c.mu.Lock()
+	c.closed = true
+	for _, st := range c.streams {
+		st.resetErr = errConnClosed
+		if st.body != nil {
+			st.body.closeWithError(errConnClosed)
+		}
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	c.stop()
+	c.c.Close()
+	c.wg.Wait()
+}
+
+// writeFrame 在写锁内写出帧并 Flush, 失败时关闭连接使读协程退出
+func (c *h2Conn) writeFrame(fn func(fr *http2.Framer) error) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	err := fn(c.fr)
+	if err == nil {
+		err = c.bw.Flush()
+	}
+	if err != nil {
+		c.c.Close()
+	}
+	return err
+}
+
+// writeGoAway 发送 GOAWAY, 之后新建的流被忽略
+func (c *h2Conn) writeGoAway(code http2.ErrCode) {
+	c.mu.Lock()
+	c.goAwaySent = true
+	last := c.maxStreamID
+	c.mu.Unlock()
+	c.writeFrame(func(fr *http2.Framer) error { return fr.WriteGoAway(last, code, nil) })
+}
+
+// startShutdown 发送 GOAWAY, 已有的流结束后关闭连接
+func (c *h2Conn) startShutdown() {
+	c.mu.Lock()
+	if c.goAwaySent || c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	c.writeGoAway(http2.ErrCodeNo)
+	c.mu.Lock()
+	idle := len(c.streams) == 0
+	c.mu.Unlock()
+	if idle {
+		c.drain()
+	}
+}
+
+// drain 关闭写方向, 等待客户端在 goAwayTimeout 内关闭连接
+func (c *h2Conn) drain() {
+	c.mu.Lock()
+	if c.draining {
+		c.mu.Unlock()
+		return
+	}
+	c.draining = true
+	c.mu.Unlock()
+	c.wmu.Lock()
+	c.bw.Flush()
+	c.c.CloseWrite()
+	c.wmu.Unlock()
+	c.c.SetReadDeadline(time.Now().Add(goAwayTimeout))
+}
+
+func (c *h2Conn) processFrame(f http2.Frame) error {
+	switch f := f.(type) {
+	case *http2.SettingsFrame:
+		return c.processSettings(f)
+	case *http2.HeadersFrame:
+		c.hdrBlock = append(c.hdrBlock[:0], f.BlockFragment...)
+		c.hdrEnd = f.StreamEnded()
+		if f.HeadersEnded() {
+			c.hdrStream = f.StreamID
+			return c.processHeaderBlock()
+		}
+		c.startHeaderBlock(f.StreamID)
+	case *http2.ContinuationFrame:
+		c.hdrBlock = append(c.hdrBlock, f.BlockFragment...)
+		c.hdrFrames++
+		// 空的 CONTINUATION 帧不增加头部块长度, 需要单独限制帧数
+		limit := max(minContinuationLimit, 2*int(c.conf.MaxHeaderListSize/http2.DefaultMaxFrameSize))
+		if len(c.hdrBlock) > 2*int(c.conf.MaxHeaderListSize) || c.hdrFrames > limit {
+			return http2.ConnectionError(http2.ErrCodeEnhanceYourCalm)
+		}
+		if f.HeadersEnded() {
+			return c.processHeaderBlock()
+		}
+	case *http2.DataFrame:
+		return c.processData(f)
+	case *http2.WindowUpdateFrame:
+		return c.processWindowUpdate(f)
+	case *http2.RSTStreamFrame:
+		c.mu.Lock()
+		idle := f.StreamID > c.maxStreamID
+		st := c.streams[f.StreamID]
+		c.mu.Unlock()
+		if idle {
+			return http2.ConnectionError(http2.ErrCodeProtocol)
+		}
+		if !c.resets.Allow() {
+			return http2.ConnectionError(http2.ErrCodeEnhanceYourCalm)
+		}
+		if st != nil {
+			c.closeStream(st, errStreamClosed)
+		}
+	case *http2.PingFrame:
+		if !f.IsAck() {
+			if !c.controls.Allow() {
+				return http2.ConnectionError(http2.ErrCodeEnhanceYourCalm)
+			}
+			data := f.Data
+			return c.writeFrame(func(fr *http2.Framer) error { return fr.WritePing(true, data) })
+		}
+	case *http2.GoAwayFrame:
+		c.startShutdown()
+	case *http2.PushPromiseFrame:
+		// 客户端不能推送
+		return http2.ConnectionError(http2.ErrCodeProtocol)
+	}
+	// PRIORITY 和未知类型的帧忽略
+	return nil
+}
+
+func (c *h2Conn) processSettings(f *http2.SettingsFrame) error {
+	if f.IsAck() {
+		return nil
+	}
+	if !c.controls.Allow() {
+		return http2.ConnectionError(http2.ErrCodeEnhanceYourCalm)
+	}
+	if err := c.applySettings(f.Settings); err != nil {
+		return err
+	}
+	return c.writeFrame(func(fr *http2.Framer) error { return fr.WriteSettingsAck() })
+}
+
+// applySettings 应用客户端的 SETTINGS 参数
+func (c *h2Conn) applySettings(settings []http2.Setting) error {
+	for _, s := range settings {
+		switch s.ID {
+		case http2.SettingHeaderTableSize:
+			c.wmu.Lock()
+			c.enc.SetMaxDynamicTableSizeLimit(s.Val)
+			c.wmu.Unlock()
+		case http2.SettingInitialWindowSize:
+			c.mu.Lock()
+			delta := int64(s.Val) - c.peerInitialWindow
+			c.peerInitialWindow = int64(s.Val)
+			for _, st := range c.streams {
+				if !st.sendWindow.Add(delta) {
+					c.mu.Unlock()
+					return http2.ConnectionError(http2.ErrCodeFlowControl)
+				}
+			}
+			c.cond.Broadcast()
+			c.mu.Unlock()
+		case http2.SettingMaxFrameSize:
+			c.mu.Lock()
+			c.peerMaxFrameSize = s.Val
+			c.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+func (c *h2Conn) processWindowUpdate(f *http2.WindowUpdateFrame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f.StreamID == 0 {
+		if !c.sendWindow.Add(int64(f.Increment)) {
+			return http2.ConnectionError(http2.ErrCodeFlowControl)
+		}
+		c.cond.Broadcast()
+		return nil
+	}
+	if f.StreamID > c.maxStreamID {
+		return http2.ConnectionError(http2.ErrCodeProtocol)
+	}
+	if st := c.streams[f.StreamID]; st != nil {
+		if !st.sendWindow.Add(int64(f.Increment)) {
+			return &http2.StreamError{StreamID: f.StreamID, Code: http2.ErrCodeFlowControl}
+		}
+		c.cond.Broadcast()
+	}
+	return nil
+}
+
+// startHeaderBlock 开始跨多个帧的头部块, 按 ReadHeaderTimeout (未设置时为 IdleTimeout) 设置读超时,
+// 在头部块结束前不再延长, 对端不能靠持续发送 CONTINUATION 占住连接
+func (c *h2Conn) startHeaderBlock(id uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hdrStream, c.hdrFrames = id, 0
+	if c.draining {
+		return
+	}
+	timeout := c.srv.ReadHeaderTimeout
+	if timeout <= 0 {
+		timeout = c.srv.IdleTimeout
+	}
+	c.c.SetReadDeadline(deadline(timeout))
+}
+
+// processHeaderBlock 解码完整的头部块, 新建流或作为 trailer 结束已有的流
+func (c *h2Conn) processHeaderBlock() error {
+	id, end := c.hdrStream, c.hdrEnd
+	c.hdrStream = 0
+	fields, err := c.dec.Decode(c.hdrBlock)
+	tooLarge := errors.Is(err, hpack.ErrHeaderListTooLarge)
+	if err != nil && !tooLarge {
+		return http2.ConnectionError(http2.ErrCodeCompression)
+	}
+
+	c.mu.Lock()
+	if st := c.streams[id]; st != nil {
+		c.mu.Unlock()
+		if tooLarge {
+			return &http2.StreamError{StreamID: id, Code: http2.ErrCodeProtocol, Cause: err}
+		}
+		return c.processTrailers(st, fields, end)
+	}
+	switch {
+	case id%2 == 0:
+		c.mu.Unlock()
+		return http2.ConnectionError(http2.ErrCodeProtocol)
+	case id <= c.maxStreamID:
+		c.mu.Unlock()
+		return http2.ConnectionError(http2.ErrCodeStreamClosed)
+	}
+	c.maxStreamID = id
+	if c.goAwaySent {
+		c.mu.Unlock()
+		return nil
+	}
+	if uint32(c.handlers) >= c.conf.MaxConcurrentStreams {
+		c.mu.Unlock()
+		return &http2.StreamError{StreamID: id, Code: http2.ErrCodeRefusedStream}
+	}
+	c.mu.Unlock()
+
+	if tooLarge {
+		status := strconv.Itoa(common.StatusRequestHeaderFieldsTooLarge)
+		maxFrame := c.peerFrameSize()
+		return c.writeFrame(func(fr *http2.Framer) error {
+			c.hbuf = c.enc.AppendBlock(c.hbuf[:0], hpack.HeaderField{Name: ":status", Value: status})
+			return fr.WriteHeaderBlock(id, true, c.hbuf, maxFrame)
+		})
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	st := &h2Stream{conn: c, id: id, cancel: cancel, state: http2.StateOpen, declLen: -1}
+	req, err := c.newRequest(st, fields, end)
+	if err != nil {
+		cancel()
+		return err
+	}
+	req = req.WithContext(ctx)
+	st.mem = headerSize(req.Header)
+	if err := c.c.Memory().Reserve(st.mem); err != nil {
+		cancel()
+		return &http2.StreamError{StreamID: id, Code: http2.ErrCodeRefusedStream, Cause: err}
+	}
+
+	c.mu.Lock()
+	st.sendWindow.Add(c.peerInitialWindow)
+	st.recvFlow.Init(int64(c.conf.InitialWindowSize))
+	if end {
+		st.state = st.state.RecvEndStream()
+	}
+	c.streams[id] = st
+	c.mu.Unlock()
+	c.startHandler(st, req)
+	return nil
+}
+
+// h2ConnectionHeaders HTTP/2 中禁止出现的连接级头部 (RFC 9113 8.2.2)
+var h2ConnectionHeaders = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// newRequest 由解码后的头部字段构造请求, 格式错误时返回 PROTOCOL_ERROR 流错误
+func (c *h2Conn) newRequest(st *h2Stream, fields []hpack.HeaderField, end bool) (*message.Request, error) {
+	req, declLen, err := requestFromFields(fields, "HTTP/2.0")
+	if err != nil {
+		return nil, &http2.StreamError{StreamID: st.id, Code: http2.ErrCodeProtocol, Cause: err}
+	}
+	st.declLen = declLen
+	st.prio = http2.ParsePriority(req.Header.Get("Priority"))
+	if end {
+		if st.declLen > 0 {
+			return nil, &http2.StreamError{StreamID: st.id, Code: http2.ErrCodeProtocol,
+				Cause: fmt.Errorf("content-length %d with empty body", st.declLen)}
+		}
+		req.Body = message.NoBody
+		req.ContentLength = 0
+		return req, nil
+	}
+	st.body = &h2Body{st: st}
+	st.body.cond.L = &st.body.mu
+	req.Body = st.body
+	req.ContentLength = st.declLen
+	return req, nil
+}
+
+// requestFromFields 由 HTTP/2 或 HTTP/3 的请求头部字段构造没有消息体的请求,
+// 同时返回 Content-Length 声明的长度 (-1 表示未声明); 两者对伪头部和字段名的要求相同
+func requestFromFields(fields []hpack.HeaderField, proto string) (*message.Request, int64, error) {
+	pseudo := make(map[string]string, 4)
+	header := make(common.Header, len(fields))
+	for _, f := range fields {
+		if f.IsPseudo() {
+			switch f.Name {
+			case ":method", ":scheme", ":path", ":authority":
+			default:
+				return nil, 0, fmt.Errorf("unknown pseudo-header %s", f.Name)
+			}
+			if len(header) > 0 {
+				return nil, 0, fmt.Errorf("pseudo-header %s after regular header", f.Name)
+			}
+			if _, dup := pseudo[f.Name]; dup {
+				return nil, 0, fmt.Errorf("duplicate pseudo-header %s", f.Name)
+			}
+			pseudo[f.Name] = f.Value
+			continue
+		}
+		if !validH2FieldName(f.Name) {
+			return nil, 0, fmt.Errorf("invalid header field name %q", f.Name)
+		}
+		if h2ConnectionHeaders[f.Name] || f.Name == "te" && f.Value != "trailers" {
+			return nil, 0, fmt.Errorf("connection-specific header %s", f.Name)
+		}
+		header.Add(f.Name, f.Value)
+	}
+	if cookies := header["Cookie"]; len(cookies) > 1 {
+		// 客户端可以把 Cookie 拆成多个字段发送 (RFC 9113 8.2.3)
+		header["Cookie"] = []string{strings.Join(cookies, "; ")}
+	}
+
+	method, authority := pseudo[":method"], pseudo[":authority"]
+	req, err := message.NewRequest(method, "", nil)
+	if err != nil || method == "" {
+		return nil, 0, fmt.Errorf("invalid method %q", method)
+	}
+	if method == common.MethodConnect {
+		if authority == "" || pseudo[":scheme"] != "" || pseudo[":path"] != "" {
+			return nil, 0, errors.New("malformed CONNECT request")
+		}
+		req.URL = &url.URL{Host: authority}
+	} else {
+		if pseudo[":scheme"] == "" || pseudo[":path"] == "" {
+			return nil, 0, errors.New("missing :scheme or :path")
+		}
+		if req.URL, err = url.ParseRequestURI(pseudo[":path"]); err != nil {
+			return nil, 0, fmt.Errorf("invalid :path: %v", err)
+		}
+	}
+	req.Proto = proto
+	req.Header = header
+	req.Host = authority
+	if req.Host == "" {
+		req.Host = header.Get("Host")
+	}
+
+	declLen := int64(-1)
+	if v := header.Get("Content-Length"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return nil, 0, fmt.Errorf("invalid content-length %q", v)
+		}
+		declLen = n
+	}
+	return req, declLen, nil
+}
+
+// validH2FieldName HTTP/2 的头部名称必须是小写的 token
+func validH2FieldName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if b := name[i]; !common.IsTokenChar(b) || b >= 'A' && b <= 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// processTrailers 处理请求 trailer, 必须带 END_STREAM 且不含伪头部
+func (c *h2Conn) processTrailers(st *h2Stream, fields []hpack.HeaderField, end bool) error {
+	if !end {
+		return &http2.StreamError{StreamID: st.id, Code: http2.ErrCodeProtocol}
+	}
+	trailer := make(common.Header, len(fields))
+	for _, f := range fields {
+		if f.IsPseudo() || !validH2FieldName(f.Name) {
+			return &http2.StreamError{StreamID: st.id, Code: http2.ErrCodeProtocol}
+		}
+		trailer.Add(f.Name, f.Value)
+	}
+	c.mu.Lock()
+	if !st.state.CanRecvData() {
+		c.mu.Unlock()
+		return &http2.StreamError{StreamID: st.id, Code: http2.ErrCodeStreamClosed}
+	}
+	if st.declLen >= 0 && st.gotLen != st.declLen {
+		c.mu.Unlock()
+		return &http2.StreamError{StreamID: st.id, Code: http2.ErrCodeProtocol}
+	}
+	st.state = st.state.RecvEndStream()
+	closed := st.state == http2.StateClosed
+	c.mu.Unlock()
+	if st.body != nil {
+		st.body.setTrailer(trailer)
+		st.body.closeWithError(io.EOF)
+	}
+	if closed {
+		c.removeStream(st)
+	}
+	return nil
+}
+
+func (c *h2Conn) processData(f *http2.DataFrame) error {
+	id, n := f.StreamID, int64(f.Length)
+	c.mu.Lock()
+	if !c.recvFlow.Take(n) {
+		c.mu.Unlock()
+		return http2.ConnectionError(http2.ErrCodeFlowControl)
+	}
+	st := c.streams[id]
+	if st == nil || !st.state.CanRecvData() || st.body == nil {
+		idle := id > c.maxStreamID
+		c.mu.Unlock()
+		c.consumed(nil, n)
+		if idle {
+			return http2.ConnectionError(http2.ErrCodeProtocol)
+		}
+		return &http2.StreamError{StreamID: id, Code: http2.ErrCodeStreamClosed}
+	}
+	if !st.recvFlow.Take(n) {
+		c.mu.Unlock()
+		c.consumed(nil, n)
+		return &http2.StreamError{StreamID: id, Code: http2.ErrCodeFlowControl}
+	}
+	st.gotLen += int64(len(f.Data))
+	end := f.StreamEnded()
+	if st.declLen >= 0 && (st.gotLen > st.declLen || end && st.gotLen != st.declLen) {
+		c.mu.Unlock()
+		c.consumed(nil, n)
+		return &http2.StreamError{StreamID: id, Code: http2.ErrCodeProtocol}
+	}
+	if end {
+		st.state = st.state.RecvEndStream()
+	}
+	closed := st.state == http2.StateClosed
+	c.mu.Unlock()
+
+	// 填充不会被读取, 立即归还; 处理器已关闭请求体时数据直接丢弃
+	pad := n - int64(len(f.Data))
+	if len(f.Data) > 0 && !st.body.write(f.Data) {
+		pad = n
+	}
+	c.consumed(st, pad)
+	if end {
+		st.body.closeWithError(io.EOF)
+	}
+	if closed {
+		c.removeStream(st)
+	}
+	return nil
+}
+
+// consumed 归还 n 个字节的接收窗口, 累计足够时发送 WINDOW_UPDATE; st 为空时只归还连接窗口
+func (c *h2Conn) consumed(st *h2Stream, n int64) {
+	if n <= 0 {
+		return
+	}
+	c.mu.Lock()
+	connInc := c.recvFlow.Release(n)
+	var streamInc uint32
+	if st != nil && c.streams[st.id] == st && st.state.CanRecvData() {
+		streamInc = st.recvFlow.Release(n)
+	}
+	closed := c.closed
+	c.mu.Unlock()
+	if closed || connInc == 0 && streamInc == 0 {
+		return
+	}
+	c.writeFrame(func(fr *http2.Framer) error {
+		if connInc > 0 {
+			if err := fr.WriteWindowUpdate(0, connInc); err != nil {
+				return err
+			}
+		}
+		if streamInc > 0 {
+			return fr.WriteWindowUpdate(st.id, streamInc)
+		}
+		return nil
+	})
+}
+
+// resetStream 以 code 重置流并发送 RST_STREAM
+func (c *h2Conn) resetStream(id uint32, code http2.ErrCode) {
+	c.mu.Lock()
+	st := c.streams[id]
+	c.mu.Unlock()
+	if st != nil {
+		c.closeStream(st, errStreamClosed)
+	}
+	c.writeFrame(func(fr *http2.Framer) error { return fr.WriteRSTStream(id, code) })
+}
+
+// closeStream 立即关闭流, 唤醒等待窗口的写出方并使请求体读取失败
+func (c *h2Conn) closeStream(st *h2Stream, err error) {
+	c.mu.Lock()
+	st.state = http2.StateClosed
+	if st.resetErr == nil {
+		st.resetErr = err
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	st.cancel()
+	if st.body != nil {
+		st.body.closeWithError(err)
+	}
+	c.removeStream(st)
+}
+
+// removeStream 从流表中删除已关闭的流; 已发送 GOAWAY 且没有剩余流时开始关闭连接
+func (c *h2Conn) removeStream(st *h2Stream) {
+	c.mu.Lock()
+	removed := c.streams[st.id] == st
+	if removed {
+		delete(c.streams, st.id)
+	}
+	done := c.goAwaySent && len(c.streams) == 0
+	c.mu.Unlock()
+	if removed {
+		c.c.Memory().Release(st.mem)
+	}
+	c.sched.Forget(st.id)
+	if done {
+		c.drain()
+	}
+}
+
+func (c *h2Conn) peerFrameSize() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.peerMaxFrameSize
+}
+
+// writable 返回流不能再发送的原因, 由调用方持有 conn.mu
+func (st *h2Stream) writable() error {
+	switch {
+	case st.resetErr != nil:
+		return st.resetErr
+	case st.conn.closed:
+		return errConnClosed
+	case !st.state.CanSendData():
+		return errStreamClosed
+	}
+	return nil
+}
+
+// writeHeaders 发送头部块, end 为 true 时结束本端的发送
+func (c *h2Conn) writeHeaders(st *h2Stream, status int, h common.Header, end bool) error {
+	c.mu.Lock()
+	if err := st.writable(); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	if end {
+		st.state = st.state.SendEndStream()
+	}
+	closed := st.state == http2.StateClosed
+	maxFrame := c.peerMaxFrameSize
+	c.mu.Unlock()
+
+	err := c.writeFrame(func(fr *http2.Framer) error {
+		c.fields = c.fields[:0]
+		if status != 0 {
+			c.fields = append(c.fields, hpack.HeaderField{Name: ":status", Value: strconv.Itoa(status)})
+		}
+		for k, vs := range h {
+			name := strings.ToLower(k)
+			if h2ConnectionHeaders[name] {
+				continue
+			}
+			for _, v := range vs {
+				c.fields = append(c.fields, hpack.HeaderField{Name: name, Value: v})
+			}
+		}
+		c.hbuf = c.enc.AppendBlock(c.hbuf[:0], c.fields...)
+		return fr.WriteHeaderBlock(st.id, end, c.hbuf, maxFrame)
+	})
+	if closed {
+		c.removeStream(st)
+	}
+	return err
+}
+
+// writeData 按流量控制窗口和对端的 MAX_FRAME_SIZE 切分发送 p, end 为 true 时最后一帧带 END_STREAM
+func (c *h2Conn) writeData(st *h2Stream, p []byte, end bool) error {
+	for {
+		c.mu.Lock()
+		var n int
+		for {
+			if err := st.writable(); err != nil {
+				c.mu.Unlock()
+				return err
+			}
+			if len(p) == 0 {
+				break
+			}
+			avail := min(st.sendWindow.Available(), c.sendWindow.Available(), int64(c.peerMaxFrameSize))
+			if avail > 0 {
+				n = int(min(avail, int64(len(p))))
+				break
+			}
+			c.cond.Wait()
+		}
+		st.sendWindow.Take(int64(n))
+		c.sendWindow.Take(int64(n))
+		last := end && n == len(p)
+		if last {
+			st.state = st.state.SendEndStream()
+		}
+		closed := st.state == http2.StateClosed
+		c.mu.Unlock()
+
+		chunk := p[:n]
+		c.sched.Acquire(st.id, st.prio)
+		err := c.writeFrame(func(fr *http2.Framer) error { return fr.WriteData(st.id, last, chunk) })
+		c.sched.Release()
+		if err != nil {
+			return err
+		}
+		if closed {
+			c.removeStream(st)
+		}
+		p = p[n:]
+		if len(p) == 0 && (last || !end) {
+			return nil
+		}
+	}
+}
+
+// startHandler 在新协程中运行流的处理器
+func (c *h2Conn) startHandler(st *h2Stream, req *message.Request) {
+	c.mu.Lock()
+	c.handlers++
+	c.mu.Unlock()
+	c.wg.Add(1)
+	go c.runHandler(st, req)
+}
+
+// runHandler 在流的协程中调用处理器并结束响应
+func (c *h2Conn) runHandler(st *h2Stream, req *message.Request) {
+	defer c.wg.Done()
+	defer func() {
+		c.mu.Lock()
+		c.handlers--
+		c.mu.Unlock()
+	}()
+	defer st.cancel()
+	w := &h2Response{st: st, req: req, header: c.srv.responseHeader(), contentLength: -1}
+	if !c.callHandler(w, req) {
+		c.resetStream(st.id, http2.ErrCodeInternal)
+		return
+	}
+	if err := w.finish(); err != nil {
+		return
+	}
+	// 处理器没有读完请求体时重置流, 让客户端停止发送 (RFC 9113 8.1)
+	c.mu.Lock()
+	open := st.state == http2.StateHalfClosedLocal
+	c.mu.Unlock()
+	if open {
+		c.resetStream(st.id, http2.ErrCodeNo)
+	}
+}
+
+func (c *h2Conn) callHandler(w *h2Response, req *message.Request) (ok bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			c.srv.logPanic(req, v, debug.Stack())
+			ok = false
+		}
+	}()
+	c.srv.Handler.ServeHTTP(w, req)
+	return true
+}
+
+// h2Body HTTP/2 请求体, 读协程写入 DATA 帧的数据, 处理器读取后归还流量控制窗口
+type h2Body struct {
+	st *h2Stream
+
+	mu      sync.Mutex
+	cond    sync.Cond
+	buf     bytes.Buffer
+	err     error
+	closed  bool
+	trailer common.Header
+}
+
+// write 追加数据, 请求体已被处理器关闭时返回 false
+func (b *h2Body) write(p []byte) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed || b.err != nil {
+		return false
+	}
+	b.buf.Write(p)
+	b.cond.Signal()
+	return true
+}
+
+func (b *h2Body) closeWithError(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.cond.Broadcast()
+}
+
+func (b *h2Body) setTrailer(h common.Header) {
+	b.mu.Lock()
+	b.trailer = h
+	b.mu.Unlock()
+}
+
+// Trailer 返回请求体读完后收到的 trailer
+func (b *h2Body) Trailer() common.Header {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.trailer
+}
+
+func (b *h2Body) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	for b.buf.Len() == 0 && b.err == nil && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		b.mu.Unlock()
+		return 0, errBodyClosed
+	}
+	if b.buf.Len() == 0 {
+		err := b.err
+		b.mu.Unlock()
+		return 0, err
+	}
+	n, _ := b.buf.Read(p)
+	b.mu.Unlock()
+	b.st.conn.consumed(b.st, int64(n))
+	return n, nil
+}
+
+// Close 丢弃未读的数据并归还其连接窗口, 之后到达的数据直接丢弃
+func (b *h2Body) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	n := b.buf.Len()
+	b.buf.Reset()
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	b.st.conn.consumed(nil, int64(n))
+	return nil
+}
+
+// h2Response HTTP/2 的 ResponseWriter, 实现 Flusher 和 TrailerWriter; 流不能被接管
+type h2Response struct {
+	st      *h2Stream
+	req     *message.Request
+	header  common.Header
+	trailer common.Header
+
+	status      int
+	wroteHeader bool
+	sentHeader  bool
+	buf         []byte
+	// contentLength 声明的长度, -1 表示未声明
+	contentLength int64
+	written       int64
+	err           error
+}
+
+func (w *h2Response) Header() common.Header { return w.header }
+
+// Trailer 返回在响应体之后发送的 trailer
+func (w *h2Response) Trailer() common.Header {
+	if w.trailer == nil {
+		w.trailer = make(common.Header)
+	}
+	return w.trailer
+}
+
+func (w *h2Response) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	if code >= 100 && code < 200 {
+		// 1xx 信息性响应立即发出; HTTP/2 不支持 101
+		if code != common.StatusSwitchingProtocols {
+			w.st.conn.writeHeaders(w.st, code, w.header, false)
+		}
+		return
+	}
+	w.wroteHeader = true
+	w.status = code
+	if v := w.header.Get("Content-Length"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			w.contentLength = n
+		} else {
+			w.header.Del("Content-Length")
+		}
+	}
+}
+
+func (w *h2Response) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(common.StatusOK)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if !common.BodyAllowedForStatus(w.status) {
+		return 0, ErrBodyNotAllowed
+	}
+	if w.contentLength >= 0 && w.written+int64(len(p)) > w.contentLength {
+		return 0, ErrContentLength
+	}
+	w.written += int64(len(p))
+	if w.req.Method == common.MethodHead {
+		return len(p), nil
+	}
+	if !w.sentHeader && len(w.buf)+len(p) <= bufferBodySize {
+		w.buf = append(w.buf, p...)
+		return len(p), nil
+	}
+	if err := w.sendHeader(); err != nil {
+		return 0, err
+	}
+	if w.err = w.st.conn.writeData(w.st, p, false); w.err != nil {
+		return 0, w.err
+	}
+	return len(p), nil
+}
+
+// sendHeader 发出头部和缓冲的响应体
+func (w *h2Response) sendHeader() error {
+	if w.sentHeader {
+		return w.err
+	}
+	w.sentHeader = true
+	if w.err = w.st.conn.writeHeaders(w.st, w.status, w.header, false); w.err != nil {
+		return w.err
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) > 0 {
+		w.err = w.st.conn.writeData(w.st, buf, false)
+	}
+	return w.err
+}
+
+// Flush 发出已写入的头部和响应体
+func (w *h2Response) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(common.StatusOK)
+	}
+	w.sendHeader()
+}
+
+// Hijack HTTP/2 流不能被接管
+func (w *h2Response) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, ErrHijackUnsupported
+}
+
+// finish 在处理器返回后结束响应: 发出剩余数据, 有 trailer 时以 HEADERS 帧结束流
+func (w *h2Response) finish() error {
+	if !w.wroteHeader {
+		w.WriteHeader(common.StatusOK)
+	}
+	if w.err != nil {
+		return w.err
+	}
+	c := w.st.conn
+	hasTrailer := len(w.trailer) > 0
+	if !w.sentHeader {
+		w.sentHeader = true
+		if common.BodyAllowedForStatus(w.status) && w.contentLength < 0 && (w.req.Method != common.MethodHead || w.written > 0) {
+			w.header.Set("Content-Length", strconv.FormatInt(w.written, 10))
+		}
+		end := len(w.buf) == 0 && !hasTrailer
+		if err := c.writeHeaders(w.st, w.status, w.header, end); err != nil || end {
+			return err
+		}
+		if len(w.buf) > 0 {
+			if err := c.writeData(w.st, w.buf, !hasTrailer); err != nil || !hasTrailer {
+				return err
+			}
+		}
+	} else if !hasTrailer {
+		return c.writeData(w.st, nil, true)
+	}
+	return c.writeHeaders(w.st, 0, w.trailer, true)
+}
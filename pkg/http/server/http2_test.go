@@ -0,0 +1,201 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/hpack"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http2"
+)
+
+// startH2C 以 prior knowledge 方式连接到服务 h 的 h2c 服务器, 返回已发送前言的 Framer
+func startH2C(t *testing.T, h Handler, conf *HTTP2Config) *http2.Framer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{Handler: h, H2C: true, HTTP2: conf}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Write([]byte(http2.ClientPreface)); err != nil {
+		t.Fatal(err)
+	}
+	fr := http2.NewFramer(c, c)
+	if err := fr.WriteSettings(); err != nil {
+		t.Fatal(err)
+	}
+	return fr
+}
+
+// openStream 在流 id 上发送一个没有消息体的 GET 请求
+func openStream(t *testing.T, fr *http2.Framer, id uint32) {
+	t.Helper()
+	block := hpack.NewEncoder().AppendBlock(nil,
+		hpack.HeaderField{Name: ":method", Value: "GET"},
+		hpack.HeaderField{Name: ":scheme", Value: "http"},
+		hpack.HeaderField{Name: ":authority", Value: "example.com"},
+		hpack.HeaderField{Name: ":path", Value: "/"},
+	)
+	if err := fr.WriteHeaderBlock(id, true, block, http2.DefaultMaxFrameSize); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readUntil 读取帧直到 match 返回 true
+func readUntil(t *testing.T, fr *http2.Framer, match func(http2.Frame) bool) http2.Frame {
+	t.Helper()
+	for {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			t.Fatalf("ReadFrame: %v", err)
+		}
+		if match(f) {
+			return f
+		}
+	}
+}
+
+func TestHTTP2ResetStreamsCountUntilHandlerReturns(t *testing.T) {
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	unblock := func() { releaseOnce.Do(func() { close(release) }) }
+	var running atomic.Int32
+	h := HandlerFunc(func(w ResponseWriter, req *message.Request) {
+		running.Add(1)
+		defer running.Add(-1)
+		<-release
+	})
+	fr := startH2C(t, h, &HTTP2Config{MaxConcurrentStreams: 2})
+	// 失败时也要放行处理器, 否则清理时 Close 会一直等待
+	t.Cleanup(unblock)
+	openStream(t, fr, 1)
+	openStream(t, fr, 3)
+	for running.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	fr.WriteRSTStream(1, http2.ErrCodeCancel)
+	fr.WriteRSTStream(3, http2.ErrCodeCancel)
+	openStream(t, fr, 5)
+	f := readUntil(t, fr, func(f http2.Frame) bool {
+		rst, ok := f.(*http2.RSTStreamFrame)
+		return ok && rst.StreamID == 5
+	})
+	if code := f.(*http2.RSTStreamFrame).Code; code != http2.ErrCodeRefusedStream {
+		t.Fatalf("stream 5 reset with %v, want REFUSED_STREAM", code)
+	}
+	if n := running.Load(); n != 2 {
+		t.Fatalf("%d handlers running, want 2", n)
+	}
+
+	unblock()
+	for running.Load() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	openStream(t, fr, 7)
+	readUntil(t, fr, func(f http2.Frame) bool {
+		hf, ok := f.(*http2.HeadersFrame)
+		return ok && hf.StreamID == 7
+	})
+}
+
+func TestHTTP2RapidResetGoAway(t *testing.T) {
+	h := HandlerFunc(func(w ResponseWriter, req *message.Request) {})
+	fr := startH2C(t, h, &HTTP2Config{MaxConcurrentStreams: 10, MaxResetRate: 1})
+	for id := uint32(1); id <= 41; id += 2 {
+		openStream(t, fr, id)
+		fr.WriteRSTStream(id, http2.ErrCodeCancel)
+	}
+	f := readUntil(t, fr, func(f http2.Frame) bool {
+		_, ok := f.(*http2.GoAwayFrame)
+		return ok
+	})
+	if code := f.(*http2.GoAwayFrame).Code; code != http2.ErrCodeEnhanceYourCalm {
+		t.Fatalf("GOAWAY with %v, want ENHANCE_YOUR_CALM", code)
+	}
+}
+
+// expectGoAway 读取帧直到 GOAWAY 并检查错误码
+func expectGoAway(t *testing.T, fr *http2.Framer, want http2.ErrCode) {
+	t.Helper()
+	f := readUntil(t, fr, func(f http2.Frame) bool {
+		_, ok := f.(*http2.GoAwayFrame)
+		return ok
+	})
+	if code := f.(*http2.GoAwayFrame).Code; code != want {
+		t.Fatalf("GOAWAY with %v, want %v", code, want)
+	}
+}
+
+func TestHTTP2EmptyContinuationFlood(t *testing.T) {
+	h := HandlerFunc(func(w ResponseWriter, req *message.Request) {})
+	fr := startH2C(t, h, nil)
+	block := hpack.NewEncoder().AppendBlock(nil, hpack.HeaderField{Name: ":method", Value: "GET"})
+	if err := fr.WriteHeaders(1, true, false, block); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range 10000 {
+			if fr.WriteContinuation(1, false, nil) != nil {
+				return
+			}
+		}
+	}()
+	expectGoAway(t, fr, http2.ErrCodeEnhanceYourCalm)
+}
+
+func TestHTTP2HeaderBlockKeepsReadDeadline(t *testing.T) {
+	h := HandlerFunc(func(w ResponseWriter, req *message.Request) {})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{Handler: h, H2C: true, ReadHeaderTimeout: 100 * time.Millisecond}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte(http2.ClientPreface))
+	fr := http2.NewFramer(c, c)
+	fr.WriteSettings()
+	fr.WriteHeaders(1, true, false, hpack.NewEncoder().AppendBlock(nil, hpack.HeaderField{Name: ":method", Value: "GET"}))
+
+	// 头部块不结束, 连接应在 ReadHeaderTimeout 后关闭
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, err := fr.ReadFrame(); err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				t.Fatal("connection still open with an unfinished header block")
+			}
+			return
+		}
+	}
+}
+
+func TestHTTP2PingFloodGoAway(t *testing.T) {
+	h := HandlerFunc(func(w ResponseWriter, req *message.Request) {})
+	fr := startH2C(t, h, &HTTP2Config{MaxControlRate: 5})
+	go func() {
+		for range 100 {
+			if fr.WritePing(false, [8]byte{}) != nil {
+				return
+			}
+		}
+	}()
+	expectGoAway(t, fr, http2.ErrCodeEnhanceYourCalm)
+}
@@ -0,0 +1,732 @@
+package server
+
+/*
+	HTTP/3 服务 (实验性): 在调用方提供的 QUIC 监听器上提供服务, 每个请求流在独立的协程中读取请求并调用处理器.
+	流量控制和分片由 QUIC 负责; QPACK 不使用动态表, 编码器/解码器流上没有需要处理的指令
+*/
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/hpack"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http1"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http3"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/qpack"
+	"github.com/narcilee7/http-stack/pkg/quic"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// HTTP3Config HTTP/3 参数, 零值字段使用默认值
+type HTTP3Config struct {
+	// MaxFieldSectionSize 请求头部和 trailer 字段节的最大大小, 0 表示 1MB
+	MaxFieldSectionSize uint64
+}
+
+// maxH3ControlFrame 控制流上单个帧载荷的上限
+const maxH3ControlFrame = 16 << 10
+
+// withDefaults 返回补全默认值后的副本, c 可以为空
+func (c *HTTP3Config) withDefaults() HTTP3Config {
+	var conf HTTP3Config
+	if c != nil {
+		conf = *c
+	}
+	if conf.MaxFieldSectionSize == 0 {
+		conf.MaxFieldSectionSize = http1.DefaultMaxHeaderBytes
+	}
+	return conf
+}
+
+// ServeQUIC 在 QUIC 监听器 ln 上提供 HTTP/3 服务 (实验性), 直到 ln 关闭或服务器关闭.
+// ln 的 TLS 配置应通告 ALPN "h3"; 通常同时设置 AltSvc, 让 HTTP/1.1 和 HTTP/2 客户端发现该端点
+func (s *Server) ServeQUIC(ln quic.Listener) error {
+	if s.Handler == nil {
+		return errors.New("server: nil handler")
+	}
+	s.mu.Lock()
+	if s.shutdown.Load() {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	if s.quic == nil {
+		s.quic = make(map[quic.Listener]struct{})
+	}
+	s.quic[ln] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.quic, ln)
+		s.mu.Unlock()
+	}()
+
+	for {
+		qc, err := ln.Accept(context.Background())
+		if err != nil {
+			if s.shutdown.Load() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.shutdown.Load() {
+			s.mu.Unlock()
+			qc.CloseWithError(uint64(http3.ErrCodeNo), "")
+			return ErrServerClosed
+		}
+		s.quicWG.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.quicWG.Done()
+			s.serveHTTP3(qc)
+		}()
+	}
+}
+
+// shutdownQUIC 关闭 QUIC 监听器并等待 HTTP/3 连接结束, ctx 结束时强制关闭剩余连接
+func (s *Server) shutdownQUIC(ctx context.Context) error {
+	s.closeQUIC(false)
+	done := make(chan struct{})
+	go func() {
+		s.quicWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.closeQUIC(true)
+		return ctx.Err()
+	}
+}
+
+// closeQUIC 关闭 QUIC 监听器, force 为 true 时同时关闭所有 HTTP/3 连接
+func (s *Server) closeQUIC(force bool) {
+	s.mu.Lock()
+	lns := make([]quic.Listener, 0, len(s.quic))
+	for ln := range s.quic {
+		lns = append(lns, ln)
+	}
+	var conns []*h3Conn
+	if force {
+		for c := range s.conns {
+			if hc, ok := c.(*h3Conn); ok {
+				conns = append(conns, hc)
+			}
+		}
+	}
+	s.mu.Unlock()
+	for _, ln := range lns {
+		ln.Close()
+	}
+	for _, hc := range conns {
+		hc.qc.CloseWithError(uint64(http3.ErrCodeNo), "server closed")
+	}
+}
+
+// h3Conn 一条 HTTP/3 连接
+type h3Conn struct {
+	srv  *Server
+	conf HTTP3Config
+	qc   quic.Conn
+	ctx  context.Context
+	wg   sync.WaitGroup
+
+	// ctrl 本端的控制流, 由 ctrlMu 保护
+	ctrlMu sync.Mutex
+	ctrl   quic.SendStream
+
+	mu sync.Mutex
+	// uni 已收到的控制流和 QPACK 流, 每种只能有一个
+	uni map[http3.StreamType]bool
+	// active 处理中的请求流数量
+	active int
+	// nextID 下一个未处理的请求流 ID, 作为 GOAWAY 的参数
+	nextID     quic.StreamID
+	goAwaySent bool
+}
+
+// serveHTTP3 在 QUIC 连接上提供 HTTP/3 服务, 连接关闭后返回
+func (s *Server) serveHTTP3(qc quic.Conn) {
+	c := &h3Conn{
+		srv:  s,
+		conf: s.HTTP3.withDefaults(),
+		qc:   qc,
+		ctx:  withRemoteAddr(qc.Context(), qc.RemoteAddr()),
+		uni:  make(map[http3.StreamType]bool),
+	}
+	if !s.trackConn(c, true) {
+		qc.CloseWithError(uint64(http3.ErrCodeNo), "")
+		return
+	}
+	defer s.trackConn(c, false)
+	c.serve()
+}
+
+func (c *h3Conn) serve() {
+	defer c.wg.Wait()
+	go c.acceptUniStreams()
+	ctrl, err := c.qc.OpenUniStream(c.ctx)
+	if err != nil {
+		c.closeWithError(err)
+		return
+	}
+	buf := http3.AppendVarInt(nil, uint64(http3.StreamTypeControl))
+	buf = http3.AppendSettingsFrame(buf, http3.Setting{ID: http3.SettingMaxFieldSectionSize, Val: c.conf.MaxFieldSectionSize})
+	c.ctrlMu.Lock()
+	c.ctrl = ctrl
+	_, err = ctrl.Write(buf)
+	c.ctrlMu.Unlock()
+	if err != nil {
+		c.closeWithError(err)
+		return
+	}
+
+	for {
+		st, err := c.qc.AcceptStream(c.ctx)
+		if err != nil {
+			return
+		}
+		c.mu.Lock()
+		if c.goAwaySent {
+			c.mu.Unlock()
+			st.CancelRead(uint64(http3.ErrCodeRequestRejected))
+			st.CancelWrite(uint64(http3.ErrCodeRequestRejected))
+			continue
+		}
+		c.nextID = max(c.nextID, st.StreamID()+4)
+		c.active++
+		c.mu.Unlock()
+		c.wg.Add(1)
+		go c.serveStream(st)
+	}
+}
+
+// closeWithError 关闭连接: 连接错误使用其错误码, 其余错误使用 H3_INTERNAL_ERROR
+func (c *h3Conn) closeWithError(err error) {
+	code := http3.ErrCodeInternal
+	var ce http3.ConnectionError
+	if errors.As(err, &ce) {
+		code = http3.ErrCode(ce)
+	}
+	c.qc.CloseWithError(uint64(code), err.Error())
+}
+
+// streamError 按错误的级别处理请求流上的错误: 连接错误关闭连接, 流错误重置该流, 其余错误 (如流已被对端重置) 忽略
+func (c *h3Conn) streamError(st quic.Stream, err error) {
+	var ce http3.ConnectionError
+	var se *http3.StreamError
+	switch {
+	case errors.As(err, &ce):
+		c.closeWithError(err)
+	case errors.As(err, &se):
+		st.CancelRead(uint64(se.Code))
+		st.CancelWrite(uint64(se.Code))
+	}
+}
+
+// startShutdown 发送 GOAWAY, 之后到达的请求流被拒绝, 处理中的请求结束后关闭连接
+func (c *h3Conn) startShutdown() {
+	c.mu.Lock()
+	if c.goAwaySent {
+		c.mu.Unlock()
+		return
+	}
+	c.goAwaySent = true
+	id := c.nextID
+	idle := c.active == 0
+	c.mu.Unlock()
+
+	c.ctrlMu.Lock()
+	if c.ctrl != nil {
+		c.ctrl.Write(http3.AppendGoAwayFrame(nil, uint64(id)))
+	}
+	c.ctrlMu.Unlock()
+	if idle {
+		c.closeAfterGoAway()
+	}
+}
+
+// streamDone 在请求流结束时调用, 已发送 GOAWAY 且没有其他请求时关闭连接
+func (c *h3Conn) streamDone() {
+	c.mu.Lock()
+	c.active--
+	idle := c.goAwaySent && c.active == 0
+	c.mu.Unlock()
+	if idle {
+		c.closeAfterGoAway()
+	}
+}
+
+// closeAfterGoAway 稍后关闭连接, 给最后的响应数据留出送达的时间
+func (c *h3Conn) closeAfterGoAway() {
+	time.AfterFunc(goAwayTimeout, func() {
+		c.qc.CloseWithError(uint64(http3.ErrCodeNo), "")
+	})
+}
+
+// acceptUniStreams 接受客户端发起的单向流, 连接关闭时返回
+func (c *h3Conn) acceptUniStreams() {
+	for {
+		rs, err := c.qc.AcceptUniStream(c.ctx)
+		if err != nil {
+			return
+		}
+		go c.handleUniStream(rs)
+	}
+}
+
+// handleUniStream 按流类型处理单向流 (RFC 9114 6.2). 控制流和 QPACK 流是关键流, 在连接存续期间不能关闭
+func (c *h3Conn) handleUniStream(rs quic.ReceiveStream) {
+	br := bufio.NewReader(rs)
+	v, err := http3.ReadVarInt(br)
+	if err != nil {
+		// 流在类型之前结束或连接已关闭
+		return
+	}
+	typ := http3.StreamType(v)
+	switch typ {
+	case http3.StreamTypeControl, http3.StreamTypeQPACKEncoder, http3.StreamTypeQPACKDecoder:
+	case http3.StreamTypePush:
+		// 只有服务端可以发起推送流
+		c.closeWithError(http3.ConnectionError(http3.ErrCodeStreamCreation))
+		return
+	default:
+		// 未知类型的流可以忽略
+		rs.CancelRead(uint64(http3.ErrCodeStreamCreation))
+		return
+	}
+	c.mu.Lock()
+	dup := c.uni[typ]
+	c.uni[typ] = true
+	c.mu.Unlock()
+	if dup {
+		c.closeWithError(http3.ConnectionError(http3.ErrCodeStreamCreation))
+		return
+	}
+
+	if typ == http3.StreamTypeControl {
+		err = c.readControl(br)
+	} else if _, err = io.Copy(io.Discard, br); err == nil {
+		// 本端通告的动态表容量为 0, 对端的编码器流只能把容量设为 0, 解码器流也没有需要确认的字段节, 读取后丢弃
+		err = io.EOF
+	}
+	if err == io.EOF {
+		err = http3.ConnectionError(http3.ErrCodeClosedCriticalStream)
+	}
+	var ce http3.ConnectionError
+	if errors.As(err, &ce) {
+		c.closeWithError(err)
+	}
+}
+
+// readControl 读取客户端的控制流, 第一个帧必须是 SETTINGS
+func (c *h3Conn) readControl(br *bufio.Reader) error {
+	fr := http3.NewFrameReader(br)
+	for first := true; ; first = false {
+		t, _, err := fr.Next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case first && t != http3.FrameSettings:
+			return http3.ConnectionError(http3.ErrCodeMissingSettings)
+		case !first && t == http3.FrameSettings,
+			t == http3.FrameData, t == http3.FrameHeaders, t == http3.FramePushPromise:
+			return http3.ConnectionError(http3.ErrCodeFrameUnexpected)
+		}
+		p, err := fr.ReadPayload(maxH3ControlFrame)
+		if errors.Is(err, http3.ErrFrameTooLarge) {
+			return http3.ConnectionError(http3.ErrCodeExcessiveLoad)
+		}
+		if err != nil {
+			return err
+		}
+		switch t {
+		case http3.FrameSettings:
+			// 不使用动态表时对端的 QPACK 参数不影响编码, MAX_FIELD_SECTION_SIZE 只是建议
+			if _, err := http3.ParseSettings(p); err != nil {
+				return err
+			}
+		case http3.FrameGoAway:
+			// 客户端的 GOAWAY 针对服务端推送, 本端不推送
+			if _, err := http3.ParseGoAway(p); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// serveStream 读取请求流上的请求, 调用处理器并结束响应
+func (c *h3Conn) serveStream(st quic.Stream) {
+	defer c.wg.Done()
+	defer c.streamDone()
+	fr := http3.NewFrameReader(st)
+	t, _, err := fr.Next()
+	switch {
+	case err == io.EOF:
+		c.streamError(st, &http3.StreamError{StreamID: int64(st.StreamID()), Code: http3.ErrCodeRequestIncomplete})
+		return
+	case err != nil:
+		c.streamError(st, err)
+		return
+	case t != http3.FrameHeaders:
+		c.streamError(st, http3.ConnectionError(http3.ErrCodeFrameUnexpected))
+		return
+	}
+	fields, err := c.readFieldSection(fr)
+	if errors.Is(err, http3.ErrFrameTooLarge) || errors.Is(err, qpack.ErrFieldSectionTooLarge) {
+		w := &h3Response{st: st}
+		if w.writeHeaders(common.StatusRequestHeaderFieldsTooLarge, nil) == nil {
+			st.Close()
+		}
+		st.CancelRead(uint64(http3.ErrCodeNo))
+		return
+	}
+	if err != nil {
+		c.streamError(st, err)
+		return
+	}
+	req, declLen, err := requestFromFields(fields, "HTTP/3.0")
+	if err != nil {
+		c.streamError(st, &http3.StreamError{StreamID: int64(st.StreamID()), Code: http3.ErrCodeMessage, Cause: err})
+		return
+	}
+	body := &h3Body{c: c, st: st, fr: fr, declLen: declLen}
+	req.Body = body
+	req.ContentLength = declLen
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+	req = req.WithContext(ctx)
+	w := &h3Response{st: st, req: req, header: common.Header{"Date": {utils.HTTPDate()}}, contentLength: -1}
+	if !c.callHandler(w, req) {
+		st.CancelRead(uint64(http3.ErrCodeInternal))
+		st.CancelWrite(uint64(http3.ErrCodeInternal))
+		return
+	}
+	if err := w.finish(); err != nil {
+		return
+	}
+	if body.err == nil {
+		// 处理器没有读完请求体, 响应已完整发出, 让客户端停止发送 (RFC 9114 4.1)
+		st.CancelRead(uint64(http3.ErrCodeNo))
+	}
+}
+
+// readFieldSection 读取当前 HEADERS 帧并解码字段节. 超过 MaxFieldSectionSize 时返回
+// http3.ErrFrameTooLarge 或 qpack.ErrFieldSectionTooLarge, 其余解码错误是连接错误
+func (c *h3Conn) readFieldSection(fr *http3.FrameReader) ([]hpack.HeaderField, error) {
+	p, err := fr.ReadPayload(c.conf.MaxFieldSectionSize)
+	if err != nil {
+		return nil, err
+	}
+	dec := qpack.Decoder{MaxFieldSectionSize: c.conf.MaxFieldSectionSize, MaxStringLength: int(c.conf.MaxFieldSectionSize)}
+	fields, err := dec.Decode(p)
+	if errors.Is(err, qpack.ErrFieldSectionTooLarge) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, http3.ConnectionError(http3.ErrCodeQPACKDecompressionFailed)
+	}
+	return fields, nil
+}
+
+func (c *h3Conn) callHandler(w *h3Response, req *message.Request) (ok bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			c.srv.logPanic(req, v, debug.Stack())
+			ok = false
+		}
+	}()
+	c.srv.Handler.ServeHTTP(w, req)
+	return true
+}
+
+// h3Body HTTP/3 请求体, 由处理器所在的协程直接从请求流读取 DATA 帧
+type h3Body struct {
+	c  *h3Conn
+	st quic.Stream
+	fr *http3.FrameReader
+	// declLen Content-Length 声明的长度, -1 表示未声明
+	declLen int64
+	gotLen  int64
+	// inData 正在读取 DATA 帧的载荷
+	inData  bool
+	trailer common.Header
+	// err 读完时为 io.EOF, 之后的读取都返回它
+	err    error
+	closed bool
+}
+
+func (b *h3Body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, errBodyClosed
+	}
+	for b.err == nil {
+		if !b.inData {
+			b.err = b.next()
+			continue
+		}
+		n, err := b.fr.Read(p)
+		if err == io.EOF {
+			b.inData = false
+			continue
+		}
+		b.gotLen += int64(n)
+		if b.declLen >= 0 && b.gotLen > b.declLen {
+			b.err = b.fail(b.messageError("body exceeds content-length %d", b.declLen))
+			return 0, b.err
+		}
+		if err != nil {
+			b.err = b.fail(err)
+		}
+		return n, err
+	}
+	return 0, b.err
+}
+
+// next 读取下一个帧头: DATA 继续消息体, HEADERS 为 trailer 且之后流必须结束
+func (b *h3Body) next() error {
+	t, _, err := b.fr.Next()
+	switch {
+	case err == io.EOF:
+		return b.end()
+	case err != nil:
+		return b.fail(err)
+	case t == http3.FrameData:
+		b.inData = true
+		return nil
+	case t != http3.FrameHeaders:
+		return b.fail(http3.ConnectionError(http3.ErrCodeFrameUnexpected))
+	}
+
+	fields, err := b.c.readFieldSection(b.fr)
+	if errors.Is(err, http3.ErrFrameTooLarge) || errors.Is(err, qpack.ErrFieldSectionTooLarge) {
+		return b.fail(&http3.StreamError{StreamID: int64(b.st.StreamID()), Code: http3.ErrCodeExcessiveLoad, Cause: err})
+	}
+	if err != nil {
+		return b.fail(err)
+	}
+	trailer := make(common.Header, len(fields))
+	for _, f := range fields {
+		if f.IsPseudo() || !validH2FieldName(f.Name) {
+			return b.fail(b.messageError("invalid trailer field %q", f.Name))
+		}
+		trailer.Add(f.Name, f.Value)
+	}
+	if _, _, err := b.fr.Next(); err == nil {
+		return b.fail(http3.ConnectionError(http3.ErrCodeFrameUnexpected))
+	} else if err != io.EOF {
+		return b.fail(err)
+	}
+	b.trailer = trailer
+	return b.end()
+}
+
+// end 在请求流结束时检查消息体长度
+func (b *h3Body) end() error {
+	if b.declLen >= 0 && b.gotLen != b.declLen {
+		return b.fail(b.messageError("body length %d does not match content-length %d", b.gotLen, b.declLen))
+	}
+	return io.EOF
+}
+
+func (b *h3Body) messageError(format string, args ...any) error {
+	return &http3.StreamError{StreamID: int64(b.st.StreamID()), Code: http3.ErrCodeMessage, Cause: fmt.Errorf(format, args...)}
+}
+
+// fail 按错误级别重置流或关闭连接, 并把错误返回给处理器
+func (b *h3Body) fail(err error) error {
+	b.c.streamError(b.st, err)
+	return err
+}
+
+// Trailer 返回请求体读完后收到的 trailer
+func (b *h3Body) Trailer() common.Header { return b.trailer }
+
+// Close 之后的读取返回错误; 未读的数据在响应结束后通过 STOP_SENDING 拒收
+func (b *h3Body) Close() error {
+	b.closed = true
+	return nil
+}
+
+// h3Response HTTP/3 的 ResponseWriter, 实现 Flusher 和 TrailerWriter; 流不能被接管
+type h3Response struct {
+	st      quic.Stream
+	req     *message.Request
+	header  common.Header
+	trailer common.Header
+
+	status      int
+	wroteHeader bool
+	sentHeader  bool
+	buf         []byte
+	// contentLength 声明的长度, -1 表示未声明
+	contentLength int64
+	written       int64
+	err           error
+	// fields 和 hdr 编码时复用
+	fields []hpack.HeaderField
+	hdr    []byte
+}
+
+func (w *h3Response) Header() common.Header { return w.header }
+
+// Trailer 返回在响应体之后发送的 trailer
+func (w *h3Response) Trailer() common.Header {
+	if w.trailer == nil {
+		w.trailer = make(common.Header)
+	}
+	return w.trailer
+}
+
+func (w *h3Response) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	if code >= 100 && code < 200 {
+		// 1xx 信息性响应立即发出; HTTP/3 不支持 101
+		if code != common.StatusSwitchingProtocols && w.err == nil {
+			w.err = w.writeHeaders(code, w.header)
+		}
+		return
+	}
+	w.wroteHeader = true
+	w.status = code
+	if v := w.header.Get("Content-Length"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			w.contentLength = n
+		} else {
+			w.header.Del("Content-Length")
+		}
+	}
+}
+
+func (w *h3Response) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(common.StatusOK)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if !common.BodyAllowedForStatus(w.status) {
+		return 0, ErrBodyNotAllowed
+	}
+	if w.contentLength >= 0 && w.written+int64(len(p)) > w.contentLength {
+		return 0, ErrContentLength
+	}
+	w.written += int64(len(p))
+	if w.req.Method == common.MethodHead {
+		return len(p), nil
+	}
+	if !w.sentHeader && len(w.buf)+len(p) <= bufferBodySize {
+		w.buf = append(w.buf, p...)
+		return len(p), nil
+	}
+	if err := w.sendHeader(); err != nil {
+		return 0, err
+	}
+	if w.err = w.writeData(p); w.err != nil {
+		return 0, w.err
+	}
+	return len(p), nil
+}
+
+// sendHeader 发出头部和缓冲的响应体
+func (w *h3Response) sendHeader() error {
+	if w.sentHeader {
+		return w.err
+	}
+	w.sentHeader = true
+	if w.err = w.writeHeaders(w.status, w.header); w.err != nil {
+		return w.err
+	}
+	buf := w.buf
+	w.buf = nil
+	w.err = w.writeData(buf)
+	return w.err
+}
+
+// Flush 发出已写入的头部和响应体
+func (w *h3Response) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(common.StatusOK)
+	}
+	w.sendHeader()
+}
+
+// Hijack HTTP/3 流不能被接管
+func (w *h3Response) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, ErrHijackUnsupported
+}
+
+// finish 在处理器返回后结束响应: 发出剩余数据和 trailer, 然后结束流的发送方向
+func (w *h3Response) finish() error {
+	if !w.wroteHeader {
+		w.WriteHeader(common.StatusOK)
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if !w.sentHeader {
+		if common.BodyAllowedForStatus(w.status) && w.contentLength < 0 && (w.req.Method != common.MethodHead || w.written > 0) {
+			w.header.Set("Content-Length", strconv.FormatInt(w.written, 10))
+		}
+		if err := w.sendHeader(); err != nil {
+			return err
+		}
+	}
+	if len(w.trailer) > 0 {
+		if err := w.writeHeaders(0, w.trailer); err != nil {
+			return err
+		}
+	}
+	return w.st.Close()
+}
+
+// writeHeaders 以 HEADERS 帧发出字段节, status 为 0 时表示 trailer
+func (w *h3Response) writeHeaders(status int, h common.Header) error {
+	fields := w.fields[:0]
+	if status != 0 {
+		fields = append(fields, hpack.HeaderField{Name: ":status", Value: strconv.Itoa(status)})
+	}
+	for k, vs := range h {
+		name := strings.ToLower(k)
+		if h2ConnectionHeaders[name] {
+			continue
+		}
+		for _, v := range vs {
+			fields = append(fields, hpack.HeaderField{Name: name, Value: v})
+		}
+	}
+	w.fields = fields
+	block := qpack.AppendFieldSection(nil, fields...)
+	buf := http3.AppendFrameHeader(make([]byte, 0, len(block)+16), http3.FrameHeaders, uint64(len(block)))
+	_, err := w.st.Write(append(buf, block...))
+	return err
+}
+
+// writeData 以一个 DATA 帧发出 p
+func (w *h3Response) writeData(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	w.hdr = http3.AppendFrameHeader(w.hdr[:0], http3.FrameData, uint64(len(p)))
+	if _, err := w.st.Write(w.hdr); err != nil {
+		return err
+	}
+	_, err := w.st.Write(p)
+	return err
+}
@@ -0,0 +1,88 @@
+package server
+
+/*
+	指标中间件和 Prometheus 导出端点: MetricsHandler 按方法和状态码统计请求数、耗时和响应字节数,
+	PrometheusHandler 以文本格式输出注册表中的全部指标
+*/
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/metrics"
+)
+
+// MetricsHandler 统计 Handler 处理的请求
+type MetricsHandler struct {
+	// Handler 被统计的处理器
+	Handler Handler
+	// Metrics 上报的注册表, 为空时不统计
+	Metrics *metrics.Registry
+
+	once     sync.Once
+	requests *metrics.CounterVec
+	duration *metrics.HistogramVec
+	inFlight *metrics.Gauge
+	sent     *metrics.CounterVec
+}
+
+func (h *MetricsHandler) init() {
+	h.once.Do(func() {
+		r := h.Metrics
+		h.requests = r.CounterVec("http_server_requests_total", "Requests served.", "method", "code")
+		h.duration = r.HistogramVec("http_server_request_duration_seconds", "Time spent in the handler.", metrics.DefBuckets, "method")
+		h.inFlight = r.Gauge("http_server_requests_in_flight", "Requests currently being served.")
+		h.sent = r.CounterVec("http_server_response_body_bytes_total", "Response body bytes written.", "method")
+	})
+}
+
+// ServeHTTP 实现 Handler
+func (h *MetricsHandler) ServeHTTP(w ResponseWriter, req *message.Request) {
+	if h.Metrics == nil {
+		h.Handler.ServeHTTP(w, req)
+		return
+	}
+	h.init()
+	method := methodLabel(req.Method)
+	start := time.Now()
+	h.inFlight.Inc()
+	sw := &statusWriter{w: w}
+	defer func() {
+		// 处理器 panic 时也要计数, panic 继续交给服务器处理
+		h.inFlight.Dec()
+		h.duration.With(method).ObserveDuration(time.Since(start))
+		h.requests.With(method, strconv.Itoa(sw.code())).Inc()
+		h.sent.With(method).Add(uint64(sw.written))
+	}()
+	h.Handler.ServeHTTP(sw, req)
+}
+
+// methodLabel 把非标准方法归为 OTHER, 避免客户端随意的方法名撑大序列数
+func methodLabel(method string) string {
+	switch method {
+	case common.MethodGet, common.MethodHead, common.MethodPost, common.MethodPut, common.MethodPatch,
+		common.MethodDelete, common.MethodConnect, common.MethodOptions, common.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// PrometheusHandler 返回以 Prometheus 文本格式输出 r 中指标的处理器
+func PrometheusHandler(r *metrics.Registry) Handler {
+	return HandlerFunc(func(w ResponseWriter, req *message.Request) {
+		if req.Method != common.MethodGet && req.Method != common.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			w.WriteHeader(common.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", metrics.PrometheusContentType)
+		w.Header().Set("Cache-Control", "no-store")
+		if req.Method == common.MethodHead {
+			return
+		}
+		r.WritePrometheus(w)
+	})
+}
@@ -1 +1,330 @@
 package server
+
+/*
+	通用中间件: CompressHandler 按 Accept-Encoding 压缩响应, AccessLogHandler 为每个请求记录访问日志
+*/
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/log"
+)
+
+// DefaultCompressMinSize CompressHandler 默认的最小压缩长度, 更小的响应压缩后往往反而变大
+const DefaultCompressMinSize = 1024
+
+// DefaultCompressTypes CompressHandler 默认压缩的媒体类型
+var DefaultCompressTypes = []string{
+	"text/html", "text/css", "text/plain", "text/javascript", "text/xml", "text/csv",
+	"application/javascript", "application/json", "application/xml", "application/wasm",
+	"image/svg+xml",
+}
+
+// CompressHandler 以 gzip 或 deflate 压缩 Handler 的响应. 已有 Content-Encoding、Range 响应、
+// 媒体类型不在 Types 中或长度小于 MinSize 的响应原样发送
+type CompressHandler struct {
+	// Handler 被压缩的处理器
+	Handler Handler
+	// Level 压缩级别 (compress/flate), 0 时使用默认级别
+	Level int
+	// MinSize 最小压缩长度, 0 时使用 DefaultCompressMinSize
+	MinSize int
+	// Types 可压缩的媒体类型, 支持 "text/*" 形式; 为空时使用 DefaultCompressTypes
+	Types []string
+
+	once    sync.Once
+	gzip    sync.Pool
+	deflate sync.Pool
+}
+
+func (h *CompressHandler) init() {
+	h.once.Do(func() {
+		level := h.Level
+		if level == 0 {
+			level = flate.DefaultCompression
+		}
+		h.gzip.New = func() any {
+			zw, err := gzip.NewWriterLevel(io.Discard, level)
+			if err != nil {
+				zw = gzip.NewWriter(io.Discard)
+			}
+			return zw
+		}
+		h.deflate.New = func() any {
+			zw, err := flate.NewWriter(io.Discard, level)
+			if err != nil {
+				zw, _ = flate.NewWriter(io.Discard, flate.DefaultCompression)
+			}
+			return zw
+		}
+	})
+}
+
+// ServeHTTP 实现 Handler
+func (h *CompressHandler) ServeHTTP(w ResponseWriter, req *message.Request) {
+	ae := req.Header.Values("Accept-Encoding")
+	if len(ae) == 0 || req.Method == common.MethodHead || req.Header.Has("Upgrade") {
+		h.Handler.ServeHTTP(w, req)
+		return
+	}
+	enc := common.Negotiate(ae, "gzip", "deflate")
+	if enc == "" {
+		h.Handler.ServeHTTP(w, req)
+		return
+	}
+	h.init()
+	cw := &compressWriter{h: h, w: w, enc: enc}
+	defer cw.finish()
+	h.Handler.ServeHTTP(cw, req)
+}
+
+func (h *CompressHandler) minSize() int {
+	if h.MinSize > 0 {
+		return h.MinSize
+	}
+	return DefaultCompressMinSize
+}
+
+// compressible 报告媒体类型是否在 Types 中
+func (h *CompressHandler) compressible(ctype string) bool {
+	mt, _, _ := strings.Cut(ctype, ";")
+	mt = strings.ToLower(strings.TrimSpace(mt))
+	if mt == "" {
+		return false
+	}
+	types := h.Types
+	if len(types) == 0 {
+		types = DefaultCompressTypes
+	}
+	for _, t := range types {
+		if t == mt || strings.HasSuffix(t, "/*") && strings.HasPrefix(mt, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// flushWriter gzip.Writer 和 flate.Writer 的公共方法
+type flushWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressWriter 先缓冲不超过 MinSize 的数据再决定是否压缩, 决定之前不向底层写出头部
+type compressWriter struct {
+	h   *CompressHandler
+	w   ResponseWriter
+	enc string
+
+	status int
+	// decided 已决定是否压缩并写出了头部; zw 非空表示压缩
+	decided  bool
+	zw       flushWriter
+	buf      []byte
+	hijacked bool
+}
+
+func (cw *compressWriter) Header() common.Header { return cw.w.Header() }
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided || cw.status != 0 {
+		return
+	}
+	if code >= 100 && code < 200 {
+		cw.w.WriteHeader(code)
+		return
+	}
+	cw.status = code
+	if !cw.eligible() {
+		cw.passthrough()
+	}
+}
+
+// eligible 报告仅凭状态码和头部是否可能压缩
+func (cw *compressWriter) eligible() bool {
+	h := cw.w.Header()
+	switch {
+	case cw.status == common.StatusNoContent, cw.status == common.StatusNotModified,
+		cw.status == common.StatusPartialContent:
+		return false
+	case h.Get("Content-Encoding") != "", h.Has("Content-Range"):
+		return false
+	case !cw.h.compressible(h.Get("Content-Type")):
+		return false
+	}
+	if n, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil && n < int64(cw.h.minSize()) {
+		return false
+	}
+	return true
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(common.StatusOK)
+	}
+	if cw.decided {
+		if cw.zw != nil {
+			return cw.zw.Write(p)
+		}
+		return cw.w.Write(p)
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.h.minSize() {
+		if err := cw.startCompress(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// passthrough 不压缩, 写出头部和已缓冲的数据
+func (cw *compressWriter) passthrough() error {
+	cw.decided = true
+	cw.w.WriteHeader(cw.status)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	_, err := cw.w.Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+// startCompress 开始压缩, 写出头部和已缓冲的数据
+func (cw *compressWriter) startCompress() error {
+	cw.decided = true
+	h := cw.w.Header()
+	h.Set("Content-Encoding", cw.enc)
+	h.Add("Vary", "Accept-Encoding")
+	h.Del("Content-Length")
+	// 压缩后的表示与原内容不再逐字节相同, 强 ETag 降为弱 ETag
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+	cw.w.WriteHeader(cw.status)
+	if cw.enc == "gzip" {
+		zw := cw.h.gzip.Get().(*gzip.Writer)
+		zw.Reset(cw.w)
+		cw.zw = zw
+	} else {
+		zw := cw.h.deflate.Get().(*flate.Writer)
+		zw.Reset(cw.w)
+		cw.zw = zw
+	}
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	_, err := cw.zw.Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+// finish 在处理器返回后结束响应: 数据不足 MinSize 时原样写出, 否则结束压缩流
+func (cw *compressWriter) finish() {
+	if cw.hijacked {
+		return
+	}
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = common.StatusOK
+		}
+		cw.w.Header().Set("Content-Length", strconv.Itoa(len(cw.buf)))
+		cw.passthrough()
+		return
+	}
+	if cw.zw == nil {
+		return
+	}
+	cw.zw.Close()
+	if gz, ok := cw.zw.(*gzip.Writer); ok {
+		gz.Reset(io.Discard)
+		cw.h.gzip.Put(gz)
+	} else {
+		fw := cw.zw.(*flate.Writer)
+		fw.Reset(io.Discard)
+		cw.h.deflate.Put(fw)
+	}
+	cw.zw = nil
+}
+
+// Flush 实现 Flusher; 尚未决定时按可压缩处理, 以便流式响应立即发送
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.WriteHeader(common.StatusOK)
+		}
+		if !cw.decided {
+			cw.startCompress()
+		}
+	}
+	if cw.zw != nil {
+		cw.zw.Flush()
+	}
+	if fl, ok := cw.w.(Flusher); ok {
+		fl.Flush()
+	}
+}
+
+// Hijack 实现 Hijacker, 只能在写出任何内容之前调用
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := cw.w.(Hijacker)
+	if !ok || cw.status != 0 {
+		return nil, nil, ErrHijackUnsupported
+	}
+	nc, brw, err := hj.Hijack()
+	if err == nil {
+		cw.hijacked = true
+	}
+	return nc, brw, err
+}
+
+// AccessLogHandler 在每个请求结束后以 Info 级别记录一条访问日志
+type AccessLogHandler struct {
+	// Handler 被记录的处理器
+	Handler Handler
+	// Logger 访问日志的输出, 为空时使用 log.Default()
+	Logger *log.Logger
+}
+
+// ServeHTTP 实现 Handler
+func (h *AccessLogHandler) ServeHTTP(w ResponseWriter, req *message.Request) {
+	start := time.Now()
+	sw := &statusWriter{w: w}
+	defer func() {
+		fields := []log.Field{
+			log.String("method", req.Method),
+			log.String("uri", req.URL.RequestURI()),
+			log.String("proto", req.Proto),
+			log.Int("status", sw.code()),
+			log.Int64("bytes", sw.written),
+			log.Duration("duration", time.Since(start)),
+		}
+		if addr := RemoteAddr(req); addr != nil {
+			host, _, err := net.SplitHostPort(addr.String())
+			if err != nil {
+				host = addr.String()
+			}
+			fields = append(fields, log.String("remote", host))
+		}
+		if host := req.HostHeader(); host != "" {
+			fields = append(fields, log.String("host", host))
+		}
+		if ua := req.Header.Get("User-Agent"); ua != "" {
+			fields = append(fields, log.String("user_agent", ua))
+		}
+		if ref := req.Header.Get("Referer"); ref != "" {
+			fields = append(fields, log.String("referer", ref))
+		}
+		log.Or(h.Logger).Info("access", fields...)
+	}()
+	h.Handler.ServeHTTP(sw, req)
+}
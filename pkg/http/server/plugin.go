@@ -0,0 +1,255 @@
+package server
+
+/*
+	插件注册表: 中间件和响应过滤器以名称注册, 由 JSON 配置实例化并按顺序组装成处理器链,
+	httpd 等程序因此可以通过配置文件启用中间件而无需重新编译. 内置插件在 init 中注册
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+// ErrUnknownPlugin 没有以该名称注册的插件
+var ErrUnknownPlugin = errors.New("server: unknown plugin")
+
+// Middleware 包装处理器的中间件
+type Middleware func(next Handler) Handler
+
+// PluginFactory 根据 JSON 配置创建中间件; 未提供配置时 config 为空
+type PluginFactory func(config json.RawMessage) (Middleware, error)
+
+// ResponseFilter 在响应头写出前调用, 可以修改响应头部; status 是处理器写出的状态码
+type ResponseFilter func(req *message.Request, status int, header common.Header)
+
+// FilterFactory 根据 JSON 配置创建响应过滤器
+type FilterFactory func(config json.RawMessage) (ResponseFilter, error)
+
+// PluginConfig 配置文件中的一个插件, 如 {"name": "rate_limit", "config": {"rate": 100}}
+type PluginConfig struct {
+	Name   string          `json:"name"`
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+var plugins struct {
+	sync.RWMutex
+	m map[string]PluginFactory
+}
+
+// RegisterPlugin 以 name 注册中间件插件, 通常在 init 中调用; 名称为空或重复时 panic
+func RegisterPlugin(name string, f PluginFactory) {
+	if name == "" || f == nil {
+		panic("server: RegisterPlugin with empty name or nil factory")
+	}
+	plugins.Lock()
+	defer plugins.Unlock()
+	if _, dup := plugins.m[name]; dup {
+		panic("server: plugin " + name + " registered twice")
+	}
+	if plugins.m == nil {
+		plugins.m = make(map[string]PluginFactory)
+	}
+	plugins.m[name] = f
+}
+
+// RegisterFilter 以 name 注册响应过滤器, 实例化后以 FilterHandler 包装处理器; 与中间件共用名称空间
+func RegisterFilter(name string, f FilterFactory) {
+	if f == nil {
+		panic("server: RegisterFilter with nil factory")
+	}
+	RegisterPlugin(name, func(config json.RawMessage) (Middleware, error) {
+		filter, err := f(config)
+		if err != nil {
+			return nil, err
+		}
+		return func(next Handler) Handler { return &FilterHandler{Handler: next, Filter: filter} }, nil
+	})
+}
+
+// Plugins 返回已注册的插件名称, 按字母顺序排列
+func Plugins() []string {
+	plugins.RLock()
+	defer plugins.RUnlock()
+	names := make([]string, 0, len(plugins.m))
+	for name := range plugins.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewPlugin 以 config 实例化名为 name 的插件
+func NewPlugin(name string, config json.RawMessage) (Middleware, error) {
+	plugins.RLock()
+	f := plugins.m[name]
+	plugins.RUnlock()
+	if f == nil {
+		return nil, fmt.Errorf("%w %q", ErrUnknownPlugin, name)
+	}
+	mw, err := f(config)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", name, err)
+	}
+	return mw, nil
+}
+
+// Chain 用 mws 包装 h, 第一个中间件在最外层, 最先看到请求
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// BuildChain 按配置实例化插件并包装 h, 顺序同 Chain
+func BuildChain(h Handler, configs []PluginConfig) (Handler, error) {
+	mws := make([]Middleware, 0, len(configs))
+	for _, c := range configs {
+		mw, err := NewPlugin(c.Name, c.Config)
+		if err != nil {
+			return nil, err
+		}
+		mws = append(mws, mw)
+	}
+	return Chain(h, mws...), nil
+}
+
+// DecodePluginConfig 把插件配置解码到 v, 拒绝未知字段以便发现拼写错误; 配置为空时保留 v 的默认值
+func DecodePluginConfig(config json.RawMessage, v any) error {
+	if len(bytes.TrimSpace(config)) == 0 || bytes.Equal(bytes.TrimSpace(config), []byte("null")) {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(config))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// FilterHandler 在 Handler 写出响应头之前调用 Filter, 信息性 (1xx) 响应除外
+type FilterHandler struct {
+	// Handler 被过滤的处理器
+	Handler Handler
+	Filter  ResponseFilter
+}
+
+// ServeHTTP 实现 Handler
+func (h *FilterHandler) ServeHTTP(w ResponseWriter, req *message.Request) {
+	h.Handler.ServeHTTP(&filterWriter{statusWriter: &statusWriter{w: w}, req: req, filter: h.Filter}, req)
+}
+
+// filterWriter 在第一次写出最终响应头时调用过滤器; Flush、Hijack 和 Trailer 由 statusWriter 透传
+type filterWriter struct {
+	*statusWriter
+	req    *message.Request
+	filter ResponseFilter
+	done   bool
+}
+
+func (fw *filterWriter) WriteHeader(code int) {
+	if !fw.done && (code >= 200 || code == common.StatusSwitchingProtocols) {
+		fw.done = true
+		fw.filter(fw.req, code, fw.Header())
+	}
+	fw.statusWriter.WriteHeader(code)
+}
+
+func (fw *filterWriter) Write(p []byte) (int, error) {
+	if !fw.done {
+		fw.WriteHeader(common.StatusOK)
+	}
+	return fw.statusWriter.Write(p)
+}
+
+func init() {
+	RegisterPlugin("access_log", func(config json.RawMessage) (Middleware, error) {
+		if err := DecodePluginConfig(config, &struct{}{}); err != nil {
+			return nil, err
+		}
+		return func(next Handler) Handler { return &AccessLogHandler{Handler: next} }, nil
+	})
+	RegisterPlugin("compress", func(config json.RawMessage) (Middleware, error) {
+		var c struct {
+			Level   int      `json:"level"`
+			MinSize int      `json:"min_size"`
+			Types   []string `json:"types"`
+		}
+		if err := DecodePluginConfig(config, &c); err != nil {
+			return nil, err
+		}
+		return func(next Handler) Handler {
+			return &CompressHandler{Handler: next, Level: c.Level, MinSize: c.MinSize, Types: c.Types}
+		}, nil
+	})
+	RegisterPlugin("rate_limit", func(config json.RawMessage) (Middleware, error) {
+		var c struct {
+			Rate      float64 `json:"rate"`
+			Burst     int     `json:"burst"`
+			PerClient bool    `json:"per_client"`
+		}
+		if err := DecodePluginConfig(config, &c); err != nil {
+			return nil, err
+		}
+		if c.Rate <= 0 {
+			return nil, errors.New("rate must be positive")
+		}
+		return func(next Handler) Handler {
+			return &RateLimitHandler{Handler: next, Rate: c.Rate, Burst: c.Burst, PerClient: c.PerClient}
+		}, nil
+	})
+	RegisterPlugin("request_id", func(config json.RawMessage) (Middleware, error) {
+		var c struct {
+			Header string `json:"header"`
+		}
+		if err := DecodePluginConfig(config, &c); err != nil {
+			return nil, err
+		}
+		return func(next Handler) Handler { return &RequestIDHandler{Handler: next, Header: c.Header} }, nil
+	})
+	RegisterPlugin("cors", func(config json.RawMessage) (Middleware, error) {
+		var c struct {
+			AllowedOrigins   []string `json:"allowed_origins"`
+			AllowedMethods   []string `json:"allowed_methods"`
+			AllowedHeaders   []string `json:"allowed_headers"`
+			ExposedHeaders   []string `json:"exposed_headers"`
+			AllowCredentials bool     `json:"allow_credentials"`
+			MaxAge           int      `json:"max_age"`
+		}
+		if err := DecodePluginConfig(config, &c); err != nil {
+			return nil, err
+		}
+		return func(next Handler) Handler {
+			return &CORSHandler{
+				Handler: next, AllowedOrigins: c.AllowedOrigins, AllowedMethods: c.AllowedMethods,
+				AllowedHeaders: c.AllowedHeaders, ExposedHeaders: c.ExposedHeaders,
+				AllowCredentials: c.AllowCredentials, MaxAge: c.MaxAge,
+			}
+		}, nil
+	})
+	RegisterFilter("headers", func(config json.RawMessage) (ResponseFilter, error) {
+		var c struct {
+			Set    map[string]string `json:"set"`
+			Add    map[string]string `json:"add"`
+			Remove []string          `json:"remove"`
+		}
+		if err := DecodePluginConfig(config, &c); err != nil {
+			return nil, err
+		}
+		return func(_ *message.Request, _ int, h common.Header) {
+			for _, k := range c.Remove {
+				h.Del(k)
+			}
+			for k, v := range c.Set {
+				h.Set(k, v)
+			}
+			for k, v := range c.Add {
+				h.Add(k, v)
+			}
+		}, nil
+	})
+}
@@ -0,0 +1,58 @@
+package server_test
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	httptest "github.com/narcilee7/http-stack/internal/testing"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/server"
+)
+
+// 回放 testdata/replay 中的畸形流量, 服务器应返回预期的状态且不 panic、不挂起
+func TestServerReplayCaptures(t *testing.T) {
+	want := map[string]string{
+		"split_request.hex":   "HTTP/1.1 200 ",
+		"cl_te_smuggling.hex": "HTTP/1.1 400 ",
+		"bad_chunk_size.hex":  "HTTP/1.1 400 ",
+		"obs_fold.hex":        "HTTP/1.1 400 ",
+	}
+	var handled int
+	srv := &server.Server{Handler: server.HandlerFunc(func(w server.ResponseWriter, req *message.Request) {
+		handled++
+		// 消息体格式错误只能在读取时发现, 由处理器决定如何响应
+		if _, err := io.Copy(io.Discard, req.Body); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		io.WriteString(w, "hello")
+	})}
+	opts := httptest.ReplayOptions{SegmentDelay: 10 * time.Millisecond, Timeout: 2 * time.Second}
+	httptest.ReplayFiles(t, srv, filepath.Join("testdata", "replay", "*.hex"), 0, opts,
+		func(t *testing.T, c *httptest.Capture, res *httptest.ReplayResult) {
+			if res.Panic != nil {
+				t.Fatalf("server panicked: %v\n%s", res.Panic, res.Stack)
+			}
+			if res.TimedOut {
+				t.Fatalf("server did not close the connection, output %q", res.Output)
+			}
+			prefix, ok := want[c.Name]
+			if !ok {
+				t.Fatalf("no expectation for %s", c.Name)
+			}
+			if !bytes.HasPrefix(res.Output, []byte(prefix)) {
+				t.Fatalf("response %q, want prefix %q", res.Output, prefix)
+			}
+			// 被拒绝的请求之后的数据 (如走私的第二个请求) 不应得到响应
+			if n := bytes.Count(res.Output, []byte("HTTP/1.1 ")); n != 1 {
+				t.Fatalf("%d responses in %q", n, res.Output)
+			}
+		})
+	// 只有 split_request 和 bad_chunk_size 到达处理器
+	if handled != 2 {
+		t.Fatalf("handler ran %d times, want 2", handled)
+	}
+}
@@ -1,5 +1,250 @@
 package server
 
 /*
-	HTTP服务器实现, 支持HTTP/1.1和HTTP/2.0
+	HTTP服务器实现, 支持HTTP/1.1和HTTP/2.0, 以及实验性的HTTP/3
 */
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http2"
+	"github.com/narcilee7/http-stack/pkg/log"
+	"github.com/narcilee7/http-stack/pkg/metrics"
+	"github.com/narcilee7/http-stack/pkg/quic"
+	"github.com/narcilee7/http-stack/pkg/tcp"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// ErrServerClosed 服务器已关闭
+var ErrServerClosed = errors.New("server: server closed")
+
+// NextProtoHTTP1 TLS ALPN 中 HTTP/1.1 的协议标识
+const NextProtoHTTP1 = "http/1.1"
+
+// NextProtoACME ACME TLS-ALPN-01 验证使用的 ALPN 协议标识 (RFC 8737), 这样的连接握手后即关闭
+const NextProtoACME = "acme-tls/1"
+
+// Server HTTP 服务器. TLS 连接通过 ALPN 协商 HTTP/2, 处理器无需区分协议版本
+type Server struct {
+	// Addr 监听地址, 如 ":8080"
+	Addr string
+	// Handler 请求处理器
+	Handler Handler
+	// TLS 非空时启用 TLS; NextProtos 为空时通告 h2 和 http/1.1
+	TLS *tcp.TLSOptions
+	// ReadHeaderTimeout 读取请求头部的时间上限, 0 表示不限制
+	ReadHeaderTimeout time.Duration
+	// IdleTimeout 连接等待下一个请求的时间上限, 0 表示不限制
+	IdleTimeout time.Duration
+	// WriteTimeout HTTP/1.1 写出一个响应的时间上限, 0 表示不限制
+	WriteTimeout time.Duration
+	// HTTP2 HTTP/2 参数, 为空时使用默认值
+	HTTP2 *HTTP2Config
+	// HTTP2ForConn 按连接覆盖 HTTP2, 返回空时使用 HTTP2; 用于在同一服务器上比较不同的窗口和调度参数
+	HTTP2ForConn func(c *tcp.Conn) *HTTP2Config
+	// DisableHTTP2 为 true 时不通过 ALPN 协商 HTTP/2, 也不接受 h2c
+	DisableHTTP2 bool
+	// H2C 为 true 时明文连接也可以使用 HTTP/2: 以连接前言开头 (prior knowledge) 或通过 Upgrade: h2c 升级
+	H2C bool
+	// HTTP3 HTTP/3 参数, 为空时使用默认值; 只在通过 ServeQUIC 提供 HTTP/3 服务时使用
+	HTTP3 *HTTP3Config
+	// AltSvc 非空时作为 HTTP/1.1 和 HTTP/2 响应的 Alt-Svc 头部, 如 `h3=":443"; ma=86400`,
+	// 通告同一服务的 HTTP/3 端点 (RFC 7838); 处理器可以覆盖或删除
+	AltSvc string
+	// OnPanic 处理器 panic 时调用, 为空时以 Error 级别记录到 Logger
+	OnPanic func(req *message.Request, v any, stack []byte)
+	// Logger 服务器和底层 TCP 服务器的日志, 为空时使用 log.Default()
+	Logger *log.Logger
+	// Metrics 非空时底层 TCP 服务器向其上报连接指标; 请求级指标见 MetricsHandler
+	Metrics *metrics.Registry
+	// MemoryBudget 非空时交给底层 TCP 服务器, 连接的读写缓冲计入其中
+	MemoryBudget *tcp.MemoryBudget
+
+	mu       sync.Mutex
+	tcp      *tcp.Server
+	conns    map[serverConn]struct{}
+	shutdown atomic.Bool
+	// quic ServeQUIC 使用中的监听器, quicWG 跟踪其上的连接
+	quic   map[quic.Listener]struct{}
+	quicWG sync.WaitGroup
+}
+
+// serverConn 一条协议连接, 用于优雅关闭
+type serverConn interface {
+	// startShutdown 不再接受新请求, 处理中的请求结束后关闭连接
+	startShutdown()
+}
+
+// ListenAndServe 监听 s.Addr 并开始服务
+func (s *Server) ListenAndServe() error {
+	ts, err := s.tcpServer()
+	if err != nil {
+		return err
+	}
+	return s.mapErr(ts.ListenAndServe())
+}
+
+// Serve 在 ln 上接受连接, 直到 ln 关闭或服务器关闭
+func (s *Server) Serve(ln net.Listener) error {
+	ts, err := s.tcpServer()
+	if err != nil {
+		return err
+	}
+	return s.mapErr(ts.Serve(ln))
+}
+
+func (s *Server) mapErr(err error) error {
+	if errors.Is(err, tcp.ErrServerClosed) {
+		return ErrServerClosed
+	}
+	return err
+}
+
+// tcpServer 返回底层 TCP 服务器, 首次调用时创建
+func (s *Server) tcpServer() (*tcp.Server, error) {
+	if s.Handler == nil {
+		return nil, errors.New("server: nil handler")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown.Load() {
+		return nil, ErrServerClosed
+	}
+	if s.tcp == nil {
+		s.tcp = &tcp.Server{Addr: s.Addr, Handler: s, TLS: s.tlsOptions(), Logger: s.Logger, Metrics: s.Metrics, MemoryBudget: s.MemoryBudget}
+	}
+	return s.tcp, nil
+}
+
+// tlsOptions 补全 ALPN 协议列表, 不修改调用方的配置
+func (s *Server) tlsOptions() *tcp.TLSOptions {
+	o := s.TLS
+	if o == nil || len(o.NextProtos) > 0 || (o.Config != nil && len(o.Config.NextProtos) > 0) {
+		return o
+	}
+	protos := []string{http2.NextProtoTLS, NextProtoHTTP1}
+	if s.DisableHTTP2 {
+		protos = protos[1:]
+	}
+	return &tcp.TLSOptions{
+		Config:                o.Config,
+		HandshakeTimeout:      o.HandshakeTimeout,
+		NextProtos:            protos,
+		SessionCacheSize:      o.SessionCacheSize,
+		SessionTicketKeys:     o.SessionTicketKeys,
+		DisableSessionTickets: o.DisableSessionTickets,
+	}
+}
+
+// ServeConn 在一条已建立的连接上提供服务, 按 ALPN 结果选择协议, 启用 H2C 时识别明文 HTTP/2 前言; 实现 tcp.Handler
+func (s *Server) ServeConn(c *tcp.Conn) {
+	if c.NegotiatedProtocol() == NextProtoACME {
+		c.Close()
+		return
+	}
+	// 读写缓冲取自池并计入连接的内存预算; 连接被接管后缓冲区仍由接管方使用, 不再归还
+	bc := tcp.NewBufferedConn(c)
+	br, bw := bc.Reader(), bc.Writer()
+	switch {
+	case s.DisableHTTP2:
+	case c.NegotiatedProtocol() == http2.NextProtoTLS:
+		s.serveHTTP2(c, br, bw, nil)
+		bc.Release()
+		return
+	case s.h2cEnabled(c) && s.sniffPreface(c, br):
+		s.serveHTTP2(c, br, bw, nil)
+		bc.Release()
+		return
+	}
+	if !s.serveHTTP1(c, br, bw) {
+		bc.Release()
+	}
+}
+
+func (s *Server) trackConn(c serverConn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.conns, c)
+		return true
+	}
+	if s.shutdown.Load() {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[serverConn]struct{})
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
+
+// Shutdown 停止接受新连接, 通知现有连接在当前请求结束后关闭 (HTTP/2 发送 GOAWAY),
+// 并等待它们关闭; ctx 结束时强制关闭剩余连接
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shutdown.Store(true)
+	ts := s.tcp
+	conns := make([]serverConn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	for _, c := range conns {
+		c.startShutdown()
+	}
+	err := s.shutdownQUIC(ctx)
+	if ts == nil {
+		return err
+	}
+	if terr := ts.Shutdown(ctx); terr != nil {
+		return terr
+	}
+	return err
+}
+
+// Close 立即关闭所有监听器和连接
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.shutdown.Store(true)
+	ts := s.tcp
+	s.mu.Unlock()
+	s.closeQUIC(true)
+	if ts == nil {
+		return nil
+	}
+	return ts.Close()
+}
+
+// responseHeader 返回新响应的初始头部: Date (RFC 9110 6.6.1), 配置了 AltSvc 时还有 Alt-Svc
+func (s *Server) responseHeader() common.Header {
+	h := common.Header{"Date": {utils.HTTPDate()}}
+	if s.AltSvc != "" {
+		h.Set("Alt-Svc", s.AltSvc)
+	}
+	return h
+}
+
+// logPanic 报告处理器 panic, req 为当前请求
+func (s *Server) logPanic(req *message.Request, v any, stack []byte) {
+	if s.OnPanic != nil {
+		s.OnPanic(req, v, stack)
+		return
+	}
+	log.Or(s.Logger).Error("server: panic serving request",
+		log.String("method", req.Method), log.String("uri", req.RequestURI()), log.Any("panic", v), log.String("stack", string(stack)))
+}
+
+// deadline 返回 d 之后的时间, d 为 0 时返回零值表示不限制
+func deadline(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
@@ -0,0 +1,330 @@
+package server
+
+/*
+	静态文件处理器: 以 fs.FS 中的文件响应 GET 和 HEAD, 支持目录索引、可选的目录列表、
+	条件请求 (ETag / Last-Modified) 和单个字节范围的 Range 请求
+*/
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// DefaultIndex FileServer 默认的目录索引文件
+const DefaultIndex = "index.html"
+
+// FileServer 以 FS 中的文件响应请求. 路径以 "." 开头的文件和目录默认不可见, 请求路径中的 ".." 被清理,
+// 不会越出 FS 的根
+type FileServer struct {
+	// FS 文件来源, 如 os.DirFS(dir)
+	FS fs.FS
+	// StripPrefix 映射到 FS 之前从请求路径中去掉的前缀, 如 "/static"
+	StripPrefix string
+	// Index 目录的索引文件, 为空时使用 DefaultIndex
+	Index string
+	// Browse 为 true 时没有索引文件的目录返回文件列表, 否则返回 404
+	Browse bool
+	// AllowDotfiles 为 true 时允许访问以 "." 开头的文件和目录
+	AllowDotfiles bool
+	// CacheControl 非空时作为响应的 Cache-Control 头部
+	CacheControl string
+}
+
+// ServeHTTP 实现 Handler
+func (s *FileServer) ServeHTTP(w ResponseWriter, req *message.Request) {
+	if req.Method != common.MethodGet && req.Method != common.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		textError(w, common.StatusMethodNotAllowed)
+		return
+	}
+	upath := req.URL.Path
+	if !strings.HasPrefix(upath, "/") {
+		upath = "/" + upath
+	}
+	rest, ok := strings.CutPrefix(upath, strings.TrimSuffix(s.StripPrefix, "/"))
+	if !ok || rest != "" && rest[0] != '/' {
+		textError(w, common.StatusNotFound)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean("/"+rest), "/")
+	if name == "" {
+		name = "."
+	}
+	if !s.AllowDotfiles && hasDotSegment(name) {
+		textError(w, common.StatusNotFound)
+		return
+	}
+
+	f, err := s.FS.Open(name)
+	if err != nil {
+		textError(w, fsErrorStatus(err))
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		textError(w, fsErrorStatus(err))
+		return
+	}
+	if info.IsDir() {
+		// 目录需要以 / 结尾, 页面中的相对链接才能正确解析
+		if !strings.HasSuffix(upath, "/") {
+			redirectSlash(w, req)
+			return
+		}
+		index := s.Index
+		if index == "" {
+			index = DefaultIndex
+		}
+		ff, err := s.FS.Open(path.Join(name, index))
+		if err == nil {
+			defer ff.Close()
+			if fi, err := ff.Stat(); err == nil && !fi.IsDir() {
+				s.serveContent(w, req, ff, fi)
+				return
+			}
+		}
+		if !s.Browse {
+			textError(w, common.StatusNotFound)
+			return
+		}
+		s.listDir(w, req, name)
+		return
+	}
+	s.serveContent(w, req, f, info)
+}
+
+// serveContent 写出文件内容, 处理条件请求和 Range
+func (s *FileServer) serveContent(w ResponseWriter, req *message.Request, f fs.File, info fs.FileInfo) {
+	h := w.Header()
+	modTime := info.ModTime().UTC().Truncate(time.Second)
+	etag := utils.WeakETag(info.Size(), info.ModTime())
+	h.Set("ETag", etag)
+	if !modTime.IsZero() {
+		h.Set("Last-Modified", modTime.Format(utils.TimeFormat))
+	}
+	if s.CacheControl != "" {
+		h.Set("Cache-Control", s.CacheControl)
+	}
+	if notModified(req, etag, modTime) {
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		w.WriteHeader(common.StatusNotModified)
+		return
+	}
+	if h.Get("Content-Type") == "" {
+		ctype := mime.TypeByExtension(path.Ext(info.Name()))
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		h.Set("Content-Type", ctype)
+	}
+	h.Set("Accept-Ranges", "bytes")
+
+	size := info.Size()
+	var content io.Reader = f
+	if of, ok := f.(*os.File); ok && info.Mode().IsRegular() {
+		// 映射后由 MmapReader 持有文件, ServeHTTP 中的 Close 随之成为空操作
+		if m, err := utils.NewMmapReader(of); err == nil {
+			defer m.Close()
+			m.Advise(utils.MmapSequential)
+			content = io.NewSectionReader(m, 0, m.Len())
+		}
+	}
+	body := content
+	code := common.StatusOK
+	if rs, ok := content.(io.ReadSeeker); ok && rangeApplies(req, etag, modTime) {
+		start, end, ok := parseRange(req.Header.Get("Range"), size)
+		if !ok {
+			h.Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+			textError(w, common.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if end >= start {
+			if _, err := rs.Seek(start, io.SeekStart); err != nil {
+				textError(w, common.StatusInternalServerError)
+				return
+			}
+			h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+			body = io.LimitReader(rs, end-start+1)
+			size = end - start + 1
+			code = common.StatusPartialContent
+		}
+	}
+	h.Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(code)
+	if req.Method == common.MethodHead {
+		return
+	}
+	io.Copy(w, body)
+}
+
+// listDir 写出目录列表
+func (s *FileServer) listDir(w ResponseWriter, req *message.Request, name string) {
+	entries, err := fs.ReadDir(s.FS, name)
+	if err != nil {
+		textError(w, fsErrorStatus(err))
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir() != entries[j].IsDir() {
+			return entries[i].IsDir()
+		}
+		return entries[i].Name() < entries[j].Name()
+	})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(common.StatusOK)
+	if req.Method == common.MethodHead {
+		return
+	}
+	title := html.EscapeString(req.URL.Path)
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!doctype html>\n<title>Index of %s</title>\n<h1>Index of %s</h1>\n<pre>\n", title, title)
+	if name != "." {
+		b.WriteString("<a href=\"../\">../</a>\n")
+	}
+	for _, e := range entries {
+		n := e.Name()
+		if !s.AllowDotfiles && strings.HasPrefix(n, ".") {
+			continue
+		}
+		if e.IsDir() {
+			n += "/"
+		}
+		ref := url.URL{Path: n}
+		fmt.Fprintf(&b, "<a href=\"%s\">%s</a>\n", html.EscapeString(ref.String()), html.EscapeString(n))
+	}
+	b.WriteString("</pre>\n")
+	io.WriteString(w, b.String())
+}
+
+// notModified 按 RFC 9110 13.2.2 的顺序求值 If-None-Match 和 If-Modified-Since
+func notModified(req *message.Request, etag string, modTime time.Time) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		return etagListMatch(inm, etag)
+	}
+	ims := req.Header.Get("If-Modified-Since")
+	if ims == "" || modTime.IsZero() {
+		return false
+	}
+	t, err := utils.ParseHTTPTime(ims)
+	return err == nil && !modTime.After(t)
+}
+
+// rangeApplies 报告是否处理 Range; If-Range 与当前版本不符时返回完整内容
+func rangeApplies(req *message.Request, etag string, modTime time.Time) bool {
+	if req.Header.Get("Range") == "" {
+		return false
+	}
+	ir := req.Header.Get("If-Range")
+	switch {
+	case ir == "":
+		return true
+	case strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/"):
+		// If-Range 要求强比较, 弱 ETag 永远不匹配
+		return false
+	}
+	t, err := utils.ParseHTTPTime(ir)
+	return err == nil && t.Equal(modTime)
+}
+
+// etagListMatch 以弱比较判断 If-None-Match 列表是否包含 etag
+func etagListMatch(list, etag string) bool {
+	want := strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange 解析单个字节范围, 返回闭区间; 多个范围或无法解析时返回 end < start 表示忽略 Range,
+// 范围不可满足时 ok 为 false
+func parseRange(s string, size int64) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(s, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, -1, true
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, -1, true
+	}
+	if first == "" {
+		// 后缀范围: 最后 n 个字节
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, -1, true
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false
+		}
+		return max(size-n, 0), size - 1, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, -1, true
+	}
+	if start >= size {
+		return 0, 0, false
+	}
+	end = size - 1
+	if last != "" {
+		e, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || e < start {
+			return 0, -1, true
+		}
+		end = min(e, size-1)
+	}
+	return start, end, true
+}
+
+func hasDotSegment(name string) bool {
+	for _, seg := range strings.Split(name, "/") {
+		if len(seg) > 1 && seg[0] == '.' {
+			return true
+		}
+	}
+	return false
+}
+
+func fsErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrInvalid):
+		return common.StatusNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return common.StatusForbidden
+	}
+	return common.StatusInternalServerError
+}
+
+// redirectSlash 以 301 重定向到加上 / 的路径, 保留查询参数
+func redirectSlash(w ResponseWriter, req *message.Request) {
+	u := url.URL{Path: req.URL.Path + "/", RawQuery: req.URL.RawQuery}
+	w.Header().Set("Location", u.String())
+	w.WriteHeader(common.StatusMovedPermanently)
+}
+
+// textError 以状态文本作为纯文本消息体写出错误响应
+func textError(w ResponseWriter, code int) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	io.WriteString(w, common.StatusText(code)+"\n")
+}
@@ -0,0 +1,61 @@
+package server_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	httptest "github.com/narcilee7/http-stack/internal/testing"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/server"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+func TestFileServerServesMappedFile(t *testing.T) {
+	dir := t.TempDir()
+	content := "0123456789abcdef"
+	if err := os.WriteFile(filepath.Join(dir, "data.txt"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "data.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsrv := &server.FileServer{FS: os.DirFS(dir)}
+	get := func(header map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req, err := message.NewRequest("GET", "http://example.com/data.txt", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		fsrv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get(nil)
+	if rec.Code != common.StatusOK || rec.Body.String() != content {
+		t.Fatalf("GET = %d %q", rec.Code, rec.Body)
+	}
+	etag := rec.Result().Header.Get("ETag")
+	if want := utils.WeakETag(info.Size(), info.ModTime()); etag != want {
+		t.Fatalf("ETag = %q, want %q", etag, want)
+	}
+
+	rec = get(map[string]string{"Range": "bytes=4-7"})
+	if rec.Code != common.StatusPartialContent || rec.Body.String() != "4567" {
+		t.Fatalf("Range GET = %d %q", rec.Code, rec.Body)
+	}
+	if cr := rec.Result().Header.Get("Content-Range"); cr != "bytes 4-7/16" {
+		t.Fatalf("Content-Range = %q", cr)
+	}
+
+	rec = get(map[string]string{"If-None-Match": etag})
+	if rec.Code != common.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("conditional GET = %d %q", rec.Code, rec.Body)
+	}
+}
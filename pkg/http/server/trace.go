@@ -0,0 +1,172 @@
+package server
+
+/*
+	追踪中间件: 从 traceparent 延续上游追踪或开启新追踪, 为每个请求创建 server span,
+	记录方法、状态码和收发字节数
+*/
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/trace"
+)
+
+// TraceHandler 为 Handler 处理的每个请求创建 server span, span 放在请求上下文中,
+// 处理器可通过 trace.SpanFromContext(req.Context()) 取得并添加属性
+type TraceHandler struct {
+	// Handler 被追踪的处理器
+	Handler Handler
+	// Tracer 创建 span, 为空时使用 trace.NoopTracer (只传播标识)
+	Tracer trace.Tracer
+}
+
+// ServeHTTP 实现 Handler
+func (h *TraceHandler) ServeHTTP(w ResponseWriter, req *message.Request) {
+	tracer := h.Tracer
+	if tracer == nil {
+		tracer = trace.NoopTracer{}
+	}
+	ctx := req.Context()
+	if sc, ok := trace.Extract(req.Header); ok {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, sc)
+	}
+	attrs := []trace.Attribute{
+		trace.String(trace.AttrHTTPRequestMethod, req.Method),
+		trace.String(trace.AttrNetworkProtocolVersion, strings.TrimPrefix(req.Proto, "HTTP/")),
+	}
+	if req.URL != nil {
+		attrs = append(attrs, trace.String(trace.AttrURLPath, req.URL.Path))
+		if req.URL.RawQuery != "" {
+			attrs = append(attrs, trace.String(trace.AttrURLQuery, req.URL.RawQuery))
+		}
+	}
+	if host, port, err := net.SplitHostPort(req.HostHeader()); err == nil {
+		attrs = append(attrs, trace.String(trace.AttrServerAddress, host))
+		if n, err := strconv.Atoi(port); err == nil {
+			attrs = append(attrs, trace.Int(trace.AttrServerPort, n))
+		}
+	} else if host := req.HostHeader(); host != "" {
+		attrs = append(attrs, trace.String(trace.AttrServerAddress, host))
+	}
+	if addr := RemoteAddr(req); addr != nil {
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+			attrs = append(attrs, trace.String(trace.AttrClientAddress, host))
+		}
+	}
+	if ua := req.Header.Get("User-Agent"); ua != "" {
+		attrs = append(attrs, trace.String(trace.AttrUserAgent, ua))
+	}
+	ctx, span := tracer.Start(ctx, req.Method, trace.StartOptions{Kind: trace.SpanKindServer, Attributes: attrs})
+	defer span.End()
+
+	req = req.WithContext(ctx)
+	var body *countingBody
+	if req.Body != nil && req.Body != message.NoBody {
+		body = &countingBody{ReadCloser: req.Body}
+		req.Body = body
+	}
+	tw := &statusWriter{w: w}
+	h.Handler.ServeHTTP(tw, req)
+
+	status := tw.code()
+	span.SetAttributes(
+		trace.Int(trace.AttrHTTPResponseStatusCode, status),
+		trace.Int64(trace.AttrHTTPResponseBodySize, tw.written),
+	)
+	if body != nil {
+		span.SetAttributes(trace.Int64(trace.AttrHTTPRequestBodySize, body.n.Load()))
+	}
+	// 服务端只把 5xx 视为错误, 4xx 是客户端的问题
+	if status >= 500 {
+		span.SetAttributes(trace.String(trace.AttrErrorType, strconv.Itoa(status)))
+		span.SetStatus(trace.StatusError, "")
+	}
+}
+
+// countingBody 统计读出的字节数
+type countingBody struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+// statusWriter 记录状态码和写出的字节数, 并透传 Flusher、Hijacker 和 TrailerWriter
+type statusWriter struct {
+	w        ResponseWriter
+	status   int
+	written  int64
+	hijacked bool
+	trailer  common.Header
+}
+
+// code 返回最终的状态码: 没有写出时为 200, 连接被接管时为 101
+func (tw *statusWriter) code() int {
+	switch {
+	case tw.status != 0:
+		return tw.status
+	case tw.hijacked:
+		return common.StatusSwitchingProtocols
+	}
+	return common.StatusOK
+}
+
+func (tw *statusWriter) Header() common.Header { return tw.w.Header() }
+
+func (tw *statusWriter) WriteHeader(code int) {
+	if tw.status == 0 && (code < 100 || code >= 200 || code == common.StatusSwitchingProtocols) {
+		tw.status = code
+	}
+	tw.w.WriteHeader(code)
+}
+
+func (tw *statusWriter) Write(p []byte) (int, error) {
+	if tw.status == 0 {
+		tw.status = common.StatusOK
+	}
+	n, err := tw.w.Write(p)
+	tw.written += int64(n)
+	return n, err
+}
+
+// Flush 实现 Flusher
+func (tw *statusWriter) Flush() {
+	if fl, ok := tw.w.(Flusher); ok {
+		fl.Flush()
+	}
+}
+
+// Hijack 实现 Hijacker, 底层不支持时返回 ErrHijackUnsupported
+func (tw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := tw.w.(Hijacker)
+	if !ok {
+		return nil, nil, ErrHijackUnsupported
+	}
+	nc, brw, err := hj.Hijack()
+	if err == nil {
+		tw.hijacked = true
+	}
+	return nc, brw, err
+}
+
+// Trailer 实现 TrailerWriter, 底层不支持时返回的头部被丢弃
+func (tw *statusWriter) Trailer() common.Header {
+	if t, ok := tw.w.(TrailerWriter); ok {
+		return t.Trailer()
+	}
+	if tw.trailer == nil {
+		tw.trailer = make(common.Header)
+	}
+	return tw.trailer
+}
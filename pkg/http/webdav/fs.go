@@ -0,0 +1,111 @@
+package webdav
+
+/*
+	WebDAV 处理器访问的文件系统接口, Dir 以本地目录实现它. 名称都是以 / 开头、以 / 分隔的清理过的路径
+*/
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FileSystem 处理器读写资源使用的文件系统, 实现必须可以并发使用
+type FileSystem interface {
+	// Mkdir 创建目录, 父目录不存在时返回 fs.ErrNotExist, 已存在时返回 fs.ErrExist
+	Mkdir(ctx context.Context, name string, perm fs.FileMode) error
+	// OpenFile 语义同 os.OpenFile
+	OpenFile(ctx context.Context, name string, flag int, perm fs.FileMode) (File, error)
+	// RemoveAll 删除文件或整个目录树
+	RemoveAll(ctx context.Context, name string) error
+	// Rename 移动文件或目录, newName 已存在时的行为由实现决定, 处理器会先删除它
+	Rename(ctx context.Context, oldName, newName string) error
+	Stat(ctx context.Context, name string) (fs.FileInfo, error)
+}
+
+// File 打开的文件或目录, *os.File 满足该接口
+type File interface {
+	io.ReadWriteSeeker
+	io.Closer
+	// Readdir 语义同 (*os.File).Readdir
+	Readdir(count int) ([]fs.FileInfo, error)
+	Stat() (fs.FileInfo, error)
+}
+
+// Dir 以本地目录为根的 FileSystem; 名称中的 ".." 被清理, 不会越出该目录 (符号链接除外)
+type Dir string
+
+// resolve 把名称映射为本地路径
+func (d Dir) resolve(name string) (string, error) {
+	if strings.ContainsRune(name, 0) || filepath.Separator != '/' && strings.ContainsRune(name, filepath.Separator) {
+		return "", fs.ErrInvalid
+	}
+	dir := string(d)
+	if dir == "" {
+		dir = "."
+	}
+	return filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name))), nil
+}
+
+// Mkdir 实现 FileSystem
+func (d Dir) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
+	p, err := d.resolve(name)
+	if err != nil {
+		return err
+	}
+	return os.Mkdir(p, perm)
+}
+
+// OpenFile 实现 FileSystem
+func (d Dir) OpenFile(ctx context.Context, name string, flag int, perm fs.FileMode) (File, error) {
+	p, err := d.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(p, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// RemoveAll 实现 FileSystem; 不允许删除根目录
+func (d Dir) RemoveAll(ctx context.Context, name string) error {
+	p, err := d.resolve(name)
+	if err != nil {
+		return err
+	}
+	if path.Clean("/"+name) == "/" {
+		return fs.ErrPermission
+	}
+	return os.RemoveAll(p)
+}
+
+// Rename 实现 FileSystem; 不允许移动根目录
+func (d Dir) Rename(ctx context.Context, oldName, newName string) error {
+	oldPath, err := d.resolve(oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := d.resolve(newName)
+	if err != nil {
+		return err
+	}
+	if path.Clean("/"+oldName) == "/" || path.Clean("/"+newName) == "/" {
+		return fs.ErrPermission
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+// Stat 实现 FileSystem
+func (d Dir) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	p, err := d.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
@@ -0,0 +1,294 @@
+package webdav
+
+/*
+	WebDAV 写锁 (RFC 4918 6, 7): 内存中的锁表, 支持排他锁和共享锁、深度 0 和 infinity、超时与刷新;
+	以及 If 头部 (RFC 4918 10.4) 的解析
+*/
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLockTimeout 客户端没有给出 Timeout 或要求 Infinite 时锁的有效期
+const DefaultLockTimeout = time.Hour
+
+// maxLockTimeout 客户端可以请求的最长有效期, 过期未刷新的锁被自动释放
+const maxLockTimeout = 24 * time.Hour
+
+var (
+	// errLocked 与已有的锁冲突
+	errLocked = errors.New("webdav: resource is locked")
+	// errNoSuchLock 锁令牌不存在、已过期或不作用于该资源
+	errNoSuchLock = errors.New("webdav: no such lock")
+)
+
+// infiniteDepth Depth: infinity
+const infiniteDepth = -1
+
+// lock 一个写锁, root 是加锁的资源名称
+type lock struct {
+	token     string
+	root      string
+	depth     int
+	exclusive bool
+	// owner 客户端在 lockinfo 中提供的 owner 元素内容, 原样在 lockdiscovery 中返回
+	owner   string
+	timeout time.Duration
+	expires time.Time
+}
+
+// lockTable 所有活动的锁, 以令牌为键
+type lockTable struct {
+	mu    sync.Mutex
+	locks map[string]*lock
+}
+
+// expire 删除过期的锁, 调用方持有 mu
+func (t *lockTable) expire(now time.Time) {
+	for token, l := range t.locks {
+		if now.After(l.expires) {
+			delete(t.locks, token)
+		}
+	}
+}
+
+// create 为 root 加锁; 与已有的锁重叠且任一方是排他锁时返回 errLocked
+func (t *lockTable) create(root string, depth int, exclusive bool, owner string, timeout time.Duration) (lock, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.expire(now)
+	for _, l := range t.locks {
+		if (exclusive || l.exclusive) && overlaps(l, root, depth) {
+			return lock{}, errLocked
+		}
+	}
+	l := &lock{
+		token: newLockToken(), root: root, depth: depth, exclusive: exclusive, owner: owner,
+		timeout: timeout, expires: now.Add(timeout),
+	}
+	if t.locks == nil {
+		t.locks = make(map[string]*lock)
+	}
+	t.locks[l.token] = l
+	return *l, nil
+}
+
+// refresh 延长作用于 name 的锁 token 的有效期
+func (t *lockTable) refresh(token, name string, timeout time.Duration) (lock, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.expire(now)
+	l := t.locks[token]
+	if l == nil || !covers(l, name) {
+		return lock{}, errNoSuchLock
+	}
+	l.timeout = timeout
+	l.expires = now.Add(timeout)
+	return *l, nil
+}
+
+// unlock 释放作用于 name 的锁 token
+func (t *lockTable) unlock(token, name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(time.Now())
+	l := t.locks[token]
+	if l == nil || !covers(l, name) {
+		return errNoSuchLock
+	}
+	delete(t.locks, token)
+	return nil
+}
+
+// valid 报告 token 是否为作用于 name 的活动锁, 用于求值 If 头部中的状态令牌
+func (t *lockTable) valid(token, name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(time.Now())
+	l := t.locks[token]
+	return l != nil && covers(l, name)
+}
+
+// discover 返回作用于 name 的锁, 用于 lockdiscovery 属性
+func (t *lockTable) discover(name string) []lock {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(time.Now())
+	var ls []lock
+	for _, l := range t.locks {
+		if covers(l, name) {
+			ls = append(ls, *l)
+		}
+	}
+	return ls
+}
+
+// confirm 报告修改 name 的请求是否提交了所需的锁令牌. 作用于 name 或其子资源的锁都需要令牌;
+// member 表示请求会增删父集合的成员, 此时父集合上的锁也需要令牌. 同一资源上的共享锁提交其一即可
+func (t *lockTable) confirm(name string, member bool, tokens []string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(time.Now())
+	submitted := make(map[string]bool)
+	var missing []*lock
+	for _, l := range t.locks {
+		if !(covers(l, name) || isAncestor(name, l.root) || member && name != "/" && l.root == path.Dir(name)) {
+			continue
+		}
+		if !containsToken(tokens, l.token) {
+			missing = append(missing, l)
+		} else if !l.exclusive {
+			submitted[l.root] = true
+		}
+	}
+	for _, l := range missing {
+		if l.exclusive || !submitted[l.root] {
+			return false
+		}
+	}
+	return true
+}
+
+// removeTree 删除 name 及其子资源上的锁, 资源被删除或移走后调用
+func (t *lockTable) removeTree(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for token, l := range t.locks {
+		if l.root == name || isAncestor(name, l.root) {
+			delete(t.locks, token)
+		}
+	}
+}
+
+// covers 报告锁 l 是否作用于 name: 加在 name 上, 或加在祖先集合上且深度为 infinity
+func covers(l *lock, name string) bool {
+	return l.root == name || l.depth == infiniteDepth && isAncestor(l.root, name)
+}
+
+// overlaps 报告锁 l 与以 depth 加在 root 上的新锁是否作用于同一资源
+func overlaps(l *lock, root string, depth int) bool {
+	return covers(l, root) || depth == infiniteDepth && isAncestor(root, l.root)
+}
+
+// isAncestor 报告 a 是否为 b 的祖先集合
+func isAncestor(a, b string) bool {
+	return a != b && (a == "/" || strings.HasPrefix(b, a+"/"))
+}
+
+func containsToken(tokens []string, token string) bool {
+	for _, t := range tokens {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
+
+// newLockToken 生成 urn:uuid 形式的锁令牌 (RFC 4918 6.5 要求全局唯一)
+func newLockToken() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// ifCondition If 头部中的一个条件: 状态令牌或 ETag, Not 取反
+type ifCondition struct {
+	not   bool
+	token string
+	etag  string
+}
+
+// ifList If 头部中括号内的条件列表, 所有条件都成立时列表成立; resource 为带标签列表的资源, 否则为空
+type ifList struct {
+	resource   string
+	conditions []ifCondition
+}
+
+// parseIf 解析 If 头部, 语法错误时 ok 为 false
+func parseIf(s string) (lists []ifList, ok bool) {
+	resource := ""
+	s = strings.TrimSpace(s)
+	for s != "" {
+		switch s[0] {
+		case '<':
+			end := strings.IndexByte(s, '>')
+			if end < 0 {
+				return nil, false
+			}
+			resource = s[1:end]
+			s = strings.TrimSpace(s[end+1:])
+			if !strings.HasPrefix(s, "(") {
+				return nil, false
+			}
+		case '(':
+			l := ifList{resource: resource}
+			s = s[1:]
+			for {
+				s = strings.TrimSpace(s)
+				if s == "" {
+					return nil, false
+				}
+				if s[0] == ')' {
+					s = s[1:]
+					break
+				}
+				var c ifCondition
+				if len(s) >= 3 && strings.EqualFold(s[:3], "Not") {
+					c.not = true
+					s = strings.TrimSpace(s[3:])
+				}
+				var term byte
+				switch {
+				case strings.HasPrefix(s, "<"):
+					term = '>'
+				case strings.HasPrefix(s, "["):
+					term = ']'
+				default:
+					return nil, false
+				}
+				end := strings.IndexByte(s, term)
+				if end < 0 {
+					return nil, false
+				}
+				if term == '>' {
+					c.token = s[1:end]
+				} else {
+					c.etag = s[1:end]
+				}
+				s = s[end+1:]
+				l.conditions = append(l.conditions, c)
+			}
+			if len(l.conditions) == 0 {
+				return nil, false
+			}
+			lists = append(lists, l)
+		default:
+			return nil, false
+		}
+		s = strings.TrimSpace(s)
+	}
+	return lists, len(lists) > 0
+}
+
+// ifTokens 返回 If 头部中提交的 (非 Not) 锁令牌
+func ifTokens(lists []ifList) []string {
+	var tokens []string
+	for _, l := range lists {
+		for _, c := range l.conditions {
+			if c.token != "" && !c.not {
+				tokens = append(tokens, c.token)
+			}
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,288 @@
+package webdav
+
+/*
+	属性与 XML 消息体 (RFC 4918 9.1, 14, 15): 解析 propfind、propertyupdate 和 lockinfo 请求体,
+	生成 multistatus 响应. 只支持由文件系统计算的活属性, 不保存死属性
+*/
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// davNS WebDAV 元素的 XML 名称空间
+const davNS = "DAV:"
+
+// propfind PROPFIND 请求体, 三个子元素只能出现一个; 没有请求体时视为 allprop
+type propfind struct {
+	XMLName  xml.Name  `xml:"DAV: propfind"`
+	AllProp  *struct{} `xml:"DAV: allprop"`
+	PropName *struct{} `xml:"DAV: propname"`
+	Prop     propNames `xml:"DAV: prop"`
+}
+
+// propertyUpdate PROPPATCH 请求体
+type propertyUpdate struct {
+	XMLName xml.Name    `xml:"DAV: propertyupdate"`
+	Set     []propNames `xml:"DAV: set>prop"`
+	Remove  []propNames `xml:"DAV: remove>prop"`
+}
+
+// lockInfo LOCK 请求体
+type lockInfo struct {
+	XMLName   xml.Name  `xml:"DAV: lockinfo"`
+	Exclusive *struct{} `xml:"DAV: lockscope>exclusive"`
+	Shared    *struct{} `xml:"DAV: lockscope>shared"`
+	Write     *struct{} `xml:"DAV: locktype>write"`
+	Owner     struct {
+		InnerXML string `xml:",innerxml"`
+	} `xml:"DAV: owner"`
+}
+
+// propNames prop 元素中的属性名称, 属性的值被忽略
+type propNames []xml.Name
+
+// UnmarshalXML 实现 xml.Unmarshaler
+func (p *propNames) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	// 空的 prop 元素也与没有 prop 区分开
+	if *p == nil {
+		*p = propNames{}
+	}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			return nil
+		case xml.StartElement:
+			*p = append(*p, t.Name)
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// liveProp 由文件系统计算的活属性, value 返回属性值的 XML, ok 为 false 表示资源没有该属性
+type liveProp struct {
+	name  string
+	value func(h *Handler, name string, fi fs.FileInfo) (string, bool)
+}
+
+// liveProps 支持的活属性, allprop 和 propname 按此顺序输出
+var liveProps = []liveProp{
+	{"resourcetype", func(_ *Handler, _ string, fi fs.FileInfo) (string, bool) {
+		if fi.IsDir() {
+			return "<D:collection/>", true
+		}
+		return "", true
+	}},
+	{"displayname", func(_ *Handler, name string, _ fs.FileInfo) (string, bool) {
+		if name == "/" {
+			return "", false
+		}
+		return escape(path.Base(name)), true
+	}},
+	{"getcontentlength", func(_ *Handler, _ string, fi fs.FileInfo) (string, bool) {
+		if fi.IsDir() {
+			return "", false
+		}
+		return strconv.FormatInt(fi.Size(), 10), true
+	}},
+	{"getlastmodified", func(_ *Handler, _ string, fi fs.FileInfo) (string, bool) {
+		return fi.ModTime().UTC().Format(utils.TimeFormat), true
+	}},
+	{"getcontenttype", func(_ *Handler, name string, fi fs.FileInfo) (string, bool) {
+		if fi.IsDir() {
+			return "", false
+		}
+		return escape(contentType(name)), true
+	}},
+	{"getetag", func(_ *Handler, _ string, fi fs.FileInfo) (string, bool) {
+		return escape(etag(fi)), true
+	}},
+	{"supportedlock", func(_ *Handler, _ string, _ fs.FileInfo) (string, bool) {
+		return "<D:lockentry><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>" +
+			"<D:lockentry><D:lockscope><D:shared/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>", true
+	}},
+	{"lockdiscovery", func(h *Handler, name string, _ fs.FileInfo) (string, bool) {
+		var b strings.Builder
+		for _, l := range h.locks.discover(name) {
+			b.WriteString(h.activeLock(l))
+		}
+		return b.String(), true
+	}},
+}
+
+// propstat 状态相同的一组属性
+type propstat struct {
+	status int
+	props  []xml.Name
+	values []string
+}
+
+// multistatus 逐个写出 response 元素的 207 响应体
+type multistatus struct {
+	buf bytes.Buffer
+}
+
+func newMultistatus() *multistatus {
+	ms := &multistatus{}
+	ms.buf.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n" + `<D:multistatus xmlns:D="DAV:">`)
+	return ms
+}
+
+// response 写出一个资源的 response 元素, 空的 propstat 被省略
+func (ms *multistatus) response(href string, stats ...propstat) {
+	b := &ms.buf
+	b.WriteString("<D:response><D:href>" + escape(href) + "</D:href>")
+	for _, ps := range stats {
+		if len(ps.props) == 0 {
+			continue
+		}
+		b.WriteString("<D:propstat><D:prop>")
+		for i, n := range ps.props {
+			v := ""
+			if ps.values != nil {
+				v = ps.values[i]
+			}
+			writeElement(b, n, v)
+		}
+		b.WriteString("</D:prop>" + statusElement(ps.status) + "</D:propstat>")
+	}
+	b.WriteString("</D:response>")
+}
+
+func (ms *multistatus) bytes() []byte {
+	ms.buf.WriteString("</D:multistatus>\n")
+	return ms.buf.Bytes()
+}
+
+// propfind 按请求为 name 写出 response: 找到的属性 200, 不存在的属性 404
+func (h *Handler) propfind(ms *multistatus, pf *propfind, name string, fi fs.FileInfo) {
+	href := h.href(name, fi.IsDir())
+	if pf.PropName != nil {
+		ok := propstat{status: common.StatusOK}
+		for _, p := range liveProps {
+			if _, has := p.value(h, name, fi); has {
+				ok.props = append(ok.props, xml.Name{Space: davNS, Local: p.name})
+			}
+		}
+		ms.response(href, ok)
+		return
+	}
+	ok := propstat{status: common.StatusOK, values: []string{}}
+	missing := propstat{status: common.StatusNotFound}
+	if pf.Prop == nil {
+		for _, p := range liveProps {
+			if v, has := p.value(h, name, fi); has {
+				ok.props = append(ok.props, xml.Name{Space: davNS, Local: p.name})
+				ok.values = append(ok.values, v)
+			}
+		}
+		ms.response(href, ok)
+		return
+	}
+	for _, n := range pf.Prop {
+		v, has := "", false
+		if n.Space == davNS {
+			for _, p := range liveProps {
+				if p.name == n.Local {
+					v, has = p.value(h, name, fi)
+					break
+				}
+			}
+		}
+		if has {
+			ok.props = append(ok.props, n)
+			ok.values = append(ok.values, v)
+		} else {
+			missing.props = append(missing.props, n)
+		}
+	}
+	ms.response(href, ok, missing)
+}
+
+// activeLock 以 activelock 元素描述锁 l
+func (h *Handler) activeLock(l lock) string {
+	scope, depth := "<D:shared/>", "0"
+	if l.exclusive {
+		scope = "<D:exclusive/>"
+	}
+	if l.depth == infiniteDepth {
+		depth = "infinity"
+	}
+	var b strings.Builder
+	b.WriteString("<D:activelock><D:locktype><D:write/></D:locktype><D:lockscope>" + scope + "</D:lockscope>")
+	b.WriteString("<D:depth>" + depth + "</D:depth>")
+	if l.owner != "" {
+		b.WriteString("<D:owner>" + l.owner + "</D:owner>")
+	}
+	fmt.Fprintf(&b, "<D:timeout>Second-%d</D:timeout>", int64(l.timeout/time.Second))
+	b.WriteString("<D:locktoken><D:href>" + escape(l.token) + "</D:href></D:locktoken>")
+	b.WriteString("<D:lockroot><D:href>" + escape(h.href(l.root, false)) + "</D:href></D:lockroot></D:activelock>")
+	return b.String()
+}
+
+// writeElement 写出属性元素; DAV: 以外的名称空间在元素上声明为默认名称空间
+func writeElement(b *bytes.Buffer, n xml.Name, inner string) {
+	local := n.Local
+	if n.Space == davNS {
+		local = "D:" + local
+		b.WriteString("<" + local)
+	} else {
+		b.WriteString("<" + local + ` xmlns="` + escape(n.Space) + `"`)
+	}
+	if inner == "" {
+		b.WriteString("/>")
+		return
+	}
+	b.WriteString(">" + inner + "</" + local + ">")
+}
+
+// statusElement 返回 status 元素, 如 <D:status>HTTP/1.1 404 Not Found</D:status>
+func statusElement(code int) string {
+	return "<D:status>HTTP/1.1 " + strconv.Itoa(code) + " " + common.StatusText(code) + "</D:status>"
+}
+
+// escape 转义 XML 文本和属性值
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// href 返回资源 name 在响应中的 URL 路径, 集合以 / 结尾
+func (h *Handler) href(name string, dir bool) string {
+	p := strings.TrimSuffix(h.Prefix, "/") + name
+	if dir && !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// etag 以修改时间和长度生成强 ETag
+func etag(fi fs.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size())
+}
+
+// contentType 按扩展名推断媒体类型
+func contentType(name string) string {
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		return ctype
+	}
+	return "application/octet-stream"
+}
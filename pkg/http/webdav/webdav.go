@@ -0,0 +1,724 @@
+package webdav
+
+/*
+	WebDAV 处理器 (RFC 4918 的 1、2 级): 在 FileSystem 上实现 PROPFIND、PROPPATCH、MKCOL、COPY、MOVE、
+	LOCK 和 UNLOCK 等扩展方法以及 GET、PUT、DELETE. 不支持的部分: 死属性 (PROPPATCH 一律 403)、
+	Depth: infinity 的 PROPFIND (403 propfind-finite-depth), 以及部分失败时的 207 响应 (COPY/DELETE 遇错即停)
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/server"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// WebDAV 定义的方法
+const (
+	MethodPropfind  = "PROPFIND"
+	MethodProppatch = "PROPPATCH"
+	MethodMkcol     = "MKCOL"
+	MethodCopy      = "COPY"
+	MethodMove      = "MOVE"
+	MethodLock      = "LOCK"
+	MethodUnlock    = "UNLOCK"
+)
+
+// DefaultMaxBodySize XML 请求体的默认长度上限
+const DefaultMaxBodySize = 1 << 20
+
+// allowedMethods OPTIONS 和 405 响应中的 Allow
+var allowedMethods = strings.Join([]string{
+	common.MethodOptions, common.MethodGet, common.MethodHead, common.MethodPut, common.MethodDelete,
+	MethodPropfind, MethodProppatch, MethodMkcol, MethodCopy, MethodMove, MethodLock, MethodUnlock,
+}, ", ")
+
+var (
+	errBodyTooLarge = errors.New("webdav: request body too large")
+	errBadXML       = errors.New("webdav: malformed XML body")
+)
+
+// Handler 以 FileSystem 中的文件和目录响应 WebDAV 请求, 锁保存在内存中. 不能复制
+type Handler struct {
+	// FileSystem 资源所在的文件系统, 如 Dir("/srv/dav")
+	FileSystem FileSystem
+	// Prefix 映射到 FileSystem 之前从请求路径中去掉的前缀, 如 "/dav"; 响应中的 href 会加回该前缀
+	Prefix string
+	// ReadOnly 为 true 时拒绝所有修改资源或加锁的请求
+	ReadOnly bool
+	// MaxBodySize XML 请求体的长度上限, 0 时使用 DefaultMaxBodySize
+	MaxBodySize int64
+
+	locks lockTable
+}
+
+// ServeHTTP 实现 server.Handler
+func (h *Handler) ServeHTTP(w server.ResponseWriter, req *message.Request) {
+	name, ok := h.stripPrefix(req.URL.Path)
+	if !ok {
+		textError(w, common.StatusNotFound)
+		return
+	}
+	switch req.Method {
+	case common.MethodOptions, common.MethodGet, common.MethodHead, MethodPropfind:
+	case common.MethodPut, common.MethodDelete, MethodProppatch, MethodMkcol, MethodCopy, MethodMove, MethodLock, MethodUnlock:
+		if h.ReadOnly {
+			textError(w, common.StatusForbidden)
+			return
+		}
+	default:
+		w.Header().Set("Allow", allowedMethods)
+		textError(w, common.StatusMethodNotAllowed)
+		return
+	}
+
+	// If 头部对所有方法生效, 其中的锁令牌同时作为提交的令牌
+	var tokens []string
+	if v := req.Header.Get("If"); v != "" {
+		lists, ok := parseIf(v)
+		if !ok {
+			textError(w, common.StatusBadRequest)
+			return
+		}
+		if !h.evalIf(req, lists, name) {
+			textError(w, common.StatusPreconditionFailed)
+			return
+		}
+		tokens = ifTokens(lists)
+	}
+
+	switch req.Method {
+	case common.MethodOptions:
+		h.serveOptions(w)
+	case common.MethodGet, common.MethodHead:
+		h.serveGet(w, req, name)
+	case common.MethodPut:
+		h.servePut(w, req, name, tokens)
+	case common.MethodDelete:
+		h.serveDelete(w, req, name, tokens)
+	case MethodMkcol:
+		h.serveMkcol(w, req, name, tokens)
+	case MethodCopy, MethodMove:
+		h.serveCopyMove(w, req, name, tokens)
+	case MethodLock:
+		h.serveLock(w, req, name, tokens)
+	case MethodUnlock:
+		h.serveUnlock(w, req, name)
+	case MethodPropfind:
+		h.servePropfind(w, req, name)
+	case MethodProppatch:
+		h.serveProppatch(w, req, name, tokens)
+	}
+}
+
+func (h *Handler) serveOptions(w server.ResponseWriter) {
+	hdr := w.Header()
+	hdr.Set("Allow", allowedMethods)
+	hdr.Set("DAV", "1, 2")
+	// Windows 的 WebDAV 客户端靠它识别服务器
+	hdr.Set("MS-Author-Via", "DAV")
+	hdr.Set("Content-Length", "0")
+	w.WriteHeader(common.StatusOK)
+}
+
+func (h *Handler) serveGet(w server.ResponseWriter, req *message.Request, name string) {
+	ctx := req.Context()
+	f, err := h.FileSystem.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		textError(w, errorStatus(err))
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		textError(w, errorStatus(err))
+		return
+	}
+	if fi.IsDir() {
+		// 集合的内容用 PROPFIND 获取
+		w.Header().Set("Allow", allowedMethods)
+		textError(w, common.StatusMethodNotAllowed)
+		return
+	}
+	hdr := w.Header()
+	tag := etag(fi)
+	hdr.Set("ETag", tag)
+	hdr.Set("Last-Modified", fi.ModTime().UTC().Format(utils.TimeFormat))
+	if inm := req.Header.Get("If-None-Match"); inm != "" && (strings.TrimSpace(inm) == "*" || strings.Contains(inm, tag)) {
+		w.WriteHeader(common.StatusNotModified)
+		return
+	}
+	hdr.Set("Content-Type", contentType(name))
+	hdr.Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	w.WriteHeader(common.StatusOK)
+	if req.Method == common.MethodHead {
+		return
+	}
+	io.Copy(w, f)
+}
+
+func (h *Handler) servePut(w server.ResponseWriter, req *message.Request, name string, tokens []string) {
+	ctx := req.Context()
+	fi, err := h.FileSystem.Stat(ctx, name)
+	exists := err == nil
+	if exists && fi.IsDir() {
+		w.Header().Set("Allow", allowedMethods)
+		textError(w, common.StatusMethodNotAllowed)
+		return
+	}
+	if !h.confirmLocks(w, name, !exists, tokens) || !h.parentExists(w, ctx, name) {
+		return
+	}
+	f, err := h.FileSystem.OpenFile(ctx, name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+	if err != nil {
+		textError(w, errorStatus(err))
+		return
+	}
+	var werr error
+	if req.Body != nil {
+		_, werr = io.Copy(f, req.Body)
+	}
+	if cerr := f.Close(); werr == nil {
+		werr = cerr
+	}
+	if werr != nil {
+		textError(w, common.StatusInternalServerError)
+		return
+	}
+	if fi, err := h.FileSystem.Stat(ctx, name); err == nil {
+		w.Header().Set("ETag", etag(fi))
+	}
+	if exists {
+		w.WriteHeader(common.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(common.StatusCreated)
+}
+
+func (h *Handler) serveDelete(w server.ResponseWriter, req *message.Request, name string, tokens []string) {
+	ctx := req.Context()
+	// 集合只能整体删除 (RFC 4918 9.6.1)
+	if d := req.Header.Get("Depth"); d != "" && d != "infinity" {
+		textError(w, common.StatusBadRequest)
+		return
+	}
+	if _, err := h.FileSystem.Stat(ctx, name); err != nil {
+		textError(w, errorStatus(err))
+		return
+	}
+	if !h.confirmLocks(w, name, true, tokens) {
+		return
+	}
+	if err := h.FileSystem.RemoveAll(ctx, name); err != nil {
+		textError(w, errorStatus(err))
+		return
+	}
+	h.locks.removeTree(name)
+	w.WriteHeader(common.StatusNoContent)
+}
+
+func (h *Handler) serveMkcol(w server.ResponseWriter, req *message.Request, name string, tokens []string) {
+	ctx := req.Context()
+	// 不支持带请求体的 MKCOL 扩展
+	if hasBody(req) {
+		textError(w, common.StatusUnsupportedMediaType)
+		return
+	}
+	if !h.confirmLocks(w, name, true, tokens) {
+		return
+	}
+	if err := h.FileSystem.Mkdir(ctx, name, 0o777); err != nil {
+		switch {
+		case errors.Is(err, fs.ErrExist):
+			w.Header().Set("Allow", allowedMethods)
+			textError(w, common.StatusMethodNotAllowed)
+		case errors.Is(err, fs.ErrNotExist):
+			textError(w, common.StatusConflict)
+		default:
+			textError(w, errorStatus(err))
+		}
+		return
+	}
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(common.StatusCreated)
+}
+
+func (h *Handler) serveCopyMove(w server.ResponseWriter, req *message.Request, name string, tokens []string) {
+	ctx := req.Context()
+	move := req.Method == MethodMove
+	dest := req.Header.Get("Destination")
+	if dest == "" {
+		textError(w, common.StatusBadRequest)
+		return
+	}
+	dst, ok := h.resolveHref(req, dest)
+	if !ok {
+		// 目标在别的服务器或不在本处理器之下
+		textError(w, common.StatusBadGateway)
+		return
+	}
+	depth := infiniteDepth
+	switch d := req.Header.Get("Depth"); {
+	case d == "" || d == "infinity":
+	case d == "0" && !move:
+		depth = 0
+	default:
+		textError(w, common.StatusBadRequest)
+		return
+	}
+	overwrite := true
+	switch req.Header.Get("Overwrite") {
+	case "", "T":
+	case "F":
+		overwrite = false
+	default:
+		textError(w, common.StatusBadRequest)
+		return
+	}
+
+	src, err := h.FileSystem.Stat(ctx, name)
+	if err != nil {
+		textError(w, errorStatus(err))
+		return
+	}
+	// 目标是源的祖先时覆盖会先删掉源本身, 源是目录时也不能复制进自己的子树
+	if dst == name || isAncestor(dst, name) || src.IsDir() && isAncestor(name, dst) {
+		textError(w, common.StatusForbidden)
+		return
+	}
+	if move && !h.confirmLocks(w, name, true, tokens) || !h.confirmLocks(w, dst, true, tokens) || !h.parentExists(w, ctx, dst) {
+		return
+	}
+	_, err = h.FileSystem.Stat(ctx, dst)
+	existed := err == nil
+	if existed {
+		if !overwrite {
+			textError(w, common.StatusPreconditionFailed)
+			return
+		}
+		if err := h.FileSystem.RemoveAll(ctx, dst); err != nil {
+			textError(w, errorStatus(err))
+			return
+		}
+		h.locks.removeTree(dst)
+	}
+
+	if move {
+		err = h.FileSystem.Rename(ctx, name, dst)
+		if err == nil {
+			// 锁不随资源移动 (RFC 4918 9.9.4)
+			h.locks.removeTree(name)
+		}
+	} else {
+		err = h.copyTree(ctx, name, dst, src, depth)
+	}
+	if err != nil {
+		textError(w, errorStatus(err))
+		return
+	}
+	if existed {
+		w.WriteHeader(common.StatusNoContent)
+		return
+	}
+	w.Header().Set("Location", h.href(dst, src.IsDir()))
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(common.StatusCreated)
+}
+
+// copyTree 把 src 复制为 dst; depth 为 0 时集合只复制自身, 不复制成员
+func (h *Handler) copyTree(ctx context.Context, src, dst string, fi fs.FileInfo, depth int) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if fi.IsDir() {
+		if err := h.FileSystem.Mkdir(ctx, dst, fi.Mode().Perm()|0o700); err != nil {
+			return err
+		}
+		if depth == 0 {
+			return nil
+		}
+		d, err := h.FileSystem.OpenFile(ctx, src, os.O_RDONLY, 0)
+		if err != nil {
+			return err
+		}
+		children, err := d.Readdir(-1)
+		d.Close()
+		if err != nil {
+			return err
+		}
+		for _, c := range children {
+			if err := h.copyTree(ctx, path.Join(src, c.Name()), path.Join(dst, c.Name()), c, depth); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	in, err := h.FileSystem.OpenFile(ctx, src, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := h.FileSystem.OpenFile(ctx, dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm()|0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (h *Handler) serveLock(w server.ResponseWriter, req *message.Request, name string, tokens []string) {
+	ctx := req.Context()
+	timeout := parseTimeout(req.Header.Get("Timeout"))
+	var li lockInfo
+	hasInfo, err := h.readXML(req, &li)
+	if err != nil {
+		textError(w, bodyErrorStatus(err))
+		return
+	}
+	if !hasInfo {
+		// 没有请求体的 LOCK 刷新 If 头部中的锁
+		if len(tokens) != 1 {
+			textError(w, common.StatusBadRequest)
+			return
+		}
+		l, err := h.locks.refresh(tokens[0], name, timeout)
+		if err != nil {
+			textError(w, common.StatusPreconditionFailed)
+			return
+		}
+		h.writeLockDiscovery(w, common.StatusOK, l)
+		return
+	}
+	if li.Write == nil || (li.Exclusive == nil) == (li.Shared == nil) {
+		textError(w, common.StatusBadRequest)
+		return
+	}
+	depth := infiniteDepth
+	switch d := req.Header.Get("Depth"); d {
+	case "", "infinity":
+	case "0":
+		depth = 0
+	default:
+		textError(w, common.StatusBadRequest)
+		return
+	}
+
+	l, err := h.locks.create(name, depth, li.Exclusive != nil, strings.TrimSpace(li.Owner.InnerXML), timeout)
+	if err != nil {
+		textError(w, common.StatusLocked)
+		return
+	}
+	// 为不存在的资源加锁时创建一个空文件 (RFC 4918 7.3)
+	status := common.StatusOK
+	if _, err := h.FileSystem.Stat(ctx, name); errors.Is(err, fs.ErrNotExist) {
+		if !h.confirmLocks(w, name, true, append(tokens, l.token)) || !h.parentExists(w, ctx, name) {
+			h.locks.unlock(l.token, name)
+			return
+		}
+		f, err := h.FileSystem.OpenFile(ctx, name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+		if err == nil {
+			err = f.Close()
+		}
+		if err != nil {
+			h.locks.unlock(l.token, name)
+			textError(w, errorStatus(err))
+			return
+		}
+		status = common.StatusCreated
+	}
+	w.Header().Set("Lock-Token", "<"+l.token+">")
+	h.writeLockDiscovery(w, status, l)
+}
+
+// writeLockDiscovery 写出 LOCK 的响应体: 只包含 lockdiscovery 属性的 prop 元素
+func (h *Handler) writeLockDiscovery(w server.ResponseWriter, status int, l lock) {
+	body := `<?xml version="1.0" encoding="utf-8"?>` + "\n" +
+		`<D:prop xmlns:D="DAV:"><D:lockdiscovery>` + h.activeLock(l) + "</D:lockdiscovery></D:prop>\n"
+	writeXML(w, status, []byte(body))
+}
+
+func (h *Handler) serveUnlock(w server.ResponseWriter, req *message.Request, name string) {
+	token := req.Header.Get("Lock-Token")
+	if len(token) < 2 || token[0] != '<' || token[len(token)-1] != '>' {
+		textError(w, common.StatusBadRequest)
+		return
+	}
+	if err := h.locks.unlock(token[1:len(token)-1], name); err != nil {
+		textError(w, common.StatusConflict)
+		return
+	}
+	w.WriteHeader(common.StatusNoContent)
+}
+
+func (h *Handler) servePropfind(w server.ResponseWriter, req *message.Request, name string) {
+	ctx := req.Context()
+	fi, err := h.FileSystem.Stat(ctx, name)
+	if err != nil {
+		textError(w, errorStatus(err))
+		return
+	}
+	switch req.Header.Get("Depth") {
+	case "0", "1":
+	case "", "infinity":
+		// 遍历整棵树代价太高 (RFC 4918 9.1)
+		writeXML(w, common.StatusForbidden, []byte(`<?xml version="1.0" encoding="utf-8"?>`+"\n"+
+			`<D:error xmlns:D="DAV:"><D:propfind-finite-depth/></D:error>`+"\n"))
+		return
+	default:
+		textError(w, common.StatusBadRequest)
+		return
+	}
+	var pf propfind
+	ok, err := h.readXML(req, &pf)
+	if err != nil {
+		textError(w, bodyErrorStatus(err))
+		return
+	}
+	// allprop、propname 和 prop 只能出现一个
+	if ok && countSet(pf.AllProp != nil, pf.PropName != nil, pf.Prop != nil) != 1 {
+		textError(w, common.StatusBadRequest)
+		return
+	}
+
+	ms := newMultistatus()
+	h.propfind(ms, &pf, name, fi)
+	if fi.IsDir() && req.Header.Get("Depth") == "1" {
+		d, err := h.FileSystem.OpenFile(ctx, name, os.O_RDONLY, 0)
+		if err != nil {
+			textError(w, errorStatus(err))
+			return
+		}
+		children, err := d.Readdir(-1)
+		d.Close()
+		if err != nil {
+			textError(w, errorStatus(err))
+			return
+		}
+		for _, c := range children {
+			h.propfind(ms, &pf, path.Join(name, c.Name()), c)
+		}
+	}
+	writeXML(w, common.StatusMultiStatus, ms.bytes())
+}
+
+func (h *Handler) serveProppatch(w server.ResponseWriter, req *message.Request, name string, tokens []string) {
+	ctx := req.Context()
+	fi, err := h.FileSystem.Stat(ctx, name)
+	if err != nil {
+		textError(w, errorStatus(err))
+		return
+	}
+	if !h.confirmLocks(w, name, false, tokens) {
+		return
+	}
+	var pu propertyUpdate
+	ok, err := h.readXML(req, &pu)
+	if err != nil || !ok {
+		textError(w, bodyErrorStatus(err))
+		return
+	}
+	// 活属性受保护, 死属性不保存: 所有属性都以 403 拒绝, 整个请求不生效
+	denied := propstat{status: common.StatusForbidden}
+	for _, ps := range append(pu.Set, pu.Remove...) {
+		denied.props = append(denied.props, ps...)
+	}
+	ms := newMultistatus()
+	ms.response(h.href(name, fi.IsDir()), denied)
+	writeXML(w, common.StatusMultiStatus, ms.bytes())
+}
+
+// evalIf 求值 If 头部: 任一列表的条件全部成立时为真; 带标签的列表针对标签所指的资源求值
+func (h *Handler) evalIf(req *message.Request, lists []ifList, name string) bool {
+	for _, l := range lists {
+		target := name
+		if l.resource != "" {
+			t, ok := h.resolveHref(req, l.resource)
+			if !ok {
+				continue
+			}
+			target = t
+		}
+		tag := ""
+		if fi, err := h.FileSystem.Stat(req.Context(), target); err == nil {
+			tag = etag(fi)
+		}
+		match := true
+		for _, c := range l.conditions {
+			var ok bool
+			if c.token != "" {
+				ok = h.locks.valid(c.token, target)
+			} else {
+				ok = tag != "" && c.etag == tag
+			}
+			if ok == c.not {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// confirmLocks 检查请求提交了修改 name 所需的锁令牌, 否则写出 423
+func (h *Handler) confirmLocks(w server.ResponseWriter, name string, member bool, tokens []string) bool {
+	if h.locks.confirm(name, member, tokens) {
+		return true
+	}
+	writeXML(w, common.StatusLocked, []byte(`<?xml version="1.0" encoding="utf-8"?>`+"\n"+
+		`<D:error xmlns:D="DAV:"><D:lock-token-submitted><D:href>`+escape(h.href(name, false))+
+		"</D:href></D:lock-token-submitted></D:error>\n"))
+	return false
+}
+
+// parentExists 检查 name 的父集合存在, 否则写出 409 (RFC 4918 9.3.1, 9.7.1)
+func (h *Handler) parentExists(w server.ResponseWriter, ctx context.Context, name string) bool {
+	fi, err := h.FileSystem.Stat(ctx, path.Dir(name))
+	if err == nil && fi.IsDir() {
+		return true
+	}
+	textError(w, common.StatusConflict)
+	return false
+}
+
+// stripPrefix 把请求路径映射为 FileSystem 中的名称
+func (h *Handler) stripPrefix(p string) (string, bool) {
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	rest, ok := strings.CutPrefix(p, strings.TrimSuffix(h.Prefix, "/"))
+	if !ok || rest != "" && rest[0] != '/' {
+		return "", false
+	}
+	return path.Clean("/" + rest), true
+}
+
+// resolveHref 把 Destination 或 If 标签中的 URL 映射为名称; 主机与请求不同或不在 Prefix 之下时 ok 为 false
+func (h *Handler) resolveHref(req *message.Request, href string) (string, bool) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", false
+	}
+	if u.Host != "" && !strings.EqualFold(u.Host, req.HostHeader()) {
+		return "", false
+	}
+	return h.stripPrefix(u.Path)
+}
+
+// readXML 把请求体解码到 v; 没有请求体时 ok 为 false
+func (h *Handler) readXML(req *message.Request, v any) (ok bool, err error) {
+	if req.Body == nil {
+		return false, nil
+	}
+	limit := h.MaxBodySize
+	if limit <= 0 {
+		limit = DefaultMaxBodySize
+	}
+	b, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil {
+		return false, err
+	}
+	if int64(len(b)) > limit {
+		return false, errBodyTooLarge
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return false, nil
+	}
+	if err := xml.Unmarshal(b, v); err != nil {
+		return false, errors.Join(errBadXML, err)
+	}
+	return true, nil
+}
+
+// hasBody 报告请求是否带有非空的消息体
+func hasBody(req *message.Request) bool {
+	if req.Body == nil || req.ContentLength == 0 {
+		return false
+	}
+	if req.ContentLength > 0 {
+		return true
+	}
+	var b [1]byte
+	n, _ := io.ReadFull(req.Body, b[:])
+	return n > 0
+}
+
+// parseTimeout 解析 Timeout 头部 (RFC 4918 10.7), 取第一个可识别的值
+func parseTimeout(s string) time.Duration {
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if strings.EqualFold(v, "Infinite") {
+			return maxLockTimeout
+		}
+		if n, ok := strings.CutPrefix(v, "Second-"); ok {
+			if sec, err := strconv.ParseUint(n, 10, 32); err == nil {
+				return min(max(time.Duration(sec)*time.Second, time.Second), maxLockTimeout)
+			}
+		}
+	}
+	return DefaultLockTimeout
+}
+
+func countSet(bs ...bool) int {
+	n := 0
+	for _, b := range bs {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+func bodyErrorStatus(err error) int {
+	if errors.Is(err, errBodyTooLarge) {
+		return common.StatusRequestEntityTooLarge
+	}
+	return common.StatusBadRequest
+}
+
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrInvalid):
+		return common.StatusNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return common.StatusForbidden
+	case errors.Is(err, fs.ErrExist):
+		return common.StatusConflict
+	}
+	return common.StatusInternalServerError
+}
+
+// writeXML 以 application/xml 写出响应体
+func writeXML(w server.ResponseWriter, status int, body []byte) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// textError 以状态文本作为纯文本消息体写出错误响应
+func textError(w server.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	io.WriteString(w, common.StatusText(code)+"\n")
+}
@@ -0,0 +1,254 @@
+package webdav
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	httptest "github.com/narcilee7/http-stack/internal/testing"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+const lockBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype>
+<D:owner>tester</D:owner></D:lockinfo>`
+
+// newTestHandler 在临时目录上创建处理器, files 为相对路径到内容的映射, 以 / 结尾的键创建目录
+func newTestHandler(t *testing.T, files map[string]string) (*Handler, string) {
+	t.Helper()
+	root := t.TempDir()
+	for name, data := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(p, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &Handler{FileSystem: Dir(root)}, root
+}
+
+func serve(t *testing.T, h *Handler, method, target string, header map[string]string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req, err := message.NewRequest(method, "http://dav.example"+target, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "dav.example"
+	req.ContentLength = int64(len(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func readFile(t *testing.T, root, name string) (string, bool) {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return "", false
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b), true
+}
+
+func TestCopyFile(t *testing.T) {
+	h, root := newTestHandler(t, map[string]string{"a.txt": "alpha", "b.txt": "beta"})
+
+	rec := serve(t, h, MethodCopy, "/a.txt", map[string]string{"Destination": "/c.txt"}, "")
+	if rec.Code != common.StatusCreated {
+		t.Fatalf("COPY to new resource = %d, want 201", rec.Code)
+	}
+	if got, _ := readFile(t, root, "c.txt"); got != "alpha" {
+		t.Fatalf("c.txt = %q", got)
+	}
+	if _, ok := readFile(t, root, "a.txt"); !ok {
+		t.Fatal("COPY removed the source")
+	}
+
+	rec = serve(t, h, MethodCopy, "/a.txt", map[string]string{"Destination": "/b.txt", "Overwrite": "F"}, "")
+	if rec.Code != common.StatusPreconditionFailed {
+		t.Fatalf("COPY with Overwrite: F onto existing = %d, want 412", rec.Code)
+	}
+	rec = serve(t, h, MethodCopy, "/a.txt", map[string]string{"Destination": "/b.txt"}, "")
+	if rec.Code != common.StatusNoContent {
+		t.Fatalf("COPY onto existing = %d, want 204", rec.Code)
+	}
+	if got, _ := readFile(t, root, "b.txt"); got != "alpha" {
+		t.Fatalf("b.txt = %q after overwrite", got)
+	}
+
+	rec = serve(t, h, MethodCopy, "/a.txt", map[string]string{"Destination": "/missing/a.txt"}, "")
+	if rec.Code != common.StatusConflict {
+		t.Fatalf("COPY into missing collection = %d, want 409", rec.Code)
+	}
+	rec = serve(t, h, MethodCopy, "/a.txt", map[string]string{"Destination": "http://other.example/x"}, "")
+	if rec.Code != common.StatusBadGateway {
+		t.Fatalf("COPY to another host = %d, want 502", rec.Code)
+	}
+}
+
+func TestCopyCollectionDepth(t *testing.T) {
+	h, root := newTestHandler(t, map[string]string{"src/one.txt": "1", "src/sub/two.txt": "2"})
+
+	rec := serve(t, h, MethodCopy, "/src", map[string]string{"Destination": "/deep"}, "")
+	if rec.Code != common.StatusCreated {
+		t.Fatalf("COPY Depth: infinity = %d, want 201", rec.Code)
+	}
+	if got, _ := readFile(t, root, "deep/sub/two.txt"); got != "2" {
+		t.Fatalf("deep/sub/two.txt = %q", got)
+	}
+
+	rec = serve(t, h, MethodCopy, "/src", map[string]string{"Destination": "/shallow", "Depth": "0"}, "")
+	if rec.Code != common.StatusCreated {
+		t.Fatalf("COPY Depth: 0 = %d, want 201", rec.Code)
+	}
+	if fi, err := os.Stat(filepath.Join(root, "shallow")); err != nil || !fi.IsDir() {
+		t.Fatalf("shallow is not a collection: %v", err)
+	}
+	if _, ok := readFile(t, root, "shallow/one.txt"); ok {
+		t.Fatal("COPY Depth: 0 copied collection members")
+	}
+
+	rec = serve(t, h, MethodCopy, "/src", map[string]string{"Destination": "/src/sub/inner"}, "")
+	if rec.Code != common.StatusForbidden {
+		t.Fatalf("COPY collection into itself = %d, want 403", rec.Code)
+	}
+}
+
+func TestMove(t *testing.T) {
+	h, root := newTestHandler(t, map[string]string{"dir/a.txt": "alpha", "other/": ""})
+
+	rec := serve(t, h, MethodMove, "/dir/a.txt", map[string]string{"Destination": "/other/a.txt"}, "")
+	if rec.Code != common.StatusCreated {
+		t.Fatalf("MOVE = %d, want 201", rec.Code)
+	}
+	if _, ok := readFile(t, root, "dir/a.txt"); ok {
+		t.Fatal("MOVE left the source in place")
+	}
+	if got, _ := readFile(t, root, "other/a.txt"); got != "alpha" {
+		t.Fatalf("other/a.txt = %q", got)
+	}
+
+	rec = serve(t, h, MethodMove, "/other/a.txt", map[string]string{"Destination": "/other/a.txt"}, "")
+	if rec.Code != common.StatusForbidden {
+		t.Fatalf("MOVE onto itself = %d, want 403", rec.Code)
+	}
+	rec = serve(t, h, MethodMove, "/other", map[string]string{"Destination": "/moved", "Depth": "0"}, "")
+	if rec.Code != common.StatusBadRequest {
+		t.Fatalf("MOVE with Depth: 0 = %d, want 400", rec.Code)
+	}
+}
+
+func TestLockBlocksWritesWithoutToken(t *testing.T) {
+	h, root := newTestHandler(t, map[string]string{"doc.txt": "v1"})
+
+	rec := serve(t, h, MethodLock, "/doc.txt", map[string]string{"Timeout": "Second-60"}, lockBody)
+	if rec.Code != common.StatusOK {
+		t.Fatalf("LOCK = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	token := rec.Result().Header.Get("Lock-Token")
+	if !strings.HasPrefix(token, "<urn:uuid:") {
+		t.Fatalf("Lock-Token = %q", token)
+	}
+	if !strings.Contains(rec.Body.String(), "tester") {
+		t.Fatalf("lockdiscovery does not report the owner: %s", rec.Body)
+	}
+
+	if rec := serve(t, h, common.MethodPut, "/doc.txt", nil, "v2"); rec.Code != common.StatusLocked {
+		t.Fatalf("PUT without token = %d, want 423", rec.Code)
+	}
+	if rec := serve(t, h, common.MethodDelete, "/doc.txt", nil, ""); rec.Code != common.StatusLocked {
+		t.Fatalf("DELETE without token = %d, want 423", rec.Code)
+	}
+	if rec := serve(t, h, MethodLock, "/doc.txt", nil, lockBody); rec.Code != common.StatusLocked {
+		t.Fatalf("conflicting exclusive LOCK = %d, want 423", rec.Code)
+	}
+	if got, _ := readFile(t, root, "doc.txt"); got != "v1" {
+		t.Fatalf("locked file changed to %q", got)
+	}
+
+	rec = serve(t, h, common.MethodPut, "/doc.txt", map[string]string{"If": "(" + token + ")"}, "v2")
+	if rec.Code != common.StatusNoContent && rec.Code != common.StatusOK {
+		t.Fatalf("PUT with token = %d, want 2xx", rec.Code)
+	}
+	if got, _ := readFile(t, root, "doc.txt"); got != "v2" {
+		t.Fatalf("doc.txt = %q after PUT with token", got)
+	}
+
+	if rec := serve(t, h, common.MethodPut, "/doc.txt", map[string]string{"If": "(<urn:uuid:bogus>)"}, "v3"); rec.Code != common.StatusPreconditionFailed {
+		t.Fatalf("PUT with unknown token = %d, want 412", rec.Code)
+	}
+
+	if rec := serve(t, h, MethodUnlock, "/doc.txt", map[string]string{"Lock-Token": token}, ""); rec.Code != common.StatusNoContent {
+		t.Fatalf("UNLOCK = %d, want 204", rec.Code)
+	}
+	if rec := serve(t, h, common.MethodDelete, "/doc.txt", nil, ""); rec.Code != common.StatusNoContent {
+		t.Fatalf("DELETE after UNLOCK = %d, want 204", rec.Code)
+	}
+}
+
+func TestLockCollectionCoversMembers(t *testing.T) {
+	h, _ := newTestHandler(t, map[string]string{"dir/a.txt": "alpha"})
+
+	rec := serve(t, h, MethodLock, "/dir", nil, lockBody)
+	if rec.Code != common.StatusOK {
+		t.Fatalf("LOCK = %d, want 200", rec.Code)
+	}
+	token := rec.Result().Header.Get("Lock-Token")
+
+	if rec := serve(t, h, common.MethodPut, "/dir/new.txt", nil, "x"); rec.Code != common.StatusLocked {
+		t.Fatalf("PUT into locked collection = %d, want 423", rec.Code)
+	}
+	if rec := serve(t, h, MethodMove, "/dir/a.txt", map[string]string{"Destination": "/a.txt"}, ""); rec.Code != common.StatusLocked {
+		t.Fatalf("MOVE out of locked collection = %d, want 423", rec.Code)
+	}
+	rec = serve(t, h, MethodMove, "/dir/a.txt", map[string]string{"Destination": "/a.txt", "If": "(" + token + ")"}, "")
+	if rec.Code != common.StatusCreated {
+		t.Fatalf("MOVE with token = %d, want 201", rec.Code)
+	}
+}
+
+func TestLockUnmappedCreatesEmptyResource(t *testing.T) {
+	h, root := newTestHandler(t, nil)
+	rec := serve(t, h, MethodLock, "/new.txt", map[string]string{"Depth": "0"}, lockBody)
+	if rec.Code != common.StatusCreated {
+		t.Fatalf("LOCK on unmapped URL = %d, want 201", rec.Code)
+	}
+	if got, ok := readFile(t, root, "new.txt"); !ok || got != "" {
+		t.Fatalf("new.txt = %q, %v; want empty file", got, ok)
+	}
+}
+
+func TestMoveOntoAncestorIsForbidden(t *testing.T) {
+	h, root := newTestHandler(t, map[string]string{"a/b.txt": "b", "a/keep.txt": "keep"})
+
+	for _, method := range []string{MethodMove, MethodCopy} {
+		rec := serve(t, h, method, "/a/b.txt", map[string]string{"Destination": "/a"}, "")
+		if rec.Code != common.StatusForbidden {
+			t.Fatalf("%s onto ancestor = %d, want 403", method, rec.Code)
+		}
+		rec = serve(t, h, method, "/a/b.txt", map[string]string{"Destination": "/"}, "")
+		if rec.Code != common.StatusForbidden {
+			t.Fatalf("%s onto root = %d, want 403", method, rec.Code)
+		}
+	}
+	for _, name := range []string{"a/b.txt", "a/keep.txt"} {
+		if _, ok := readFile(t, root, name); !ok {
+			t.Fatalf("%s was deleted", name)
+		}
+	}
+}
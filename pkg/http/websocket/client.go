@@ -0,0 +1,195 @@
+package websocket
+
+/*
+	客户端握手 (RFC 6455 4.1): 经连接池建立 ws/wss 连接, 发送升级请求并校验服务端的 101 响应
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/client"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http1"
+)
+
+// ErrBadScheme 地址的 scheme 不是 ws 或 wss
+var ErrBadScheme = errors.New("websocket: URL scheme must be ws or wss")
+
+// maxRejectBody 握手被拒绝时读入内存的响应体上限
+const maxRejectBody = 1 << 10
+
+// Dialer 建立 WebSocket 连接
+type Dialer struct {
+	// Pool 建立连接使用的连接池, 为空时使用默认配置; wss 的 TLS 配置和拨号函数取自连接池.
+	// 握手成功后连接由 WebSocket 独占, 关闭时从连接池的计数中移除
+	Pool *client.Pool
+	// Subprotocols 按优先顺序请求的子协议
+	Subprotocols []string
+	// EnableCompression 为 true 时提议 permessage-deflate
+	EnableCompression bool
+	// HandshakeTimeout 握手的最长时间, 0 表示只受 ctx 限制
+	HandshakeTimeout time.Duration
+	// Options 握手后连接的读写参数
+	Options
+
+	once sync.Once
+	pool *client.Pool
+}
+
+// DefaultDialer 默认配置的 Dialer
+var DefaultDialer = &Dialer{}
+
+// Dial 使用 DefaultDialer 连接 rawURL
+func Dial(ctx context.Context, rawURL string, header common.Header) (*Conn, *message.Response, error) {
+	return DefaultDialer.Dial(ctx, rawURL, header)
+}
+
+func (d *Dialer) getPool() *client.Pool {
+	d.once.Do(func() {
+		d.pool = d.Pool
+		if d.pool == nil {
+			d.pool = client.NewPool(client.PoolConfig{})
+		}
+	})
+	return d.pool
+}
+
+// Dial 连接 ws:// 或 wss:// 地址 rawURL, header 为握手请求附加的头部 (如 Origin、Cookie).
+// 服务端拒绝升级时返回包装 ErrBadHandshake 的错误和服务端的响应, 响应体已读入内存 (最多 1KB)
+func (d *Dialer) Dial(ctx context.Context, rawURL string, header common.Header) (*Conn, *message.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	var scheme, port string
+	switch u.Scheme {
+	case "ws":
+		scheme, port = "http", "80"
+	case "wss":
+		scheme, port = "https", "443"
+	default:
+		return nil, nil, fmt.Errorf("%w: %q", ErrBadScheme, rawURL)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+	if d.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.HandshakeTimeout)
+		defer cancel()
+	}
+
+	hu := *u
+	hu.Scheme = scheme
+	hu.Fragment = ""
+	req, err := message.NewRequestWithContext(ctx, common.MethodGet, hu.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, vs := range header {
+		if common.CanonicalHeaderKey(k) == "Host" {
+			req.Host = vs[0]
+			continue
+		}
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	key := newKey()
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(d.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(d.Subprotocols, ", "))
+	}
+	if d.EnableCompression {
+		req.Header.Set("Sec-WebSocket-Extensions", deflateOffer)
+	}
+
+	pool := d.getPool()
+	pc, err := pool.Get(ctx, scheme, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 上下文取消时通过设置过期时间打断阻塞的读写
+	stop := context.AfterFunc(ctx, func() {
+		pc.SetDeadline(time.Unix(1, 0))
+	})
+	if deadline, ok := ctx.Deadline(); ok {
+		pc.SetDeadline(deadline)
+	}
+	bw := bufio.NewWriter(pc)
+	br := bufio.NewReader(pc)
+	err = http1.WriteRequest(bw, req)
+	var resp *message.Response
+	if err == nil {
+		resp, err = http1.ReadResponse(br, req)
+	}
+	if !stop() && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		pool.Discard(pc)
+		return nil, nil, err
+	}
+
+	compress, err := d.checkResponse(resp, key)
+	if err != nil {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxRejectBody))
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		pool.Discard(pc)
+		return nil, resp, err
+	}
+	pc.SetDeadline(time.Time{})
+	release := func() error {
+		pool.Discard(pc)
+		return nil
+	}
+	return newConn(pc, br, false, d.Options, resp.Header.Get("Sec-WebSocket-Protocol"), compress, release), resp, nil
+}
+
+// checkResponse 校验服务端的 101 响应, 返回是否协商到 permessage-deflate
+func (d *Dialer) checkResponse(resp *message.Response, key string) (bool, error) {
+	if resp.StatusCode != common.StatusSwitchingProtocols {
+		return false, fmt.Errorf("%w: unexpected status %s", ErrBadHandshake, resp.Status)
+	}
+	if !resp.Header.HasToken("Connection", "upgrade") || !resp.Header.HasToken("Upgrade", "websocket") {
+		return false, fmt.Errorf("%w: missing Connection: Upgrade or Upgrade: websocket", ErrBadHandshake)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return false, fmt.Errorf("%w: mismatched Sec-WebSocket-Accept", ErrBadHandshake)
+	}
+	if p := resp.Header.Get("Sec-WebSocket-Protocol"); p != "" && !slices.Contains(d.Subprotocols, p) {
+		return false, fmt.Errorf("%w: unrequested subprotocol %q", ErrBadHandshake, p)
+	}
+	if !d.EnableCompression {
+		if resp.Header.Has("Sec-WebSocket-Extensions") {
+			return false, fmt.Errorf("%w: unrequested extensions", ErrBadHandshake)
+		}
+		return false, nil
+	}
+	return checkDeflateResponse(resp.Header)
+}
+
+// newKey 生成随机的 Sec-WebSocket-Key
+func newKey() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return base64.StdEncoding.EncodeToString(b[:])
+}
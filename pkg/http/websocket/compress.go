@@ -0,0 +1,193 @@
+package websocket
+
+/*
+	permessage-deflate 扩展 (RFC 7692): 协商扩展参数, 以及消息的压缩与解压.
+	双方都不保留跨消息的压缩上下文 (no_context_takeover), 每条消息独立压缩
+*/
+
+import (
+	"compress/flate"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+const extPermessageDeflate = "permessage-deflate"
+
+// deflateOffer 客户端的提议和服务端的响应: 双方都不保留压缩上下文, 压缩器因此可以按消息复用
+const deflateOffer = extPermessageDeflate + "; server_no_context_takeover; client_no_context_takeover"
+
+var (
+	// deflateSyncTail sync flush 输出的结尾, 发送时去掉 (RFC 7692 7.2.1)
+	deflateSyncTail = []byte{0x00, 0x00, 0xff, 0xff}
+	// deflateTail 接收时补在消息之后: 补回 sync flush 结尾, 再加一个空的最终块, 使解压器在消息末尾返回 io.EOF
+	deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+)
+
+var (
+	flateWriterPools [flate.BestCompression - flate.HuffmanOnly + 1]sync.Pool
+	flateReaderPool  sync.Pool
+)
+
+// getFlateWriter 取出输出到 w 的压缩器, level 必须是合法的 flate 级别
+func getFlateWriter(w io.Writer, level int) *flate.Writer {
+	if fw, ok := flateWriterPools[level-flate.HuffmanOnly].Get().(*flate.Writer); ok {
+		fw.Reset(w)
+		return fw
+	}
+	fw, _ := flate.NewWriter(w, level)
+	return fw
+}
+
+func putFlateWriter(fw *flate.Writer, level int) {
+	flateWriterPools[level-flate.HuffmanOnly].Put(fw)
+}
+
+// getFlateReader 取出读取 r 的解压器
+func getFlateReader(r io.Reader) io.ReadCloser {
+	if fr, ok := flateReaderPool.Get().(io.ReadCloser); ok {
+		fr.(flate.Resetter).Reset(r, nil)
+		return fr
+	}
+	return flate.NewReader(r)
+}
+
+func putFlateReader(fr io.ReadCloser) {
+	flateReaderPool.Put(fr)
+}
+
+// deflateSink 接收压缩器的输出, 末尾 4 字节留在缓冲中, 消息结束时作为 sync flush 结尾去掉
+type deflateSink struct{ w *messageWriter }
+
+func (s deflateSink) Write(p []byte) (int, error) {
+	s.w.appendPayload(p, len(deflateSyncTail))
+	if s.w.err != nil {
+		return 0, s.w.err
+	}
+	return len(p), nil
+}
+
+// extension Sec-WebSocket-Extensions 中的一项
+type extension struct {
+	name   string
+	params []extParam
+}
+
+type extParam struct {
+	key, value string
+}
+
+// parseExtensions 解析 Sec-WebSocket-Extensions 头部, 保持出现的顺序
+func parseExtensions(h common.Header) ([]extension, error) {
+	var exts []extension
+	var sc utils.ParamScanner
+	for _, v := range h.Values("Sec-WebSocket-Extensions") {
+		for _, item := range strings.Split(v, ",") {
+			if strings.TrimSpace(item) == "" {
+				continue
+			}
+			sc.Reset(item, ';')
+			if !sc.Next() || sc.RawValue() != "" {
+				return nil, fmt.Errorf("%w: malformed extension %q", ErrBadHandshake, item)
+			}
+			ext := extension{name: sc.Key()}
+			for sc.Next() {
+				ext.params = append(ext.params, extParam{key: sc.Key(), value: sc.Value()})
+			}
+			if err := sc.Err(); err != nil {
+				return nil, fmt.Errorf("%w: malformed extension %q", ErrBadHandshake, item)
+			}
+			exts = append(exts, ext)
+		}
+	}
+	return exts, nil
+}
+
+// windowBits 解析 *_max_window_bits 的值 (8 到 15), 没有值时返回 0
+func windowBits(v string) (int, bool) {
+	if v == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(v)
+	return n, err == nil && n >= 8 && n <= 15
+}
+
+// acceptDeflate 服务端判断能否接受客户端的一个 permessage-deflate 提议.
+// 压缩器总是使用 32KB 窗口, 因此不接受限制服务端窗口的提议
+func acceptDeflate(ext extension) bool {
+	seen := make(map[string]bool, len(ext.params))
+	for _, p := range ext.params {
+		if seen[p.key] {
+			return false
+		}
+		seen[p.key] = true
+		switch p.key {
+		case "server_no_context_takeover", "client_no_context_takeover":
+			if p.value != "" {
+				return false
+			}
+		case "server_max_window_bits":
+			if n, ok := windowBits(p.value); !ok || n != 15 {
+				return false
+			}
+		case "client_max_window_bits":
+			if _, ok := windowBits(p.value); !ok {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// negotiateDeflate 在客户端的提议中选择第一个可以接受的 permessage-deflate
+func negotiateDeflate(h common.Header) bool {
+	exts, err := parseExtensions(h)
+	if err != nil {
+		return false
+	}
+	for _, ext := range exts {
+		if ext.name == extPermessageDeflate && acceptDeflate(ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDeflateResponse 客户端检查服务端对 deflateOffer 的响应, 返回是否启用压缩
+func checkDeflateResponse(h common.Header) (bool, error) {
+	exts, err := parseExtensions(h)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case len(exts) == 0:
+		return false, nil
+	case len(exts) > 1 || exts[0].name != extPermessageDeflate:
+		return false, fmt.Errorf("%w: unexpected extensions %q", ErrBadHandshake, h.Values("Sec-WebSocket-Extensions"))
+	}
+	noTakeover := false
+	for _, p := range exts[0].params {
+		switch p.key {
+		case "server_no_context_takeover":
+			noTakeover = true
+		case "client_no_context_takeover":
+		case "server_max_window_bits":
+			if _, ok := windowBits(p.value); !ok || p.value == "" {
+				return false, fmt.Errorf("%w: bad server_max_window_bits %q", ErrBadHandshake, p.value)
+			}
+		default:
+			return false, fmt.Errorf("%w: unexpected permessage-deflate parameter %q", ErrBadHandshake, p.key)
+		}
+	}
+	if !noTakeover {
+		return false, fmt.Errorf("%w: server_no_context_takeover not accepted", ErrBadHandshake)
+	}
+	return true, nil
+}
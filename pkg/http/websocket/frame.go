@@ -0,0 +1,172 @@
+package websocket
+
+/*
+	WebSocket 帧 (RFC 6455 5.2): 帧头的解析与编码, 以及客户端到服务端方向的载荷掩码
+*/
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Opcode 帧类型
+type Opcode uint8
+
+const (
+	OpContinuation Opcode = 0x0
+	OpText         Opcode = 0x1
+	OpBinary       Opcode = 0x2
+	OpClose        Opcode = 0x8
+	OpPing         Opcode = 0x9
+	OpPong         Opcode = 0xA
+)
+
+func (op Opcode) String() string {
+	switch op {
+	case OpContinuation:
+		return "continuation"
+	case OpText:
+		return "text"
+	case OpBinary:
+		return "binary"
+	case OpClose:
+		return "close"
+	case OpPing:
+		return "ping"
+	case OpPong:
+		return "pong"
+	}
+	return "opcode(" + strconv.Itoa(int(op)) + ")"
+}
+
+// IsControl 报告是否为控制帧 (close、ping、pong)
+func (op Opcode) IsControl() bool { return op&0x8 != 0 }
+
+const (
+	finBit  = 0x80
+	rsv1Bit = 0x40
+	rsv2Bit = 0x20
+	rsv3Bit = 0x10
+	maskBit = 0x80
+
+	// maxControlPayload 控制帧载荷的上限 (RFC 6455 5.5)
+	maxControlPayload = 125
+	// maxFrameHeaderLen 帧头的最大长度: 2 字节基本头 + 8 字节扩展长度 + 4 字节掩码
+	maxFrameHeaderLen = 14
+)
+
+// frameHeader 解析后的帧头
+type frameHeader struct {
+	fin    bool
+	rsv    byte
+	op     Opcode
+	masked bool
+	key    [4]byte
+	length int64
+}
+
+// readFrameHeader 从 br 读取一个帧头
+func readFrameHeader(br *bufio.Reader) (frameHeader, error) {
+	var h frameHeader
+	b, err := br.Peek(2)
+	if err != nil {
+		return h, err
+	}
+	h.fin = b[0]&finBit != 0
+	h.rsv = b[0] & (rsv1Bit | rsv2Bit | rsv3Bit)
+	h.op = Opcode(b[0] & 0x0f)
+	h.masked = b[1]&maskBit != 0
+	n := int64(b[1] & 0x7f)
+	br.Discard(2)
+
+	var ext []byte
+	switch n {
+	case 126:
+		if ext, err = readFull(br, 2); err != nil {
+			return h, err
+		}
+		n = int64(binary.BigEndian.Uint16(ext))
+	case 127:
+		if ext, err = readFull(br, 8); err != nil {
+			return h, err
+		}
+		v := binary.BigEndian.Uint64(ext)
+		if v>>63 != 0 {
+			return h, fmt.Errorf("%w: frame length overflows", ErrProtocol)
+		}
+		n = int64(v)
+	}
+	h.length = n
+	if h.masked {
+		key, err := readFull(br, 4)
+		if err != nil {
+			return h, err
+		}
+		copy(h.key[:], key)
+	}
+	return h, nil
+}
+
+// readFull 读取 n 字节, 返回的切片在下一次读取 br 前有效
+func readFull(br *bufio.Reader, n int) ([]byte, error) {
+	b, err := br.Peek(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	br.Discard(n)
+	return b, nil
+}
+
+// appendFrameHeader 追加帧头, masked 为 true 时写入掩码 key
+func appendFrameHeader(dst []byte, fin bool, rsv byte, op Opcode, length int, masked bool, key [4]byte) []byte {
+	b0 := rsv | byte(op)
+	if fin {
+		b0 |= finBit
+	}
+	var b1 byte
+	if masked {
+		b1 = maskBit
+	}
+	switch {
+	case length <= 125:
+		dst = append(dst, b0, b1|byte(length))
+	case length <= 0xffff:
+		dst = append(dst, b0, b1|126)
+		dst = binary.BigEndian.AppendUint16(dst, uint16(length))
+	default:
+		dst = append(dst, b0, b1|127)
+		dst = binary.BigEndian.AppendUint64(dst, uint64(length))
+	}
+	if masked {
+		dst = append(dst, key[:]...)
+	}
+	return dst
+}
+
+// maskBytes 以 key 从偏移 pos 起对 b 做异或掩码 (掩码与去掩码相同), 返回下一段数据的偏移
+func maskBytes(key [4]byte, pos int, b []byte) int {
+	pos &= 3
+	// 先逐字节对齐到 key 的起点, 再按 8 字节一组处理
+	for len(b) > 0 && pos != 0 {
+		b[0] ^= key[pos]
+		b = b[1:]
+		pos = (pos + 1) & 3
+	}
+	k32 := binary.LittleEndian.Uint32(key[:])
+	k64 := uint64(k32) | uint64(k32)<<32
+	for len(b) >= 8 {
+		binary.LittleEndian.PutUint64(b, binary.LittleEndian.Uint64(b)^k64)
+		b = b[8:]
+	}
+	for i := range b {
+		b[i] ^= key[pos]
+		pos = (pos + 1) & 3
+	}
+	return pos
+}
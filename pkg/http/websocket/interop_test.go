@@ -0,0 +1,169 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	httptest "github.com/narcilee7/http-stack/internal/testing"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/server"
+)
+
+// 以下测试使用 internal/testing 中独立实现的 WebSocket 夹具, 检查与生产实现之间的互操作
+
+func expectMaskedText(want string) httptest.WSStep {
+	return func(c *httptest.WSConn) error {
+		f, err := c.ReadFrame()
+		if err != nil {
+			return err
+		}
+		if !f.Masked || f.Opcode != httptest.WSText || string(f.Payload) != want {
+			return fmt.Errorf("got %v masked=%v, want masked text %q", f, f.Masked, want)
+		}
+		return nil
+	}
+}
+
+func expectCloseCode(code int) httptest.WSStep {
+	return func(c *httptest.WSConn) error {
+		c.NetConn().SetReadDeadline(time.Now().Add(2 * time.Second))
+		f, err := c.ReadFrame()
+		if err != nil {
+			return err
+		}
+		if got, _ := f.CloseCode(); f.Opcode != httptest.WSClose || got != code {
+			return fmt.Errorf("got %v, want close %d", f, code)
+		}
+		return nil
+	}
+}
+
+// scriptDone 脚本的最后一步, 关闭 done 以便测试等待夹具一侧完成后再结束
+func scriptDone(done chan struct{}) httptest.WSStep {
+	return func(*httptest.WSConn) error {
+		close(done)
+		return nil
+	}
+}
+
+func waitScript(t *testing.T, done chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fixture script did not finish")
+	}
+}
+
+func TestDialerAgainstFixture(t *testing.T) {
+	done := make(chan struct{})
+	fixture, err := httptest.NewWSServer(t, httptest.WSScript(
+		expectMaskedText("hello"),
+		httptest.WSSend(&httptest.WSFrame{Opcode: httptest.WSText, Payload: []byte("frag")}),
+		httptest.WSSend(&httptest.WSFrame{Opcode: httptest.WSPing, Fin: true, Payload: []byte("p")}),
+		httptest.WSSend(&httptest.WSFrame{Opcode: httptest.WSContinuation, Fin: true, Payload: []byte("mented")}),
+		httptest.WSExpect(httptest.WSPong, []byte("p")),
+		httptest.WSSendClose(httptest.WSCloseGoingAway, "bye"),
+		expectCloseCode(httptest.WSCloseGoingAway),
+		scriptDone(done),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture.Protocols = []string{"v1"}
+
+	d := &Dialer{Subprotocols: []string{"v2", "v1"}}
+	c, _, err := d.Dial(context.Background(), fixture.URL+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Subprotocol() != "v1" {
+		t.Fatalf("subprotocol = %q", c.Subprotocol())
+	}
+	if err := c.WriteMessage(OpText, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	// 分片中间的 ping 被自动回应
+	if op, data, err := c.ReadMessage(); err != nil || op != OpText || string(data) != "fragmented" {
+		t.Fatalf("ReadMessage = %v %q %v", op, data, err)
+	}
+	var ce *CloseError
+	if _, _, err := c.ReadMessage(); !errors.As(err, &ce) || ce.Code != CloseGoingAway || ce.Text != "bye" {
+		t.Fatalf("ReadMessage after close = %v", err)
+	}
+	waitScript(t, done)
+}
+
+func TestDialerRejectsMaskedServerFrame(t *testing.T) {
+	done := make(chan struct{})
+	fixture, err := httptest.NewWSServer(t, httptest.WSScript(
+		func(c *httptest.WSConn) error {
+			return c.WriteRawFrame(&httptest.WSFrame{Fin: true, Opcode: httptest.WSText, Payload: []byte("x")}, true)
+		},
+		expectCloseCode(httptest.WSCloseProtocolError),
+		scriptDone(done),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _, err := Dial(context.Background(), fixture.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := c.ReadMessage(); err == nil {
+		t.Fatal("masked server frame accepted")
+	}
+	waitScript(t, done)
+}
+
+func TestUpgraderAgainstFixtureClient(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &Upgrader{Subprotocols: []string{"chat"}}
+	srv := &server.Server{Handler: server.HandlerFunc(func(w server.ResponseWriter, req *message.Request) {
+		c, err := u.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			op, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := c.WriteMessage(op, data); err != nil {
+				return
+			}
+		}
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	c, resp, err := httptest.DialWS("ws://"+ln.Addr().String()+"/", nil, "chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp.StatusCode != 101 || c.Protocol != "chat" {
+		t.Fatalf("handshake = %d %q", resp.StatusCode, c.Protocol)
+	}
+	c.WriteFragmented(httptest.WSBinary, []byte("0123456789"), 4)
+	c.ExpectBinary(t, []byte("0123456789"))
+	c.Ping([]byte("ping"))
+	c.ExpectFrame(t, httptest.WSPong, []byte("ping"))
+
+	// 客户端帧必须加掩码 (RFC 6455 5.1)
+	c.WriteRawFrame(&httptest.WSFrame{Fin: true, Opcode: httptest.WSText, Payload: []byte("plain")}, false)
+	c.ExpectClose(t, httptest.WSCloseProtocolError)
+	c.ExpectEOF(t)
+}
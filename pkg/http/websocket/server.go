@@ -0,0 +1,136 @@
+package websocket
+
+/*
+	服务端握手 (RFC 6455 4.2): 校验升级请求, 协商子协议和 permessage-deflate, 通过 Hijacker 接管连接后回复 101
+*/
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/server"
+)
+
+// acceptGUID 计算 Sec-WebSocket-Accept 时拼接在 key 之后的固定串
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// handshakeHeaders 由握手本身设置, 调用方附加的头部中的同名键被忽略
+var handshakeHeaders = map[string]bool{
+	"Upgrade":                  true,
+	"Connection":               true,
+	"Sec-Websocket-Accept":     true,
+	"Sec-Websocket-Protocol":   true,
+	"Sec-Websocket-Extensions": true,
+}
+
+// Upgrader 将 HTTP/1.1 请求升级为 WebSocket 连接
+type Upgrader struct {
+	// Subprotocols 支持的子协议, 按服务端的优先顺序选择客户端请求中出现的第一个
+	Subprotocols []string
+	// CheckOrigin 返回 false 时以 403 拒绝握手; 为空时只接受没有 Origin 或 Origin 的主机与 Host 相同的请求
+	CheckOrigin func(req *message.Request) bool
+	// EnableCompression 为 true 时接受客户端提议的 permessage-deflate
+	EnableCompression bool
+	// Options 握手后连接的读写参数
+	Options
+}
+
+// IsUpgradeRequest 报告 req 是否请求升级为 WebSocket
+func IsUpgradeRequest(req *message.Request) bool {
+	return req.Header.HasToken("Connection", "upgrade") && req.Header.HasToken("Upgrade", "websocket")
+}
+
+// Upgrade 完成握手并接管连接, header 为附加到 101 响应的头部 (如 Set-Cookie).
+// 握手失败时已向客户端写出错误响应, 返回的错误包装 ErrBadHandshake
+func (u *Upgrader) Upgrade(w server.ResponseWriter, req *message.Request, header common.Header) (*Conn, error) {
+	if req.Method != common.MethodGet {
+		w.Header().Set("Allow", common.MethodGet)
+		return nil, reject(w, common.StatusMethodNotAllowed, "request method is not GET")
+	}
+	if !IsUpgradeRequest(req) {
+		return nil, reject(w, common.StatusBadRequest, "missing Connection: Upgrade or Upgrade: websocket")
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, reject(w, common.StatusUpgradeRequired, "unsupported Sec-WebSocket-Version")
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+		return nil, reject(w, common.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		return nil, reject(w, common.StatusForbidden, "origin not allowed")
+	}
+	hj, ok := w.(server.Hijacker)
+	if !ok {
+		return nil, reject(w, common.StatusInternalServerError, "response does not support hijacking")
+	}
+	subprotocol := u.selectSubprotocol(req)
+	compress := u.EnableCompression && negotiateDeflate(req.Header)
+
+	nc, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	bw := brw.Writer
+	bw.WriteString("HTTP/1.1 " + message.StatusLine(common.StatusSwitchingProtocols) + "\r\n")
+	bw.WriteString("Upgrade: websocket\r\nConnection: Upgrade\r\n")
+	bw.WriteString("Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n")
+	if subprotocol != "" {
+		bw.WriteString("Sec-WebSocket-Protocol: " + subprotocol + "\r\n")
+	}
+	if compress {
+		bw.WriteString("Sec-WebSocket-Extensions: " + deflateOffer + "\r\n")
+	}
+	header.WriteSubset(bw, handshakeHeaders)
+	bw.WriteString("\r\n")
+	if err := bw.Flush(); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	// 读缓冲中可能已有客户端紧随握手发来的帧
+	return newConn(nc, brw.Reader, true, u.Options, subprotocol, compress, nc.Close), nil
+}
+
+// selectSubprotocol 按服务端顺序选择客户端请求的子协议
+func (u *Upgrader) selectSubprotocol(req *message.Request) string {
+	for _, p := range u.Subprotocols {
+		if req.Header.HasToken("Sec-WebSocket-Protocol", p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// reject 写出握手失败的响应
+func reject(w server.ResponseWriter, code int, reason string) error {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write([]byte(reason + "\n"))
+	return fmt.Errorf("%w: %s", ErrBadHandshake, reason)
+}
+
+// sameOrigin 默认的来源检查: 浏览器之外的客户端通常不发送 Origin
+func sameOrigin(req *message.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.HostHeader())
+}
+
+// acceptKey 计算 Sec-WebSocket-Accept
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
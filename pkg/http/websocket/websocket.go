@@ -0,0 +1,836 @@
+package websocket
+
+/*
+	WebSocket 连接 (RFC 6455): 消息的分片读写、控制帧处理、关闭握手和 ping/pong 保活.
+	一个连接同时支持一个读者和任意多个写者
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// ErrBadHandshake 握手请求或响应不符合 RFC 6455 4 节
+	ErrBadHandshake = errors.New("websocket: bad handshake")
+	// ErrProtocol 对端发送了不合法的帧, 连接以 CloseProtocolError 关闭
+	ErrProtocol = errors.New("websocket: protocol error")
+	// ErrReadLimit 消息 (解压后) 超过读取上限, 连接以 CloseMessageTooBig 关闭
+	ErrReadLimit = errors.New("websocket: message exceeds read limit")
+	// ErrInvalidUTF8 文本消息不是合法的 UTF-8, 连接以 CloseInvalidPayload 关闭
+	ErrInvalidUTF8 = errors.New("websocket: invalid UTF-8 in text message")
+	// ErrCloseSent 关闭帧已发出, 不能再发送其他帧
+	ErrCloseSent = errors.New("websocket: close frame already sent")
+
+	errStaleReader  = errors.New("websocket: read on superseded message reader")
+	errWriterClosed = errors.New("websocket: write on closed message writer")
+)
+
+// 关闭状态码 (RFC 6455 7.4.1)
+const (
+	CloseNormal             = 1000
+	CloseGoingAway          = 1001
+	CloseProtocolError      = 1002
+	CloseUnsupportedData    = 1003
+	CloseNoStatus           = 1005
+	CloseAbnormal           = 1006
+	CloseInvalidPayload     = 1007
+	ClosePolicyViolation    = 1008
+	CloseMessageTooBig      = 1009
+	CloseMandatoryExtension = 1010
+	CloseInternalError      = 1011
+	CloseServiceRestart     = 1012
+	CloseTryAgainLater      = 1013
+)
+
+// maxCloseReason 关闭帧中原因文本的上限: 控制帧载荷减去 2 字节状态码
+const maxCloseReason = maxControlPayload - 2
+
+// CloseError 对端发来的关闭帧, Code 为 CloseNoStatus 时关闭帧没有载荷
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	s := "websocket: close " + strconv.Itoa(e.Code)
+	if e.Text != "" {
+		s += ": " + e.Text
+	}
+	return s
+}
+
+// validCloseCode 判断关闭帧中的状态码能否出现在线路上 (RFC 6455 7.4)
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// Options 连接建立后的读写参数, 零值字段使用默认值
+type Options struct {
+	// ReadLimit 单条消息 (解压后) 的最大字节数, 0 表示 32MB, 负数表示不限制
+	ReadLimit int64
+	// WriteFragmentSize 写消息时每个分片的最大载荷, 0 表示 64KB
+	WriteFragmentSize int
+	// CompressionLevel 协商到 permessage-deflate 时的 flate 压缩级别, 0 或不合法的值表示 flate.BestSpeed
+	CompressionLevel int
+	// PingInterval 非零时按该间隔发送 ping, 两个间隔内没有收到任何帧时关闭连接;
+	// pong 只在读取时被处理, 因此需要有协程持续读取
+	PingInterval time.Duration
+	// CloseTimeout Close 等待对端回应关闭帧的最长时间, 0 表示 5s
+	CloseTimeout time.Duration
+}
+
+const (
+	defaultReadLimit         = 32 << 20
+	defaultWriteFragmentSize = 64 << 10
+	defaultCloseTimeout      = 5 * time.Second
+	// smallFrame 服务端不超过该大小的帧与帧头合并为一次写
+	smallFrame = 2 << 10
+)
+
+// withDefaults 返回补全默认值后的副本
+func (o Options) withDefaults() Options {
+	if o.ReadLimit == 0 {
+		o.ReadLimit = defaultReadLimit
+	}
+	if o.WriteFragmentSize <= 0 {
+		o.WriteFragmentSize = defaultWriteFragmentSize
+	}
+	if o.CompressionLevel == 0 || o.CompressionLevel < flate.HuffmanOnly || o.CompressionLevel > flate.BestCompression {
+		o.CompressionLevel = flate.BestSpeed
+	}
+	if o.CloseTimeout <= 0 {
+		o.CloseTimeout = defaultCloseTimeout
+	}
+	return o
+}
+
+// Conn 一条 WebSocket 连接
+type Conn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	server      bool
+	opts        Options
+	subprotocol string
+	// compress 协商到了 permessage-deflate
+	compress      bool
+	writeCompress atomic.Bool
+	// release 关闭底层连接, 客户端连接同时归还连接池的计数
+	release   func() error
+	closeOnce sync.Once
+	done      chan struct{}
+	// closeRecv 收到对端的关闭帧时关闭
+	closeRecv chan struct{}
+	lastRecv  atomic.Int64
+
+	// msgMu 一次只有一条数据消息在写, 控制帧可以插在分片之间
+	msgMu     sync.Mutex
+	wmu       sync.Mutex
+	wbuf      []byte
+	closeSent bool
+	writeErr  error
+
+	// 以下字段由 rmu 保护
+	rmu     sync.Mutex
+	readErr error
+	// msgSeq 当前消息的序号, 旧消息的读取器据此失效
+	msgSeq  uint64
+	hdr     frameHeader
+	remain  int64
+	maskPos int
+	ctrlBuf [maxControlPayload]byte
+
+	pingHandler func(data []byte) error
+	pongHandler func(data []byte) error
+}
+
+// newConn 在完成握手的连接上创建 Conn, br 为握手时使用的读缓冲, 其中可能已有对端发来的帧
+func newConn(nc net.Conn, br *bufio.Reader, server bool, opts Options, subprotocol string, compress bool, release func() error) *Conn {
+	c := &Conn{
+		conn:        nc,
+		br:          br,
+		server:      server,
+		opts:        opts.withDefaults(),
+		subprotocol: subprotocol,
+		compress:    compress,
+		release:     release,
+		done:        make(chan struct{}),
+		closeRecv:   make(chan struct{}),
+	}
+	c.writeCompress.Store(compress)
+	c.lastRecv.Store(time.Now().UnixNano())
+	c.SetPingHandler(nil)
+	c.SetPongHandler(nil)
+	if c.opts.PingInterval > 0 {
+		go c.keepAlive(c.opts.PingInterval)
+	}
+	return c
+}
+
+// Subprotocol 返回握手协商的子协议, 没有时为空
+func (c *Conn) Subprotocol() string { return c.subprotocol }
+
+// Compressed 报告是否协商到了 permessage-deflate
+func (c *Conn) Compressed() bool { return c.compress }
+
+// SetWriteCompression 在协商到 permessage-deflate 时开关之后消息的压缩, 如对已压缩的数据关闭压缩
+func (c *Conn) SetWriteCompression(enabled bool) { c.writeCompress.Store(enabled && c.compress) }
+
+// LocalAddr 返回本端地址
+func (c *Conn) LocalAddr() net.Addr { return c.conn.LocalAddr() }
+
+// RemoteAddr 返回对端地址
+func (c *Conn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// SetReadDeadline 设置底层连接的读超时
+func (c *Conn) SetReadDeadline(t time.Time) error { return c.conn.SetReadDeadline(t) }
+
+// SetWriteDeadline 设置底层连接的写超时, 超时后连接不能再写
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+
+// SetReadLimit 修改单条消息的读取上限, 负数表示不限制
+func (c *Conn) SetReadLimit(n int64) {
+	c.rmu.Lock()
+	c.opts.ReadLimit = n
+	c.rmu.Unlock()
+}
+
+// SetPingHandler 设置收到 ping 时的处理函数, 为空时回复同样载荷的 pong.
+// 处理函数在读取的协程中调用, data 只在调用期间有效; 返回错误时读取以该错误结束. 应在开始读取前设置
+func (c *Conn) SetPingHandler(h func(data []byte) error) {
+	if h == nil {
+		h = func(data []byte) error {
+			if err := c.WriteControl(OpPong, data); err != nil && !errors.Is(err, ErrCloseSent) {
+				return err
+			}
+			return nil
+		}
+	}
+	c.pingHandler = h
+}
+
+// SetPongHandler 设置收到 pong 时的处理函数, 为空时忽略 pong; 约定同 SetPingHandler
+func (c *Conn) SetPongHandler(h func(data []byte) error) {
+	if h == nil {
+		h = func([]byte) error { return nil }
+	}
+	c.pongHandler = h
+}
+
+// ReadMessage 读取下一条完整的数据消息
+func (c *Conn) ReadMessage() (Opcode, []byte, error) {
+	op, r, err := c.NextReader()
+	if err != nil {
+		return 0, nil, err
+	}
+	data, err := io.ReadAll(r)
+	return op, data, err
+}
+
+// NextReader 返回下一条数据消息的类型和读取器, 分片被透明地拼接, 期间到达的控制帧被自动处理.
+// 读取器在下一次调用 NextReader 后失效, 未读完的部分被丢弃. 对端关闭连接时返回 *CloseError
+func (c *Conn) NextReader() (Opcode, io.Reader, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if err := c.discardMessage(); err != nil {
+		return 0, nil, err
+	}
+	h, err := c.nextFrame()
+	if err != nil {
+		return 0, nil, err
+	}
+	if h.op == OpContinuation {
+		return 0, nil, c.fail(CloseProtocolError, fmt.Errorf("%w: continuation frame without a message", ErrProtocol))
+	}
+	c.msgSeq++
+	c.setFrame(h)
+
+	mr := &messageReader{c: c, text: h.op == OpText}
+	var src io.Reader = &payloadReader{c: c, seq: c.msgSeq}
+	if h.rsv&rsv1Bit != 0 {
+		mr.fr = getFlateReader(io.MultiReader(src, bytes.NewReader(deflateTail)))
+		src = mr.fr
+	}
+	mr.r = src
+	return h.op, mr, nil
+}
+
+// discardMessage 丢弃当前消息未读的部分, 由调用方持有 c.rmu
+func (c *Conn) discardMessage() error {
+	if c.msgSeq == 0 || c.readErr != nil {
+		return c.readErr
+	}
+	for {
+		if c.remain > 0 {
+			n, err := c.br.Discard(int(c.remain))
+			c.remain -= int64(n)
+			if err != nil {
+				return c.readFailed(err)
+			}
+		}
+		if err := c.nextFragment(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// nextFragment 当前帧读完后读取消息的下一个延续帧, 消息已结束时返回 io.EOF
+func (c *Conn) nextFragment() error {
+	if c.hdr.fin {
+		return io.EOF
+	}
+	h, err := c.nextFrame()
+	if err != nil {
+		return err
+	}
+	if h.op != OpContinuation {
+		return c.fail(CloseProtocolError, fmt.Errorf("%w: %v frame inside a fragmented message", ErrProtocol, h.op))
+	}
+	c.setFrame(h)
+	return nil
+}
+
+// setFrame 开始读取数据帧 h 的载荷
+func (c *Conn) setFrame(h frameHeader) {
+	c.hdr = h
+	c.remain = h.length
+	c.maskPos = 0
+}
+
+// nextFrame 读取下一个数据帧的帧头, 途中的控制帧就地处理; 由调用方持有 c.rmu
+func (c *Conn) nextFrame() (frameHeader, error) {
+	for {
+		if c.readErr != nil {
+			return frameHeader{}, c.readErr
+		}
+		h, err := readFrameHeader(c.br)
+		if err != nil {
+			if errors.Is(err, ErrProtocol) {
+				return h, c.fail(CloseProtocolError, err)
+			}
+			return h, c.readFailed(err)
+		}
+		c.lastRecv.Store(time.Now().UnixNano())
+		if err := c.checkFrame(h); err != nil {
+			return h, c.fail(CloseProtocolError, err)
+		}
+		if !h.op.IsControl() {
+			return h, nil
+		}
+		if err := c.handleControl(h); err != nil {
+			return h, err
+		}
+	}
+}
+
+// checkFrame 检查帧头是否符合 RFC 6455 5 节和协商的扩展
+func (c *Conn) checkFrame(h frameHeader) error {
+	switch h.op {
+	case OpContinuation, OpText, OpBinary, OpClose, OpPing, OpPong:
+	default:
+		return fmt.Errorf("%w: unknown opcode %d", ErrProtocol, h.op)
+	}
+	if h.rsv&(rsv2Bit|rsv3Bit) != 0 {
+		return fmt.Errorf("%w: reserved bits set", ErrProtocol)
+	}
+	if h.rsv&rsv1Bit != 0 && (!c.compress || (h.op != OpText && h.op != OpBinary)) {
+		return fmt.Errorf("%w: unexpected RSV1 on %v frame", ErrProtocol, h.op)
+	}
+	if c.server && !h.masked {
+		return fmt.Errorf("%w: unmasked client frame", ErrProtocol)
+	}
+	if !c.server && h.masked {
+		return fmt.Errorf("%w: masked server frame", ErrProtocol)
+	}
+	if h.op.IsControl() {
+		if !h.fin {
+			return fmt.Errorf("%w: fragmented %v frame", ErrProtocol, h.op)
+		}
+		if h.length > maxControlPayload {
+			return fmt.Errorf("%w: %v frame payload too large", ErrProtocol, h.op)
+		}
+	}
+	return nil
+}
+
+// handleControl 读出控制帧的载荷并处理
+func (c *Conn) handleControl(h frameHeader) error {
+	p, err := readFull(c.br, int(h.length))
+	if err != nil {
+		return c.readFailed(err)
+	}
+	data := c.ctrlBuf[:copy(c.ctrlBuf[:], p)]
+	if h.masked {
+		maskBytes(h.key, 0, data)
+	}
+	switch h.op {
+	case OpPing:
+		err = c.pingHandler(data)
+	case OpPong:
+		err = c.pongHandler(data)
+	case OpClose:
+		return c.handleClose(data)
+	}
+	if err != nil {
+		return c.readFailed(err)
+	}
+	return nil
+}
+
+// handleClose 处理对端的关闭帧: 回应关闭帧 (已发出过时跳过) 并关闭底层连接
+func (c *Conn) handleClose(data []byte) error {
+	cerr := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(data) == 1:
+		return c.fail(CloseProtocolError, fmt.Errorf("%w: truncated close payload", ErrProtocol))
+	case len(data) >= 2:
+		cerr.Code = int(binary.BigEndian.Uint16(data))
+		if !validCloseCode(cerr.Code) {
+			return c.fail(CloseProtocolError, fmt.Errorf("%w: invalid close code %d", ErrProtocol, cerr.Code))
+		}
+		if !utf8.Valid(data[2:]) {
+			return c.fail(CloseInvalidPayload, fmt.Errorf("%w: close reason", ErrInvalidUTF8))
+		}
+		cerr.Text = string(data[2:])
+	}
+	c.readErr = cerr
+	close(c.closeRecv)
+	reply := cerr.Code
+	if reply == CloseNoStatus {
+		reply = CloseNormal
+	}
+	c.WriteClose(reply, "")
+	c.closeConn()
+	return cerr
+}
+
+// fail 因对端违反协议结束读取: 以 code 发出关闭帧后关闭底层连接, 由调用方持有 c.rmu
+func (c *Conn) fail(code int, err error) error {
+	if c.readErr == nil {
+		c.readErr = err
+	}
+	c.WriteClose(code, "")
+	c.closeConn()
+	return c.readErr
+}
+
+// readFailed 读取出错, 连接不再可读; 由调用方持有 c.rmu
+func (c *Conn) readFailed(err error) error {
+	if c.readErr == nil {
+		c.readErr = err
+	}
+	c.closeConn()
+	return c.readErr
+}
+
+// readPayload 读取当前消息的载荷, 当前帧读完时继续读取后续的延续帧; 由调用方持有 c.rmu
+func (c *Conn) readPayload(p []byte) (int, error) {
+	for c.remain == 0 {
+		if err := c.nextFragment(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > c.remain {
+		p = p[:c.remain]
+	}
+	n, err := c.br.Read(p)
+	if c.hdr.masked {
+		c.maskPos = maskBytes(c.hdr.key, c.maskPos, p[:n])
+	}
+	c.remain -= int64(n)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return n, c.readFailed(err)
+	}
+	return n, nil
+}
+
+// payloadReader 读出一条消息各帧的载荷 (已去掩码, 未解压)
+type payloadReader struct {
+	c   *Conn
+	seq uint64
+}
+
+func (r *payloadReader) Read(p []byte) (int, error) {
+	c := r.c
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if r.seq != c.msgSeq {
+		return 0, errStaleReader
+	}
+	if c.readErr != nil {
+		return 0, c.readErr
+	}
+	return c.readPayload(p)
+}
+
+// messageReader NextReader 返回的读取器: 解压载荷, 检查读取上限和文本消息的 UTF-8 编码
+type messageReader struct {
+	c    *Conn
+	r    io.Reader
+	fr   io.ReadCloser
+	n    int64
+	text bool
+	utf8 utf8Checker
+	err  error
+}
+
+func (m *messageReader) Read(p []byte) (int, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	n, err := m.r.Read(p)
+	m.n += int64(n)
+	if limit := m.c.readLimit(); limit > 0 && m.n > limit {
+		return 0, m.abort(CloseMessageTooBig, ErrReadLimit)
+	}
+	if m.text && !m.utf8.write(p[:n]) {
+		return 0, m.abort(CloseInvalidPayload, ErrInvalidUTF8)
+	}
+	if err == io.EOF {
+		if m.text && !m.utf8.done() {
+			return 0, m.abort(CloseInvalidPayload, ErrInvalidUTF8)
+		}
+		m.finish(io.EOF)
+	} else if err != nil {
+		m.finish(err)
+	}
+	return n, err
+}
+
+// abort 以 code 关闭连接并结束读取
+func (m *messageReader) abort(code int, err error) error {
+	m.c.rmu.Lock()
+	err = m.c.fail(code, err)
+	m.c.rmu.Unlock()
+	m.finish(err)
+	return err
+}
+
+// finish 记录消息读取的结果并归还解压器
+func (m *messageReader) finish(err error) {
+	m.err = err
+	if m.fr != nil {
+		putFlateReader(m.fr)
+		m.fr = nil
+	}
+}
+
+func (c *Conn) readLimit() int64 {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	return c.opts.ReadLimit
+}
+
+// utf8Checker 增量校验分段到达的文本, 跨越分段边界的不完整字符暂存在 buf 中
+type utf8Checker struct {
+	buf [utf8.UTFMax]byte
+	n   int
+}
+
+// write 校验 p, 出现非法编码时返回 false
+func (u *utf8Checker) write(p []byte) bool {
+	for u.n > 0 && len(p) > 0 && !utf8.FullRune(u.buf[:u.n]) {
+		u.buf[u.n] = p[0]
+		u.n++
+		p = p[1:]
+	}
+	if u.n > 0 {
+		if !utf8.FullRune(u.buf[:u.n]) {
+			return true
+		}
+		if !utf8.Valid(u.buf[:u.n]) {
+			return false
+		}
+		u.n = 0
+	}
+	cut := len(p)
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	if !utf8.Valid(p[:cut]) {
+		return false
+	}
+	u.n = copy(u.buf[:], p[cut:])
+	return true
+}
+
+// done 报告文本是否在完整的字符处结束
+func (u *utf8Checker) done() bool { return u.n == 0 }
+
+// writeFrame 写出一帧, 客户端的帧使用随机掩码; 关闭帧发出后不再允许写出其他帧
+func (c *Conn) writeFrame(fin bool, rsv byte, op Opcode, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	var key [4]byte
+	if !c.server {
+		rand.Read(key[:])
+	}
+	b := appendFrameHeader(c.wbuf[:0], fin, rsv, op, len(payload), !c.server, key)
+	var err error
+	if c.server && len(payload) > smallFrame {
+		bufs := net.Buffers{b, payload}
+		_, err = bufs.WriteTo(c.conn)
+	} else {
+		hl := len(b)
+		b = append(b, payload...)
+		if !c.server {
+			maskBytes(key, 0, b[hl:])
+		}
+		_, err = c.conn.Write(b)
+	}
+	if cap(b) <= c.opts.WriteFragmentSize+maxFrameHeaderLen {
+		c.wbuf = b[:0]
+	}
+	if op == OpClose {
+		c.closeSent = true
+	}
+	if err != nil {
+		// 帧可能只写出了一部分, 连接不能再用
+		c.writeErr = err
+	}
+	return err
+}
+
+// WriteControl 发送 ping 或 pong, 载荷不超过 125 字节; 可以与数据消息的分片交错
+func (c *Conn) WriteControl(op Opcode, data []byte) error {
+	if op != OpPing && op != OpPong {
+		return fmt.Errorf("websocket: WriteControl with %v frame", op)
+	}
+	if len(data) > maxControlPayload {
+		return fmt.Errorf("websocket: %v payload exceeds %d bytes", op, maxControlPayload)
+	}
+	return c.writeFrame(true, 0, op, data)
+}
+
+// WriteClose 发出关闭帧开始关闭握手, 之后不能再发送数据; 对端的回应由读取方收到, 届时连接被关闭.
+// code 为 CloseNoStatus 时关闭帧不带载荷
+func (c *Conn) WriteClose(code int, reason string) error {
+	if len(reason) > maxCloseReason {
+		return fmt.Errorf("websocket: close reason exceeds %d bytes", maxCloseReason)
+	}
+	var payload []byte
+	if code != CloseNoStatus {
+		payload = binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(reason)), uint16(code))
+		payload = append(payload, reason...)
+	}
+	return c.writeFrame(true, 0, OpClose, payload)
+}
+
+// Close 执行关闭握手: 发出 CloseNormal 关闭帧, 等待对端回应 (最多 CloseTimeout) 后关闭底层连接.
+// 没有其他协程在读时, Close 自己读取并丢弃对端在回应前发来的数据
+func (c *Conn) Close() error {
+	if err := c.WriteClose(CloseNormal, ""); err != nil && !errors.Is(err, ErrCloseSent) {
+		return c.closeConn()
+	}
+	deadline := time.Now().Add(c.opts.CloseTimeout)
+	if c.rmu.TryLock() {
+		c.conn.SetReadDeadline(deadline)
+		for c.discardMessage() == nil {
+			h, err := c.nextFrame()
+			if err != nil {
+				break
+			}
+			c.msgSeq++
+			c.setFrame(h)
+		}
+		c.rmu.Unlock()
+	} else {
+		t := time.NewTimer(time.Until(deadline))
+		select {
+		case <-c.closeRecv:
+		case <-c.done:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+	return c.closeConn()
+}
+
+// closeConn 关闭底层连接, 只有第一次调用生效
+func (c *Conn) closeConn() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.release()
+	})
+	return err
+}
+
+// keepAlive 定期发送 ping, 两个间隔内没有收到任何帧时关闭连接
+func (c *Conn) keepAlive(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-t.C:
+			if now.Sub(time.Unix(0, c.lastRecv.Load())) > 2*interval {
+				c.closeConn()
+				return
+			}
+			if err := c.WriteControl(OpPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// WriteMessage 发送一条数据消息, 超过 WriteFragmentSize 时分片发送
+func (c *Conn) WriteMessage(op Opcode, data []byte) error {
+	if (op == OpText || op == OpBinary) && !c.writeCompress.Load() && len(data) <= c.opts.WriteFragmentSize {
+		c.msgMu.Lock()
+		defer c.msgMu.Unlock()
+		return c.writeFrame(true, 0, op, data)
+	}
+	w, err := c.NextWriter(op)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// NextWriter 开始写一条数据消息: 写入的数据每积累 WriteFragmentSize 字节发出一个分片, Close 发出最后一帧.
+// 同一时间只有一条消息在写, 其他写者等待到该消息的 Close; 控制帧可以插在分片之间
+func (c *Conn) NextWriter(op Opcode) (io.WriteCloser, error) {
+	if op != OpText && op != OpBinary {
+		return nil, fmt.Errorf("websocket: invalid message type %v", op)
+	}
+	c.msgMu.Lock()
+	w := &messageWriter{c: c, op: op}
+	if c.writeCompress.Load() {
+		w.rsv = rsv1Bit
+		w.fw = getFlateWriter(deflateSink{w}, c.opts.CompressionLevel)
+	}
+	return w, nil
+}
+
+// messageWriter NextWriter 返回的写入器
+type messageWriter struct {
+	c   *Conn
+	op  Opcode
+	rsv byte
+	buf []byte
+	fw  *flate.Writer
+	// sent 已发出过分片, 之后的帧为延续帧
+	sent   bool
+	closed bool
+	err    error
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errWriterClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.fw != nil {
+		if _, err := w.fw.Write(p); err != nil {
+			return 0, err
+		}
+	} else {
+		w.appendPayload(p, 0)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	return len(p), nil
+}
+
+// appendPayload 追加待发的载荷, 缓冲满一个分片时发出; 末尾 hold 字节始终留在缓冲中
+func (w *messageWriter) appendPayload(p []byte, hold int) {
+	size := w.c.opts.WriteFragmentSize
+	for w.err == nil {
+		if len(w.buf) == 0 && len(p) > size+hold {
+			// 缓冲为空时直接从 p 发出整片, 避免复制
+			w.flushFrame(p[:size], false)
+			p = p[size:]
+			continue
+		}
+		n := min(len(p), size+hold-len(w.buf))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		if len(p) == 0 {
+			return
+		}
+		w.flushFrame(w.buf[:size], false)
+		w.buf = append(w.buf[:0], w.buf[size:]...)
+	}
+}
+
+// flushFrame 发出一个分片, 第一片使用消息类型和 RSV1, 之后为延续帧
+func (w *messageWriter) flushFrame(payload []byte, fin bool) {
+	op, rsv := w.op, w.rsv
+	if w.sent {
+		op, rsv = OpContinuation, 0
+	}
+	w.err = w.c.writeFrame(fin, rsv, op, payload)
+	w.sent = true
+}
+
+// Close 发出消息的最后一帧, 释放写消息的权利
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return errWriterClosed
+	}
+	w.closed = true
+	defer w.c.msgMu.Unlock()
+	if w.fw != nil {
+		// sync flush 的输出以 00 00 ff ff 结尾, 按 RFC 7692 7.2.1 去掉
+		err := w.fw.Flush()
+		putFlateWriter(w.fw, w.c.opts.CompressionLevel)
+		w.fw = nil
+		if w.err == nil && err != nil {
+			w.err = err
+		}
+		if w.err == nil && !bytes.HasSuffix(w.buf, deflateSyncTail) {
+			w.err = errors.New("websocket: deflate output without sync flush tail")
+		}
+		if w.err == nil {
+			w.buf = w.buf[:len(w.buf)-len(deflateSyncTail)]
+		}
+	}
+	if w.err == nil {
+		w.flushFrame(w.buf, true)
+	}
+	return w.err
+}
@@ -0,0 +1,227 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// connPair 在内存管道上建立一对已握手的连接
+func connPair(t *testing.T, opts Options) (srv, cli *Conn) {
+	t.Helper()
+	a, b := net.Pipe()
+	srv = newConn(a, bufio.NewReader(a), true, opts, "", false, a.Close)
+	cli = newConn(b, bufio.NewReader(b), false, opts, "", false, b.Close)
+	t.Cleanup(func() {
+		srv.closeConn()
+		cli.closeConn()
+	})
+	return srv, cli
+}
+
+// rawPeer 以原始帧与服务端 Conn 对话, 用于发送不合法的帧
+type rawPeer struct {
+	out    chan []byte
+	frames chan rawFrame
+}
+
+type rawFrame struct {
+	h       frameHeader
+	payload []byte
+}
+
+func newRawPeer(t *testing.T) (*Conn, *rawPeer) {
+	t.Helper()
+	a, b := net.Pipe()
+	srv := newConn(a, bufio.NewReader(a), true, Options{CloseTimeout: time.Second}, "", false, a.Close)
+	p := &rawPeer{out: make(chan []byte, 16), frames: make(chan rawFrame, 16)}
+	// 管道的写会阻塞到对端读取, 由单独的协程按顺序写出
+	go func() {
+		for buf := range p.out {
+			if _, err := b.Write(buf); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer close(p.frames)
+		br := bufio.NewReader(b)
+		for {
+			h, err := readFrameHeader(br)
+			if err != nil {
+				return
+			}
+			payload := make([]byte, h.length)
+			if _, err := io.ReadFull(br, payload); err != nil {
+				return
+			}
+			p.frames <- rawFrame{h, payload}
+		}
+	}()
+	t.Cleanup(func() {
+		close(p.out)
+		srv.closeConn()
+		b.Close()
+	})
+	return srv, p
+}
+
+func (p *rawPeer) send(fin bool, op Opcode, payload []byte, masked bool) {
+	key := [4]byte{1, 2, 3, 4}
+	buf := appendFrameHeader(nil, fin, 0, op, len(payload), masked, key)
+	data := append([]byte(nil), payload...)
+	if masked {
+		maskBytes(key, 0, data)
+	}
+	p.out <- append(buf, data...)
+}
+
+// closeCode 等待服务端发出的关闭帧并返回状态码
+func (p *rawPeer) closeCode(t *testing.T) int {
+	t.Helper()
+	for f := range p.frames {
+		if f.h.op == OpClose {
+			if len(f.payload) < 2 {
+				return CloseNoStatus
+			}
+			return int(binary.BigEndian.Uint16(f.payload))
+		}
+	}
+	t.Fatal("connection closed without a close frame")
+	return 0
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	srv, cli := connPair(t, Options{WriteFragmentSize: 1000})
+	text := strings.Repeat("héllo wörld ", 1000) // 跨分片边界拆开多字节字符
+	bin := bytes.Repeat([]byte{0, 1, 2, 0xff}, 50000)
+	go func() {
+		cli.WriteMessage(OpText, []byte(text))
+		cli.WriteMessage(OpBinary, bin)
+		w, _ := cli.NextWriter(OpText)
+		io.WriteString(w, "str")
+		io.WriteString(w, "eamed")
+		w.Close()
+	}()
+
+	for _, want := range []struct {
+		op   Opcode
+		data []byte
+	}{{OpText, []byte(text)}, {OpBinary, bin}, {OpText, []byte("streamed")}} {
+		op, data, err := srv.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if op != want.op || !bytes.Equal(data, want.data) {
+			t.Fatalf("ReadMessage = %v (%d bytes), want %v (%d bytes)", op, len(data), want.op, len(want.data))
+		}
+	}
+}
+
+func TestControlFrameBetweenFragments(t *testing.T) {
+	srv, p := newRawPeer(t)
+	p.send(false, OpText, []byte("frag"), true)
+	p.send(true, OpPing, []byte("hi"), true)
+	p.send(true, OpContinuation, []byte("mented"), true)
+
+	op, data, err := srv.ReadMessage()
+	if err != nil || op != OpText || string(data) != "fragmented" {
+		t.Fatalf("ReadMessage = %v %q %v, want text \"fragmented\"", op, data, err)
+	}
+	f := <-p.frames
+	if f.h.op != OpPong || string(f.payload) != "hi" || f.h.masked {
+		t.Fatalf("reply = %v %q masked=%v, want unmasked pong \"hi\"", f.h.op, f.payload, f.h.masked)
+	}
+}
+
+func TestProtocolViolations(t *testing.T) {
+	tests := []struct {
+		name string
+		send func(p *rawPeer)
+		err  error
+		code int
+	}{
+		{"unmasked", func(p *rawPeer) { p.send(true, OpText, []byte("x"), false) }, ErrProtocol, CloseProtocolError},
+		{"unknown opcode", func(p *rawPeer) { p.send(true, Opcode(3), nil, true) }, ErrProtocol, CloseProtocolError},
+		{"fragmented ping", func(p *rawPeer) { p.send(false, OpPing, nil, true) }, ErrProtocol, CloseProtocolError},
+		{"large ping", func(p *rawPeer) { p.send(true, OpPing, make([]byte, 126), true) }, ErrProtocol, CloseProtocolError},
+		{"orphan continuation", func(p *rawPeer) { p.send(true, OpContinuation, []byte("x"), true) }, ErrProtocol, CloseProtocolError},
+		{"interleaved message", func(p *rawPeer) {
+			p.send(false, OpText, []byte("a"), true)
+			p.send(true, OpBinary, []byte("b"), true)
+		}, ErrProtocol, CloseProtocolError},
+		{"invalid utf-8", func(p *rawPeer) { p.send(true, OpText, []byte{'a', 0xff}, true) }, ErrInvalidUTF8, CloseInvalidPayload},
+		{"truncated utf-8", func(p *rawPeer) { p.send(true, OpText, []byte("\xc3"), true) }, ErrInvalidUTF8, CloseInvalidPayload},
+		{"bad close code", func(p *rawPeer) { p.send(true, OpClose, []byte{0x03, 0xed}, true) }, ErrProtocol, CloseProtocolError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, p := newRawPeer(t)
+			tt.send(p)
+			_, _, err := srv.ReadMessage()
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ReadMessage error = %v, want %v", err, tt.err)
+			}
+			if code := p.closeCode(t); code != tt.code {
+				t.Fatalf("close code = %d, want %d", code, tt.code)
+			}
+		})
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	srv, p := newRawPeer(t)
+	srv.SetReadLimit(10)
+	p.send(false, OpBinary, make([]byte, 8), true)
+	p.send(true, OpContinuation, make([]byte, 8), true)
+	if _, _, err := srv.ReadMessage(); !errors.Is(err, ErrReadLimit) {
+		t.Fatalf("ReadMessage error = %v, want ErrReadLimit", err)
+	}
+	if code := p.closeCode(t); code != CloseMessageTooBig {
+		t.Fatalf("close code = %d, want %d", code, CloseMessageTooBig)
+	}
+}
+
+func TestCloseHandshake(t *testing.T) {
+	srv, cli := connPair(t, Options{})
+	read := make(chan error, 1)
+	go func() {
+		_, _, err := srv.ReadMessage()
+		read <- err
+	}()
+
+	start := time.Now()
+	if err := cli.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Close waited %v for the close reply", d)
+	}
+	var ce *CloseError
+	if err := <-read; !errors.As(err, &ce) || ce.Code != CloseNormal {
+		t.Fatalf("server ReadMessage = %v, want CloseError %d", err, CloseNormal)
+	}
+	if err := srv.WriteMessage(OpText, []byte("late")); !errors.Is(err, ErrCloseSent) {
+		t.Fatalf("WriteMessage after close = %v, want ErrCloseSent", err)
+	}
+}
+
+func TestCloseReasonFromPeer(t *testing.T) {
+	srv, p := newRawPeer(t)
+	payload := binary.BigEndian.AppendUint16(nil, CloseGoingAway)
+	p.send(true, OpClose, append(payload, "bye"...), true)
+	var ce *CloseError
+	if _, _, err := srv.ReadMessage(); !errors.As(err, &ce) || ce.Code != CloseGoingAway || ce.Text != "bye" {
+		t.Fatalf("ReadMessage = %v, want CloseError 1001 \"bye\"", err)
+	}
+	// 回应的关闭帧回显状态码
+	if code := p.closeCode(t); code != CloseGoingAway {
+		t.Fatalf("reply close code = %d, want %d", code, CloseGoingAway)
+	}
+}
@@ -1 +1,176 @@
 package log
+
+/*
+	日志格式: JSON 每行一个对象, 便于采集; 控制台格式为 "时间 级别 消息 key=value ...", 便于阅读
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Format 把一条日志追加到 buf 并返回, 结果以换行结尾
+type Format func(buf []byte, r *Record) []byte
+
+// JSONFormat 输出 {"time":...,"level":...,"msg":...,字段...}, 字段值为 error 时取 Error(), 为 Duration 时取 String()
+func JSONFormat(buf []byte, r *Record) []byte {
+	buf = append(buf, `{"time":"`...)
+	buf = r.Time.AppendFormat(buf, time.RFC3339Nano)
+	buf = append(buf, `","level":"`...)
+	buf = append(buf, r.Level.String()...)
+	buf = append(buf, `","msg":`...)
+	buf = appendJSONString(buf, r.Message)
+	for _, f := range r.Fields {
+		buf = append(buf, ',')
+		buf = appendJSONString(buf, f.Key)
+		buf = append(buf, ':')
+		buf = appendJSONValue(buf, f.Value)
+	}
+	return append(buf, "}\n"...)
+}
+
+// ConsoleFormat 输出 "2006-01-02T15:04:05.000Z07:00 INFO msg key=value", 含空白或引号的值加引号
+func ConsoleFormat(buf []byte, r *Record) []byte {
+	buf = r.Time.AppendFormat(buf, "2006-01-02T15:04:05.000Z07:00")
+	buf = append(buf, ' ')
+	lvl := r.Level.String()
+	buf = append(buf, lvl...)
+	for i := len(lvl); i < 5; i++ {
+		buf = append(buf, ' ')
+	}
+	buf = append(buf, ' ')
+	buf = append(buf, r.Message...)
+	for _, f := range r.Fields {
+		buf = append(buf, ' ')
+		buf = append(buf, f.Key...)
+		buf = append(buf, '=')
+		buf = appendConsoleValue(buf, f.Value)
+	}
+	return append(buf, '\n')
+}
+
+func appendJSONValue(buf []byte, v any) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, "null"...)
+	case string:
+		return appendJSONString(buf, v)
+	case bool:
+		return strconv.AppendBool(buf, v)
+	case int:
+		return strconv.AppendInt(buf, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(buf, v, 10)
+	case int32:
+		return strconv.AppendInt(buf, int64(v), 10)
+	case uint64:
+		return strconv.AppendUint(buf, v, 10)
+	case uint32:
+		return strconv.AppendUint(buf, uint64(v), 10)
+	case float64:
+		return appendJSONFloat(buf, v)
+	case time.Duration:
+		return appendJSONString(buf, v.String())
+	case time.Time:
+		return appendJSONString(buf, v.Format(time.RFC3339Nano))
+	case error:
+		return appendJSONString(buf, v.Error())
+	case fmt.Stringer:
+		return appendJSONString(buf, v.String())
+	case []byte:
+		return appendJSONString(buf, string(v))
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return appendJSONString(buf, fmt.Sprint(v))
+	}
+	return append(buf, b...)
+}
+
+func appendJSONFloat(buf []byte, f float64) []byte {
+	b, err := json.Marshal(f)
+	if err != nil {
+		// NaN 和 Inf 不是合法的 JSON 数字
+		return appendJSONString(buf, strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	return append(buf, b...)
+}
+
+// appendJSONString 追加带引号的 JSON 字符串, 无效的 UTF-8 替换为 U+FFFD
+func appendJSONString(buf []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	buf = append(buf, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				buf = append(buf, '\\', c)
+			case c == '\n':
+				buf = append(buf, '\\', 'n')
+			case c == '\r':
+				buf = append(buf, '\\', 'r')
+			case c == '\t':
+				buf = append(buf, '\\', 't')
+			case c < 0x20:
+				buf = append(buf, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			default:
+				buf = append(buf, c)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf = append(buf, "\ufffd"...)
+		} else {
+			buf = append(buf, s[i:i+size]...)
+		}
+		i += size
+	}
+	return append(buf, '"')
+}
+
+func appendConsoleValue(buf []byte, v any) []byte {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return append(buf, "<nil>"...)
+	case string:
+		s = v
+	case bool:
+		return strconv.AppendBool(buf, v)
+	case int64:
+		return strconv.AppendInt(buf, v, 10)
+	case int:
+		return strconv.AppendInt(buf, int64(v), 10)
+	case time.Time:
+		return v.AppendFormat(buf, time.RFC3339Nano)
+	case error:
+		s = v.Error()
+	case []byte:
+		s = string(v)
+	default:
+		s = fmt.Sprint(v)
+	}
+	if needsQuote(s) {
+		return strconv.AppendQuote(buf, s)
+	}
+	return append(buf, s...)
+}
+
+// needsQuote 报告控制台格式中 s 是否需要加引号才能无歧义地分隔
+func needsQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError || r == 0x7f {
+			return true
+		}
+	}
+	return false
+}
@@ -1 +1,205 @@
 package log
+
+/*
+	轻量的分级结构化日志: Logger 负责级别过滤和附加字段, 格式化与输出交给 Sink.
+	接入 slog、zap 等日志库时实现 Sink 做转换即可, slog 的适配见 SlogSink
+*/
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// ErrInvalidLevel 无法识别的日志级别
+var ErrInvalidLevel = errors.New("log: invalid level")
+
+// Level 日志级别, 数值与 log/slog 的级别一致
+type Level int8
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	}
+	return "ERROR"
+}
+
+// ParseLevel 解析 debug、info、warn(ing)、error, 不区分大小写
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrInvalidLevel, s)
+}
+
+// Field 结构化字段, Value 可以是任意类型, 由 Sink 决定如何编码
+type Field struct {
+	Key   string
+	Value any
+}
+
+// String 创建字符串字段
+func String(key, value string) Field { return Field{Key: key, Value: value} }
+
+// Int 创建整数字段
+func Int(key string, value int) Field { return Field{Key: key, Value: int64(value)} }
+
+// Int64 创建整数字段
+func Int64(key string, value int64) Field { return Field{Key: key, Value: value} }
+
+// Bool 创建布尔字段
+func Bool(key string, value bool) Field { return Field{Key: key, Value: value} }
+
+// Duration 创建时长字段
+func Duration(key string, value time.Duration) Field { return Field{Key: key, Value: value} }
+
+// Err 创建键为 "error" 的字段
+func Err(err error) Field { return Field{Key: "error", Value: err} }
+
+// Any 创建任意类型的字段
+func Any(key string, value any) Field { return Field{Key: key, Value: value} }
+
+// Record 一条日志
+type Record struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	// Fields 先是 Logger.With 附加的字段, 后是本次调用的字段
+	Fields []Field
+}
+
+// Sink 日志的输出端, 需要支持并发调用
+type Sink interface {
+	// Enabled 报告是否需要该级别的日志, 用于在构造 Record 前过滤
+	Enabled(level Level) bool
+	// Write 输出一条日志, 调用返回后 r 不再有效
+	Write(r *Record) error
+}
+
+// Logger 分级结构化日志, 可以并发使用
+type Logger struct {
+	sink   Sink
+	level  Level
+	fields []Field
+	clock  utils.Clock
+}
+
+// New 创建输出到 sink、记录 level 及以上级别的 Logger
+func New(sink Sink, level Level) *Logger {
+	return &Logger{sink: sink, level: level}
+}
+
+// WithClock 返回使用 clock 记录时间的副本
+func (l *Logger) WithClock(clock utils.Clock) *Logger {
+	c := *l
+	c.clock = clock
+	return &c
+}
+
+// WithLevel 返回最低级别为 level 的副本
+func (l *Logger) WithLevel(level Level) *Logger {
+	c := *l
+	c.level = level
+	return &c
+}
+
+// With 返回附加了 fields 的副本, 之后的每条日志都带上这些字段
+func (l *Logger) With(fields ...Field) *Logger {
+	if len(fields) == 0 {
+		return l
+	}
+	c := *l
+	c.fields = append(l.fields[:len(l.fields):len(l.fields)], fields...)
+	return &c
+}
+
+// Enabled 报告 level 级别的日志是否会被输出
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level && l.sink.Enabled(level)
+}
+
+// Log 以 level 级别记录 msg
+func (l *Logger) Log(level Level, msg string, fields ...Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	r := &Record{
+		Time:    utils.ClockOr(l.clock).Now(),
+		Level:   level,
+		Message: msg,
+		Fields:  l.fields,
+	}
+	if len(fields) > 0 {
+		r.Fields = append(l.fields[:len(l.fields):len(l.fields)], fields...)
+	}
+	// 日志本身出错时无处可报, 忽略
+	_ = l.sink.Write(r)
+}
+
+// Debug 记录调试日志
+func (l *Logger) Debug(msg string, fields ...Field) { l.Log(LevelDebug, msg, fields...) }
+
+// Info 记录一般日志
+func (l *Logger) Info(msg string, fields ...Field) { l.Log(LevelInfo, msg, fields...) }
+
+// Warn 记录警告日志
+func (l *Logger) Warn(msg string, fields ...Field) { l.Log(LevelWarn, msg, fields...) }
+
+// Error 记录错误日志
+func (l *Logger) Error(msg string, fields ...Field) { l.Log(LevelError, msg, fields...) }
+
+var std atomic.Pointer[Logger]
+
+func init() {
+	std.Store(New(NewConsoleSink(os.Stderr), LevelInfo))
+}
+
+// Default 返回默认 Logger, 初始为输出到标准错误的 Info 级别控制台格式
+func Default() *Logger { return std.Load() }
+
+// SetDefault 替换默认 Logger, 之后未配置 Logger 的组件都使用它
+func SetDefault(l *Logger) {
+	if l != nil {
+		std.Store(l)
+	}
+}
+
+// Or 返回 l, l 为 nil 时返回 Default()
+func Or(l *Logger) *Logger {
+	if l == nil {
+		return Default()
+	}
+	return l
+}
+
+// Discard 丢弃所有日志的 Logger
+var Discard = New(discardSink{}, LevelError)
+
+type discardSink struct{}
+
+func (discardSink) Enabled(Level) bool  { return false }
+func (discardSink) Write(*Record) error { return nil }
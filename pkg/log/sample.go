@@ -0,0 +1,96 @@
+package log
+
+/*
+	日志采样: 每个时间窗口内, 相同级别和消息的日志只完整输出前 First 条, 之后每 Thereafter 条输出一条,
+	防止连接风暴等场景下日志淹没磁盘; 计数按哈希分桶, 内存占用固定
+*/
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+const sampleBuckets = 4096
+
+// DefaultSampleTick 默认的采样窗口
+const DefaultSampleTick = time.Second
+
+// SampledSink 对 Sink 采样
+type SampledSink struct {
+	// Sink 实际输出
+	Sink Sink
+	// Tick 采样窗口, 0 表示 DefaultSampleTick
+	Tick time.Duration
+	// First 每个窗口内完整输出的条数
+	First int
+	// Thereafter 超过 First 后每隔多少条输出一条, 0 表示全部丢弃
+	Thereafter int
+	// MaxLevel 只对不高于该级别的日志采样, 0 值 (LevelInfo) 表示只采样 Debug 和 Info
+	MaxLevel Level
+	// Clock 为空时使用系统时钟
+	Clock utils.Clock
+
+	counters [sampleBuckets]sampleCounter
+	dropped  atomic.Uint64
+}
+
+type sampleCounter struct {
+	resetAt atomic.Int64
+	n       atomic.Uint64
+}
+
+// Sample 创建采样 Sink, 每秒内相同消息输出前 first 条, 之后每 thereafter 条输出一条; Error 级别不采样
+func Sample(sink Sink, first, thereafter int) *SampledSink {
+	return &SampledSink{Sink: sink, First: first, Thereafter: thereafter, MaxLevel: LevelWarn}
+}
+
+// Enabled 实现 Sink
+func (s *SampledSink) Enabled(level Level) bool { return s.Sink.Enabled(level) }
+
+// Write 实现 Sink, 被采样丢弃的日志返回 nil
+func (s *SampledSink) Write(r *Record) error {
+	if r.Level > s.MaxLevel {
+		return s.Sink.Write(r)
+	}
+	tick := s.Tick
+	if tick <= 0 {
+		tick = DefaultSampleTick
+	}
+	now := utils.ClockOr(s.Clock).Now().UnixNano()
+	c := &s.counters[sampleKey(r.Level, r.Message)%sampleBuckets]
+	n := c.inc(now, int64(tick))
+	if n <= uint64(s.First) || s.Thereafter > 0 && (n-uint64(s.First))%uint64(s.Thereafter) == 0 {
+		return s.Sink.Write(r)
+	}
+	s.dropped.Add(1)
+	return nil
+}
+
+// Dropped 返回被采样丢弃的日志条数
+func (s *SampledSink) Dropped() uint64 { return s.dropped.Load() }
+
+// inc 计数加一并返回窗口内的序号, 窗口过期时从 1 重新开始
+func (c *sampleCounter) inc(now, tick int64) uint64 {
+	resetAt := c.resetAt.Load()
+	if now < resetAt {
+		return c.n.Add(1)
+	}
+	// 只有一个调用者能开启新窗口, 其余的计入新窗口
+	if !c.resetAt.CompareAndSwap(resetAt, now+tick) {
+		return c.n.Add(1)
+	}
+	c.n.Store(1)
+	return 1
+}
+
+// sampleKey FNV-1a 哈希
+func sampleKey(level Level, msg string) uint32 {
+	h := uint32(2166136261)
+	h = (h ^ uint32(uint8(level))) * 16777619
+	for i := 0; i < len(msg); i++ {
+		h = (h ^ uint32(msg[i])) * 16777619
+	}
+	return h
+}
@@ -0,0 +1,34 @@
+package log
+
+/*
+	与标准库 log/slog 的适配: SlogSink 把日志交给 slog.Handler, 其他日志库 (如 zap) 可按同样方式实现 Sink
+*/
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogSink 把日志转交给 slog.Handler 的 Sink
+type SlogSink struct {
+	Handler slog.Handler
+}
+
+// FromSlog 创建输出到 l 的 Logger, 级别过滤交给 l 的 Handler
+func FromSlog(l *slog.Logger) *Logger {
+	return New(SlogSink{Handler: l.Handler()}, LevelDebug)
+}
+
+// Enabled 实现 Sink
+func (s SlogSink) Enabled(level Level) bool {
+	return s.Handler.Enabled(context.Background(), slog.Level(level))
+}
+
+// Write 实现 Sink
+func (s SlogSink) Write(r *Record) error {
+	sr := slog.NewRecord(r.Time, slog.Level(r.Level), r.Message, 0)
+	for _, f := range r.Fields {
+		sr.AddAttrs(slog.Any(f.Key, f.Value))
+	}
+	return s.Handler.Handle(context.Background(), sr)
+}
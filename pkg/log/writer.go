@@ -1 +1,81 @@
 package log
+
+/*
+	写入 io.Writer 的 Sink 以及组合多个 Sink 的 MultiSink
+*/
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// WriterSink 把格式化后的日志写入 W, 每条日志一次 Write 调用, 并发写入串行化
+type WriterSink struct {
+	// W 输出目标
+	W io.Writer
+	// Format 日志格式, 为空时使用 ConsoleFormat
+	Format Format
+	// Level 输出的最低级别, 与 Logger 的级别同时生效
+	Level Level
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// NewJSONSink 创建以 JSON 格式写入 w 的 Sink
+func NewJSONSink(w io.Writer) *WriterSink {
+	return &WriterSink{W: w, Format: JSONFormat, Level: LevelDebug}
+}
+
+// NewConsoleSink 创建以控制台格式写入 w 的 Sink
+func NewConsoleSink(w io.Writer) *WriterSink {
+	return &WriterSink{W: w, Format: ConsoleFormat, Level: LevelDebug}
+}
+
+// Enabled 实现 Sink
+func (s *WriterSink) Enabled(level Level) bool { return level >= s.Level }
+
+// Write 实现 Sink
+func (s *WriterSink) Write(r *Record) error {
+	format := s.Format
+	if format == nil {
+		format = ConsoleFormat
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf = format(s.buf[:0], r)
+	_, err := s.W.Write(s.buf)
+	// 偶尔很长的日志不长期占用内存
+	if cap(s.buf) > 64<<10 {
+		s.buf = nil
+	}
+	return err
+}
+
+// MultiSink 把日志同时交给多个 Sink, 每个 Sink 按各自的级别过滤
+type MultiSink []Sink
+
+// Enabled 实现 Sink, 任一 Sink 需要时返回 true
+func (m MultiSink) Enabled(level Level) bool {
+	for _, s := range m {
+		if s.Enabled(level) {
+			return true
+		}
+	}
+	return false
+}
+
+// Write 实现 Sink, 返回所有 Sink 的错误
+func (m MultiSink) Write(r *Record) error {
+	var errs []error
+	for _, s := range m {
+		if !s.Enabled(r.Level) {
+			continue
+		}
+		if err := s.Write(r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
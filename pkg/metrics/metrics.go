@@ -0,0 +1,156 @@
+package metrics
+
+/*
+	指标的基本类型: Counter、Gauge 和 Histogram, 更新路径只用原子操作, 可以在热路径上调用.
+	指标通常通过 Registry 创建, 以便统一导出; 也可以直接使用零值 (Histogram 除外)
+*/
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Counter 只增不减的计数器, 零值可用
+type Counter struct {
+	v atomic.Uint64
+}
+
+// Inc 加一
+func (c *Counter) Inc() { c.v.Add(1) }
+
+// Add 加 n
+func (c *Counter) Add(n uint64) { c.v.Add(n) }
+
+// Value 返回当前值
+func (c *Counter) Value() uint64 { return c.v.Load() }
+
+// Gauge 可增可减的数值, 零值可用
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+// Set 设置为 v
+func (g *Gauge) Set(v float64) { g.bits.Store(math.Float64bits(v)) }
+
+// Add 加 d, d 可以为负
+func (g *Gauge) Add(d float64) {
+	for {
+		old := g.bits.Load()
+		if g.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+d)) {
+			return
+		}
+	}
+}
+
+// Inc 加一
+func (g *Gauge) Inc() { g.Add(1) }
+
+// Dec 减一
+func (g *Gauge) Dec() { g.Add(-1) }
+
+// SetMax 在 v 大于当前值时设置为 v
+func (g *Gauge) SetMax(v float64) {
+	for {
+		old := g.bits.Load()
+		if v <= math.Float64frombits(old) || g.bits.CompareAndSwap(old, math.Float64bits(v)) {
+			return
+		}
+	}
+}
+
+// Value 返回当前值
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+
+// DefBuckets 默认的直方图桶上界, 单位秒, 适合记录请求耗时
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ExponentialBuckets 返回 n 个从 start 开始、每个是前一个 factor 倍的桶上界
+func ExponentialBuckets(start, factor float64, n int) []float64 {
+	b := make([]float64, n)
+	for i := range b {
+		b[i] = start
+		start *= factor
+	}
+	return b
+}
+
+// LinearBuckets 返回 n 个从 start 开始、间隔 width 的桶上界
+func LinearBuckets(start, width float64, n int) []float64 {
+	b := make([]float64, n)
+	for i := range b {
+		b[i] = start + float64(i)*width
+	}
+	return b
+}
+
+// Histogram 按桶统计观测值的分布, 同时记录总数和总和
+type Histogram struct {
+	upper  []float64
+	counts []atomic.Uint64 // 最后一个对应 +Inf
+	sum    Gauge
+}
+
+// NewHistogram 创建以 buckets 为桶上界的直方图, buckets 为空时使用 DefBuckets; 上界会被排序并去掉 +Inf
+func NewHistogram(buckets []float64) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	upper := make([]float64, 0, len(buckets))
+	for _, b := range buckets {
+		if !math.IsInf(b, 1) && !math.IsNaN(b) {
+			upper = append(upper, b)
+		}
+	}
+	sort.Float64s(upper)
+	return &Histogram{upper: upper, counts: make([]atomic.Uint64, len(upper)+1)}
+}
+
+// Observe 记录一个观测值
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upper, v)
+	h.counts[i].Add(1)
+	h.sum.Add(v)
+}
+
+// ObserveDuration 以秒为单位记录 d
+func (h *Histogram) ObserveDuration(d time.Duration) { h.Observe(d.Seconds()) }
+
+// Bucket 直方图的一个桶, Count 为不大于 UpperBound 的观测值个数 (累计)
+type Bucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+// HistogramSnapshot 直方图的快照, Buckets 不含 +Inf 桶, 其累计数即 Count
+type HistogramSnapshot struct {
+	Buckets []Bucket
+	Count   uint64
+	Sum     float64
+}
+
+// Snapshot 返回当前分布; 与并发的 Observe 之间不保证严格一致
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{Buckets: make([]Bucket, len(h.upper))}
+	for i := range h.counts {
+		s.Count += h.counts[i].Load()
+		if i < len(h.upper) {
+			s.Buckets[i] = Bucket{UpperBound: h.upper[i], Count: s.Count}
+		}
+	}
+	s.Sum = h.sum.Value()
+	return s
+}
+
+// Count 返回观测次数
+func (h *Histogram) Count() uint64 {
+	var n uint64
+	for i := range h.counts {
+		n += h.counts[i].Load()
+	}
+	return n
+}
+
+// Sum 返回观测值之和
+func (h *Histogram) Sum() float64 { return h.sum.Value() }
@@ -0,0 +1,101 @@
+package metrics
+
+/*
+	Prometheus 文本格式 (0.0.4) 导出
+*/
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// PrometheusContentType Prometheus 文本格式的 Content-Type
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// WritePrometheus 以 Prometheus 文本格式写出所有指标
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range r.Gather() {
+		writeFamily(bw, f)
+	}
+	return bw.Flush()
+}
+
+func writeFamily(w *bufio.Writer, f Family) {
+	if f.Help != "" {
+		w.WriteString("# HELP ")
+		w.WriteString(f.Name)
+		w.WriteByte(' ')
+		w.WriteString(helpEscaper.Replace(f.Help))
+		w.WriteByte('\n')
+	}
+	w.WriteString("# TYPE ")
+	w.WriteString(f.Name)
+	w.WriteByte(' ')
+	w.WriteString(f.Type.String())
+	w.WriteByte('\n')
+	for _, m := range f.Metrics {
+		if m.Histogram == nil {
+			writeSample(w, f.Name, m.Labels, "", "", m.Value)
+			continue
+		}
+		h := m.Histogram
+		for _, b := range h.Buckets {
+			writeSample(w, f.Name+"_bucket", m.Labels, "le", formatFloat(b.UpperBound), float64(b.Count))
+		}
+		writeSample(w, f.Name+"_bucket", m.Labels, "le", "+Inf", float64(h.Count))
+		writeSample(w, f.Name+"_sum", m.Labels, "", "", h.Sum)
+		writeSample(w, f.Name+"_count", m.Labels, "", "", float64(h.Count))
+	}
+}
+
+// writeSample 写出一行样本, extraName 非空时追加一个标签 (直方图的 le)
+func writeSample(w *bufio.Writer, name string, labels []Label, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, l.Name, l.Value)
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func writeLabel(w *bufio.Writer, name, value string) {
+	w.WriteString(name)
+	w.WriteString(`="`)
+	w.WriteString(labelEscaper.Replace(value))
+	w.WriteByte('"')
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,230 @@
+package metrics
+
+/*
+	指标注册表: 按名称登记指标族, 供 Prometheus 文本导出或程序读取.
+	同名同类型同标签的重复登记返回已有的指标, 多个组件共用一个 Registry 时指标自然合并
+*/
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// Type 指标类型
+type Type int
+
+const (
+	TypeCounter Type = iota
+	TypeGauge
+	TypeHistogram
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeCounter:
+		return "counter"
+	case TypeGauge:
+		return "gauge"
+	}
+	return "histogram"
+}
+
+// Label 标签名和值
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Metric 一个序列的快照, 直方图的值在 Histogram 中
+type Metric struct {
+	Labels    []Label
+	Value     float64
+	Histogram *HistogramSnapshot
+}
+
+// Family 同名指标的快照
+type Family struct {
+	Name    string
+	Help    string
+	Type    Type
+	Metrics []Metric
+}
+
+// Registry 指标注册表, 可以并发使用
+type Registry struct {
+	mu       sync.RWMutex
+	families map[string]*family
+}
+
+type family struct {
+	help    string
+	typ     Type
+	labels  []string
+	metric  any
+	collect func() []Metric
+}
+
+// NewRegistry 创建空的注册表
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// register 登记名为 name 的指标族, 已存在且类型和标签一致时返回已有的指标;
+// 名称非法或与已有指标冲突属于编程错误, 直接 panic
+func (r *Registry) register(name, help string, typ Type, labels []string, metric any, collect func() []Metric) any {
+	if !validName(name, true) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	for _, l := range labels {
+		if !validName(l, false) || l == "le" && typ == TypeHistogram {
+			panic(fmt.Sprintf("metrics: invalid label name %q for %s", l, name))
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.typ != typ || !slices.Equal(f.labels, labels) || fmt.Sprintf("%T", f.metric) != fmt.Sprintf("%T", metric) {
+			panic(fmt.Sprintf("metrics: %s already registered with a different type or labels", name))
+		}
+		return f.metric
+	}
+	r.families[name] = &family{help: help, typ: typ, labels: labels, metric: metric, collect: collect}
+	return metric
+}
+
+// Counter 登记并返回名为 name 的 Counter
+func (r *Registry) Counter(name, help string) *Counter {
+	c := new(Counter)
+	return r.register(name, help, TypeCounter, nil, c, func() []Metric {
+		return []Metric{{Value: float64(c.Value())}}
+	}).(*Counter)
+}
+
+// CounterVec 登记并返回名为 name、标签名为 labels 的 CounterVec
+func (r *Registry) CounterVec(name, help string, labels ...string) *CounterVec {
+	c := NewCounterVec(labels...)
+	return r.register(name, help, TypeCounter, labels, c, func() []Metric {
+		var ms []Metric
+		c.v.each(func(values []string, m *Counter) {
+			ms = append(ms, Metric{Labels: pairs(labels, values), Value: float64(m.Value())})
+		})
+		return ms
+	}).(*CounterVec)
+}
+
+// Gauge 登记并返回名为 name 的 Gauge
+func (r *Registry) Gauge(name, help string) *Gauge {
+	g := new(Gauge)
+	return r.register(name, help, TypeGauge, nil, g, func() []Metric {
+		return []Metric{{Value: g.Value()}}
+	}).(*Gauge)
+}
+
+// GaugeVec 登记并返回名为 name、标签名为 labels 的 GaugeVec
+func (r *Registry) GaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := NewGaugeVec(labels...)
+	return r.register(name, help, TypeGauge, labels, g, func() []Metric {
+		var ms []Metric
+		g.v.each(func(values []string, m *Gauge) {
+			ms = append(ms, Metric{Labels: pairs(labels, values), Value: m.Value()})
+		})
+		return ms
+	}).(*GaugeVec)
+}
+
+// GaugeFunc 登记在导出时调用 f 取值的 Gauge; 同名的 GaugeFunc 已存在时保留先登记的
+func (r *Registry) GaugeFunc(name, help string, f func() float64) {
+	r.register(name, help, TypeGauge, nil, f, func() []Metric {
+		return []Metric{{Value: f()}}
+	})
+}
+
+// Histogram 登记并返回名为 name 的 Histogram, 已存在时沿用其桶
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	h := NewHistogram(buckets)
+	return r.register(name, help, TypeHistogram, nil, h, func() []Metric {
+		s := h.Snapshot()
+		return []Metric{{Histogram: &s}}
+	}).(*Histogram)
+}
+
+// HistogramVec 登记并返回名为 name、标签名为 labels 的 HistogramVec, 已存在时沿用其桶
+func (r *Registry) HistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := NewHistogramVec(buckets, labels...)
+	return r.register(name, help, TypeHistogram, labels, h, func() []Metric {
+		var ms []Metric
+		h.v.each(func(values []string, m *Histogram) {
+			s := m.Snapshot()
+			ms = append(ms, Metric{Labels: pairs(labels, values), Histogram: &s})
+		})
+		return ms
+	}).(*HistogramVec)
+}
+
+// Unregister 注销名为 name 的指标族, 返回是否存在
+func (r *Registry) Unregister(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.families[name]
+	delete(r.families, name)
+	return ok
+}
+
+// Gather 返回所有指标族的快照, 按名称排序; 没有任何序列的指标族也会返回
+func (r *Registry) Gather() []Family {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.families))
+	fams := make(map[string]*family, len(r.families))
+	for name, f := range r.families {
+		names = append(names, name)
+		fams[name] = f
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+
+	out := make([]Family, 0, len(names))
+	for _, name := range names {
+		f := fams[name]
+		out = append(out, Family{Name: name, Help: f.help, Type: f.typ, Metrics: f.collect()})
+	}
+	return out
+}
+
+// Family 返回名为 name 的指标族的快照
+func (r *Registry) Family(name string) (Family, bool) {
+	r.mu.RLock()
+	f, ok := r.families[name]
+	r.mu.RUnlock()
+	if !ok {
+		return Family{}, false
+	}
+	return Family{Name: name, Help: f.help, Type: f.typ, Metrics: f.collect()}, true
+}
+
+func pairs(names, values []string) []Label {
+	ls := make([]Label, len(names))
+	for i := range names {
+		ls[i] = Label{Name: names[i], Value: values[i]}
+	}
+	return ls
+}
+
+// validName 检查指标名 ([a-zA-Z_:][a-zA-Z0-9_:]*) 或标签名 (不含冒号, 不以 __ 开头)
+func validName(s string, metric bool) bool {
+	if s == "" || !metric && len(s) >= 2 && s[:2] == "__" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c == ':' && metric:
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
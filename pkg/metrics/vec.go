@@ -0,0 +1,108 @@
+package metrics
+
+/*
+	带标签的指标族: 同名指标按标签值划分为多个序列, 序列在第一次 With 时创建
+*/
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// vec 按标签值索引的序列集合
+type vec[T any] struct {
+	labels []string
+	newFn  func() T
+	series sync.Map // 标签值拼接 -> *entry[T]
+}
+
+type entry[T any] struct {
+	values []string
+	metric T
+}
+
+// labelSep 拼接标签值的分隔符, 不会出现在合法的 UTF-8 中
+const labelSep = "\xff"
+
+func (v *vec[T]) with(values []string) T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: got %d label values, want %d", len(values), len(v.labels)))
+	}
+	key := strings.Join(values, labelSep)
+	if e, ok := v.series.Load(key); ok {
+		return e.(*entry[T]).metric
+	}
+	e, _ := v.series.LoadOrStore(key, &entry[T]{values: append([]string(nil), values...), metric: v.newFn()})
+	return e.(*entry[T]).metric
+}
+
+func (v *vec[T]) delete(values []string) bool {
+	_, ok := v.series.LoadAndDelete(strings.Join(values, labelSep))
+	return ok
+}
+
+// each 按标签值排序遍历所有序列
+func (v *vec[T]) each(f func(values []string, m T)) {
+	var es []*entry[T]
+	v.series.Range(func(_, e any) bool {
+		es = append(es, e.(*entry[T]))
+		return true
+	})
+	sort.Slice(es, func(i, j int) bool {
+		return strings.Join(es[i].values, labelSep) < strings.Join(es[j].values, labelSep)
+	})
+	for _, e := range es {
+		f(e.values, e.metric)
+	}
+}
+
+// CounterVec 带标签的 Counter
+type CounterVec struct{ v vec[*Counter] }
+
+// NewCounterVec 创建标签名为 labels 的 CounterVec
+func NewCounterVec(labels ...string) *CounterVec {
+	return &CounterVec{v: vec[*Counter]{labels: labels, newFn: func() *Counter { return new(Counter) }}}
+}
+
+// With 返回标签值为 values 的序列, 不存在时创建; values 的个数必须与标签名一致
+func (c *CounterVec) With(values ...string) *Counter { return c.v.with(values) }
+
+// Delete 删除标签值为 values 的序列
+func (c *CounterVec) Delete(values ...string) bool { return c.v.delete(values) }
+
+// Total 返回所有序列之和
+func (c *CounterVec) Total() uint64 {
+	var n uint64
+	c.v.each(func(_ []string, m *Counter) { n += m.Value() })
+	return n
+}
+
+// GaugeVec 带标签的 Gauge
+type GaugeVec struct{ v vec[*Gauge] }
+
+// NewGaugeVec 创建标签名为 labels 的 GaugeVec
+func NewGaugeVec(labels ...string) *GaugeVec {
+	return &GaugeVec{v: vec[*Gauge]{labels: labels, newFn: func() *Gauge { return new(Gauge) }}}
+}
+
+// With 返回标签值为 values 的序列, 不存在时创建
+func (g *GaugeVec) With(values ...string) *Gauge { return g.v.with(values) }
+
+// Delete 删除标签值为 values 的序列
+func (g *GaugeVec) Delete(values ...string) bool { return g.v.delete(values) }
+
+// HistogramVec 带标签的 Histogram, 所有序列使用相同的桶
+type HistogramVec struct{ v vec[*Histogram] }
+
+// NewHistogramVec 创建桶上界为 buckets、标签名为 labels 的 HistogramVec
+func NewHistogramVec(buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{v: vec[*Histogram]{labels: labels, newFn: func() *Histogram { return NewHistogram(buckets) }}}
+}
+
+// With 返回标签值为 values 的序列, 不存在时创建
+func (h *HistogramVec) With(values ...string) *Histogram { return h.v.with(values) }
+
+// Delete 删除标签值为 values 的序列
+func (h *HistogramVec) Delete(values ...string) bool { return h.v.delete(values) }
@@ -0,0 +1,90 @@
+package quic
+
+/*
+	QUIC 传输层接口 (实验性). 标准库没有公开的 QUIC 实现, HTTP/3 只依赖这里的接口,
+	由调用方把具体的 QUIC 实现 (如 quic-go) 适配为 Listener 和 Conn 后接入
+*/
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+)
+
+// StreamID QUIC 流 ID, 低两位表示发起方和方向
+type StreamID int64
+
+// ClientInitiated 流是否由客户端发起
+func (id StreamID) ClientInitiated() bool { return id&1 == 0 }
+
+// Bidirectional 是否为双向流
+func (id StreamID) Bidirectional() bool { return id&2 == 0 }
+
+// StreamError 对端以应用错误码重置了流 (RESET_STREAM 或 STOP_SENDING).
+// 适配器在流的读写因此失败时应返回可以用 errors.As 取得该类型的错误, 上层据此区分对端拒绝和其他失败
+type StreamError struct {
+	StreamID  StreamID
+	ErrorCode uint64
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("quic: stream %d reset by peer: error code 0x%x", e.StreamID, e.ErrorCode)
+}
+
+// ReceiveStream 单向流的接收端, 或双向流的读方向
+type ReceiveStream interface {
+	StreamID() StreamID
+	// Read 读取流数据, 对端正常结束发送时返回 io.EOF
+	io.Reader
+	// CancelRead 以应用错误码要求对端停止发送 (STOP_SENDING)
+	CancelRead(code uint64)
+}
+
+// SendStream 单向流的发送端, 或双向流的写方向
+type SendStream interface {
+	StreamID() StreamID
+	io.Writer
+	// Close 正常结束发送 (FIN), 不影响读方向
+	Close() error
+	// CancelWrite 以应用错误码重置发送方向 (RESET_STREAM)
+	CancelWrite(code uint64)
+}
+
+// Stream 双向流
+type Stream interface {
+	ReceiveStream
+	SendStream
+}
+
+// Conn 一条已完成握手的 QUIC 连接
+type Conn interface {
+	// AcceptStream 等待对端发起的双向流
+	AcceptStream(ctx context.Context) (Stream, error)
+	// AcceptUniStream 等待对端发起的单向流
+	AcceptUniStream(ctx context.Context) (ReceiveStream, error)
+	// OpenStream 发起双向流, 超出对端允许的流数量时等待或在 ctx 结束时返回
+	OpenStream(ctx context.Context) (Stream, error)
+	// OpenUniStream 发起单向流
+	OpenUniStream(ctx context.Context) (SendStream, error)
+	// CloseWithError 以应用错误码关闭连接
+	CloseWithError(code uint64, reason string) error
+	// Context 在连接关闭时结束
+	Context() context.Context
+	// ConnectionState 握手结果, NegotiatedProtocol 为 ALPN 协商的应用协议
+	ConnectionState() tls.ConnectionState
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+}
+
+// Listener 接受 QUIC 连接
+type Listener interface {
+	// Accept 等待下一条完成握手的连接, 监听器关闭后返回错误
+	Accept(ctx context.Context) (Conn, error)
+	Close() error
+	Addr() net.Addr
+}
+
+// DialFunc 建立到 addr 的 QUIC 连接, tlsConf 已设置 ServerName 和 NextProtos
+type DialFunc func(ctx context.Context, addr string, tlsConf *tls.Config) (Conn, error)
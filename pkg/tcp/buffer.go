@@ -1 +1,148 @@
 package tcp
+
+/*
+	缓冲区管理, 为连接提供池化的 bufio.Reader/Writer
+*/
+
+import (
+	"bufio"
+	"sync"
+
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// 默认读写缓冲区大小
+const (
+	DefaultReadBufferSize  = 4 << 10
+	DefaultWriteBufferSize = 4 << 10
+)
+
+// BufferedConn 为 Conn 附加池化的读写缓冲区, Close 或 Release 时归还缓冲区.
+// Read、Write 可与 Release、Close 并发调用; 读与写分别加锁, 彼此互不阻塞
+type BufferedConn struct {
+	*Conn
+
+	rmu   sync.Mutex
+	wmu   sync.Mutex
+	r     *bufio.Reader
+	w     *bufio.Writer
+	rsize int
+	wsize int
+}
+
+// NewBufferedConn 使用默认大小的缓冲区封装 c
+func NewBufferedConn(c *Conn) *BufferedConn {
+	return NewBufferedConnSize(c, DefaultReadBufferSize, DefaultWriteBufferSize)
+}
+
+// NewBufferedConnSize 使用指定大小的缓冲区封装 c
+func NewBufferedConnSize(c *Conn, readSize, writeSize int) *BufferedConn {
+	if readSize <= 0 {
+		readSize = DefaultReadBufferSize
+	}
+	if writeSize <= 0 {
+		writeSize = DefaultWriteBufferSize
+	}
+	// 超出内存预算时账户会关闭连接, 后续读写随之失败
+	c.mem.Reserve(int64(readSize + writeSize))
+	return &BufferedConn{
+		Conn:  c,
+		r:     utils.GetReader(c, readSize),
+		w:     utils.GetWriter(c, writeSize),
+		rsize: readSize,
+		wsize: writeSize,
+	}
+}
+
+// Reader 返回带缓冲的读取器, Release 之后返回 nil.
+// 直接使用读取器时不经过 BufferedConn 的锁, 调用方需保证它与 Release 不并发
+func (bc *BufferedConn) Reader() *bufio.Reader {
+	bc.rmu.Lock()
+	defer bc.rmu.Unlock()
+	return bc.r
+}
+
+// Writer 返回带缓冲的写入器, Release 之后返回 nil.
+// 与 Reader 相同, 调用方需保证它与 Release 不并发
+func (bc *BufferedConn) Writer() *bufio.Writer {
+	bc.wmu.Lock()
+	defer bc.wmu.Unlock()
+	return bc.w
+}
+
+func (bc *BufferedConn) Read(p []byte) (int, error) {
+	bc.rmu.Lock()
+	defer bc.rmu.Unlock()
+	if bc.r == nil {
+		return bc.Conn.Read(p)
+	}
+	return bc.r.Read(p)
+}
+
+func (bc *BufferedConn) Write(p []byte) (int, error) {
+	bc.wmu.Lock()
+	defer bc.wmu.Unlock()
+	if bc.w == nil {
+		return bc.Conn.Write(p)
+	}
+	return bc.w.Write(p)
+}
+
+// Flush 写出缓冲区中的数据
+func (bc *BufferedConn) Flush() error {
+	bc.wmu.Lock()
+	defer bc.wmu.Unlock()
+	if bc.w == nil {
+		return nil
+	}
+	return bc.w.Flush()
+}
+
+// Release 刷出写缓冲并归还缓冲区, 返回读缓冲中尚未消费的数据, 连接保持打开.
+// 用于连接被接管 (如协议升级) 的场景; 会等待进行中的 Read 返回
+func (bc *BufferedConn) Release() ([]byte, error) {
+	err := bc.releaseWriter()
+	return bc.releaseReader(), err
+}
+
+func (bc *BufferedConn) releaseWriter() error {
+	bc.wmu.Lock()
+	defer bc.wmu.Unlock()
+	if bc.w == nil {
+		return nil
+	}
+	err := bc.w.Flush()
+	utils.PutWriter(bc.w)
+	bc.Conn.mem.Release(int64(bc.wsize))
+	bc.w = nil
+	return err
+}
+
+func (bc *BufferedConn) releaseReader() []byte {
+	bc.rmu.Lock()
+	defer bc.rmu.Unlock()
+	if bc.r == nil {
+		return nil
+	}
+	var buffered []byte
+	if n := bc.r.Buffered(); n > 0 {
+		peek, _ := bc.r.Peek(n)
+		buffered = append([]byte(nil), peek...)
+	}
+	utils.PutReader(bc.r)
+	bc.Conn.mem.Release(int64(bc.rsize))
+	bc.r = nil
+	return buffered
+}
+
+// Close 刷出写缓冲、关闭连接并归还缓冲区.
+// 先关闭连接再回收读缓冲, 使阻塞中的 Read 返回而不是让 Close 永久等待
+func (bc *BufferedConn) Close() error {
+	ferr := bc.releaseWriter()
+	err := bc.Conn.Close()
+	bc.releaseReader()
+	if err == nil {
+		err = ferr
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package tcp
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBufferedConnConcurrentClose(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		a, b := net.Pipe()
+		go io.Copy(io.Discard, b)
+		go func() {
+			for {
+				if _, err := b.Write([]byte("data")); err != nil {
+					return
+				}
+			}
+		}()
+		bc := NewBufferedConn(NewConn(a))
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 3)
+			for {
+				if _, err := bc.Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for {
+				if _, err := bc.Write([]byte("x")); err != nil {
+					return
+				}
+				if err := bc.Flush(); err != nil {
+					return
+				}
+			}
+		}()
+		time.Sleep(time.Millisecond)
+
+		done := make(chan struct{})
+		go func() {
+			bc.Close()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Close blocked behind an in-flight Read")
+		}
+		wg.Wait()
+		b.Close()
+		if bc.Reader() != nil || bc.Writer() != nil {
+			t.Fatal("buffers not released after Close")
+		}
+	}
+}
+
+func TestBufferedConnReleaseReturnsBuffered(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	go b.Write([]byte("hello world"))
+	bc := NewBufferedConn(NewConn(a))
+	p := make([]byte, 5)
+	if _, err := io.ReadFull(bc, p); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := bc.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p)+string(rest) != "hello world" {
+		t.Fatalf("read %q then released %q, want hello world", p, rest)
+	}
+}
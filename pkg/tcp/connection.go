@@ -1 +1,181 @@
 package tcp
+
+/*
+	TCP连接封装, 提供超时辅助、字节计数、时间戳和连接 ID
+*/
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+var connID atomic.Uint64
+
+// Conn 对 net.Conn 的封装, 所有读写都会更新计数和最后活动时间
+type Conn struct {
+	net.Conn
+
+	id           uint64
+	createdAt    time.Time
+	lastActivity atomic.Int64
+	bytesRead    atomic.Uint64
+	bytesWritten atomic.Uint64
+	tls          *TLSState
+	hooks        *Hooks
+	meta         *Metadata
+	mem          *MemoryAccount
+	closed       atomic.Bool
+}
+
+// ConnStats 连接统计快照
+type ConnStats struct {
+	ID           uint64
+	LocalAddr    string
+	RemoteAddr   string
+	CreatedAt    time.Time
+	LastActivity time.Time
+	BytesRead    uint64
+	BytesWritten uint64
+}
+
+// NewConn 封装 c, 分配进程内唯一的连接 ID
+func NewConn(c net.Conn) *Conn {
+	now := time.Now()
+	conn := &Conn{
+		Conn:      c,
+		id:        connID.Add(1),
+		createdAt: now,
+		meta:      &Metadata{},
+	}
+	conn.lastActivity.Store(now.UnixNano())
+	return conn
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.recordRead(n, err)
+	return n, err
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.recordWrite(n, err)
+	return n, err
+}
+
+// recordRead 更新读计数并触发钩子, 绕过 Read 直接搬运数据 (如 splice) 时也需调用
+func (c *Conn) recordRead(n int, err error) {
+	if n > 0 {
+		c.bytesRead.Add(uint64(n))
+		c.touch()
+	}
+	if c.hooks != nil && c.hooks.OnRead != nil {
+		c.hooks.OnRead(c, n, err)
+	}
+}
+
+func (c *Conn) recordWrite(n int, err error) {
+	if n > 0 {
+		c.bytesWritten.Add(uint64(n))
+		c.touch()
+	}
+	if c.hooks != nil && c.hooks.OnWrite != nil {
+		c.hooks.OnWrite(c, n, err)
+	}
+}
+
+// Close 关闭连接, 首次关闭时触发 OnClose 钩子
+func (c *Conn) Close() error {
+	err := c.Conn.Close()
+	if c.closed.CompareAndSwap(false, true) {
+		c.mem.Close()
+		if c.hooks != nil && c.hooks.OnClose != nil {
+			c.hooks.OnClose(c)
+		}
+	}
+	return err
+}
+
+func (c *Conn) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+// ID 返回连接 ID, 用于日志关联
+func (c *Conn) ID() uint64 { return c.id }
+
+// CreatedAt 返回连接创建时间
+func (c *Conn) CreatedAt() time.Time { return c.createdAt }
+
+// LastActivity 返回最后一次成功读写的时间
+func (c *Conn) LastActivity() time.Time { return time.Unix(0, c.lastActivity.Load()) }
+
+// IdleFor 返回距最后一次读写的时间
+func (c *Conn) IdleFor() time.Duration { return time.Since(c.LastActivity()) }
+
+// BytesRead 返回已读取的字节数
+func (c *Conn) BytesRead() uint64 { return c.bytesRead.Load() }
+
+// BytesWritten 返回已写出的字节数
+func (c *Conn) BytesWritten() uint64 { return c.bytesWritten.Load() }
+
+// Stats 返回连接统计快照
+func (c *Conn) Stats() ConnStats {
+	return ConnStats{
+		ID:           c.id,
+		LocalAddr:    addrString(c.LocalAddr()),
+		RemoteAddr:   addrString(c.RemoteAddr()),
+		CreatedAt:    c.createdAt,
+		LastActivity: c.LastActivity(),
+		BytesRead:    c.BytesRead(),
+		BytesWritten: c.BytesWritten(),
+	}
+}
+
+// SetReadTimeout 设置从现在起的读超时, d <= 0 时清除
+func (c *Conn) SetReadTimeout(d time.Duration) error {
+	return c.SetReadDeadline(deadline(d))
+}
+
+// SetWriteTimeout 设置从现在起的写超时, d <= 0 时清除
+func (c *Conn) SetWriteTimeout(d time.Duration) error {
+	return c.SetWriteDeadline(deadline(d))
+}
+
+// SetTimeout 同时设置读写超时, d <= 0 时清除
+func (c *Conn) SetTimeout(d time.Duration) error {
+	return c.SetDeadline(deadline(d))
+}
+
+// TLS 返回 TLS 握手结果, 非 TLS 连接返回 nil
+func (c *Conn) TLS() *TLSState { return c.tls }
+
+// NegotiatedProtocol 返回 ALPN 协商的协议, 未协商时为空
+func (c *Conn) NegotiatedProtocol() string {
+	if c.tls == nil {
+		return ""
+	}
+	return c.tls.NegotiatedProtocol
+}
+
+// Unwrap 返回被封装的连接
+func (c *Conn) Unwrap() net.Conn { return c.Conn }
+
+func (c *Conn) String() string {
+	return fmt.Sprintf("conn#%d %s->%s", c.id, addrString(c.LocalAddr()), addrString(c.RemoteAddr()))
+}
+
+func deadline(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+func addrString(a net.Addr) string {
+	if a == nil {
+		return ""
+	}
+	return a.String()
+}
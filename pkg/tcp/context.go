@@ -0,0 +1,93 @@
+package tcp
+
+/*
+	上下文取消支持: 阻塞操作在 ctx 结束时立即返回, 错误区分超时与主动取消
+*/
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ContextError ctx 结束导致操作中止, 实现 net.Error; Unwrap 返回 ctx.Err(),
+// 可用 errors.Is(err, context.Canceled) 或 context.DeadlineExceeded 判断
+type ContextError struct {
+	// Op 被中止的操作, 如 "dial"、"handshake"、"read"
+	Op string
+	// Err context.Canceled 或 context.DeadlineExceeded
+	Err error
+	// Cause context.Cause 返回的原因, 限速等待超时时为 os.ErrDeadlineExceeded; 未设置时与 Err 相同
+	Cause error
+}
+
+func (e *ContextError) Error() string {
+	if e.Cause != nil && e.Cause != e.Err {
+		return "tcp: " + e.Op + ": " + e.Err.Error() + ": " + e.Cause.Error()
+	}
+	return "tcp: " + e.Op + ": " + e.Err.Error()
+}
+
+func (e *ContextError) Unwrap() error { return e.Err }
+
+// Is 使 errors.Is 同样匹配 Cause, 如限速等待超时的 os.ErrDeadlineExceeded
+func (e *ContextError) Is(target error) bool { return e.Cause != nil && errors.Is(e.Cause, target) }
+
+// Timeout 报告是否因截止时间到达而中止
+func (e *ContextError) Timeout() bool { return errors.Is(e.Err, context.DeadlineExceeded) }
+
+// Temporary 为满足 net.Error 接口, 与 Timeout 一致
+func (e *ContextError) Temporary() bool { return e.Timeout() }
+
+// Canceled 报告是否被主动取消
+func (e *ContextError) Canceled() bool { return errors.Is(e.Err, context.Canceled) }
+
+// ctxError ctx 已结束时返回 *ContextError, 否则返回 err 本身;
+// err 已是同一原因的 *ContextError 时原样返回
+func ctxError(op string, ctx context.Context, err error) error {
+	if ctx.Err() == nil {
+		return err
+	}
+	var ce *ContextError
+	if errors.As(err, &ce) && ce.Err == ctx.Err() {
+		return err
+	}
+	return &ContextError{Op: op, Err: ctx.Err(), Cause: context.Cause(ctx)}
+}
+
+// aLongTimeAgo 用于立即唤醒阻塞在读写上的 goroutine
+var aLongTimeAgo = time.Unix(1, 0)
+
+// ReadContext 读取数据, ctx 结束时立即返回 *ContextError.
+// 取消通过设置读截止时间实现, 对限速等待同样有效; 取消后读截止时间被清除, 调用方如依赖需重新设置
+func (c *Conn) ReadContext(ctx context.Context, p []byte) (int, error) {
+	if ctx.Done() == nil {
+		return c.Read(p)
+	}
+	stop := context.AfterFunc(ctx, func() { c.SetReadDeadline(aLongTimeAgo) })
+	n, err := c.Read(p)
+	if !stop() {
+		c.SetReadDeadline(time.Time{})
+		if err != nil {
+			err = ctxError("read", ctx, err)
+		}
+	}
+	return n, err
+}
+
+// WriteContext 写出数据, ctx 结束时立即返回已写出的字节数和 *ContextError.
+// 取消会清除写截止时间
+func (c *Conn) WriteContext(ctx context.Context, p []byte) (int, error) {
+	if ctx.Done() == nil {
+		return c.Write(p)
+	}
+	stop := context.AfterFunc(ctx, func() { c.SetWriteDeadline(aLongTimeAgo) })
+	n, err := c.Write(p)
+	if !stop() {
+		c.SetWriteDeadline(time.Time{})
+		if err != nil {
+			err = ctxError("write", ctx, err)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,119 @@
+package tcp
+
+/*
+	TCP拨号器, 支持上下文取消、连接超时、keepalive、本地地址绑定和双栈回退
+*/
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// DefaultDialTimeout 默认连接超时
+const DefaultDialTimeout = 30 * time.Second
+
+// Dialer TCP拨号器, 零值可用
+type Dialer struct {
+	// Timeout 建立连接的超时, 0 使用 DefaultDialTimeout, 负数表示不限制
+	Timeout time.Duration
+	// KeepAlive keepalive 探测配置
+	KeepAlive KeepAlive
+	// LocalAddr 绑定的本地地址, 可为 "ip" 或 "ip:port"
+	LocalAddr string
+	// FallbackDelay 双栈 (Happy Eyeballs) 回退前等待 IPv6 的时间, 0 使用默认 300ms, 负数关闭双栈回退
+	FallbackDelay time.Duration
+	// Resolver 域名解析器, 为空时使用系统默认
+	Resolver *net.Resolver
+	// TLS DialTLSContext 使用的 TLS 配置, 复用同一实例才能共享会话缓存
+	TLS *TLSOptions
+	// SocketOptions 连接建立前后应用的 socket 选项
+	SocketOptions *SocketOptions
+	// Throttle 非空时对拨出的连接限速
+	Throttle *Throttle
+	// Tap 非空时按采样率捕获连接收发的原始字节
+	Tap *Tap
+	// Hooks 连接生命周期钩子
+	Hooks *Hooks
+}
+
+// DialContext 建立连接, ctx 取消时立即返回
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (*Conn, error) {
+	nd, err := d.netDialer(network)
+	if err != nil {
+		return nil, err
+	}
+	nc, err := nd.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, ctxError("dial", ctx, err)
+	}
+	// 运行时在连接建立后会重新开启 TCP_NODELAY, 需要再应用一次
+	if err := d.SocketOptions.Apply(nc); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	nc = d.Tap.Wrap(nc)
+	if d.Throttle != nil {
+		nc = NewThrottledConn(nc, d.Throttle)
+	}
+	c := NewConn(nc)
+	attachHooks(c, d.Hooks)
+	return c, nil
+}
+
+// Dial 建立连接
+func (d *Dialer) Dial(network, addr string) (*Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialFunc 返回返回值为 net.Conn 的拨号函数, 便于接入只接受标准签名的组件
+func (d *Dialer) DialFunc() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+func (d *Dialer) netDialer(network string) (*net.Dialer, error) {
+	nd := &net.Dialer{
+		Timeout:         d.Timeout,
+		FallbackDelay:   d.FallbackDelay,
+		Resolver:        d.Resolver,
+		KeepAliveConfig: d.KeepAlive.config(),
+	}
+	if nd.Timeout == 0 {
+		nd.Timeout = DefaultDialTimeout
+	} else if nd.Timeout < 0 {
+		nd.Timeout = 0
+	}
+	if d.SocketOptions != nil {
+		nd.Control = d.SocketOptions.Control
+	}
+	if d.KeepAlive.Disable {
+		nd.KeepAlive = -1
+	}
+	if d.LocalAddr != "" {
+		laddr, err := localAddr(network, d.LocalAddr)
+		if err != nil {
+			return nil, err
+		}
+		nd.LocalAddr = laddr
+	}
+	return nd, nil
+}
+
+func localAddr(network, addr string) (net.Addr, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "0")
+	}
+	switch network {
+	case "unix", "unixgram", "unixpacket":
+		return net.ResolveUnixAddr(network, addr)
+	case "udp", "udp4", "udp6":
+		return net.ResolveUDPAddr(network, addr)
+	}
+	return net.ResolveTCPAddr("tcp", addr)
+}
@@ -0,0 +1,64 @@
+package tcp_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	httptest "github.com/narcilee7/http-stack/internal/testing"
+	"github.com/narcilee7/http-stack/pkg/tcp"
+)
+
+func TestDialerUsesResolver(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Write([]byte("hi"))
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	r := httptest.NewMockResolver()
+	defer r.Close()
+	r.SetHost("backend.test", time.Minute, "127.0.0.1")
+	d := &tcp.Dialer{Resolver: r.Resolver(), FallbackDelay: -1}
+
+	c, err := d.DialContext(context.Background(), "tcp4", net.JoinHostPort("backend.test", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Read(buf); err != nil || string(buf) != "hi" {
+		t.Fatalf("read = %q, %v", buf, err)
+	}
+	c.Close()
+	if r.Queries("backend.test") == 0 {
+		t.Fatal("dialer bypassed the resolver")
+	}
+
+	r.Fail("backend.test", httptest.DNSNXDomain)
+	_, err = d.DialContext(context.Background(), "tcp4", net.JoinHostPort("backend.test", port))
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Fatalf("dial with NXDOMAIN = %v", err)
+	}
+
+	r.Fail("backend.test", httptest.DNSTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := d.DialContext(ctx, "tcp4", net.JoinHostPort("backend.test", port)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("dial with DNS timeout = %v, want DeadlineExceeded", err)
+	}
+}
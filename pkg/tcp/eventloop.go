@@ -0,0 +1,126 @@
+package tcp
+
+/*
+	事件循环模式 (实验性): 基于 epoll/kqueue 的就绪通知, 少量 goroutine 承载大量空闲连接.
+	数据以回调方式交付, 适合配合增量解析器使用; 不支持的平台返回 ErrEventLoopUnsupported
+*/
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrEventLoopUnsupported 当前平台不支持事件循环模式
+var ErrEventLoopUnsupported = errors.New("tcp: event loop not supported on this platform")
+
+// ErrWriteBufferFull 连接待发送数据超过上限
+var ErrWriteBufferFull = errors.New("tcp: event conn write buffer full")
+
+// 事件循环默认参数
+const (
+	DefaultEventReadBuffer = 64 << 10
+	DefaultMaxPendingWrite = 4 << 20
+)
+
+// EventHandler 事件循环回调. 同一连接的 OnData 在所属循环的 goroutine 中串行调用,
+// 回调不应阻塞, 否则会拖慢该循环上的所有连接
+type EventHandler interface {
+	// OnOpen 连接加入事件循环前调用
+	OnOpen(c *EventConn)
+	// OnData 收到数据时调用, data 仅在调用期间有效
+	OnData(c *EventConn, data []byte)
+	// OnClose 连接关闭后调用, 对端正常关闭时 err 为 io.EOF
+	OnClose(c *EventConn, err error)
+}
+
+// EventLoop 就绪通知驱动的服务器
+type EventLoop struct {
+	// Handler 事件回调
+	Handler EventHandler
+	// Loops 事件循环数量, 默认 GOMAXPROCS
+	Loops int
+	// ReadBufferSize 每个循环共享的读缓冲大小
+	ReadBufferSize int
+	// MaxPendingWrite 单个连接待发送数据上限, 超出时关闭连接
+	MaxPendingWrite int
+	// MemoryBudget 非空时待发送数据计入预算; 全局预算用尽时拒绝新连接, 超出预算的连接被关闭
+	MemoryBudget *MemoryBudget
+
+	mu      sync.Mutex
+	pollers []*poller
+	lns     []net.Listener
+	closed  atomic.Bool
+	conns   atomic.Int64
+	next    atomic.Uint64
+	wg      sync.WaitGroup
+}
+
+// EventConn 事件循环中的连接, Write 和 Close 可在任意 goroutine 调用
+type EventConn struct {
+	fd     int
+	p      *poller
+	el     *EventLoop
+	local  net.Addr
+	remote net.Addr
+
+	mem *MemoryAccount
+
+	mu      sync.Mutex
+	pending []byte
+	closed  bool
+	// draining CloseAfterWrite 之后为 true, 待发送数据写完即关闭
+	draining bool
+	ctx      any
+}
+
+// Memory 返回连接的内存账户, 未设置预算时为 nil
+func (c *EventConn) Memory() *MemoryAccount { return c.mem }
+
+// LocalAddr 返回本地地址
+func (c *EventConn) LocalAddr() net.Addr { return c.local }
+
+// RemoteAddr 返回对端地址
+func (c *EventConn) RemoteAddr() net.Addr { return c.remote }
+
+// SetContext 附加任意状态 (如增量解析器), 避免以连接为键的外部映射
+func (c *EventConn) SetContext(v any) {
+	c.mu.Lock()
+	c.ctx = v
+	c.mu.Unlock()
+}
+
+// Context 返回 SetContext 附加的状态
+func (c *EventConn) Context() any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ctx
+}
+
+// ActiveConns 返回事件循环中的连接数
+func (el *EventLoop) ActiveConns() int64 {
+	return el.conns.Load()
+}
+
+func (el *EventLoop) loops() int {
+	if el.Loops > 0 {
+		return el.Loops
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+func (el *EventLoop) readBufferSize() int {
+	if el.ReadBufferSize > 0 {
+		return el.ReadBufferSize
+	}
+	return DefaultEventReadBuffer
+}
+
+func (el *EventLoop) maxPending() int {
+	if el.MaxPendingWrite > 0 {
+		return el.MaxPendingWrite
+	}
+	return DefaultMaxPendingWrite
+}
@@ -0,0 +1,78 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package tcp
+
+import "syscall"
+
+// poller 基于 kqueue 的就绪通知
+type poller struct {
+	pollerBase
+	kq  int
+	raw []syscall.Kevent_t
+}
+
+func newPoller() (*poller, error) {
+	kq, err := syscall.Kqueue()
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(kq)
+	p := &poller{kq: kq}
+	if err := p.initWake(); err != nil {
+		syscall.Close(kq)
+		return nil, err
+	}
+	if err := p.add(p.wakeR); err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *poller) ctl(fd, filter, flags int) error {
+	var ev [1]syscall.Kevent_t
+	syscall.SetKevent(&ev[0], fd, filter, flags)
+	_, err := syscall.Kevent(p.kq, ev[:], nil, nil)
+	return err
+}
+
+func (p *poller) add(fd int) error {
+	return p.ctl(fd, syscall.EVFILT_READ, syscall.EV_ADD)
+}
+
+func (p *poller) watchWrite(fd int, on bool) error {
+	if on {
+		return p.ctl(fd, syscall.EVFILT_WRITE, syscall.EV_ADD|syscall.EV_ENABLE)
+	}
+	return p.ctl(fd, syscall.EVFILT_WRITE, syscall.EV_DELETE)
+}
+
+// del 关闭描述符时 kqueue 会自动移除相关事件, 这里只做显式清理
+func (p *poller) del(fd int) error {
+	p.ctl(fd, syscall.EVFILT_WRITE, syscall.EV_DELETE)
+	return p.ctl(fd, syscall.EVFILT_READ, syscall.EV_DELETE)
+}
+
+func (p *poller) wait(events []pollEvent) (int, error) {
+	if len(p.raw) < len(events) {
+		p.raw = make([]syscall.Kevent_t, len(events))
+	}
+	n, err := syscall.Kevent(p.kq, nil, p.raw[:len(events)], nil)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < n; i++ {
+		ev := p.raw[i]
+		events[i] = pollEvent{
+			fd:       int(ev.Ident),
+			readable: ev.Filter == syscall.EVFILT_READ,
+			writable: ev.Filter == syscall.EVFILT_WRITE,
+		}
+	}
+	return n, nil
+}
+
+func (p *poller) close() {
+	p.closeWake()
+	syscall.Close(p.kq)
+}
@@ -0,0 +1,69 @@
+package tcp
+
+import "syscall"
+
+// poller 基于 epoll 的就绪通知, 水平触发
+type poller struct {
+	pollerBase
+	epfd int
+	raw  []syscall.EpollEvent
+}
+
+func newPoller() (*poller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	p := &poller{epfd: epfd}
+	if err := p.initWake(); err != nil {
+		syscall.Close(epfd)
+		return nil, err
+	}
+	if err := p.add(p.wakeR); err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *poller) add(fd int) error {
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP, Fd: int32(fd)}
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, fd, &ev)
+}
+
+func (p *poller) watchWrite(fd int, on bool) error {
+	events := uint32(syscall.EPOLLIN | syscall.EPOLLRDHUP)
+	if on {
+		events |= syscall.EPOLLOUT
+	}
+	ev := syscall.EpollEvent{Events: events, Fd: int32(fd)}
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_MOD, fd, &ev)
+}
+
+func (p *poller) del(fd int) error {
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
+func (p *poller) wait(events []pollEvent) (int, error) {
+	if len(p.raw) < len(events) {
+		p.raw = make([]syscall.EpollEvent, len(events))
+	}
+	n, err := syscall.EpollWait(p.epfd, p.raw[:len(events)], -1)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < n; i++ {
+		ev := p.raw[i].Events
+		events[i] = pollEvent{
+			fd:       int(p.raw[i].Fd),
+			readable: ev&(syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLHUP|syscall.EPOLLERR) != 0,
+			writable: ev&syscall.EPOLLOUT != 0,
+		}
+	}
+	return n, nil
+}
+
+func (p *poller) close() {
+	p.closeWake()
+	syscall.Close(p.epfd)
+}
@@ -0,0 +1,33 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package tcp
+
+import "net"
+
+// Serve 当前平台不支持事件循环模式
+func (el *EventLoop) Serve(ln net.Listener) error {
+	return ErrEventLoopUnsupported
+}
+
+// Close 当前平台无需清理
+func (el *EventLoop) Close() error {
+	el.closed.Store(true)
+	return nil
+}
+
+// Write 当前平台不支持
+func (c *EventConn) Write(p []byte) (int, error) {
+	return 0, ErrEventLoopUnsupported
+}
+
+// Close 当前平台不支持
+func (c *EventConn) Close() error {
+	return ErrEventLoopUnsupported
+}
+
+// CloseAfterWrite 当前平台不支持
+func (c *EventConn) CloseAfterWrite() error {
+	return ErrEventLoopUnsupported
+}
+
+type poller struct{}
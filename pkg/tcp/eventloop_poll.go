@@ -0,0 +1,352 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package tcp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// pollEvent 一次就绪通知
+type pollEvent struct {
+	fd       int
+	readable bool
+	writable bool
+}
+
+// Serve 在 ln 上接受 TCP 连接并交给事件循环处理, 直到 ln 或事件循环关闭
+func (el *EventLoop) Serve(ln net.Listener) error {
+	if el.Handler == nil {
+		return errors.New("tcp: nil event handler")
+	}
+	if err := el.start(ln); err != nil {
+		return err
+	}
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			if el.closed.Load() {
+				return ErrServerClosed
+			}
+			if isTimeout(err) || isTemporary(err) {
+				continue
+			}
+			return err
+		}
+		if err := el.register(nc); err != nil {
+			nc.Close()
+		}
+	}
+}
+
+func (el *EventLoop) start(ln net.Listener) error {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	if el.closed.Load() {
+		return ErrServerClosed
+	}
+	el.lns = append(el.lns, ln)
+	if el.pollers != nil {
+		return nil
+	}
+	for i := 0; i < el.loops(); i++ {
+		p, err := newPoller()
+		if err != nil {
+			for _, p := range el.pollers {
+				p.close()
+			}
+			el.pollers = nil
+			return err
+		}
+		p.conns = make(map[int]*EventConn)
+		el.pollers = append(el.pollers, p)
+		el.wg.Add(1)
+		go el.run(p)
+	}
+	return nil
+}
+
+// register 复制连接的描述符并交给某个循环, 原 net.Conn 随即关闭
+func (el *EventLoop) register(nc net.Conn) error {
+	if el.MemoryBudget.Exhausted() {
+		return &BudgetError{Scope: "global", Limit: el.MemoryBudget.Global}
+	}
+	tc, ok := unwrapTCP(nc)
+	if !ok || tc != nc {
+		return ErrEventLoopUnsupported
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	fd := -1
+	var derr error
+	if err := rc.Control(func(s uintptr) { fd, derr = syscall.Dup(int(s)) }); err != nil {
+		return err
+	}
+	if derr != nil {
+		return derr
+	}
+	local, remote := nc.LocalAddr(), nc.RemoteAddr()
+	nc.Close()
+	syscall.CloseOnExec(fd)
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+
+	p := el.pollers[el.next.Add(1)%uint64(len(el.pollers))]
+	c := &EventConn{fd: fd, p: p, el: el, local: local, remote: remote}
+	if el.MemoryBudget != nil {
+		c.mem = el.MemoryBudget.NewAccount(nil)
+	}
+	el.Handler.OnOpen(c)
+	p.mu.Lock()
+	p.conns[fd] = c
+	p.mu.Unlock()
+	el.conns.Add(1)
+	if err := p.add(fd); err != nil {
+		c.closeWith(err)
+	}
+	return nil
+}
+
+func (el *EventLoop) run(p *poller) {
+	defer el.wg.Done()
+	buf := make([]byte, el.readBufferSize())
+	events := make([]pollEvent, 128)
+	for !el.closed.Load() {
+		n, err := p.wait(events)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return
+		}
+		for _, ev := range events[:n] {
+			if ev.fd == p.wakeR {
+				p.drainWake()
+				continue
+			}
+			p.mu.Lock()
+			c := p.conns[ev.fd]
+			p.mu.Unlock()
+			if c == nil {
+				continue
+			}
+			if ev.writable {
+				c.flush()
+			}
+			if ev.readable {
+				c.readReady(buf)
+			}
+		}
+	}
+}
+
+func (c *EventConn) readReady(buf []byte) {
+	// 持锁读取, 防止并发 Close 后描述符被复用导致读到其他连接的数据
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	n, err := syscall.Read(c.fd, buf)
+	c.mu.Unlock()
+	switch {
+	case n > 0:
+		c.el.Handler.OnData(c, buf[:n])
+	case err == syscall.EAGAIN || err == syscall.EINTR:
+		// 描述符被复用后的陈旧事件
+	case err != nil:
+		c.closeWith(err)
+	default:
+		c.closeWith(io.EOF)
+	}
+}
+
+// Write 立即尝试写出, 写不完的部分排队等待可写通知; 返回值总是 len(p) 或错误
+func (c *EventConn) Write(p []byte) (int, error) {
+	total := len(p)
+	c.mu.Lock()
+	if c.closed || c.draining {
+		c.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	if len(c.pending) == 0 {
+		n, err := syscall.Write(c.fd, p)
+		if err != nil && err != syscall.EAGAIN {
+			c.mu.Unlock()
+			c.closeWith(err)
+			return 0, err
+		}
+		if n < 0 {
+			n = 0
+		}
+		p = p[n:]
+		if len(p) == 0 {
+			c.mu.Unlock()
+			return total, nil
+		}
+	}
+	if len(c.pending)+len(p) > c.el.maxPending() {
+		c.mu.Unlock()
+		c.closeWith(ErrWriteBufferFull)
+		return 0, ErrWriteBufferFull
+	}
+	if err := c.mem.Reserve(int64(len(p))); err != nil {
+		c.mu.Unlock()
+		c.closeWith(err)
+		return 0, err
+	}
+	if len(c.pending) == 0 {
+		// 在锁内切换关注事件, 与 flush 的关闭操作保持顺序
+		c.p.watchWrite(c.fd, true)
+	}
+	c.pending = append(c.pending, p...)
+	c.mu.Unlock()
+	return total, nil
+}
+
+func (c *EventConn) flush() {
+	c.mu.Lock()
+	if c.closed || len(c.pending) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	n, err := syscall.Write(c.fd, c.pending)
+	if err != nil && err != syscall.EAGAIN {
+		c.mu.Unlock()
+		c.closeWith(err)
+		return
+	}
+	if n > 0 {
+		c.pending = c.pending[n:]
+		c.mem.Release(int64(n))
+	}
+	done := false
+	if len(c.pending) == 0 {
+		c.pending = nil
+		c.p.watchWrite(c.fd, false)
+		done = c.draining
+	}
+	c.mu.Unlock()
+	if done {
+		c.closeWith(nil)
+	}
+}
+
+// Close 关闭连接, 未发送的数据被丢弃
+func (c *EventConn) Close() error {
+	c.closeWith(nil)
+	return nil
+}
+
+// CloseAfterWrite 不再接受写入, 待发送数据全部写出后关闭连接; 没有待发送数据时立即关闭
+func (c *EventConn) CloseAfterWrite() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.draining = true
+	empty := len(c.pending) == 0
+	c.mu.Unlock()
+	if empty {
+		c.closeWith(nil)
+	}
+	return nil
+}
+
+func (c *EventConn) closeWith(err error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	c.pending = nil
+	c.mem.Close()
+	c.mu.Unlock()
+
+	c.p.mu.Lock()
+	delete(c.p.conns, c.fd)
+	c.p.mu.Unlock()
+	c.p.del(c.fd)
+	syscall.Close(c.fd)
+	c.el.conns.Add(-1)
+	c.el.Handler.OnClose(c, err)
+}
+
+// Close 关闭监听器、事件循环和所有连接
+func (el *EventLoop) Close() error {
+	el.mu.Lock()
+	if el.closed.Swap(true) {
+		el.mu.Unlock()
+		return nil
+	}
+	for _, ln := range el.lns {
+		ln.Close()
+	}
+	pollers := el.pollers
+	el.mu.Unlock()
+
+	for _, p := range pollers {
+		p.wake()
+	}
+	el.wg.Wait()
+	for _, p := range pollers {
+		p.mu.Lock()
+		conns := make([]*EventConn, 0, len(p.conns))
+		for _, c := range p.conns {
+			conns = append(conns, c)
+		}
+		p.mu.Unlock()
+		for _, c := range conns {
+			c.closeWith(net.ErrClosed)
+		}
+		p.close()
+	}
+	return nil
+}
+
+// pollerBase 各平台 poller 共用的唤醒管道和连接表
+type pollerBase struct {
+	wakeR, wakeW int
+	mu           sync.Mutex
+	conns        map[int]*EventConn
+}
+
+func (b *pollerBase) initWake() error {
+	var fds [2]int
+	if err := syscall.Pipe(fds[:]); err != nil {
+		return err
+	}
+	for _, fd := range fds {
+		syscall.CloseOnExec(fd)
+		syscall.SetNonblock(fd, true)
+	}
+	b.wakeR, b.wakeW = fds[0], fds[1]
+	return nil
+}
+
+func (b *pollerBase) wake() {
+	syscall.Write(b.wakeW, []byte{0})
+}
+
+func (b *pollerBase) drainWake() {
+	var buf [64]byte
+	for {
+		if n, _ := syscall.Read(b.wakeR, buf[:]); n <= 0 {
+			return
+		}
+	}
+}
+
+func (b *pollerBase) closeWake() {
+	syscall.Close(b.wakeR)
+	syscall.Close(b.wakeW)
+}
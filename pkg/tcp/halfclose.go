@@ -0,0 +1,81 @@
+package tcp
+
+/*
+	半关闭支持: 关闭写方向发送 FIN (TLS 先发送 close_notify), 关闭读方向停止接收
+*/
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+)
+
+// ErrHalfCloseUnsupported 底层连接不支持半关闭
+var ErrHalfCloseUnsupported = errors.New("tcp: half-close not supported")
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+type closeReader interface {
+	CloseRead() error
+}
+
+// CloseWrite 关闭写方向, 对端读到 EOF 后仍可继续发送数据
+func (c *Conn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
+
+// CloseRead 关闭读方向
+func (c *Conn) CloseRead() error {
+	return closeRead(c.Conn)
+}
+
+// CloseWrite 刷出写缓冲后关闭写方向
+func (bc *BufferedConn) CloseWrite() error {
+	if err := bc.Flush(); err != nil {
+		return err
+	}
+	return bc.Conn.CloseWrite()
+}
+
+// CloseWrite 关闭 c 的写方向; TLS 连接先发送 close_notify, 再对底层连接发送 FIN
+func CloseWrite(c net.Conn) error {
+	return closeWrite(c)
+}
+
+// CloseRead 关闭 c 的读方向; TLS 连接直接作用于底层连接
+func CloseRead(c net.Conn) error {
+	return closeRead(c)
+}
+
+func closeWrite(c net.Conn) error {
+	switch v := c.(type) {
+	case *tls.Conn:
+		if err := v.CloseWrite(); err != nil {
+			return err
+		}
+		// close_notify 之后不会再写, 底层发送 FIN 让不解析 TLS 的中间层也能感知结束
+		if err := closeWrite(v.NetConn()); err != nil && !errors.Is(err, ErrHalfCloseUnsupported) {
+			return err
+		}
+		return nil
+	case closeWriter:
+		return v.CloseWrite()
+	case interface{ Unwrap() net.Conn }:
+		return closeWrite(v.Unwrap())
+	}
+	return ErrHalfCloseUnsupported
+}
+
+func closeRead(c net.Conn) error {
+	switch v := c.(type) {
+	case *tls.Conn:
+		return closeRead(v.NetConn())
+	case closeReader:
+		return v.CloseRead()
+	case interface{ Unwrap() net.Conn }:
+		return closeRead(v.Unwrap())
+	}
+	return ErrHalfCloseUnsupported
+}
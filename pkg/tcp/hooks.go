@@ -0,0 +1,76 @@
+package tcp
+
+/*
+	连接生命周期钩子, 用于指标、日志和安全审计观察每一个连接
+*/
+
+// Hooks 连接事件回调, 各字段均可为空.
+// 回调在读写所在的 goroutine 中同步执行, 不应阻塞;
+// TLS 连接上观察到的是线路上的密文字节数
+type Hooks struct {
+	// OnOpen 连接建立 (拨号成功或通过接入检查) 后调用
+	OnOpen func(c *Conn)
+	// OnClose 连接首次关闭时调用, 此时统计数据已是最终值
+	OnClose func(c *Conn)
+	// OnRead 每次 Read 返回后调用
+	OnRead func(c *Conn, n int, err error)
+	// OnWrite 每次 Write 返回后调用
+	OnWrite func(c *Conn, n int, err error)
+}
+
+// ChainHooks 合并多组钩子, 同一事件按参数顺序依次调用
+func ChainHooks(hs ...*Hooks) *Hooks {
+	var list []*Hooks
+	for _, h := range hs {
+		if h != nil {
+			list = append(list, h)
+		}
+	}
+	switch len(list) {
+	case 0:
+		return nil
+	case 1:
+		return list[0]
+	}
+	return &Hooks{
+		OnOpen: func(c *Conn) {
+			for _, h := range list {
+				if h.OnOpen != nil {
+					h.OnOpen(c)
+				}
+			}
+		},
+		OnClose: func(c *Conn) {
+			for _, h := range list {
+				if h.OnClose != nil {
+					h.OnClose(c)
+				}
+			}
+		},
+		OnRead: func(c *Conn, n int, err error) {
+			for _, h := range list {
+				if h.OnRead != nil {
+					h.OnRead(c, n, err)
+				}
+			}
+		},
+		OnWrite: func(c *Conn, n int, err error) {
+			for _, h := range list {
+				if h.OnWrite != nil {
+					h.OnWrite(c, n, err)
+				}
+			}
+		},
+	}
+}
+
+// attachHooks 为新连接挂载钩子并触发 OnOpen
+func attachHooks(c *Conn, h *Hooks) {
+	if h == nil {
+		return
+	}
+	c.hooks = h
+	if h.OnOpen != nil {
+		h.OnOpen(c)
+	}
+}
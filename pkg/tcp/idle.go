@@ -0,0 +1,96 @@
+package tcp
+
+/*
+	空闲超时, 基于时间轮检查最后活动时间, 空闲超过阈值的连接被关闭
+*/
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// idleWatcher 在时间轮上周期检查连接的空闲时间
+type idleWatcher struct {
+	c       *Conn
+	wheel   *TimerWheel
+	timeout atomic.Int64
+	expired atomic.Bool
+
+	mu      sync.Mutex
+	timer   *WheelTimer
+	stopped bool
+}
+
+func watchIdle(c *Conn, timeout time.Duration, wheel *TimerWheel) *idleWatcher {
+	if wheel == nil {
+		wheel = DefaultTimerWheel()
+	}
+	iw := &idleWatcher{c: c, wheel: wheel}
+	iw.timeout.Store(int64(timeout))
+	iw.schedule(timeout)
+	return iw
+}
+
+func (iw *idleWatcher) schedule(d time.Duration) {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+	if iw.stopped {
+		return
+	}
+	iw.timer = iw.wheel.AfterFunc(d, iw.check)
+}
+
+// check 在时间轮 goroutine 中执行, 只做判断, 关闭放到新 goroutine 以免 TLS 关闭阻塞时间轮
+func (iw *idleWatcher) check() {
+	timeout := time.Duration(iw.timeout.Load())
+	idle := iw.c.IdleFor()
+	if idle < timeout {
+		iw.schedule(timeout - idle)
+		return
+	}
+	iw.expired.Store(true)
+	iw.stop()
+	go iw.c.Close()
+}
+
+func (iw *idleWatcher) stop() {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+	iw.stopped = true
+	if iw.timer != nil {
+		iw.timer.Stop()
+	}
+}
+
+// IdleConn 空闲超过阈值后自动关闭的连接, 读写不会操作定时器
+type IdleConn struct {
+	*Conn
+	iw *idleWatcher
+}
+
+// NewIdleConn 使用共享时间轮监视 c 的空闲时间
+func NewIdleConn(c *Conn, timeout time.Duration) *IdleConn {
+	return NewIdleConnWheel(c, timeout, nil)
+}
+
+// NewIdleConnWheel 使用指定的时间轮监视 c, wheel 为空时使用共享时间轮
+func NewIdleConnWheel(c *Conn, timeout time.Duration, wheel *TimerWheel) *IdleConn {
+	return &IdleConn{Conn: c, iw: watchIdle(c, timeout, wheel)}
+}
+
+// SetIdleTimeout 修改空闲阈值, 在下一次检查时生效
+func (ic *IdleConn) SetIdleTimeout(d time.Duration) {
+	ic.iw.timeout.Store(int64(d))
+}
+
+// Expired 报告连接是否因空闲超时被关闭
+func (ic *IdleConn) Expired() bool {
+	return ic.iw.expired.Load()
+}
+
+// Close 停止监视并关闭连接
+func (ic *IdleConn) Close() error {
+	ic.iw.stop()
+	return ic.Conn.Close()
+}
@@ -1 +1,63 @@
 package tcp
+
+/*
+	TCP Keep-Alive 配置
+*/
+
+import (
+	"net"
+	"time"
+)
+
+// 默认 keepalive 参数, 与 net 包保持一致
+const (
+	DefaultKeepAliveIdle     = 15 * time.Second
+	DefaultKeepAliveInterval = 15 * time.Second
+	DefaultKeepAliveCount    = 9
+)
+
+// KeepAlive TCP keepalive 探测配置, 零值表示启用并使用默认参数
+type KeepAlive struct {
+	// Disable 关闭 keepalive 探测
+	Disable bool
+	// Idle 连接空闲多久后开始探测
+	Idle time.Duration
+	// Interval 探测间隔
+	Interval time.Duration
+	// Count 连续多少次探测无响应后断开
+	Count int
+}
+
+func (k KeepAlive) config() net.KeepAliveConfig {
+	if k.Disable {
+		return net.KeepAliveConfig{Enable: false}
+	}
+	cfg := net.KeepAliveConfig{
+		Enable:   true,
+		Idle:     k.Idle,
+		Interval: k.Interval,
+		Count:    k.Count,
+	}
+	if cfg.Idle <= 0 {
+		cfg.Idle = DefaultKeepAliveIdle
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultKeepAliveInterval
+	}
+	if cfg.Count <= 0 {
+		cfg.Count = DefaultKeepAliveCount
+	}
+	return cfg
+}
+
+// SetKeepAlive 对已建立的 TCP 连接应用 keepalive 配置, 非 TCP 连接直接忽略
+func SetKeepAlive(c net.Conn, k KeepAlive) error {
+	if conn, ok := c.(*Conn); ok {
+		c = conn.Conn
+	}
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	return tc.SetKeepAliveConfig(k.config())
+}
@@ -1 +1,565 @@
 package tcp
+
+/*
+	TCP监听器, 负责 accept 循环、接入限流、单 IP 连接数限制和连接分发
+*/
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/log"
+	"github.com/narcilee7/http-stack/pkg/metrics"
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// ErrServerClosed 服务器已关闭
+var ErrServerClosed = errors.New("tcp: server closed")
+
+// Handler 处理一个已接入的连接, ServeConn 返回后连接会被关闭
+type Handler interface {
+	ServeConn(c *Conn)
+}
+
+// HandlerFunc 将函数适配为 Handler
+type HandlerFunc func(c *Conn)
+
+func (f HandlerFunc) ServeConn(c *Conn) { f(c) }
+
+// Server TCP服务器
+type Server struct {
+	// Addr 监听地址, 如 ":8080"
+	Addr string
+	// Addrs 额外的监听地址, 与 Addr 共享同一生命周期; IPv4/IPv6 字面量地址只监听对应协议族
+	Addrs []string
+	// Handler 连接处理器
+	Handler Handler
+	// MaxConnsPerIP 单个来源 IP 的最大并发连接数, 0 表示不限制
+	MaxConnsPerIP int
+	// AcceptRate 每秒允许接入的新连接数, 0 表示不限制
+	AcceptRate float64
+	// AcceptBurst 接入限流的突发容量, 默认与 AcceptRate 相同
+	AcceptBurst int
+	// AcceptRatePerIP 单个来源 IP 每秒允许接入的新连接数, 0 表示不限制
+	AcceptRatePerIP float64
+	// AcceptBurstPerIP 单 IP 接入限流的突发容量, 默认与 AcceptRatePerIP 相同
+	AcceptBurstPerIP int
+	// GracefulReject 为 true 时正常关闭被拒绝的连接; 默认发送 RST 立即释放资源
+	GracefulReject bool
+	// OnReject 连接被拒绝时调用
+	OnReject func(addr net.Addr, reason RejectReason)
+	// TLS 非空时接入的连接先完成 TLS 握手再交给处理器
+	TLS *TLSOptions
+	// SocketOptions 应用于监听 socket 和每个接入连接的选项, ReusePort 可用于多进程共享端口
+	SocketOptions *SocketOptions
+	// IdleTimeout 连接空闲超过该时间后关闭, 0 表示不限制
+	IdleTimeout time.Duration
+	// Throttle 非空时对每个接入连接限速
+	Throttle *Throttle
+	// Tap 非空时按采样率捕获连接收发的原始字节
+	Tap *Tap
+	// MemoryBudget 非空时为每个连接建立内存账户; 全局预算用尽时拒绝新连接, 单连接超限时关闭该连接
+	MemoryBudget *MemoryBudget
+	// Hooks 连接生命周期钩子
+	Hooks *Hooks
+	// OnPanic 处理器 panic 时调用, 为空时以 Error 级别记录到 Logger
+	OnPanic func(c *Conn, v any, stack []byte)
+	// Logger 记录 panic、accept 错误等事件, 为空时使用 log.Default()
+	Logger *log.Logger
+	// Metrics 非空时向其上报按监听地址划分的接入、拒绝、连接数和字节数指标
+	Metrics *metrics.Registry
+
+	mu        sync.Mutex
+	listeners map[net.Listener]*listenerState
+	metrics   *serverMetrics
+	conns     map[*Conn]*listenerState
+	perIP     map[string]int
+	limiter   *utils.RateLimiter
+	ipLimits  map[string]*utils.RateLimiter
+	lastPrune time.Time
+	closed    atomic.Bool
+	wg        sync.WaitGroup
+	rejected  atomic.Uint64
+}
+
+// ListenerStats 单个监听器的统计
+type ListenerStats struct {
+	Addr     string
+	Accepted uint64
+	Rejected uint64
+	Active   int64
+}
+
+type listenerState struct {
+	addr     string
+	accepted *metrics.Counter
+	rejected map[RejectReason]*metrics.Counter
+	active   *metrics.Gauge
+	read     *metrics.Counter
+	written  *metrics.Counter
+}
+
+func newListenerState(addr string, m *serverMetrics) *listenerState {
+	ls := &listenerState{
+		addr:     addr,
+		accepted: m.accepted.With(addr),
+		rejected: make(map[RejectReason]*metrics.Counter, len(rejectReasons)),
+		active:   m.active.With(addr),
+		read:     m.read.With(addr),
+		written:  m.written.With(addr),
+	}
+	for _, r := range rejectReasons {
+		ls.rejected[r] = m.rejected.With(addr, r.label())
+	}
+	return ls
+}
+
+// rejectedTotal 返回各原因拒绝数之和
+func (ls *listenerState) rejectedTotal() uint64 {
+	var n uint64
+	for _, c := range ls.rejected {
+		n += c.Value()
+	}
+	return n
+}
+
+// ListenAndServe 监听 s.Addr 和 s.Addrs 中的所有地址并开始服务, 任一地址监听失败时不会启动
+func (s *Server) ListenAndServe() error {
+	if s.closed.Load() {
+		return ErrServerClosed
+	}
+	addrs := s.Addrs
+	if s.Addr != "" || len(addrs) == 0 {
+		addrs = append([]string{s.Addr}, addrs...)
+	}
+	lc := net.ListenConfig{}
+	if s.SocketOptions != nil {
+		lc.Control = s.SocketOptions.Control
+	}
+	lns := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		if addr == "" {
+			addr = ":0"
+		}
+		ln, err := lc.Listen(context.Background(), listenNetwork(addr), addr)
+		if err != nil {
+			for _, l := range lns {
+				l.Close()
+			}
+			return err
+		}
+		lns = append(lns, ln)
+	}
+	return s.ServeListeners(lns...)
+}
+
+// ServeListeners 在多个监听器上同时服务; 某个监听器出错时关闭其余监听器并返回该错误,
+// 已建立的连接不受影响, 仍由 Shutdown/Close 统一管理
+func (s *Server) ServeListeners(lns ...net.Listener) error {
+	if len(lns) == 1 {
+		return s.Serve(lns[0])
+	}
+	errc := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func(ln net.Listener) { errc <- s.Serve(ln) }(ln)
+	}
+	var first error
+	for range lns {
+		err := <-errc
+		if first == nil {
+			first = err
+			if err != ErrServerClosed {
+				for _, ln := range lns {
+					ln.Close()
+				}
+			}
+		}
+	}
+	return first
+}
+
+// listenNetwork IPv4/IPv6 字面量地址使用对应协议族, 避免 "[::]" 的双栈监听与 "0.0.0.0" 冲突
+func listenNetwork(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return "tcp"
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "tcp"
+	case ip.To4() != nil:
+		return "tcp4"
+	}
+	return "tcp6"
+}
+
+// Serve 在 ln 上接受连接, 直到 ln 关闭或服务器关闭
+func (s *Server) Serve(ln net.Listener) error {
+	if s.Handler == nil {
+		return errors.New("tcp: nil handler")
+	}
+	ls := s.trackListener(ln, true)
+	if ls == nil {
+		return ErrServerClosed
+	}
+	defer s.trackListener(ln, false)
+
+	var backoff time.Duration
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			if s.closed.Load() {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() || isTemporary(err) {
+				backoff = nextBackoff(backoff)
+				log.Or(s.Logger).Warn("tcp: accept error", log.Err(err), log.Duration("retry", backoff))
+				time.Sleep(backoff)
+				continue
+			}
+			return err
+		}
+		backoff = 0
+
+		if err := s.SocketOptions.Apply(nc); err != nil {
+			log.Or(s.Logger).Debug("tcp: apply socket options", log.String("remote", nc.RemoteAddr().String()), log.Err(err))
+			nc.Close()
+			continue
+		}
+		nc = s.Tap.Wrap(nc)
+		if s.Throttle != nil {
+			nc = NewThrottledConn(nc, s.Throttle)
+		}
+		c := NewConn(nc)
+		if reason := s.admit(c, ls); reason != 0 {
+			s.reject(nc, reason, ls)
+			continue
+		}
+		if s.MemoryBudget != nil {
+			c.mem = s.MemoryBudget.NewAccount(func(error) { c.Close() })
+		}
+		attachHooks(c, s.Hooks)
+		s.wg.Add(1)
+		go s.serve(c)
+	}
+}
+
+// RejectReason 连接被拒绝的原因
+type RejectReason int
+
+const (
+	// RejectRate 超出全局接入速率
+	RejectRate RejectReason = iota + 1
+	// RejectIPRate 超出单 IP 接入速率
+	RejectIPRate
+	// RejectIPConns 超出单 IP 并发连接数
+	RejectIPConns
+	// RejectClosed 服务器正在关闭
+	RejectClosed
+	// RejectMemory 全局内存预算已用尽
+	RejectMemory
+)
+
+func (r RejectReason) String() string {
+	switch r {
+	case RejectRate:
+		return "accept rate exceeded"
+	case RejectIPRate:
+		return "per-ip accept rate exceeded"
+	case RejectIPConns:
+		return "per-ip connection limit exceeded"
+	case RejectClosed:
+		return "server closed"
+	case RejectMemory:
+		return "memory budget exhausted"
+	}
+	return "unknown"
+}
+
+// ipBucketPruneSize 单 IP 限流桶超过该数量时清理已回满的桶
+const ipBucketPruneSize = 1024
+
+// admit 检查接入限流和单 IP 连接数, 通过后登记连接; 返回 0 表示接入
+func (s *Server) admit(c *Conn, ls *listenerState) RejectReason {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed.Load() {
+		return RejectClosed
+	}
+	if s.MemoryBudget.Exhausted() {
+		return RejectMemory
+	}
+	now := time.Now()
+	if s.AcceptRate > 0 {
+		if s.limiter == nil {
+			s.limiter = utils.NewRateLimiter(s.AcceptRate, s.AcceptBurst)
+		}
+		if !s.limiter.AllowN(now, 1) {
+			return RejectRate
+		}
+	}
+	ip := remoteIP(c)
+	if s.MaxConnsPerIP > 0 && ip != "" && s.perIP[ip] >= s.MaxConnsPerIP {
+		return RejectIPConns
+	}
+	if s.AcceptRatePerIP > 0 && ip != "" && !s.allowIP(ip, now) {
+		return RejectIPRate
+	}
+	if s.conns == nil {
+		s.conns = make(map[*Conn]*listenerState)
+		s.perIP = make(map[string]int)
+	}
+	s.conns[c] = ls
+	ls.accepted.Inc()
+	ls.active.Inc()
+	if ip != "" {
+		s.perIP[ip]++
+	}
+	return 0
+}
+
+// allowIP 单 IP 令牌桶检查, 由调用方加锁
+func (s *Server) allowIP(ip string, now time.Time) bool {
+	if s.ipLimits == nil {
+		s.ipLimits = make(map[string]*utils.RateLimiter)
+	}
+	if len(s.ipLimits) >= ipBucketPruneSize && now.Sub(s.lastPrune) > time.Second {
+		s.lastPrune = now
+		for k, b := range s.ipLimits {
+			// 回满的桶与新建的桶等价, 可以丢弃
+			if b.Tokens() >= float64(b.Burst()) {
+				delete(s.ipLimits, k)
+			}
+		}
+	}
+	b := s.ipLimits[ip]
+	if b == nil {
+		b = utils.NewRateLimiter(s.AcceptRatePerIP, s.AcceptBurstPerIP)
+		s.ipLimits[ip] = b
+	}
+	return b.AllowN(now, 1)
+}
+
+// reject 拒绝连接; 默认设置 SO_LINGER=0 使关闭时发送 RST, 避免大量 TIME_WAIT 和半开连接
+func (s *Server) reject(nc net.Conn, reason RejectReason, ls *listenerState) {
+	s.rejected.Add(1)
+	if c := ls.rejected[reason]; c != nil {
+		c.Inc()
+	}
+	if s.OnReject != nil {
+		s.OnReject(nc.RemoteAddr(), reason)
+	}
+	log.Or(s.Logger).Debug("tcp: connection rejected", log.String("remote", nc.RemoteAddr().String()), log.String("reason", reason.String()))
+	if !s.GracefulReject {
+		if tc, ok := unwrapTCP(nc); ok {
+			tc.SetLinger(0)
+		}
+	}
+	nc.Close()
+}
+
+func (s *Server) serve(c *Conn) {
+	defer s.wg.Done()
+	defer s.release(c)
+	if s.IdleTimeout > 0 {
+		defer watchIdle(c, s.IdleTimeout, nil).stop()
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			stack := debug.Stack()
+			if s.OnPanic != nil {
+				s.OnPanic(c, v, stack)
+				return
+			}
+			log.Or(s.Logger).Error("tcp: panic serving connection",
+				log.String("remote", c.RemoteAddr().String()), log.Any("panic", v), log.String("stack", string(stack)))
+		}
+	}()
+	if s.TLS != nil {
+		tc, err := ServerHandshake(context.Background(), c, s.TLS)
+		if err != nil {
+			log.Or(s.Logger).Debug("tcp: TLS handshake failed", log.String("remote", c.RemoteAddr().String()), log.Err(err))
+			return
+		}
+		s.Handler.ServeConn(tc)
+		tc.Close()
+		return
+	}
+	s.Handler.ServeConn(c)
+}
+
+func (s *Server) release(c *Conn) {
+	c.Close()
+	s.mu.Lock()
+	if ls := s.conns[c]; ls != nil {
+		ls.active.Dec()
+		ls.read.Add(c.BytesRead())
+		ls.written.Add(c.BytesWritten())
+	}
+	delete(s.conns, c)
+	if ip := remoteIP(c); ip != "" {
+		if s.perIP[ip]--; s.perIP[ip] <= 0 {
+			delete(s.perIP, ip)
+		}
+	}
+	s.mu.Unlock()
+}
+
+// trackListener 登记或注销监听器, 登记成功返回其统计状态, 服务器已关闭时返回 nil
+func (s *Server) trackListener(ln net.Listener, add bool) *listenerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.listeners, ln)
+		return nil
+	}
+	if s.closed.Load() {
+		return nil
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]*listenerState)
+	}
+	if s.metrics == nil {
+		s.metrics = newServerMetrics(s.Metrics)
+	}
+	ls := newListenerState(addrString(ln.Addr()), s.metrics)
+	s.listeners[ln] = ls
+	return ls
+}
+
+// ListenAddrs 返回正在服务的监听地址, 监听 ":0" 时可据此获取实际端口
+func (s *Server) ListenAddrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for ln := range s.listeners {
+		addrs = append(addrs, ln.Addr())
+	}
+	return addrs
+}
+
+// ListenerStats 返回每个监听器的统计, 按地址排序
+func (s *Server) ListenerStats() []ListenerStats {
+	s.mu.Lock()
+	stats := make([]ListenerStats, 0, len(s.listeners))
+	for _, ls := range s.listeners {
+		stats = append(stats, ListenerStats{
+			Addr:     ls.addr,
+			Accepted: ls.accepted.Value(),
+			Rejected: ls.rejectedTotal(),
+			Active:   int64(ls.active.Value()),
+		})
+	}
+	s.mu.Unlock()
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Addr < stats[j].Addr })
+	return stats
+}
+
+// ActiveConns 返回当前连接数
+func (s *Server) ActiveConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// RangeConns 遍历当前连接, f 返回 false 时停止; 可配合 Conn.TCPInfo 采样网络质量
+func (s *Server) RangeConns(f func(c *Conn) bool) {
+	s.mu.Lock()
+	conns := make([]*Conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	for _, c := range conns {
+		if !f(c) {
+			return
+		}
+	}
+}
+
+// Rejected 返回因限流或连接数限制被拒绝的连接数
+func (s *Server) Rejected() uint64 {
+	return s.rejected.Load()
+}
+
+// Shutdown 停止接受新连接并等待现有连接处理完毕; ctx 结束时强制关闭剩余连接
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closeListeners()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.closeConns()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Close 立即关闭所有监听器和连接
+func (s *Server) Close() error {
+	s.closeListeners()
+	s.closeConns()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) closeListeners() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed.Store(true)
+	for ln := range s.listeners {
+		ln.Close()
+	}
+}
+
+func (s *Server) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+func remoteIP(c net.Conn) string {
+	addr := c.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	if ta, ok := addr.(*net.TCPAddr); ok {
+		return ta.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+func isTemporary(err error) bool {
+	var t interface{ Temporary() bool }
+	return errors.As(err, &t) && t.Temporary()
+}
+
+func nextBackoff(d time.Duration) time.Duration {
+	if d == 0 {
+		return 5 * time.Millisecond
+	}
+	if d *= 2; d > time.Second {
+		d = time.Second
+	}
+	return d
+}
@@ -0,0 +1,133 @@
+package tcp
+
+/*
+	内存预算: 统计每个连接占用的缓冲区 (读缓冲、解析状态、待发送数据), 超出单连接或全局上限时拒绝或关闭连接
+*/
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// BudgetError 申请内存超出预算
+type BudgetError struct {
+	// Scope "conn" 或 "global"
+	Scope     string
+	Requested int64
+	Limit     int64
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("tcp: %s memory budget exceeded: requested %d, limit %d", e.Scope, e.Requested, e.Limit)
+}
+
+// MemoryBudget 全局内存预算, 由所有连接的 MemoryAccount 共享
+type MemoryBudget struct {
+	// PerConn 单个连接的上限, 0 表示不限制
+	PerConn int64
+	// Global 所有连接合计的上限, 0 表示不限制
+	Global int64
+
+	used     atomic.Int64
+	exceeded atomic.Uint64
+}
+
+// Used 返回当前已占用的字节数
+func (b *MemoryBudget) Used() int64 { return b.used.Load() }
+
+// Exceeded 返回申请被拒绝的次数
+func (b *MemoryBudget) Exceeded() uint64 { return b.exceeded.Load() }
+
+// Exhausted 报告全局预算是否已用尽, 服务器据此拒绝新连接
+func (b *MemoryBudget) Exhausted() bool {
+	return b != nil && b.Global > 0 && b.used.Load() >= b.Global
+}
+
+// NewAccount 创建一个计入该预算的账户; onExceed 在申请失败时调用, 通常用于关闭连接
+func (b *MemoryBudget) NewAccount(onExceed func(err error)) *MemoryAccount {
+	return &MemoryAccount{b: b, onExceed: onExceed}
+}
+
+// MemoryAccount 单个连接的内存账户, nil 账户表示不做限制.
+// 账户关闭后 Reserve 和 Release 均不再计数, 避免连接关闭后迟到的归还扣减全局用量
+type MemoryAccount struct {
+	b        *MemoryBudget
+	onExceed func(err error)
+
+	mu     sync.Mutex
+	used   int64
+	closed bool
+}
+
+// Reserve 申请 n 字节, 超出预算时返回 *BudgetError 并触发 onExceed
+func (a *MemoryAccount) Reserve(n int64) error {
+	if a == nil || a.b == nil || n <= 0 {
+		return nil
+	}
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	var err error
+	if a.b.PerConn > 0 && a.used+n > a.b.PerConn {
+		err = &BudgetError{Scope: "conn", Requested: n, Limit: a.b.PerConn}
+	} else if total := a.b.used.Add(n); a.b.Global > 0 && total > a.b.Global {
+		a.b.used.Add(-n)
+		err = &BudgetError{Scope: "global", Requested: n, Limit: a.b.Global}
+	} else {
+		a.used += n
+	}
+	a.mu.Unlock()
+	if err != nil {
+		a.b.exceeded.Add(1)
+		if a.onExceed != nil {
+			a.onExceed(err)
+		}
+	}
+	return err
+}
+
+// Release 归还 n 字节, 不会超过已申请的数量
+func (a *MemoryAccount) Release(n int64) {
+	if a == nil || a.b == nil || n <= 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return
+	}
+	n = min(n, a.used)
+	a.used -= n
+	a.b.used.Add(-n)
+}
+
+// Used 返回账户当前占用的字节数
+func (a *MemoryAccount) Used() int64 {
+	if a == nil {
+		return 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.used
+}
+
+// Close 归还账户占用的全部内存, 连接关闭时调用
+func (a *MemoryAccount) Close() {
+	if a == nil || a.b == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.closed {
+		a.closed = true
+		a.b.used.Add(-a.used)
+		a.used = 0
+	}
+}
+
+// Memory 返回连接的内存账户, 未设置预算时为 nil (nil 账户的方法均可安全调用).
+// 上层协议通过它把解析状态 (如 HTTP 请求头部) 计入同一账户
+func (c *Conn) Memory() *MemoryAccount { return c.mem }
@@ -0,0 +1,81 @@
+package tcp
+
+/*
+	连接元数据, 上层可在连接上附加租户、认证身份、PROXY 信息等, 无需维护以连接为键的并行映射
+*/
+
+import "sync"
+
+// MetaKey 预定义的元数据键类型, 自定义键建议使用未导出类型避免冲突
+type MetaKey string
+
+// 常用元数据键
+const (
+	// MetaTenant 租户标识
+	MetaTenant MetaKey = "tenant"
+	// MetaIdentity 认证后的身份
+	MetaIdentity MetaKey = "identity"
+	// MetaALPN TLS 握手协商的应用层协议, 握手完成后自动设置
+	MetaALPN MetaKey = "alpn"
+	// MetaProxyInfo PROXY 协议解析出的原始来源信息
+	MetaProxyInfo MetaKey = "proxy"
+)
+
+// Metadata 并发安全的键值存储, TLS 握手前后的连接共享同一实例
+type Metadata struct {
+	mu sync.RWMutex
+	m  map[any]any
+}
+
+// Get 返回 key 对应的值
+func (md *Metadata) Get(key any) (any, bool) {
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+	v, ok := md.m[key]
+	return v, ok
+}
+
+// Set 设置 key 对应的值
+func (md *Metadata) Set(key, value any) {
+	md.mu.Lock()
+	defer md.mu.Unlock()
+	if md.m == nil {
+		md.m = make(map[any]any)
+	}
+	md.m[key] = value
+}
+
+// Delete 删除 key
+func (md *Metadata) Delete(key any) {
+	md.mu.Lock()
+	defer md.mu.Unlock()
+	delete(md.m, key)
+}
+
+// Range 遍历所有键值, f 返回 false 时停止; 遍历期间不可修改同一实例
+func (md *Metadata) Range(f func(key, value any) bool) {
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+	for k, v := range md.m {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+// Len 返回键的数量
+func (md *Metadata) Len() int {
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+	return len(md.m)
+}
+
+// MetaString 读取字符串类型的元数据, 不存在或类型不符时返回空串
+func (c *Conn) MetaString(key any) string {
+	v, _ := c.meta.Get(key)
+	s, _ := v.(string)
+	return s
+}
+
+// Metadata 返回连接的元数据
+func (c *Conn) Metadata() *Metadata { return c.meta }
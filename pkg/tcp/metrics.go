@@ -0,0 +1,49 @@
+package tcp
+
+/*
+	TCP服务器的指标: 按监听地址统计接入、拒绝、当前连接数和收发字节数
+*/
+
+import "github.com/narcilee7/http-stack/pkg/metrics"
+
+// serverMetrics Server 上报的指标
+type serverMetrics struct {
+	accepted *metrics.CounterVec
+	rejected *metrics.CounterVec
+	active   *metrics.GaugeVec
+	read     *metrics.CounterVec
+	written  *metrics.CounterVec
+}
+
+func newServerMetrics(r *metrics.Registry) *serverMetrics {
+	if r == nil {
+		r = metrics.NewRegistry()
+	}
+	return &serverMetrics{
+		accepted: r.CounterVec("tcp_connections_accepted_total", "Connections accepted.", "listener"),
+		rejected: r.CounterVec("tcp_connections_rejected_total", "Connections rejected by admission control.", "listener", "reason"),
+		active:   r.GaugeVec("tcp_connections_active", "Connections currently being served.", "listener"),
+		read:     r.CounterVec("tcp_received_bytes_total", "Bytes read from closed connections.", "listener"),
+		written:  r.CounterVec("tcp_sent_bytes_total", "Bytes written to closed connections.", "listener"),
+	}
+}
+
+// rejectReasons 所有拒绝原因, 用于预先创建序列
+var rejectReasons = []RejectReason{RejectRate, RejectIPRate, RejectIPConns, RejectClosed, RejectMemory}
+
+// label 返回用作指标标签的原因名
+func (r RejectReason) label() string {
+	switch r {
+	case RejectRate:
+		return "rate"
+	case RejectIPRate:
+		return "ip_rate"
+	case RejectIPConns:
+		return "ip_conns"
+	case RejectClosed:
+		return "closed"
+	case RejectMemory:
+		return "memory"
+	}
+	return "unknown"
+}
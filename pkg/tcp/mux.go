@@ -0,0 +1,488 @@
+package tcp
+
+/*
+	轻量流复用 (yamux 风格): 在一个连接上承载多个独立的双向流, 每个流有独立的流量控制窗口
+*/
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// 复用协议错误
+var (
+	ErrMuxClosed    = errors.New("tcp: mux session closed")
+	ErrMuxGoAway    = errors.New("tcp: mux session is going away")
+	ErrMuxProtocol  = errors.New("tcp: mux protocol error")
+	ErrStreamReset  = errors.New("tcp: mux stream reset")
+	ErrMuxExhausted = errors.New("tcp: mux stream ids exhausted")
+)
+
+// 帧格式: version(1) type(1) flags(2) stream(4) length(4), 大端序
+const (
+	muxVersion    = 0
+	muxHeaderSize = 12
+
+	frameData         uint8 = 0
+	frameWindowUpdate uint8 = 1
+	framePing         uint8 = 2
+	frameGoAway       uint8 = 3
+
+	flagSYN uint16 = 1 << 0
+	flagACK uint16 = 1 << 1
+	flagFIN uint16 = 1 << 2
+	flagRST uint16 = 1 << 3
+
+	// muxInitialWindow 协议约定的初始窗口, 更大的窗口在 SYN/ACK 中以增量通告
+	muxInitialWindow = 256 << 10
+	// muxMaxFrame 单个数据帧的最大负载
+	muxMaxFrame = 16 << 10
+	// muxCtrlQueue 接收循环待发送的控制帧 (ping 应答, RST, 窗口确认) 上限
+	muxCtrlQueue = 64
+)
+
+// MuxConfig 复用会话配置, 零值字段使用默认值
+type MuxConfig struct {
+	// AcceptBacklog 等待 Accept 的新流上限, 超出时直接重置, 默认 256
+	AcceptBacklog int
+	// StreamWindow 每个流的接收窗口, 不小于 256KB
+	StreamWindow uint32
+	// KeepAliveInterval 心跳间隔, 默认 30s, 负数关闭
+	KeepAliveInterval time.Duration
+	// PingTimeout 心跳等待应答的超时, 默认 10s
+	PingTimeout time.Duration
+	// WriteTimeout 单帧写出超时, 默认 10s, 负数表示不限制
+	WriteTimeout time.Duration
+}
+
+func (c *MuxConfig) withDefaults() MuxConfig {
+	var cfg MuxConfig
+	if c != nil {
+		cfg = *c
+	}
+	if cfg.AcceptBacklog <= 0 {
+		cfg.AcceptBacklog = 256
+	}
+	if cfg.StreamWindow < muxInitialWindow {
+		cfg.StreamWindow = muxInitialWindow
+	}
+	if cfg.KeepAliveInterval == 0 {
+		cfg.KeepAliveInterval = 30 * time.Second
+	}
+	if cfg.PingTimeout <= 0 {
+		cfg.PingTimeout = 10 * time.Second
+	}
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = 10 * time.Second
+	}
+	return cfg
+}
+
+// MuxSession 一个连接上的复用会话, 实现 net.Listener 以接收对端打开的流
+type MuxSession struct {
+	conn net.Conn
+	cfg  MuxConfig
+	br   *bufio.Reader
+
+	mu           sync.Mutex
+	streams      map[uint32]*MuxStream
+	nextID       uint32
+	localGoAway  bool
+	remoteGoAway bool
+	pings        map[uint32]chan struct{}
+	pingID       uint32
+
+	acceptCh chan *MuxStream
+	ctrlCh   chan muxCtrl
+	writeMu  sync.Mutex
+
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewMuxClient 以客户端身份创建会话, 本端打开的流使用奇数 ID
+func NewMuxClient(conn net.Conn, cfg *MuxConfig) *MuxSession {
+	return newMux(conn, cfg, 1)
+}
+
+// NewMuxServer 以服务端身份创建会话, 本端打开的流使用偶数 ID
+func NewMuxServer(conn net.Conn, cfg *MuxConfig) *MuxSession {
+	return newMux(conn, cfg, 2)
+}
+
+func newMux(conn net.Conn, cfg *MuxConfig, firstID uint32) *MuxSession {
+	s := &MuxSession{
+		conn:    conn,
+		cfg:     cfg.withDefaults(),
+		br:      bufio.NewReaderSize(conn, 32<<10),
+		streams: make(map[uint32]*MuxStream),
+		nextID:  firstID,
+		pings:   make(map[uint32]chan struct{}),
+		ctrlCh:  make(chan muxCtrl, muxCtrlQueue),
+		done:    make(chan struct{}),
+	}
+	s.acceptCh = make(chan *MuxStream, s.cfg.AcceptBacklog)
+	go s.recvLoop()
+	go s.ctrlLoop()
+	if s.cfg.KeepAliveInterval > 0 {
+		go s.keepalive()
+	}
+	return s
+}
+
+// Open 打开一个新流
+func (s *MuxSession) Open() (*MuxStream, error) {
+	s.mu.Lock()
+	switch {
+	case s.isClosed():
+		s.mu.Unlock()
+		return nil, s.err()
+	case s.remoteGoAway:
+		s.mu.Unlock()
+		return nil, ErrMuxGoAway
+	case s.nextID > math.MaxUint32-2:
+		s.mu.Unlock()
+		return nil, ErrMuxExhausted
+	}
+	id := s.nextID
+	s.nextID += 2
+	st := newMuxStream(s, id)
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	if err := s.writeFrame(frameWindowUpdate, flagSYN, id, s.cfg.StreamWindow-muxInitialWindow, nil); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+	return st, nil
+}
+
+// AcceptStream 等待对端打开的流
+func (s *MuxSession) AcceptStream() (*MuxStream, error) {
+	return s.AcceptStreamContext(context.Background())
+}
+
+// AcceptStreamContext 等待对端打开的流, ctx 结束时返回 *ContextError
+func (s *MuxSession) AcceptStreamContext(ctx context.Context) (*MuxStream, error) {
+	select {
+	case st := <-s.acceptCh:
+		return st, nil
+	case <-s.done:
+		return nil, s.err()
+	case <-ctx.Done():
+		return nil, ctxError("accept stream", ctx, ctx.Err())
+	}
+}
+
+// Accept 实现 net.Listener
+func (s *MuxSession) Accept() (net.Conn, error) {
+	st, err := s.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Addr 实现 net.Listener, 返回底层连接的本地地址
+func (s *MuxSession) Addr() net.Addr { return s.conn.LocalAddr() }
+
+// NumStreams 返回当前活跃的流数量
+func (s *MuxSession) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// Done 返回会话关闭时关闭的通道
+func (s *MuxSession) Done() <-chan struct{} { return s.done }
+
+// GoAway 通知对端不再接受新流, 已有的流不受影响
+func (s *MuxSession) GoAway() error {
+	s.mu.Lock()
+	s.localGoAway = true
+	s.mu.Unlock()
+	return s.writeFrame(frameGoAway, 0, 0, 0, nil)
+}
+
+// Ping 发送心跳并返回往返时间
+func (s *MuxSession) Ping() (time.Duration, error) {
+	ch := make(chan struct{})
+	s.mu.Lock()
+	id := s.pingID
+	s.pingID++
+	s.pings[id] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pings, id)
+		s.mu.Unlock()
+	}()
+
+	start := time.Now()
+	if err := s.writeFrame(framePing, flagSYN, 0, id, nil); err != nil {
+		return 0, err
+	}
+	t := time.NewTimer(s.cfg.PingTimeout)
+	defer t.Stop()
+	select {
+	case <-ch:
+		return time.Since(start), nil
+	case <-t.C:
+		return 0, fmt.Errorf("tcp: mux ping: %w", os.ErrDeadlineExceeded)
+	case <-s.done:
+		return 0, s.err()
+	}
+}
+
+// Close 通知对端后关闭会话和底层连接, 所有流随之失效
+func (s *MuxSession) Close() error {
+	s.GoAway()
+	s.closeWith(ErrMuxClosed)
+	return nil
+}
+
+func (s *MuxSession) closeWith(err error) {
+	s.closeOnce.Do(func() {
+		s.closeErr = err
+		close(s.done)
+		s.conn.Close()
+	})
+}
+
+func (s *MuxSession) isClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *MuxSession) err() error {
+	<-s.done
+	if errors.Is(s.closeErr, ErrMuxClosed) || errors.Is(s.closeErr, ErrMuxProtocol) {
+		return s.closeErr
+	}
+	return fmt.Errorf("%w: %v", ErrMuxClosed, s.closeErr)
+}
+
+func (s *MuxSession) removeStream(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+// writeFrame 串行写出一帧
+func (s *MuxSession) writeFrame(typ uint8, flags uint16, id, length uint32, payload []byte) error {
+	var hdr [muxHeaderSize]byte
+	hdr[0] = muxVersion
+	hdr[1] = typ
+	binary.BigEndian.PutUint16(hdr[2:], flags)
+	binary.BigEndian.PutUint32(hdr[4:], id)
+	binary.BigEndian.PutUint32(hdr[8:], length)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.isClosed() {
+		return s.err()
+	}
+	if s.cfg.WriteTimeout > 0 {
+		s.conn.SetWriteDeadline(time.Now().Add(s.cfg.WriteTimeout))
+	}
+	bufs := net.Buffers{hdr[:]}
+	if len(payload) > 0 {
+		bufs = append(bufs, payload)
+	}
+	if _, err := bufs.WriteTo(s.conn); err != nil {
+		s.closeWith(err)
+		return s.err()
+	}
+	return nil
+}
+
+// muxCtrl 排队等待发送的控制帧
+type muxCtrl struct {
+	typ    uint8
+	flags  uint16
+	id     uint32
+	length uint32
+}
+
+// sendAsync 把接收循环产生的控制帧交给 ctrlLoop 发送.
+// 队列满时阻塞接收循环, 对端的 ping 洪泛由此受到反压而不会堆积 goroutine
+func (s *MuxSession) sendAsync(typ uint8, flags uint16, id, length uint32) {
+	select {
+	case s.ctrlCh <- muxCtrl{typ: typ, flags: flags, id: id, length: length}:
+	case <-s.done:
+	}
+}
+
+// ctrlLoop 唯一的控制帧发送者
+func (s *MuxSession) ctrlLoop() {
+	for {
+		select {
+		case f := <-s.ctrlCh:
+			if err := s.writeFrame(f.typ, f.flags, f.id, f.length, nil); err != nil {
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *MuxSession) keepalive() {
+	t := time.NewTicker(s.cfg.KeepAliveInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if _, err := s.Ping(); err != nil {
+				s.closeWith(err)
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *MuxSession) recvLoop() {
+	var hdr [muxHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(s.br, hdr[:]); err != nil {
+			s.closeWith(err)
+			return
+		}
+		if hdr[0] != muxVersion {
+			s.closeWith(fmt.Errorf("%w: unsupported version %d", ErrMuxProtocol, hdr[0]))
+			return
+		}
+		typ := hdr[1]
+		flags := binary.BigEndian.Uint16(hdr[2:])
+		id := binary.BigEndian.Uint32(hdr[4:])
+		length := binary.BigEndian.Uint32(hdr[8:])
+
+		var err error
+		switch typ {
+		case frameData, frameWindowUpdate:
+			err = s.handleStream(typ, flags, id, length)
+		case framePing:
+			s.handlePing(flags, length)
+		case frameGoAway:
+			s.mu.Lock()
+			s.remoteGoAway = true
+			s.mu.Unlock()
+		default:
+			err = fmt.Errorf("%w: unknown frame type %d", ErrMuxProtocol, typ)
+		}
+		if err != nil {
+			s.closeWith(err)
+			return
+		}
+	}
+}
+
+func (s *MuxSession) handlePing(flags uint16, opaque uint32) {
+	if flags&flagSYN != 0 {
+		s.sendAsync(framePing, flagACK, 0, opaque)
+		return
+	}
+	s.mu.Lock()
+	ch := s.pings[opaque]
+	delete(s.pings, opaque)
+	s.mu.Unlock()
+	if ch != nil {
+		close(ch)
+	}
+}
+
+func (s *MuxSession) handleStream(typ uint8, flags uint16, id, length uint32) error {
+	// 分配缓冲区前先检查长度, 伪造的帧头不能让本端分配任意大小的内存
+	if typ == frameData && length > muxMaxFrame {
+		return fmt.Errorf("%w: frame length %d exceeds %d", ErrMuxProtocol, length, muxMaxFrame)
+	}
+	st, err := s.lookupStream(flags, id)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		// 已关闭或被拒绝的流, 丢弃数据
+		if typ == frameData && length > 0 {
+			_, err := s.br.Discard(int(length))
+			return err
+		}
+		return nil
+	}
+	if typ == frameWindowUpdate {
+		if err := st.addSendWindow(length); err != nil {
+			return err
+		}
+	} else if length > 0 {
+		if length > st.availRecvWindow() {
+			return fmt.Errorf("%w: stream %d exceeded receive window", ErrMuxProtocol, id)
+		}
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(s.br, buf); err != nil {
+			return err
+		}
+		if err := st.receive(buf); err != nil {
+			return err
+		}
+	}
+	st.processFlags(flags)
+	return nil
+}
+
+// lookupStream 查找帧所属的流, 带 SYN 时创建对端发起的流
+func (s *MuxSession) lookupStream(flags uint16, id uint32) (*MuxStream, error) {
+	if flags&flagSYN == 0 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.streams[id], nil
+	}
+	st, err := s.acceptRemote(id)
+	if err != nil {
+		return nil, err
+	}
+	// 控制帧在锁外入队, 队列满时不会占住会话锁
+	if st == nil {
+		s.sendAsync(frameWindowUpdate, flagRST, id, 0)
+		return nil, nil
+	}
+	s.sendAsync(frameWindowUpdate, flagACK, id, s.cfg.StreamWindow-muxInitialWindow)
+	return st, nil
+}
+
+// acceptRemote 登记对端打开的流, 返回 nil 表示拒绝
+func (s *MuxSession) acceptRemote(id uint32) (*MuxStream, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id == 0 || id%2 == s.nextID%2 {
+		return nil, fmt.Errorf("%w: invalid stream id %d", ErrMuxProtocol, id)
+	}
+	if _, dup := s.streams[id]; dup {
+		return nil, fmt.Errorf("%w: duplicate stream id %d", ErrMuxProtocol, id)
+	}
+	if s.localGoAway {
+		return nil, nil
+	}
+	st := newMuxStream(s, id)
+	select {
+	case s.acceptCh <- st:
+	default:
+		return nil, nil
+	}
+	s.streams[id] = st
+	return st, nil
+}
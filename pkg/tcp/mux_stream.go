@@ -0,0 +1,304 @@
+package tcp
+
+/*
+	复用流, 实现 net.Conn; 接收方消费过半窗口后发送窗口更新
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// MuxStream 复用会话中的一个流
+type MuxStream struct {
+	s  *MuxSession
+	id uint32
+
+	mu           sync.Mutex
+	recvBuf      bytes.Buffer
+	recvWindow   uint32 // 已通告给对端、尚未收到数据的窗口
+	consumed     uint32 // 已读取但尚未通告的字节数
+	sendWindow   uint32
+	writeClosed  bool // 本端已发送 FIN
+	readClosed   bool // 本端不再读取
+	remoteClosed bool // 收到对端 FIN
+	reset        bool
+	readDL       time.Time
+	writeDL      time.Time
+
+	recvNotify chan struct{}
+	sendNotify chan struct{}
+}
+
+func newMuxStream(s *MuxSession, id uint32) *MuxStream {
+	return &MuxStream{
+		s:          s,
+		id:         id,
+		recvWindow: s.cfg.StreamWindow,
+		sendWindow: muxInitialWindow,
+		recvNotify: make(chan struct{}, 1),
+		sendNotify: make(chan struct{}, 1),
+	}
+}
+
+// ID 返回流 ID
+func (st *MuxStream) ID() uint32 { return st.id }
+
+// Session 返回所属会话
+func (st *MuxStream) Session() *MuxSession { return st.s }
+
+func (st *MuxStream) Read(p []byte) (int, error) {
+	st.mu.Lock()
+	for st.recvBuf.Len() == 0 {
+		var err error
+		switch {
+		case st.readClosed:
+			err = net.ErrClosed
+		case st.remoteClosed:
+			err = io.EOF
+		case st.reset:
+			err = ErrStreamReset
+		case st.s.isClosed():
+			err = st.s.err()
+		}
+		if err != nil {
+			st.mu.Unlock()
+			return 0, err
+		}
+		dl := st.readDL
+		st.mu.Unlock()
+		if err := st.wait(st.recvNotify, dl); err != nil {
+			return 0, err
+		}
+		st.mu.Lock()
+	}
+	n, _ := st.recvBuf.Read(p)
+	st.consumed += uint32(n)
+	var delta uint32
+	if st.consumed >= st.s.cfg.StreamWindow/2 && !st.remoteClosed {
+		delta = st.consumed
+		st.recvWindow += delta
+		st.consumed = 0
+	}
+	st.mu.Unlock()
+	if delta > 0 {
+		st.s.writeFrame(frameWindowUpdate, 0, st.id, delta, nil)
+	}
+	return n, nil
+}
+
+func (st *MuxStream) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		n, err := st.write(p)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+func (st *MuxStream) write(p []byte) (int, error) {
+	st.mu.Lock()
+	for st.sendWindow == 0 || st.writeClosed || st.reset {
+		var err error
+		switch {
+		case st.writeClosed:
+			err = net.ErrClosed
+		case st.reset:
+			err = ErrStreamReset
+		}
+		if err != nil {
+			st.mu.Unlock()
+			return 0, err
+		}
+		dl := st.writeDL
+		st.mu.Unlock()
+		if err := st.wait(st.sendNotify, dl); err != nil {
+			return 0, err
+		}
+		st.mu.Lock()
+	}
+	n := min(uint32(len(p)), st.sendWindow, muxMaxFrame)
+	st.sendWindow -= n
+	st.mu.Unlock()
+	if err := st.s.writeFrame(frameData, 0, st.id, n, p[:n]); err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// wait 等待通知、截止时间或会话关闭
+func (st *MuxStream) wait(ch chan struct{}, dl time.Time) error {
+	var timeout <-chan time.Time
+	if !dl.IsZero() {
+		d := time.Until(dl)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	case <-st.s.done:
+		return st.s.err()
+	}
+}
+
+// CloseWrite 发送 FIN, 对端读完已发送的数据后得到 EOF
+func (st *MuxStream) CloseWrite() error {
+	st.mu.Lock()
+	if st.writeClosed || st.reset {
+		st.mu.Unlock()
+		return nil
+	}
+	st.writeClosed = true
+	done := st.remoteClosed
+	st.mu.Unlock()
+	notify(st.sendNotify)
+	if done {
+		st.s.removeStream(st.id)
+	}
+	return st.s.writeFrame(frameWindowUpdate, flagFIN, st.id, 0, nil)
+}
+
+// Close 关闭写方向并停止读取; 对端在关闭后继续发送的数据会触发重置
+func (st *MuxStream) Close() error {
+	err := st.CloseWrite()
+	st.mu.Lock()
+	st.readClosed = true
+	st.recvBuf.Reset()
+	st.mu.Unlock()
+	notify(st.recvNotify)
+	return err
+}
+
+// Reset 立即中止流, 双方未完成的读写均返回 ErrStreamReset
+func (st *MuxStream) Reset() error {
+	st.mu.Lock()
+	if st.reset {
+		st.mu.Unlock()
+		return nil
+	}
+	st.reset = true
+	st.mu.Unlock()
+	notify(st.recvNotify)
+	notify(st.sendNotify)
+	st.s.removeStream(st.id)
+	return st.s.writeFrame(frameWindowUpdate, flagRST, st.id, 0, nil)
+}
+
+// receive 由接收循环调用, 写入对端发送的数据
+func (st *MuxStream) receive(p []byte) error {
+	st.mu.Lock()
+	if uint32(len(p)) > st.recvWindow {
+		st.mu.Unlock()
+		return fmt.Errorf("%w: stream %d exceeded receive window", ErrMuxProtocol, st.id)
+	}
+	st.recvWindow -= uint32(len(p))
+	if st.readClosed {
+		st.mu.Unlock()
+		st.s.removeStream(st.id)
+		st.s.sendAsync(frameWindowUpdate, flagRST, st.id, 0)
+		return nil
+	}
+	st.recvBuf.Write(p)
+	st.mu.Unlock()
+	notify(st.recvNotify)
+	return nil
+}
+
+// availRecvWindow 返回对端还可以发送的字节数
+func (st *MuxStream) availRecvWindow() uint32 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.recvWindow
+}
+
+// addSendWindow 增加发送窗口, 溢出 uint32 的增量视为协议错误
+func (st *MuxStream) addSendWindow(n uint32) error {
+	if n == 0 {
+		return nil
+	}
+	st.mu.Lock()
+	if n > math.MaxUint32-st.sendWindow {
+		st.mu.Unlock()
+		return fmt.Errorf("%w: stream %d send window overflow", ErrMuxProtocol, st.id)
+	}
+	st.sendWindow += n
+	st.mu.Unlock()
+	notify(st.sendNotify)
+	return nil
+}
+
+func (st *MuxStream) processFlags(flags uint16) {
+	if flags&flagRST != 0 {
+		st.mu.Lock()
+		st.reset = true
+		st.mu.Unlock()
+		notify(st.recvNotify)
+		notify(st.sendNotify)
+		st.s.removeStream(st.id)
+		return
+	}
+	if flags&flagFIN != 0 {
+		st.mu.Lock()
+		st.remoteClosed = true
+		done := st.writeClosed
+		st.mu.Unlock()
+		notify(st.recvNotify)
+		if done {
+			st.s.removeStream(st.id)
+		}
+	}
+}
+
+// LocalAddr 返回底层连接的本地地址
+func (st *MuxStream) LocalAddr() net.Addr { return st.s.conn.LocalAddr() }
+
+// RemoteAddr 返回底层连接的对端地址
+func (st *MuxStream) RemoteAddr() net.Addr { return st.s.conn.RemoteAddr() }
+
+// SetDeadline 同时设置读写截止时间
+func (st *MuxStream) SetDeadline(t time.Time) error {
+	st.SetReadDeadline(t)
+	return st.SetWriteDeadline(t)
+}
+
+// SetReadDeadline 设置读截止时间, 会唤醒正在等待的读取以重新计算
+func (st *MuxStream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDL = t
+	st.mu.Unlock()
+	notify(st.recvNotify)
+	return nil
+}
+
+// SetWriteDeadline 设置写截止时间 (仅作用于等待发送窗口)
+func (st *MuxStream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.writeDL = t
+	st.mu.Unlock()
+	notify(st.sendNotify)
+	return nil
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
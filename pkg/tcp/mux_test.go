@@ -0,0 +1,206 @@
+package tcp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// muxPair 在回环连接上建立一对客户端/服务端会话
+func muxPair(t *testing.T, cfg *MuxConfig) (client, server *MuxSession) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+	cc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := <-accepted
+	if sc == nil {
+		t.Fatal("accept failed")
+	}
+	client, server = NewMuxClient(cc, cfg), NewMuxServer(sc, cfg)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestMuxStreamEcho(t *testing.T) {
+	client, server := muxPair(t, nil)
+	go func() {
+		for {
+			st, err := server.AcceptStream()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(st, st)
+				st.CloseWrite()
+			}()
+		}
+	}()
+
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 64<<10) // 1MB, 超过初始窗口
+	for i := 0; i < 3; i++ {
+		st, err := client.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.ID()%2 != 1 {
+			t.Fatalf("client stream id %d is not odd", st.ID())
+		}
+		go func() {
+			st.Write(payload)
+			st.CloseWrite()
+		}()
+		got, err := io.ReadAll(st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Fatalf("stream %d echoed %d bytes, want %d", st.ID(), len(got), len(payload))
+		}
+	}
+}
+
+func TestMuxFlowControlBlocksWriter(t *testing.T) {
+	client, server := muxPair(t, nil)
+	st, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 对端不读取时, 写满初始窗口后阻塞直到截止时间
+	st.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	n, err := st.Write(make([]byte, muxInitialWindow+1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) || n != muxInitialWindow {
+		t.Fatalf("Write = %d, %v; want %d, deadline exceeded", n, err, muxInitialWindow)
+	}
+
+	peer, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(peer, make([]byte, muxInitialWindow)); err != nil {
+		t.Fatal(err)
+	}
+	// 读取过半窗口后对端发送窗口更新, 写入恢复
+	st.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := st.Write([]byte("more")); err != nil {
+		t.Fatalf("Write after window update: %v", err)
+	}
+}
+
+func TestMuxStreamReset(t *testing.T) {
+	client, server := muxPair(t, nil)
+	st, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Write([]byte("x"))
+	peer, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer.Read(make([]byte, 1))
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := peer.Read(make([]byte, 1))
+		read <- err
+	}()
+	st.Reset()
+	if err := <-read; !errors.Is(err, ErrStreamReset) {
+		t.Fatalf("peer Read after reset = %v, want ErrStreamReset", err)
+	}
+	if _, err := st.Write([]byte("x")); !errors.Is(err, ErrStreamReset) {
+		t.Fatalf("Write after Reset = %v, want ErrStreamReset", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for client.NumStreams()+server.NumStreams() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("streams not removed after reset: client %d, server %d", client.NumStreams(), server.NumStreams())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMuxGoAway(t *testing.T) {
+	client, server := muxPair(t, nil)
+	st, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Write([]byte("ping"))
+	peer, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.GoAway(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := client.Open()
+		if errors.Is(err, ErrMuxGoAway) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Open after GOAWAY = %v, want ErrMuxGoAway", err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Open still succeeds after GOAWAY")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 已有的流不受影响
+	go peer.Write([]byte("pong"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(st, buf); err != nil || string(buf) != "pong" {
+		t.Fatalf("existing stream after GOAWAY: %q, %v", buf, err)
+	}
+}
+
+func TestMuxPing(t *testing.T) {
+	client, _ := muxPair(t, nil)
+	if _, err := client.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMuxRejectsOversizedFrame(t *testing.T) {
+	a, b := net.Pipe()
+	s := NewMuxServer(a, nil)
+	defer s.Close()
+	defer b.Close()
+	go io.Copy(io.Discard, b)
+
+	// 帧头声明的长度超过上限, 会话应在分配缓冲区前以协议错误关闭
+	hdr := []byte{muxVersion, frameData, 0, byte(flagSYN), 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff}
+	if _, err := b.Write(hdr); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("session not closed after oversized frame")
+	}
+	if _, err := s.AcceptStream(); !errors.Is(err, ErrMuxProtocol) {
+		t.Fatalf("AcceptStream = %v, want ErrMuxProtocol", err)
+	}
+}
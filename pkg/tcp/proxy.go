@@ -0,0 +1,193 @@
+package tcp
+
+/*
+	双向数据转发, linux 上两端均为普通 TCP 连接时使用 splice 零拷贝, 其余情况使用池化缓冲区复制
+*/
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+const (
+	proxyBufferSize = 32 << 10
+	spliceChunkSize = 1 << 20
+)
+
+// ProxyOptions 转发选项
+type ProxyOptions struct {
+	// IdleTimeout 两个方向都没有数据超过该时间后结束转发, 0 表示不限制
+	IdleTimeout time.Duration
+	// Context 结束时关闭两个连接并返回 *ContextError
+	Context context.Context
+	// OnProgress 每搬运一块数据后以两个方向的累计字节数调用, 可能在两个 goroutine 中并发调用
+	OnProgress func(aToB, bToA int64)
+}
+
+// ProxyStats 转发结果
+type ProxyStats struct {
+	// AToB a 读出并写入 b 的字节数
+	AToB int64
+	// BToA b 读出并写入 a 的字节数
+	BToA int64
+	// Duration 转发持续时间
+	Duration time.Duration
+}
+
+// Proxy 在 a 和 b 之间双向转发数据, 直到两个方向都结束或出错.
+// 一个方向读到 EOF 时半关闭另一端的写方向, 返回前关闭两个连接; 字节数同时计入两端 Conn 的计数
+func Proxy(a, b *Conn, opts *ProxyOptions) (ProxyStats, error) {
+	p := &proxier{a: a, b: b}
+	ctx := context.Background()
+	if opts != nil {
+		p.idle = opts.IdleTimeout
+		p.progress = opts.OnProgress
+		if opts.Context != nil {
+			ctx = opts.Context
+		}
+	}
+	start := time.Now()
+	stop := context.AfterFunc(ctx, func() { p.fail() })
+
+	var st ProxyStats
+	errc := make(chan error, 2)
+	go func() {
+		var err error
+		st.AToB, err = p.pipe(b, a)
+		errc <- err
+	}()
+	go func() {
+		var err error
+		st.BToA, err = p.pipe(a, b)
+		errc <- err
+	}()
+	err := <-errc
+	if err2 := <-errc; err == nil {
+		err = err2
+	}
+	a.Close()
+	b.Close()
+	st.Duration = time.Since(start)
+	if !stop() {
+		err = ctxError("proxy", ctx, net.ErrClosed)
+	}
+	return st, err
+}
+
+type proxier struct {
+	a, b     *Conn
+	idle     time.Duration
+	progress func(aToB, bToA int64)
+	aToB     atomic.Int64
+	bToA     atomic.Int64
+
+	mu     sync.Mutex
+	failed bool
+}
+
+// fail 标记转发失败并关闭两端以唤醒两个方向, 返回是否为首次失败
+func (p *proxier) fail() bool {
+	p.mu.Lock()
+	first := !p.failed
+	p.failed = true
+	p.mu.Unlock()
+	p.a.Close()
+	p.b.Close()
+	return first
+}
+
+// pipe 将 src 复制到 dst, 正常结束时半关闭 dst, 出错时关闭两端以唤醒另一个方向
+func (p *proxier) pipe(dst, src *Conn) (int64, error) {
+	n, err := p.copy(dst, src)
+	if err == nil {
+		if cerr := dst.CloseWrite(); cerr == nil {
+			return n, nil
+		} else if !errors.Is(cerr, ErrHalfCloseUnsupported) {
+			err = cerr
+		}
+	}
+
+	if !p.fail() {
+		// 由对向关闭引起的错误不再上报
+		err = nil
+	}
+	return n, err
+}
+
+func (p *proxier) copy(dst, src *Conn) (int64, error) {
+	var total int64
+	for {
+		if p.idle > 0 {
+			d := time.Now().Add(p.idle)
+			src.SetReadDeadline(d)
+			dst.SetWriteDeadline(d)
+		}
+		n, err := copyChunk(dst, src)
+		total += n
+		if n > 0 && p.progress != nil {
+			if src == p.a {
+				p.aToB.Store(total)
+			} else {
+				p.bToA.Store(total)
+			}
+			p.progress(p.aToB.Load(), p.bToA.Load())
+		}
+		switch {
+		case err == nil:
+			continue
+		case err == io.EOF:
+			return total, nil
+		case p.idle > 0 && isTimeout(err) && p.active():
+			continue
+		}
+		return total, err
+	}
+}
+
+// active 报告两端是否有任一方向在空闲阈值内有过活动
+func (p *proxier) active() bool {
+	return p.a.IdleFor() < p.idle || p.b.IdleFor() < p.idle
+}
+
+// copyChunk 搬运一批数据, 源端结束时返回 io.EOF
+func copyChunk(dst, src *Conn) (int64, error) {
+	if spliceSupported {
+		dt, dok := dst.Conn.(*net.TCPConn)
+		st, sok := src.Conn.(*net.TCPConn)
+		if dok && sok {
+			n, err := dt.ReadFrom(&io.LimitedReader{R: st, N: spliceChunkSize})
+			src.recordRead(int(n), err)
+			dst.recordWrite(int(n), err)
+			if err == nil && n < spliceChunkSize {
+				err = io.EOF
+			}
+			return n, err
+		}
+	}
+
+	buf := utils.GetBytes(proxyBufferSize)
+	defer utils.PutBytes(buf)
+	n, rerr := src.Read(buf)
+	if n > 0 {
+		w, werr := dst.Write(buf[:n])
+		if werr == nil && w < n {
+			werr = io.ErrShortWrite
+		}
+		if werr != nil {
+			return int64(w), werr
+		}
+	}
+	return int64(n), rerr
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
@@ -0,0 +1,4 @@
+package tcp
+
+// spliceSupported linux 上 TCPConn.ReadFrom 使用 splice 在内核中搬运数据
+const spliceSupported = true
@@ -0,0 +1,5 @@
+//go:build !linux
+
+package tcp
+
+const spliceSupported = false
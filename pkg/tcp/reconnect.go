@@ -0,0 +1,312 @@
+package tcp
+
+/*
+	自动重连连接: 底层连接断开后按退避策略重新拨号, 通过回调通知连接建立和断开
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// ErrReconnectFailed 重连次数用尽
+var ErrReconnectFailed = errors.New("tcp: reconnect attempts exhausted")
+
+// 默认重连退避
+const (
+	DefaultReconnectMinBackoff = 100 * time.Millisecond
+	DefaultReconnectMaxBackoff = 30 * time.Second
+)
+
+// ReconnectConfig 重连配置
+type ReconnectConfig struct {
+	// Dialer 拨号器, 为空时使用零值 Dialer
+	Dialer *Dialer
+	// MinBackoff 首次重试前的等待, 之后每次翻倍直到 MaxBackoff, 实际等待带 50% 随机抖动
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Backoff 非空时代替 MinBackoff/MaxBackoff 决定重试间隔, 其 MaxAttempts 被忽略
+	Backoff *utils.Backoff
+	// MaxAttempts 连续失败的最大拨号次数, 0 表示不限制
+	MaxAttempts int
+	// OnConnect 每次连接建立后调用 (首次连接 attempt 为 0), 可在此重新发送握手或订阅;
+	// 返回错误时该连接被视为拨号失败
+	OnConnect func(c *Conn, attempt int) error
+	// OnDisconnect 连接因读写错误断开时调用
+	OnDisconnect func(c *Conn, err error)
+}
+
+// ReconnectingConn 断线后自动重连的连接.
+// 读写在断线时透明地切换到新连接: 读取会从新连接继续, 未写出任何字节的写入会在新连接上重试.
+// 连接切换意味着字节流不连续, 上层协议应借助 OnConnect 恢复会话状态
+type ReconnectingConn struct {
+	network string
+	addr    string
+	cfg     ReconnectConfig
+	backoff *utils.Backoff
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	dialMu  sync.Mutex
+	mu      sync.Mutex
+	cur     *Conn
+	gen     uint64
+	readDL  time.Time
+	writeDL time.Time
+	reconns int
+}
+
+// DialReconnecting 建立首个连接并返回自动重连的连接, 首次拨号失败时直接返回错误
+func DialReconnecting(ctx context.Context, network, addr string, cfg *ReconnectConfig) (*ReconnectingConn, error) {
+	rc := &ReconnectingConn{network: network, addr: addr}
+	if cfg != nil {
+		rc.cfg = *cfg
+	}
+	if rc.cfg.Dialer == nil {
+		rc.cfg.Dialer = &Dialer{}
+	}
+	if rc.cfg.MinBackoff <= 0 {
+		rc.cfg.MinBackoff = DefaultReconnectMinBackoff
+	}
+	if rc.cfg.MaxBackoff <= 0 {
+		rc.cfg.MaxBackoff = DefaultReconnectMaxBackoff
+	}
+	rc.backoff = rc.cfg.Backoff
+	if rc.backoff == nil {
+		rc.backoff = &utils.Backoff{Min: rc.cfg.MinBackoff, Max: rc.cfg.MaxBackoff, Jitter: 0.5}
+	}
+	rc.ctx, rc.cancel = context.WithCancel(context.Background())
+
+	c, err := rc.dialOnce(ctx, 0)
+	if err != nil {
+		rc.cancel()
+		return nil, err
+	}
+	rc.cur = c
+	rc.gen = 1
+	return rc, nil
+}
+
+func (rc *ReconnectingConn) dialOnce(ctx context.Context, attempt int) (*Conn, error) {
+	c, err := rc.cfg.Dialer.DialContext(ctx, rc.network, rc.addr)
+	if err != nil {
+		return nil, err
+	}
+	if rc.cfg.OnConnect != nil {
+		if err := rc.cfg.OnConnect(c, attempt); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// conn 返回当前连接, 已断开时阻塞重连直到成功、连接关闭或 ctx 结束
+func (rc *ReconnectingConn) conn(ctx context.Context) (*Conn, uint64, error) {
+	rc.mu.Lock()
+	if c := rc.cur; c != nil {
+		gen := rc.gen
+		rc.mu.Unlock()
+		return c, gen, nil
+	}
+	rc.mu.Unlock()
+
+	// 合并调用方 ctx 与连接自身的生命周期
+	dctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(rc.ctx, cancel)
+	defer stop()
+
+	rc.dialMu.Lock()
+	defer rc.dialMu.Unlock()
+	rc.mu.Lock()
+	if c := rc.cur; c != nil {
+		// 其他 goroutine 已完成重连
+		gen := rc.gen
+		rc.mu.Unlock()
+		return c, gen, nil
+	}
+	rc.mu.Unlock()
+
+	var lastErr error
+	for attempt := 1; rc.cfg.MaxAttempts <= 0 || attempt <= rc.cfg.MaxAttempts; attempt++ {
+		if err := rc.backoff.Sleep(dctx, attempt); err == nil {
+			var c *Conn
+			c, err = rc.dialOnce(dctx, attempt)
+			if err == nil {
+				return rc.install(c)
+			}
+			lastErr = err
+		}
+		switch {
+		case rc.ctx.Err() != nil:
+			return nil, 0, net.ErrClosed
+		case ctx.Err() != nil:
+			return nil, 0, ctxError("reconnect", ctx, ctx.Err())
+		}
+	}
+	return nil, 0, fmt.Errorf("%w: %v", ErrReconnectFailed, lastErr)
+}
+
+// install 设置新连接为当前连接
+func (rc *ReconnectingConn) install(c *Conn) (*Conn, uint64, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.ctx.Err() != nil {
+		c.Close()
+		return nil, 0, net.ErrClosed
+	}
+	c.SetReadDeadline(rc.readDL)
+	c.SetWriteDeadline(rc.writeDL)
+	rc.cur = c
+	rc.gen++
+	rc.reconns++
+	return c, rc.gen, nil
+}
+
+// fail 丢弃第 gen 代连接, 连接已被替换时忽略
+func (rc *ReconnectingConn) fail(gen uint64, err error) {
+	rc.mu.Lock()
+	c := rc.cur
+	if gen != rc.gen || c == nil {
+		rc.mu.Unlock()
+		return
+	}
+	rc.cur = nil
+	rc.mu.Unlock()
+	c.Close()
+	if rc.cfg.OnDisconnect != nil {
+		rc.cfg.OnDisconnect(c, err)
+	}
+}
+
+// shouldReconnect 超时和调用方取消由调用方处理, 其余错误 (含 EOF) 视为连接断开
+func (rc *ReconnectingConn) shouldReconnect(err error) bool {
+	var ce *ContextError
+	return rc.ctx.Err() == nil && !isTimeout(err) && !errors.As(err, &ce)
+}
+
+func (rc *ReconnectingConn) Read(p []byte) (int, error) {
+	return rc.ReadContext(context.Background(), p)
+}
+
+// ReadContext 读取数据, 断线时在 ctx 内等待重连
+func (rc *ReconnectingConn) ReadContext(ctx context.Context, p []byte) (int, error) {
+	for {
+		c, gen, err := rc.conn(ctx)
+		if err != nil {
+			return 0, err
+		}
+		n, err := c.ReadContext(ctx, p)
+		if err == nil || !rc.shouldReconnect(err) {
+			return n, err
+		}
+		rc.fail(gen, err)
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (rc *ReconnectingConn) Write(p []byte) (int, error) {
+	return rc.WriteContext(context.Background(), p)
+}
+
+// WriteContext 写出数据, 断线时在 ctx 内等待重连
+func (rc *ReconnectingConn) WriteContext(ctx context.Context, p []byte) (int, error) {
+	for {
+		c, gen, err := rc.conn(ctx)
+		if err != nil {
+			return 0, err
+		}
+		n, err := c.WriteContext(ctx, p)
+		if err == nil || !rc.shouldReconnect(err) {
+			return n, err
+		}
+		rc.fail(gen, err)
+		if n > 0 {
+			// 已部分写出, 重试会导致重复数据
+			return n, err
+		}
+	}
+}
+
+// Current 返回当前底层连接, 正在重连时返回 nil
+func (rc *ReconnectingConn) Current() *Conn {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.cur
+}
+
+// Reconnects 返回成功重连的次数
+func (rc *ReconnectingConn) Reconnects() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.reconns
+}
+
+// Close 关闭当前连接并停止重连
+func (rc *ReconnectingConn) Close() error {
+	rc.cancel()
+	rc.mu.Lock()
+	c := rc.cur
+	rc.cur = nil
+	rc.mu.Unlock()
+	if c != nil {
+		return c.Close()
+	}
+	return nil
+}
+
+// LocalAddr 返回当前连接的本地地址, 正在重连时为 nil
+func (rc *ReconnectingConn) LocalAddr() net.Addr {
+	if c := rc.Current(); c != nil {
+		return c.LocalAddr()
+	}
+	return nil
+}
+
+// RemoteAddr 返回当前连接的对端地址, 正在重连时为 nil
+func (rc *ReconnectingConn) RemoteAddr() net.Addr {
+	if c := rc.Current(); c != nil {
+		return c.RemoteAddr()
+	}
+	return nil
+}
+
+// SetDeadline 设置读写截止时间, 重连后的新连接沿用该设置
+func (rc *ReconnectingConn) SetDeadline(t time.Time) error {
+	rc.SetReadDeadline(t)
+	return rc.SetWriteDeadline(t)
+}
+
+// SetReadDeadline 设置读截止时间
+func (rc *ReconnectingConn) SetReadDeadline(t time.Time) error {
+	rc.mu.Lock()
+	rc.readDL = t
+	c := rc.cur
+	rc.mu.Unlock()
+	if c != nil {
+		return c.SetReadDeadline(t)
+	}
+	return nil
+}
+
+// SetWriteDeadline 设置写截止时间
+func (rc *ReconnectingConn) SetWriteDeadline(t time.Time) error {
+	rc.mu.Lock()
+	rc.writeDL = t
+	c := rc.cur
+	rc.mu.Unlock()
+	if c != nil {
+		return c.SetWriteDeadline(t)
+	}
+	return nil
+}
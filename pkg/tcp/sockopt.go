@@ -0,0 +1,122 @@
+package tcp
+
+/*
+	Socket 选项控制: TCP_NODELAY、收发缓冲区、地址/端口复用和 DSCP/TOS 标记
+*/
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// ErrSockoptUnsupported 当前平台不支持该选项
+var ErrSockoptUnsupported = errors.New("tcp: socket option not supported on this platform")
+
+// SocketOptions socket 选项, 零值字段表示保持系统默认
+type SocketOptions struct {
+	// NoDelay 设置 TCP_NODELAY, nil 表示不修改 (Go 默认开启)
+	NoDelay *bool
+	// RecvBuffer SO_RCVBUF 字节数
+	RecvBuffer int
+	// SendBuffer SO_SNDBUF 字节数
+	SendBuffer int
+	// ReuseAddr 监听前设置 SO_REUSEADDR
+	ReuseAddr bool
+	// ReusePort 监听前设置 SO_REUSEPORT, 多个进程可共享同一端口
+	ReusePort bool
+	// TOS IP 头部的 TOS/Traffic Class 字节, DSCP 值需左移 2 位; 0 表示不修改
+	TOS int
+	// IgnoreUnsupported 忽略当前平台不支持的选项, 而不是返回错误
+	IgnoreUnsupported bool
+}
+
+// DSCP 将 DSCP 码点转换为 TOS 字节
+func DSCP(codepoint int) int {
+	return codepoint << 2
+}
+
+// Control 在 socket 创建后、bind/connect 之前应用选项,
+// 可直接用作 net.Dialer.Control 或 net.ListenConfig.Control
+func (o *SocketOptions) Control(network, address string, rc syscall.RawConn) error {
+	if o == nil {
+		return nil
+	}
+	var serr error
+	err := rc.Control(func(fd uintptr) {
+		serr = o.control(fd, network)
+	})
+	if err != nil {
+		return err
+	}
+	return o.filter(serr)
+}
+
+// Apply 对已建立的连接应用选项, 用于 accept 得到的连接.
+// 地址/端口复用只在监听前有效, 此处忽略
+func (o *SocketOptions) Apply(c net.Conn) error {
+	if o == nil {
+		return nil
+	}
+	tc, ok := unwrapTCP(c)
+	if !ok {
+		return nil
+	}
+	if o.NoDelay != nil {
+		if err := tc.SetNoDelay(*o.NoDelay); err != nil {
+			return err
+		}
+	}
+	if o.RecvBuffer > 0 {
+		if err := tc.SetReadBuffer(o.RecvBuffer); err != nil {
+			return err
+		}
+	}
+	if o.SendBuffer > 0 {
+		if err := tc.SetWriteBuffer(o.SendBuffer); err != nil {
+			return err
+		}
+	}
+	if o.TOS == 0 {
+		return nil
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) { serr = setTOS(fd, c.LocalAddr().Network(), o.TOS) }); err != nil {
+		return err
+	}
+	return o.filter(serr)
+}
+
+func (o *SocketOptions) filter(err error) error {
+	if o.IgnoreUnsupported && errors.Is(err, ErrSockoptUnsupported) {
+		return nil
+	}
+	return err
+}
+
+func unwrapTCP(c net.Conn) (*net.TCPConn, bool) {
+	for {
+		switch v := c.(type) {
+		case *net.TCPConn:
+			return v, true
+		case *tls.Conn:
+			c = v.NetConn()
+		case interface{ Unwrap() net.Conn }:
+			c = v.Unwrap()
+		default:
+			return nil, false
+		}
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,4 @@
+package tcp
+
+// soReusePort SO_REUSEPORT, syscall 包在 linux 上未导出该常量
+const soReusePort = 0xf
@@ -0,0 +1,7 @@
+//go:build unix && !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package tcp
+
+func setReusePort(int) error {
+	return ErrSockoptUnsupported
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package tcp
+
+// control 非 unix 平台只支持通过 Apply 设置 NoDelay 和缓冲区大小
+func (o *SocketOptions) control(fd uintptr, network string) error {
+	if o.ReuseAddr || o.ReusePort || o.TOS != 0 {
+		return ErrSockoptUnsupported
+	}
+	return nil
+}
+
+func setTOS(uintptr, string, int) error {
+	return ErrSockoptUnsupported
+}
@@ -0,0 +1,9 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package tcp
+
+import "syscall"
+
+func setReusePort(s int) error {
+	return syscall.SetsockoptInt(s, syscall.SOL_SOCKET, soReusePort, 1)
+}
@@ -0,0 +1,55 @@
+//go:build unix
+
+package tcp
+
+import (
+	"strings"
+	"syscall"
+)
+
+func (o *SocketOptions) control(fd uintptr, network string) error {
+	s := int(fd)
+	if o.NoDelay != nil && strings.HasPrefix(network, "tcp") {
+		if err := syscall.SetsockoptInt(s, syscall.IPPROTO_TCP, syscall.TCP_NODELAY, boolInt(*o.NoDelay)); err != nil {
+			return err
+		}
+	}
+	if o.RecvBuffer > 0 {
+		if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_RCVBUF, o.RecvBuffer); err != nil {
+			return err
+		}
+	}
+	if o.SendBuffer > 0 {
+		if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_SNDBUF, o.SendBuffer); err != nil {
+			return err
+		}
+	}
+	if o.ReuseAddr {
+		if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+			return err
+		}
+	}
+	if o.ReusePort {
+		if err := setReusePort(s); err != nil {
+			return err
+		}
+	}
+	if o.TOS != 0 {
+		return setTOS(fd, network, o.TOS)
+	}
+	return nil
+}
+
+// setTOS 按地址族设置 IP_TOS 或 IPV6_TCLASS; 双栈 socket 两者都尝试
+func setTOS(fd uintptr, network string, tos int) error {
+	s := int(fd)
+	err4 := syscall.SetsockoptInt(s, syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+	if strings.HasSuffix(network, "4") {
+		return err4
+	}
+	err6 := syscall.SetsockoptInt(s, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+	if err4 == nil || err6 == nil {
+		return nil
+	}
+	return err6
+}
@@ -0,0 +1,194 @@
+package tcp
+
+/*
+	流量旁路: 将连接收发的原始字节复制到可插拔的输出 (环形缓冲、文本文件、pcap), 支持按连接采样
+*/
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TapDirection 数据方向
+type TapDirection uint8
+
+const (
+	// TapInbound 从对端读取的数据
+	TapInbound TapDirection = iota
+	// TapOutbound 写往对端的数据
+	TapOutbound
+)
+
+func (d TapDirection) String() string {
+	if d == TapInbound {
+		return "<"
+	}
+	return ">"
+}
+
+// TapRecord 一次读写捕获的数据
+type TapRecord struct {
+	ConnID uint64
+	Local  net.Addr
+	Remote net.Addr
+	Dir    TapDirection
+	Time   time.Time
+	// Data 仅在 WriteRecord 调用期间有效, 需要保留时应复制
+	Data []byte
+}
+
+// TapSink 捕获数据的输出, 需并发安全
+type TapSink interface {
+	WriteRecord(r *TapRecord) error
+}
+
+var tapConnID atomic.Uint64
+
+// Tap 流量旁路配置
+type Tap struct {
+	// Sink 捕获输出
+	Sink TapSink
+	// SampleRate 被捕获连接的比例 (0, 1], 0 表示全部捕获
+	SampleRate float64
+	// MaxBytes 每个连接每个方向最多捕获的字节数, 0 表示不限制
+	MaxBytes int64
+	// OnError 输出出错时调用, 捕获失败不影响连接本身
+	OnError func(err error)
+}
+
+// Wrap 按采样率决定是否捕获 c, 未被选中时原样返回 c
+func (t *Tap) Wrap(c net.Conn) net.Conn {
+	if t == nil || t.Sink == nil {
+		return c
+	}
+	if t.SampleRate > 0 && t.SampleRate < 1 && rand.Float64() >= t.SampleRate {
+		return c
+	}
+	return &TapConn{Conn: c, tap: t, id: tapConnID.Add(1)}
+}
+
+// TapConn 捕获收发数据的连接
+type TapConn struct {
+	net.Conn
+
+	tap *Tap
+	id  uint64
+	in  atomic.Int64
+	out atomic.Int64
+}
+
+func (tc *TapConn) Read(p []byte) (int, error) {
+	n, err := tc.Conn.Read(p)
+	if n > 0 {
+		tc.capture(TapInbound, p[:n], &tc.in)
+	}
+	return n, err
+}
+
+func (tc *TapConn) Write(p []byte) (int, error) {
+	n, err := tc.Conn.Write(p)
+	if n > 0 {
+		tc.capture(TapOutbound, p[:n], &tc.out)
+	}
+	return n, err
+}
+
+func (tc *TapConn) capture(dir TapDirection, p []byte, counter *atomic.Int64) {
+	if limit := tc.tap.MaxBytes; limit > 0 {
+		before := counter.Add(int64(len(p))) - int64(len(p))
+		if before >= limit {
+			return
+		}
+		if rest := limit - before; int64(len(p)) > rest {
+			p = p[:rest]
+		}
+	}
+	err := tc.tap.Sink.WriteRecord(&TapRecord{
+		ConnID: tc.id,
+		Local:  tc.LocalAddr(),
+		Remote: tc.RemoteAddr(),
+		Dir:    dir,
+		Time:   time.Now(),
+		Data:   p,
+	})
+	if err != nil && tc.tap.OnError != nil {
+		tc.tap.OnError(err)
+	}
+}
+
+// Unwrap 返回被封装的连接
+func (tc *TapConn) Unwrap() net.Conn { return tc.Conn }
+
+// RingSink 在内存中保留最近捕获的数据, 总字节数超过容量时丢弃最早的记录
+type RingSink struct {
+	mu      sync.Mutex
+	cap     int
+	size    int
+	records []TapRecord
+}
+
+// NewRingSink 创建容量为 capacity 字节的环形缓冲
+func NewRingSink(capacity int) *RingSink {
+	return &RingSink{cap: capacity}
+}
+
+// WriteRecord 实现 TapSink
+func (rs *RingSink) WriteRecord(r *TapRecord) error {
+	rec := *r
+	rec.Data = append([]byte(nil), r.Data...)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.records = append(rs.records, rec)
+	rs.size += len(rec.Data)
+	drop := 0
+	for rs.size > rs.cap && drop < len(rs.records)-1 {
+		rs.size -= len(rs.records[drop].Data)
+		drop++
+	}
+	if drop > 0 {
+		rs.records = append(rs.records[:0], rs.records[drop:]...)
+	}
+	return nil
+}
+
+// Records 返回当前保留的记录副本, 按捕获顺序排列
+func (rs *RingSink) Records() []TapRecord {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return append([]TapRecord(nil), rs.records...)
+}
+
+// Reset 清空缓冲
+func (rs *RingSink) Reset() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.records = nil
+	rs.size = 0
+}
+
+// WriterSink 以十六进制转储的文本格式写出记录, 适合写入日志文件
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink 创建写往 w 的文本输出
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// WriteRecord 实现 TapSink
+func (ws *WriterSink) WriteRecord(r *TapRecord) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	_, err := fmt.Fprintf(ws.w, "%s tap#%d %s %s %s %d bytes\n%s",
+		r.Time.Format(time.RFC3339Nano), r.ConnID, addrString(r.Local), r.Dir, addrString(r.Remote),
+		len(r.Data), hex.Dump(r.Data))
+	return err
+}
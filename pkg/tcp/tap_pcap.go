@@ -0,0 +1,145 @@
+package tcp
+
+/*
+	pcap 输出: 为捕获的数据合成 IP/TCP 头部, 可直接用 Wireshark/tcpdump 打开
+*/
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+)
+
+const (
+	pcapMagic       = 0xa1b2c3d4
+	pcapSnapLen     = 65535
+	pcapLinkTypeRaw = 101 // LINKTYPE_RAW, 数据包以 IP 头开始
+	pcapMaxPayload  = 65535 - 60 - 20
+)
+
+// PcapSink 将记录写成 pcap 格式; 每个连接每个方向单独维护序列号, 不合成握手包
+type PcapSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	header bool
+	seqs   map[uint64]*[2]uint32
+}
+
+// NewPcapSink 创建写往 w 的 pcap 输出, 文件头在首条记录前写出
+func NewPcapSink(w io.Writer) *PcapSink {
+	return &PcapSink{w: w, seqs: make(map[uint64]*[2]uint32)}
+}
+
+// WriteRecord 实现 TapSink
+func (ps *PcapSink) WriteRecord(r *TapRecord) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if !ps.header {
+		var h [24]byte
+		binary.LittleEndian.PutUint32(h[0:], pcapMagic)
+		binary.LittleEndian.PutUint16(h[4:], 2)
+		binary.LittleEndian.PutUint16(h[6:], 4)
+		binary.LittleEndian.PutUint32(h[16:], pcapSnapLen)
+		binary.LittleEndian.PutUint32(h[20:], pcapLinkTypeRaw)
+		if _, err := ps.w.Write(h[:]); err != nil {
+			return err
+		}
+		ps.header = true
+	}
+
+	seq := ps.seqs[r.ConnID]
+	if seq == nil {
+		seq = &[2]uint32{}
+		ps.seqs[r.ConnID] = seq
+	}
+	src, dst := tcpAddrOf(r.Local), tcpAddrOf(r.Remote)
+	if r.Dir == TapInbound {
+		src, dst = dst, src
+	}
+	data := r.Data
+	for len(data) > 0 {
+		n := min(len(data), pcapMaxPayload)
+		pkt := buildPacket(src, dst, seq[r.Dir], seq[1-r.Dir], data[:n])
+		var rec [16]byte
+		usec := r.Time.UnixMicro()
+		binary.LittleEndian.PutUint32(rec[0:], uint32(usec/1e6))
+		binary.LittleEndian.PutUint32(rec[4:], uint32(usec%1e6))
+		binary.LittleEndian.PutUint32(rec[8:], uint32(len(pkt)))
+		binary.LittleEndian.PutUint32(rec[12:], uint32(len(pkt)))
+		if _, err := ps.w.Write(rec[:]); err != nil {
+			return err
+		}
+		if _, err := ps.w.Write(pkt); err != nil {
+			return err
+		}
+		seq[r.Dir] += uint32(n)
+		data = data[n:]
+	}
+	return nil
+}
+
+// Forget 释放连接的序列号状态, 连接关闭后调用以免长期运行时状态增长
+func (ps *PcapSink) Forget(connID uint64) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	delete(ps.seqs, connID)
+}
+
+func tcpAddrOf(a net.Addr) *net.TCPAddr {
+	if ta, ok := a.(*net.TCPAddr); ok {
+		return ta
+	}
+	return &net.TCPAddr{IP: net.IPv4zero}
+}
+
+// buildPacket 合成 PSH|ACK 数据包; IPv4 头部带校验和, TCP 校验和置 0
+func buildPacket(src, dst *net.TCPAddr, seq, ack uint32, payload []byte) []byte {
+	src4, dst4 := src.IP.To4(), dst.IP.To4()
+	v4 := src4 != nil && dst4 != nil
+
+	var ip []byte
+	if v4 {
+		ip = make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+20+len(payload)))
+		ip[8] = 64
+		ip[9] = 6
+		copy(ip[12:], src4)
+		copy(ip[16:], dst4)
+		binary.BigEndian.PutUint16(ip[10:], ipChecksum(ip))
+	} else {
+		ip = make([]byte, 40)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(20+len(payload)))
+		ip[6] = 6
+		ip[7] = 64
+		copy(ip[8:], src.IP.To16())
+		copy(ip[24:], dst.IP.To16())
+	}
+
+	tcp := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcp[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(tcp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = 5 << 4
+	tcp[13] = 0x18 // PSH|ACK
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+
+	pkt := make([]byte, 0, len(ip)+len(tcp)+len(payload))
+	pkt = append(pkt, ip...)
+	pkt = append(pkt, tcp...)
+	return append(pkt, payload...)
+}
+
+func ipChecksum(h []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(h); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(h[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
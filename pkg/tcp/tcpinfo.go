@@ -0,0 +1,137 @@
+package tcp
+
+/*
+	内核 TCP_INFO 采样, 提供 RTT、重传和拥塞窗口等网络质量指标 (仅 linux, 386 除外),
+	TCPInfoCollector 把各连接的采样汇总到指标注册表
+*/
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/metrics"
+)
+
+// ErrTCPInfoUnsupported 当前平台或连接类型不支持 TCP_INFO
+var ErrTCPInfoUnsupported = errors.New("tcp: TCP_INFO not supported")
+
+// TCPInfo 内核 TCP 状态快照
+type TCPInfo struct {
+	// State 内核连接状态 (TCP_ESTABLISHED 等)
+	State uint8
+	// RTT 平滑往返时间
+	RTT time.Duration
+	// RTTVar 往返时间方差
+	RTTVar time.Duration
+	// RTO 当前重传超时
+	RTO time.Duration
+	// Retransmits 当前未确认段的重传次数
+	Retransmits uint32
+	// TotalRetrans 连接生命周期内的重传总数
+	TotalRetrans uint32
+	// Lost 估计丢失的段数
+	Lost uint32
+	// Unacked 已发送未确认的段数
+	Unacked uint32
+	// SndCwnd 拥塞窗口 (段)
+	SndCwnd uint32
+	// SndSsthresh 慢启动阈值 (段)
+	SndSsthresh uint32
+	// SndMSS 发送方最大段长度
+	SndMSS uint32
+	// PMTU 路径 MTU
+	PMTU uint32
+}
+
+// TCPInfo 采样连接的内核 TCP 状态, 对 TLS 连接作用于底层 TCP 连接
+func (c *Conn) TCPInfo() (*TCPInfo, error) {
+	tc, ok := unwrapTCP(c.Conn)
+	if !ok {
+		return nil, ErrTCPInfoUnsupported
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var info *TCPInfo
+	var serr error
+	if err := rc.Control(func(fd uintptr) { info, serr = getTCPInfo(fd) }); err != nil {
+		return nil, err
+	}
+	return info, serr
+}
+
+// TCPInfoCollector 通过 Server.RangeConns 采样所有连接的 TCP_INFO, 把 RTT、拥塞窗口和重传上报到指标注册表
+type TCPInfoCollector struct {
+	srv *Server
+
+	rtt     *metrics.Histogram
+	cwnd    *metrics.Histogram
+	retrans *metrics.Counter
+	sampled *metrics.Gauge
+
+	mu sync.Mutex
+	// last 各连接上次采样时的重传总数, 用于把累计值折算为增量
+	last map[*Conn]uint32
+}
+
+// NewTCPInfoCollector 创建采样 s 的收集器, r 为空时使用 s.Metrics (也为空时使用独立的注册表)
+func NewTCPInfoCollector(s *Server, r *metrics.Registry) *TCPInfoCollector {
+	if r == nil {
+		r = s.Metrics
+	}
+	if r == nil {
+		r = metrics.NewRegistry()
+	}
+	return &TCPInfoCollector{
+		srv:     s,
+		rtt:     r.Histogram("tcp_info_rtt_seconds", "Smoothed round-trip time of sampled connections.", metrics.ExponentialBuckets(0.0001, 2, 16)),
+		cwnd:    r.Histogram("tcp_info_snd_cwnd_segments", "Congestion window of sampled connections.", metrics.ExponentialBuckets(1, 2, 12)),
+		retrans: r.Counter("tcp_info_retransmitted_segments_total", "Segments retransmitted on sampled connections."),
+		sampled: r.Gauge("tcp_info_sampled_connections", "Connections with TCP_INFO in the last sample."),
+		last:    make(map[*Conn]uint32),
+	}
+}
+
+// Sample 采样一次所有连接, 返回成功采样的连接数; 不支持 TCP_INFO 的连接被跳过
+func (tc *TCPInfoCollector) Sample() int {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	seen := make(map[*Conn]uint32)
+	tc.srv.RangeConns(func(c *Conn) bool {
+		info, err := c.TCPInfo()
+		if err != nil {
+			return true
+		}
+		seen[c] = info.TotalRetrans
+		tc.rtt.ObserveDuration(info.RTT)
+		tc.cwnd.Observe(float64(info.SndCwnd))
+		return true
+	})
+
+	for c, total := range seen {
+		if prev := tc.last[c]; total > prev {
+			tc.retrans.Add(uint64(total - prev))
+		}
+	}
+	// 已关闭的连接不再出现在采样中, 一并丢弃其记录
+	tc.last = seen
+	tc.sampled.Set(float64(len(seen)))
+	return len(seen)
+}
+
+// Run 每隔 interval 采样一次, 直到 ctx 结束
+func (tc *TCPInfoCollector) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			tc.Sample()
+		}
+	}
+}
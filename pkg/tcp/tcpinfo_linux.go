@@ -0,0 +1,33 @@
+//go:build linux && !386
+
+package tcp
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+func getTCPInfo(fd uintptr) (*TCPInfo, error) {
+	var ki syscall.TCPInfo
+	size := uint32(syscall.SizeofTCPInfo)
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+		uintptr(unsafe.Pointer(&ki)), uintptr(unsafe.Pointer(&size)), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	return &TCPInfo{
+		State:        ki.State,
+		RTT:          time.Duration(ki.Rtt) * time.Microsecond,
+		RTTVar:       time.Duration(ki.Rttvar) * time.Microsecond,
+		RTO:          time.Duration(ki.Rto) * time.Microsecond,
+		Retransmits:  uint32(ki.Retransmits),
+		TotalRetrans: ki.Total_retrans,
+		Lost:         ki.Lost,
+		Unacked:      ki.Unacked,
+		SndCwnd:      ki.Snd_cwnd,
+		SndSsthresh:  ki.Snd_ssthresh,
+		SndMSS:       ki.Snd_mss,
+		PMTU:         ki.Pmtu,
+	}, nil
+}
@@ -0,0 +1,7 @@
+//go:build !linux || 386
+
+package tcp
+
+func getTCPInfo(uintptr) (*TCPInfo, error) {
+	return nil, ErrTCPInfoUnsupported
+}
@@ -0,0 +1,63 @@
+package tcp
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/metrics"
+)
+
+func TestTCPInfoCollectorSamplesConns(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan *Conn, 1)
+	release := make(chan struct{})
+	srv := &Server{Handler: HandlerFunc(func(c *Conn) {
+		served <- c
+		<-release
+	})}
+	go srv.Serve(ln)
+	t.Cleanup(func() {
+		close(release)
+		srv.Close()
+	})
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	sc := <-served
+	if _, err := sc.TCPInfo(); errors.Is(err, ErrTCPInfoUnsupported) {
+		t.Skip("TCP_INFO not supported on this platform")
+	}
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	sc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := sc.Read(make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	r := metrics.NewRegistry()
+	tc := NewTCPInfoCollector(srv, r)
+	if n := tc.Sample(); n != 1 {
+		t.Fatalf("Sample = %d connections, want 1", n)
+	}
+	for _, name := range []string{"tcp_info_rtt_seconds", "tcp_info_snd_cwnd_segments"} {
+		f, ok := r.Family(name)
+		if !ok || len(f.Metrics) != 1 || f.Metrics[0].Histogram.Count != 1 {
+			t.Fatalf("%s = %+v, want one observation", name, f)
+		}
+	}
+	if f, _ := r.Family("tcp_info_sampled_connections"); f.Metrics[0].Value != 1 {
+		t.Fatalf("tcp_info_sampled_connections = %v, want 1", f.Metrics[0].Value)
+	}
+	if _, ok := r.Family("tcp_info_retransmitted_segments_total"); !ok {
+		t.Fatal("retransmit counter not registered")
+	}
+}
@@ -0,0 +1,219 @@
+package tcp
+
+/*
+	带宽限速连接, 支持单连接和全局共享两级限速
+*/
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// DefaultThrottleChunk 单次限速读写的最大字节数, 保证限速平滑
+const DefaultThrottleChunk = 16 << 10
+
+// Throttle 限速配置, 速率单位为字节/秒, 0 表示不限制
+type Throttle struct {
+	// ReadRate 单连接读速率
+	ReadRate float64
+	// WriteRate 单连接写速率
+	WriteRate float64
+	// Burst 单连接突发字节数, 默认等于速率
+	Burst int
+	// SharedRead 所有连接共享的读限速器
+	SharedRead *utils.RateLimiter
+	// SharedWrite 所有连接共享的写限速器
+	SharedWrite *utils.RateLimiter
+	// ReadScheduler 按权重在连接间公平分配读带宽的调度器
+	ReadScheduler *utils.BandwidthScheduler
+	// WriteScheduler 按权重在连接间公平分配写带宽的调度器
+	WriteScheduler *utils.BandwidthScheduler
+	// Weight 连接在调度器中的权重, 默认 1
+	Weight float64
+	// MinRate 连接在调度器中的最低保证速率
+	MinRate float64
+}
+
+// ThrottledConn 对读写限速的连接, 关闭连接会中断正在进行的等待
+type ThrottledConn struct {
+	net.Conn
+
+	read    throttleDir
+	write   throttleDir
+	chunk   int
+	streams []*utils.BandwidthStream
+	ctx     context.Context
+	cancel  context.CancelFunc
+	once    sync.Once
+}
+
+// throttleDir 单个方向的限速器和截止时间, 修改截止时间会唤醒正在等待的一方重新计算
+type throttleDir struct {
+	limiters []waiter
+
+	mu       sync.Mutex
+	deadline time.Time
+	wake     context.CancelFunc
+}
+
+// waiter 限速等待, 由 utils.RateLimiter 和 utils.BandwidthStream 实现
+type waiter interface {
+	Wait(ctx context.Context, n int) error
+}
+
+func (d *throttleDir) setDeadline(t time.Time) {
+	d.mu.Lock()
+	d.deadline = t
+	if d.wake != nil {
+		d.wake()
+	}
+	d.mu.Unlock()
+}
+
+// NewThrottledConn 按 t 为 c 创建限速连接, 每次调用都会新建单连接限速器
+func NewThrottledConn(c net.Conn, t *Throttle) *ThrottledConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	tc := &ThrottledConn{Conn: c, chunk: DefaultThrottleChunk, ctx: ctx, cancel: cancel}
+	if t == nil {
+		return tc
+	}
+	if t.ReadRate > 0 {
+		tc.read.limiters = append(tc.read.limiters, utils.NewRateLimiter(t.ReadRate, t.Burst))
+	}
+	if t.SharedRead != nil {
+		tc.read.limiters = append(tc.read.limiters, t.SharedRead)
+	}
+	if t.WriteRate > 0 {
+		tc.write.limiters = append(tc.write.limiters, utils.NewRateLimiter(t.WriteRate, t.Burst))
+	}
+	if t.SharedWrite != nil {
+		tc.write.limiters = append(tc.write.limiters, t.SharedWrite)
+	}
+	if t.ReadScheduler != nil {
+		st := t.ReadScheduler.Register(t.Weight, t.MinRate)
+		tc.streams = append(tc.streams, st)
+		tc.read.limiters = append(tc.read.limiters, st)
+	}
+	if t.WriteScheduler != nil {
+		st := t.WriteScheduler.Register(t.Weight, t.MinRate)
+		tc.streams = append(tc.streams, st)
+		tc.write.limiters = append(tc.write.limiters, st)
+	}
+	return tc
+}
+
+// Read 读取后按实际字节数等待, 单次读取不超过限速块大小
+func (tc *ThrottledConn) Read(p []byte) (int, error) {
+	if len(tc.read.limiters) == 0 {
+		return tc.Conn.Read(p)
+	}
+	if len(p) > tc.chunk {
+		p = p[:tc.chunk]
+	}
+	n, err := tc.Conn.Read(p)
+	if n > 0 {
+		if werr := tc.wait("read", &tc.read, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// Write 分块等待令牌后写出
+func (tc *ThrottledConn) Write(p []byte) (int, error) {
+	if len(tc.write.limiters) == 0 {
+		return tc.Conn.Write(p)
+	}
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > tc.chunk {
+			chunk = chunk[:tc.chunk]
+		}
+		if err := tc.wait("write", &tc.write, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := tc.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// wait 等待令牌. 连接关闭返回 net.ErrClosed; 截止时间到达返回 *ContextError,
+// 可用 errors.Is 匹配 os.ErrDeadlineExceeded 或 context.DeadlineExceeded
+func (tc *ThrottledConn) wait(op string, d *throttleDir, n int) error {
+	for _, l := range d.limiters {
+		for {
+			d.mu.Lock()
+			dl := d.deadline
+			ctx, wake := context.WithCancel(tc.ctx)
+			d.wake = wake
+			d.mu.Unlock()
+			cancel := func() {}
+			if !dl.IsZero() {
+				ctx, cancel = context.WithDeadline(ctx, dl)
+			}
+
+			err := l.Wait(ctx, n)
+			cancel()
+			wake()
+			d.mu.Lock()
+			d.wake = nil
+			d.mu.Unlock()
+			switch {
+			case err == nil:
+			case tc.ctx.Err() != nil:
+				return net.ErrClosed
+			case !dl.IsZero() && !time.Now().Before(dl):
+				return &ContextError{Op: op, Err: context.DeadlineExceeded, Cause: os.ErrDeadlineExceeded}
+			default:
+				// 截止时间被修改, 按新的截止时间重新等待
+				continue
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// SetDeadline 同时设置读写截止时间, 对限速等待同样生效
+func (tc *ThrottledConn) SetDeadline(t time.Time) error {
+	tc.read.setDeadline(t)
+	tc.write.setDeadline(t)
+	return tc.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline 设置读截止时间
+func (tc *ThrottledConn) SetReadDeadline(t time.Time) error {
+	tc.read.setDeadline(t)
+	return tc.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline 设置写截止时间
+func (tc *ThrottledConn) SetWriteDeadline(t time.Time) error {
+	tc.write.setDeadline(t)
+	return tc.Conn.SetWriteDeadline(t)
+}
+
+// Close 关闭连接, 唤醒等待中的读写并从调度器注销
+func (tc *ThrottledConn) Close() error {
+	tc.once.Do(func() {
+		tc.cancel()
+		for _, st := range tc.streams {
+			st.Close()
+		}
+	})
+	return tc.Conn.Close()
+}
+
+// Unwrap 返回被封装的连接
+func (tc *ThrottledConn) Unwrap() net.Conn { return tc.Conn }
@@ -0,0 +1,66 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestThrottledConnWaitErrors(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			if _, err := b.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	tc := NewThrottledConn(a, &Throttle{WriteRate: 10, Burst: 1})
+
+	tc.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err := tc.Write([]byte("0123456789"))
+	var ce *ContextError
+	if !errors.As(err, &ce) || ce.Op != "write" {
+		t.Fatalf("write past deadline = %v, want *ContextError for write", err)
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("write past deadline = %v, want it to match both deadline errors", err)
+	}
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("write past deadline = %v, want a net.Error timeout", err)
+	}
+
+	tc.SetWriteDeadline(time.Time{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := tc.Write([]byte("0123456789"))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	tc.Close()
+	if err := <-done; !errors.Is(err, net.ErrClosed) || errors.As(err, &ce) {
+		t.Fatalf("write interrupted by Close = %v, want net.ErrClosed", err)
+	}
+}
+
+func TestThrottledConnReadContextCanceled(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	go b.Write([]byte("0123456789"))
+	c := NewConn(NewThrottledConn(a, &Throttle{ReadRate: 1, Burst: 1}))
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	p := make([]byte, 10)
+	// 数据已读到, 取消发生在限速等待期间
+	if _, err := c.ReadContext(ctx, p); !errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ReadContext after cancel = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,180 @@
+package tcp
+
+/*
+	TLS 连接支持, 在拨号和接入两侧完成握手, 上层通过 Conn 读取协商结果
+*/
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultHandshakeTimeout 默认的 TLS 握手超时
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// DefaultSessionCacheSize 客户端默认缓存的会话数
+const DefaultSessionCacheSize = 64
+
+// TLSOptions TLS 配置
+type TLSOptions struct {
+	// Config 基础配置 (证书、根证书等), 不会被修改
+	Config *tls.Config
+	// HandshakeTimeout 握手超时, 0 使用 DefaultHandshakeTimeout, 负数表示不限制
+	HandshakeTimeout time.Duration
+	// NextProtos ALPN 协议列表, 非空时覆盖 Config 中的设置
+	NextProtos []string
+	// SessionCacheSize 客户端会话缓存大小, 用于会话恢复; 0 使用默认值, 负数关闭
+	SessionCacheSize int
+	// SessionTicketKeys 服务端会话票据密钥, 第一个用于加密, 其余用于解密 (便于轮换)
+	SessionTicketKeys [][32]byte
+	// DisableSessionTickets 服务端关闭会话票据
+	DisableSessionTickets bool
+
+	once         sync.Once
+	sessionCache tls.ClientSessionCache
+	serverConfig *tls.Config
+}
+
+// ClientConfig 返回客户端使用的配置, serverName 为空时沿用 Config.ServerName
+func (o *TLSOptions) ClientConfig(serverName string) *tls.Config {
+	o.init()
+	cfg := o.base()
+	if serverName != "" && cfg.ServerName == "" {
+		cfg.ServerName = serverName
+	}
+	if cfg.ClientSessionCache == nil {
+		cfg.ClientSessionCache = o.sessionCache
+	}
+	return cfg
+}
+
+// ServerConfig 返回服务端使用的配置, 多次调用返回同一实例以共享票据密钥
+func (o *TLSOptions) ServerConfig() *tls.Config {
+	o.init()
+	return o.serverConfig
+}
+
+func (o *TLSOptions) init() {
+	o.once.Do(func() {
+		if o.SessionCacheSize >= 0 {
+			size := o.SessionCacheSize
+			if size == 0 {
+				size = DefaultSessionCacheSize
+			}
+			o.sessionCache = tls.NewLRUClientSessionCache(size)
+		}
+		cfg := o.base()
+		cfg.SessionTicketsDisabled = cfg.SessionTicketsDisabled || o.DisableSessionTickets
+		if len(o.SessionTicketKeys) > 0 {
+			cfg.SetSessionTicketKeys(o.SessionTicketKeys)
+		}
+		o.serverConfig = cfg
+	})
+}
+
+func (o *TLSOptions) base() *tls.Config {
+	var cfg *tls.Config
+	if o.Config != nil {
+		cfg = o.Config.Clone()
+	} else {
+		cfg = &tls.Config{}
+	}
+	if len(o.NextProtos) > 0 {
+		cfg.NextProtos = append([]string(nil), o.NextProtos...)
+	}
+	return cfg
+}
+
+func (o *TLSOptions) handshakeTimeout() time.Duration {
+	switch {
+	case o.HandshakeTimeout == 0:
+		return DefaultHandshakeTimeout
+	case o.HandshakeTimeout < 0:
+		return 0
+	}
+	return o.HandshakeTimeout
+}
+
+// TLSState TLS 握手结果
+type TLSState struct {
+	Version            uint16
+	CipherSuite        uint16
+	NegotiatedProtocol string
+	ServerName         string
+	DidResume          bool
+	HandshakeDuration  time.Duration
+	// Raw 完整的连接状态, 需要证书链等细节时使用
+	Raw tls.ConnectionState
+}
+
+// ClientHandshake 以客户端身份在 c 上完成 TLS 握手, 返回保留原连接 ID 的新 Conn
+func ClientHandshake(ctx context.Context, c net.Conn, serverName string, opts *TLSOptions) (*Conn, error) {
+	if opts == nil {
+		opts = &TLSOptions{}
+	}
+	return handshake(ctx, c, tls.Client(c, opts.ClientConfig(serverName)), opts)
+}
+
+// ServerHandshake 以服务端身份在 c 上完成 TLS 握手
+func ServerHandshake(ctx context.Context, c net.Conn, opts *TLSOptions) (*Conn, error) {
+	if opts == nil {
+		return nil, errors.New("tcp: nil TLS options")
+	}
+	return handshake(ctx, c, tls.Server(c, opts.ServerConfig()), opts)
+}
+
+func handshake(ctx context.Context, raw net.Conn, tc *tls.Conn, opts *TLSOptions) (*Conn, error) {
+	if d := opts.handshakeTimeout(); d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	start := time.Now()
+	if err := tc.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		return nil, ctxError("handshake", ctx, err)
+	}
+	cs := tc.ConnectionState()
+
+	conn := NewConn(tc)
+	if base, ok := raw.(*Conn); ok {
+		conn.id = base.id
+		conn.createdAt = base.createdAt
+		conn.meta = base.meta
+		conn.mem = base.mem
+	}
+	if cs.NegotiatedProtocol != "" {
+		conn.meta.Set(MetaALPN, cs.NegotiatedProtocol)
+	}
+	conn.tls = &TLSState{
+		Version:            cs.Version,
+		CipherSuite:        cs.CipherSuite,
+		NegotiatedProtocol: cs.NegotiatedProtocol,
+		ServerName:         cs.ServerName,
+		DidResume:          cs.DidResume,
+		HandshakeDuration:  time.Since(start),
+		Raw:                cs,
+	}
+	return conn, nil
+}
+
+// DialTLSContext 建立 TCP 连接并完成 TLS 握手, 未设置 d.TLS 时使用默认配置
+func (d *Dialer) DialTLSContext(ctx context.Context, network, addr string) (*Conn, error) {
+	c, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	opts := d.TLS
+	if opts == nil {
+		opts = &TLSOptions{}
+	}
+	return ClientHandshake(ctx, c, host, opts)
+}
@@ -0,0 +1,147 @@
+package tcp
+
+/*
+	时间轮, 多个连接共享一个 goroutine 处理超时, 精度为一个刻度
+*/
+
+import (
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// 默认时间轮参数: 500ms 一格, 一圈 512 格 (约 256s), 更长的超时按圈数计算
+const (
+	DefaultWheelTick  = 500 * time.Millisecond
+	DefaultWheelSlots = 512
+)
+
+var (
+	defaultWheelOnce sync.Once
+	defaultWheel     *TimerWheel
+)
+
+// DefaultTimerWheel 返回进程共享的时间轮
+func DefaultTimerWheel() *TimerWheel {
+	defaultWheelOnce.Do(func() {
+		defaultWheel = NewTimerWheel(DefaultWheelTick, DefaultWheelSlots)
+	})
+	return defaultWheel
+}
+
+// TimerWheel 哈希时间轮, 回调在时间轮 goroutine 中执行, 不应阻塞
+type TimerWheel struct {
+	tick  time.Duration
+	mu    sync.Mutex
+	slots []map[*WheelTimer]struct{}
+	pos   int
+	run   bool
+	stop  chan struct{}
+	clock utils.Clock
+}
+
+// WheelTimer 时间轮上的定时器
+type WheelTimer struct {
+	w      *TimerWheel
+	slot   int
+	rounds int
+	fn     func()
+}
+
+// NewTimerWheel 创建时间轮, 后台 goroutine 在首次添加定时器时启动
+func NewTimerWheel(tick time.Duration, slots int) *TimerWheel {
+	if tick <= 0 {
+		tick = DefaultWheelTick
+	}
+	if slots <= 0 {
+		slots = DefaultWheelSlots
+	}
+	w := &TimerWheel{tick: tick, slots: make([]map[*WheelTimer]struct{}, slots), stop: make(chan struct{})}
+	for i := range w.slots {
+		w.slots[i] = make(map[*WheelTimer]struct{})
+	}
+	return w
+}
+
+// AfterFunc 在 d 之后 (向上取整到刻度) 调用 fn
+func (w *TimerWheel) AfterFunc(d time.Duration, fn func()) *WheelTimer {
+	ticks := int((d + w.tick - 1) / w.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t := &WheelTimer{
+		w:      w,
+		slot:   (w.pos + ticks) % len(w.slots),
+		rounds: (ticks - 1) / len(w.slots),
+		fn:     fn,
+	}
+	w.slots[t.slot][t] = struct{}{}
+	if !w.run {
+		w.run = true
+		go w.loop()
+	}
+	return t
+}
+
+// Stop 取消定时器, 已触发或已取消时返回 false
+func (t *WheelTimer) Stop() bool {
+	t.w.mu.Lock()
+	defer t.w.mu.Unlock()
+	if _, ok := t.w.slots[t.slot][t]; !ok {
+		return false
+	}
+	delete(t.w.slots[t.slot], t)
+	return true
+}
+
+// SetClock 替换驱动时间轮的时钟, 须在添加第一个定时器之前调用
+func (w *TimerWheel) SetClock(c utils.Clock) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.clock = c
+}
+
+// Stop 停止时间轮, 未触发的定时器不会再执行
+func (w *TimerWheel) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-w.stop:
+	default:
+		close(w.stop)
+	}
+}
+
+func (w *TimerWheel) loop() {
+	w.mu.Lock()
+	ticker := utils.ClockOr(w.clock).NewTicker(w.tick)
+	w.mu.Unlock()
+	defer ticker.Stop()
+	var due []func()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C():
+		}
+		w.mu.Lock()
+		w.pos = (w.pos + 1) % len(w.slots)
+		for t := range w.slots[w.pos] {
+			if t.rounds > 0 {
+				t.rounds--
+				continue
+			}
+			delete(w.slots[w.pos], t)
+			due = append(due, t.fn)
+		}
+		w.mu.Unlock()
+		for i, fn := range due {
+			fn()
+			due[i] = nil
+		}
+		due = due[:0]
+	}
+}
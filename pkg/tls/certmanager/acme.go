@@ -0,0 +1,510 @@
+package certmanager
+
+/*
+	ACME 协议客户端 (RFC 8555): 目录、nonce、账户注册、订单、授权与挑战、最终化和证书下载.
+	所有请求都经过 JWS 签名, 读取资源使用 POST-as-GET; 服务器返回 badNonce 时换新 nonce 重试
+*/
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/client"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+// LetsEncryptURL Let's Encrypt 正式环境的目录地址
+const LetsEncryptURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+// LetsEncryptStagingURL Let's Encrypt 测试环境的目录地址, 签发的证书不受信任但限额宽松
+const LetsEncryptStagingURL = "https://acme-staging-v02.api.letsencrypt.org/directory"
+
+// 订单、授权和挑战的状态
+const (
+	StatusPending     = "pending"
+	StatusReady       = "ready"
+	StatusProcessing  = "processing"
+	StatusValid       = "valid"
+	StatusInvalid     = "invalid"
+	StatusDeactivated = "deactivated"
+	StatusExpired     = "expired"
+	StatusRevoked     = "revoked"
+)
+
+// maxBadNonceRetries 收到 badNonce 后的最大重试次数
+const maxBadNonceRetries = 3
+
+// defaultPollInterval 服务器未给出 Retry-After 时的轮询间隔
+const defaultPollInterval = time.Second
+
+// maxResponseSize ACME 响应 (包括证书链) 的最大长度
+const maxResponseSize = 1 << 20
+
+// ErrNoAccount 客户端尚未注册或找回账户
+var ErrNoAccount = errors.New("certmanager: no ACME account registered")
+
+// Error ACME 服务器返回的问题文档 (RFC 7807)
+type Error struct {
+	StatusCode  int     `json:"status"`
+	Type        string  `json:"type"`
+	Detail      string  `json:"detail"`
+	Subproblems []Error `json:"subproblems,omitempty"`
+}
+
+// Error 实现 error
+func (e *Error) Error() string {
+	s := fmt.Sprintf("acme: %d %s: %s", e.StatusCode, strings.TrimPrefix(e.Type, "urn:ietf:params:acme:error:"), e.Detail)
+	for _, sp := range e.Subproblems {
+		s += "; " + sp.Detail
+	}
+	return s
+}
+
+func (e *Error) is(kind string) bool { return e.Type == "urn:ietf:params:acme:error:"+kind }
+
+// Directory ACME 服务器的目录
+type Directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+	RevokeCert string `json:"revokeCert"`
+	KeyChange  string `json:"keyChange"`
+	Meta       struct {
+		TermsOfService string `json:"termsOfService"`
+		Website        string `json:"website"`
+	} `json:"meta"`
+}
+
+// Identifier 订单中的标识, 目前只使用 dns 类型
+type Identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Order 证书订单
+type Order struct {
+	// URL 订单地址, 来自创建订单时的 Location
+	URL            string       `json:"-"`
+	Status         string       `json:"status"`
+	Identifiers    []Identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate,omitempty"`
+	Error          *Error       `json:"error,omitempty"`
+}
+
+// Authorization 对一个标识的授权
+type Authorization struct {
+	URL        string       `json:"-"`
+	Status     string       `json:"status"`
+	Identifier Identifier   `json:"identifier"`
+	Challenges []*Challenge `json:"challenges"`
+	Wildcard   bool         `json:"wildcard,omitempty"`
+}
+
+// Challenge 授权的一种验证方式
+type Challenge struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+	Error  *Error `json:"error,omitempty"`
+}
+
+// Client ACME 客户端, 可以并发使用
+type Client struct {
+	// DirectoryURL 目录地址, 为空时使用 LetsEncryptURL
+	DirectoryURL string
+	// Key 账户密钥
+	Key *ecdsa.PrivateKey
+	// HTTPClient 发送请求的客户端, 为空时使用默认配置
+	HTTPClient *client.Client
+
+	mu     sync.Mutex
+	dir    *Directory
+	kid    string
+	nonces []string
+}
+
+// Discover 读取目录, 结果被缓存
+func (c *Client) Discover(ctx context.Context) (*Directory, error) {
+	c.mu.Lock()
+	dir := c.dir
+	c.mu.Unlock()
+	if dir != nil {
+		return dir, nil
+	}
+	u := c.DirectoryURL
+	if u == "" {
+		u = LetsEncryptURL
+	}
+	resp, body, err := c.do(ctx, common.MethodGet, u, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != common.StatusOK {
+		return nil, responseError(resp, body)
+	}
+	dir = &Directory{}
+	if err := json.Unmarshal(body, dir); err != nil {
+		return nil, fmt.Errorf("certmanager: decoding directory: %w", err)
+	}
+	if dir.NewNonce == "" || dir.NewAccount == "" || dir.NewOrder == "" {
+		return nil, errors.New("certmanager: incomplete ACME directory")
+	}
+	c.mu.Lock()
+	c.dir = dir
+	c.mu.Unlock()
+	return dir, nil
+}
+
+// Register 注册账户, 账户已存在时找回它; agreeTOS 表示同意服务条款
+func (c *Client) Register(ctx context.Context, contact []string, agreeTOS bool) error {
+	dir, err := c.Discover(ctx)
+	if err != nil {
+		return err
+	}
+	req := struct {
+		Contact []string `json:"contact,omitempty"`
+		TOS     bool     `json:"termsOfServiceAgreed,omitempty"`
+	}{contact, agreeTOS}
+	resp, body, err := c.post(ctx, dir.NewAccount, req, false)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != common.StatusOK && resp.StatusCode != common.StatusCreated {
+		return responseError(resp, body)
+	}
+	kid := resp.Header.Get("Location")
+	if kid == "" {
+		return errors.New("certmanager: account response without Location")
+	}
+	c.mu.Lock()
+	c.kid = kid
+	c.mu.Unlock()
+	return nil
+}
+
+// AuthorizeOrder 为 domains 创建订单
+func (c *Client) AuthorizeOrder(ctx context.Context, domains ...string) (*Order, error) {
+	dir, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req := struct {
+		Identifiers []Identifier `json:"identifiers"`
+	}{}
+	for _, d := range domains {
+		req.Identifiers = append(req.Identifiers, Identifier{Type: "dns", Value: d})
+	}
+	resp, body, err := c.post(ctx, dir.NewOrder, req, true)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != common.StatusCreated {
+		return nil, responseError(resp, body)
+	}
+	o := &Order{URL: resp.Header.Get("Location")}
+	if err := json.Unmarshal(body, o); err != nil {
+		return nil, fmt.Errorf("certmanager: decoding order: %w", err)
+	}
+	return o, nil
+}
+
+// GetOrder 读取订单的当前状态
+func (c *Client) GetOrder(ctx context.Context, url string) (*Order, error) {
+	o := &Order{URL: url}
+	if _, err := c.fetch(ctx, url, o); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// GetAuthorization 读取授权
+func (c *Client) GetAuthorization(ctx context.Context, url string) (*Authorization, error) {
+	a := &Authorization{URL: url}
+	if _, err := c.fetch(ctx, url, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Accept 通知服务器挑战已就绪, 可以开始验证
+func (c *Client) Accept(ctx context.Context, ch *Challenge) error {
+	resp, body, err := c.post(ctx, ch.URL, struct{}{}, true)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != common.StatusOK {
+		return responseError(resp, body)
+	}
+	return nil
+}
+
+// WaitAuthorization 轮询授权直到验证完成; 授权失败时返回挑战的错误
+func (c *Client) WaitAuthorization(ctx context.Context, url string) (*Authorization, error) {
+	for {
+		a := &Authorization{URL: url}
+		resp, err := c.fetch(ctx, url, a)
+		if err != nil {
+			return nil, err
+		}
+		switch a.Status {
+		case StatusValid:
+			return a, nil
+		case StatusPending, StatusProcessing:
+		default:
+			for _, ch := range a.Challenges {
+				if ch.Error != nil {
+					return a, fmt.Errorf("certmanager: authorization for %s %s: %w", a.Identifier.Value, a.Status, ch.Error)
+				}
+			}
+			return a, fmt.Errorf("certmanager: authorization for %s %s", a.Identifier.Value, a.Status)
+		}
+		if err := sleep(ctx, retryAfter(resp)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// WaitOrder 轮询订单直到它进入 ready 或 valid 状态
+func (c *Client) WaitOrder(ctx context.Context, url string) (*Order, error) {
+	for {
+		o := &Order{URL: url}
+		resp, err := c.fetch(ctx, url, o)
+		if err != nil {
+			return nil, err
+		}
+		switch o.Status {
+		case StatusReady, StatusValid:
+			return o, nil
+		case StatusPending, StatusProcessing:
+		default:
+			if o.Error != nil {
+				return o, fmt.Errorf("certmanager: order %s: %w", o.Status, o.Error)
+			}
+			return o, fmt.Errorf("certmanager: order %s", o.Status)
+		}
+		if err := sleep(ctx, retryAfter(resp)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Finalize 提交 CSR (DER 编码) 并等待签发, 返回证书链 (DER, 叶子证书在前)
+func (c *Client) Finalize(ctx context.Context, o *Order, csr []byte) ([][]byte, error) {
+	req := struct {
+		CSR string `json:"csr"`
+	}{b64(csr)}
+	resp, body, err := c.post(ctx, o.Finalize, req, true)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != common.StatusOK {
+		return nil, responseError(resp, body)
+	}
+	if o, err = c.WaitOrder(ctx, o.URL); err != nil {
+		return nil, err
+	}
+	for o.Status != StatusValid {
+		// ready 表示服务器还未开始处理, 继续等待
+		if err := sleep(ctx, defaultPollInterval); err != nil {
+			return nil, err
+		}
+		if o, err = c.WaitOrder(ctx, o.URL); err != nil {
+			return nil, err
+		}
+	}
+	if o.Certificate == "" {
+		return nil, errors.New("certmanager: valid order without certificate URL")
+	}
+	return c.FetchCert(ctx, o.Certificate)
+}
+
+// FetchCert 下载 PEM 证书链
+func (c *Client) FetchCert(ctx context.Context, url string) ([][]byte, error) {
+	resp, body, err := c.post(ctx, url, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != common.StatusOK {
+		return nil, responseError(resp, body)
+	}
+	var chain [][]byte
+	for {
+		var b *pem.Block
+		b, body = pem.Decode(body)
+		if b == nil {
+			break
+		}
+		if b.Type == "CERTIFICATE" {
+			chain = append(chain, b.Bytes)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("certmanager: no certificates in ACME response")
+	}
+	return chain, nil
+}
+
+// fetch 以 POST-as-GET 读取资源并解码到 v
+func (c *Client) fetch(ctx context.Context, url string, v any) (*message.Response, error) {
+	resp, body, err := c.post(ctx, url, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != common.StatusOK {
+		return nil, responseError(resp, body)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return nil, fmt.Errorf("certmanager: decoding %s: %w", url, err)
+	}
+	return resp, nil
+}
+
+// post 发送签名请求; useKID 为 false 时用 jwk 携带公钥 (仅用于注册账户)
+func (c *Client) post(ctx context.Context, url string, payload any, useKID bool) (*message.Response, []byte, error) {
+	if c.Key == nil {
+		return nil, nil, errors.New("certmanager: ACME client without account key")
+	}
+	var kid string
+	if useKID {
+		c.mu.Lock()
+		kid = c.kid
+		c.mu.Unlock()
+		if kid == "" {
+			return nil, nil, ErrNoAccount
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		nonce, err := c.nonce(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		jws, err := signJWS(c.Key, kid, nonce, url, payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		resp, body, err := c.do(ctx, common.MethodPost, url, jws, "application/jose+json")
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp.StatusCode == common.StatusBadRequest && attempt < maxBadNonceRetries {
+			var e *Error
+			if errors.As(responseError(resp, body), &e) && e.is("badNonce") {
+				continue
+			}
+		}
+		return resp, body, nil
+	}
+}
+
+// nonce 取出一个缓存的 nonce, 没有时向 newNonce 请求
+func (c *Client) nonce(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	if n := len(c.nonces); n > 0 {
+		nonce := c.nonces[n-1]
+		c.nonces = c.nonces[:n-1]
+		c.mu.Unlock()
+		return nonce, nil
+	}
+	c.mu.Unlock()
+	dir, err := c.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	resp, body, err := c.do(ctx, common.MethodHead, dir.NewNonce, nil, "")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != common.StatusOK && resp.StatusCode != common.StatusNoContent {
+		return "", responseError(resp, body)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// do 已把响应中的 nonce 放入缓存
+	if n := len(c.nonces); n > 0 {
+		nonce := c.nonces[n-1]
+		c.nonces = c.nonces[:n-1]
+		return nonce, nil
+	}
+	return "", errors.New("certmanager: newNonce response without Replay-Nonce")
+}
+
+// do 发送请求并读完响应体, 记录响应中的 Replay-Nonce
+func (c *Client) do(ctx context.Context, method, url string, body []byte, contentType string) (*message.Response, []byte, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := message.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = defaultHTTPClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, nil, err
+	}
+	if nonce := resp.Header.Get("Replay-Nonce"); nonce != "" {
+		c.mu.Lock()
+		c.nonces = append(c.nonces, nonce)
+		c.mu.Unlock()
+	}
+	return resp, data, nil
+}
+
+var defaultHTTPClient = client.New(client.WithTimeout(30*time.Second), client.WithUserAgent("http-stack-certmanager/0.1"))
+
+// responseError 把错误响应转换为 *Error, 响应体不是问题文档时使用状态行
+func responseError(resp *message.Response, body []byte) error {
+	e := &Error{}
+	if json.Unmarshal(body, e) != nil || e.Type == "" {
+		e = &Error{Detail: resp.Status}
+		if len(body) > 0 && len(body) < 256 {
+			e.Detail += ": " + strings.TrimSpace(string(body))
+		}
+	}
+	e.StatusCode = resp.StatusCode
+	return e
+}
+
+// retryAfter 返回服务器要求的轮询间隔
+func retryAfter(resp *message.Response) time.Duration {
+	if d, ok := common.ParseRetryAfter(resp.Header.Get("Retry-After"), nil); ok && d > 0 {
+		return min(d, time.Minute)
+	}
+	return defaultPollInterval
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
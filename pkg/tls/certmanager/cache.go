@@ -0,0 +1,124 @@
+package certmanager
+
+/*
+	证书与账户密钥的持久化: DirCache 保存在磁盘目录中 (文件权限 0600), MemCache 只保存在内存中
+*/
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrCacheMiss 缓存中没有该键
+var ErrCacheMiss = errors.New("certmanager: cache miss")
+
+// Cache 证书缓存, 实现必须可以并发使用. 值是 PEM 编码的私钥和证书链
+type Cache interface {
+	// Get 读取键对应的数据, 不存在时返回 ErrCacheMiss
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, data []byte) error
+	// Delete 删除键, 不存在时不是错误
+	Delete(ctx context.Context, key string) error
+}
+
+// DirCache 基于目录的缓存, 每个键一个文件, 目录不存在时自动创建
+type DirCache string
+
+// Get 实现 Cache
+func (d DirCache) Get(ctx context.Context, key string) ([]byte, error) {
+	name, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCacheMiss
+	}
+	return b, err
+}
+
+// Put 先写临时文件再重命名, 避免读到写了一半的内容
+func (d DirCache) Put(ctx context.Context, key string, data []byte) error {
+	name, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(string(d), 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(string(d), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Delete 实现 Cache
+func (d DirCache) Delete(ctx context.Context, key string) error {
+	name, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// path 键是域名或内部名称, 拒绝可能逃出目录的键
+func (d DirCache) path(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || key == "." || key == ".." || strings.HasPrefix(key, ".tmp-") {
+		return "", errors.New("certmanager: invalid cache key " + key)
+	}
+	return filepath.Join(string(d), key), nil
+}
+
+// MemCache 内存缓存, 进程重启后丢失; 零值可用
+type MemCache struct {
+	mu   sync.RWMutex
+	data map[string][]byte
+}
+
+// Get 实现 Cache
+func (m *MemCache) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	b, ok := m.data[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	return append([]byte(nil), b...), nil
+}
+
+// Put 实现 Cache
+func (m *MemCache) Put(ctx context.Context, key string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.data == nil {
+		m.data = make(map[string][]byte)
+	}
+	m.data[key] = append([]byte(nil), data...)
+	return nil
+}
+
+// Delete 实现 Cache
+func (m *MemCache) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data, key)
+	return nil
+}
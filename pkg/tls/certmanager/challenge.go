@@ -0,0 +1,121 @@
+package certmanager
+
+/*
+	挑战的应答: HTTP-01 在 /.well-known/acme-challenge/<token> 返回密钥授权,
+	TLS-ALPN-01 在协商 acme-tls/1 的握手中出示带 acmeIdentifier 扩展的自签名证书 (RFC 8737)
+*/
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/message"
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+	"github.com/narcilee7/http-stack/pkg/http/server"
+)
+
+// 支持的挑战类型
+const (
+	ChallengeHTTP01    = "http-01"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+)
+
+// httpChallengePrefix HTTP-01 挑战的路径前缀
+const httpChallengePrefix = "/.well-known/acme-challenge/"
+
+// idPeACMEIdentifier acmeIdentifier 扩展的 OID (RFC 8737 3)
+var idPeACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// HTTPHandler 返回应答 HTTP-01 挑战的处理器, 其余请求交给 fallback;
+// fallback 为空时把 GET/HEAD 请求重定向到 https, 其他请求返回 400
+func (m *Manager) HTTPHandler(fallback server.Handler) server.Handler {
+	return server.HandlerFunc(func(w server.ResponseWriter, req *message.Request) {
+		if token, ok := strings.CutPrefix(req.URL.Path, httpChallengePrefix); ok {
+			m.mu.Lock()
+			keyAuth, found := m.tokens[token]
+			m.mu.Unlock()
+			if !found {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				w.WriteHeader(common.StatusNotFound)
+				w.Write([]byte("unknown ACME challenge token\n"))
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(keyAuth))
+			return
+		}
+		if fallback != nil {
+			fallback.ServeHTTP(w, req)
+			return
+		}
+		if req.Method != common.MethodGet && req.Method != common.MethodHead {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(common.StatusBadRequest)
+			w.Write([]byte("use HTTPS\n"))
+			return
+		}
+		// 去掉明文端口, 使用 https 的默认端口
+		host := req.HostHeader()
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+			if strings.Contains(h, ":") {
+				host = "[" + h + "]"
+			}
+		}
+		w.Header().Set("Location", "https://"+host+req.URL.RequestURI())
+		w.WriteHeader(common.StatusFound)
+	})
+}
+
+// tlsALPNCert 生成 TLS-ALPN-01 挑战使用的自签名证书
+func tlsALPNCert(domain, keyAuth string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(keyAuth))
+	ext, err := asn1.Marshal(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "ACME challenge"},
+		DNSNames:              []string{domain},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		ExtraExtensions:       []pkix.Extension{{Id: idPeACMEIdentifier, Critical: true, Value: ext}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// isACMEHello 客户端只提供 acme-tls/1, 说明这是 TLS-ALPN-01 验证
+func isACMEHello(hello *tls.ClientHelloInfo) bool {
+	return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == server.NextProtoACME
+}
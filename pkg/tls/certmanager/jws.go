@@ -0,0 +1,112 @@
+package certmanager
+
+/*
+	ACME 请求的 JWS 签名 (RFC 7515 平铺 JSON 序列化, RFC 8555 6.2): 账户密钥为 ECDSA P-256, 算法 ES256.
+	注册前用 jwk 携带公钥, 注册后用 kid 引用账户 URL
+*/
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// errUnsupportedKey 账户密钥不是 ECDSA P-256
+var errUnsupportedKey = errors.New("certmanager: account key must be ECDSA P-256")
+
+// jwsMessage 平铺 JSON 序列化的 JWS
+type jwsMessage struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// jwkEC EC 公钥的 JWK, 字段按字典序排列, 序列化结果可直接用于计算指纹 (RFC 7638)
+type jwkEC struct {
+	Crv string `json:"crv"`
+	Kty string `json:"kty"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// jwkFor 返回公钥的 JWK
+func jwkFor(pub crypto.PublicKey) (*jwkEC, error) {
+	k, ok := pub.(*ecdsa.PublicKey)
+	if !ok || k.Curve != elliptic.P256() {
+		return nil, errUnsupportedKey
+	}
+	raw, err := k.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	// 未压缩格式: 0x04 || X || Y, 坐标都是 32 字节
+	b := raw.Bytes()
+	return &jwkEC{Crv: "P-256", Kty: "EC", X: b64(b[1:33]), Y: b64(b[33:])}, nil
+}
+
+// thumbprint 返回公钥的 JWK 指纹 (RFC 7638), base64url 编码
+func thumbprint(pub crypto.PublicKey) (string, error) {
+	jwk, err := jwkFor(pub)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(jwk)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return b64(sum[:]), nil
+}
+
+// signJWS 对 payload 签名; kid 为空时在头部携带 jwk. payload 为 nil 时生成 POST-as-GET 使用的空载荷
+func signJWS(key *ecdsa.PrivateKey, kid, nonce, url string, payload any) ([]byte, error) {
+	header := map[string]any{"alg": "ES256", "nonce": nonce, "url": url}
+	if kid != "" {
+		header["kid"] = kid
+	} else {
+		jwk, err := jwkFor(key.Public())
+		if err != nil {
+			return nil, err
+		}
+		header["jwk"] = jwk
+	}
+	ph, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	var pl []byte
+	if payload != nil {
+		if pl, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+	msg := jwsMessage{Protected: b64(ph), Payload: b64(pl)}
+	digest := sha256.Sum256([]byte(msg.Protected + "." + msg.Payload))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("certmanager: signing request: %w", err)
+	}
+	// ES256 的签名是定长的 R || S, 而不是 ASN.1 DER
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	msg.Signature = b64(sig)
+	return json.Marshal(msg)
+}
+
+// keyAuthorization 返回挑战的密钥授权 token.指纹 (RFC 8555 8.1)
+func keyAuthorization(key *ecdsa.PrivateKey, token string) (string, error) {
+	tp, err := thumbprint(key.Public())
+	if err != nil {
+		return "", err
+	}
+	return token + "." + tp, nil
+}
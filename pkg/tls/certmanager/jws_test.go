@@ -0,0 +1,129 @@
+package certmanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// verifyJWS 校验 ES256 签名并解出头部和载荷
+func verifyJWS(pub *ecdsa.PublicKey, data []byte) (header map[string]any, payload []byte, err error) {
+	var msg jwsMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(msg.Signature)
+	if err != nil || len(sig) != 64 {
+		return nil, nil, errors.New("malformed ES256 signature")
+	}
+	digest := sha256.Sum256([]byte(msg.Protected + "." + msg.Payload))
+	if !ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, nil, errors.New("bad signature")
+	}
+	ph, err := base64.RawURLEncoding.DecodeString(msg.Protected)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(ph, &header); err != nil {
+		return nil, nil, err
+	}
+	payload, err = base64.RawURLEncoding.DecodeString(msg.Payload)
+	return header, payload, err
+}
+
+// publicKeyFromJWK 由 JWK 的 x、y 还原 P-256 公钥
+func publicKeyFromJWK(jwk map[string]any) (*ecdsa.PublicKey, error) {
+	if jwk["kty"] != "EC" || jwk["crv"] != "P-256" {
+		return nil, errUnsupportedKey
+	}
+	x, err1 := base64.RawURLEncoding.DecodeString(jwk["x"].(string))
+	y, err2 := base64.RawURLEncoding.DecodeString(jwk["y"].(string))
+	if err1 != nil || err2 != nil || len(x) != 32 || len(y) != 32 {
+		return nil, errors.New("malformed JWK coordinates")
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
+
+func TestSignJWS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 注册前: 头部携带 jwk, 不带 kid
+	data, err := signJWS(key, "", "nonce-1", "https://ca/new-account", map[string]bool{"termsOfServiceAgreed": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, payload, err := verifyJWS(&key.PublicKey, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header["alg"] != "ES256" || header["nonce"] != "nonce-1" || header["url"] != "https://ca/new-account" || header["kid"] != nil {
+		t.Fatalf("protected header = %v", header)
+	}
+	jwk, _ := header["jwk"].(map[string]any)
+	pub, err := publicKeyFromJWK(jwk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.Equal(&key.PublicKey) {
+		t.Fatal("jwk does not match the account key")
+	}
+	if string(payload) != `{"termsOfServiceAgreed":true}` {
+		t.Fatalf("payload = %s", payload)
+	}
+
+	// 注册后: kid 引用账户, POST-as-GET 的载荷为空
+	data, err = signJWS(key, "https://ca/acct/1", "nonce-2", "https://ca/order/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, payload, err = verifyJWS(&key.PublicKey, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header["kid"] != "https://ca/acct/1" || header["jwk"] != nil || len(payload) != 0 {
+		t.Fatalf("header = %v, payload = %q; want kid only and empty payload", header, payload)
+	}
+
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, _, err := verifyJWS(&other.PublicKey, data); err == nil {
+		t.Fatal("signature verified with a different key")
+	}
+}
+
+func TestThumbprintAndKeyAuthorization(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RFC 7638: 按字典序只保留必需成员, 无空白
+	x := base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32)))
+	y := base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32)))
+	sum := sha256.Sum256([]byte(`{"crv":"P-256","kty":"EC","x":"` + x + `","y":"` + y + `"}`))
+	want := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	tp, err := thumbprint(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp != want {
+		t.Fatalf("thumbprint = %s, want %s", tp, want)
+	}
+	ka, err := keyAuthorization(key, "tok")
+	if err != nil || ka != "tok."+want {
+		t.Fatalf("keyAuthorization = %q, %v", ka, err)
+	}
+
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if _, err := thumbprint(p384.Public()); !errors.Is(err, errUnsupportedKey) {
+		t.Fatalf("thumbprint of a P-384 key = %v, want errUnsupportedKey", err)
+	}
+}
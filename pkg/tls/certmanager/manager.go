@@ -0,0 +1,516 @@
+package certmanager
+
+/*
+	证书管理器: 在 TLS 握手时按 SNI 取得证书, 依次查找内存、缓存, 都没有时通过 ACME 签发;
+	同一域名的并发请求只触发一次签发. 证书在到期前 RenewBefore 自动续期, 失败时退避重试
+*/
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	mathrand "math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/http/protocol/http2"
+	"github.com/narcilee7/http-stack/pkg/http/server"
+	"github.com/narcilee7/http-stack/pkg/log"
+)
+
+// DefaultRenewBefore 默认在证书到期前多久续期
+const DefaultRenewBefore = 30 * 24 * time.Hour
+
+// issueTimeout 一次签发 (包括挑战验证) 的最长时间
+const issueTimeout = 5 * time.Minute
+
+// 续期失败后的重试间隔, 从 renewRetryMin 开始加倍, 不超过 renewRetryMax
+const (
+	renewRetryMin = time.Minute
+	renewRetryMax = time.Hour
+)
+
+// accountKeyName 账户密钥在缓存中的键, 不是合法域名, 不会与证书冲突
+const accountKeyName = "acme_account+key"
+
+var (
+	// ErrHostNotAllowed HostPolicy 拒绝为该主机签发证书
+	ErrHostNotAllowed = errors.New("certmanager: host not allowed")
+	// ErrMissingServerName 客户端没有发送 SNI
+	ErrMissingServerName = errors.New("certmanager: missing server name")
+	// ErrManagerClosed 管理器已关闭
+	ErrManagerClosed = errors.New("certmanager: manager closed")
+)
+
+// HostPolicy 决定是否为 host 签发证书, 返回错误时拒绝
+type HostPolicy func(ctx context.Context, host string) error
+
+// HostWhitelist 只允许给定的主机名 (不区分大小写)
+func HostWhitelist(hosts ...string) HostPolicy {
+	allowed := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		allowed[normalizeHost(h)] = true
+	}
+	return func(_ context.Context, host string) error {
+		if !allowed[normalizeHost(host)] {
+			return fmt.Errorf("%w: %s", ErrHostNotAllowed, host)
+		}
+		return nil
+	}
+}
+
+// Manager 通过 ACME 自动签发和续期证书, 把 GetCertificate 设置到 tls.Config 即可使用
+type Manager struct {
+	// Client ACME 客户端, 为空时使用 Let's Encrypt; Key 为空时从 Cache 读取或生成账户密钥
+	Client *Client
+	// Cache 保存证书和账户密钥, 为空时只保存在内存中, 重启后需要重新签发
+	Cache Cache
+	// HostPolicy 为空时允许任意主机名, 这样任何人都可以让服务器申请证书, 生产环境应当设置
+	HostPolicy HostPolicy
+	// Email 注册账户时的联系邮箱, 可以为空
+	Email string
+	// AcceptTOS 同意 ACME 服务器的服务条款, Let's Encrypt 要求为 true
+	AcceptTOS bool
+	// RenewBefore 到期前多久续期, 0 时使用 DefaultRenewBefore
+	RenewBefore time.Duration
+	// Challenges 按优先级尝试的挑战类型, 为空时先 TLS-ALPN-01 后 HTTP-01;
+	// 使用 HTTP-01 时需要在 80 端口提供 HTTPHandler
+	Challenges []string
+	// Logger 记录签发和续期, 为空时使用 log.Default()
+	Logger *log.Logger
+
+	once      sync.Once
+	accountMu sync.Mutex
+	client    *Client
+	mu        sync.Mutex
+	closed    bool
+	certs     map[string]*tls.Certificate
+	pending   map[string]*issueCall
+	timers    map[string]*time.Timer
+	// tokens HTTP-01 挑战的 token 到密钥授权
+	tokens map[string]string
+	// alpnCerts 域名到 TLS-ALPN-01 挑战证书
+	alpnCerts map[string]*tls.Certificate
+}
+
+// issueCall 一次进行中的取得证书操作, 完成后关闭 done
+type issueCall struct {
+	done chan struct{}
+	cert *tls.Certificate
+	err  error
+}
+
+func (m *Manager) init() {
+	m.once.Do(func() {
+		m.certs = make(map[string]*tls.Certificate)
+		m.pending = make(map[string]*issueCall)
+		m.timers = make(map[string]*time.Timer)
+		m.tokens = make(map[string]string)
+		m.alpnCerts = make(map[string]*tls.Certificate)
+		if m.Cache == nil {
+			m.Cache = &MemCache{}
+		}
+		m.client = m.Client
+		if m.client == nil {
+			m.client = &Client{}
+		}
+	})
+}
+
+// TLSConfig 返回使用本管理器取得证书的配置, ALPN 通告 h2、http/1.1 和 acme-tls/1
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     []string{http2.NextProtoTLS, server.NextProtoHTTP1, server.NextProtoACME},
+	}
+}
+
+// GetCertificate 实现 tls.Config.GetCertificate
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.init()
+	name := normalizeHost(hello.ServerName)
+	if name == "" {
+		return nil, ErrMissingServerName
+	}
+	if isACMEHello(hello) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if c := m.alpnCerts[name]; c != nil {
+			return c, nil
+		}
+		return nil, fmt.Errorf("certmanager: no pending tls-alpn-01 challenge for %s", name)
+	}
+	ctx := hello.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return m.cert(ctx, name)
+}
+
+// cert 返回 name 的有效证书, 必要时从缓存读取或签发
+func (m *Manager) cert(ctx context.Context, name string) (*tls.Certificate, error) {
+	m.mu.Lock()
+	c := m.certs[name]
+	m.mu.Unlock()
+	if c != nil && time.Now().Before(c.Leaf.NotAfter) {
+		return c, nil
+	}
+	if m.HostPolicy != nil {
+		if err := m.HostPolicy(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, ErrManagerClosed
+	}
+	call := m.pending[name]
+	if call == nil {
+		call = &issueCall{done: make(chan struct{})}
+		m.pending[name] = call
+		// 签发与本次握手解耦: 握手超时后签发继续进行, 结果供之后的连接使用
+		go m.obtain(name, call)
+	}
+	m.mu.Unlock()
+	select {
+	case <-call.done:
+		return call.cert, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// obtain 从缓存读取或签发证书, 保存并安排续期
+func (m *Manager) obtain(name string, call *issueCall) {
+	ctx, cancel := context.WithTimeout(context.Background(), issueTimeout)
+	defer cancel()
+	c, err := m.loadCached(ctx, name)
+	if err != nil {
+		if !errors.Is(err, ErrCacheMiss) {
+			m.logger().Warn("certmanager: ignoring cached certificate", log.String("domain", name), log.Err(err))
+		}
+		c, err = m.issue(ctx, name)
+	}
+	m.mu.Lock()
+	delete(m.pending, name)
+	if err == nil {
+		m.certs[name] = c
+		m.scheduleRenewal(name, c, 0)
+	}
+	m.mu.Unlock()
+	call.cert, call.err = c, err
+	close(call.done)
+}
+
+// loadCached 从缓存读取证书, 已过期或不匹配域名时返回错误
+func (m *Manager) loadCached(ctx context.Context, name string) (*tls.Certificate, error) {
+	data, err := m.Cache.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	c, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLeaf(&c, name); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// checkLeaf 解析叶子证书并检查有效期和域名
+func checkLeaf(c *tls.Certificate, name string) error {
+	if c.Leaf == nil {
+		leaf, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			return err
+		}
+		c.Leaf = leaf
+	}
+	now := time.Now()
+	if now.Before(c.Leaf.NotBefore) || !now.Before(c.Leaf.NotAfter) {
+		return fmt.Errorf("certmanager: certificate for %s is not valid now (expires %s)", name, c.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	return c.Leaf.VerifyHostname(name)
+}
+
+// issue 通过 ACME 签发证书并写入缓存; 一种挑战失败时用下一种挑战重新下单
+func (m *Manager) issue(ctx context.Context, name string) (*tls.Certificate, error) {
+	client, err := m.account(ctx)
+	if err != nil {
+		return nil, err
+	}
+	logger := m.logger().With(log.String("domain", name))
+	logger.Info("certmanager: requesting certificate")
+	var order *Order
+	for _, typ := range m.challenges() {
+		if order, err = client.AuthorizeOrder(ctx, name); err != nil {
+			return nil, err
+		}
+		if err = m.authorize(ctx, client, order, typ); err == nil {
+			break
+		}
+		logger.Warn("certmanager: challenge failed", log.String("challenge", typ), log.Err(err))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if order, err = client.WaitOrder(ctx, order.URL); err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: name},
+		DNSNames: []string{name},
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := client.Finalize(ctx, order, csr)
+	if err != nil {
+		return nil, err
+	}
+	data, err := encodeCert(key, chain)
+	if err != nil {
+		return nil, err
+	}
+	c, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, fmt.Errorf("certmanager: issued certificate: %w", err)
+	}
+	if err := checkLeaf(&c, name); err != nil {
+		return nil, fmt.Errorf("certmanager: issued certificate: %w", err)
+	}
+	if err := m.Cache.Put(ctx, name, data); err != nil {
+		logger.Warn("certmanager: caching certificate", log.Err(err))
+	}
+	logger.Info("certmanager: certificate issued", log.String("expires", c.Leaf.NotAfter.Format(time.RFC3339)))
+	return &c, nil
+}
+
+// authorize 用 typ 类型的挑战完成订单中所有待验证的授权
+func (m *Manager) authorize(ctx context.Context, client *Client, order *Order, typ string) error {
+	for _, u := range order.Authorizations {
+		a, err := client.GetAuthorization(ctx, u)
+		if err != nil {
+			return err
+		}
+		if a.Status == StatusValid {
+			continue
+		}
+		if a.Status != StatusPending {
+			return fmt.Errorf("certmanager: authorization for %s is %s", a.Identifier.Value, a.Status)
+		}
+		var ch *Challenge
+		for _, c := range a.Challenges {
+			if c.Type == typ {
+				ch = c
+				break
+			}
+		}
+		if ch == nil {
+			return fmt.Errorf("certmanager: server offers no %s challenge for %s", typ, a.Identifier.Value)
+		}
+		cleanup, err := m.prepare(client, a.Identifier.Value, ch)
+		if err != nil {
+			return err
+		}
+		err = client.Accept(ctx, ch)
+		if err == nil {
+			_, err = client.WaitAuthorization(ctx, u)
+		}
+		cleanup()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepare 开始应答挑战, 返回撤销应答的函数
+func (m *Manager) prepare(client *Client, domain string, ch *Challenge) (func(), error) {
+	keyAuth, err := keyAuthorization(client.Key, ch.Token)
+	if err != nil {
+		return nil, err
+	}
+	switch ch.Type {
+	case ChallengeHTTP01:
+		m.mu.Lock()
+		m.tokens[ch.Token] = keyAuth
+		m.mu.Unlock()
+		return func() {
+			m.mu.Lock()
+			delete(m.tokens, ch.Token)
+			m.mu.Unlock()
+		}, nil
+	case ChallengeTLSALPN01:
+		c, err := tlsALPNCert(domain, keyAuth)
+		if err != nil {
+			return nil, err
+		}
+		m.mu.Lock()
+		m.alpnCerts[domain] = c
+		m.mu.Unlock()
+		return func() {
+			m.mu.Lock()
+			delete(m.alpnCerts, domain)
+			m.mu.Unlock()
+		}, nil
+	}
+	return nil, fmt.Errorf("certmanager: unsupported challenge %s", ch.Type)
+}
+
+// account 返回已注册账户的客户端, 首次调用时读取或生成账户密钥并注册
+func (m *Manager) account(ctx context.Context) (*Client, error) {
+	m.accountMu.Lock()
+	defer m.accountMu.Unlock()
+	c := m.client
+	c.mu.Lock()
+	registered := c.kid != ""
+	c.mu.Unlock()
+	if registered {
+		return c, nil
+	}
+	if c.Key == nil {
+		key, err := m.accountKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.Key = key
+	}
+	var contact []string
+	if m.Email != "" {
+		contact = []string{"mailto:" + m.Email}
+	}
+	if err := c.Register(ctx, contact, m.AcceptTOS); err != nil {
+		return nil, fmt.Errorf("certmanager: registering account: %w", err)
+	}
+	return c, nil
+}
+
+// accountKey 从缓存读取账户密钥, 没有时生成并保存
+func (m *Manager) accountKey(ctx context.Context) (*ecdsa.PrivateKey, error) {
+	data, err := m.Cache.Get(ctx, accountKeyName)
+	switch {
+	case err == nil:
+		b, _ := pem.Decode(data)
+		if b == nil || b.Type != "EC PRIVATE KEY" {
+			return nil, errors.New("certmanager: malformed cached account key")
+		}
+		return x509.ParseECPrivateKey(b.Bytes)
+	case !errors.Is(err, ErrCacheMiss):
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Cache.Put(ctx, accountKeyName, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// scheduleRenewal 安排在到期前 RenewBefore 续期, 加上最多一小时的随机提前量以分散请求;
+// retry > 0 表示上次续期失败, 按退避间隔重试. 调用方持有 m.mu
+func (m *Manager) scheduleRenewal(name string, c *tls.Certificate, retry time.Duration) {
+	if m.closed {
+		return
+	}
+	if t := m.timers[name]; t != nil {
+		t.Stop()
+	}
+	renewBefore := m.RenewBefore
+	if renewBefore <= 0 {
+		renewBefore = DefaultRenewBefore
+	}
+	delay := retry
+	if delay == 0 {
+		delay = time.Until(c.Leaf.NotAfter.Add(-renewBefore)) - mathrand.N(time.Hour)
+		// 已进入续期窗口 (如从缓存读到旧证书) 时稍后续期, 不阻塞本次握手
+		delay = max(delay, mathrand.N(renewRetryMin))
+	}
+	m.timers[name] = time.AfterFunc(delay, func() { m.renew(name, retry) })
+}
+
+// renew 续期证书, 失败时保留旧证书并稍后重试
+func (m *Manager) renew(name string, lastRetry time.Duration) {
+	m.mu.Lock()
+	closed := m.closed
+	m.mu.Unlock()
+	if closed {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), issueTimeout)
+	defer cancel()
+	c, err := m.issue(ctx, name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil {
+		m.certs[name] = c
+		m.scheduleRenewal(name, c, 0)
+		return
+	}
+	retry := min(max(lastRetry*2, renewRetryMin), renewRetryMax)
+	m.logger().Error("certmanager: renewal failed", log.String("domain", name), log.Duration("retry_in", retry), log.Err(err))
+	m.scheduleRenewal(name, m.certs[name], retry)
+}
+
+// Close 停止所有续期, 之后不再签发新证书; 已取得的证书仍然可用
+func (m *Manager) Close() error {
+	m.init()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	for name, t := range m.timers {
+		t.Stop()
+		delete(m.timers, name)
+	}
+	return nil
+}
+
+func (m *Manager) challenges() []string {
+	if len(m.Challenges) > 0 {
+		return m.Challenges
+	}
+	return []string{ChallengeTLSALPN01, ChallengeHTTP01}
+}
+
+func (m *Manager) logger() *log.Logger { return log.Or(m.Logger) }
+
+// encodeCert 把私钥和证书链编码为 PEM, 即缓存中保存的格式
+func encodeCert(key *ecdsa.PrivateKey, chain [][]byte) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	for _, c := range chain {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: c})
+	}
+	return buf.Bytes(), nil
+}
+
+// normalizeHost 转为小写并去掉末尾的点
+func normalizeHost(h string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(h)), ".")
+}
@@ -0,0 +1,386 @@
+package certmanager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	httptest "github.com/narcilee7/http-stack/internal/testing"
+	"github.com/narcilee7/http-stack/pkg/http/message"
+)
+
+// fakeACME 最小的 ACME 服务器: 校验每个请求的 JWS 和 nonce, 用 HTTP-01 挑战向管理器取密钥授权,
+// 用测试 CA 签发证书
+type fakeACME struct {
+	t   *testing.T
+	srv *httptest.MockServer
+	ca  *httptest.CA
+	m   *Manager
+
+	mu        sync.Mutex
+	nonce     int
+	nonces    map[string]bool
+	account   *ecdsa.PublicKey
+	orders    int
+	issued    int
+	valid     map[int]bool
+	certs     map[int][]byte
+	badNonce  int
+	failOrder bool
+}
+
+func newFakeACME(t *testing.T) *fakeACME {
+	srv, err := httptest.NewMockServer(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := httptest.NewCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeACME{t: t, srv: srv, ca: ca, nonces: make(map[string]bool), valid: make(map[int]bool), certs: make(map[int][]byte)}
+	srv.HandleFunc("GET", "/dir", func(*message.Request, []byte) *httptest.MockResponse {
+		return f.json(200, map[string]string{
+			"newNonce":   srv.URL + "/nonce",
+			"newAccount": srv.URL + "/new-account",
+			"newOrder":   srv.URL + "/new-order",
+		})
+	})
+	srv.HandleFunc("HEAD", "/nonce", func(*message.Request, []byte) *httptest.MockResponse {
+		return f.withNonce(&httptest.MockResponse{})
+	})
+	srv.HandleFunc("POST", "/*", f.post)
+	return f
+}
+
+func (f *fakeACME) withNonce(r *httptest.MockResponse) *httptest.MockResponse {
+	f.mu.Lock()
+	f.nonce++
+	n := fmt.Sprintf("nonce-%d", f.nonce)
+	f.nonces[n] = true
+	f.mu.Unlock()
+	if r.Header == nil {
+		r.Header = make(map[string][]string)
+	}
+	r.Header.Set("Replay-Nonce", n)
+	return r
+}
+
+func (f *fakeACME) json(status int, v any) *httptest.MockResponse {
+	b, _ := json.Marshal(v)
+	r := f.withNonce(&httptest.MockResponse{Status: status, Body: b})
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func (f *fakeACME) problem(status int, typ, detail string) *httptest.MockResponse {
+	r := f.json(status, map[string]any{"type": "urn:ietf:params:acme:error:" + typ, "detail": detail})
+	r.Header.Set("Content-Type", "application/problem+json")
+	return r
+}
+
+// post 校验 JWS 后按路径分派
+func (f *fakeACME) post(req *message.Request, body []byte) *httptest.MockResponse {
+	var msg jwsMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return f.problem(400, "malformed", err.Error())
+	}
+	ph, _ := base64.RawURLEncoding.DecodeString(msg.Protected)
+	var header map[string]any
+	json.Unmarshal(ph, &header)
+
+	f.mu.Lock()
+	nonce, _ := header["nonce"].(string)
+	fresh := f.nonces[nonce]
+	delete(f.nonces, nonce)
+	inject := f.badNonce > 0
+	if inject {
+		f.badNonce--
+	}
+	account := f.account
+	f.mu.Unlock()
+	if !fresh || inject {
+		return f.problem(400, "badNonce", "stale nonce "+nonce)
+	}
+	if header["url"] != f.srv.URL+req.URL.Path {
+		return f.problem(401, "unauthorized", fmt.Sprintf("url %v does not match %s", header["url"], req.URL.Path))
+	}
+
+	path := req.URL.Path
+	if path == "/new-account" {
+		jwk, _ := header["jwk"].(map[string]any)
+		pub, err := publicKeyFromJWK(jwk)
+		if err != nil {
+			return f.problem(400, "badPublicKey", err.Error())
+		}
+		if _, _, err := verifyJWS(pub, body); err != nil {
+			return f.problem(401, "unauthorized", err.Error())
+		}
+		f.mu.Lock()
+		f.account = pub
+		f.mu.Unlock()
+		r := f.json(201, map[string]string{"status": "valid"})
+		r.Header.Set("Location", f.srv.URL+"/acct/1")
+		return r
+	}
+	if account == nil || header["kid"] != f.srv.URL+"/acct/1" {
+		return f.problem(400, "accountDoesNotExist", "unknown kid")
+	}
+	_, payload, err := verifyJWS(account, body)
+	if err != nil {
+		return f.problem(401, "unauthorized", err.Error())
+	}
+
+	var id int
+	switch {
+	case path == "/new-order":
+		f.mu.Lock()
+		fail := f.failOrder
+		f.orders++
+		id = f.orders
+		f.mu.Unlock()
+		if fail {
+			return f.problem(503, "rateLimited", "try later")
+		}
+		r := f.json(201, f.order(id))
+		r.Header.Set("Location", fmt.Sprintf("%s/order/%d", f.srv.URL, id))
+		return r
+	case scan(path, "/order/%d", &id):
+		return f.json(200, f.order(id))
+	case scan(path, "/authz/%d", &id):
+		f.mu.Lock()
+		status := map[bool]string{false: StatusPending, true: StatusValid}[f.valid[id]]
+		f.mu.Unlock()
+		return f.json(200, map[string]any{
+			"status":     status,
+			"identifier": Identifier{Type: "dns", Value: "example.com"},
+			"challenges": []Challenge{{Type: ChallengeHTTP01, URL: fmt.Sprintf("%s/chall/%d", f.srv.URL, id), Token: fmt.Sprintf("token-%d", id), Status: status}},
+		})
+	case scan(path, "/chall/%d", &id):
+		// 向管理器的 HTTP-01 处理器取密钥授权
+		token := fmt.Sprintf("token-%d", id)
+		rec := httptest.NewRecorder()
+		creq, _ := message.NewRequest("GET", "http://example.com"+httpChallengePrefix+token, nil)
+		f.m.HTTPHandler(nil).ServeHTTP(rec, creq)
+		tp, _ := thumbprint(account)
+		if rec.Code != 200 || rec.Body.String() != token+"."+tp {
+			return f.problem(403, "unauthorized", "key authorization mismatch: "+rec.Body.String())
+		}
+		f.mu.Lock()
+		f.valid[id] = true
+		f.mu.Unlock()
+		return f.json(200, map[string]string{"status": StatusValid})
+	case scan(path, "/finalize/%d", &id):
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			return f.problem(400, "badCSR", err.Error())
+		}
+		f.mu.Lock()
+		f.issued++
+		serial := int64(f.issued)
+		f.mu.Unlock()
+		now := time.Now()
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      csr.Subject,
+			DNSNames:     csr.DNSNames,
+			NotBefore:    now.Add(-time.Hour),
+			NotAfter:     now.Add(24 * time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		cert, err := x509.CreateCertificate(rand.Reader, tmpl, f.ca.Cert, csr.PublicKey, f.ca.Key)
+		if err != nil {
+			return f.problem(500, "serverInternal", err.Error())
+		}
+		f.mu.Lock()
+		f.certs[id] = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), f.ca.CertPEM...)
+		f.mu.Unlock()
+		return f.json(200, f.order(id))
+	case scan(path, "/cert/%d", &id):
+		f.mu.Lock()
+		chain := f.certs[id]
+		f.mu.Unlock()
+		r := f.withNonce(&httptest.MockResponse{Body: chain})
+		r.Header.Set("Content-Type", "application/pem-certificate-chain")
+		return r
+	}
+	return f.problem(404, "malformed", "unknown resource "+path)
+}
+
+// counts 返回已创建的订单数和已签发的证书数
+func (f *fakeACME) counts() (orders, issued int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.orders, f.issued
+}
+
+func (f *fakeACME) order(id int) map[string]any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	o := map[string]any{
+		"status":         StatusPending,
+		"identifiers":    []Identifier{{Type: "dns", Value: "example.com"}},
+		"authorizations": []string{fmt.Sprintf("%s/authz/%d", f.srv.URL, id)},
+		"finalize":       fmt.Sprintf("%s/finalize/%d", f.srv.URL, id),
+	}
+	switch {
+	case f.certs[id] != nil:
+		o["status"] = StatusValid
+		o["certificate"] = fmt.Sprintf("%s/cert/%d", f.srv.URL, id)
+	case f.valid[id]:
+		o["status"] = StatusReady
+	}
+	return o
+}
+
+func scan(path, format string, id *int) bool {
+	n, err := fmt.Sscanf(path, format, id)
+	return n == 1 && err == nil && fmt.Sprintf(format, *id) == path
+}
+
+func newTestManager(t *testing.T) (*Manager, *fakeACME) {
+	f := newFakeACME(t)
+	m := &Manager{
+		Client:      &Client{DirectoryURL: f.srv.URL + "/dir"},
+		HostPolicy:  HostWhitelist("example.com"),
+		AcceptTOS:   true,
+		RenewBefore: time.Minute,
+		Challenges:  []string{ChallengeHTTP01},
+	}
+	f.m = m
+	t.Cleanup(func() { m.Close() })
+	return m, f
+}
+
+func serial(t *testing.T, c *tls.Certificate) int64 {
+	t.Helper()
+	if c == nil || c.Leaf == nil {
+		t.Fatal("certificate without parsed leaf")
+	}
+	return c.Leaf.SerialNumber.Int64()
+}
+
+func TestManagerIssuesCertificate(t *testing.T) {
+	m, f := newTestManager(t)
+	f.badNonce = 1 // 第一次签名请求被拒绝后应换 nonce 重试
+
+	c, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "Example.COM."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Leaf.VerifyHostname("example.com"); err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(f.ca.Cert)
+	if _, err := c.Leaf.Verify(x509.VerifyOptions{Roots: pool}); err != nil {
+		t.Fatalf("issued leaf does not chain to the CA: %v", err)
+	}
+
+	// 再次握手使用内存中的证书
+	again, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if err != nil || again != c {
+		t.Fatalf("second GetCertificate = %v, %v; want the same certificate", again, err)
+	}
+	if _, issued := f.counts(); issued != 1 {
+		t.Fatalf("issued %d certificates, want 1", issued)
+	}
+	m.mu.Lock()
+	timer := m.timers["example.com"]
+	tokens := len(m.tokens)
+	m.mu.Unlock()
+	if timer == nil {
+		t.Fatal("no renewal scheduled after issuance")
+	}
+	if tokens != 0 {
+		t.Fatalf("%d HTTP-01 tokens left after issuance", tokens)
+	}
+
+	// 缓存中的证书可被新的管理器直接使用
+	m2 := &Manager{Client: &Client{DirectoryURL: f.srv.URL + "/dir"}, Cache: m.Cache, RenewBefore: time.Minute}
+	defer m2.Close()
+	cached, err := m2.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, issued := f.counts(); serial(t, cached) != serial(t, c) || issued != 1 {
+		t.Fatalf("cached certificate serial %d, issued %d; want serial %d from the cache", serial(t, cached), issued, serial(t, c))
+	}
+}
+
+func TestManagerHostPolicy(t *testing.T) {
+	m, f := newTestManager(t)
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "evil.example"}); !errors.Is(err, ErrHostNotAllowed) {
+		t.Fatalf("GetCertificate for a disallowed host = %v, want ErrHostNotAllowed", err)
+	}
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{}); !errors.Is(err, ErrMissingServerName) {
+		t.Fatalf("GetCertificate without SNI = %v, want ErrMissingServerName", err)
+	}
+	if n := len(f.srv.Requests()); n != 0 {
+		t.Fatalf("ACME server received %d requests for rejected hosts", n)
+	}
+}
+
+func TestManagerRenewal(t *testing.T) {
+	m, f := newTestManager(t)
+	first, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.renew("example.com", 0)
+	renewed, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial(t, renewed) == serial(t, first) {
+		t.Fatal("renewal did not replace the certificate")
+	}
+	data, _ := m.Cache.Get(context.Background(), "example.com")
+	if !strings.Contains(string(data), "CERTIFICATE") {
+		t.Fatal("renewed certificate not written to the cache")
+	}
+
+	// 续期失败时保留旧证书并按退避重试
+	f.mu.Lock()
+	f.failOrder = true
+	f.mu.Unlock()
+	m.mu.Lock()
+	before := m.timers["example.com"]
+	m.mu.Unlock()
+	m.renew("example.com", 0)
+	m.mu.Lock()
+	after := m.timers["example.com"]
+	m.mu.Unlock()
+	current, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if err != nil || serial(t, current) != serial(t, renewed) {
+		t.Fatalf("certificate after failed renewal: %v, %v", current, err)
+	}
+	if after == nil || after == before {
+		t.Fatal("no retry scheduled after failed renewal")
+	}
+
+	// 关闭后不再续期
+	m.Close()
+	orders, _ := f.counts()
+	m.renew("example.com", 0)
+	if n, _ := f.counts(); n != orders {
+		t.Fatal("renewal ran after Close")
+	}
+}
@@ -0,0 +1,106 @@
+package trace
+
+/*
+	W3C Trace Context 传播: 在 traceparent 和 tracestate 头部中读写 SpanContext
+*/
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+
+	"github.com/narcilee7/http-stack/pkg/http/protocol/common"
+)
+
+const (
+	headerTraceparent = "Traceparent"
+	headerTracestate  = "Tracestate"
+
+	// traceparentLen 版本 00 的 traceparent 长度: 2+1+32+1+16+1+2
+	traceparentLen = 55
+	// maxTracestateLen tracestate 的长度上限, 超出时丢弃而不是截断
+	maxTracestateLen = 512
+)
+
+// ParseTraceparent 解析 traceparent 头部值
+func ParseTraceparent(v string) (SpanContext, bool) {
+	var sc SpanContext
+	v = strings.TrimSpace(v)
+	if len(v) < traceparentLen || v[2] != '-' || v[35] != '-' || v[52] != '-' {
+		return sc, false
+	}
+	var version [1]byte
+	if _, err := hex.Decode(version[:], []byte(v[:2])); err != nil || version[0] == 0xff {
+		return sc, false
+	}
+	// 版本 00 必须正好 55 字节, 更高版本允许在后面追加以 '-' 分隔的字段
+	if version[0] == 0 && len(v) != traceparentLen || version[0] != 0 && len(v) > traceparentLen && v[traceparentLen] != '-' {
+		return sc, false
+	}
+	if !decodeLowerHex(sc.TraceID[:], v[3:35]) || !decodeLowerHex(sc.SpanID[:], v[36:52]) {
+		return sc, false
+	}
+	var flags [1]byte
+	if !decodeLowerHex(flags[:], v[53:55]) {
+		return sc, false
+	}
+	sc.Flags = flags[0]
+	return sc, sc.IsValid()
+}
+
+// FormatTraceparent 以版本 00 格式化 traceparent 头部值
+func FormatTraceparent(sc SpanContext) string {
+	var b [traceparentLen]byte
+	copy(b[:], "00-")
+	hex.Encode(b[3:35], sc.TraceID[:])
+	b[35] = '-'
+	hex.Encode(b[36:52], sc.SpanID[:])
+	b[52] = '-'
+	hex.Encode(b[53:55], []byte{sc.Flags})
+	return string(b[:])
+}
+
+// Extract 从请求头部提取上游的 SpanContext
+func Extract(h common.Header) (SpanContext, bool) {
+	values := h.Values(headerTraceparent)
+	if len(values) != 1 {
+		// 多个 traceparent 无法判断哪个有效
+		return SpanContext{}, false
+	}
+	sc, ok := ParseTraceparent(values[0])
+	if !ok {
+		return sc, false
+	}
+	if ts := strings.Join(h.Values(headerTracestate), ","); len(ts) <= maxTracestateLen {
+		sc.TraceState = ts
+	}
+	sc.Remote = true
+	return sc, true
+}
+
+// Inject 将 ctx 中 span 的标识写入请求头部, 没有有效 span 时删除已有的传播头部
+func Inject(ctx context.Context, h common.Header) {
+	sc := ParentFromContext(ctx)
+	if !sc.IsValid() {
+		h.Del(headerTraceparent)
+		h.Del(headerTracestate)
+		return
+	}
+	h.Set(headerTraceparent, FormatTraceparent(sc))
+	if sc.TraceState != "" {
+		h.Set(headerTracestate, sc.TraceState)
+	} else {
+		h.Del(headerTracestate)
+	}
+}
+
+// decodeLowerHex 解码小写十六进制, 大写字母视为非法 (W3C Trace Context 3.2.2)
+func decodeLowerHex(dst []byte, s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
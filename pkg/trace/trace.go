@@ -0,0 +1,193 @@
+package trace
+
+/*
+	分布式追踪的基本模型, 与 OpenTelemetry 的 trace API 对应: TraceID、SpanID、SpanContext、Span 和 Tracer.
+	接入 OpenTelemetry SDK 时实现 Tracer 和 Span 做转换即可, 不需要时使用本包自带的 SimpleTracer
+*/
+
+import (
+	"context"
+	"encoding/hex"
+	"time"
+)
+
+// TraceID 16 字节的追踪标识, 全零无效
+type TraceID [16]byte
+
+// IsValid 报告是否非零
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// SpanID 8 字节的 span 标识, 全零无效
+type SpanID [8]byte
+
+// IsValid 报告是否非零
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// FlagsSampled traceparent 中表示已采样的标志位
+const FlagsSampled byte = 0x01
+
+// SpanContext 跨进程传播的 span 标识
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Flags trace-flags, 目前只定义了 FlagsSampled
+	Flags byte
+	// TraceState tracestate 头部的原始值, 原样传播
+	TraceState string
+	// Remote 为 true 表示从上游请求中提取而来
+	Remote bool
+}
+
+// IsValid 报告 TraceID 和 SpanID 是否都有效
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+// IsSampled 报告是否已采样
+func (sc SpanContext) IsSampled() bool { return sc.Flags&FlagsSampled != 0 }
+
+// SpanKind span 在调用关系中的角色
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = iota
+	SpanKindServer
+	SpanKindClient
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	}
+	return "internal"
+}
+
+// StatusCode span 的结束状态
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = iota
+	StatusOK
+	StatusError
+)
+
+// Attribute span 属性, Value 为 string、bool、int64 或 float64
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// String 创建字符串属性
+func String(key, value string) Attribute { return Attribute{Key: key, Value: value} }
+
+// Int64 创建整数属性
+func Int64(key string, value int64) Attribute { return Attribute{Key: key, Value: value} }
+
+// Int 创建整数属性
+func Int(key string, value int) Attribute { return Attribute{Key: key, Value: int64(value)} }
+
+// Bool 创建布尔属性
+func Bool(key string, value bool) Attribute { return Attribute{Key: key, Value: value} }
+
+// Span 一次操作的追踪记录
+type Span interface {
+	// SpanContext 返回用于传播的标识
+	SpanContext() SpanContext
+	// SetAttributes 添加或覆盖属性
+	SetAttributes(attrs ...Attribute)
+	// SetStatus 设置结束状态, description 只在 StatusError 时有意义
+	SetStatus(code StatusCode, description string)
+	// RecordError 记录一个错误事件
+	RecordError(err error)
+	// End 结束 span, 之后的调用无效
+	End()
+}
+
+// StartOptions 创建 span 的参数
+type StartOptions struct {
+	Kind SpanKind
+	// Attributes 初始属性, 可供采样决策使用
+	Attributes []Attribute
+	// StartTime 开始时间, 零值表示当前时间
+	StartTime time.Time
+}
+
+// Tracer 创建 span; ctx 中的 span (或远端 SpanContext) 作为父 span
+type Tracer interface {
+	Start(ctx context.Context, name string, opts StartOptions) (context.Context, Span)
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// ContextWithSpan 返回携带 span 的上下文
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext 返回 ctx 中的 span, 没有时返回不记录的空 span
+func SpanFromContext(ctx context.Context) Span {
+	if span, ok := ctx.Value(spanKey{}).(Span); ok {
+		return span
+	}
+	return noopSpan{sc: remoteFromContext(ctx)}
+}
+
+// ContextWithRemoteSpanContext 返回携带从上游提取的 SpanContext 的上下文, 下一个创建的 span 以它为父
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	sc.Remote = true
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// ParentFromContext 返回新 span 的父标识: 优先取 ctx 中的 span, 其次取远端 SpanContext
+func ParentFromContext(ctx context.Context) SpanContext {
+	if span, ok := ctx.Value(spanKey{}).(Span); ok {
+		return span.SpanContext()
+	}
+	return remoteFromContext(ctx)
+}
+
+func remoteFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+// NoopTracer 不记录任何内容的 Tracer, 只传递父 SpanContext
+type NoopTracer struct{}
+
+// Start 实现 Tracer
+func (NoopTracer) Start(ctx context.Context, _ string, _ StartOptions) (context.Context, Span) {
+	span := noopSpan{sc: ParentFromContext(ctx)}
+	return ContextWithSpan(ctx, span), span
+}
+
+type noopSpan struct{ sc SpanContext }
+
+func (s noopSpan) SpanContext() SpanContext   { return s.sc }
+func (noopSpan) SetAttributes(...Attribute)   {}
+func (noopSpan) SetStatus(StatusCode, string) {}
+func (noopSpan) RecordError(error)            {}
+func (noopSpan) End()                         {}
+
+// HTTP 语义约定中的属性名 (OpenTelemetry semantic conventions)
+const (
+	AttrHTTPRequestMethod      = "http.request.method"
+	AttrHTTPResponseStatusCode = "http.response.status_code"
+	AttrHTTPRequestBodySize    = "http.request.body.size"
+	AttrHTTPResponseBodySize   = "http.response.body.size"
+	AttrURLFull                = "url.full"
+	AttrURLPath                = "url.path"
+	AttrURLQuery               = "url.query"
+	AttrServerAddress          = "server.address"
+	AttrServerPort             = "server.port"
+	AttrClientAddress          = "client.address"
+	AttrNetworkProtocolVersion = "network.protocol.version"
+	AttrUserAgent              = "user_agent.original"
+	AttrErrorType              = "error.type"
+)
@@ -0,0 +1,186 @@
+package trace
+
+/*
+	自带的简单 Tracer: 生成 ID、按父 span 或比例采样, span 结束时交给 Exporter
+*/
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/narcilee7/http-stack/pkg/utils"
+)
+
+// SpanData 结束后的 span 快照
+type SpanData struct {
+	Name        string
+	Kind        SpanKind
+	SpanContext SpanContext
+	// Parent 父 span 的标识, 根 span 时无效
+	Parent     SpanContext
+	StartTime  time.Time
+	EndTime    time.Time
+	Attributes []Attribute
+	Status     StatusCode
+	// StatusDescription 错误状态的说明
+	StatusDescription string
+	// Errors RecordError 记录的错误
+	Errors []error
+}
+
+// Exporter 接收已采样且结束的 span, 可能被并发调用
+type Exporter interface {
+	ExportSpan(s *SpanData)
+}
+
+// ExporterFunc 将函数适配为 Exporter
+type ExporterFunc func(s *SpanData)
+
+// ExportSpan 调用 f(s)
+func (f ExporterFunc) ExportSpan(s *SpanData) { f(s) }
+
+// Sampler 为没有父 span 的新追踪决定是否采样
+type Sampler func(traceID TraceID, name string) bool
+
+// RatioSampler 按 TraceID 采样约 ratio 比例的追踪, 同一 TraceID 的决定在各进程间一致
+func RatioSampler(ratio float64) Sampler {
+	switch {
+	case ratio >= 1:
+		return func(TraceID, string) bool { return true }
+	case ratio <= 0:
+		return func(TraceID, string) bool { return false }
+	}
+	bound := uint64(ratio * (1 << 63))
+	return func(id TraceID, _ string) bool {
+		return binary.BigEndian.Uint64(id[8:])>>1 < bound
+	}
+}
+
+// SimpleTracer 本包自带的 Tracer 实现
+type SimpleTracer struct {
+	// Exporter 接收结束的 span, 为空时 span 只用于传播
+	Exporter Exporter
+	// Sampler 根 span 的采样决定, 为空时全部采样; 有父 span 时沿用父 span 的决定
+	Sampler Sampler
+	// Clock 记录起止时间的时钟, 为空时使用系统时钟
+	Clock utils.Clock
+}
+
+// Start 实现 Tracer
+func (t *SimpleTracer) Start(ctx context.Context, name string, opts StartOptions) (context.Context, Span) {
+	parent := ParentFromContext(ctx)
+	sc := SpanContext{SpanID: newSpanID()}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Flags = parent.Flags
+		sc.TraceState = parent.TraceState
+	} else {
+		sc.TraceID = newTraceID()
+		if t.Sampler == nil || t.Sampler(sc.TraceID, name) {
+			sc.Flags |= FlagsSampled
+		}
+	}
+	start := opts.StartTime
+	if start.IsZero() {
+		start = utils.ClockOr(t.Clock).Now()
+	}
+	s := &simpleSpan{tracer: t, data: SpanData{
+		Name:        name,
+		Kind:        opts.Kind,
+		SpanContext: sc,
+		Parent:      parent,
+		StartTime:   start,
+		Attributes:  append([]Attribute(nil), opts.Attributes...),
+	}}
+	return ContextWithSpan(ctx, s), s
+}
+
+type simpleSpan struct {
+	tracer *SimpleTracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+func (s *simpleSpan) SpanContext() SpanContext { return s.data.SpanContext }
+
+func (s *simpleSpan) SetAttributes(attrs ...Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	for _, a := range attrs {
+		replaced := false
+		for i := range s.data.Attributes {
+			if s.data.Attributes[i].Key == a.Key {
+				s.data.Attributes[i] = a
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			s.data.Attributes = append(s.data.Attributes, a)
+		}
+	}
+}
+
+func (s *simpleSpan) SetStatus(code StatusCode, description string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 与 OpenTelemetry 一致: OK 不能被覆盖, Unset 不覆盖已有状态
+	if s.ended || s.data.Status == StatusOK || code == StatusUnset {
+		return
+	}
+	s.data.Status = code
+	if code == StatusError {
+		s.data.StatusDescription = description
+	}
+}
+
+func (s *simpleSpan) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Errors = append(s.data.Errors, err)
+	}
+}
+
+func (s *simpleSpan) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTime = utils.ClockOr(s.tracer.Clock).Now()
+	data := s.data
+	s.mu.Unlock()
+	if s.tracer.Exporter != nil && data.SpanContext.IsSampled() {
+		s.tracer.Exporter.ExportSpan(&data)
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
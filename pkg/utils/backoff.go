@@ -0,0 +1,168 @@
+package utils
+
+/*
+	退避策略: 指数、固定间隔、去相关抖动, 供客户端重试、自动重连和熔断探测共用
+*/
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// BackoffStrategy 退避算法
+type BackoffStrategy int
+
+const (
+	// BackoffExponential 每次乘以 Factor, 按 Jitter 比例随机缩短
+	BackoffExponential BackoffStrategy = iota
+	// BackoffConstant 固定为 Min, 按 Jitter 比例随机缩短
+	BackoffConstant
+	// BackoffDecorrelated 去相关抖动: 在 [Min, 上次等待*3] 内随机取值, 不超过 Max
+	BackoffDecorrelated
+)
+
+// 默认退避参数
+const (
+	DefaultBackoffMin    = 100 * time.Millisecond
+	DefaultBackoffMax    = 30 * time.Second
+	DefaultBackoffFactor = 2
+)
+
+// Backoff 退避配置, 零值表示 100ms 起、翻倍、上限 30s 且无抖动的指数退避; 可并发读取
+type Backoff struct {
+	Strategy BackoffStrategy
+	// Min 首次等待时间
+	Min time.Duration
+	// Max 等待时间上限
+	Max time.Duration
+	// Factor 指数退避的倍数, <= 1 时取 2
+	Factor float64
+	// Jitter [0, 1], 等待时间在 [d*(1-Jitter), d] 内均匀随机
+	Jitter float64
+	// MaxAttempts 最大尝试次数 (含首次), 0 表示不限制
+	MaxAttempts int
+	// Clock 等待使用的时钟, 为空时使用系统时钟
+	Clock Clock
+}
+
+func (b *Backoff) min() time.Duration {
+	if b.Min > 0 {
+		return b.Min
+	}
+	return DefaultBackoffMin
+}
+
+func (b *Backoff) max() time.Duration {
+	if b.Max > 0 {
+		return max(b.Max, b.min())
+	}
+	return max(DefaultBackoffMax, b.min())
+}
+
+// Duration 返回第 retry 次重试 (从 1 开始) 前的等待时间; 去相关抖动需要上次结果, 请使用 Iter
+func (b *Backoff) Duration(retry int) time.Duration {
+	return b.next(retry, 0)
+}
+
+// next prev 为上一次等待时间, 仅去相关抖动使用
+func (b *Backoff) next(retry int, prev time.Duration) time.Duration {
+	lo, hi := b.min(), b.max()
+	var d time.Duration
+	switch b.Strategy {
+	case BackoffConstant:
+		d = lo
+	case BackoffDecorrelated:
+		upper := min(max(prev, lo)*3, hi)
+		return lo + rand.N(upper-lo+1)
+	default:
+		factor := b.Factor
+		if factor <= 1 {
+			factor = DefaultBackoffFactor
+		}
+		f := float64(lo)
+		for i := 1; i < retry && f < float64(hi); i++ {
+			f *= factor
+		}
+		d = time.Duration(min(f, float64(hi)))
+	}
+	if j := min(b.Jitter, 1); j > 0 {
+		d -= time.Duration(j * float64(d) * rand.Float64())
+	}
+	return d
+}
+
+// Sleep 等待第 retry 次重试的退避时间, ctx 结束时提前返回 ctx.Err()
+func (b *Backoff) Sleep(ctx context.Context, retry int) error {
+	return b.sleep(ctx, b.Duration(retry))
+}
+
+// Iter 返回一个新的尝试迭代器
+func (b *Backoff) Iter() *BackoffIter {
+	return &BackoffIter{b: b}
+}
+
+// BackoffIter 按退避策略迭代尝试, 非并发安全:
+//
+//	for it := b.Iter(); it.Next(ctx); {
+//		if err = try(); err == nil {
+//			break
+//		}
+//	}
+type BackoffIter struct {
+	b       *Backoff
+	attempt int
+	prev    time.Duration
+	floor   time.Duration
+	err     error
+}
+
+// Next 开始下一次尝试, 除首次外先等待退避时间; 次数用尽或 ctx 结束时返回 false
+func (it *BackoffIter) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	if it.b.MaxAttempts > 0 && it.attempt >= it.b.MaxAttempts {
+		return false
+	}
+	if it.attempt > 0 {
+		d := max(it.b.next(it.attempt, it.prev), it.floor)
+		it.prev, it.floor = d, 0
+		if err := it.b.sleep(ctx, d); err != nil {
+			it.err = err
+			return false
+		}
+	} else if err := ctx.Err(); err != nil {
+		it.err = err
+		return false
+	}
+	it.attempt++
+	return true
+}
+
+// Delay 要求下一次等待至少为 d, 如服务端给出的 Retry-After
+func (it *BackoffIter) Delay(d time.Duration) {
+	it.floor = d
+}
+
+// Attempt 返回当前是第几次尝试, 从 1 开始
+func (it *BackoffIter) Attempt() int { return it.attempt }
+
+// Err 返回导致迭代结束的 ctx 错误, 次数用尽时为 nil
+func (it *BackoffIter) Err() error { return it.err }
+
+// Reset 重新从首次尝试开始, 如连接恢复后
+func (it *BackoffIter) Reset() {
+	it.attempt, it.prev, it.floor, it.err = 0, 0, 0, nil
+}
+
+func (b *Backoff) sleep(ctx context.Context, d time.Duration) error {
+	sleepClock(ctx.Done(), ClockOr(b.Clock), d)
+	return ctx.Err()
+}
+
+// SleepContext 等待 d 或直到 ctx 结束, 返回 ctx.Err()
+func SleepContext(ctx context.Context, d time.Duration) error {
+	sleepClock(ctx.Done(), SystemClock, d)
+	return ctx.Err()
+}
@@ -0,0 +1,239 @@
+package utils
+
+/*
+	加权公平带宽调度: 将全局字节预算按权重分给注册的流, 每个流可设置最低保证速率,
+	空闲流的份额由活跃流瓜分. 供服务端按客户端限速和分段下载器共享带宽
+*/
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultBandwidthInterval 默认的分配周期, 周期越短越平滑, 调度开销越大
+const DefaultBandwidthInterval = 10 * time.Millisecond
+
+// maxBandwidthSlice 单个周期最多分配的时长, 避免调度 goroutine 被延迟后一次发放过多
+const maxBandwidthSlice = 100 * time.Millisecond
+
+// BandwidthScheduler 加权公平的带宽调度器, 并发安全.
+// 仅在有流等待时运行后台分配 goroutine, 空闲时不占用资源
+type BandwidthScheduler struct {
+	mu       sync.Mutex
+	rate     float64
+	interval time.Duration
+	clock    Clock
+	streams  map[*BandwidthStream]struct{}
+	running  bool
+}
+
+// NewBandwidthScheduler 创建总速率为 rate 字节/秒的调度器, rate <= 0 表示不限制
+func NewBandwidthScheduler(rate float64) *BandwidthScheduler {
+	return &BandwidthScheduler{rate: rate, interval: DefaultBandwidthInterval, streams: make(map[*BandwidthStream]struct{})}
+}
+
+// SetClock 替换时间来源, 须在使用前调用; nil 表示系统时钟
+func (s *BandwidthScheduler) SetClock(c Clock) { s.clock = c }
+
+// SetInterval 设置分配周期, 须在使用前调用
+func (s *BandwidthScheduler) SetInterval(d time.Duration) {
+	if d > 0 {
+		s.interval = d
+	}
+}
+
+// SetRate 修改总速率, 从下一个周期开始生效
+func (s *BandwidthScheduler) SetRate(rate float64) {
+	s.mu.Lock()
+	s.rate = rate
+	if rate <= 0 {
+		// 不再限速, 释放所有等待者
+		for st := range s.streams {
+			st.credit = float64(st.demand)
+			st.notify()
+		}
+	}
+	s.mu.Unlock()
+}
+
+// Rate 返回总速率
+func (s *BandwidthScheduler) Rate() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rate
+}
+
+// Register 注册一个权重为 weight (<= 0 时取 1)、最低保证 minRate 字节/秒的流.
+// 所有流的最低保证之和超过总速率时按比例缩减
+func (s *BandwidthScheduler) Register(weight, minRate float64) *BandwidthStream {
+	if weight <= 0 {
+		weight = 1
+	}
+	st := &BandwidthStream{s: s, weight: weight, minRate: max(minRate, 0), ready: make(chan struct{})}
+	s.mu.Lock()
+	s.streams[st] = struct{}{}
+	s.mu.Unlock()
+	return st
+}
+
+// BandwidthStream 调度器中的一个流, 如一个客户端连接或一个下载分段
+type BandwidthStream struct {
+	s       *BandwidthScheduler
+	weight  float64
+	minRate float64
+	// 以下字段由 s.mu 保护
+	demand int64
+	credit float64
+	ready  chan struct{}
+	closed bool
+}
+
+// SetWeight 修改权重
+func (st *BandwidthStream) SetWeight(weight float64) {
+	if weight <= 0 {
+		weight = 1
+	}
+	st.s.mu.Lock()
+	st.weight = weight
+	st.s.mu.Unlock()
+}
+
+// Wait 等待获得 n 字节的额度, ctx 结束时返回 ctx.Err(); 流已关闭时直接返回
+func (st *BandwidthStream) Wait(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	s := st.s
+	s.mu.Lock()
+	if s.rate <= 0 || st.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	st.demand += int64(n)
+	if !s.running {
+		s.running = true
+		go s.run()
+	}
+	for st.credit < float64(n) && s.rate > 0 && !st.closed {
+		ready := st.ready
+		s.mu.Unlock()
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			s.mu.Lock()
+			st.demand -= int64(n)
+			st.credit = min(st.credit, float64(st.demand))
+			s.mu.Unlock()
+			return ctx.Err()
+		}
+		s.mu.Lock()
+	}
+	st.demand -= int64(n)
+	st.credit = max(st.credit-float64(n), 0)
+	s.mu.Unlock()
+	return nil
+}
+
+// Close 注销流并释放其等待者
+func (st *BandwidthStream) Close() {
+	s := st.s
+	s.mu.Lock()
+	if !st.closed {
+		st.closed = true
+		delete(s.streams, st)
+		st.notify()
+	}
+	s.mu.Unlock()
+}
+
+// notify 持有 s.mu 时调用, 唤醒所有等待者
+func (st *BandwidthStream) notify() {
+	close(st.ready)
+	st.ready = make(chan struct{})
+}
+
+// need 持有 s.mu 时调用, 返回尚未满足的需求
+func (st *BandwidthStream) need() float64 {
+	return float64(st.demand) - st.credit
+}
+
+// run 每个周期按权重分配额度, 没有需求时退出
+func (s *BandwidthScheduler) run() {
+	clock := ClockOr(s.clock)
+	ticker := clock.NewTicker(s.interval)
+	defer ticker.Stop()
+	last := clock.Now()
+	for {
+		now := <-ticker.C()
+		dt := min(now.Sub(last), maxBandwidthSlice)
+		last = now
+		s.mu.Lock()
+		if !s.allocate(dt) {
+			s.running = false
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+	}
+}
+
+// allocate 持有 s.mu 时调用, 分配 dt 时长的预算, 没有活跃的流时返回 false
+func (s *BandwidthScheduler) allocate(dt time.Duration) bool {
+	var active []*BandwidthStream
+	var minSum float64
+	for st := range s.streams {
+		if st.need() > 0 {
+			active = append(active, st)
+			minSum += st.minRate
+		}
+	}
+	if len(active) == 0 {
+		return false
+	}
+	if s.rate <= 0 {
+		return true
+	}
+	budget := s.rate * dt.Seconds()
+	granted := make(map[*BandwidthStream]float64, len(active))
+
+	// 先满足最低保证, 超额订阅时按比例缩减
+	if minSum > 0 {
+		scale := min(1, s.rate/minSum)
+		for _, st := range active {
+			g := min(st.minRate*scale*dt.Seconds(), st.need())
+			granted[st] += g
+			budget -= g
+		}
+	}
+	// 剩余预算按权重注水分配, 需求已满足的流让出份额
+	for budget > 1e-9 && len(active) > 0 {
+		var weights float64
+		for _, st := range active {
+			weights += st.weight
+		}
+		spent := 0.0
+		next := active[:0]
+		for _, st := range active {
+			want := st.need() - granted[st]
+			g := min(budget*st.weight/weights, want)
+			granted[st] += g
+			spent += g
+			if want-g > 1e-9 {
+				next = append(next, st)
+			}
+		}
+		budget -= spent
+		active = next
+		if spent <= 1e-9 {
+			break
+		}
+	}
+	for st, g := range granted {
+		if g > 0 {
+			st.credit += g
+			st.notify()
+		}
+	}
+	return true
+}
@@ -1 +1,256 @@
 package utils
+
+/*
+	缓冲区池: bytes.Buffer 池 (含带统计的分片版本)、按容量分级的 []byte 池和 bufio.Reader/Writer 池
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math/bits"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultMaxPooledBuffer 默认可回收的 bytes.Buffer 最大容量, 超过的直接丢弃, 避免池中长期持有大块内存
+const DefaultMaxPooledBuffer = 64 << 10
+
+// BufferPool bytes.Buffer 池
+type BufferPool struct {
+	maxSize int
+	pool    sync.Pool
+}
+
+// NewBufferPool 创建容量超过 maxSize 时不回收的 Buffer 池, maxSize <= 0 时使用 DefaultMaxPooledBuffer
+func NewBufferPool(maxSize int) *BufferPool {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxPooledBuffer
+	}
+	return &BufferPool{maxSize: maxSize}
+}
+
+// Get 返回一个已清空的 Buffer
+func (p *BufferPool) Get() *bytes.Buffer {
+	if v := p.pool.Get(); v != nil {
+		return v.(*bytes.Buffer)
+	}
+	return new(bytes.Buffer)
+}
+
+// Put 归还 Buffer, 调用后不得再使用 b
+func (p *BufferPool) Put(b *bytes.Buffer) {
+	if b == nil || b.Cap() > p.maxSize {
+		return
+	}
+	b.Reset()
+	p.pool.Put(b)
+}
+
+// 默认 []byte 池的容量范围
+const (
+	DefaultBytePoolMin = 512
+	DefaultBytePoolMax = 1 << 20
+)
+
+// BytePool 按 2 的幂分级的 []byte 池, 用于读缓冲、拷贝缓冲等需要原始切片的场景
+type BytePool struct {
+	minShift int
+	maxShift int
+	classes  []sync.Pool
+}
+
+// NewBytePool 创建容量分级在 [minSize, maxSize] 之间的池, 两端向上取整到 2 的幂
+func NewBytePool(minSize, maxSize int) *BytePool {
+	minSize = max(minSize, 1)
+	maxSize = max(maxSize, minSize)
+	p := &BytePool{minShift: ceilShift(minSize), maxShift: ceilShift(maxSize)}
+	p.classes = make([]sync.Pool, p.maxShift-p.minShift+1)
+	return p
+}
+
+// ceilShift 返回不小于 n 的最小 2 的幂的指数
+func ceilShift(n int) int {
+	if n <= 1 {
+		return 0
+	}
+	return bits.Len(uint(n - 1))
+}
+
+// Get 返回长度为 size 的切片, 容量为所属分级大小; 超出最大分级时直接分配且不会被回收
+func (p *BytePool) Get(size int) []byte {
+	shift := max(ceilShift(size), p.minShift)
+	if shift > p.maxShift {
+		return make([]byte, size)
+	}
+	if v := p.classes[shift-p.minShift].Get(); v != nil {
+		return (*v.(*[]byte))[:size]
+	}
+	return make([]byte, size, 1<<shift)
+}
+
+// Put 归还切片, 容量不是分级大小的切片 (如 Get 超限分配的) 被丢弃; 调用后不得再使用 b
+func (p *BytePool) Put(b []byte) {
+	c := cap(b)
+	if c == 0 || c&(c-1) != 0 {
+		return
+	}
+	shift := bits.TrailingZeros(uint(c))
+	if shift < p.minShift || shift > p.maxShift {
+		return
+	}
+	b = b[:0]
+	p.classes[shift-p.minShift].Put(&b)
+}
+
+var (
+	defaultBufferPool = NewBufferPool(0)
+	defaultBytePool   = NewBytePool(DefaultBytePoolMin, DefaultBytePoolMax)
+)
+
+// GetBuffer 从默认池取一个 Buffer
+func GetBuffer() *bytes.Buffer { return defaultBufferPool.Get() }
+
+// PutBuffer 归还 Buffer 到默认池
+func PutBuffer(b *bytes.Buffer) { defaultBufferPool.Put(b) }
+
+// GetBytes 从默认池取长度为 size 的切片
+func GetBytes(size int) []byte { return defaultBytePool.Get(size) }
+
+// PutBytes 归还切片到默认池
+func PutBytes(b []byte) { defaultBytePool.Put(b) }
+
+var (
+	readerPools sync.Map // size -> *sync.Pool
+	writerPools sync.Map
+)
+
+func bufioPool(m *sync.Map, size int) *sync.Pool {
+	if p, ok := m.Load(size); ok {
+		return p.(*sync.Pool)
+	}
+	p, _ := m.LoadOrStore(size, &sync.Pool{})
+	return p.(*sync.Pool)
+}
+
+// GetReader 从池中取缓冲区大小为 size 的 bufio.Reader 并绑定到 r
+func GetReader(r io.Reader, size int) *bufio.Reader {
+	if v := bufioPool(&readerPools, size).Get(); v != nil {
+		br := v.(*bufio.Reader)
+		br.Reset(r)
+		return br
+	}
+	return bufio.NewReaderSize(r, size)
+}
+
+// PutReader 归还 bufio.Reader, 按其缓冲区大小放回对应的池; 调用后不得再使用 br
+func PutReader(br *bufio.Reader) {
+	if br == nil {
+		return
+	}
+	br.Reset(nil)
+	bufioPool(&readerPools, br.Size()).Put(br)
+}
+
+// GetWriter 从池中取缓冲区大小为 size 的 bufio.Writer 并绑定到 w
+func GetWriter(w io.Writer, size int) *bufio.Writer {
+	if v := bufioPool(&writerPools, size).Get(); v != nil {
+		bw := v.(*bufio.Writer)
+		bw.Reset(w)
+		return bw
+	}
+	return bufio.NewWriterSize(w, size)
+}
+
+// PutWriter 归还 bufio.Writer, 未刷出的数据被丢弃; 调用后不得再使用 bw
+func PutWriter(bw *bufio.Writer) {
+	if bw == nil {
+		return
+	}
+	bw.Reset(nil)
+	bufioPool(&writerPools, bw.Size()).Put(bw)
+}
+
+// PoolStats 池的命中统计
+type PoolStats struct {
+	// Hits Get 复用了池中对象的次数
+	Hits uint64
+	// Misses Get 新分配的次数
+	Misses uint64
+	// Puts 成功归还的次数
+	Puts uint64
+	// Drops 因超过最大容量而被丢弃的次数
+	Drops uint64
+}
+
+// bufferShard 单个分片, 填充到缓存行大小避免相邻分片的计数器伪共享
+type bufferShard struct {
+	pool   sync.Pool
+	hits   atomic.Uint64
+	misses atomic.Uint64
+	puts   atomic.Uint64
+	drops  atomic.Uint64
+	_      [64]byte
+}
+
+// ShardedBufferPool 分片的 bytes.Buffer 池, 每次操作随机选择分片, 多核下统计计数器不会集中竞争同一缓存行
+type ShardedBufferPool struct {
+	maxSize int
+	shards  []bufferShard
+}
+
+// NewShardedBufferPool 创建 shards 个分片的池, shards <= 0 时取 GOMAXPROCS; maxSize 含义同 NewBufferPool
+func NewShardedBufferPool(shards, maxSize int) *ShardedBufferPool {
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxPooledBuffer
+	}
+	return &ShardedBufferPool{maxSize: maxSize, shards: make([]bufferShard, shards)}
+}
+
+func (p *ShardedBufferPool) shard() *bufferShard {
+	return &p.shards[rand.N(len(p.shards))]
+}
+
+// Get 返回一个已清空的 Buffer
+func (p *ShardedBufferPool) Get() *bytes.Buffer {
+	s := p.shard()
+	if v := s.pool.Get(); v != nil {
+		s.hits.Add(1)
+		return v.(*bytes.Buffer)
+	}
+	s.misses.Add(1)
+	return new(bytes.Buffer)
+}
+
+// Put 归还 Buffer, 容量超过上限的被丢弃; 调用后不得再使用 b
+func (p *ShardedBufferPool) Put(b *bytes.Buffer) {
+	if b == nil {
+		return
+	}
+	s := p.shard()
+	if b.Cap() > p.maxSize {
+		s.drops.Add(1)
+		return
+	}
+	b.Reset()
+	s.puts.Add(1)
+	s.pool.Put(b)
+}
+
+// Stats 汇总各分片的统计
+func (p *ShardedBufferPool) Stats() PoolStats {
+	var st PoolStats
+	for i := range p.shards {
+		s := &p.shards[i]
+		st.Hits += s.hits.Load()
+		st.Misses += s.misses.Load()
+		st.Puts += s.puts.Load()
+		st.Drops += s.drops.Load()
+	}
+	return st
+}
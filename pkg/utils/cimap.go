@@ -0,0 +1,113 @@
+package utils
+
+/*
+	大小写不敏感的字符串键映射, 条目较少时使用线性数组
+*/
+
+// ciMapSmall 条目数不超过该值时用数组线性查找, 超过后建立索引
+const ciMapSmall = 16
+
+// CIMap 键按 ASCII 忽略大小写的有序映射, 保留首次写入时的键和写入顺序, 非并发安全.
+// 零值可直接使用; 适合作为头部、MIME 参数、Cookie 属性的存储
+type CIMap[V any] struct {
+	entries []ciEntry[V]
+	// index 小写键 -> entries 下标, 条目超过 ciMapSmall 时建立
+	index map[string]int
+}
+
+type ciEntry[V any] struct {
+	key   string
+	value V
+}
+
+func (m *CIMap[V]) find(key string) int {
+	if m.index != nil {
+		if i, ok := m.index[ToLowerASCII(key)]; ok {
+			return i
+		}
+		return -1
+	}
+	for i := range m.entries {
+		if EqualFoldASCII(m.entries[i].key, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get 返回 key 对应的值
+func (m *CIMap[V]) Get(key string) (V, bool) {
+	if i := m.find(key); i >= 0 {
+		return m.entries[i].value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// Has 判断 key 是否存在
+func (m *CIMap[V]) Has(key string) bool { return m.find(key) >= 0 }
+
+// Set 设置 key 的值, 已存在时覆盖值并保留原键
+func (m *CIMap[V]) Set(key string, value V) {
+	if i := m.find(key); i >= 0 {
+		m.entries[i].value = value
+		return
+	}
+	m.entries = append(m.entries, ciEntry[V]{key, value})
+	if m.index != nil {
+		m.index[ToLowerASCII(key)] = len(m.entries) - 1
+	} else if len(m.entries) > ciMapSmall {
+		m.reindex()
+	}
+}
+
+// Delete 删除 key, 保持其余条目的顺序
+func (m *CIMap[V]) Delete(key string) {
+	i := m.find(key)
+	if i < 0 {
+		return
+	}
+	m.entries = append(m.entries[:i], m.entries[i+1:]...)
+	if m.index != nil {
+		m.reindex()
+	}
+}
+
+func (m *CIMap[V]) reindex() {
+	if len(m.entries) <= ciMapSmall {
+		m.index = nil
+		return
+	}
+	m.index = make(map[string]int, len(m.entries))
+	for i, e := range m.entries {
+		m.index[ToLowerASCII(e.key)] = i
+	}
+}
+
+// Len 返回条目数
+func (m *CIMap[V]) Len() int { return len(m.entries) }
+
+// Range 按写入顺序遍历, f 返回 false 时停止
+func (m *CIMap[V]) Range(f func(key string, value V) bool) {
+	for _, e := range m.entries {
+		if !f(e.key, e.value) {
+			return
+		}
+	}
+}
+
+// Keys 按写入顺序返回全部键
+func (m *CIMap[V]) Keys() []string {
+	keys := make([]string, len(m.entries))
+	for i, e := range m.entries {
+		keys[i] = e.key
+	}
+	return keys
+}
+
+// Reset 清空映射, 保留已分配的空间
+func (m *CIMap[V]) Reset() {
+	clear(m.entries)
+	m.entries = m.entries[:0]
+	m.index = nil
+}
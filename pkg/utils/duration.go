@@ -0,0 +1,151 @@
+package utils
+
+/*
+	时长解析与格式化: 在 time.ParseDuration 的基础上支持天 (d) 和周 (w), 可以组合使用, 如 "1w2d12h30m",
+	供配置加载和 Retry-After 等使用
+*/
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrInvalidDuration 时长格式错误或超出 time.Duration 范围
+var ErrInvalidDuration = errors.New("utils: invalid duration")
+
+const (
+	// Day 一天, 按固定的 24 小时计算, 不考虑夏令时
+	Day = 24 * time.Hour
+	// Week 七天
+	Week = 7 * Day
+)
+
+var durationUnits = map[string]uint64{
+	"ns": uint64(time.Nanosecond),
+	"us": uint64(time.Microsecond),
+	"µs": uint64(time.Microsecond), // U+00B5
+	"μs": uint64(time.Microsecond), // U+03BC
+	"ms": uint64(time.Millisecond),
+	"s":  uint64(time.Second),
+	"m":  uint64(time.Minute),
+	"h":  uint64(time.Hour),
+	"d":  uint64(Day),
+	"w":  uint64(Week),
+}
+
+// ParseDuration 解析形如 "300ms"、"-1.5h"、"1d12h"、"2w" 的时长, 单位可为 ns、us (µs)、ms、s、m、h、d、w
+func ParseDuration(s string) (time.Duration, error) {
+	orig := s
+	bad := func() (time.Duration, error) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidDuration, orig)
+	}
+	neg := false
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	if s == "0" {
+		return 0, nil
+	}
+	if s == "" {
+		return bad()
+	}
+	var total uint64
+	for s != "" {
+		i := digitsPrefix(s)
+		whole := s[:i]
+		s = s[i:]
+		var frac string
+		if s != "" && s[0] == '.' {
+			s = s[1:]
+			i = digitsPrefix(s)
+			frac, s = s[:i], s[i:]
+		}
+		if whole == "" && frac == "" {
+			return bad()
+		}
+		i = 0
+		for i < len(s) && s[i] != '.' && (s[i] < '0' || s[i] > '9') {
+			i++
+		}
+		unit, ok := durationUnits[s[:i]]
+		if !ok {
+			return bad()
+		}
+		s = s[i:]
+
+		var v uint64
+		if whole != "" {
+			n, err := strconv.ParseUint(whole, 10, 64)
+			if err != nil || n > (1<<63)/unit {
+				return bad()
+			}
+			v = n * unit
+		}
+		if frac != "" {
+			// 与 time.ParseDuration 相同, 小数部分按浮点换算, 超过 18 位的精度被忽略
+			f, _ := strconv.ParseFloat("0."+frac[:min(len(frac), 18)], 64)
+			v += uint64(f * float64(unit))
+		}
+		total += v
+		if v > 1<<63 || total > 1<<63 {
+			return bad()
+		}
+	}
+	if total == 1<<63 && !neg {
+		return bad()
+	}
+	if neg {
+		return -time.Duration(total), nil
+	}
+	return time.Duration(total), nil
+}
+
+func digitsPrefix(s string) int {
+	i := 0
+	for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+		i++
+	}
+	return i
+}
+
+// FormatDuration 按周、天、时、分、秒格式化, 省略为零的部分, 结果可被 ParseDuration 还原, 如 "1w2d3h0.5s".
+// 不足一秒时与 time.Duration.String 一致
+func FormatDuration(d time.Duration) string {
+	if d == 0 {
+		return "0s"
+	}
+	var b []byte
+	u := uint64(d)
+	if d < 0 {
+		b = append(b, '-')
+		u = -u
+	}
+	if u < uint64(time.Second) {
+		return string(b) + time.Duration(u).String()
+	}
+	for _, unit := range [...]struct {
+		n    time.Duration
+		name byte
+	}{{Week, 'w'}, {Day, 'd'}, {time.Hour, 'h'}, {time.Minute, 'm'}} {
+		if q := u / uint64(unit.n); q > 0 {
+			b = strconv.AppendUint(b, q, 10)
+			b = append(b, unit.name)
+			u %= uint64(unit.n)
+		}
+	}
+	if u > 0 {
+		b = strconv.AppendUint(b, u/uint64(time.Second), 10)
+		if ns := u % uint64(time.Second); ns > 0 {
+			frac := strconv.AppendUint(nil, ns+uint64(time.Second), 10)[1:]
+			for frac[len(frac)-1] == '0' {
+				frac = frac[:len(frac)-1]
+			}
+			b = append(append(b, '.'), frac...)
+		}
+		b = append(b, 's')
+	}
+	return string(b)
+}
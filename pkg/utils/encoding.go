@@ -0,0 +1,138 @@
+package utils
+
+/*
+	流式 base64/hex 编解码: 补充标准库缺少的方向 (编码 Reader 和解码 Writer),
+	大消息体无需整体缓冲. 编码 Writer 和解码 Reader 请直接使用 base64.NewEncoder/NewDecoder 与 hex.NewEncoder/NewDecoder
+*/
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+)
+
+// encodeChunkSize 编码 Reader 每次从源读取的字节数
+const encodeChunkSize = 3 * 1024
+
+// encodingReader 从源读取原始数据, 按 block 对齐后编码输出
+type encodingReader struct {
+	r      io.Reader
+	block  int
+	encode func(raw []byte) []byte
+	in     []byte
+	rest   int
+	out    []byte
+	err    error
+}
+
+func (e *encodingReader) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if e.err != nil {
+			return 0, e.err
+		}
+		n, err := e.r.Read(e.in[e.rest:])
+		raw := e.in[:e.rest+n]
+		usable := len(raw)
+		if err == nil {
+			usable -= usable % e.block
+		}
+		e.out = e.encode(raw[:usable])
+		e.rest = copy(e.in, raw[usable:])
+		e.err = err
+	}
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+// NewBase64EncodingReader 返回从 r 读取原始数据并输出 base64 文本的 Reader
+func NewBase64EncodingReader(enc *base64.Encoding, r io.Reader) io.Reader {
+	var out []byte
+	return &encodingReader{r: r, block: 3, in: make([]byte, encodeChunkSize), encode: func(raw []byte) []byte {
+		out = growBytes(out, enc.EncodedLen(len(raw)))
+		enc.Encode(out, raw)
+		return out
+	}}
+}
+
+// NewHexEncodingReader 返回从 r 读取原始数据并输出小写十六进制文本的 Reader
+func NewHexEncodingReader(r io.Reader) io.Reader {
+	var out []byte
+	return &encodingReader{r: r, block: 1, in: make([]byte, encodeChunkSize), encode: func(raw []byte) []byte {
+		out = hex.AppendEncode(out[:0], raw)
+		return out
+	}}
+}
+
+// decodingWriter 接收编码文本, 按 block 对齐后解码写入 w, Close 时处理剩余部分
+type decodingWriter struct {
+	w      io.Writer
+	block  int
+	skipWS bool
+	decode func(dst, text []byte) ([]byte, error)
+	buf    []byte
+	out    []byte
+	err    error
+}
+
+func (d *decodingWriter) Write(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	for _, c := range p {
+		if d.skipWS && (c == '\r' || c == '\n') {
+			continue
+		}
+		d.buf = append(d.buf, c)
+	}
+	usable := len(d.buf) - len(d.buf)%d.block
+	if usable == 0 {
+		return len(p), nil
+	}
+	if err := d.flush(d.buf[:usable]); err != nil {
+		return 0, err
+	}
+	d.buf = d.buf[:copy(d.buf, d.buf[usable:])]
+	return len(p), nil
+}
+
+func (d *decodingWriter) flush(text []byte) error {
+	var err error
+	if d.out, err = d.decode(d.out[:0], text); err == nil {
+		_, err = d.w.Write(d.out)
+	}
+	d.err = err
+	return err
+}
+
+// Close 解码剩余的不完整块 (无填充编码的结尾), 不关闭下层 Writer
+func (d *decodingWriter) Close() error {
+	if d.err != nil || len(d.buf) == 0 {
+		return d.err
+	}
+	err := d.flush(d.buf)
+	d.buf = d.buf[:0]
+	return err
+}
+
+// NewBase64DecodingWriter 返回接收 base64 文本并向 w 写出原始数据的 Writer, 忽略换行; 须调用 Close 处理结尾
+func NewBase64DecodingWriter(enc *base64.Encoding, w io.Writer) io.WriteCloser {
+	return &decodingWriter{w: w, block: 4, skipWS: true, decode: func(dst, text []byte) ([]byte, error) {
+		dst = growBytes(dst, enc.DecodedLen(len(text)))
+		n, err := enc.Decode(dst, text)
+		return dst[:n], err
+	}}
+}
+
+// NewHexDecodingWriter 返回接收十六进制文本并向 w 写出原始数据的 Writer; 须调用 Close 检查结尾是否完整
+func NewHexDecodingWriter(w io.Writer) io.WriteCloser {
+	return &decodingWriter{w: w, block: 2, decode: hex.AppendDecode}
+}
+
+// growBytes 返回长度为 n 的切片, 容量足够时复用 b
+func growBytes(b []byte, n int) []byte {
+	if cap(b) < n {
+		return make([]byte, n)
+	}
+	return b[:n]
+}
@@ -0,0 +1,78 @@
+package utils
+
+/*
+	ETag 与内容哈希: 基于内容的强 ETag、基于大小和修改时间的弱 ETag, 以及边读边算摘要的 HashingReader
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ETagSHA256 返回内容 SHA-256 摘要的强 ETag (带引号)
+func ETagSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return ETagFromSum(sum[:])
+}
+
+// ETagCRC32 返回内容 CRC32 (IEEE) 的强 ETag, 计算更快但只适合防止意外不一致
+func ETagCRC32(data []byte) string {
+	return crc32ETag(crc32.ChecksumIEEE(data))
+}
+
+func crc32ETag(sum uint32) string {
+	return `"` + strconv.FormatUint(uint64(sum), 16) + `"`
+}
+
+// ETagFromSum 将摘要编码为强 ETag: 引号包裹的无填充 base64url
+func ETagFromSum(sum []byte) string {
+	return `"` + base64.RawURLEncoding.EncodeToString(sum) + `"`
+}
+
+// WeakETag 由内容大小和修改时间生成弱 ETag, 适用于静态文件等无需读取内容的场景
+func WeakETag(size int64, modTime time.Time) string {
+	return `W/"` + strconv.FormatInt(size, 16) + "-" + strconv.FormatInt(modTime.UnixNano(), 16) + `"`
+}
+
+// HashingReader 在读取的同时计算摘要, 读到 EOF 后即可取得 ETag, 无需缓冲整个消息体
+type HashingReader struct {
+	r io.Reader
+	h hash.Hash
+	n int64
+}
+
+// NewHashingReader 包装 r, 读出的数据同时写入 h; h 为 nil 时使用 SHA-256
+func NewHashingReader(r io.Reader, h hash.Hash) *HashingReader {
+	if h == nil {
+		h = sha256.New()
+	}
+	return &HashingReader{r: r, h: h}
+}
+
+func (hr *HashingReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	if n > 0 {
+		hr.h.Write(p[:n])
+		hr.n += int64(n)
+	}
+	return n, err
+}
+
+// Sum 返回目前已读数据的摘要
+func (hr *HashingReader) Sum() []byte { return hr.h.Sum(nil) }
+
+// Size 返回目前已读的字节数
+func (hr *HashingReader) Size() int64 { return hr.n }
+
+// ETag 返回目前已读数据的强 ETag; 使用 CRC32 时与 ETagCRC32 的格式一致
+func (hr *HashingReader) ETag() string {
+	if h32, ok := hr.h.(hash.Hash32); ok && hr.h.Size() == crc32.Size {
+		return crc32ETag(h32.Sum32())
+	}
+	return ETagFromSum(hr.Sum())
+}
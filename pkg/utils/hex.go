@@ -0,0 +1,61 @@
+package utils
+
+/*
+	分块大小的十六进制解析与格式化 (RFC 9112 7.1), 供分块编解码和 HTTP/1 代理共用, 均不分配内存
+*/
+
+import "errors"
+
+var (
+	// ErrChunkSize 分块大小不是合法的十六进制数
+	ErrChunkSize = errors.New("utils: invalid chunk size")
+	// ErrChunkSizeOverflow 分块大小超出 63 位
+	ErrChunkSizeOverflow = errors.New("utils: chunk size overflows")
+)
+
+// MaxChunkSizeLen 格式化后的分块大小最多占用的字节数
+const MaxChunkSizeLen = 16
+
+// ParseChunkSize 解析分块大小行 (不含 CRLF), 忽略 ';' 开始的分块扩展和其前的空白;
+// 数字之后出现扩展以外的内容时返回 ErrChunkSize
+func ParseChunkSize(line []byte) (uint64, error) {
+	var n uint64
+	i := 0
+	for ; i < len(line); i++ {
+		d, ok := unhex(line[i])
+		if !ok {
+			break
+		}
+		if n>>59 != 0 {
+			return 0, ErrChunkSizeOverflow
+		}
+		n = n<<4 | uint64(d)
+	}
+	if i == 0 {
+		return 0, ErrChunkSize
+	}
+	rest := line[i:]
+	for len(rest) > 0 && (rest[0] == ' ' || rest[0] == '\t') {
+		rest = rest[1:]
+	}
+	if len(rest) > 0 && rest[0] != ';' {
+		return 0, ErrChunkSize
+	}
+	return n, nil
+}
+
+// AppendChunkSize 将 n 以小写十六进制追加到 dst, 不含 CRLF
+func AppendChunkSize(dst []byte, n uint64) []byte {
+	const digits = "0123456789abcdef"
+	var buf [MaxChunkSizeLen]byte
+	i := len(buf)
+	for {
+		i--
+		buf[i] = digits[n&0xf]
+		n >>= 4
+		if n == 0 {
+			break
+		}
+	}
+	return append(dst, buf[i:]...)
+}
@@ -0,0 +1,70 @@
+package utils
+
+/*
+	字符串驻留: 相同内容返回同一个字符串, 避免解析时为重复出现的名称反复分配
+*/
+
+import "sync"
+
+// Interner 字符串驻留表, 并发安全. 预置的字符串只读; 动态加入的字符串数量受 max 限制,
+// 防止不可信输入撑大表
+type Interner struct {
+	static map[string]string
+	max    int
+
+	mu      sync.RWMutex
+	dynamic map[string]string
+}
+
+// NewInterner 创建预置 preload 的驻留表, 最多再动态加入 max 个字符串 (0 表示只使用预置表)
+func NewInterner(max int, preload ...string) *Interner {
+	in := &Interner{static: make(map[string]string, len(preload)), max: max}
+	for _, s := range preload {
+		in.static[s] = s
+	}
+	return in
+}
+
+// Lookup 在表中查找与 b 内容相同的字符串, 不分配内存
+func (in *Interner) Lookup(b []byte) (string, bool) {
+	if s, ok := in.static[string(b)]; ok {
+		return s, true
+	}
+	if in.max <= 0 {
+		return "", false
+	}
+	in.mu.RLock()
+	s, ok := in.dynamic[string(b)]
+	in.mu.RUnlock()
+	return s, ok
+}
+
+// Intern 返回与 b 内容相同的驻留字符串, 表已满时返回新分配的字符串
+func (in *Interner) Intern(b []byte) string {
+	if s, ok := in.Lookup(b); ok {
+		return s
+	}
+	s := string(b)
+	if in.max <= 0 {
+		return s
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if prev, ok := in.dynamic[s]; ok {
+		return prev
+	}
+	if len(in.dynamic) < in.max {
+		if in.dynamic == nil {
+			in.dynamic = make(map[string]string)
+		}
+		in.dynamic[s] = s
+	}
+	return s
+}
+
+// Len 返回表中的字符串数量
+func (in *Interner) Len() int {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+	return len(in.static) + len(in.dynamic)
+}
@@ -1 +1,346 @@
 package utils
+
+/*
+	IO 辅助: 计数读写器、超时读取器、带取消的复制和写入上限等
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// counter 原子字节计数, 每跨过 every 的整数倍时回调一次
+type counter struct {
+	n     atomic.Int64
+	every int64
+	fn    func(total int64)
+}
+
+func (c *counter) add(n int) {
+	if n <= 0 {
+		return
+	}
+	total := c.n.Add(int64(n))
+	// 每次 Add 覆盖的区间互不重叠, 无需加锁即可保证每个阈值只回调一次
+	if c.fn != nil && c.every > 0 && total/c.every > (total-int64(n))/c.every {
+		c.fn(total)
+	}
+}
+
+// CountingReader 统计读取字节数, 计数使用原子操作, 不会串行化并发读取
+type CountingReader struct {
+	r io.Reader
+	counter
+}
+
+// NewCountingReader 包装 r 并统计读取的字节数
+func NewCountingReader(r io.Reader) *CountingReader {
+	return &CountingReader{r: r}
+}
+
+// OnThreshold 设置阈值回调: 累计读取量每跨过 every 的整数倍时以当前总量调用 fn.
+// 须在开始读取前设置; 回调在 Read 所在 goroutine 中同步执行
+func (c *CountingReader) OnThreshold(every int64, fn func(total int64)) *CountingReader {
+	c.every, c.fn = every, fn
+	return c
+}
+
+func (c *CountingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.add(n)
+	return n, err
+}
+
+// Count 返回已读取的字节数
+func (c *CountingReader) Count() int64 { return c.n.Load() }
+
+// Reset 清零计数
+func (c *CountingReader) Reset() { c.n.Store(0) }
+
+// CountingWriter 统计写出字节数, 计数使用原子操作, 不会串行化并发写入
+type CountingWriter struct {
+	w io.Writer
+	counter
+}
+
+// NewCountingWriter 包装 w 并统计写出的字节数
+func NewCountingWriter(w io.Writer) *CountingWriter {
+	return &CountingWriter{w: w}
+}
+
+// OnThreshold 设置阈值回调: 累计写出量每跨过 every 的整数倍时以当前总量调用 fn.
+// 须在开始写入前设置; 回调在 Write 所在 goroutine 中同步执行
+func (c *CountingWriter) OnThreshold(every int64, fn func(total int64)) *CountingWriter {
+	c.every, c.fn = every, fn
+	return c
+}
+
+func (c *CountingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.add(n)
+	return n, err
+}
+
+// Count 返回已写出的字节数
+func (c *CountingWriter) Count() int64 { return c.n.Load() }
+
+// Reset 清零计数
+func (c *CountingWriter) Reset() { c.n.Store(0) }
+
+// ErrReadTimeout TimeoutReader 在超时时间内未读到数据, 实现 net.Error 且 Timeout() 为 true
+var ErrReadTimeout error = readTimeoutError{}
+
+type readTimeoutError struct{}
+
+func (readTimeoutError) Error() string   { return "utils: read timeout" }
+func (readTimeoutError) Timeout() bool   { return true }
+func (readTimeoutError) Temporary() bool { return true }
+
+// deadlineReader 支持读截止时间的读取器, 如 net.Conn 和 *os.File
+type deadlineReader interface {
+	io.Reader
+	SetReadDeadline(t time.Time) error
+}
+
+// TimeoutReader 为每次 Read 设置超时.
+// 底层支持 SetReadDeadline 时直接使用截止时间, 不创建 goroutine;
+// 否则至多维持一个后台读取, 超时后该读取继续进行, 其结果交给下一次 Read, 数据不会丢失
+type TimeoutReader struct {
+	r       io.Reader
+	dr      deadlineReader
+	timeout time.Duration
+
+	mu       sync.Mutex
+	inflight chan timedRead
+	buf      []byte
+	pending  []byte
+	err      error
+}
+
+type timedRead struct {
+	n   int
+	err error
+}
+
+// NewTimeoutReader 包装 r, 每次 Read 最多等待 timeout; timeout <= 0 表示不限制
+func NewTimeoutReader(r io.Reader, timeout time.Duration) *TimeoutReader {
+	tr := &TimeoutReader{r: r, timeout: timeout}
+	tr.dr, _ = r.(deadlineReader)
+	return tr
+}
+
+func (t *TimeoutReader) Read(p []byte) (int, error) {
+	return t.ReadContext(context.Background(), p)
+}
+
+// ReadContext 读取数据, 超时返回 ErrReadTimeout, ctx 结束返回 ctx.Err()
+func (t *TimeoutReader) ReadContext(ctx context.Context, p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.dr != nil {
+		return t.readDeadline(ctx, p)
+	}
+	return t.readAsync(ctx, p)
+}
+
+func (t *TimeoutReader) readDeadline(ctx context.Context, p []byte) (int, error) {
+	var dl time.Time
+	if t.timeout > 0 {
+		dl = time.Now().Add(t.timeout)
+	}
+	if err := t.dr.SetReadDeadline(dl); err != nil {
+		if errors.Is(err, os.ErrNoDeadline) {
+			// 如普通文件: 退回后台读取
+			t.dr = nil
+			return t.readAsync(ctx, p)
+		}
+		return 0, err
+	}
+	stop := context.AfterFunc(ctx, func() { t.dr.SetReadDeadline(time.Unix(1, 0)) })
+	n, err := t.dr.Read(p)
+	if !stop() && err != nil {
+		return n, ctx.Err()
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = ErrReadTimeout
+	}
+	return n, err
+}
+
+func (t *TimeoutReader) readAsync(ctx context.Context, p []byte) (int, error) {
+	if len(t.pending) > 0 {
+		n := copy(p, t.pending)
+		t.pending = t.pending[n:]
+		return n, nil
+	}
+	if t.err != nil {
+		return 0, t.err
+	}
+	if t.inflight == nil {
+		if cap(t.buf) < len(p) {
+			t.buf = make([]byte, len(p))
+		}
+		buf, ch := t.buf[:len(p)], make(chan timedRead, 1)
+		t.inflight = ch
+		go func() {
+			n, err := t.r.Read(buf)
+			ch <- timedRead{n, err}
+		}()
+	}
+
+	var timeout <-chan time.Time
+	if t.timeout > 0 {
+		timer := time.NewTimer(t.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case res := <-t.inflight:
+		t.inflight = nil
+		n := copy(p, t.buf[:res.n])
+		t.pending = t.buf[n:res.n]
+		if res.err != nil {
+			if len(t.pending) > 0 {
+				// 先交付剩余数据, 错误留给之后的 Read
+				t.err = res.err
+				return n, nil
+			}
+			t.err = res.err
+		}
+		return n, res.err
+	case <-timeout:
+		return 0, ErrReadTimeout
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// Close 关闭底层读取器 (如果实现了 io.Closer), 这会结束仍在进行的后台读取
+func (t *TimeoutReader) Close() error {
+	if c, ok := t.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// DefaultCopyBufferSize 复制缓冲为空时从 BytePool 取的缓冲大小
+const DefaultCopyBufferSize = 32 << 10
+
+// CopyWithBuffer 同 io.CopyBuffer, buf 为空时使用池化的缓冲
+func CopyWithBuffer(dst io.Writer, src io.Reader, buf []byte) (int64, error) {
+	if len(buf) == 0 {
+		buf = GetBytes(DefaultCopyBufferSize)
+		defer PutBytes(buf)
+	}
+	return io.CopyBuffer(dst, src, buf)
+}
+
+// CopyContext 将 src 复制到 dst, 每搬运一块后检查 ctx 并以累计字节数调用 progress (可为空).
+// ctx 结束时返回已复制的字节数和 ctx.Err(); 阻塞中的 Read/Write 不会被打断,
+// 需要立即中止时应同时为连接设置截止时间或关闭连接.
+// 为了能够逐块检查和回报进度, 不使用 WriterTo/ReaderFrom 快速路径
+func CopyContext(ctx context.Context, dst io.Writer, src io.Reader, buf []byte, progress func(written int64)) (int64, error) {
+	if len(buf) == 0 {
+		buf = GetBytes(DefaultCopyBufferSize)
+		defer PutBytes(buf)
+	}
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		nr, rerr := src.Read(buf)
+		if nr > 0 {
+			nw, werr := dst.Write(buf[:nr])
+			if nw < 0 || nw > nr {
+				nw, werr = 0, errors.New("utils: invalid write result")
+			}
+			written += int64(nw)
+			if progress != nil && nw > 0 {
+				progress(written)
+			}
+			if werr == nil && nw != nr {
+				werr = io.ErrShortWrite
+			}
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if rerr == io.EOF {
+			return written, nil
+		}
+		if rerr != nil {
+			return written, rerr
+		}
+	}
+}
+
+// LimitExceededError 严格模式的 LimitWriter 写入超出上限, errors.Is(err, ErrLimitExceeded) 为 true
+type LimitExceededError struct {
+	Limit int64
+	// Discarded 累计被丢弃的字节数
+	Discarded int64
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("utils: write limit %d exceeded, %d bytes discarded", e.Limit, e.Discarded)
+}
+
+func (e *LimitExceededError) Unwrap() error { return ErrLimitExceeded }
+
+// LimitWriter 最多写出 limit 字节. 默认超出部分被静默丢弃并报告写入成功;
+// 严格模式下超出时写出剩余额度并返回 *LimitExceededError
+type LimitWriter struct {
+	w         io.Writer
+	limit     int64
+	strict    bool
+	written   int64
+	discarded int64
+}
+
+// NewLimitWriter 创建超出部分静默截断的 LimitWriter
+func NewLimitWriter(w io.Writer, limit int64) *LimitWriter {
+	return &LimitWriter{w: w, limit: limit}
+}
+
+// NewStrictLimitWriter 创建超出时返回 *LimitExceededError 的 LimitWriter, 用于错误响应等需要感知截断的场景
+func NewStrictLimitWriter(w io.Writer, limit int64) *LimitWriter {
+	return &LimitWriter{w: w, limit: limit, strict: true}
+}
+
+func (l *LimitWriter) Write(p []byte) (int, error) {
+	room := max(l.limit-l.written, 0)
+	if int64(len(p)) <= room {
+		n, err := l.w.Write(p)
+		l.written += int64(n)
+		return n, err
+	}
+	n, err := l.w.Write(p[:room])
+	l.written += int64(n)
+	if err != nil {
+		return n, err
+	}
+	l.discarded += int64(len(p)) - room
+	if l.strict {
+		return n, &LimitExceededError{Limit: l.limit, Discarded: l.discarded}
+	}
+	return len(p), nil
+}
+
+// Written 返回实际写出的字节数
+func (l *LimitWriter) Written() int64 { return l.written }
+
+// Discarded 返回被丢弃的字节数
+func (l *LimitWriter) Discarded() int64 { return l.discarded }
+
+// Exceeded 报告是否发生过截断
+func (l *LimitWriter) Exceeded() bool { return l.discarded > 0 }
@@ -0,0 +1,243 @@
+package utils
+
+/*
+	限流算法: 令牌桶、滑动窗口日志、滑动窗口计数、漏桶, 统一实现 Limiter 接口,
+	中间件可按精度与内存开销选择
+*/
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrLimitExceeded 超出上限: 限流器的单次申请超过容量 (永远无法满足), 或 LimitWriter 写入超过上限
+var ErrLimitExceeded = errors.New("utils: limit exceeded")
+
+// Limiter 限流器的公共接口
+type Limiter interface {
+	// AllowN 非阻塞地在 now 时刻申请 n 个配额
+	AllowN(now time.Time, n int) bool
+	// Wait 阻塞直到取得 n 个配额或 ctx 结束
+	Wait(ctx context.Context, n int) error
+}
+
+var (
+	_ Limiter = (*RateLimiter)(nil)
+	_ Limiter = (*SlidingWindowLog)(nil)
+	_ Limiter = (*SlidingWindowCounter)(nil)
+	_ Limiter = (*LeakyBucket)(nil)
+)
+
+// waitLimiter 反复尝试 try 直到成功; try 失败时返回需要等待的时间
+func waitLimiter(ctx context.Context, c Clock, try func(now time.Time) (bool, time.Duration)) error {
+	c = ClockOr(c)
+	for {
+		ok, wait := try(c.Now())
+		if ok {
+			return nil
+		}
+		if !sleepClock(ctx.Done(), c, max(wait, time.Millisecond)) {
+			return ctx.Err()
+		}
+	}
+}
+
+// SlidingWindowLog 滑动窗口日志: 记录窗口内每个配额的时间戳, 精确但内存随 limit 线性增长
+type SlidingWindowLog struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	// 环形队列, 按时间升序
+	log   []time.Time
+	head  int
+	count int
+	clock Clock
+}
+
+// NewSlidingWindowLog 创建任意 window 时长内至多 limit 个配额的限流器
+func NewSlidingWindowLog(limit int, window time.Duration) *SlidingWindowLog {
+	limit = max(limit, 1)
+	return &SlidingWindowLog{limit: limit, window: window, log: make([]time.Time, limit)}
+}
+
+// AllowN 窗口内剩余配额足够时记录 n 个时间戳
+func (l *SlidingWindowLog) AllowN(now time.Time, n int) bool {
+	ok, _ := l.try(now, n)
+	return ok
+}
+
+// Wait 阻塞直到窗口内有 n 个空闲配额
+func (l *SlidingWindowLog) Wait(ctx context.Context, n int) error {
+	if n > l.limit {
+		return ErrLimitExceeded
+	}
+	return waitLimiter(ctx, l.clock, func(now time.Time) (bool, time.Duration) { return l.try(now, n) })
+}
+
+// SetClock 替换 Wait 使用的时间来源, 须在使用前调用
+func (l *SlidingWindowLog) SetClock(c Clock) { l.clock = c }
+
+func (l *SlidingWindowLog) try(now time.Time, n int) (bool, time.Duration) {
+	if n <= 0 {
+		return true, 0
+	}
+	if n > l.limit {
+		return false, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cutoff := now.Add(-l.window)
+	for l.count > 0 && !l.log[l.head].After(cutoff) {
+		l.head = (l.head + 1) % l.limit
+		l.count--
+	}
+	if need := l.count + n - l.limit; need > 0 {
+		// 需要等到第 need 个最旧的记录滑出窗口
+		oldest := l.log[(l.head+need-1)%l.limit]
+		return false, oldest.Add(l.window).Sub(now)
+	}
+	for i := 0; i < n; i++ {
+		l.log[(l.head+l.count)%l.limit] = now
+		l.count++
+	}
+	return true, 0
+}
+
+// SlidingWindowCounter 滑动窗口计数: 按上一窗口计数的剩余比例加权估算, 内存固定, 精度略低
+type SlidingWindowCounter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	start  time.Time
+	cur    int
+	prev   int
+	clock  Clock
+}
+
+// NewSlidingWindowCounter 创建每个 window 时长约 limit 个配额的限流器
+func NewSlidingWindowCounter(limit int, window time.Duration) *SlidingWindowCounter {
+	return &SlidingWindowCounter{limit: max(limit, 1), window: window}
+}
+
+// AllowN 估算值加 n 不超过 limit 时计入当前窗口
+func (l *SlidingWindowCounter) AllowN(now time.Time, n int) bool {
+	ok, _ := l.try(now, n)
+	return ok
+}
+
+// Wait 阻塞直到估算的窗口用量允许 n 个配额
+func (l *SlidingWindowCounter) Wait(ctx context.Context, n int) error {
+	if n > l.limit {
+		return ErrLimitExceeded
+	}
+	return waitLimiter(ctx, l.clock, func(now time.Time) (bool, time.Duration) { return l.try(now, n) })
+}
+
+// SetClock 替换 Wait 使用的时间来源, 须在使用前调用
+func (l *SlidingWindowCounter) SetClock(c Clock) { l.clock = c }
+
+func (l *SlidingWindowCounter) try(now time.Time, n int) (bool, time.Duration) {
+	if n <= 0 {
+		return true, 0
+	}
+	if n > l.limit {
+		return false, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.roll(now)
+	elapsed := now.Sub(l.start)
+	weight := 1 - float64(elapsed)/float64(l.window)
+	if float64(l.prev)*weight+float64(l.cur+n) <= float64(l.limit) {
+		l.cur += n
+		return true, 0
+	}
+	next := l.window - elapsed
+	if l.cur+n <= l.limit && l.prev > 0 {
+		// 上一窗口的权重降到 (limit-cur-n)/prev 时即可放行
+		need := 1 - float64(l.limit-l.cur-n)/float64(l.prev)
+		next = time.Duration(need*float64(l.window)) - elapsed
+	}
+	return false, next
+}
+
+// roll 根据 now 推进固定窗口
+func (l *SlidingWindowCounter) roll(now time.Time) {
+	if l.start.IsZero() {
+		l.start = now
+		return
+	}
+	switch elapsed := now.Sub(l.start); {
+	case elapsed < l.window:
+	case elapsed < 2*l.window:
+		l.prev, l.cur = l.cur, 0
+		l.start = l.start.Add(l.window)
+	default:
+		l.prev, l.cur = 0, 0
+		l.start = now
+	}
+}
+
+// LeakyBucket 漏桶: 水位按固定速率下降, 加入后水位不超过容量即放行, 输出速率平滑无突发
+type LeakyBucket struct {
+	mu       sync.Mutex
+	rate     float64
+	capacity float64
+	level    float64
+	last     time.Time
+	clock    Clock
+}
+
+// NewLeakyBucket 创建每秒漏出 rate 个、容量为 capacity 的漏桶, capacity <= 0 时取 1
+func NewLeakyBucket(rate float64, capacity int) *LeakyBucket {
+	return &LeakyBucket{rate: rate, capacity: float64(max(capacity, 1))}
+}
+
+// AllowN 加入 n 后水位不超过容量时放行
+func (l *LeakyBucket) AllowN(now time.Time, n int) bool {
+	ok, _ := l.try(now, n)
+	return ok
+}
+
+// Wait 阻塞直到桶内有 n 个空位
+func (l *LeakyBucket) Wait(ctx context.Context, n int) error {
+	if float64(n) > l.capacity {
+		return ErrLimitExceeded
+	}
+	return waitLimiter(ctx, l.clock, func(now time.Time) (bool, time.Duration) { return l.try(now, n) })
+}
+
+// SetClock 替换 Wait 使用的时间来源, 须在使用前调用
+func (l *LeakyBucket) SetClock(c Clock) { l.clock = c }
+
+func (l *LeakyBucket) try(now time.Time, n int) (bool, time.Duration) {
+	if n <= 0 || l.rate <= 0 {
+		return true, 0
+	}
+	if float64(n) > l.capacity {
+		return false, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() && now.After(l.last) {
+		l.level = math.Max(l.level-now.Sub(l.last).Seconds()*l.rate, 0)
+	}
+	if now.After(l.last) {
+		l.last = now
+	}
+	if over := l.level + float64(n) - l.capacity; over > 0 {
+		return false, time.Duration(over / l.rate * float64(time.Second))
+	}
+	l.level += float64(n)
+	return true, 0
+}
+
+// Level 返回当前水位
+func (l *LeakyBucket) Level() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.level
+}
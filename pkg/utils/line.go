@@ -0,0 +1,86 @@
+package utils
+
+/*
+	行读取: 限制最大长度, 可选严格要求 CRLF 行尾
+*/
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+var (
+	// ErrLineTooLong 行长度 (不含行尾) 超过上限
+	ErrLineTooLong = errors.New("utils: line too long")
+	// ErrBareLF 严格模式下遇到没有 CR 的 LF 行尾
+	ErrBareLF = errors.New("utils: line not terminated by CRLF")
+)
+
+// LineReader 面向 HTTP 协议的行读取器
+type LineReader struct {
+	br *bufio.Reader
+	// MaxLength 单行最大字节数 (不含行尾), <= 0 表示不限制
+	MaxLength int
+	// StrictCRLF 为 true 时拒绝仅以 LF 结尾的行, 否则与 RFC 9112 建议一致地容忍 LF
+	StrictCRLF bool
+
+	buf []byte
+}
+
+// NewLineReader 从 br 读取行
+func NewLineReader(br *bufio.Reader, maxLength int, strictCRLF bool) *LineReader {
+	return &LineReader{br: br, MaxLength: maxLength, StrictCRLF: strictCRLF}
+}
+
+// ReadLine 读取一行并去掉行尾, 返回的切片在下次调用前有效.
+// 超长时返回 ErrLineTooLong, 已读取的部分被丢弃; 行未结束就遇到 EOF 时返回 io.ErrUnexpectedEOF
+func (lr *LineReader) ReadLine() ([]byte, error) {
+	line, buf, err := readLine(lr.br, lr.MaxLength, lr.StrictCRLF, lr.buf[:0])
+	lr.buf = buf
+	return line, err
+}
+
+// ReadLine 不保留状态的单次行读取, 规则同 LineReader.ReadLine; 返回的切片可能引用 br 的内部缓冲
+func ReadLine(br *bufio.Reader, maxLength int, strictCRLF bool) ([]byte, error) {
+	line, _, err := readLine(br, maxLength, strictCRLF, nil)
+	return line, err
+}
+
+// readLine buf 用于拼接跨越 bufio 缓冲区的长行, 返回可能扩容后的 buf 以便复用
+func readLine(br *bufio.Reader, maxLength int, strict bool, buf []byte) ([]byte, []byte, error) {
+	joined := false
+	for {
+		chunk, err := br.ReadSlice('\n')
+		// 允许多出行尾的两个字节
+		if maxLength > 0 && len(buf)+len(chunk) > maxLength+2 {
+			return nil, buf, ErrLineTooLong
+		}
+		if err == bufio.ErrBufferFull {
+			buf = append(buf, chunk...)
+			joined = true
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(buf)+len(chunk) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, buf, err
+		}
+		line := chunk
+		if joined {
+			buf = append(buf, chunk...)
+			line = buf
+		}
+		line = line[:len(line)-1]
+		if len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		} else if strict {
+			return nil, buf, ErrBareLF
+		}
+		if maxLength > 0 && len(line) > maxLength {
+			return nil, buf, ErrLineTooLong
+		}
+		return line, buf, nil
+	}
+}
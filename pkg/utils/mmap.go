@@ -0,0 +1,138 @@
+package utils
+
+/*
+	内存映射文件: 供静态文件服务读取热点文件, 避免每次读取的系统调用.
+	通过引用计数管理映射的生命周期, 不支持 mmap 的平台或映射失败时退化为 os.File.ReadAt
+*/
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// ErrMmapClosed MmapReader 的引用已全部释放
+var ErrMmapClosed = errors.New("utils: mmap reader closed")
+
+// MmapAdvice 访问模式提示, 对应 madvise; 仅 Linux 生效, 其他平台忽略
+type MmapAdvice int
+
+const (
+	MmapNormal MmapAdvice = iota
+	MmapSequential
+	MmapRandom
+	MmapWillNeed
+	MmapDontNeed
+)
+
+// MmapReader 只读映射的文件, 实现 io.ReaderAt, 并发安全.
+// 创建时持有一个引用, 每个额外的使用者先 Acquire, 用完后 Close; 最后一个引用释放时解除映射.
+// 调用方必须在持有引用期间读取, 释放后访问 Bytes 返回的切片会导致崩溃
+type MmapReader struct {
+	data []byte
+	f    *os.File // 未映射时用于 ReadAt
+	size int64
+	refs atomic.Int64
+}
+
+var _ io.ReaderAt = (*MmapReader)(nil)
+
+// OpenMmap 打开并映射 path
+func OpenMmap(path string) (*MmapReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := NewMmapReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewMmapReader 映射 f 的全部内容, 接管 f 的所有权. 空文件、非普通文件或映射失败时退化为 ReadAt 模式
+func NewMmapReader(f *os.File) (*MmapReader, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	m := &MmapReader{size: fi.Size()}
+	m.refs.Store(1)
+	if fi.Mode().IsRegular() && m.size > 0 && int64(int(m.size)) == m.size {
+		if data, err := mmapFile(f, int(m.size)); err == nil {
+			m.data = data
+			f.Close()
+			return m, nil
+		}
+	}
+	m.f = f
+	return m, nil
+}
+
+// Mapped 报告是否处于映射模式
+func (m *MmapReader) Mapped() bool { return m.data != nil }
+
+// Len 返回文件大小
+func (m *MmapReader) Len() int64 { return m.size }
+
+// Bytes 返回映射的内容, 未映射时返回 nil; 切片仅在持有引用期间有效
+func (m *MmapReader) Bytes() []byte { return m.data }
+
+// ReadAt 从偏移 off 读取
+func (m *MmapReader) ReadAt(p []byte, off int64) (int, error) {
+	if m.refs.Load() <= 0 {
+		return 0, ErrMmapClosed
+	}
+	if m.data == nil {
+		return m.f.ReadAt(p, off)
+	}
+	if off < 0 {
+		return 0, errors.New("utils: negative offset")
+	}
+	if off >= m.size {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Advise 提示内核访问模式, 未映射时忽略
+func (m *MmapReader) Advise(advice MmapAdvice) error {
+	if m.data == nil || m.refs.Load() <= 0 {
+		return nil
+	}
+	return madvise(m.data, advice)
+}
+
+// Acquire 增加一个引用, 已全部释放时返回 false
+func (m *MmapReader) Acquire() bool {
+	for {
+		n := m.refs.Load()
+		if n <= 0 {
+			return false
+		}
+		if m.refs.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// Close 释放一个引用, 最后一个引用释放时解除映射或关闭文件
+func (m *MmapReader) Close() error {
+	n := m.refs.Add(-1)
+	switch {
+	case n > 0:
+		return nil
+	case n < 0:
+		return ErrMmapClosed
+	}
+	if m.data != nil {
+		return munmap(m.data)
+	}
+	return m.f.Close()
+}
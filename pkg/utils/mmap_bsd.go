@@ -0,0 +1,6 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package utils
+
+// madvise syscall 包在这些平台上未提供 Madvise, 提示被忽略
+func madvise([]byte, MmapAdvice) error { return nil }
@@ -0,0 +1,20 @@
+package utils
+
+import "syscall"
+
+func madvise(b []byte, advice MmapAdvice) error {
+	var a int
+	switch advice {
+	case MmapSequential:
+		a = syscall.MADV_SEQUENTIAL
+	case MmapRandom:
+		a = syscall.MADV_RANDOM
+	case MmapWillNeed:
+		a = syscall.MADV_WILLNEED
+	case MmapDontNeed:
+		a = syscall.MADV_DONTNEED
+	default:
+		a = syscall.MADV_NORMAL
+	}
+	return syscall.Madvise(b, a)
+}
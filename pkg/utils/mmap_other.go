@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package utils
+
+import (
+	"errors"
+	"os"
+)
+
+func mmapFile(*os.File, int) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func munmap([]byte) error { return nil }
+
+func madvise([]byte, MmapAdvice) error { return nil }
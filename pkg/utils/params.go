@@ -0,0 +1,151 @@
+package utils
+
+/*
+	头部参数列表扫描: 逐个读出 `key=value; key2="quoted \"value\""` 中的参数, 正确处理引号和转义,
+	除含转义的引号值外不分配内存
+*/
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrMalformedParams 参数列表格式错误, 如引号未闭合或值后出现多余内容
+var ErrMalformedParams = errors.New("utils: malformed parameter list")
+
+// ParamScanner 参数列表扫描器, 零值不可用, 通过 NewParamScanner 或 Reset 初始化:
+//
+//	sc := utils.NewParamScanner(s, ';')
+//	for sc.Next() {
+//		use(sc.Key(), sc.Value())
+//	}
+//	if err := sc.Err(); err != nil { ... }
+type ParamScanner struct {
+	s      string
+	sep    byte
+	pos    int
+	key    string
+	raw    string
+	quoted bool
+	err    error
+}
+
+// NewParamScanner 创建以 sep (通常为 ';') 分隔参数的扫描器
+func NewParamScanner(s string, sep byte) *ParamScanner {
+	sc := new(ParamScanner)
+	sc.Reset(s, sep)
+	return sc
+}
+
+// Reset 重新扫描 s, 可复用同一扫描器避免分配
+func (sc *ParamScanner) Reset(s string, sep byte) {
+	*sc = ParamScanner{s: s, sep: sep}
+}
+
+// Next 读取下一个参数, 结束或出错时返回 false. 空参数 (连续分隔符) 被跳过
+func (sc *ParamScanner) Next() bool {
+	if sc.err != nil {
+		return false
+	}
+	s, i := sc.s, sc.pos
+	for i < len(s) && (s[i] == sc.sep || isOWS(s[i])) {
+		i++
+	}
+	if i == len(s) {
+		sc.pos = i
+		return false
+	}
+	start := i
+	for i < len(s) && s[i] != '=' && s[i] != sc.sep && !isOWS(s[i]) {
+		i++
+	}
+	sc.key, sc.raw, sc.quoted = s[start:i], "", false
+	i = skipOWS(s, i)
+	if i < len(s) && s[i] == '=' {
+		i = skipOWS(s, i+1)
+		if i < len(s) && s[i] == '"' {
+			end, ok := scanQuoted(s, i)
+			if !ok {
+				return sc.fail()
+			}
+			sc.raw, sc.quoted, i = s[i+1:end], true, end+1
+		} else {
+			start = i
+			for i < len(s) && s[i] != sc.sep && !isOWS(s[i]) {
+				i++
+			}
+			sc.raw = s[start:i]
+		}
+		i = skipOWS(s, i)
+	}
+	if sc.key == "" || i < len(s) && s[i] != sc.sep {
+		return sc.fail()
+	}
+	sc.pos = i
+	return true
+}
+
+func (sc *ParamScanner) fail() bool {
+	sc.err = ErrMalformedParams
+	sc.key, sc.raw = "", ""
+	return false
+}
+
+// scanQuoted 返回从 s[i] 的 '"' 开始的引号串的闭合引号位置
+func scanQuoted(s string, i int) (int, bool) {
+	for i++; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func isOWS(c byte) bool { return c == ' ' || c == '\t' }
+
+func skipOWS(s string, i int) int {
+	for i < len(s) && isOWS(s[i]) {
+		i++
+	}
+	return i
+}
+
+// Key 返回当前参数名, 保持原样大小写
+func (sc *ParamScanner) Key() string { return sc.key }
+
+// Value 返回当前参数值, 引号值已去除引号和转义; 没有 '=' 时为空串
+func (sc *ParamScanner) Value() string {
+	if !sc.quoted {
+		return sc.raw
+	}
+	return UnquoteParam(sc.raw)
+}
+
+// RawValue 返回当前参数值的原文, 引号值不含两侧引号但保留转义
+func (sc *ParamScanner) RawValue() string { return sc.raw }
+
+// Quoted 报告当前参数值是否为引号串
+func (sc *ParamScanner) Quoted() bool { return sc.quoted }
+
+// Err 返回扫描中遇到的错误
+func (sc *ParamScanner) Err() error { return sc.err }
+
+// UnquoteParam 处理引号串内容 (不含两侧引号) 中的 quoted-pair, 没有转义时不分配
+func UnquoteParam(s string) string {
+	i := strings.IndexByte(s, '\\')
+	if i < 0 {
+		return s
+	}
+	b := make([]byte, 0, len(s))
+	b = append(b, s[:i]...)
+	for ; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
@@ -0,0 +1,145 @@
+package utils
+
+/*
+	令牌桶限流器, 可用于请求数限流和字节带宽限流
+*/
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimiter 令牌桶限流器, 并发安全; rate <= 0 表示不限制
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+	clock  Clock
+}
+
+// NewRateLimiter 创建每秒补充 rate 个令牌、容量为 burst 的限流器, burst <= 0 时取 max(rate, 1)
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst <= 0 {
+		burst = int(math.Max(math.Ceil(rate), 1))
+	}
+	return &RateLimiter{rate: rate, burst: burst, tokens: float64(burst)}
+}
+
+// SetClock 替换时间来源, 须在使用前调用; nil 表示系统时钟
+func (l *RateLimiter) SetClock(c Clock) { l.clock = c }
+
+func (l *RateLimiter) now() time.Time { return ClockOr(l.clock).Now() }
+
+// Rate 返回每秒补充的令牌数
+func (l *RateLimiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Burst 返回桶容量
+func (l *RateLimiter) Burst() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.burst
+}
+
+// Allow 非阻塞地取 1 个令牌
+func (l *RateLimiter) Allow() bool { return l.AllowN(l.now(), 1) }
+
+// AllowN 非阻塞地在 now 时刻取 n 个令牌, 令牌不足或 n 超过桶容量时返回 false 且不扣减
+func (l *RateLimiter) AllowN(now time.Time, n int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return true
+	}
+	l.advance(now)
+	if n > l.burst || l.tokens < float64(n) {
+		return false
+	}
+	l.tokens -= float64(n)
+	return true
+}
+
+// Wait 阻塞直到取得 n 个令牌或 ctx 结束. n 超过桶容量时按容量分批获取,
+// 因此可直接用于字节数限流; ctx 结束时归还尚未使用的令牌并返回 ctx.Err()
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	for n > 0 {
+		take, wait, ok := l.reserve(n, l.now())
+		if !ok {
+			return nil
+		}
+		if wait > 0 {
+			if !sleepClock(ctx.Done(), ClockOr(l.clock), wait) {
+				l.cancel(take)
+				return ctx.Err()
+			}
+		} else if err := ctx.Err(); err != nil {
+			l.cancel(take)
+			return err
+		}
+		n -= take
+	}
+	return nil
+}
+
+// Tokens 返回当前可用的令牌数, 有等待者预扣时可能为负
+func (l *RateLimiter) Tokens() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.advance(l.now())
+	return l.tokens
+}
+
+// SetRate 修改每秒补充的令牌数, 已累积的令牌按旧速率结算; rate <= 0 表示不限制
+func (l *RateLimiter) SetRate(rate float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.advance(l.now())
+	l.rate = rate
+}
+
+// SetBurst 修改桶容量, 与速率相互独立; 当前令牌超出新容量的部分被丢弃
+func (l *RateLimiter) SetBurst(burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.advance(l.now())
+	l.burst = max(burst, 1)
+	l.tokens = math.Min(l.tokens, float64(l.burst))
+}
+
+// reserve 预扣至多一个桶容量的令牌, 返回实际预扣数量和需要等待的时间; 不限流时 ok 为 false
+func (l *RateLimiter) reserve(n int, now time.Time) (take int, wait time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0, 0, false
+	}
+	l.advance(now)
+	take = min(n, l.burst)
+	l.tokens -= float64(take)
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	return take, wait, true
+}
+
+func (l *RateLimiter) cancel(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = math.Min(l.tokens+float64(n), float64(l.burst))
+}
+
+func (l *RateLimiter) advance(now time.Time) {
+	if !l.last.IsZero() && now.After(l.last) {
+		l.tokens = math.Min(l.tokens+now.Sub(l.last).Seconds()*l.rate, float64(l.burst))
+	}
+	if now.After(l.last) {
+		l.last = now
+	}
+}
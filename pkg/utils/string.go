@@ -1 +1,40 @@
 package utils
+
+/*
+	字符串工具: ASCII 大小写处理等
+*/
+
+// EqualFoldASCII 按 ASCII 规则忽略大小写比较, 比 strings.EqualFold 快且不做 Unicode 折叠,
+// 适用于头部名称、参数名等协议字段
+func EqualFoldASCII(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if lowerASCII(a[i]) != lowerASCII(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ToLowerASCII 将 ASCII 大写字母转为小写, 已是小写时不分配
+func ToLowerASCII(s string) string {
+	for i := 0; i < len(s); i++ {
+		if 'A' <= s[i] && s[i] <= 'Z' {
+			b := []byte(s)
+			for j := i; j < len(b); j++ {
+				b[j] = lowerASCII(b[j])
+			}
+			return string(b)
+		}
+	}
+	return s
+}
+
+func lowerASCII(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
@@ -0,0 +1,106 @@
+package utils
+
+/*
+	异步 TeeReader: 旁路副本经有界队列由独立 goroutine 写出, 慢速的旁路 (如落盘抓包) 不会拖慢主读取路径
+*/
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// TeePolicy 旁路队列满时的处理策略
+type TeePolicy int
+
+const (
+	// TeeDrop 队列满时丢弃本次数据, 主路径永不等待, 旁路得到的副本可能不完整, 丢弃量见 Dropped
+	TeeDrop TeePolicy = iota
+	// TeeBlock 队列满时等待旁路写出, 副本完整, 但旁路持续过慢时主路径随之变慢
+	TeeBlock
+)
+
+// DefaultTeeQueue 默认队列长度 (以 Read 次数计)
+const DefaultTeeQueue = 64
+
+// AsyncTeeReader 读取 r 的同时把数据副本异步写入 w.
+// 旁路写出出错后不再写入, 剩余数据被丢弃, 不影响主路径; 错误由 Close 返回
+type AsyncTeeReader struct {
+	r       io.Reader
+	policy  TeePolicy
+	queue   chan []byte
+	done    chan struct{}
+	mu      sync.RWMutex
+	closed  bool
+	dropped atomic.Int64
+	err     error
+}
+
+// NewAsyncTeeReader 创建异步 tee, queue 为队列长度, <= 0 时使用 DefaultTeeQueue; 使用完毕须调用 Close
+func NewAsyncTeeReader(r io.Reader, w io.Writer, queue int, policy TeePolicy) *AsyncTeeReader {
+	if queue <= 0 {
+		queue = DefaultTeeQueue
+	}
+	t := &AsyncTeeReader{r: r, policy: policy, queue: make(chan []byte, queue), done: make(chan struct{})}
+	go t.sink(w)
+	return t
+}
+
+func (t *AsyncTeeReader) sink(w io.Writer) {
+	defer close(t.done)
+	for b := range t.queue {
+		if t.err == nil {
+			if _, err := w.Write(b); err != nil {
+				t.err = err
+			}
+		} else {
+			t.dropped.Add(int64(len(b)))
+		}
+		PutBytes(b)
+	}
+}
+
+func (t *AsyncTeeReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.enqueue(p[:n])
+	}
+	return n, err
+}
+
+func (t *AsyncTeeReader) enqueue(p []byte) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		t.dropped.Add(int64(len(p)))
+		return
+	}
+	b := GetBytes(len(p))
+	copy(b, p)
+	if t.policy == TeeBlock {
+		t.queue <- b
+		return
+	}
+	select {
+	case t.queue <- b:
+	default:
+		t.dropped.Add(int64(len(b)))
+		PutBytes(b)
+	}
+}
+
+// Dropped 返回未写入旁路的字节数
+func (t *AsyncTeeReader) Dropped() int64 { return t.dropped.Load() }
+
+// Close 等待队列中的数据写出后停止旁路, 返回旁路的写出错误; 不关闭 r 和 w.
+// 之后读取的数据不再进入旁路
+func (t *AsyncTeeReader) Close() error {
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+	<-t.done
+	return t.err
+}
@@ -1 +1,110 @@
 package utils
+
+/*
+	时间工具: HTTP 日期的缓存生成与解析
+*/
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// TimeFormat HTTP 日期格式 (RFC 9110 IMF-fixdate), 时间须为 UTC
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// cachedDate 某一秒的格式化结果
+type cachedDate struct {
+	unix int64
+	text []byte
+	str  string
+}
+
+var dateCache atomic.Pointer[cachedDate]
+
+// loadDate 返回 now 所在秒的格式化日期; 跨秒时由首个发现的调用方重新格式化,
+// 并发刷新至多多格式化几次, 读取始终无锁
+func loadDate(now time.Time) *cachedDate {
+	sec := now.Unix()
+	if d := dateCache.Load(); d != nil && d.unix == sec {
+		return d
+	}
+	text := now.UTC().AppendFormat(make([]byte, 0, len(TimeFormat)), TimeFormat)
+	d := &cachedDate{unix: sec, text: text, str: string(text)}
+	dateCache.Store(d)
+	return d
+}
+
+// HTTPDate 返回当前时间的 HTTP 日期, 每秒只格式化一次, 供服务器每个响应的 Date 头使用
+func HTTPDate() string { return loadDate(time.Now()).str }
+
+// AppendHTTPDate 将当前时间的 HTTP 日期追加到 dst, 不产生额外分配
+func AppendHTTPDate(dst []byte) []byte { return append(dst, loadDate(time.Now()).text...) }
+
+// ErrBadHTTPTime 无法识别的 HTTP 日期
+var ErrBadHTTPTime = errors.New("utils: malformed HTTP date")
+
+// 接收方须兼容的过时日期格式, 以及 Set-Cookie Expires 常见的 Netscape 格式
+const (
+	timeFormatRFC850 = "Monday, 02-Jan-06 15:04:05 GMT"
+	timeFormatANSIC  = "Mon Jan _2 15:04:05 2006"
+	timeFormatCookie = "Mon, 02-Jan-2006 15:04:05 GMT"
+)
+
+// ParseHTTPTime 解析 HTTP 日期. IMF-fixdate 走手写的定长解析, 过时的 RFC 850、asctime 与 Cookie 格式回退到 time.Parse
+func ParseHTTPTime(s string) (time.Time, error) {
+	if t, ok := parseIMFFixdate(s); ok {
+		return t, nil
+	}
+	for _, layout := range []string{TimeFormat, timeFormatRFC850, timeFormatANSIC, timeFormatCookie} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, ErrBadHTTPTime
+}
+
+var monthIndex = map[string]time.Month{
+	"Jan": time.January, "Feb": time.February, "Mar": time.March, "Apr": time.April,
+	"May": time.May, "Jun": time.June, "Jul": time.July, "Aug": time.August,
+	"Sep": time.September, "Oct": time.October, "Nov": time.November, "Dec": time.December,
+}
+
+// parseIMFFixdate 解析 "Mon, 02 Jan 2006 15:04:05 GMT", 不校验星期
+func parseIMFFixdate(s string) (time.Time, bool) {
+	if len(s) != len(TimeFormat) || s[3] != ',' || s[4] != ' ' || s[7] != ' ' || s[11] != ' ' ||
+		s[16] != ' ' || s[19] != ':' || s[22] != ':' || s[25:] != " GMT" {
+		return time.Time{}, false
+	}
+	month, ok := monthIndex[s[8:11]]
+	if !ok {
+		return time.Time{}, false
+	}
+	day, ok1 := atoiFixed(s[5:7])
+	year, ok2 := atoiFixed(s[12:16])
+	hour, ok3 := atoiFixed(s[17:19])
+	minute, ok4 := atoiFixed(s[20:22])
+	sec, ok5 := atoiFixed(s[23:25])
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || day < 1 || hour > 23 || minute > 59 || sec > 60 {
+		return time.Time{}, false
+	}
+	t := time.Date(year, month, day, hour, minute, sec, 0, time.UTC)
+	if t.Day() != day {
+		// 如 31 Feb, 交给 time.Parse 报错
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// atoiFixed 解析定长十进制数字
+func atoiFixed(s string) (int, bool) {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, true
+}
@@ -0,0 +1,166 @@
+package utils
+
+/*
+	百分号编码: 按 RFC 3986 对路径段、路径、查询组件、片段和用户信息分别使用对应的保留字符集
+*/
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalidEscape 非法的百分号转义, 如 "%zz" 或末尾不完整的 "%4"
+var ErrInvalidEscape = errors.New("utils: invalid percent-encoding")
+
+type encodeMode int
+
+const (
+	encodePathSegment encodeMode = iota
+	encodePath
+	encodeQueryComponent
+	encodeFragment
+	encodeUserinfo
+	encodeForm
+	numEncodeModes
+)
+
+// shouldKeep[mode][c] 为 true 的字符保持原样
+var shouldKeep [numEncodeModes][256]bool
+
+func init() {
+	keep := func(mode encodeMode, chars string) {
+		for i := 0; i < len(chars); i++ {
+			shouldKeep[mode][chars[i]] = true
+		}
+	}
+	for mode := encodeMode(0); mode < numEncodeModes; mode++ {
+		for c := 'a'; c <= 'z'; c++ {
+			shouldKeep[mode][c] = true
+			shouldKeep[mode][c-'a'+'A'] = true
+		}
+		keep(mode, "0123456789-._~")
+	}
+	// pchar = unreserved / sub-delims / ":" / "@"
+	const pchar = "!$&'()*+,;=:@"
+	keep(encodePathSegment, pchar)
+	keep(encodePath, pchar+"/")
+	// 作为 key 或 value 时需转义分隔符 & = + ;
+	keep(encodeQueryComponent, "!$'()*,:@/?")
+	keep(encodeFragment, pchar+"/?")
+	// 单独的用户名或密码, 需转义 ":" 和 "@"
+	keep(encodeUserinfo, "!$&'()*+,;=")
+}
+
+const upperHex = "0123456789ABCDEF"
+
+func escape(s string, mode encodeMode) string {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if !shouldKeep[mode][s[i]] && !(mode == encodeForm && s[i] == ' ') {
+			n++
+		}
+	}
+	if n == 0 {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s) + 2*n)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case shouldKeep[mode][c]:
+			b.WriteByte(c)
+		case mode == encodeForm && c == ' ':
+			b.WriteByte('+')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(upperHex[c>>4])
+			b.WriteByte(upperHex[c&15])
+		}
+	}
+	return b.String()
+}
+
+// EscapePathSegment 转义单个路径段, "/" 会被转义
+func EscapePathSegment(s string) string { return escape(s, encodePathSegment) }
+
+// EscapePath 转义整个路径, 保留 "/"
+func EscapePath(s string) string { return escape(s, encodePath) }
+
+// EscapeQueryComponent 转义查询参数的键或值, 空格编码为 %20
+func EscapeQueryComponent(s string) string { return escape(s, encodeQueryComponent) }
+
+// EscapeFragment 转义片段
+func EscapeFragment(s string) string { return escape(s, encodeFragment) }
+
+// EscapeUserinfo 转义用户名或密码, ":" 和 "@" 会被转义
+func EscapeUserinfo(s string) string { return escape(s, encodeUserinfo) }
+
+// URLEncode application/x-www-form-urlencoded 编码, 空格编码为 "+"
+func URLEncode(s string) string { return escape(s, encodeForm) }
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+func unescape(s string, plusSpace bool) (string, error) {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", ErrInvalidEscape
+		}
+		if _, ok := unhex(s[i+1]); !ok {
+			return "", ErrInvalidEscape
+		}
+		if _, ok := unhex(s[i+2]); !ok {
+			return "", ErrInvalidEscape
+		}
+		n++
+		i += 2
+	}
+	if n == 0 && (!plusSpace || !strings.Contains(s, "+")) {
+		return s, nil
+	}
+	b := make([]byte, 0, len(s)-2*n)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '%':
+			hi, _ := unhex(s[i+1])
+			lo, _ := unhex(s[i+2])
+			b = append(b, hi<<4|lo)
+			i += 2
+		case c == '+' && plusSpace:
+			b = append(b, ' ')
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(b), nil
+}
+
+// UnescapePath 解码路径或路径段, "+" 保持原样.
+// 注意 "%2F" 会被解码为 "/", 需要区分时应先按 "/" 切分再逐段解码
+func UnescapePath(s string) (string, error) { return unescape(s, false) }
+
+// UnescapeQueryComponent 解码查询参数的键或值, "+" 视为空格
+func UnescapeQueryComponent(s string) (string, error) { return unescape(s, true) }
+
+// UnescapeFragment 解码片段, "+" 保持原样
+func UnescapeFragment(s string) (string, error) { return unescape(s, false) }
+
+// UnescapeUserinfo 解码用户名或密码, "+" 保持原样
+func UnescapeUserinfo(s string) (string, error) { return unescape(s, false) }
+
+// URLDecode application/x-www-form-urlencoded 解码, "+" 视为空格
+func URLDecode(s string) (string, error) { return unescape(s, true) }